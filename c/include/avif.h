@@ -11,6 +11,17 @@
 extern "C" {
 #endif
 
+// bit_depth の自動指定: 入力画像のビット深度に従う
+// 8-bit入力は8-bit、16-bit PNG/TIFFは12-bit（sBITで10-bit以下と分かる場合は10-bit）
+#define NEXTIMAGE_AVIF_BIT_DEPTH_AUTO 0
+
+// ゲインマップ（ISO 21496-1）エンコードモード
+typedef enum {
+    NEXTIMAGE_GAIN_MAP_NONE = 0,      // ゲインマップなし（デフォルト）
+    NEXTIMAGE_GAIN_MAP_FROM_PAIR = 1, // 入力=SDRベース、gain_map_alternate=HDR
+    NEXTIMAGE_GAIN_MAP_FROM_HDR = 2   // 入力=HDR、SDRベースはトーンマッピングで生成
+} NextImageGainMapMode;
+
 // AVIF エンコードオプション
 typedef struct {
     // Quality settings
@@ -25,7 +36,7 @@ typedef struct {
     int max_quantizer_alpha;// 0-63, default -1 (use quality_alpha instead)
 
     // Format settings
-    int bit_depth;          // 8, 10, or 12 (default: 8)
+    int bit_depth;          // 8, 10, 12, or 0=auto (follow input: 16-bit PNG/TIFF -> 10/12) (default: 8)
     int yuv_format;         // 0=444, 1=422, 2=420, 3=400 (default: 444)
     int yuv_range;          // 0=limited, 1=full (default: 1=full for PNG/JPEG)
 
@@ -45,6 +56,7 @@ typedef struct {
     // Advanced settings
     int sharp_yuv;          // 0 or 1, use sharp RGB->YUV conversion (default: 0)
     int target_size;        // target file size in bytes, 0=disabled (default: 0)
+    int jobs;               // encoder threads, -1 or 0=all cores, >0=thread count (default: -1)
 
     // Metadata settings
     const uint8_t* exif_data;   // EXIF metadata bytes (NULL=no EXIF)
@@ -76,6 +88,31 @@ typedef struct {
     // Animation settings (for future use)
     int timescale;          // timescale/fps for animations (default: 30)
     int keyframe_interval;  // max keyframe interval (default: 0=disabled)
+
+    // Gain map (ISO 21496-1)
+    int gain_map_mode;                      // 0=disabled (default), 1=input is the SDR base and gain_map_alternate is the HDR rendition, 2=input is HDR (SDR base is tone mapped from it)
+    const uint8_t* gain_map_alternate_data; // HDR rendition image file (AVIF, or 16-bit PNG/TIFF) for gain_map_mode=1
+    size_t gain_map_alternate_size;         // HDR rendition data size in bytes
+    int gain_map_quality;                   // 0-100, gain map quality (default: 60)
+    int gain_map_downscale;                 // gain map downscaling as log2: 0=full size (default), 1=half, 2=quarter...
+    int gain_map_bit_depth;                 // 8, 10, or 12 (default: 8)
+    int gain_map_tone_mapping;              // tone mapping operator for the SDR base in gain_map_mode=2 (default: 1=BT.2390 EETF)
+    int hdr_color_primaries;                // CICP primaries of non-AVIF HDR inputs, -1=auto (9=BT.2020)
+    int hdr_transfer_characteristics;       // CICP transfer of non-AVIF HDR inputs, -1=auto (16=PQ)
+
+    // Grid encoding (avifenc --grid MxN) for images beyond the AV1 frame size limits
+    int grid_cols;          // grid columns M (1-256), 0=disabled (default); width must divide evenly
+    int grid_rows;          // grid rows N (1-256), 0=disabled (default); height must divide evenly
+
+    // Progressive (layered) encoding (avifenc --progressive / --layered)
+    int progressive_layers;                                       // number of layers 2-4, 0=disabled (default)
+    int progressive_layer_quality[NEXTIMAGE_AVIF_MAX_LAYERS];     // per-layer quality 0-100 from coarsest to final, -1=auto (default)
+
+    // Codec-specific options (avifenc -a key=value), forwarded to avifEncoderSetCodecSpecificOption.
+    // Keys may be prefixed with "color:"/"c:" or "alpha:"/"a:" to apply to one plane type only
+    const char** codec_option_keys;     // option names, e.g. "tune", "alpha:sharpness" (default: NULL)
+    const char** codec_option_values;   // option values, e.g. "ssim", "2" (default: NULL)
+    int codec_option_count;             // number of options (default: 0)
 } NextImageAVIFEncodeOptions;
 
 // Output format enum for decoder (must match avifdec.h)
@@ -84,6 +121,21 @@ typedef enum {
     NEXTIMAGE_AVIF_OUTPUT_JPEG = 1   // JPEG output
 } NextImageAVIFOutputFormat;
 
+// HDR -> SDR トーンマッピング演算子
+typedef enum {
+    NEXTIMAGE_TONE_MAPPING_NONE = 0,     // トーンマッピングなし（デフォルト）
+    NEXTIMAGE_TONE_MAPPING_BT2390 = 1,   // ITU-R BT.2390 EETF（PQ領域のエルミートスプライン）
+    NEXTIMAGE_TONE_MAPPING_REINHARD = 2, // 拡張Reinhard（ソースピークを白に合わせる）
+    NEXTIMAGE_TONE_MAPPING_HABLE = 3     // Hable (Uncharted 2) フィルミックカーブ
+} NextImageToneMapping;
+
+// ゲインマップ付きAVIFのデコード出力
+typedef enum {
+    NEXTIMAGE_GAIN_MAP_OUTPUT_BASE = 0,      // ベース画像（デフォルト）
+    NEXTIMAGE_GAIN_MAP_OUTPUT_IMAGE = 1,     // ゲインマップ画像そのもの
+    NEXTIMAGE_GAIN_MAP_OUTPUT_RENDITION = 2  // gain_map_headroomに合わせて適用した画像
+} NextImageGainMapOutput;
+
 // AVIF デコードオプション
 typedef struct {
     // Output format options
@@ -116,6 +168,15 @@ typedef struct {
     int resize_width;           // resize width
     int resize_height;          // resize height
     int use_resize;             // 0 or 1, enable resizing
+
+    // HDR -> SDR tone mapping (applied only to PQ/HLG images; SDR images are unaffected)
+    int tone_mapping;             // NextImageToneMapping: 0=disabled (default), 1=BT.2390 EETF, 2=Reinhard, 3=Hable
+    int tone_mapping_source_peak; // source peak luminance in nits, 0=auto (CLLI maxCLL, else mdcv max luminance, else 1000)
+    int tone_mapping_target_nits; // SDR white luminance in nits that maps to 1.0 (default: 203)
+
+    // Gain map (ISO 21496-1)
+    int gain_map_output;        // 0=base image (default), 1=gain map image, 2=rendition for gain_map_headroom
+    float gain_map_headroom;    // target HDR headroom (log2, 0=SDR) for gain_map_output=2; >0 outputs 16-bit PQ (default: 0)
 } NextImageAVIFDecodeOptions;
 
 // デフォルトオプションの取得
@@ -139,6 +200,57 @@ NextImageStatus nextimage_avif_encode_alloc(
     NextImageBuffer* output
 );
 
+// エンコード統計情報
+typedef struct NextImageAVIFEncodeStats {
+    // estimated_で始まる値はlibavifが公開していないため、設定からlibavifの計算式で求めた推定値
+    // （libavifの更新で実際の値とずれる可能性がある）
+    int estimated_min_quantizer;        // カラーの最小量子化値（0-63、プログレッシブは最終レイヤー）
+    int estimated_max_quantizer;        // カラーの最大量子化値（0-63）
+    int estimated_min_quantizer_alpha;  // アルファの最小量子化値（0-63、アルファなしの場合は-1）
+    int estimated_max_quantizer_alpha;  // アルファの最大量子化値（0-63、アルファなしの場合は-1）
+    int estimated_tile_rows_log2;       // タイル行数のlog2（自動タイリングでなければ設定値）
+    int estimated_tile_cols_log2;       // タイル列数のlog2
+    int grid_cols;                      // グリッド列数（グリッドなしは1）
+    int grid_rows;                      // グリッド行数（グリッドなしは1）
+    int layer_count;                    // レイヤー数（プログレッシブでなければ1）
+    size_t color_obu_size;              // カラーOBUの合計サイズ（バイト）
+    size_t alpha_obu_size;              // アルファOBUの合計サイズ（バイト、アルファなしは0）
+} NextImageAVIFEncodeStats;
+
+// エンコード（統計情報付き）
+// stats: 成功時に統計情報が格納される（NULL可）
+NextImageStatus nextimage_avif_encode_alloc_with_stats(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output,
+    NextImageAVIFEncodeStats* stats
+);
+
+// エンコード（ライターへ逐次出力）
+// 出力バッファを確保せず、エンコード結果をwriterへチャンクごとに渡す
+// writerが0を返した場合は中断してエラーを返す（それまでのチャンクは出力済み）
+// stats: 成功時に統計情報が格納される（NULL可）
+NextImageStatus nextimage_avif_encode_to_writer(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    const NextImageWriter* writer,
+    NextImageAVIFEncodeStats* stats
+);
+
+// デコード済みRGBA 8-bitピクセルからエンコード
+// 1回のデコード結果から複数のエンコードを行う場合に使う（ゲインマップは非対応）
+// rgba: RGBA画素データ、stride: 1行のバイト数（width * 4以上）
+NextImageStatus nextimage_avif_encode_rgba_alloc(
+    const uint8_t* rgba,
+    int width,
+    int height,
+    int stride,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output
+);
+
 // ========================================
 // AVIF デコード
 // ========================================
@@ -177,6 +289,41 @@ NextImageStatus nextimage_avif_decode_size(
     size_t* required_size
 );
 
+// ========================================
+// ゲインマップ（ISO 21496-1）
+// ========================================
+
+// ゲインマップのメタデータ（分数は浮動小数点に変換済み）
+typedef struct {
+    int present;                    // 1=ゲインマップあり, 0=なし（以下は未設定）
+    int width;                      // ゲインマップ画像の幅
+    int height;                     // ゲインマップ画像の高さ
+    int bit_depth;                  // ゲインマップ画像のビット深度
+    double gain_map_min[3];         // log2スケールの最小ゲイン（R, G, B）
+    double gain_map_max[3];         // log2スケールの最大ゲイン（R, G, B）
+    double gain_map_gamma[3];       // ゲインマップのガンマ（R, G, B）
+    double base_offset[3];          // ベース画像のオフセット（R, G, B）
+    double alternate_offset[3];     // 代替画像のオフセット（R, G, B）
+    double base_hdr_headroom;       // ベース画像のHDRヘッドルーム（log2）
+    double alternate_hdr_headroom;  // 代替画像のHDRヘッドルーム（log2）
+    int use_base_color_space;       // 1=ベース画像の色空間でゲインを適用
+    int alt_color_primaries;        // 代替画像のCICP色域
+    int alt_transfer_characteristics; // 代替画像のCICP伝達関数
+    int alt_matrix_coefficients;    // 代替画像のCICP行列係数
+    int alt_bit_depth;              // 代替画像のビット深度
+    int alt_plane_count;            // 代替画像のプレーン数（1=グレー, 3=カラー）
+    int alt_clli_max_cll;           // 代替画像のCLLI maxCLL
+    int alt_clli_max_pall;          // 代替画像のCLLI maxPALL
+} NextImageAVIFGainMapInfo;
+
+// ゲインマップのメタデータを取得（画素データはデコードしない）
+// ゲインマップがない場合もNEXTIMAGE_OKを返し、info->present=0となる
+NextImageStatus nextimage_avif_gain_map_info(
+    const uint8_t* avif_data,
+    size_t avif_size,
+    NextImageAVIFGainMapInfo* info
+);
+
 // ========================================
 // インスタンスベースのエンコーダー/デコーダー
 // ========================================
@@ -201,6 +348,22 @@ NextImageStatus nextimage_avif_encoder_encode(
     size_t input_size,
     NextImageBuffer* output);
 
+// エンコーダーでエンコード（統計情報付き）
+NextImageStatus nextimage_avif_encoder_encode_with_stats(
+    NextImageAVIFEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    NextImageAVIFEncodeStats* stats);
+
+// エンコーダーでエンコード（ライターへ逐次出力、statsはNULL可）
+NextImageStatus nextimage_avif_encoder_encode_to_writer(
+    NextImageAVIFEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    NextImageAVIFEncodeStats* stats);
+
 // エンコーダーの破棄（内部メモリの解放）
 void nextimage_avif_encoder_destroy(NextImageAVIFEncoder* encoder);
 
@@ -224,6 +387,50 @@ NextImageStatus nextimage_avif_decoder_decode(
     size_t avif_size,
     NextImageDecodeBuffer* output);
 
+// プログレッシブ（レイヤー）AVIFのレイヤーを順にデコード
+// avif_dataはファイルの先頭部分（受信済みのデータ）でもよい。complete=0の場合、
+// データが揃っていないレイヤーはデコードせずに終了する（エラーにはならない）
+// decoder: デコーダーインスタンス
+// avif_data: AVIFファイルデータ（先頭部分）
+// avif_size: データサイズ
+// complete: 1=avif_dataはファイル全体、0=続きのデータが未到着
+// layers: 出力バッファの配列（NEXTIMAGE_AVIF_MAX_LAYERS要素、各要素はnextimage_free_decode_bufferで解放）
+// layer_count: 総レイヤー数（プログレッシブでない画像は1、ヘッダ未到着の場合は0）
+// decoded_count: layersに出力したバッファの数
+NextImageStatus nextimage_avif_decoder_decode_layers(
+    NextImageAVIFDecoder* decoder,
+    const uint8_t* avif_data,
+    size_t avif_size,
+    int complete,
+    NextImageDecodeBuffer* layers,
+    int* layer_count,
+    int* decoded_count);
+
+// レイヤーの逐次デコード（受信したデータを順に渡す）
+// 同じavifDecoderでデコードを続けるため、データの読み込みとレイヤーのデコードは1回ずつで済む
+typedef struct NextImageAVIFLayerStream NextImageAVIFLayerStream;
+
+// 逐次デコードの開始（decoderのオプションをコピーする、失敗時はNULL）
+NextImageAVIFLayerStream* nextimage_avif_layer_stream_create(NextImageAVIFDecoder* decoder);
+
+// 受信したデータを追記し、新たにデコードできたレイヤーを出力する
+// data/size: 前回の呼び出し以降に受信したデータ
+// complete: 1=これでファイル全体（以降は呼び出さない）
+// layers: 出力バッファの配列（NEXTIMAGE_AVIF_MAX_LAYERS要素、各要素はnextimage_free_decode_bufferで解放）
+// layer_count: 総レイヤー数（ヘッダ未到着の場合は0）
+// decoded_count: 今回layersに出力したバッファの数（前回までに出力したレイヤーの続き）
+NextImageStatus nextimage_avif_layer_stream_push(
+    NextImageAVIFLayerStream* stream,
+    const uint8_t* data,
+    size_t size,
+    int complete,
+    NextImageDecodeBuffer* layers,
+    int* layer_count,
+    int* decoded_count);
+
+// 逐次デコードの終了
+void nextimage_avif_layer_stream_destroy(NextImageAVIFLayerStream* stream);
+
 // デコーダーの破棄（内部メモリの解放）
 void nextimage_avif_decoder_destroy(NextImageAVIFDecoder* decoder);
 
@@ -22,6 +22,7 @@ typedef enum {
     NEXTIMAGE_ERROR_OUT_OF_MEMORY = -4,
     NEXTIMAGE_ERROR_UNSUPPORTED = -5,
     NEXTIMAGE_ERROR_BUFFER_TOO_SMALL = -6,
+    NEXTIMAGE_ERROR_CROP_OUTSIDE_IMAGE = -7,  // クロップ矩形がデコード後の画像からはみ出している
 } NextImageStatus;
 
 // ピクセルフォーマット定義
@@ -41,6 +42,16 @@ typedef struct {
     size_t size;
 } NextImageBuffer;
 
+// 出力ライター（エンコード結果をバッファに溜めずに逐次渡す）
+// write: data/sizeのチャンクを受け取り、成功時に0以外を返す（0を返すと中断）
+// user_data: writeにそのまま渡される
+typedef int (*NextImageWriteFunc)(const uint8_t* data, size_t size, void* user_data);
+
+typedef struct {
+    NextImageWriteFunc write;
+    void* user_data;
+} NextImageWriter;
+
 // デコード用バッファ情報（プレーン別の詳細情報を含む）
 typedef struct {
     // プライマリプレーン（インターリーブ形式の場合は全データ、planarの場合はYプレーン）
@@ -82,14 +93,28 @@ const char* nextimage_last_error_message(void);
 
 // エラーメッセージのクリア
 // - 次のエラーまでnextimage_last_error_message()がNULLを返すようにする
+// - nextimage_last_error_image_size()も0に戻す
 void nextimage_clear_error(void);
 
-// デバッグビルド専用: メモリリークカウンター
+// 最後のエラーの対象となった画像サイズ取得
+// - NEXTIMAGE_ERROR_CROP_OUTSIDE_IMAGE では、クロップ矩形を検査したデコード後の画像の幅と高さ
+// - それ以外のエラーや未設定の場合は0（エラーメッセージと同じくスレッドローカル）
+void nextimage_last_error_image_size(int* width, int* height);
+
+// メモリ割り当て統計
+// - ライブラリ内部の割り当て（出力バッファ、エンコーダー、デコーダー、コマンドを含む）を数える
+// - コーデック内部の一時的な割り当ては含まない
+typedef struct {
+    int64_t allocations;  // 起動からの割り当て回数
+    int64_t frees;        // 起動からの解放回数
+} NextImageAllocationStats;
+
+// メモリリークカウンター
 // - 現在の割り当てカウント - 解放カウントを返す
-// - リリースビルドでは常に0を返す
-#ifdef NEXTIMAGE_DEBUG
 int64_t nextimage_allocation_counter(void);
-#endif
+
+// メモリ割り当て統計の取得
+void nextimage_allocation_stats(NextImageAllocationStats* stats);
 
 // バージョン取得
 const char* nextimage_version(void);
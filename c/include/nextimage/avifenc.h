@@ -7,6 +7,9 @@
 extern "C" {
 #endif
 
+// プログレッシブ（レイヤー）エンコードの最大レイヤー数（AV1の上限）
+#define NEXTIMAGE_AVIF_MAX_LAYERS 4
+
 // avifenc エンコードオプション
 typedef struct {
     // Quality settings
@@ -21,7 +24,7 @@ typedef struct {
     int max_quantizer_alpha;// 0-63, default -1 (use quality_alpha instead)
 
     // Format settings
-    int bit_depth;          // 8, 10, or 12 (default: 8)
+    int bit_depth;          // 8, 10, 12, or 0=auto (follow input: 16-bit PNG/TIFF -> 10/12) (default: 8)
     int yuv_format;         // 0=444, 1=422, 2=420, 3=400 (default: 444)
     int yuv_range;          // 0=limited, 1=full (default: 1=full for PNG/JPEG)
 
@@ -72,6 +75,31 @@ typedef struct {
     // Animation settings (for future use)
     int timescale;          // timescale/fps for animations (default: 30)
     int keyframe_interval;  // max keyframe interval (default: 0=disabled)
+
+    // Gain map (ISO 21496-1)
+    int gain_map_mode;                      // 0=disabled (default), 1=input is the SDR base and gain_map_alternate is the HDR rendition, 2=input is HDR (SDR base is tone mapped from it)
+    const uint8_t* gain_map_alternate_data; // HDR rendition image file (AVIF, or 16-bit PNG/TIFF) for gain_map_mode=1
+    size_t gain_map_alternate_size;         // HDR rendition data size in bytes
+    int gain_map_quality;                   // 0-100, gain map quality (default: 60)
+    int gain_map_downscale;                 // gain map downscaling as log2: 0=full size (default), 1=half, 2=quarter...
+    int gain_map_bit_depth;                 // 8, 10, or 12 (default: 8)
+    int gain_map_tone_mapping;              // tone mapping operator for the SDR base in gain_map_mode=2 (default: 1=BT.2390 EETF)
+    int hdr_color_primaries;                // CICP primaries of non-AVIF HDR inputs, -1=auto (9=BT.2020)
+    int hdr_transfer_characteristics;       // CICP transfer of non-AVIF HDR inputs, -1=auto (16=PQ)
+
+    // Grid encoding (avifenc --grid MxN) for images beyond the AV1 frame size limits
+    int grid_cols;          // grid columns M (1-256), 0=disabled (default); width must divide evenly
+    int grid_rows;          // grid rows N (1-256), 0=disabled (default); height must divide evenly
+
+    // Progressive (layered) encoding (avifenc --progressive / --layered)
+    int progressive_layers;                                       // number of layers 2-4, 0=disabled (default)
+    int progressive_layer_quality[NEXTIMAGE_AVIF_MAX_LAYERS];     // per-layer quality 0-100 from coarsest to final, -1=auto (default)
+
+    // Codec-specific options (avifenc -a key=value), forwarded to avifEncoderSetCodecSpecificOption.
+    // Keys may be prefixed with "color:"/"c:" or "alpha:"/"a:" to apply to one plane type only
+    const char** codec_option_keys;     // option names, e.g. "tune", "alpha:sharpness" (default: NULL)
+    const char** codec_option_values;   // option values, e.g. "ssim", "2" (default: NULL)
+    int codec_option_count;             // number of options (default: 0)
 } AVIFEncOptions;
 
 // デフォルトオプションの作成
@@ -96,6 +124,27 @@ NextImageStatus avifenc_run_command(
     NextImageBuffer* output
 );
 
+// バイト列の変換（統計情報付き）
+// stats: NextImageAVIFEncodeStats（avif.hで定義）
+struct NextImageAVIFEncodeStats;
+NextImageStatus avifenc_run_command_with_stats(
+    AVIFEncCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    struct NextImageAVIFEncodeStats* stats
+);
+
+// バイト列の変換（出力をライターへ逐次渡す、statsはNULL可）
+// 出力全体をメモリに保持しないため大きな画像向け
+NextImageStatus avifenc_run_command_to_writer(
+    AVIFEncCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    struct NextImageAVIFEncodeStats* stats
+);
+
 // コマンドの解放
 void avifenc_free_command(AVIFEncCommand* cmd);
 
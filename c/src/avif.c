@@ -3,6 +3,7 @@
 #include <string.h>
 #include <stdlib.h>
 #include <stdio.h>
+#include <math.h>
 
 // libavif headers
 #include "avif/avif.h"
@@ -17,6 +18,11 @@
 // stb_image_write for PNG/JPEG encoding (implementation is in webp.c)
 #include "../../deps/stb/stb_image_write.h"
 
+// libpng for reading 16-bit PNG at full precision
+#include <png.h>
+#include <setjmp.h>
+#include <zlib.h>
+
 // Platform-specific headers for CPU count query
 #if defined(_WIN32)
 #include <windows.h>
@@ -94,6 +100,7 @@ void nextimage_avif_default_encode_options(NextImageAVIFEncodeOptions* options)
     // Advanced settings
     options->sharp_yuv = 0;
     options->target_size = 0;  // disabled
+    options->jobs = -1;        // all cores
 
     // Transformation settings
     options->irot_angle = -1;      // disabled
@@ -126,6 +133,32 @@ void nextimage_avif_default_encode_options(NextImageAVIFEncodeOptions* options)
     // Animation settings
     options->timescale = 30;
     options->keyframe_interval = 0;  // disabled
+
+    // Gain map settings (avifgainmaputil defaults)
+    options->gain_map_mode = NEXTIMAGE_GAIN_MAP_NONE;
+    options->gain_map_alternate_data = NULL;
+    options->gain_map_alternate_size = 0;
+    options->gain_map_quality = 60;
+    options->gain_map_downscale = 0;     // full size
+    options->gain_map_bit_depth = 8;
+    options->gain_map_tone_mapping = NEXTIMAGE_TONE_MAPPING_BT2390;
+    options->hdr_color_primaries = -1;           // auto (BT.2020)
+    options->hdr_transfer_characteristics = -1;  // auto (PQ)
+
+    // Grid settings
+    options->grid_cols = 0;  // disabled
+    options->grid_rows = 0;  // disabled
+
+    // Progressive settings
+    options->progressive_layers = 0;  // disabled
+    for (int i = 0; i < NEXTIMAGE_AVIF_MAX_LAYERS; i++) {
+        options->progressive_layer_quality[i] = -1;  // auto
+    }
+
+    // Codec-specific options
+    options->codec_option_keys = NULL;
+    options->codec_option_values = NULL;
+    options->codec_option_count = 0;
 }
 
 // デフォルトデコードオプション
@@ -163,6 +196,15 @@ void nextimage_avif_default_decode_options(NextImageAVIFDecodeOptions* options)
     options->resize_width = 0;
     options->resize_height = 0;
     options->use_resize = 0;
+
+    // HDR -> SDR tone mapping (disabled by default)
+    options->tone_mapping = NEXTIMAGE_TONE_MAPPING_NONE;
+    options->tone_mapping_source_peak = 0;    // auto (CLLI or 1000 nits)
+    options->tone_mapping_target_nits = 203;  // BT.2408 reference white
+
+    // Gain map output (base image by default)
+    options->gain_map_output = NEXTIMAGE_GAIN_MAP_OUTPUT_BASE;
+    options->gain_map_headroom = 0.0f;
 }
 
 // YUV format を avifPixelFormat に変換
@@ -190,69 +232,317 @@ static avifRGBFormat pixel_format_to_avif_rgb(NextImagePixelFormat format) {
     }
 }
 
-// エンコード実装（画像ファイルデータから）
-NextImageStatus nextimage_avif_encode_alloc(
-    const uint8_t* input_data,
-    size_t input_size,
-    const NextImageAVIFEncodeOptions* options,
-    NextImageBuffer* output
-) {
-    if (!input_data || input_size == 0 || !output) {
-        nextimage_set_error("Invalid parameters: NULL input or output");
-        return NEXTIMAGE_ERROR_INVALID_PARAM;
+// ========================================
+// 高ビット深度入力（16-bit PNG/TIFF）
+// ========================================
+// libwebpのimageioは8-bit ARGBのWebPPictureにしか読み込めないため、
+// 16-bitのPNG/TIFFは独自に読み込んで精度を保ったままavifImageへ渡す
+// (avifencのavifpng.cと同じ考え方)
+
+// 16-bit RGBAのソース画像（ネイティブエンディアン）
+typedef struct {
+    uint32_t width;
+    uint32_t height;
+    int significant_bits;   // sBIT等から得た有効ビット数（不明な場合は16）
+    uint16_t* pixels;       // RGBA16, width * height * 4
+} HighBitDepthSource;
+
+static void high_bit_depth_source_free(HighBitDepthSource* src) {
+    if (src && src->pixels) {
+        nextimage_free(src->pixels);
+        src->pixels = NULL;
     }
+}
 
-    memset(output, 0, sizeof(NextImageBuffer));
+static int is_little_endian(void) {
+    const uint16_t probe = 1;
+    return *(const uint8_t*)&probe == 1;
+}
 
-    // デフォルトオプション
-    NextImageAVIFEncodeOptions default_opts;
-    if (!options) {
-        nextimage_avif_default_encode_options(&default_opts);
-        options = &default_opts;
+// PNG メモリリーダー
+typedef struct {
+    const uint8_t* data;
+    size_t size;
+    size_t offset;
+} PNGMemoryReader;
+
+static void png_read_from_memory(png_structp png, png_bytep out, png_size_t length) {
+    PNGMemoryReader* reader = (PNGMemoryReader*)png_get_io_ptr(png);
+    if (reader->offset + length > reader->size) {
+        png_error(png, "Read past end of PNG data");
+        return;
     }
+    memcpy(out, reader->data + reader->offset, length);
+    reader->offset += length;
+}
 
-    // 画像フォーマットを推測（libwebpのimageioを使用）
-    WebPInputFileFormat format = WebPGuessImageType(input_data, input_size);
-    if (format == WEBP_UNSUPPORTED_FORMAT) {
-        nextimage_set_error("Unsupported or unrecognized image format");
-        return NEXTIMAGE_ERROR_UNSUPPORTED;
+// 16-bit PNGを読み込む
+// 戻り値: 1=読み込み成功, 0=16-bitではない（8-bit経路を使う）, -1=エラー
+static int read_png_16bit(const uint8_t* data, size_t size, HighBitDepthSource* out) {
+    png_structp png = png_create_read_struct(PNG_LIBPNG_VER_STRING, NULL, NULL, NULL);
+    if (!png) {
+        nextimage_set_error("Failed to create PNG read struct");
+        return -1;
+    }
+    png_infop info = png_create_info_struct(png);
+    if (!info) {
+        png_destroy_read_struct(&png, NULL, NULL);
+        nextimage_set_error("Failed to create PNG info struct");
+        return -1;
+    }
+
+    PNGMemoryReader reader = { data, size, 0 };
+    // volatile: setjmp後にも値を保持するため
+    uint16_t* volatile pixels = NULL;
+    png_bytep* volatile rows = NULL;
+
+    if (setjmp(png_jmpbuf(png))) {
+        if (rows) nextimage_free(rows);
+        if (pixels) nextimage_free(pixels);
+        png_destroy_read_struct(&png, &info, NULL);
+        nextimage_set_error("Failed to read 16-bit PNG");
+        return -1;
+    }
+
+    png_set_read_fn(png, &reader, png_read_from_memory);
+    png_read_info(png, info);
+
+    if (png_get_bit_depth(png, info) != 16) {
+        png_destroy_read_struct(&png, &info, NULL);
+        return 0;
+    }
+
+    const uint32_t width = png_get_image_width(png, info);
+    const uint32_t height = png_get_image_height(png, info);
+    const int color_type = png_get_color_type(png, info);
+
+    // 有効ビット数（sBITチャンク）: 10-bitソースを16-bitで保存したPNG等
+    int significant_bits = 16;
+    png_color_8p sig_bit = NULL;
+    if (png_get_sBIT(png, info, &sig_bit) && sig_bit) {
+        int bits = (color_type & PNG_COLOR_MASK_COLOR) ? sig_bit->red : sig_bit->gray;
+        if (bits > 8 && bits < 16) {
+            significant_bits = bits;
+        }
     }
 
-    // 適切なリーダーを取得
-    WebPImageReader reader = WebPGetImageReader(format);
-    if (!reader) {
-        nextimage_set_error("No reader available for this image format");
-        return NEXTIMAGE_ERROR_UNSUPPORTED;
+    // RGBA16へ正規化
+    if (png_get_valid(png, info, PNG_INFO_tRNS)) {
+        png_set_tRNS_to_alpha(png);
     }
+    if (color_type == PNG_COLOR_TYPE_GRAY || color_type == PNG_COLOR_TYPE_GRAY_ALPHA) {
+        png_set_gray_to_rgb(png);
+    }
+    if (!(color_type & PNG_COLOR_MASK_ALPHA) && !png_get_valid(png, info, PNG_INFO_tRNS)) {
+        png_set_add_alpha(png, 0xFFFF, PNG_FILLER_AFTER);
+    }
+    if (is_little_endian()) {
+        png_set_swap(png);
+    }
+    png_set_interlace_handling(png);
+    png_read_update_info(png, info);
 
-    // WebPPictureに一旦読み込む（imageioを使うため）
-    WebPPicture picture;
-    // CRITICAL: Zero-initialize to prevent stack memory pollution between calls
-    memset(&picture, 0, sizeof(WebPPicture));
-    if (!WebPPictureInit(&picture)) {
-        nextimage_set_error("Failed to initialize WebPPicture");
-        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    const size_t row_bytes = (size_t)width * 4 * sizeof(uint16_t);
+    if (png_get_rowbytes(png, info) != row_bytes) {
+        png_destroy_read_struct(&png, &info, NULL);
+        nextimage_set_error("Unexpected PNG row size after conversion to RGBA16");
+        return -1;
     }
 
-    // CRITICAL: Set use_argb BEFORE calling reader to request ARGB format
-    // The reader checks this flag and preserves ARGB if set
-    picture.use_argb = 1;
+    pixels = (uint16_t*)nextimage_malloc(row_bytes * height);
+    if (!pixels) {
+        png_destroy_read_struct(&png, &info, NULL);
+        nextimage_set_error("Failed to allocate 16-bit PNG buffer");
+        return -1;
+    }
 
-    if (!reader(input_data, input_size, &picture, 1, NULL)) {
-        WebPPictureFree(&picture);
-        nextimage_set_error("Failed to read input image");
-        return NEXTIMAGE_ERROR_DECODE_FAILED;
+    rows = (png_bytep*)nextimage_malloc(sizeof(png_bytep) * height);
+    if (!rows) {
+        nextimage_free(pixels);
+        png_destroy_read_struct(&png, &info, NULL);
+        nextimage_set_error("Failed to allocate PNG row pointers");
+        return -1;
+    }
+    for (uint32_t y = 0; y < height; y++) {
+        rows[y] = (png_bytep)(pixels + (size_t)y * width * 4);
+    }
+
+    png_read_image(png, rows);
+    png_read_end(png, NULL);
+    nextimage_free(rows);
+    png_destroy_read_struct(&png, &info, NULL);
+
+    out->width = width;
+    out->height = height;
+    out->significant_bits = significant_bits;
+    out->pixels = pixels;
+    return 1;
+}
+
+#ifdef NEXTIMAGE_HAVE_TIFF
+// 16-bit TIFFを読み込む（チャンキー形式のグレー/RGB、アルファ付き、ストリップ/タイル形式を含む）
+// 戻り値: 1=読み込み成功, 0=16-bitではない（8-bit経路を使う）, -1=エラー
+static int read_tiff_16bit(const uint8_t* data, size_t size, HighBitDepthSource* out) {
+    NextImageTIFFMemory mem;
+    TIFF* tif = nextimage_tiff_open(data, size, &mem);
+    if (!tif) {
+        nextimage_set_error("Failed to open TIFF data");
+        return -1;
+    }
+
+    uint16_t bits_per_sample = 0, samples_per_pixel = 1, planar = PLANARCONFIG_CONTIG, photometric = 0;
+    uint32_t width = 0, height = 0;
+    TIFFGetFieldDefaulted(tif, TIFFTAG_BITSPERSAMPLE, &bits_per_sample);
+    TIFFGetFieldDefaulted(tif, TIFFTAG_SAMPLESPERPIXEL, &samples_per_pixel);
+    TIFFGetFieldDefaulted(tif, TIFFTAG_PLANARCONFIG, &planar);
+    TIFFGetField(tif, TIFFTAG_PHOTOMETRIC, &photometric);
+    TIFFGetField(tif, TIFFTAG_IMAGEWIDTH, &width);
+    TIFFGetField(tif, TIFFTAG_IMAGELENGTH, &height);
+
+    if (bits_per_sample != 16) {
+        TIFFClose(tif);
+        return 0;
+    }
+    const int is_gray = (photometric == PHOTOMETRIC_MINISBLACK);
+    const int color_samples = is_gray ? 1 : 3;
+    // RGBは1ピクセル3サンプル以上必要（不足するとバッファ外を読む）
+    if (planar != PLANARCONFIG_CONTIG ||
+        (photometric != PHOTOMETRIC_RGB && photometric != PHOTOMETRIC_MINISBLACK) ||
+        samples_per_pixel < color_samples || samples_per_pixel > 4 || width == 0 || height == 0) {
+        TIFFClose(tif);
+        nextimage_set_error("Unsupported 16-bit TIFF layout (photometric=%d, samples=%d, planar=%d)",
+                            photometric, samples_per_pixel, planar);
+        return -1;
+    }
+
+    const int has_alpha = samples_per_pixel > color_samples;
+
+    // タイル形式はタイル単位、ストリップ形式はスキャンライン単位で読み込む
+    const int tiled = TIFFIsTiled(tif);
+    uint32_t tile_width = width, tile_height = 1;
+    if (tiled) {
+        TIFFGetField(tif, TIFFTAG_TILEWIDTH, &tile_width);
+        TIFFGetField(tif, TIFFTAG_TILELENGTH, &tile_height);
+        if (tile_width == 0 || tile_height == 0) {
+            TIFFClose(tif);
+            nextimage_set_error("Invalid TIFF tile size %ux%u", tile_width, tile_height);
+            return -1;
+        }
+    }
+
+    uint16_t* pixels = (uint16_t*)nextimage_malloc((size_t)width * height * 4 * sizeof(uint16_t));
+    uint16_t* buf = (uint16_t*)nextimage_malloc((size_t)(tiled ? TIFFTileSize(tif) : TIFFScanlineSize(tif)));
+    if (!pixels || !buf) {
+        if (pixels) nextimage_free(pixels);
+        if (buf) nextimage_free(buf);
+        TIFFClose(tif);
+        nextimage_set_error("Failed to allocate 16-bit TIFF buffer");
+        return -1;
+    }
+
+    for (uint32_t ty = 0; ty < height; ty += tile_height) {
+        for (uint32_t tx = 0; tx < width; tx += tile_width) {
+            const int read_ok = tiled
+                ? TIFFReadTile(tif, buf, tx, ty, 0, 0) >= 0
+                : TIFFReadScanline(tif, buf, ty, 0) >= 0;
+            if (!read_ok) {
+                nextimage_free(buf);
+                nextimage_free(pixels);
+                TIFFClose(tif);
+                if (tiled) {
+                    nextimage_set_error("Failed to read TIFF tile at %u,%u", tx, ty);
+                } else {
+                    nextimage_set_error("Failed to read TIFF scanline %u", ty);
+                }
+                return -1;
+            }
+            // 画像の右端・下端にはみ出したタイルの部分は捨てる
+            const uint32_t rows = (height - ty < tile_height) ? height - ty : tile_height;
+            const uint32_t cols = (width - tx < tile_width) ? width - tx : tile_width;
+            for (uint32_t y = 0; y < rows; y++) {
+                uint16_t* dst = pixels + ((size_t)(ty + y) * width + tx) * 4;
+                for (uint32_t x = 0; x < cols; x++) {
+                    const uint16_t* s = buf + ((size_t)y * tile_width + x) * samples_per_pixel;
+                    dst[x * 4 + 0] = s[0];
+                    dst[x * 4 + 1] = is_gray ? s[0] : s[1];
+                    dst[x * 4 + 2] = is_gray ? s[0] : s[2];
+                    dst[x * 4 + 3] = has_alpha ? s[color_samples] : 0xFFFF;
+                }
+            }
+        }
     }
 
+    nextimage_free(buf);
+    TIFFClose(tif);
+
+    out->width = width;
+    out->height = height;
+    out->significant_bits = 16;
+    out->pixels = pixels;
+    return 1;
+}
+#endif // NEXTIMAGE_HAVE_TIFF
+
+// 入力が16-bit PNG/TIFFであれば高ビット深度のまま読み込む
+// 戻り値: 1=読み込み成功, 0=対象外（8-bit経路を使う）, -1=エラー
+static int read_high_bit_depth_source(
+    const uint8_t* data,
+    size_t size,
+    WebPInputFileFormat format,
+    HighBitDepthSource* out
+) {
+    memset(out, 0, sizeof(HighBitDepthSource));
+    switch (format) {
+        case WEBP_PNG_FORMAT:
+            return read_png_16bit(data, size, out);
+#ifdef NEXTIMAGE_HAVE_TIFF
+        case WEBP_TIFF_FORMAT:
+            return read_tiff_16bit(data, size, out);
+#endif
+        default:
+            return 0;
+    }
+}
+
+// bit_depth=0（自動）の場合に入力から出力ビット深度を決定する
+// avifencと同様に16-bit入力は12-bit、sBITで10-bit以下と分かる場合は10-bit
+static int resolve_bit_depth(int requested, const HighBitDepthSource* source) {
+    if (requested != NEXTIMAGE_AVIF_BIT_DEPTH_AUTO) {
+        return requested;
+    }
+    if (!source || !source->pixels) {
+        return 8;
+    }
+    return (source->significant_bits <= 10) ? 10 : 12;
+}
+
+// 出力ビット深度の指定を検証する
+static NextImageStatus validate_bit_depth(const NextImageAVIFEncodeOptions* options) {
+    if (options->bit_depth != NEXTIMAGE_AVIF_BIT_DEPTH_AUTO &&
+        options->bit_depth != 8 && options->bit_depth != 10 && options->bit_depth != 12) {
+        nextimage_set_error("Invalid bit depth: %d (must be 8, 10, 12, or 0 for auto)", options->bit_depth);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    return NEXTIMAGE_OK;
+}
+
+// RGBA画素（rgb_depth=8はuint8_t、16はuint16_t）からYUV変換済みのavifImageを作成する
+// pixelsは変換中に参照されるだけで、コピーや解放はしない
+static NextImageStatus create_avif_image_from_rgba(
+    const uint8_t* pixels,
+    uint32_t row_bytes,
+    uint32_t width,
+    uint32_t height,
+    uint32_t rgb_depth,
+    uint32_t image_depth,
+    const NextImageAVIFEncodeOptions* options,
+    avifImage** out_image
+) {
+    *out_image = NULL;
+
     // avifImageを作成
-    avifImage* image = avifImageCreate(
-        picture.width,
-        picture.height,
-        options->bit_depth,
-        yuv_format_to_avif(options->yuv_format)
-    );
+    avifImage* image = avifImageCreate(width, height, image_depth, yuv_format_to_avif(options->yuv_format));
     if (!image) {
-        WebPPictureFree(&picture);
         nextimage_set_error("Failed to create avifImage");
         return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
@@ -293,14 +583,16 @@ NextImageStatus nextimage_avif_encode_alloc(
         image->alphaPremultiplied = AVIF_TRUE;
     }
 
-    // avifRGBImageを設定
+    // avifRGBImageを設定（画素は呼び出し元のバッファを直接参照する）
     avifRGBImage rgb;
     // CRITICAL: Zero-initialize to match avifenc behavior
     memset(&rgb, 0, sizeof(avifRGBImage));
 
     avifRGBImageSetDefaults(&rgb, image);
     rgb.format = AVIF_RGB_FORMAT_RGBA;
-    rgb.depth = 8;
+    rgb.depth = rgb_depth;
+    rgb.pixels = (uint8_t*)pixels;
+    rgb.rowBytes = row_bytes;
 
     // Set chroma downsampling method (SharpYUV if requested)
     if (options->sharp_yuv && options->yuv_format == 2) {  // YUV420 only
@@ -309,20 +601,90 @@ NextImageStatus nextimage_avif_encode_alloc(
         rgb.chromaDownsampling = AVIF_CHROMA_DOWNSAMPLING_AUTOMATIC;
     }
 
-    // RGBAバッファを割り当て
-    avifResult allocResult = avifRGBImageAllocatePixels(&rgb);
-    if (allocResult != AVIF_RESULT_OK) {
+    // RGBからYUVに変換（16-bit入力はimage->depth（10/12-bit）へスケーリングされる）
+    avifResult result = avifImageRGBToYUV(image, &rgb);
+    if (result != AVIF_RESULT_OK) {
         avifImageDestroy(image);
+        nextimage_set_error("Failed to convert RGB to YUV: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    *out_image = image;
+    return NEXTIMAGE_OK;
+}
+
+// 画像ファイルデータを読み込み、YUV変換済みのavifImageを作成する
+// 16-bit PNG/TIFFは高ビット深度のまま、それ以外はimageio経由の8-bit ARGBで読み込む
+static NextImageStatus create_avif_image_from_file(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    avifImage** out_image
+) {
+    *out_image = NULL;
+
+    NextImageStatus status = validate_bit_depth(options);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+
+    // 画像フォーマットを推測（libwebpのimageioを使用）
+    WebPInputFileFormat format = WebPGuessImageType(input_data, input_size);
+    if (format == WEBP_UNSUPPORTED_FORMAT) {
+        nextimage_set_error("Unsupported or unrecognized image format");
+        return NEXTIMAGE_ERROR_UNSUPPORTED;
+    }
+
+    // 16-bit PNG/TIFFの場合は精度を落とさずに読み込む
+    HighBitDepthSource source;
+    int high_result = read_high_bit_depth_source(input_data, input_size, format, &source);
+    if (high_result < 0) {
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
+    }
+    if (high_result > 0) {
+        // RGBA16 (uint16_t) をそのままavifRGBImage (16-bit) として渡す
+        status = create_avif_image_from_rgba(
+            (const uint8_t*)source.pixels,
+            source.width * 4 * (uint32_t)sizeof(uint16_t),
+            source.width,
+            source.height,
+            16,
+            resolve_bit_depth(options->bit_depth, &source),
+            options,
+            out_image
+        );
+        high_bit_depth_source_free(&source);
+        return status;
+    }
+
+    // 適切なリーダーを取得
+    WebPImageReader reader = WebPGetImageReader(format);
+    if (!reader) {
+        nextimage_set_error("No reader available for this image format");
+        return NEXTIMAGE_ERROR_UNSUPPORTED;
+    }
+
+    // WebPPictureに一旦読み込む（imageioを使うため）
+    WebPPicture picture;
+    // CRITICAL: Zero-initialize to prevent stack memory pollution between calls
+    memset(&picture, 0, sizeof(WebPPicture));
+    if (!WebPPictureInit(&picture)) {
+        nextimage_set_error("Failed to initialize WebPPicture");
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    // CRITICAL: Set use_argb BEFORE calling reader to request ARGB format
+    // The reader checks this flag and preserves ARGB if set
+    picture.use_argb = 1;
+
+    if (!reader(input_data, input_size, &picture, 1, NULL)) {
         WebPPictureFree(&picture);
-        nextimage_set_error("Failed to allocate RGB buffer: %s", avifResultToString(allocResult));
-        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        nextimage_set_error("Failed to read input image");
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
     }
 
-    // WebPPictureのARGBデータをavifRGBImageにコピー
     // picture.use_argb=1を事前設定しているため、readerはARGBフォーマットで読み込むはず
     if (!picture.use_argb || !picture.argb) {
-        avifRGBImageFreePixels(&rgb);
-        avifImageDestroy(image);
         WebPPictureFree(&picture);
         nextimage_set_error("WebPPicture is not in ARGB format (use_argb=%d, argb=%p)",
                            picture.use_argb, (void*)picture.argb);
@@ -330,225 +692,1513 @@ NextImageStatus nextimage_avif_encode_alloc(
     }
 
     // ARGB (uint32_t) -> RGBA (uint8_t) conversion
+    const uint32_t width = (uint32_t)picture.width;
+    const uint32_t height = (uint32_t)picture.height;
+    uint8_t* rgba = (uint8_t*)nextimage_malloc((size_t)width * height * 4);
+    if (!rgba) {
+        WebPPictureFree(&picture);
+        nextimage_set_error("Failed to allocate RGB buffer");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
     const uint32_t* src = picture.argb;
-    uint8_t* dst = rgb.pixels;
-    for (int y = 0; y < picture.height; y++) {
-        for (int x = 0; x < picture.width; x++) {
+    for (uint32_t y = 0; y < height; y++) {
+        for (uint32_t x = 0; x < width; x++) {
             const uint32_t argb_val = src[y * picture.argb_stride + x];
-            const uint8_t a = (argb_val >> 24) & 0xFF;
-            const uint8_t r = (argb_val >> 16) & 0xFF;
-            const uint8_t g = (argb_val >> 8) & 0xFF;
-            const uint8_t b = argb_val & 0xFF;
-
-            dst[y * rgb.rowBytes + x * 4 + 0] = r;
-            dst[y * rgb.rowBytes + x * 4 + 1] = g;
-            dst[y * rgb.rowBytes + x * 4 + 2] = b;
-            dst[y * rgb.rowBytes + x * 4 + 3] = a;
+            uint8_t* dst = rgba + ((size_t)y * width + x) * 4;
+            dst[0] = (argb_val >> 16) & 0xFF;
+            dst[1] = (argb_val >> 8) & 0xFF;
+            dst[2] = argb_val & 0xFF;
+            dst[3] = (argb_val >> 24) & 0xFF;
         }
     }
-
-    // RGBからYUVに変換
-    avifResult result = avifImageRGBToYUV(image, &rgb);
-    avifRGBImageFreePixels(&rgb);
     WebPPictureFree(&picture);
 
-    if (result != AVIF_RESULT_OK) {
-        avifImageDestroy(image);
-        nextimage_set_error("Failed to convert RGB to YUV: %s", avifResultToString(result));
-        return NEXTIMAGE_ERROR_ENCODE_FAILED;
-    }
+    status = create_avif_image_from_rgba(rgba, width * 4, width, height, 8,
+                                         resolve_bit_depth(options->bit_depth, NULL), options, out_image);
+    nextimage_free(rgba);
+    return status;
+}
 
-    // Set metadata (EXIF, XMP, ICC) if provided
-    if (options->exif_data && options->exif_size > 0) {
-        result = avifImageSetMetadataExif(image, options->exif_data, options->exif_size);
-        if (result != AVIF_RESULT_OK) {
-            avifImageDestroy(image);
-            nextimage_set_error("Failed to set EXIF metadata: %s", avifResultToString(result));
-            return NEXTIMAGE_ERROR_ENCODE_FAILED;
-        }
-    }
+// ========================================
+// ゲインマップ（ISO 21496-1）付きエンコード
+// ========================================
 
-    if (options->xmp_data && options->xmp_size > 0) {
-        result = avifImageSetMetadataXMP(image, options->xmp_data, options->xmp_size);
-        if (result != AVIF_RESULT_OK) {
-            avifImageDestroy(image);
-            nextimage_set_error("Failed to set XMP metadata: %s", avifResultToString(result));
-            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+// トーンマッピング（デコード側で定義）
+static int is_hdr_transfer(avifTransferCharacteristics tc);
+static NextImageStatus tone_map_hdr_to_rgb8(
+    const avifImage* image,
+    double mastering_peak,
+    const NextImageAVIFDecodeOptions* options,
+    avifRGBImage* out);
+
+// ISOBMFFのftypボックスで始まるか（AVIF入力の判定）
+static int is_avif_file(const uint8_t* data, size_t size) {
+    return size >= 12 && memcmp(data + 4, "ftyp", 4) == 0;
+}
+
+static uint32_t read_be32(const uint8_t* p) {
+    return ((uint32_t)p[0] << 24) | ((uint32_t)p[1] << 16) | ((uint32_t)p[2] << 8) | (uint32_t)p[3];
+}
+
+// ISOBMFFのボックス列からtypeのボックスを探し、ペイロードを返す
+// 戻り値: 1=見つかった, 0=見つからない（途中で切れたデータを含む）
+static int find_box(const uint8_t* data, size_t size, const char* type,
+                    const uint8_t** payload, size_t* payload_size) {
+    size_t pos = 0;
+    while (size - pos >= 8) {
+        uint64_t box_size = read_be32(data + pos);
+        size_t header = 8;
+        if (box_size == 1) {
+            if (size - pos < 16) return 0;
+            box_size = ((uint64_t)read_be32(data + pos + 8) << 32) | read_be32(data + pos + 12);
+            header = 16;
+        } else if (box_size == 0) {
+            box_size = size - pos;
+        }
+        if (box_size < header || box_size > size - pos) return 0;
+        if (memcmp(data + pos + 4, type, 4) == 0) {
+            *payload = data + pos + header;
+            *payload_size = (size_t)box_size - header;
+            return 1;
         }
+        pos += (size_t)box_size;
     }
+    return 0;
+}
 
-    if (options->icc_data && options->icc_size > 0) {
-        result = avifImageSetProfileICC(image, options->icc_data, options->icc_size);
+// mdcv（Mastering Display Colour Volume）の最大マスタリング輝度をnitsで返す（なければ0）
+// libavifはmdcvをavifImageに公開しないため、meta/iprp/ipcoを辿って直接読む
+static double avif_mastering_peak_nits(const uint8_t* data, size_t size) {
+    if (!data || !is_avif_file(data, size)) return 0.0;
+    const uint8_t* p;
+    size_t n;
+    if (!find_box(data, size, "meta", &p, &n) || n < 4) return 0.0;
+    // metaはFullBox（version/flagsの4バイト）
+    if (!find_box(p + 4, n - 4, "iprp", &p, &n)) return 0.0;
+    if (!find_box(p, n, "ipco", &p, &n)) return 0.0;
+    // display_primaries(12) + white_point(4) + max_display_mastering_luminance(4, 0.0001 cd/m2単位)
+    if (!find_box(p, n, "mdcv", &p, &n) || n < 24) return 0.0;
+    return read_be32(p + 16) * 0.0001;
+}
+
+// HDR画像を読み込む
+// AVIFはCICP/CLLIを保ったままデコードし、PNG/TIFFはhdr_*のCICPを付けて高ビット深度で読み込む
+static NextImageStatus load_hdr_image(
+    const uint8_t* data,
+    size_t size,
+    const NextImageAVIFEncodeOptions* options,
+    avifImage** out_image
+) {
+    *out_image = NULL;
+
+    if (is_avif_file(data, size)) {
+        avifDecoder* decoder = avifDecoderCreate();
+        if (!decoder) {
+            nextimage_set_error("Failed to create AVIF decoder");
+            return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        }
+        avifImage* image = avifImageCreateEmpty();
+        if (!image) {
+            avifDecoderDestroy(decoder);
+            nextimage_set_error("Failed to create avifImage");
+            return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        }
+        avifResult result = avifDecoderReadMemory(decoder, image, data, size);
+        avifDecoderDestroy(decoder);
         if (result != AVIF_RESULT_OK) {
             avifImageDestroy(image);
-            nextimage_set_error("Failed to set ICC profile: %s", avifResultToString(result));
-            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+            nextimage_set_error("Failed to decode HDR AVIF: %s", avifResultToString(result));
+            return NEXTIMAGE_ERROR_DECODE_FAILED;
         }
+        *out_image = image;
+        return NEXTIMAGE_OK;
+    }
+
+    // PNG/TIFF: メタデータなし、4:4:4、入力ビット深度のまま
+    NextImageAVIFEncodeOptions hdr_opts = *options;
+    hdr_opts.bit_depth = NEXTIMAGE_AVIF_BIT_DEPTH_AUTO;
+    hdr_opts.yuv_format = 0;
+    hdr_opts.yuv_range = 1;
+    hdr_opts.sharp_yuv = 0;
+    hdr_opts.premultiply_alpha = 0;
+    hdr_opts.exif_data = NULL;
+    hdr_opts.exif_size = 0;
+    hdr_opts.xmp_data = NULL;
+    hdr_opts.xmp_size = 0;
+    hdr_opts.icc_data = NULL;
+    hdr_opts.icc_size = 0;
+    hdr_opts.color_primaries = (options->hdr_color_primaries >= 0)
+        ? options->hdr_color_primaries : AVIF_COLOR_PRIMARIES_BT2020;
+    hdr_opts.transfer_characteristics = (options->hdr_transfer_characteristics >= 0)
+        ? options->hdr_transfer_characteristics : AVIF_TRANSFER_CHARACTERISTICS_PQ;
+    hdr_opts.matrix_coefficients = (hdr_opts.color_primaries == AVIF_COLOR_PRIMARIES_BT2020)
+        ? AVIF_MATRIX_COEFFICIENTS_BT2020_NCL : AVIF_MATRIX_COEFFICIENTS_BT601;
+
+    return create_avif_image_from_file(data, size, &hdr_opts, out_image);
+}
+
+// HDR画像をトーンマッピングしてSDR(sRGB)のベース画像を作成する
+static NextImageStatus create_sdr_base_from_hdr(
+    const avifImage* hdr,
+    double mastering_peak,
+    const NextImageAVIFEncodeOptions* options,
+    avifImage** out_image
+) {
+    *out_image = NULL;
+
+    if (!is_hdr_transfer(hdr->transferCharacteristics)) {
+        nextimage_set_error("HDR input must use the PQ (16) or HLG (18) transfer characteristics, got %d",
+                            hdr->transferCharacteristics);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
     }
 
-    // Set transformation properties
-    // Note: transformFlags must be set to enable encoding of these properties
-    image->transformFlags = 0;
+    NextImageAVIFDecodeOptions tm_opts;
+    nextimage_avif_default_decode_options(&tm_opts);
+    tm_opts.tone_mapping = options->gain_map_tone_mapping;
 
-    // Image rotation (irot)
-    if (options->irot_angle >= 0 && options->irot_angle <= 3) {
-        image->irot.angle = (uint8_t)options->irot_angle;
-        image->transformFlags |= AVIF_TRANSFORM_IROT;
+    avifRGBImage rgb;
+    memset(&rgb, 0, sizeof(avifRGBImage));
+    avifRGBImageSetDefaults(&rgb, hdr);
+    rgb.format = AVIF_RGB_FORMAT_RGBA;
+    rgb.depth = 8;
+    avifResult result = avifRGBImageAllocatePixels(&rgb);
+    if (result != AVIF_RESULT_OK) {
+        nextimage_set_error("Failed to allocate RGB buffer: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
 
-    // Image mirror (imir)
-    if (options->imir_axis >= 0 && options->imir_axis <= 1) {
-        image->imir.axis = (uint8_t)options->imir_axis;
-        image->transformFlags |= AVIF_TRANSFORM_IMIR;
+    NextImageStatus status = tone_map_hdr_to_rgb8(hdr, mastering_peak, &tm_opts, &rgb);
+    if (status != NEXTIMAGE_OK) {
+        avifRGBImageFreePixels(&rgb);
+        return status;
     }
 
-    // Pixel aspect ratio (pasp)
-    if (options->pasp[0] >= 0 && options->pasp[1] >= 0) {
-        image->pasp.hSpacing = (uint32_t)options->pasp[0];
-        image->pasp.vSpacing = (uint32_t)options->pasp[1];
-        image->transformFlags |= AVIF_TRANSFORM_PASP;
+    const int depth = (options->bit_depth == NEXTIMAGE_AVIF_BIT_DEPTH_AUTO) ? 8 : options->bit_depth;
+    avifImage* base = avifImageCreate(hdr->width, hdr->height, depth, yuv_format_to_avif(options->yuv_format));
+    if (!base) {
+        avifRGBImageFreePixels(&rgb);
+        nextimage_set_error("Failed to create avifImage");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
 
-    // Crop rectangle - convert to clap
-    if (options->crop[0] >= 0) {
-        avifCropRect cropRect;
-        cropRect.x = (uint32_t)options->crop[0];
-        cropRect.y = (uint32_t)options->crop[1];
-        cropRect.width = (uint32_t)options->crop[2];
-        cropRect.height = (uint32_t)options->crop[3];
+    // トーンマッピングの出力はsRGB (BT.709原色)
+    base->colorPrimaries = AVIF_COLOR_PRIMARIES_BT709;
+    base->transferCharacteristics = AVIF_TRANSFER_CHARACTERISTICS_SRGB;
+    base->matrixCoefficients = (options->matrix_coefficients >= 0)
+        ? (avifMatrixCoefficients)options->matrix_coefficients : AVIF_MATRIX_COEFFICIENTS_BT601;
+    base->yuvRange = (options->yuv_range == 0) ? AVIF_RANGE_LIMITED : AVIF_RANGE_FULL;
 
-        avifDiagnostics diag;
-        avifDiagnosticsClearError(&diag);
+    if (options->sharp_yuv && options->yuv_format == 2) {
+        rgb.chromaDownsampling = AVIF_CHROMA_DOWNSAMPLING_SHARP_YUV;
+    }
 
-        avifBool convertResult = avifCleanApertureBoxFromCropRect(
-            &image->clap,
-            &cropRect,
-            picture.width,
-            picture.height,
-            &diag
-        );
+    result = avifImageRGBToYUV(base, &rgb);
+    avifRGBImageFreePixels(&rgb);
+    if (result != AVIF_RESULT_OK) {
+        avifImageDestroy(base);
+        nextimage_set_error("Failed to convert RGB to YUV: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
 
-        if (!convertResult) {
-            avifRGBImageFreePixels(&rgb);
-            avifImageDestroy(image);
-            WebPPictureFree(&picture);
-            nextimage_set_error("Failed to convert crop rect to clap: %s", diag.error);
-            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    *out_image = base;
+    return NEXTIMAGE_OK;
+}
+
+// ベース画像とHDR画像からゲインマップを計算してベース画像に付与する
+static NextImageStatus attach_gain_map(
+    avifImage* base,
+    const avifImage* alternate,
+    const NextImageAVIFEncodeOptions* options
+) {
+    if (base->width != alternate->width || base->height != alternate->height) {
+        nextimage_set_error("Gain map base and alternate images differ in size: %ux%u vs %ux%u",
+                            base->width, base->height, alternate->width, alternate->height);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    base->gainMap = avifGainMapCreate();
+    if (!base->gainMap) {
+        nextimage_set_error("Failed to create gain map");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    uint32_t gm_width = base->width >> options->gain_map_downscale;
+    uint32_t gm_height = base->height >> options->gain_map_downscale;
+    if (gm_width < 1) gm_width = 1;
+    if (gm_height < 1) gm_height = 1;
+
+    base->gainMap->image = avifImageCreate(gm_width, gm_height, options->gain_map_bit_depth,
+                                           AVIF_PIXEL_FORMAT_YUV444);
+    if (!base->gainMap->image) {
+        nextimage_set_error("Failed to create gain map image");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    avifDiagnostics diag;
+    avifDiagnosticsClearError(&diag);
+    avifResult result = avifImageComputeGainMap(base, alternate, base->gainMap, &diag);
+    if (result != AVIF_RESULT_OK) {
+        nextimage_set_error("Failed to compute gain map: %s (%s)", avifResultToString(result), diag.error);
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    return NEXTIMAGE_OK;
+}
+
+// エンコード対象のavifImageを作成する（ゲインマップモードに応じてゲインマップを付与）
+static NextImageStatus create_avif_base_image(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    avifImage** out_image
+) {
+    *out_image = NULL;
+
+    if (options->gain_map_mode == NEXTIMAGE_GAIN_MAP_NONE) {
+        return create_avif_image_from_file(input_data, input_size, options, out_image);
+    }
+
+    if (options->gain_map_mode != NEXTIMAGE_GAIN_MAP_FROM_PAIR &&
+        options->gain_map_mode != NEXTIMAGE_GAIN_MAP_FROM_HDR) {
+        nextimage_set_error("Invalid gain map mode: %d", options->gain_map_mode);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (options->gain_map_bit_depth != 8 && options->gain_map_bit_depth != 10 &&
+        options->gain_map_bit_depth != 12) {
+        nextimage_set_error("Invalid gain map bit depth: %d (must be 8, 10, or 12)", options->gain_map_bit_depth);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (options->gain_map_downscale < 0 || options->gain_map_downscale > 7) {
+        nextimage_set_error("Invalid gain map downscale: %d (must be 0-7)", options->gain_map_downscale);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (options->gain_map_quality < 0 || options->gain_map_quality > 100) {
+        nextimage_set_error("Invalid gain map quality: %d (must be 0-100)", options->gain_map_quality);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    avifImage* base = NULL;
+    avifImage* hdr = NULL;
+    NextImageStatus status;
+
+    if (options->gain_map_mode == NEXTIMAGE_GAIN_MAP_FROM_PAIR) {
+        if (!options->gain_map_alternate_data || options->gain_map_alternate_size == 0) {
+            nextimage_set_error("Gain map mode 1 requires an alternate (HDR) image");
+            return NEXTIMAGE_ERROR_INVALID_PARAM;
+        }
+        status = create_avif_image_from_file(input_data, input_size, options, &base);
+        if (status != NEXTIMAGE_OK) {
+            return status;
+        }
+        status = load_hdr_image(options->gain_map_alternate_data, options->gain_map_alternate_size,
+                                options, &hdr);
+    } else {
+        status = load_hdr_image(input_data, input_size, options, &hdr);
+        if (status == NEXTIMAGE_OK) {
+            status = create_sdr_base_from_hdr(hdr, avif_mastering_peak_nits(input_data, input_size),
+                                              options, &base);
+        }
+    }
+
+    if (status == NEXTIMAGE_OK) {
+        status = attach_gain_map(base, hdr, options);
+    }
+
+    if (hdr) {
+        avifImageDestroy(hdr);
+    }
+    if (status != NEXTIMAGE_OK) {
+        if (base) {
+            avifImageDestroy(base);
+        }
+        return status;
+    }
+
+    *out_image = base;
+    return NEXTIMAGE_OK;
+}
+
+// ========================================
+// グリッドエンコード（avifenc --grid MxN）
+// ========================================
+
+#define MAX_GRID_DIMENSION 256
+
+// グリッド指定を検証する（avifencと同じく幅・高さが割り切れることを要求）
+static NextImageStatus validate_grid(const avifImage* image, const NextImageAVIFEncodeOptions* options) {
+    if (options->grid_cols == 0 && options->grid_rows == 0) {
+        return NEXTIMAGE_OK;
+    }
+    if (options->grid_cols < 1 || options->grid_cols > MAX_GRID_DIMENSION ||
+        options->grid_rows < 1 || options->grid_rows > MAX_GRID_DIMENSION) {
+        nextimage_set_error("Invalid grid: %dx%d (columns and rows must be 1-%d)",
+                            options->grid_cols, options->grid_rows, MAX_GRID_DIMENSION);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if ((image->width % (uint32_t)options->grid_cols) != 0) {
+        nextimage_set_error("Can't split image width (%u) evenly into %d columns",
+                            image->width, options->grid_cols);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if ((image->height % (uint32_t)options->grid_rows) != 0) {
+        nextimage_set_error("Can't split image height (%u) evenly into %d rows",
+                            image->height, options->grid_rows);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (image->gainMap) {
+        nextimage_set_error("Grid encoding cannot be combined with a gain map");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    return NEXTIMAGE_OK;
+}
+
+// 画像をセルに分割してグリッドとして追加する
+// セルはavifImageSetViewRectで元画像のプレーンを参照するためコピーは発生しない
+static avifResult add_image_grid(avifEncoder* encoder, const avifImage* image, int cols, int rows) {
+    const uint32_t cell_count = (uint32_t)cols * (uint32_t)rows;
+    avifImage** cells = (avifImage**)nextimage_calloc(cell_count, sizeof(avifImage*));
+    if (!cells) {
+        return AVIF_RESULT_OUT_OF_MEMORY;
+    }
+
+    const uint32_t cell_width = image->width / (uint32_t)cols;
+    const uint32_t cell_height = image->height / (uint32_t)rows;
+
+    avifResult result = AVIF_RESULT_OK;
+    for (uint32_t y = 0; y < (uint32_t)rows && result == AVIF_RESULT_OK; y++) {
+        for (uint32_t x = 0; x < (uint32_t)cols; x++) {
+            avifImage* cell = avifImageCreateEmpty();
+            if (!cell) {
+                result = AVIF_RESULT_OUT_OF_MEMORY;
+                break;
+            }
+            cells[y * (uint32_t)cols + x] = cell;
+
+            avifCropRect rect = { x * cell_width, y * cell_height, cell_width, cell_height };
+            result = avifImageSetViewRect(cell, image, &rect);
+            if (result != AVIF_RESULT_OK) {
+                break;
+            }
+        }
+    }
+
+    // メタデータは先頭セルから書き出される
+    if (result == AVIF_RESULT_OK && image->icc.size > 0) {
+        result = avifRWDataSet(&cells[0]->icc, image->icc.data, image->icc.size);
+    }
+    if (result == AVIF_RESULT_OK && image->exif.size > 0) {
+        result = avifRWDataSet(&cells[0]->exif, image->exif.data, image->exif.size);
+    }
+    if (result == AVIF_RESULT_OK && image->xmp.size > 0) {
+        result = avifRWDataSet(&cells[0]->xmp, image->xmp.data, image->xmp.size);
+    }
+
+    if (result == AVIF_RESULT_OK) {
+        result = avifEncoderAddImageGrid(encoder, (uint32_t)cols, (uint32_t)rows,
+                                         (const avifImage* const*)cells, AVIF_ADD_IMAGE_FLAG_SINGLE);
+    }
+
+    for (uint32_t i = 0; i < cell_count; i++) {
+        if (cells[i]) {
+            avifImageDestroy(cells[i]);
+        }
+    }
+    nextimage_free(cells);
+    return result;
+}
+
+// ========================================
+// プログレッシブ（レイヤー）エンコード（avifenc --progressive / --layered）
+// ========================================
+
+// 自動設定時の最初のレイヤーの品質（粗いプレビュー）
+#define PROGRESSIVE_BASE_QUALITY 10
+
+static NextImageStatus validate_progressive(const NextImageAVIFEncodeOptions* options) {
+    const int layers = options->progressive_layers;
+    if (layers == 0 || layers == 1) {
+        return NEXTIMAGE_OK;
+    }
+    if (layers < 0 || layers > NEXTIMAGE_AVIF_MAX_LAYERS) {
+        nextimage_set_error("Invalid progressive layer count: %d (must be 2-%d)", layers, NEXTIMAGE_AVIF_MAX_LAYERS);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    for (int i = 0; i < layers; i++) {
+        const int q = options->progressive_layer_quality[i];
+        if (q != -1 && (q < 0 || q > 100)) {
+            nextimage_set_error("Invalid quality for layer %d: %d (must be 0-100, or -1 for auto)", i, q);
+            return NEXTIMAGE_ERROR_INVALID_PARAM;
+        }
+    }
+    if (options->grid_cols > 0 || options->grid_rows > 0) {
+        nextimage_set_error("Progressive encoding cannot be combined with grid encoding");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (options->gain_map_mode != NEXTIMAGE_GAIN_MAP_NONE) {
+        nextimage_set_error("Progressive encoding cannot be combined with a gain map");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    return NEXTIMAGE_OK;
+}
+
+// レイヤーの品質を決定する（-1は最初のレイヤーから最終品質まで線形に補間）
+static int progressive_layer_quality(const NextImageAVIFEncodeOptions* options, int layer, int final_quality) {
+    const int layers = options->progressive_layers;
+    if (options->progressive_layer_quality[layer] >= 0) {
+        return options->progressive_layer_quality[layer];
+    }
+    if (layer == layers - 1) {
+        return final_quality;
+    }
+    const int base = (PROGRESSIVE_BASE_QUALITY < final_quality) ? PROGRESSIVE_BASE_QUALITY : final_quality;
+    return base + (final_quality - base) * layer / (layers - 1);
+}
+
+// ========================================
+// コーデック固有オプション（avifenc -a key=value）
+// ========================================
+
+// 値の範囲を事前に検証するaomの整数オプション
+// （表にないキーはそのままlibaomへ渡し、エンコード時に検証される）
+typedef struct {
+    const char* name;
+    int min;
+    int max;
+} CodecIntOption;
+
+static const CodecIntOption known_aom_int_options[] = {
+    { "sharpness", 0, 7 },
+    { "enable-chroma-deltaq", 0, 1 },
+    { "denoise-noise-level", 0, 50 },  // フィルムグレイン合成（0=無効）
+    { "enable-dnl-denoising", 0, 1 },
+    { "film-grain-test", 0, 16 },
+    { "enable-qm", 0, 1 },
+    { "qm-min", 0, 15 },
+    { "qm-max", 0, 15 },
+    { "aq-mode", 0, 3 },
+    { "deltaq-mode", 0, 5 },
+    { "cq-level", 0, 63 },
+    { "enable-tpl-model", 0, 1 },
+};
+
+static const char* const known_aom_tune_values[] = {
+    "psnr", "ssim", "iq", "ssimulacra2", "vmaf", "butteraugli", NULL
+};
+
+// スコーププレフィックス（color:/c:/alpha:/a:）を除いたオプション名を返す
+static const char* codec_option_name(const char* key) {
+    static const char* const prefixes[] = { "color:", "c:", "alpha:", "a:", NULL };
+    for (int i = 0; prefixes[i]; i++) {
+        size_t len = strlen(prefixes[i]);
+        if (strncmp(key, prefixes[i], len) == 0) {
+            return key + len;
+        }
+    }
+    return key;
+}
+
+static int parse_int_value(const char* value, int* out) {
+    char* end = NULL;
+    long v = strtol(value, &end, 10);
+    if (end == value || *end != '\0' || v < -2147483647L || v > 2147483647L) {
+        return 0;
+    }
+    *out = (int)v;
+    return 1;
+}
+
+static NextImageStatus validate_codec_options(const NextImageAVIFEncodeOptions* options) {
+    if (options->codec_option_count == 0) {
+        return NEXTIMAGE_OK;
+    }
+    if (options->codec_option_count < 0 || !options->codec_option_keys || !options->codec_option_values) {
+        nextimage_set_error("Invalid codec options: count=%d", options->codec_option_count);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    for (int i = 0; i < options->codec_option_count; i++) {
+        const char* key = options->codec_option_keys[i];
+        const char* value = options->codec_option_values[i];
+        if (!key || !value) {
+            nextimage_set_error("Invalid codec option %d: NULL key or value", i);
+            return NEXTIMAGE_ERROR_INVALID_PARAM;
+        }
+
+        const char* name = codec_option_name(key);
+        if (*name == '\0') {
+            nextimage_set_error("Invalid codec option \"%s\": empty name", key);
+            return NEXTIMAGE_ERROR_INVALID_PARAM;
+        }
+        for (const char* c = name; *c; c++) {
+            if (!((*c >= 'a' && *c <= 'z') || (*c >= '0' && *c <= '9') || *c == '-' || *c == '_')) {
+                nextimage_set_error("Invalid codec option \"%s\": unexpected character '%c'", key, *c);
+                return NEXTIMAGE_ERROR_INVALID_PARAM;
+            }
+        }
+        if (*value == '\0') {
+            nextimage_set_error("Invalid codec option \"%s\": empty value", key);
+            return NEXTIMAGE_ERROR_INVALID_PARAM;
+        }
+
+        if (strcmp(name, "tune") == 0) {
+            int found = 0;
+            for (int j = 0; known_aom_tune_values[j]; j++) {
+                if (strcmp(value, known_aom_tune_values[j]) == 0) {
+                    found = 1;
+                    break;
+                }
+            }
+            if (!found) {
+                nextimage_set_error("Invalid codec option \"%s\": unknown tune \"%s\"", key, value);
+                return NEXTIMAGE_ERROR_INVALID_PARAM;
+            }
+            continue;
+        }
+
+        for (size_t j = 0; j < sizeof(known_aom_int_options) / sizeof(known_aom_int_options[0]); j++) {
+            const CodecIntOption* opt = &known_aom_int_options[j];
+            if (strcmp(name, opt->name) != 0) {
+                continue;
+            }
+            int v;
+            if (!parse_int_value(value, &v) || v < opt->min || v > opt->max) {
+                nextimage_set_error("Invalid codec option \"%s\": \"%s\" (must be an integer %d-%d)",
+                                    key, value, opt->min, opt->max);
+                return NEXTIMAGE_ERROR_INVALID_PARAM;
+            }
+            break;
+        }
+    }
+    return NEXTIMAGE_OK;
+}
+
+static NextImageStatus apply_codec_options(avifEncoder* encoder, const NextImageAVIFEncodeOptions* options) {
+    for (int i = 0; i < options->codec_option_count; i++) {
+        avifResult result = avifEncoderSetCodecSpecificOption(encoder, options->codec_option_keys[i],
+                                                              options->codec_option_values[i]);
+        if (result != AVIF_RESULT_OK) {
+            nextimage_set_error("Failed to set codec option \"%s\": %s",
+                                options->codec_option_keys[i], avifResultToString(result));
+            return NEXTIMAGE_ERROR_INVALID_PARAM;
+        }
+    }
+    return NEXTIMAGE_OK;
+}
+
+// AV1エンコーダーに渡る量子化範囲を推定する（libavifは公開していないため、
+// avifQualityToQuantizer()の計算式を写している）
+// qualityが指定されていれば単一の値、未指定(AVIF_QUALITY_DEFAULT)なら非推奨のmin/max量子化値
+static void estimate_quantizer_range(int quality, int min_quantizer, int max_quantizer, int* min_out, int* max_out) {
+    if (quality == AVIF_QUALITY_DEFAULT) {
+        *min_out = (min_quantizer < 0) ? 0 : (min_quantizer > 63 ? 63 : min_quantizer);
+        *max_out = (max_quantizer < 0) ? 0 : (max_quantizer > 63 ? 63 : max_quantizer);
+        return;
+    }
+    quality = (quality < 0) ? 0 : (quality > 100 ? 100 : quality);
+    *min_out = *max_out = ((100 - quality) * 63 + 50) / 100;
+}
+
+// libavifの自動タイリングによる分割を推定する（libavifは公開していないため、
+// avifSetTileConfiguration()の計算式を写している）
+// 512x512未満のタイルは作らず、タイル数はスレッド数と32を上限に2の累乗へ切り下げる
+static void estimate_auto_tiling(int threads, uint32_t width, uint32_t height,
+                                 int* tile_rows_log2, int* tile_cols_log2) {
+    *tile_rows_log2 = 0;
+    *tile_cols_log2 = 0;
+    if (threads <= 1 || width == 0 || height == 0) {
+        return;
+    }
+
+    const uint64_t min_tile_area = 512 * 512;
+    uint64_t tiles = ((uint64_t)width * height + min_tile_area - 1) / min_tile_area;
+    if (tiles > 32) {
+        tiles = 32;
+    }
+    if (tiles > (uint64_t)threads) {
+        tiles = (uint64_t)threads;
+    }
+    int tiles_log2 = 0;
+    while (tiles > 1) {
+        tiles /= 2;
+        tiles_log2++;
+    }
+
+    // 長辺方向に多くタイルを割り当て、タイル形状を正方形に近づける
+    uint32_t long_side = (width >= height) ? width : height;
+    uint32_t short_side = (width >= height) ? height : width;
+    uint32_t ratio = long_side / short_side;
+    int diff_log2 = 0;
+    while (ratio > 1) {
+        ratio /= 2;
+        diff_log2++;
+    }
+    int subtract = tiles_log2 - diff_log2;
+    if (subtract < 0) {
+        subtract = 0;
+    }
+    int short_log2 = subtract / 2;
+    int long_log2 = tiles_log2 - short_log2;
+    if (width >= height) {
+        *tile_cols_log2 = long_log2;
+        *tile_rows_log2 = short_log2;
+    } else {
+        *tile_rows_log2 = long_log2;
+        *tile_cols_log2 = short_log2;
+    }
+}
+
+// エンコード完了後のエンコーダー状態から統計情報を作成
+// 量子化範囲とタイル構成はエンコーダーの設定（プログレッシブは最終レイヤー）から推定し、
+// OBUサイズはlibavifのioStatsから求める
+static void fill_encode_stats(const avifEncoder* encoder, const avifImage* image,
+                              const NextImageAVIFEncodeOptions* options,
+                              NextImageAVIFEncodeStats* stats) {
+    memset(stats, 0, sizeof(NextImageAVIFEncodeStats));
+
+    estimate_quantizer_range(encoder->quality, encoder->minQuantizer, encoder->maxQuantizer,
+                             &stats->estimated_min_quantizer, &stats->estimated_max_quantizer);
+    stats->color_obu_size = encoder->ioStats.colorOBUSize;
+    stats->alpha_obu_size = encoder->ioStats.alphaOBUSize;
+    if (stats->alpha_obu_size > 0) {
+        estimate_quantizer_range(encoder->qualityAlpha, encoder->minQuantizerAlpha, encoder->maxQuantizerAlpha,
+                                 &stats->estimated_min_quantizer_alpha, &stats->estimated_max_quantizer_alpha);
+    } else {
+        stats->estimated_min_quantizer_alpha = -1;
+        stats->estimated_max_quantizer_alpha = -1;
+    }
+
+    int grid = (options->grid_cols > 0 && options->grid_rows > 0);
+    stats->grid_cols = grid ? options->grid_cols : 1;
+    stats->grid_rows = grid ? options->grid_rows : 1;
+    stats->layer_count = (options->progressive_layers > 1) ? options->progressive_layers : 1;
+
+    if (encoder->autoTiling) {
+        estimate_auto_tiling(encoder->maxThreads,
+                             image->width / (uint32_t)stats->grid_cols,
+                             image->height / (uint32_t)stats->grid_rows,
+                             &stats->estimated_tile_rows_log2, &stats->estimated_tile_cols_log2);
+    } else {
+        stats->estimated_tile_rows_log2 = encoder->tileRowsLog2;
+        stats->estimated_tile_cols_log2 = encoder->tileColsLog2;
+    }
+}
+
+// ライターへ渡す1回あたりの最大サイズ
+#define AVIF_WRITER_CHUNK_SIZE (1024 * 1024)
+
+// エンコード結果をチャンクに分けてライターへ渡す（中断された場合は0を返す）
+static int write_chunks(const NextImageWriter* writer, const uint8_t* data, size_t size) {
+    while (size > 0) {
+        size_t n = size < AVIF_WRITER_CHUNK_SIZE ? size : AVIF_WRITER_CHUNK_SIZE;
+        if (!writer->write(data, n, writer->user_data)) {
+            return 0;
+        }
+        data += n;
+        size -= n;
+    }
+    return 1;
+}
+
+// YUV変換済みのavifImageをエンコード（statsがNULLでなければ統計情報も格納する）
+// writerがNULLでなければoutputは使わず、エンコード結果をwriterへ渡す
+// imageは成功・失敗にかかわらず解放される
+static NextImageStatus encode_avif_image(
+    avifImage* image,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output,
+    const NextImageWriter* writer,
+    NextImageAVIFEncodeStats* stats
+) {
+    NextImageStatus status = validate_grid(image, options);
+    if (status == NEXTIMAGE_OK) {
+        status = validate_progressive(options);
+    }
+    if (status == NEXTIMAGE_OK) {
+        status = validate_codec_options(options);
+    }
+    if (status != NEXTIMAGE_OK) {
+        avifImageDestroy(image);
+        return status;
+    }
+    avifResult result;
+
+    // Set metadata (EXIF, XMP, ICC) if provided
+    if (options->exif_data && options->exif_size > 0) {
+        result = avifImageSetMetadataExif(image, options->exif_data, options->exif_size);
+        if (result != AVIF_RESULT_OK) {
+            avifImageDestroy(image);
+            nextimage_set_error("Failed to set EXIF metadata: %s", avifResultToString(result));
+            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        }
+    }
+
+    if (options->xmp_data && options->xmp_size > 0) {
+        result = avifImageSetMetadataXMP(image, options->xmp_data, options->xmp_size);
+        if (result != AVIF_RESULT_OK) {
+            avifImageDestroy(image);
+            nextimage_set_error("Failed to set XMP metadata: %s", avifResultToString(result));
+            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        }
+    }
+
+    if (options->icc_data && options->icc_size > 0) {
+        result = avifImageSetProfileICC(image, options->icc_data, options->icc_size);
+        if (result != AVIF_RESULT_OK) {
+            avifImageDestroy(image);
+            nextimage_set_error("Failed to set ICC profile: %s", avifResultToString(result));
+            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        }
+    }
+
+    // Set transformation properties
+    // Note: transformFlags must be set to enable encoding of these properties
+    image->transformFlags = 0;
+
+    // Image rotation (irot)
+    if (options->irot_angle >= 0 && options->irot_angle <= 3) {
+        image->irot.angle = (uint8_t)options->irot_angle;
+        image->transformFlags |= AVIF_TRANSFORM_IROT;
+    }
+
+    // Image mirror (imir)
+    if (options->imir_axis >= 0 && options->imir_axis <= 1) {
+        image->imir.axis = (uint8_t)options->imir_axis;
+        image->transformFlags |= AVIF_TRANSFORM_IMIR;
+    }
+
+    // Pixel aspect ratio (pasp)
+    if (options->pasp[0] >= 0 && options->pasp[1] >= 0) {
+        image->pasp.hSpacing = (uint32_t)options->pasp[0];
+        image->pasp.vSpacing = (uint32_t)options->pasp[1];
+        image->transformFlags |= AVIF_TRANSFORM_PASP;
+    }
+
+    // Crop rectangle - convert to clap
+    // 矩形はデコード後の実寸で検査する（ヘッダーを読めない入力も含む）
+    if (options->crop[0] >= 0) {
+        if (options->crop[1] < 0 || options->crop[2] <= 0 || options->crop[3] <= 0 ||
+            (int64_t)options->crop[0] + options->crop[2] > image->width ||
+            (int64_t)options->crop[1] + options->crop[3] > image->height) {
+            nextimage_set_error("crop rectangle %d,%d %dx%d is outside the %ux%u image",
+                                options->crop[0], options->crop[1], options->crop[2], options->crop[3],
+                                image->width, image->height);
+            nextimage_set_error_image_size((int)image->width, (int)image->height);
+            avifImageDestroy(image);
+            return NEXTIMAGE_ERROR_CROP_OUTSIDE_IMAGE;
+        }
+        avifCropRect cropRect;
+        cropRect.x = (uint32_t)options->crop[0];
+        cropRect.y = (uint32_t)options->crop[1];
+        cropRect.width = (uint32_t)options->crop[2];
+        cropRect.height = (uint32_t)options->crop[3];
+
+        avifDiagnostics diag;
+        avifDiagnosticsClearError(&diag);
+
+        avifBool convertResult = avifCleanApertureBoxFromCropRect(
+            &image->clap,
+            &cropRect,
+            image->width,
+            image->height,
+            &diag
+        );
+
+        if (!convertResult) {
+            avifImageDestroy(image);
+            nextimage_set_error("Failed to convert crop rect to clap: %s", diag.error);
+            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        }
+        image->transformFlags |= AVIF_TRANSFORM_CLAP;
+    }
+    // Clean aperture (clap) - direct values
+    else if (options->clap[0] >= 0) {
+        image->clap.widthN = (uint32_t)options->clap[0];
+        image->clap.widthD = (uint32_t)options->clap[1];
+        image->clap.heightN = (uint32_t)options->clap[2];
+        image->clap.heightD = (uint32_t)options->clap[3];
+        image->clap.horizOffN = (uint32_t)options->clap[4];
+        image->clap.horizOffD = (uint32_t)options->clap[5];
+        image->clap.vertOffN = (uint32_t)options->clap[6];
+        image->clap.vertOffD = (uint32_t)options->clap[7];
+        image->transformFlags |= AVIF_TRANSFORM_CLAP;
+    }
+
+    // Content light level information (clli)
+    if (options->clli_max_cll >= 0 || options->clli_max_pall >= 0) {
+        image->clli.maxCLL = (options->clli_max_cll >= 0) ? (uint16_t)options->clli_max_cll : 0;
+        image->clli.maxPALL = (options->clli_max_pall >= 0) ? (uint16_t)options->clli_max_pall : 0;
+    }
+
+    // Create encoder
+    avifEncoder* encoder = avifEncoderCreate();
+    if (!encoder) {
+        avifImageDestroy(image);
+        nextimage_set_error("Failed to create AVIF encoder");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    // Set encoder options
+    encoder->speed = options->speed;
+
+    // Set maxThreads to match avifenc default (CPU count for -j all)
+    // avifenc v1.3.0 uses avifQueryCPUCount() when jobs == -1 (default)
+    encoder->maxThreads = (options->jobs > 0) ? options->jobs : queryCPUCount();
+
+    // Quality settings (color/YUV)
+    if (options->min_quantizer >= 0 && options->max_quantizer >= 0) {
+        // Deprecated: use quantizers directly for backward compatibility
+        encoder->minQuantizer = options->min_quantizer;
+        encoder->maxQuantizer = options->max_quantizer;
+    } else {
+        // Use quality setting (0-100) - this is the recommended approach
+        encoder->quality = options->quality;
+    }
+
+    // Quality settings (alpha)
+    int quality_alpha = (options->quality_alpha >= 0) ? options->quality_alpha : options->quality;
+    if (options->min_quantizer_alpha >= 0 && options->max_quantizer_alpha >= 0) {
+        // Deprecated: use quantizers directly for backward compatibility
+        encoder->minQuantizerAlpha = options->min_quantizer_alpha;
+        encoder->maxQuantizerAlpha = options->max_quantizer_alpha;
+    } else {
+        // Use qualityAlpha setting (0-100)
+        encoder->qualityAlpha = quality_alpha;
+    }
+
+    // Tiling settings
+    encoder->tileRowsLog2 = options->tile_rows_log2;
+    encoder->tileColsLog2 = options->tile_cols_log2;
+
+    // avifenc v1.3.0 starts in automatic tiling mode by default (commit 1894a21d, 2025-04-11)
+    // This automatically determines optimal tile configuration based on image dimensions
+    // Disable autoTiling if manual tiling is specified
+    if (options->tile_rows_log2 > 0 || options->tile_cols_log2 > 0) {
+        encoder->autoTiling = AVIF_FALSE;
+    } else {
+        encoder->autoTiling = AVIF_TRUE;
+    }
+
+    // Animation settings (for future use)
+    if (options->timescale > 0) {
+        encoder->timescale = options->timescale;
+    }
+    if (options->keyframe_interval > 0) {
+        encoder->keyframeInterval = options->keyframe_interval;
+    }
+
+    // Gain map quality (used only when the image carries a gain map)
+    if (image->gainMap) {
+        encoder->qualityGainMap = options->gain_map_quality;
+    }
+
+    // Codec-specific options (avifenc -a)
+    status = apply_codec_options(encoder, options);
+    if (status != NEXTIMAGE_OK) {
+        avifEncoderDestroy(encoder);
+        avifImageDestroy(image);
+        return status;
+    }
+
+    // Encode using avifEncoderAddImage + avifEncoderFinish
+    // (matching avifenc.c implementation, lines 1244-1287)
+    if (options->grid_cols > 0 && options->grid_rows > 0) {
+        result = add_image_grid(encoder, image, options->grid_cols, options->grid_rows);
+    } else if (options->progressive_layers > 1) {
+        // Layered encoding: the same image is added once per layer, from coarsest to final quality
+        encoder->extraLayerCount = (uint32_t)(options->progressive_layers - 1);
+        result = AVIF_RESULT_OK;
+        for (int layer = 0; layer < options->progressive_layers && result == AVIF_RESULT_OK; layer++) {
+            encoder->quality = progressive_layer_quality(options, layer, options->quality);
+            encoder->qualityAlpha = progressive_layer_quality(options, layer, quality_alpha);
+            result = avifEncoderAddImage(encoder, image, 1, AVIF_ADD_IMAGE_FLAG_NONE);
+        }
+    } else {
+        result = avifEncoderAddImage(encoder, image, 1, AVIF_ADD_IMAGE_FLAG_SINGLE);
+    }
+    if (result == AVIF_RESULT_INVALID_CODEC_SPECIFIC_OPTION) {
+        // libaomが受け付けないキーまたは値
+        nextimage_set_error("Invalid codec option: %s", encoder->diag.error);
+        avifEncoderDestroy(encoder);
+        avifImageDestroy(image);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (result != AVIF_RESULT_OK) {
+        avifEncoderDestroy(encoder);
+        avifImageDestroy(image);
+        nextimage_set_error("AVIF add image failed: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    avifRWData raw = AVIF_DATA_EMPTY;
+    result = avifEncoderFinish(encoder, &raw);
+    if (result != AVIF_RESULT_OK) {
+        avifEncoderDestroy(encoder);
+        avifImageDestroy(image);
+        nextimage_set_error("AVIF encoding failed: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    if (writer) {
+        // コピーせずlibavifのバッファから直接ライターへ渡す
+        if (!write_chunks(writer, raw.data, raw.size)) {
+            avifRWDataFree(&raw);
+            avifEncoderDestroy(encoder);
+            avifImageDestroy(image);
+            nextimage_set_error("AVIF encoding failed: output writer aborted");
+            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        }
+    } else {
+        // Copy output data using our tracked allocation
+        output->data = nextimage_malloc(raw.size);
+        if (!output->data) {
+            avifRWDataFree(&raw);
+            avifEncoderDestroy(encoder);
+            avifImageDestroy(image);
+            nextimage_set_error("Failed to allocate output buffer");
+            return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        }
+
+        memcpy(output->data, raw.data, raw.size);
+        output->size = raw.size;
+    }
+
+    if (stats) {
+        fill_encode_stats(encoder, image, options, stats);
+    }
+
+    // Cleanup
+    avifRWDataFree(&raw);
+    avifEncoderDestroy(encoder);
+    avifImageDestroy(image);
+
+    return NEXTIMAGE_OK;
+}
+
+// 画像ファイルからのエンコード実装（statsがNULLでなければ統計情報も格納する）
+static NextImageStatus avif_encode(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output,
+    const NextImageWriter* writer,
+    NextImageAVIFEncodeStats* stats
+) {
+    if (!input_data || input_size == 0 || (!output && !(writer && writer->write))) {
+        nextimage_set_error("Invalid parameters: NULL input or output");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    if (output) {
+        memset(output, 0, sizeof(NextImageBuffer));
+    }
+
+    // デフォルトオプション
+    NextImageAVIFEncodeOptions default_opts;
+    if (!options) {
+        nextimage_avif_default_encode_options(&default_opts);
+        options = &default_opts;
+    }
+
+    // 入力画像を読み込んでavifImageを作成
+    avifImage* image = NULL;
+    NextImageStatus status = create_avif_base_image(input_data, input_size, options, &image);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+
+    return encode_avif_image(image, options, output, writer, stats);
+}
+
+// エンコード実装（画像ファイルデータから）
+NextImageStatus nextimage_avif_encode_alloc(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output
+) {
+    return avif_encode(input_data, input_size, options, output, NULL, NULL);
+}
+
+// エンコード（統計情報付き）
+NextImageStatus nextimage_avif_encode_alloc_with_stats(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output,
+    NextImageAVIFEncodeStats* stats
+) {
+    return avif_encode(input_data, input_size, options, output, NULL, stats);
+}
+
+// エンコード（ライターへ逐次出力、statsはNULL可）
+NextImageStatus nextimage_avif_encode_to_writer(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageAVIFEncodeOptions* options,
+    const NextImageWriter* writer,
+    NextImageAVIFEncodeStats* stats
+) {
+    if (!writer || !writer->write) {
+        nextimage_set_error("Invalid parameters: NULL writer");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    return avif_encode(input_data, input_size, options, NULL, writer, stats);
+}
+
+// デコード済みRGBAピクセルからエンコード
+NextImageStatus nextimage_avif_encode_rgba_alloc(
+    const uint8_t* rgba,
+    int width,
+    int height,
+    int stride,
+    const NextImageAVIFEncodeOptions* options,
+    NextImageBuffer* output
+) {
+    if (!rgba || !output || width <= 0 || height <= 0 || stride < width * 4) {
+        nextimage_set_error("Invalid parameters");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    memset(output, 0, sizeof(NextImageBuffer));
+
+    NextImageAVIFEncodeOptions default_opts;
+    if (!options) {
+        nextimage_avif_default_encode_options(&default_opts);
+        options = &default_opts;
+    }
+
+    // ゲインマップはHDR入力ファイルが必要なため画素入力では扱わない
+    if (options->gain_map_mode != NEXTIMAGE_GAIN_MAP_NONE) {
+        nextimage_set_error("Gain map encoding requires image file input");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    NextImageStatus status = validate_bit_depth(options);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+
+    avifImage* image = NULL;
+    status = create_avif_image_from_rgba(rgba, (uint32_t)stride, (uint32_t)width, (uint32_t)height, 8,
+                                         resolve_bit_depth(options->bit_depth, NULL), options, &image);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+
+    return encode_avif_image(image, options, output, NULL, NULL);
+}
+
+// ========================================
+// HDR -> SDR トーンマッピング
+// ========================================
+// PQ/HLGの画像を16-bit RGBで展開し、表示輝度(nits)へ線形化した後、
+// BT.2020→sRGB(BT.709)の色域変換とトーンマッピングを行って8-bitへ量子化する
+
+#define PQ_M1 0.1593017578125
+#define PQ_M2 78.84375
+#define PQ_C1 0.8359375
+#define PQ_C2 18.8515625
+#define PQ_C3 18.6875
+
+#define DEFAULT_HDR_PEAK_NITS 1000.0
+#define DEFAULT_SDR_WHITE_NITS 203.0
+
+// PQ信号値(0-1) → 輝度(nits)
+static double pq_to_nits(double e) {
+    if (e <= 0.0) return 0.0;
+    const double p = pow(e, 1.0 / PQ_M2);
+    const double num = p - PQ_C1 > 0.0 ? p - PQ_C1 : 0.0;
+    const double den = PQ_C2 - PQ_C3 * p;
+    if (den <= 0.0) return 10000.0;
+    return 10000.0 * pow(num / den, 1.0 / PQ_M1);
+}
+
+// 輝度(nits) → PQ信号値(0-1)
+static double nits_to_pq(double nits) {
+    if (nits <= 0.0) return 0.0;
+    const double y = pow(nits / 10000.0, PQ_M1);
+    return pow((PQ_C1 + PQ_C2 * y) / (1.0 + PQ_C3 * y), PQ_M2);
+}
+
+// HLG信号値(0-1) → シーン線形(0-1)（BT.2100 逆OETF）
+static double hlg_to_scene_linear(double e) {
+    const double a = 0.17883277;
+    const double b = 0.28466892;
+    const double c = 0.55991073;
+    if (e <= 0.0) return 0.0;
+    if (e <= 0.5) return (e * e) / 3.0;
+    return (exp((e - c) / a) + b) / 12.0;
+}
+
+static double srgb_oetf(double v) {
+    if (v <= 0.0031308) return 12.92 * v;
+    return 1.055 * pow(v, 1.0 / 2.4) - 0.055;
+}
+
+// Hable (Uncharted 2) フィルミックカーブ
+static double hable_curve(double x) {
+    const double A = 0.15, B = 0.50, C = 0.10, D = 0.20, E = 0.02, F = 0.30;
+    return ((x * (A * x + C * B) + D * E) / (x * (A * x + B) + D * F)) - E / F;
+}
+
+typedef struct {
+    int op;
+    double source_peak;   // nits
+    double target_nits;   // nits (SDR 1.0)
+    // BT.2390 EETF
+    double pq_source_peak;
+    double max_lum;       // 正規化されたターゲットピーク（PQ領域）
+    double ks;            // ニー開始点
+    // Hable
+    double hable_white;
+} ToneMapper;
+
+static void tone_mapper_init(ToneMapper* tm, int op, double source_peak, double target_nits) {
+    memset(tm, 0, sizeof(ToneMapper));
+    tm->op = op;
+    tm->source_peak = source_peak;
+    tm->target_nits = target_nits;
+    tm->pq_source_peak = nits_to_pq(source_peak);
+    tm->max_lum = nits_to_pq(target_nits) / tm->pq_source_peak;
+    tm->ks = 1.5 * tm->max_lum - 0.5;
+    tm->hable_white = hable_curve(2.0 * source_peak / target_nits);
+}
+
+// 輝度(nits) → SDR相対値(0-1, 1.0=target_nits)
+static double tone_map_nits(const ToneMapper* tm, double nits) {
+    if (nits <= 0.0) return 0.0;
+    switch (tm->op) {
+        case NEXTIMAGE_TONE_MAPPING_BT2390: {
+            // ITU-R BT.2390 EETF (ソース最小輝度0を仮定)
+            double e1 = nits_to_pq(nits) / tm->pq_source_peak;
+            if (e1 > 1.0) e1 = 1.0;
+            double e2 = e1;
+            if (tm->ks < 1.0 && e1 >= tm->ks) {
+                const double t = (e1 - tm->ks) / (1.0 - tm->ks);
+                const double t2 = t * t;
+                const double t3 = t2 * t;
+                e2 = (2.0 * t3 - 3.0 * t2 + 1.0) * tm->ks +
+                     (t3 - 2.0 * t2 + t) * (1.0 - tm->ks) +
+                     (-2.0 * t3 + 3.0 * t2) * tm->max_lum;
+            }
+            return pq_to_nits(e2 * tm->pq_source_peak) / tm->target_nits;
         }
-        image->transformFlags |= AVIF_TRANSFORM_CLAP;
+        case NEXTIMAGE_TONE_MAPPING_REINHARD: {
+            // 拡張Reinhard: ソースピークがちょうど1.0になる
+            const double x = nits / tm->target_nits;
+            const double w = tm->source_peak / tm->target_nits;
+            return x * (1.0 + x / (w * w)) / (1.0 + x);
+        }
+        case NEXTIMAGE_TONE_MAPPING_HABLE: {
+            const double x = nits / tm->target_nits;
+            return hable_curve(2.0 * x) / tm->hable_white;
+        }
+        default:
+            return nits / tm->target_nits;
     }
-    // Clean aperture (clap) - direct values
-    else if (options->clap[0] >= 0) {
-        image->clap.widthN = (uint32_t)options->clap[0];
-        image->clap.widthD = (uint32_t)options->clap[1];
-        image->clap.heightN = (uint32_t)options->clap[2];
-        image->clap.heightD = (uint32_t)options->clap[3];
-        image->clap.horizOffN = (uint32_t)options->clap[4];
-        image->clap.horizOffD = (uint32_t)options->clap[5];
-        image->clap.vertOffN = (uint32_t)options->clap[6];
-        image->clap.vertOffD = (uint32_t)options->clap[7];
-        image->transformFlags |= AVIF_TRANSFORM_CLAP;
+}
+
+static uint8_t to_u8(double v) {
+    if (v <= 0.0) return 0;
+    if (v >= 1.0) return 255;
+    return (uint8_t)(v * 255.0 + 0.5);
+}
+
+// HDR(PQ/HLG)画像かどうか
+static int is_hdr_transfer(avifTransferCharacteristics tc) {
+    return tc == AVIF_TRANSFER_CHARACTERISTICS_PQ || tc == AVIF_TRANSFER_CHARACTERISTICS_HLG;
+}
+
+// HDR画像をトーンマッピングして8-bitのrgb（確保済み、format設定済み）へ書き込む
+// mastering_peakはmdcvの最大マスタリング輝度（nits、不明なら0）
+static NextImageStatus tone_map_hdr_to_rgb8(
+    const avifImage* image,
+    double mastering_peak,
+    const NextImageAVIFDecodeOptions* options,
+    avifRGBImage* out
+) {
+    // 16-bit RGBAで展開（信号値のまま）
+    avifRGBImage rgb16;
+    memset(&rgb16, 0, sizeof(avifRGBImage));
+    avifRGBImageSetDefaults(&rgb16, image);
+    rgb16.format = AVIF_RGB_FORMAT_RGBA;
+    rgb16.depth = 16;
+    rgb16.chromaUpsampling = (avifChromaUpsampling)options->chroma_upsampling;
+
+    avifResult result = avifRGBImageAllocatePixels(&rgb16);
+    if (result != AVIF_RESULT_OK) {
+        nextimage_set_error("Failed to allocate 16-bit RGB buffer: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+    result = avifImageYUVToRGB(image, &rgb16);
+    if (result != AVIF_RESULT_OK) {
+        avifRGBImageFreePixels(&rgb16);
+        nextimage_set_error("Failed to convert YUV to RGB: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
     }
 
-    // Content light level information (clli)
-    if (options->clli_max_cll >= 0 || options->clli_max_pall >= 0) {
-        image->clli.maxCLL = (options->clli_max_cll >= 0) ? (uint16_t)options->clli_max_cll : 0;
-        image->clli.maxPALL = (options->clli_max_pall >= 0) ? (uint16_t)options->clli_max_pall : 0;
+    // ソースピーク輝度: 明示指定 > CLLI maxCLL > mdcv最大マスタリング輝度 > 1000 nits
+    double source_peak = DEFAULT_HDR_PEAK_NITS;
+    if (options->tone_mapping_source_peak > 0) {
+        source_peak = options->tone_mapping_source_peak;
+    } else if (image->clli.maxCLL > 0) {
+        source_peak = image->clli.maxCLL;
+    } else if (mastering_peak > 0.0) {
+        source_peak = mastering_peak;
+    }
+    double target_nits = options->tone_mapping_target_nits > 0
+        ? options->tone_mapping_target_nits : DEFAULT_SDR_WHITE_NITS;
+    if (source_peak < target_nits) {
+        source_peak = target_nits;
     }
 
-    // Create encoder
-    avifEncoder* encoder = avifEncoderCreate();
-    if (!encoder) {
-        avifImageDestroy(image);
-        nextimage_set_error("Failed to create AVIF encoder");
+    ToneMapper tm;
+    tone_mapper_init(&tm, options->tone_mapping, source_peak, target_nits);
+
+    const int is_hlg = (image->transferCharacteristics == AVIF_TRANSFER_CHARACTERISTICS_HLG);
+    const int is_bt2020 = (image->colorPrimaries == AVIF_COLOR_PRIMARIES_BT2020);
+
+    // 信号値 → 線形値のLUT（PQはnits、HLGはシーン線形）
+    double* lut = (double*)nextimage_malloc(sizeof(double) * 65536);
+    if (!lut) {
+        avifRGBImageFreePixels(&rgb16);
+        nextimage_set_error("Failed to allocate tone mapping LUT");
         return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
+    for (int i = 0; i < 65536; i++) {
+        const double e = i / 65535.0;
+        lut[i] = is_hlg ? hlg_to_scene_linear(e) : pq_to_nits(e);
+    }
+
+    // HLG OOTF: システムガンマはディスプレイピーク輝度から決定（BT.2100）
+    const double hlg_gamma = 1.2 + 0.42 * log10(source_peak / 1000.0);
+
+    // 出力チャンネル配置
+    int ch_r = 0, ch_g = 1, ch_b = 2, ch_a = 3, out_channels = 4;
+    if (out->format == AVIF_RGB_FORMAT_BGRA) {
+        ch_r = 2; ch_b = 0;
+    } else if (out->format == AVIF_RGB_FORMAT_RGB) {
+        ch_a = -1; out_channels = 3;
+    }
+
+    for (uint32_t y = 0; y < rgb16.height; y++) {
+        const uint16_t* src = (const uint16_t*)(rgb16.pixels + (size_t)y * rgb16.rowBytes);
+        uint8_t* dst = out->pixels + (size_t)y * out->rowBytes;
+        for (uint32_t x = 0; x < rgb16.width; x++) {
+            double r = lut[src[x * 4 + 0]];
+            double g = lut[src[x * 4 + 1]];
+            double b = lut[src[x * 4 + 2]];
+
+            if (is_hlg) {
+                // シーン線形 → 表示輝度（OOTF、BT.2020輝度係数）
+                const double ys = 0.2627 * r + 0.6780 * g + 0.0593 * b;
+                const double scale = ys > 0.0 ? source_peak * pow(ys, hlg_gamma - 1.0) : 0.0;
+                r *= scale;
+                g *= scale;
+                b *= scale;
+            }
+
+            if (is_bt2020) {
+                // 線形BT.2020 → 線形BT.709
+                const double r709 =  1.6605 * r - 0.5876 * g - 0.0728 * b;
+                const double g709 = -0.1246 * r + 1.1329 * g - 0.0083 * b;
+                const double b709 = -0.0182 * r - 0.1006 * g + 1.1187 * b;
+                r = r709; g = g709; b = b709;
+
+                // 色域外（負値）は輝度を保ったまま彩度を落として収める
+                double mn = r < g ? (r < b ? r : b) : (g < b ? g : b);
+                if (mn < 0.0) {
+                    const double lum = 0.2126 * r + 0.7152 * g + 0.0722 * b;
+                    if (lum <= 0.0) {
+                        r = g = b = 0.0;
+                    } else {
+                        const double t = lum / (lum - mn);
+                        r = lum + t * (r - lum);
+                        g = lum + t * (g - lum);
+                        b = lum + t * (b - lum);
+                    }
+                }
+            }
+
+            // 色相を保つためにRGBの最大値でトーンマッピングし、比率を掛ける
+            double mx = r > g ? (r > b ? r : b) : (g > b ? g : b);
+            double ratio = 0.0;
+            if (mx > 0.0) {
+                ratio = tone_map_nits(&tm, mx) / mx;
+            }
+
+            dst[x * out_channels + ch_r] = to_u8(srgb_oetf(r * ratio));
+            dst[x * out_channels + ch_g] = to_u8(srgb_oetf(g * ratio));
+            dst[x * out_channels + ch_b] = to_u8(srgb_oetf(b * ratio));
+            if (ch_a >= 0) {
+                dst[x * out_channels + ch_a] = (uint8_t)((src[x * 4 + 3] * 255u + 32767u) / 65535u);
+            }
+        }
+    }
 
-    // Set encoder options
-    encoder->speed = options->speed;
-
-    // Set maxThreads to match avifenc default (CPU count for -j all)
-    // avifenc v1.3.0 uses avifQueryCPUCount() when jobs == -1 (default)
-    encoder->maxThreads = queryCPUCount();
+    nextimage_free(lut);
+    avifRGBImageFreePixels(&rgb16);
+    return NEXTIMAGE_OK;
+}
 
-    // Quality settings (color/YUV)
-    if (options->min_quantizer >= 0 && options->max_quantizer >= 0) {
-        // Deprecated: use quantizers directly for backward compatibility
-        encoder->minQuantizer = options->min_quantizer;
-        encoder->maxQuantizer = options->max_quantizer;
-    } else {
-        // Use quality setting (0-100) - this is the recommended approach
-        encoder->quality = options->quality;
+// デコードオプションの検証
+static NextImageStatus validate_decode_options(const NextImageAVIFDecodeOptions* options) {
+    if (options->tone_mapping < NEXTIMAGE_TONE_MAPPING_NONE ||
+        options->tone_mapping > NEXTIMAGE_TONE_MAPPING_HABLE) {
+        nextimage_set_error("Invalid tone mapping operator: %d", options->tone_mapping);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
     }
 
-    // Quality settings (alpha)
-    int quality_alpha = (options->quality_alpha >= 0) ? options->quality_alpha : options->quality;
-    if (options->min_quantizer_alpha >= 0 && options->max_quantizer_alpha >= 0) {
-        // Deprecated: use quantizers directly for backward compatibility
-        encoder->minQuantizerAlpha = options->min_quantizer_alpha;
-        encoder->maxQuantizerAlpha = options->max_quantizer_alpha;
-    } else {
-        // Use qualityAlpha setting (0-100)
-        encoder->qualityAlpha = quality_alpha;
+    if (options->gain_map_output < NEXTIMAGE_GAIN_MAP_OUTPUT_BASE ||
+        options->gain_map_output > NEXTIMAGE_GAIN_MAP_OUTPUT_RENDITION) {
+        nextimage_set_error("Invalid gain map output: %d", options->gain_map_output);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
     }
 
-    // Tiling settings
-    encoder->tileRowsLog2 = options->tile_rows_log2;
-    encoder->tileColsLog2 = options->tile_cols_log2;
+    return NEXTIMAGE_OK;
+}
 
-    // avifenc v1.3.0 starts in automatic tiling mode by default (commit 1894a21d, 2025-04-11)
-    // This automatically determines optimal tile configuration based on image dimensions
-    // Disable autoTiling if manual tiling is specified
-    if (options->tile_rows_log2 > 0 || options->tile_cols_log2 > 0) {
-        encoder->autoTiling = AVIF_FALSE;
+// デコーダーにオプションを設定する
+static void configure_decoder(avifDecoder* decoder, const NextImageAVIFDecodeOptions* options) {
+    // Set decoder options
+    decoder->ignoreExif = options->ignore_exif ? AVIF_TRUE : AVIF_FALSE;
+    decoder->ignoreXMP = options->ignore_xmp ? AVIF_TRUE : AVIF_FALSE;
+    // Note: libavif doesn't support ignoreColorProfile, so we'll handle ignore_icc after decoding
+
+    // Set security limits
+    decoder->imageSizeLimit = options->image_size_limit;
+    decoder->imageDimensionLimit = options->image_dimension_limit;
+
+    // Set strict validation flags
+    if (options->strict_flags == 0) {
+        decoder->strictFlags = AVIF_STRICT_DISABLED;
     } else {
-        encoder->autoTiling = AVIF_TRUE;
+        decoder->strictFlags = AVIF_STRICT_ENABLED;
     }
 
-    // Animation settings (for future use)
-    if (options->timescale > 0) {
-        encoder->timescale = options->timescale;
+    // Decode the gain map too when it is requested
+    if (options->gain_map_output != NEXTIMAGE_GAIN_MAP_OUTPUT_BASE) {
+        decoder->imageContentToDecode = AVIF_IMAGE_CONTENT_COLOR_AND_ALPHA | AVIF_IMAGE_CONTENT_GAIN_MAP;
     }
-    if (options->keyframe_interval > 0) {
-        encoder->keyframeInterval = options->keyframe_interval;
+}
+
+// デコード済みのavifImageをオプションに従ってRGB出力バッファへ変換する
+static NextImageStatus convert_decoded_image(
+    const avifImage* image,
+    double mastering_peak,
+    const NextImageAVIFDecodeOptions* options,
+    NextImageDecodeBuffer* output
+) {
+    memset(output, 0, sizeof(NextImageDecodeBuffer));
+
+    // Select the image to output (base image, or gain map image)
+    const avifImage* source = image;
+    int output_depth = 8;
+    if (options->gain_map_output != NEXTIMAGE_GAIN_MAP_OUTPUT_BASE) {
+        if (!image->gainMap || !image->gainMap->image) {
+            nextimage_set_error("AVIF image has no gain map");
+            return NEXTIMAGE_ERROR_UNSUPPORTED;
+        }
+        if (options->gain_map_output == NEXTIMAGE_GAIN_MAP_OUTPUT_IMAGE) {
+            source = image->gainMap->image;
+        } else if (options->gain_map_headroom > 0.0f) {
+            // HDR rendition is written as 16-bit PQ
+            output_depth = 16;
+        }
     }
 
-    // Encode using avifEncoderAddImage + avifEncoderFinish
-    // (matching avifenc.c implementation, lines 1244-1287)
-    result = avifEncoderAddImage(encoder, image, 1, AVIF_ADD_IMAGE_FLAG_SINGLE);
+    // Setup RGB output
+    avifRGBImage rgb;
+    avifRGBImageSetDefaults(&rgb, source);
+    rgb.format = pixel_format_to_avif_rgb(options->format);
+    rgb.depth = output_depth; // 8-bit except for HDR gain map renditions
+
+    // Set chroma upsampling mode
+    rgb.chromaUpsampling = (avifChromaUpsampling)options->chroma_upsampling;
+
+    // Allocate RGB buffer
+    avifResult result = avifRGBImageAllocatePixels(&rgb);
     if (result != AVIF_RESULT_OK) {
-        avifEncoderDestroy(encoder);
-        avifImageDestroy(image);
-        nextimage_set_error("AVIF add image failed: %s", avifResultToString(result));
-        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        nextimage_set_error("Failed to allocate RGB buffer: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
 
-    avifRWData raw = AVIF_DATA_EMPTY;
-    result = avifEncoderFinish(encoder, &raw);
-    if (result != AVIF_RESULT_OK) {
-        avifEncoderDestroy(encoder);
-        avifImageDestroy(image);
-        nextimage_set_error("AVIF encoding failed: %s", avifResultToString(result));
-        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    if (options->gain_map_output == NEXTIMAGE_GAIN_MAP_OUTPUT_RENDITION) {
+        // Apply the gain map for the requested headroom (0 = SDR in sRGB, >0 = HDR in PQ)
+        avifDiagnostics diag;
+        avifDiagnosticsClearError(&diag);
+        avifTransferCharacteristics out_transfer = (output_depth > 8)
+            ? AVIF_TRANSFER_CHARACTERISTICS_PQ : AVIF_TRANSFER_CHARACTERISTICS_SRGB;
+        result = avifImageApplyGainMap(image, image->gainMap, options->gain_map_headroom,
+                                       image->colorPrimaries, out_transfer, &rgb, NULL, &diag);
+        if (result != AVIF_RESULT_OK) {
+            avifRGBImageFreePixels(&rgb);
+            nextimage_set_error("Failed to apply gain map: %s (%s)", avifResultToString(result), diag.error);
+            return NEXTIMAGE_ERROR_DECODE_FAILED;
+        }
+    } else if (source == image &&
+               options->tone_mapping != NEXTIMAGE_TONE_MAPPING_NONE &&
+               is_hdr_transfer(image->transferCharacteristics)) {
+        // HDR (PQ/HLG) -> SDR トーンマッピング
+        NextImageStatus tm_status = tone_map_hdr_to_rgb8(image, mastering_peak, options, &rgb);
+        if (tm_status != NEXTIMAGE_OK) {
+            avifRGBImageFreePixels(&rgb);
+            return tm_status;
+        }
+    } else {
+        // Convert YUV to RGB
+        result = avifImageYUVToRGB(source, &rgb);
+        if (result != AVIF_RESULT_OK) {
+            avifRGBImageFreePixels(&rgb);
+            nextimage_set_error("Failed to convert YUV to RGB: %s", avifResultToString(result));
+            return NEXTIMAGE_ERROR_DECODE_FAILED;
+        }
+    }
+
+    // Calculate output size
+    int bytes_per_pixel;
+    switch (options->format) {
+        case NEXTIMAGE_FORMAT_RGBA:
+        case NEXTIMAGE_FORMAT_BGRA:
+            bytes_per_pixel = 4;
+            break;
+        case NEXTIMAGE_FORMAT_RGB:
+            bytes_per_pixel = 3;
+            break;
+        default:
+            avifRGBImageFreePixels(&rgb);
+            nextimage_set_error("Unsupported output format: %d", options->format);
+            return NEXTIMAGE_ERROR_UNSUPPORTED;
     }
 
-    // Copy output data using our tracked allocation
-    output->data = nextimage_malloc(raw.size);
+    bytes_per_pixel *= output_depth / 8;
+    size_t data_size = (size_t)rgb.width * rgb.height * bytes_per_pixel;
+
+    // Copy to tracked allocation
+    output->data = nextimage_malloc(data_size);
     if (!output->data) {
-        avifRWDataFree(&raw);
-        avifEncoderDestroy(encoder);
-        avifImageDestroy(image);
+        avifRGBImageFreePixels(&rgb);
         nextimage_set_error("Failed to allocate output buffer");
         return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
 
-    memcpy(output->data, raw.data, raw.size);
-    output->size = raw.size;
+    memcpy(output->data, rgb.pixels, data_size);
+
+    // Set output metadata
+    output->data_size = data_size;
+    output->data_capacity = data_size;
+    output->stride = rgb.width * bytes_per_pixel;
+    output->width = rgb.width;
+    output->height = rgb.height;
+    output->bit_depth = output_depth;
+    output->format = options->format;
+    output->owns_data = 1;
+
+    // Planar data not used for RGB formats
+    output->u_plane = NULL;
+    output->v_plane = NULL;
 
     // Cleanup
-    avifRWDataFree(&raw);
-    avifEncoderDestroy(encoder);
-    avifImageDestroy(image);
+    avifRGBImageFreePixels(&rgb);
 
     return NEXTIMAGE_OK;
 }
@@ -575,6 +2225,11 @@ NextImageStatus nextimage_avif_decode_alloc(
         options = &default_opts;
     }
 
+    NextImageStatus status = validate_decode_options(options);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+
     // Create decoder
     avifDecoder* decoder = avifDecoderCreate();
     if (!decoder) {
@@ -582,21 +2237,7 @@ NextImageStatus nextimage_avif_decode_alloc(
         return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
     }
 
-    // Set decoder options
-    decoder->ignoreExif = options->ignore_exif ? AVIF_TRUE : AVIF_FALSE;
-    decoder->ignoreXMP = options->ignore_xmp ? AVIF_TRUE : AVIF_FALSE;
-    // Note: libavif doesn't support ignoreColorProfile, so we'll handle ignore_icc after decoding
-
-    // Set security limits
-    decoder->imageSizeLimit = options->image_size_limit;
-    decoder->imageDimensionLimit = options->image_dimension_limit;
-
-    // Set strict validation flags
-    if (options->strict_flags == 0) {
-        decoder->strictFlags = AVIF_STRICT_DISABLED;
-    } else {
-        decoder->strictFlags = AVIF_STRICT_ENABLED;
-    }
+    configure_decoder(decoder, options);
 
     // Parse input
     avifResult result = avifDecoderSetIOMemory(decoder, avif_data, avif_size);
@@ -610,95 +2251,23 @@ NextImageStatus nextimage_avif_decode_alloc(
     result = avifDecoderParse(decoder);
     if (result != AVIF_RESULT_OK) {
         avifDecoderDestroy(decoder);
-        nextimage_set_error("Failed to parse AVIF: %s", avifResultToString(result));
-        return NEXTIMAGE_ERROR_DECODE_FAILED;
-    }
-
-    // Get next image (first frame)
-    result = avifDecoderNextImage(decoder);
-    if (result != AVIF_RESULT_OK) {
-        avifDecoderDestroy(decoder);
-        nextimage_set_error("Failed to decode AVIF image: %s", avifResultToString(result));
-        return NEXTIMAGE_ERROR_DECODE_FAILED;
-    }
-
-    avifImage* image = decoder->image;
-
-    // Setup RGB output
-    avifRGBImage rgb;
-    avifRGBImageSetDefaults(&rgb, image);
-    rgb.format = pixel_format_to_avif_rgb(options->format);
-    rgb.depth = 8; // Always output 8-bit for now
-
-    // Set chroma upsampling mode
-    rgb.chromaUpsampling = (avifChromaUpsampling)options->chroma_upsampling;
-
-    // Allocate RGB buffer
-    avifResult allocResult = avifRGBImageAllocatePixels(&rgb);
-    if (allocResult != AVIF_RESULT_OK) {
-        avifDecoderDestroy(decoder);
-        nextimage_set_error("Failed to allocate RGB buffer: %s", avifResultToString(allocResult));
-        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
-    }
-
-    // Convert YUV to RGB
-    result = avifImageYUVToRGB(image, &rgb);
-    if (result != AVIF_RESULT_OK) {
-        avifRGBImageFreePixels(&rgb);
-        avifDecoderDestroy(decoder);
-        nextimage_set_error("Failed to convert YUV to RGB: %s", avifResultToString(result));
-        return NEXTIMAGE_ERROR_DECODE_FAILED;
-    }
-
-    // Calculate output size
-    int bytes_per_pixel;
-    switch (options->format) {
-        case NEXTIMAGE_FORMAT_RGBA:
-        case NEXTIMAGE_FORMAT_BGRA:
-            bytes_per_pixel = 4;
-            break;
-        case NEXTIMAGE_FORMAT_RGB:
-            bytes_per_pixel = 3;
-            break;
-        default:
-            avifRGBImageFreePixels(&rgb);
-            avifDecoderDestroy(decoder);
-            nextimage_set_error("Unsupported output format: %d", options->format);
-            return NEXTIMAGE_ERROR_UNSUPPORTED;
+        nextimage_set_error("Failed to parse AVIF: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
     }
 
-    size_t data_size = (size_t)rgb.width * rgb.height * bytes_per_pixel;
-
-    // Copy to tracked allocation
-    output->data = nextimage_malloc(data_size);
-    if (!output->data) {
-        avifRGBImageFreePixels(&rgb);
+    // Get next image (first frame)
+    result = avifDecoderNextImage(decoder);
+    if (result != AVIF_RESULT_OK) {
         avifDecoderDestroy(decoder);
-        nextimage_set_error("Failed to allocate output buffer");
-        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        nextimage_set_error("Failed to decode AVIF image: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
     }
 
-    memcpy(output->data, rgb.pixels, data_size);
-
-    // Set output metadata
-    output->data_size = data_size;
-    output->data_capacity = data_size;
-    output->stride = rgb.width * bytes_per_pixel;
-    output->width = rgb.width;
-    output->height = rgb.height;
-    output->bit_depth = 8; // Always 8-bit output for now
-    output->format = options->format;
-    output->owns_data = 1;
-
-    // Planar data not used for RGB formats
-    output->u_plane = NULL;
-    output->v_plane = NULL;
-
-    // Cleanup
-    avifRGBImageFreePixels(&rgb);
+    status = convert_decoded_image(decoder->image, avif_mastering_peak_nits(avif_data, avif_size),
+                                   options, output);
     avifDecoderDestroy(decoder);
 
-    return NEXTIMAGE_OK;
+    return status;
 }
 
 // デコードサイズ計算
@@ -793,6 +2362,77 @@ NextImageStatus nextimage_avif_decode_into(
     return NEXTIMAGE_OK;
 }
 
+// ゲインマップのメタデータ取得
+static double signed_fraction_to_double(avifSignedFraction f) {
+    return f.d ? (double)f.n / (double)f.d : 0.0;
+}
+
+static double unsigned_fraction_to_double(avifUnsignedFraction f) {
+    return f.d ? (double)f.n / (double)f.d : 0.0;
+}
+
+NextImageStatus nextimage_avif_gain_map_info(
+    const uint8_t* avif_data,
+    size_t avif_size,
+    NextImageAVIFGainMapInfo* info
+) {
+    if (!avif_data || avif_size == 0 || !info) {
+        nextimage_set_error("Invalid parameters: NULL pointer");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    memset(info, 0, sizeof(NextImageAVIFGainMapInfo));
+
+    avifDecoder* decoder = avifDecoderCreate();
+    if (!decoder) {
+        nextimage_set_error("Failed to create AVIF decoder");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    // Parse only; gain map metadata is available after parsing
+    decoder->imageContentToDecode = AVIF_IMAGE_CONTENT_COLOR_AND_ALPHA | AVIF_IMAGE_CONTENT_GAIN_MAP;
+
+    avifResult result = avifDecoderSetIOMemory(decoder, avif_data, avif_size);
+    if (result == AVIF_RESULT_OK) {
+        result = avifDecoderParse(decoder);
+    }
+    if (result != AVIF_RESULT_OK) {
+        avifDecoderDestroy(decoder);
+        nextimage_set_error("Failed to parse AVIF: %s", avifResultToString(result));
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
+    }
+
+    const avifGainMap* gm = decoder->image->gainMap;
+    if (gm) {
+        info->present = 1;
+        if (gm->image) {
+            info->width = (int)gm->image->width;
+            info->height = (int)gm->image->height;
+            info->bit_depth = (int)gm->image->depth;
+        }
+        for (int c = 0; c < 3; c++) {
+            info->gain_map_min[c] = signed_fraction_to_double(gm->gainMapMin[c]);
+            info->gain_map_max[c] = signed_fraction_to_double(gm->gainMapMax[c]);
+            info->gain_map_gamma[c] = unsigned_fraction_to_double(gm->gainMapGamma[c]);
+            info->base_offset[c] = signed_fraction_to_double(gm->baseOffset[c]);
+            info->alternate_offset[c] = signed_fraction_to_double(gm->alternateOffset[c]);
+        }
+        info->base_hdr_headroom = unsigned_fraction_to_double(gm->baseHdrHeadroom);
+        info->alternate_hdr_headroom = unsigned_fraction_to_double(gm->alternateHdrHeadroom);
+        info->use_base_color_space = gm->useBaseColorSpace ? 1 : 0;
+        info->alt_color_primaries = (int)gm->altColorPrimaries;
+        info->alt_transfer_characteristics = (int)gm->altTransferCharacteristics;
+        info->alt_matrix_coefficients = (int)gm->altMatrixCoefficients;
+        info->alt_bit_depth = (int)gm->altDepth;
+        info->alt_plane_count = (int)gm->altPlaneCount;
+        info->alt_clli_max_cll = (int)gm->altCLLI.maxCLL;
+        info->alt_clli_max_pall = (int)gm->altCLLI.maxPALL;
+    }
+
+    avifDecoderDestroy(decoder);
+    return NEXTIMAGE_OK;
+}
+
 // ========================================
 // インスタンスベースのエンコーダー/デコーダー
 // ========================================
@@ -800,6 +2440,8 @@ NextImageStatus nextimage_avif_decode_into(
 // エンコーダー構造体
 struct NextImageAVIFEncoder {
     NextImageAVIFEncodeOptions options;
+    uint8_t* gain_map_alternate;  // options.gain_map_alternate_data の所有コピー
+    char** codec_options;         // codec_option_keys/values の所有コピー（keys, valuesの順に2*count個）
 };
 
 // デコーダー構造体
@@ -807,6 +2449,37 @@ struct NextImageAVIFDecoder {
     NextImageAVIFDecodeOptions options;
 };
 
+static char* copy_string(const char* str) {
+    size_t len = strlen(str) + 1;
+    char* copy = (char*)nextimage_malloc(len);
+    if (copy) {
+        memcpy(copy, str, len);
+    }
+    return copy;
+}
+
+static NextImageStatus copy_codec_options(NextImageAVIFEncoder* encoder) {
+    const int count = encoder->options.codec_option_count;
+    encoder->codec_options = (char**)nextimage_calloc((size_t)count * 2, sizeof(char*));
+    if (!encoder->codec_options) {
+        nextimage_set_error("Failed to allocate codec options");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+    for (int i = 0; i < count; i++) {
+        const char* key = encoder->options.codec_option_keys[i];
+        const char* value = encoder->options.codec_option_values[i];
+        encoder->codec_options[i] = key ? copy_string(key) : NULL;
+        encoder->codec_options[count + i] = value ? copy_string(value) : NULL;
+        if ((key && !encoder->codec_options[i]) || (value && !encoder->codec_options[count + i])) {
+            nextimage_set_error("Failed to allocate codec options");
+            return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        }
+    }
+    encoder->options.codec_option_keys = (const char**)encoder->codec_options;
+    encoder->options.codec_option_values = (const char**)encoder->codec_options + count;
+    return NEXTIMAGE_OK;
+}
+
 // エンコーダーの作成
 NextImageAVIFEncoder* nextimage_avif_encoder_create(
     const NextImageAVIFEncodeOptions* options
@@ -824,6 +2497,40 @@ NextImageAVIFEncoder* nextimage_avif_encoder_create(
         nextimage_avif_default_encode_options(&encoder->options);
     }
 
+    // コーデック固有オプションは作成時に検証する
+    if (validate_codec_options(&encoder->options) != NEXTIMAGE_OK) {
+        nextimage_free(encoder);
+        return NULL;
+    }
+
+    // ゲインマップの代替画像は呼び出し後も参照するため複製して保持する
+    encoder->gain_map_alternate = NULL;
+    if (encoder->options.gain_map_alternate_data && encoder->options.gain_map_alternate_size > 0) {
+        encoder->gain_map_alternate = (uint8_t*)nextimage_malloc(encoder->options.gain_map_alternate_size);
+        if (!encoder->gain_map_alternate) {
+            nextimage_free(encoder);
+            nextimage_set_error("Failed to allocate gain map alternate image");
+            return NULL;
+        }
+        memcpy(encoder->gain_map_alternate, encoder->options.gain_map_alternate_data,
+               encoder->options.gain_map_alternate_size);
+        encoder->options.gain_map_alternate_data = encoder->gain_map_alternate;
+    }
+
+    // コーデック固有オプションの文字列も複製して保持する
+    encoder->codec_options = NULL;
+    if (encoder->options.codec_option_count > 0 &&
+        encoder->options.codec_option_keys && encoder->options.codec_option_values) {
+        if (copy_codec_options(encoder) != NEXTIMAGE_OK) {
+            nextimage_avif_encoder_destroy(encoder);
+            return NULL;
+        }
+    } else {
+        encoder->options.codec_option_keys = NULL;
+        encoder->options.codec_option_values = NULL;
+        encoder->options.codec_option_count = 0;
+    }
+
     return encoder;
 }
 
@@ -842,9 +2549,52 @@ NextImageStatus nextimage_avif_encoder_encode(
     return nextimage_avif_encode_alloc(input_data, input_size, &encoder->options, output);
 }
 
+// エンコーダーでエンコード（統計情報付き）
+NextImageStatus nextimage_avif_encoder_encode_with_stats(
+    NextImageAVIFEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    NextImageAVIFEncodeStats* stats
+) {
+    if (!encoder) {
+        nextimage_set_error("Invalid encoder instance");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return avif_encode(input_data, input_size, &encoder->options, output, NULL, stats);
+}
+
+// エンコーダーでエンコード（ライターへ逐次出力）
+NextImageStatus nextimage_avif_encoder_encode_to_writer(
+    NextImageAVIFEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    NextImageAVIFEncodeStats* stats
+) {
+    if (!encoder) {
+        nextimage_set_error("Invalid encoder instance");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_avif_encode_to_writer(input_data, input_size, &encoder->options, writer, stats);
+}
+
 // エンコーダーの破棄
 void nextimage_avif_encoder_destroy(NextImageAVIFEncoder* encoder) {
     if (encoder) {
+        if (encoder->gain_map_alternate) {
+            nextimage_free(encoder->gain_map_alternate);
+        }
+        if (encoder->codec_options) {
+            for (int i = 0; i < encoder->options.codec_option_count * 2; i++) {
+                if (encoder->codec_options[i]) {
+                    nextimage_free(encoder->codec_options[i]);
+                }
+            }
+            nextimage_free(encoder->codec_options);
+        }
         nextimage_free(encoder);
     }
 }
@@ -883,6 +2633,280 @@ NextImageStatus nextimage_avif_decoder_decode(
     return nextimage_avif_decode_alloc(avif_data, avif_size, &decoder->options, output);
 }
 
+// 受信途中のデータ用avifIO: 未到着の範囲の読み込みにはAVIF_RESULT_WAITING_ON_IOを返す
+typedef struct {
+    avifIO io;
+    const uint8_t* data;
+    size_t size;
+    int complete;
+} PartialDataIO;
+
+static avifResult partial_io_read(struct avifIO* io, uint32_t read_flags, uint64_t offset, size_t size, avifROData* out) {
+    PartialDataIO* pio = (PartialDataIO*)io;
+    if (read_flags != 0) {
+        return AVIF_RESULT_IO_ERROR;
+    }
+    if (offset > pio->size) {
+        return pio->complete ? AVIF_RESULT_IO_ERROR : AVIF_RESULT_WAITING_ON_IO;
+    }
+    size_t available = pio->size - (size_t)offset;
+    if (size > available) {
+        if (!pio->complete) {
+            return AVIF_RESULT_WAITING_ON_IO;
+        }
+        size = available;
+    }
+    out->data = pio->data + offset;
+    out->size = size;
+    return AVIF_RESULT_OK;
+}
+
+static void partial_io_destroy(struct avifIO* io) {
+    nextimage_free(io);
+}
+
+static avifIO* partial_io_create(const uint8_t* data, size_t size, int complete) {
+    PartialDataIO* pio = (PartialDataIO*)nextimage_calloc(1, sizeof(PartialDataIO));
+    if (!pio) {
+        return NULL;
+    }
+    pio->io.destroy = partial_io_destroy;
+    pio->io.read = partial_io_read;
+    pio->io.write = NULL;
+    pio->io.sizeHint = complete ? size : 0;
+    pio->io.persistent = AVIF_TRUE;
+    pio->data = data;
+    pio->size = size;
+    pio->complete = complete;
+    return (avifIO*)pio;
+}
+
+// プログレッシブ（レイヤー）の逐次デコード
+// 受信したデータを追記しながら同じavifDecoderでデコードを続けるため、
+// 各バイトの読み込みと各レイヤーのデコードは1回だけ行われる
+struct NextImageAVIFLayerStream {
+    NextImageAVIFDecodeOptions options;
+    avifDecoder* decoder;
+    PartialDataIO* io;      // decoderが所有する
+    uint8_t* data;          // 受信済みのデータ
+    size_t size;
+    size_t capacity;
+    int parsed;             // ヘッダの解析が完了した
+    int layer_count;        // 総レイヤー数（ヘッダ未到着の場合は0）
+    int next_layer;         // 次にデコードするレイヤー
+    double mastering_peak;  // mdcvの最大マスタリング輝度（nits）
+};
+
+NextImageAVIFLayerStream* nextimage_avif_layer_stream_create(NextImageAVIFDecoder* decoder) {
+    if (!decoder) {
+        nextimage_set_error("Invalid decoder instance");
+        return NULL;
+    }
+    if (validate_decode_options(&decoder->options) != NEXTIMAGE_OK) {
+        return NULL;
+    }
+
+    NextImageAVIFLayerStream* stream =
+        (NextImageAVIFLayerStream*)nextimage_calloc(1, sizeof(NextImageAVIFLayerStream));
+    if (!stream) {
+        nextimage_set_error("Failed to allocate layer stream");
+        return NULL;
+    }
+    stream->options = decoder->options;
+
+    stream->decoder = avifDecoderCreate();
+    if (!stream->decoder) {
+        nextimage_free(stream);
+        nextimage_set_error("Failed to create AVIF decoder");
+        return NULL;
+    }
+    configure_decoder(stream->decoder, &stream->options);
+    stream->decoder->allowProgressive = AVIF_TRUE;
+
+    stream->io = (PartialDataIO*)partial_io_create(NULL, 0, 0);
+    if (!stream->io) {
+        avifDecoderDestroy(stream->decoder);
+        nextimage_free(stream);
+        nextimage_set_error("Failed to create AVIF decoder input");
+        return NULL;
+    }
+    // 追記でバッファが移動するため、libavifには読み込んだ範囲をコピーさせる
+    stream->io->io.persistent = AVIF_FALSE;
+    avifDecoderSetIO(stream->decoder, (avifIO*)stream->io);
+    return stream;
+}
+
+// データを追記する（サイズは倍々で確保し、追記全体で線形の計算量に収める）
+static NextImageStatus layer_stream_append(NextImageAVIFLayerStream* stream, const uint8_t* data, size_t size) {
+    if (size == 0) {
+        return NEXTIMAGE_OK;
+    }
+    if (size > SIZE_MAX - stream->size) {
+        nextimage_set_error("Layer stream input too large");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (stream->size + size > stream->capacity) {
+        size_t capacity = stream->capacity ? stream->capacity : 64 * 1024;
+        while (capacity < stream->size + size) {
+            capacity = (capacity > SIZE_MAX / 2) ? stream->size + size : capacity * 2;
+        }
+        uint8_t* grown = (uint8_t*)nextimage_realloc(stream->data, capacity);
+        if (!grown) {
+            nextimage_set_error("Failed to grow layer stream buffer");
+            return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+        }
+        stream->data = grown;
+        stream->capacity = capacity;
+    }
+    memcpy(stream->data + stream->size, data, size);
+    stream->size += size;
+    return NEXTIMAGE_OK;
+}
+
+// 受信済みのデータでデコードできるレイヤーをデコードする
+static NextImageStatus layer_stream_decode(
+    NextImageAVIFLayerStream* stream,
+    NextImageDecodeBuffer* layers,
+    int* layer_count,
+    int* decoded_count
+) {
+    NextImageStatus status = NEXTIMAGE_OK;
+    avifResult result;
+
+    if (!stream->parsed) {
+        result = avifDecoderParse(stream->decoder);
+        if (result == AVIF_RESULT_WAITING_ON_IO) {
+            // ヘッダがまだ揃っていない（次の追記で解析し直す）
+            return NEXTIMAGE_OK;
+        }
+        if (result != AVIF_RESULT_OK) {
+            nextimage_set_error("Failed to parse AVIF: %s", avifResultToString(result));
+            return NEXTIMAGE_ERROR_DECODE_FAILED;
+        }
+        stream->parsed = 1;
+        // プログレッシブでない画像（アニメーションを含む）は最初の画像のみを1レイヤーとして扱う
+        stream->layer_count = (stream->decoder->progressiveState == AVIF_PROGRESSIVE_STATE_ACTIVE)
+            ? stream->decoder->imageCount : 1;
+        stream->mastering_peak = avif_mastering_peak_nits(stream->io->data, stream->io->size);
+    }
+    *layer_count = stream->layer_count;
+
+    while (stream->next_layer < stream->layer_count && *decoded_count < NEXTIMAGE_AVIF_MAX_LAYERS) {
+        result = avifDecoderNextImage(stream->decoder);
+        if (result == AVIF_RESULT_WAITING_ON_IO) {
+            // このレイヤーのデータが未到着（次の追記で続きからデコードする）
+            break;
+        }
+        if (result != AVIF_RESULT_OK) {
+            nextimage_set_error("Failed to decode AVIF layer %d: %s",
+                                stream->next_layer, avifResultToString(result));
+            status = NEXTIMAGE_ERROR_DECODE_FAILED;
+            break;
+        }
+        stream->next_layer++;
+
+        status = convert_decoded_image(stream->decoder->image, stream->mastering_peak,
+                                       &stream->options, &layers[*decoded_count]);
+        if (status != NEXTIMAGE_OK) {
+            break;
+        }
+        (*decoded_count)++;
+    }
+
+    if (status != NEXTIMAGE_OK) {
+        for (int i = 0; i < *decoded_count; i++) {
+            nextimage_free_decode_buffer(&layers[i]);
+        }
+        *decoded_count = 0;
+    }
+    return status;
+}
+
+NextImageStatus nextimage_avif_layer_stream_push(
+    NextImageAVIFLayerStream* stream,
+    const uint8_t* data,
+    size_t size,
+    int complete,
+    NextImageDecodeBuffer* layers,
+    int* layer_count,
+    int* decoded_count
+) {
+    if (!stream || (!data && size > 0) || !layers || !layer_count || !decoded_count) {
+        nextimage_set_error("Invalid parameters: NULL pointer");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    *layer_count = stream->layer_count;
+    *decoded_count = 0;
+    memset(layers, 0, sizeof(NextImageDecodeBuffer) * NEXTIMAGE_AVIF_MAX_LAYERS);
+
+    if (stream->io->complete) {
+        nextimage_set_error("Layer stream input is already complete");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    NextImageStatus status = layer_stream_append(stream, data, size);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+    stream->io->data = stream->data;
+    stream->io->size = stream->size;
+    if (complete) {
+        stream->io->complete = 1;
+        stream->io->io.sizeHint = stream->size;
+    }
+
+    return layer_stream_decode(stream, layers, layer_count, decoded_count);
+}
+
+void nextimage_avif_layer_stream_destroy(NextImageAVIFLayerStream* stream) {
+    if (stream) {
+        avifDecoderDestroy(stream->decoder); // ioも破棄される
+        nextimage_free(stream->data);
+        nextimage_free(stream);
+    }
+}
+
+// プログレッシブ（レイヤー）デコード（受信済みのデータを一度に渡す）
+NextImageStatus nextimage_avif_decoder_decode_layers(
+    NextImageAVIFDecoder* decoder,
+    const uint8_t* avif_data,
+    size_t avif_size,
+    int complete,
+    NextImageDecodeBuffer* layers,
+    int* layer_count,
+    int* decoded_count
+) {
+    if (!decoder || !avif_data || !layers || !layer_count || !decoded_count) {
+        nextimage_set_error("Invalid parameters: NULL pointer");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    *layer_count = 0;
+    *decoded_count = 0;
+    memset(layers, 0, sizeof(NextImageDecodeBuffer) * NEXTIMAGE_AVIF_MAX_LAYERS);
+
+    NextImageStatus status = validate_decode_options(&decoder->options);
+    if (status != NEXTIMAGE_OK) {
+        return status;
+    }
+    NextImageAVIFLayerStream* stream = nextimage_avif_layer_stream_create(decoder);
+    if (!stream) {
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+    // 一度きりの呼び出しではコピーせずに呼び出し元のデータを直接読む
+    stream->io->data = avif_data;
+    stream->io->size = avif_size;
+    stream->io->complete = complete;
+    stream->io->io.sizeHint = complete ? avif_size : 0;
+    stream->io->io.persistent = AVIF_TRUE;
+    stream->data = NULL;
+    stream->size = 0;
+
+    status = layer_stream_decode(stream, layers, layer_count, decoded_count);
+    nextimage_avif_layer_stream_destroy(stream);
+    return status;
+}
+
 // デコーダーの破棄
 void nextimage_avif_decoder_destroy(NextImageAVIFDecoder* decoder) {
     if (decoder) {
@@ -948,6 +2972,36 @@ NextImageStatus avifenc_run_command(
     return nextimage_avif_encoder_encode(cmd->encoder, input_data, input_size, output);
 }
 
+NextImageStatus avifenc_run_command_with_stats(
+    AVIFEncCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    struct NextImageAVIFEncodeStats* stats
+) {
+    if (!cmd || !cmd->encoder) {
+        nextimage_set_error("Invalid AVIFEncCommand");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_avif_encoder_encode_with_stats(cmd->encoder, input_data, input_size, output, stats);
+}
+
+NextImageStatus avifenc_run_command_to_writer(
+    AVIFEncCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    struct NextImageAVIFEncodeStats* stats
+) {
+    if (!cmd || !cmd->encoder) {
+        nextimage_set_error("Invalid AVIFEncCommand");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_avif_encoder_encode_to_writer(cmd->encoder, input_data, input_size, writer, stats);
+}
+
 void avifenc_free_command(AVIFEncCommand* cmd) {
     if (cmd) {
         if (cmd->encoder) {
@@ -965,7 +3019,7 @@ static void stbi_write_to_buffer_callback(void* context, void* data, int size) {
     size_t new_size = buf->size + (size_t)size;
 
     // バッファを再割り当て
-    uint8_t* new_data = (uint8_t*)realloc(buf->data, new_size);
+    uint8_t* new_data = (uint8_t*)nextimage_realloc(buf->data, new_size);
     if (!new_data) {
         // メモリ割り当て失敗 - 現在のバッファは維持
         return;
@@ -977,6 +3031,108 @@ static void stbi_write_to_buffer_callback(void* context, void* data, int size) {
     buf->size = new_size;
 }
 
+// libpng書き込みコールバック（stbi_write_to_buffer_callbackと同じバッファに追記）
+static void png_write_to_buffer(png_structp png, png_bytep data, png_size_t length) {
+    stbi_write_to_buffer_callback(png_get_io_ptr(png), data, (int)length);
+}
+
+static void png_flush_noop(png_structp png) {
+    (void)png;
+}
+
+// 16-bitデコード結果（ゲインマップのHDRレンディション）をPNGに書き出す
+// stb_image_writeは8-bitのみのためlibpngを使用する
+static int write_png_16bit(const NextImageDecodeBuffer* buf, int channels, NextImageBuffer* output) {
+    png_structp png = png_create_write_struct(PNG_LIBPNG_VER_STRING, NULL, NULL, NULL);
+    if (!png) {
+        return 0;
+    }
+    png_infop info = png_create_info_struct(png);
+    if (!info) {
+        png_destroy_write_struct(&png, NULL);
+        return 0;
+    }
+
+    if (setjmp(png_jmpbuf(png))) {
+        png_destroy_write_struct(&png, &info);
+        return 0;
+    }
+
+    png_set_write_fn(png, output, png_write_to_buffer, png_flush_noop);
+    png_set_IHDR(png, info, (png_uint_32)buf->width, (png_uint_32)buf->height, 16,
+                 channels == 4 ? PNG_COLOR_TYPE_RGB_ALPHA : PNG_COLOR_TYPE_RGB,
+                 PNG_INTERLACE_NONE, PNG_COMPRESSION_TYPE_DEFAULT, PNG_FILTER_TYPE_DEFAULT);
+    png_write_info(png, info);
+    if (is_little_endian()) {
+        png_set_swap(png);
+    }
+
+    for (int y = 0; y < buf->height; y++) {
+        png_write_row(png, (png_const_bytep)(buf->data + (size_t)y * buf->stride));
+    }
+
+    png_write_end(png, info);
+    png_destroy_write_struct(&png, &info);
+    return 1;
+}
+
+// PQ/HLGのまま出力する場合の色空間（primaries, transfer）を求める
+// ゲインマップのHDRレンディションはPQ、トーンマッピングしないHDR画像は元のtransfer
+// 戻り値: 1=cICPを書く, 0=SDR出力（cICP不要）
+static int avif_output_cicp(const uint8_t* avif_data, size_t avif_size,
+                            const NextImageAVIFDecodeOptions* options, uint8_t cicp[4]) {
+    if (options->gain_map_output == NEXTIMAGE_GAIN_MAP_OUTPUT_IMAGE ||
+        (options->gain_map_output == NEXTIMAGE_GAIN_MAP_OUTPUT_RENDITION && options->gain_map_headroom <= 0.0f)) {
+        return 0;
+    }
+
+    // ヘッダーの解析のみ（画像データはデコードしない）
+    avifDecoder* decoder = avifDecoderCreate();
+    if (!decoder) return 0;
+    int found = 0;
+    if (avifDecoderSetIOMemory(decoder, avif_data, avif_size) == AVIF_RESULT_OK &&
+        avifDecoderParse(decoder) == AVIF_RESULT_OK) {
+        const avifImage* image = decoder->image;
+        avifTransferCharacteristics transfer = image->transferCharacteristics;
+        if (options->gain_map_output == NEXTIMAGE_GAIN_MAP_OUTPUT_RENDITION) {
+            transfer = AVIF_TRANSFER_CHARACTERISTICS_PQ;
+        } else if (options->tone_mapping != NEXTIMAGE_TONE_MAPPING_NONE) {
+            transfer = AVIF_TRANSFER_CHARACTERISTICS_UNSPECIFIED; // SDRへトーンマッピング済み
+        }
+        if (is_hdr_transfer(transfer)) {
+            cicp[0] = (uint8_t)image->colorPrimaries;
+            cicp[1] = (uint8_t)transfer;
+            cicp[2] = 0; // RGB
+            cicp[3] = 1; // full range
+            found = 1;
+        }
+    }
+    avifDecoderDestroy(decoder);
+    return found;
+}
+
+// PNGのIHDRの直後にcICPチャンクを挿入する（IDAT/PLTEより前に置く必要がある）
+static int png_insert_cicp(NextImageBuffer* png_data, const uint8_t cicp[4]) {
+    const size_t ihdr_end = 8 + 8 + 13 + 4; // シグネチャ + IHDR（長さ/タイプ/データ/CRC）
+    if (png_data->size < ihdr_end) return 0;
+
+    uint8_t chunk[16] = {0, 0, 0, 4, 'c', 'I', 'C', 'P'};
+    memcpy(chunk + 8, cicp, 4);
+    const uLong crc = crc32(crc32(0L, Z_NULL, 0), chunk + 4, 8);
+    chunk[12] = (uint8_t)(crc >> 24);
+    chunk[13] = (uint8_t)(crc >> 16);
+    chunk[14] = (uint8_t)(crc >> 8);
+    chunk[15] = (uint8_t)crc;
+
+    uint8_t* data = (uint8_t*)nextimage_realloc(png_data->data, png_data->size + sizeof(chunk));
+    if (!data) return 0;
+    memmove(data + ihdr_end + sizeof(chunk), data + ihdr_end, png_data->size - ihdr_end);
+    memcpy(data + ihdr_end, chunk, sizeof(chunk));
+    png_data->data = data;
+    png_data->size += sizeof(chunk);
+    return 1;
+}
+
 // AVIFDec実装（NextImageAVIFDecoderを内部で使用）
 struct AVIFDecCommand {
     NextImageAVIFDecoder* decoder;
@@ -1092,7 +3248,15 @@ NextImageStatus avifdec_run_command(
 
     // PNG or JPEGにエンコード
     int result = 0;
-    if (cmd->output_format == AVIFDEC_OUTPUT_JPEG) {
+    if (decode_buf.bit_depth > 8) {
+        // 16-bit出力（ゲインマップのHDRレンディション）はPNGのみ
+        if (cmd->output_format == AVIFDEC_OUTPUT_JPEG) {
+            nextimage_free_decode_buffer(&decode_buf);
+            nextimage_set_error("16-bit output cannot be written as JPEG");
+            return NEXTIMAGE_ERROR_UNSUPPORTED;
+        }
+        result = write_png_16bit(&decode_buf, channels, output);
+    } else if (cmd->output_format == AVIFDEC_OUTPUT_JPEG) {
         // JPEGにエンコード
         result = stbi_write_jpg_to_func(
             stbi_write_to_buffer_callback,
@@ -1119,6 +3283,13 @@ NextImageStatus avifdec_run_command(
     // デコードバッファを解放
     nextimage_free_decode_buffer(&decode_buf);
 
+    // PQ/HLGのままのPNGにはcICPを付け、ビューアーがsRGBとして扱わないようにする
+    uint8_t cicp[4];
+    if (result && cmd->output_format != AVIFDEC_OUTPUT_JPEG &&
+        avif_output_cicp(avif_data, avif_size, &cmd->decoder->options, cicp)) {
+        result = png_insert_cicp(output, cicp);
+    }
+
     if (!result) {
         nextimage_free_buffer(output);
         nextimage_set_error("Failed to encode output (format: %s)",
@@ -8,39 +8,49 @@
 #if defined(__GNUC__) || defined(__clang__)
     // GCC/Clang拡張（macOS含む）
     static __thread char g_error_buffer[1024] = {0};
+    static __thread int g_error_image_size[2] = {0, 0};
 #elif defined(_MSC_VER)
     // MSVC拡張
     static __declspec(thread) char g_error_buffer[1024] = {0};
+    static __declspec(thread) int g_error_image_size[2] = {0, 0};
 #elif defined(__STDC_VERSION__) && __STDC_VERSION__ >= 201112L && !defined(__APPLE__)
     // C11 thread_local（macOS以外）
     #include <threads.h>
     static thread_local char g_error_buffer[1024] = {0};
+    static thread_local int g_error_image_size[2] = {0, 0};
 #else
     // フォールバック: スレッドセーフではない
     #warning "Thread-local storage not supported, error messages may not be thread-safe"
     static char g_error_buffer[1024] = {0};
+    static int g_error_image_size[2] = {0, 0};
 #endif
 
-// デバッグビルド専用: メモリリークカウンター
-#ifdef NEXTIMAGE_DEBUG
+// メモリリークカウンター（リリースビルドでも有効、アトミック加算のみのためコストは無視できる）
 #include <stdatomic.h>
-static atomic_int_least64_t g_allocation_counter = 0;
+static atomic_int_least64_t g_allocations = 0;
+static atomic_int_least64_t g_frees = 0;
 
 void nextimage_increment_alloc_counter(void) {
-    atomic_fetch_add(&g_allocation_counter, 1);
+    atomic_fetch_add(&g_allocations, 1);
 }
 
 void nextimage_decrement_alloc_counter(void) {
-    atomic_fetch_sub(&g_allocation_counter, 1);
+    atomic_fetch_add(&g_frees, 1);
 }
 
 int64_t nextimage_allocation_counter(void) {
-    return atomic_load(&g_allocation_counter);
+    // 解放回数を先に読み、並行する割り当てと解放の途中で負にならないようにする
+    int64_t frees = atomic_load(&g_frees);
+    return atomic_load(&g_allocations) - frees;
+}
+
+void nextimage_allocation_stats(NextImageAllocationStats* stats) {
+    if (!stats) {
+        return;
+    }
+    stats->frees = atomic_load(&g_frees);
+    stats->allocations = atomic_load(&g_allocations);
 }
-#else
-void nextimage_increment_alloc_counter(void) {}
-void nextimage_decrement_alloc_counter(void) {}
-#endif
 
 // 内部用: エラーメッセージを設定
 void nextimage_set_error(const char* format, ...) {
@@ -50,6 +60,12 @@ void nextimage_set_error(const char* format, ...) {
     va_end(args);
 }
 
+// 内部用: エラーの対象となった画像サイズを設定
+void nextimage_set_error_image_size(int width, int height) {
+    g_error_image_size[0] = width;
+    g_error_image_size[1] = height;
+}
+
 // エラーメッセージ取得
 const char* nextimage_last_error_message(void) {
     if (g_error_buffer[0] == '\0') {
@@ -61,6 +77,18 @@ const char* nextimage_last_error_message(void) {
 // エラーメッセージのクリア
 void nextimage_clear_error(void) {
     g_error_buffer[0] = '\0';
+    g_error_image_size[0] = 0;
+    g_error_image_size[1] = 0;
+}
+
+// エラーの対象となった画像サイズ取得
+void nextimage_last_error_image_size(int* width, int* height) {
+    if (width) {
+        *width = g_error_image_size[0];
+    }
+    if (height) {
+        *height = g_error_image_size[1];
+    }
 }
 
 // バッファの解放
@@ -140,8 +168,8 @@ void* nextimage_realloc(void* ptr, size_t size) {
     if (new_ptr && !was_allocated) {
         // 新規割り当て
         nextimage_increment_alloc_counter();
-    } else if (!new_ptr && was_allocated) {
-        // 解放された
+    } else if (!new_ptr && was_allocated && size == 0) {
+        // 解放された（サイズ0以外の失敗では元のブロックが残る）
         nextimage_decrement_alloc_counter();
     }
 
@@ -12,11 +12,23 @@ void nextimage_free(void* ptr);
 
 // 内部用エラーメッセージ設定
 void nextimage_set_error(const char* format, ...);
+void nextimage_set_error_image_size(int width, int height);
 
-// デバッグビルド専用
-#ifdef NEXTIMAGE_DEBUG
+// メモリリークカウンター
 void nextimage_increment_alloc_counter(void);
 void nextimage_decrement_alloc_counter(void);
+
+#ifdef NEXTIMAGE_HAVE_TIFF
+#include <tiffio.h>
+
+// メモリ上のTIFFデータ（TIFFClientOpenのハンドル）
+typedef struct {
+    const uint8_t* data;
+    toff_t size;
+    toff_t pos;
+} NextImageTIFFMemory;
+
+TIFF* nextimage_tiff_open(const uint8_t* data, size_t size, NextImageTIFFMemory* mem);
 #endif
 
 #endif // NEXTIMAGE_INTERNAL_H
@@ -0,0 +1,66 @@
+#include "internal.h"
+#include <string.h>
+#include <stdio.h>
+
+// 16-bit TIFF入力用: メモリ上のTIFFを同梱ビルドのlibtiffで開く
+
+#ifdef NEXTIMAGE_HAVE_TIFF
+
+static tsize_t tiff_read_proc(thandle_t handle, tdata_t buf, tsize_t size) {
+    NextImageTIFFMemory* r = (NextImageTIFFMemory*)handle;
+    if (r->pos > r->size) return 0;
+    toff_t remaining = r->size - r->pos;
+    if ((toff_t)size > remaining) size = (tsize_t)remaining;
+    memcpy(buf, r->data + r->pos, (size_t)size);
+    r->pos += size;
+    return size;
+}
+
+static tsize_t tiff_write_proc(thandle_t handle, tdata_t buf, tsize_t size) {
+    (void)handle; (void)buf; (void)size;
+    return 0;
+}
+
+static toff_t tiff_seek_proc(thandle_t handle, toff_t offset, int whence) {
+    NextImageTIFFMemory* r = (NextImageTIFFMemory*)handle;
+    switch (whence) {
+        case SEEK_SET: r->pos = offset; break;
+        case SEEK_CUR: r->pos += offset; break;
+        case SEEK_END: r->pos = r->size + offset; break;
+        default: return (toff_t)-1;
+    }
+    return r->pos;
+}
+
+static int tiff_close_proc(thandle_t handle) {
+    (void)handle;
+    return 0;
+}
+
+static toff_t tiff_size_proc(thandle_t handle) {
+    return ((NextImageTIFFMemory*)handle)->size;
+}
+
+static int tiff_map_proc(thandle_t handle, void** base, toff_t* size) {
+    NextImageTIFFMemory* r = (NextImageTIFFMemory*)handle;
+    *base = (void*)r->data;
+    *size = r->size;
+    return 1;
+}
+
+static void tiff_unmap_proc(thandle_t handle, void* base, toff_t size) {
+    (void)handle; (void)base; (void)size;
+}
+
+// メモリ上のTIFFを開く（memはTIFFCloseまで有効であること）
+TIFF* nextimage_tiff_open(const uint8_t* data, size_t size, NextImageTIFFMemory* mem) {
+    mem->data = data;
+    mem->size = (toff_t)size;
+    mem->pos = 0;
+    return TIFFClientOpen("Memory", "r", (thandle_t)mem,
+                          tiff_read_proc, tiff_write_proc, tiff_seek_proc,
+                          tiff_close_proc, tiff_size_proc,
+                          tiff_map_proc, tiff_unmap_proc);
+}
+
+#endif // NEXTIMAGE_HAVE_TIFF
@@ -315,20 +315,133 @@ static int webp_memory_writer(const uint8_t* data, size_t data_size, const WebPP
     return 1;
 }
 
+// ストリーミングライターコールバック（NextImageWriterへそのまま渡す）
+static int webp_stream_writer(const uint8_t* data, size_t data_size, const WebPPicture* picture) {
+    const NextImageWriter* writer = (const NextImageWriter*)picture->custom_ptr;
+    if (data_size == 0) {
+        return 1;
+    }
+    return writer->write(data, data_size, writer->user_data);
+}
+
+// 読み込み済みのWebPPictureに crop / resize / blend_alpha を適用してエンコード
+// pictureは成功・失敗にかかわらず解放される
+// aux_statsがNULLでなければlibwebpの統計情報を格納する（cwebp -v / -print_psnr 相当）
+// writerがNULLでなければoutputは使わず、エンコード結果をwriterへ逐次出力する
+static NextImageStatus encode_picture(
+    WebPPicture* picture,
+    const WebPConfig* config,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output,
+    const NextImageWriter* writer,
+    WebPAuxStats* aux_stats
+) {
+    // 画像変換処理: crop, resize, blend_alpha (cwebp.c と同じ順序)
+    if (options) {
+        // 1. Crop処理 (cwebp.c line 1065-1073)
+        // 矩形はデコード後の実寸で検査する（ヘッダーを読めない入力も含む）
+        if (options->crop_x >= 0 && options->crop_y >= 0 &&
+            options->crop_width > 0 && options->crop_height > 0) {
+            if ((int64_t)options->crop_x + options->crop_width > picture->width ||
+                (int64_t)options->crop_y + options->crop_height > picture->height) {
+                nextimage_set_error("crop rectangle %d,%d %dx%d is outside the %dx%d image",
+                                    options->crop_x, options->crop_y,
+                                    options->crop_width, options->crop_height,
+                                    picture->width, picture->height);
+                nextimage_set_error_image_size(picture->width, picture->height);
+                WebPPictureFree(picture);
+                return NEXTIMAGE_ERROR_CROP_OUTSIDE_IMAGE;
+            }
+            if (!WebPPictureCrop(picture, options->crop_x, options->crop_y,
+                                 options->crop_width, options->crop_height)) {
+                nextimage_set_error("Crop failed");
+                WebPPictureFree(picture);
+                return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+            }
+        }
+
+        // 2. Resize処理 (cwebp.c line 1075-1091)
+        if (options->resize_width > 0 && options->resize_height > 0) {
+            int should_resize = 1;
+            int orig_width = picture->width;
+            int orig_height = picture->height;
+
+            // resize_mode による条件チェック
+            if (options->resize_mode == 1) {  // up_only
+                should_resize = (options->resize_width > orig_width ||
+                                options->resize_height > orig_height);
+            } else if (options->resize_mode == 2) {  // down_only
+                should_resize = (options->resize_width < orig_width ||
+                                options->resize_height < orig_height);
+            }
+            // mode==0 (always) の場合は常にリサイズ
+
+            if (should_resize) {
+                if (!WebPPictureRescale(picture, options->resize_width, options->resize_height)) {
+                    WebPPictureFree(picture);
+                    nextimage_set_error("Resize failed");
+                    return NEXTIMAGE_ERROR_ENCODE_FAILED;
+                }
+            }
+        }
+
+        // 3. Blend alpha処理 (cwebp.c line 1093-1104)
+        if (options->blend_alpha != (uint32_t)-1 && picture->use_argb) {
+            // WebPBlendAlpha expects 0xRRGGBB format
+            // options->blend_alpha is already in 0xRRGGBB format
+            WebPBlendAlpha(picture, options->blend_alpha);
+        }
+    }
+
+    // カスタムライターを設定
+    if (writer) {
+        picture->writer = webp_stream_writer;
+        picture->custom_ptr = (void*)writer;
+    } else {
+        picture->writer = webp_memory_writer;
+        picture->custom_ptr = output;
+    }
+    picture->stats = aux_stats;
+
+    // エンコード
+    if (!WebPEncode(config, picture)) {
+        WebPPictureFree(picture);
+        if (writer && picture->error_code == VP8_ENC_ERROR_BAD_WRITE) {
+            nextimage_set_error("WebP encoding failed: output writer aborted");
+            return NEXTIMAGE_ERROR_ENCODE_FAILED;
+        }
+        if (output && output->data) {
+            nextimage_free(output->data);
+            output->data = NULL;
+            output->size = 0;
+        }
+        nextimage_set_error("WebP encoding failed: %d", picture->error_code);
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    WebPPictureFree(picture);
+    return NEXTIMAGE_OK;
+}
+
 // エンコード実装（画像ファイルデータから）
-NextImageStatus nextimage_webp_encode_alloc(
+// 入力を読み込んでencode_pictureへ渡す（aux_stats / writerはencode_pictureと同じ）
+static NextImageStatus webp_encode(
     const uint8_t* input_data,
     size_t input_size,
     const NextImageWebPEncodeOptions* options,
-    NextImageBuffer* output
+    NextImageBuffer* output,
+    const NextImageWriter* writer,
+    WebPAuxStats* aux_stats
 ) {
-    if (!input_data || input_size == 0 || !output) {
+    if (!input_data || input_size == 0 || (!output && !(writer && writer->write))) {
         nextimage_set_error("Invalid parameters: NULL input or output");
         return NEXTIMAGE_ERROR_INVALID_PARAM;
     }
 
     // Clear output
-    memset(output, 0, sizeof(NextImageBuffer));
+    if (output) {
+        memset(output, 0, sizeof(NextImageBuffer));
+    }
 
     // 画像フォーマットを推測
     WebPInputFileFormat format = WebPGuessImageType(input_data, input_size);
@@ -373,70 +486,144 @@ NextImageStatus nextimage_webp_encode_alloc(
         return NEXTIMAGE_ERROR_DECODE_FAILED;
     }
 
-    // 画像変換処理: crop, resize, blend_alpha (cwebp.c と同じ順序)
-    if (options) {
-        // 1. Crop処理 (cwebp.c line 1065-1073)
-        if (options->crop_x >= 0 && options->crop_y >= 0 &&
-            options->crop_width > 0 && options->crop_height > 0) {
-            if (!WebPPictureCrop(&picture, options->crop_x, options->crop_y,
-                                 options->crop_width, options->crop_height)) {
-                WebPPictureFree(&picture);
-                nextimage_set_error("Crop failed (invalid crop dimensions)");
-                return NEXTIMAGE_ERROR_INVALID_PARAM;
-            }
+    return encode_picture(&picture, &config, options, output, writer, aux_stats);
+}
+
+NextImageStatus nextimage_webp_encode_alloc(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output
+) {
+    return webp_encode(input_data, input_size, options, output, NULL, NULL);
+}
+
+// WebPAuxStatsを公開用の統計情報にコピー
+static void copy_aux_stats(const WebPAuxStats* aux, NextImageWebPEncodeStats* stats) {
+    memset(stats, 0, sizeof(NextImageWebPEncodeStats));
+    stats->coded_size = aux->coded_size;
+    for (int i = 0; i < 5; i++) {
+        stats->psnr[i] = aux->PSNR[i];
+    }
+    for (int i = 0; i < 3; i++) {
+        stats->block_count[i] = aux->block_count[i];
+    }
+    stats->header_bytes = aux->header_bytes[0];
+    stats->mode_partition_bytes = aux->header_bytes[1];
+    for (int i = 0; i < 3; i++) {
+        for (int s = 0; s < 4; s++) {
+            stats->residual_bytes[i][s] = aux->residual_bytes[i][s];
         }
+    }
+    for (int s = 0; s < 4; s++) {
+        stats->segment_size[s] = aux->segment_size[s];
+        stats->segment_quant[s] = aux->segment_quant[s];
+        stats->segment_level[s] = aux->segment_level[s];
+    }
+    stats->alpha_data_size = aux->alpha_data_size;
+    stats->lossless_features = aux->lossless_features;
+    stats->histogram_bits = aux->histogram_bits;
+    stats->transform_bits = aux->transform_bits;
+    stats->cache_bits = aux->cache_bits;
+    stats->palette_size = aux->palette_size;
+    stats->lossless_size = aux->lossless_size;
+    stats->lossless_hdr_size = aux->lossless_hdr_size;
+    stats->lossless_data_size = aux->lossless_data_size;
+}
 
-        // 2. Resize処理 (cwebp.c line 1075-1091)
-        if (options->resize_width > 0 && options->resize_height > 0) {
-            int should_resize = 1;
-            int orig_width = picture.width;
-            int orig_height = picture.height;
+// 統計情報付きエンコードの共通処理（出力先はoutputかwriterのどちらか）
+static NextImageStatus webp_encode_with_stats(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output,
+    const NextImageWriter* writer,
+    NextImageWebPEncodeStats* stats
+) {
+    if (!stats) {
+        return webp_encode(input_data, input_size, options, output, writer, NULL);
+    }
 
-            // resize_mode による条件チェック
-            if (options->resize_mode == 1) {  // up_only
-                should_resize = (options->resize_width > orig_width ||
-                                options->resize_height > orig_height);
-            } else if (options->resize_mode == 2) {  // down_only
-                should_resize = (options->resize_width < orig_width ||
-                                options->resize_height < orig_height);
-            }
-            // mode==0 (always) の場合は常にリサイズ
+    WebPAuxStats aux;
+    memset(&aux, 0, sizeof(aux));
+    NextImageStatus status = webp_encode(input_data, input_size, options, output, writer, &aux);
+    if (status == NEXTIMAGE_OK) {
+        copy_aux_stats(&aux, stats);
+    }
+    return status;
+}
 
-            if (should_resize) {
-                if (!WebPPictureRescale(&picture, options->resize_width, options->resize_height)) {
-                    WebPPictureFree(&picture);
-                    nextimage_set_error("Resize failed");
-                    return NEXTIMAGE_ERROR_ENCODE_FAILED;
-                }
-            }
-        }
+// エンコード（統計情報付き）
+NextImageStatus nextimage_webp_encode_alloc_with_stats(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output,
+    NextImageWebPEncodeStats* stats
+) {
+    return webp_encode_with_stats(input_data, input_size, options, output, NULL, stats);
+}
 
-        // 3. Blend alpha処理 (cwebp.c line 1093-1104)
-        if (options->blend_alpha != (uint32_t)-1 && picture.use_argb) {
-            // WebPBlendAlpha expects 0xRRGGBB format
-            // options->blend_alpha is already in 0xRRGGBB format
-            WebPBlendAlpha(&picture, options->blend_alpha);
-        }
+// エンコード（ライターへ逐次出力、statsはNULL可）
+NextImageStatus nextimage_webp_encode_to_writer(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWebPEncodeOptions* options,
+    const NextImageWriter* writer,
+    NextImageWebPEncodeStats* stats
+) {
+    if (!writer || !writer->write) {
+        nextimage_set_error("Invalid parameters: NULL writer");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
     }
+    return webp_encode_with_stats(input_data, input_size, options, NULL, writer, stats);
+}
 
-    // カスタムライターを設定
-    picture.writer = webp_memory_writer;
-    picture.custom_ptr = output;
+// デコード済みRGBAピクセルからエンコード
+NextImageStatus nextimage_webp_encode_rgba_alloc(
+    const uint8_t* rgba,
+    int width,
+    int height,
+    int stride,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output
+) {
+    if (!rgba || !output || width <= 0 || height <= 0 || stride < width * 4) {
+        nextimage_set_error("Invalid parameters");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
 
-    // エンコード
-    if (!WebPEncode(&config, &picture)) {
+    memset(output, 0, sizeof(NextImageBuffer));
+
+    WebPPicture picture;
+    if (!WebPPictureInit(&picture)) {
+        nextimage_set_error("Failed to initialize WebPPicture");
+        return NEXTIMAGE_ERROR_ENCODE_FAILED;
+    }
+
+    WebPConfig config;
+    if (!setup_webp_config(&config, options)) {
         WebPPictureFree(&picture);
-        if (output->data) {
-            nextimage_free(output->data);
-            output->data = NULL;
-            output->size = 0;
-        }
-        nextimage_set_error("WebP encoding failed: %d", picture.error_code);
         return NEXTIMAGE_ERROR_ENCODE_FAILED;
     }
 
-    WebPPictureFree(&picture);
-    return NEXTIMAGE_OK;
+    // ファイル入力と同じく、圧縮モードに応じてARGBかYUVAかを決めてから取り込む
+    picture.use_argb = (config.lossless || config.use_sharp_yuv ||
+                        config.preprocessing > 0);
+    picture.width = width;
+    picture.height = height;
+
+    // noalpha の場合はimageioのkeep_alpha=0と同様にアルファを捨てる
+    int keep_alpha = (options && options->noalpha) ? 0 : 1;
+    int ok = keep_alpha ? WebPPictureImportRGBA(&picture, rgba, stride)
+                        : WebPPictureImportRGBX(&picture, rgba, stride);
+    if (!ok) {
+        WebPPictureFree(&picture);
+        nextimage_set_error("Failed to import RGBA pixels");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    return encode_picture(&picture, &config, options, output, NULL, NULL);
 }
 
 // WebPデコード実装 - dwebp.cの実装に基づく
@@ -661,6 +848,197 @@ NextImageStatus nextimage_webp_decode_size(
     return NEXTIMAGE_OK;
 }
 
+// ARGB形式のWebPPictureをRGBA 8-bitのデコードバッファに書き出す
+static NextImageStatus export_rgba_picture(const WebPPicture* picture, NextImageDecodeBuffer* output) {
+    size_t stride = (size_t)picture->width * 4;
+    size_t buffer_size = stride * (size_t)picture->height;
+    output->data = (uint8_t*)nextimage_malloc(buffer_size);
+    if (!output->data) {
+        nextimage_set_error("Failed to allocate output buffer");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    // ARGB (0xAARRGGBB) -> RGBA
+    for (int y = 0; y < picture->height; y++) {
+        const uint32_t* src = picture->argb + (size_t)y * picture->argb_stride;
+        uint8_t* dst = output->data + (size_t)y * stride;
+        for (int x = 0; x < picture->width; x++) {
+            uint32_t argb = src[x];
+            dst[x * 4 + 0] = (uint8_t)(argb >> 16);
+            dst[x * 4 + 1] = (uint8_t)(argb >> 8);
+            dst[x * 4 + 2] = (uint8_t)argb;
+            dst[x * 4 + 3] = (uint8_t)(argb >> 24);
+        }
+    }
+
+    output->width = picture->width;
+    output->height = picture->height;
+    output->bit_depth = 8;
+    output->format = NEXTIMAGE_FORMAT_RGBA;
+    output->stride = stride;
+    output->data_size = buffer_size;
+    output->data_capacity = buffer_size;
+    output->owns_data = 1;
+    return NEXTIMAGE_OK;
+}
+
+// 入力画像デコード（imageio経由、RGBA 8-bitで出力）
+// 品質評価の参照画像など、エンコーダーが読み込むのと同じ画素値を得るために使う
+NextImageStatus nextimage_image_decode_alloc(
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageDecodeBuffer* output
+) {
+    if (!input_data || input_size == 0 || !output) {
+        nextimage_set_error("Invalid parameters: NULL input or output");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    memset(output, 0, sizeof(NextImageDecodeBuffer));
+
+    WebPInputFileFormat format = WebPGuessImageType(input_data, input_size);
+    if (format == WEBP_UNSUPPORTED_FORMAT) {
+        nextimage_set_error("Unsupported or unrecognized image format");
+        return NEXTIMAGE_ERROR_UNSUPPORTED;
+    }
+
+    WebPImageReader reader = WebPGetImageReader(format);
+    if (!reader) {
+        nextimage_set_error("No reader available for this image format");
+        return NEXTIMAGE_ERROR_UNSUPPORTED;
+    }
+
+    WebPPicture picture;
+    if (!WebPPictureInit(&picture)) {
+        nextimage_set_error("Failed to initialize WebPPicture");
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
+    }
+    picture.use_argb = 1;
+
+    if (!reader(input_data, input_size, &picture, 1, NULL)) {
+        WebPPictureFree(&picture);
+        nextimage_set_error("Failed to read input image");
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
+    }
+    if (!picture.use_argb || !picture.argb) {
+        WebPPictureFree(&picture);
+        nextimage_set_error("Input image was not read as ARGB");
+        return NEXTIMAGE_ERROR_DECODE_FAILED;
+    }
+
+    NextImageStatus status = export_rgba_picture(&picture, output);
+    WebPPictureFree(&picture);
+    return status;
+}
+
+// RGBA 8-bit画像をリサイズ（cwebp -resize と同じlibwebpのリスケーラーを使用）
+NextImageStatus nextimage_image_resize_alloc(
+    const uint8_t* rgba,
+    int width,
+    int height,
+    int stride,
+    int new_width,
+    int new_height,
+    NextImageDecodeBuffer* output
+) {
+    if (!rgba || !output || width <= 0 || height <= 0 || stride < width * 4 ||
+        new_width < 0 || new_height < 0 || (new_width == 0 && new_height == 0)) {
+        nextimage_set_error("Invalid parameters");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    memset(output, 0, sizeof(NextImageDecodeBuffer));
+
+    WebPPicture picture;
+    if (!WebPPictureInit(&picture)) {
+        nextimage_set_error("Failed to initialize WebPPicture");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+    picture.use_argb = 1;
+    picture.width = width;
+    picture.height = height;
+    if (!WebPPictureImportRGBA(&picture, rgba, stride)) {
+        WebPPictureFree(&picture);
+        nextimage_set_error("Failed to import RGBA pixels");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    // 幅か高さの一方が0の場合はアスペクト比を維持する
+    if (!WebPPictureRescale(&picture, new_width, new_height)) {
+        WebPPictureFree(&picture);
+        nextimage_set_error("Resize to %dx%d failed", new_width, new_height);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    NextImageStatus status = export_rgba_picture(&picture, output);
+    WebPPictureFree(&picture);
+    return status;
+}
+
+// RGBA 8-bitの画素データをARGBのWebPPictureに取り込む
+static int import_rgba_picture(WebPPicture* picture, const uint8_t* rgba, int stride, int width, int height) {
+    if (!WebPPictureInit(picture)) {
+        return 0;
+    }
+    picture->use_argb = 1;
+    picture->width = width;
+    picture->height = height;
+    return WebPPictureImportRGBA(picture, rgba, stride);
+}
+
+// 画像の歪み計算（libwebp examples/get_disto.c と同じ処理）
+NextImageStatus nextimage_image_distortion(
+    const uint8_t* reference_rgba,
+    int reference_stride,
+    const uint8_t* distorted_rgba,
+    int distorted_stride,
+    int width,
+    int height,
+    NextImageDistortionMetric metric,
+    int keep_alpha,
+    float result[5]
+) {
+    if (!reference_rgba || !distorted_rgba || !result || width <= 0 || height <= 0 ||
+        reference_stride < width * 4 || distorted_stride < width * 4) {
+        nextimage_set_error("Invalid parameters");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    if (metric != NEXTIMAGE_DISTORTION_PSNR && metric != NEXTIMAGE_DISTORTION_SSIM &&
+        metric != NEXTIMAGE_DISTORTION_LSIM) {
+        nextimage_set_error("Invalid distortion metric: %d", (int)metric);
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    WebPPicture reference;
+    WebPPicture distorted;
+    memset(&reference, 0, sizeof(reference));
+    memset(&distorted, 0, sizeof(distorted));
+
+    if (!import_rgba_picture(&reference, reference_rgba, reference_stride, width, height) ||
+        !import_rgba_picture(&distorted, distorted_rgba, distorted_stride, width, height)) {
+        WebPPictureFree(&reference);
+        WebPPictureFree(&distorted);
+        nextimage_set_error("Failed to import pictures");
+        return NEXTIMAGE_ERROR_OUT_OF_MEMORY;
+    }
+
+    // get_distoと同様、-alphaなしでは黒背景に合成してアルファで重み付けする
+    if (!keep_alpha) {
+        WebPBlendAlpha(&reference, 0x00000000);
+        WebPBlendAlpha(&distorted, 0x00000000);
+    }
+
+    int ok = WebPPictureDistortion(&distorted, &reference, (int)metric, result);
+    WebPPictureFree(&reference);
+    WebPPictureFree(&distorted);
+
+    if (!ok) {
+        nextimage_set_error("Failed to compute distortion");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+    return NEXTIMAGE_OK;
+}
+
 // GIF to WebP conversion
 // ========================================
 // WebP to GIF conversion helpers
@@ -1283,6 +1661,37 @@ NextImageStatus nextimage_webp_encoder_encode(
     return nextimage_webp_encode_alloc(input_data, input_size, &encoder->options, output);
 }
 
+// エンコーダーでエンコード（統計情報付き）
+NextImageStatus nextimage_webp_encoder_encode_with_stats(
+    NextImageWebPEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    NextImageWebPEncodeStats* stats
+) {
+    if (!encoder) {
+        nextimage_set_error("Invalid encoder instance");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_webp_encode_alloc_with_stats(input_data, input_size, &encoder->options, output, stats);
+}
+
+NextImageStatus nextimage_webp_encoder_encode_to_writer(
+    NextImageWebPEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    NextImageWebPEncodeStats* stats
+) {
+    if (!encoder) {
+        nextimage_set_error("Invalid encoder instance");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_webp_encode_to_writer(input_data, input_size, &encoder->options, writer, stats);
+}
+
 // エンコーダーの破棄
 void nextimage_webp_encoder_destroy(NextImageWebPEncoder* encoder) {
     if (encoder) {
@@ -1392,6 +1801,36 @@ NextImageStatus cwebp_run_command(
     return nextimage_webp_encoder_encode(cmd->encoder, input_data, input_size, output);
 }
 
+NextImageStatus cwebp_run_command_with_stats(
+    CWebPCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    NextImageWebPEncodeStats* stats
+) {
+    if (!cmd || !cmd->encoder) {
+        nextimage_set_error("Invalid CWebPCommand");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_webp_encoder_encode_with_stats(cmd->encoder, input_data, input_size, output, stats);
+}
+
+NextImageStatus cwebp_run_command_to_writer(
+    CWebPCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    NextImageWebPEncodeStats* stats
+) {
+    if (!cmd || !cmd->encoder) {
+        nextimage_set_error("Invalid CWebPCommand");
+        return NEXTIMAGE_ERROR_INVALID_PARAM;
+    }
+
+    return nextimage_webp_encoder_encode_to_writer(cmd->encoder, input_data, input_size, writer, stats);
+}
+
 void cwebp_free_command(CWebPCommand* cmd) {
     if (cmd) {
         if (cmd->encoder) {
@@ -1409,7 +1848,7 @@ static void stbi_write_to_buffer_callback(void* context, void* data, int size) {
     size_t new_size = buf->size + (size_t)size;
 
     // バッファを再割り当て
-    uint8_t* new_data = (uint8_t*)realloc(buf->data, new_size);
+    uint8_t* new_data = (uint8_t*)nextimage_realloc(buf->data, new_size);
     if (!new_data) {
         // メモリ割り当て失敗 - 現在のバッファは維持
         return;
@@ -0,0 +1,993 @@
+package libnextimage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ideamans/libnextimage/golang/internal/cmdline"
+)
+
+// Command line compatibility: each Command type can be configured from the argument list
+// of the upstream tool (ParseCWebPArgs, ParseAVIFEncArgs, ...) and turned back into one
+// with Args. Parsing starts from the NewDefault*Options values, and Args only writes the
+// fields that differ from them, so Parse(o.Args()) reproduces o for every field that has
+// an upstream flag. Metadata bytes, gain maps and other settings without a flag are left out.
+//
+// Arguments that are not options (input and output files, -o, -quiet, -h, ...) are
+// returned in order so that callers can handle them like the upstream tool does.
+
+// argSpec describes one upstream flag
+type argSpec[T any] = cmdline.Flag[T]
+
+// argSyntax holds the error messages of an upstream tool
+type argSyntax struct {
+	unknown     string // Unknown option, with the flag
+	missing     string // Missing value, with the flag
+	notInt      string // Malformed integer, with the flag and value
+	notFloat    string // Malformed number, with the flag and value
+	unsupported string // Upstream flag nextimage does not implement, with the flag
+}
+
+// webpSyntax is the style of cwebp and dwebp. Their flags only match when followed by
+// enough values, so a missing value is reported as an unknown option.
+var webpSyntax = argSyntax{
+	unknown:     "Unknown option '%s'",
+	missing:     "Unknown option '%s'",
+	notInt:      "Error! '%[2]s' is not an integer.",
+	notFloat:    "Error! '%[2]s' is not a floating point number.",
+	unsupported: "Error! Option '%s' is not supported by nextimage.",
+}
+
+// gif2webpSyntax is the style of gif2webp and webp2gif
+var gif2webpSyntax = argSyntax{
+	unknown:     "Unknown option [%s]",
+	missing:     "Unknown option [%s]",
+	notInt:      "Error! '%[2]s' is not an integer.",
+	notFloat:    "Error! '%[2]s' is not a floating point number.",
+	unsupported: "Error! Option [%s] is not supported by nextimage.",
+}
+
+// avifSyntax is the style of avifenc and avifdec
+var avifSyntax = argSyntax{
+	unknown:     "ERROR: unrecognized option %s",
+	missing:     "%s requires an argument.",
+	notInt:      "ERROR: invalid value for %s: %s",
+	notFloat:    "ERROR: invalid value for %s: %s",
+	unsupported: "ERROR: %s is not supported by nextimage",
+}
+
+// argValueError is a malformed value, reported in the syntax of the tool
+type argValueError struct {
+	float bool
+	value string
+}
+
+func (e *argValueError) Error() string {
+	return fmt.Sprintf("'%s' is not a number", e.value)
+}
+
+// errUnsupportedArg marks an upstream flag that nextimage does not implement
+var errUnsupportedArg = errors.New("unsupported option")
+
+// parseArgs applies the flags in args to o and returns the remaining arguments.
+// As in the upstream tools, "--" makes the next argument positional and "-" is stdin/stdout;
+// it is kept in the remaining arguments in front of the argument it escapes.
+func parseArgs[T any](syntax *argSyntax, specs []argSpec[T], o *T, args []string) ([]string, error) {
+	return cmdline.Parse(&cmdline.Syntax{
+		Unknown:       syntax.unknown,
+		Missing:       syntax.missing,
+		Value:         syntax.valueError,
+		KeepSeparator: true,
+	}, specs, o, args)
+}
+
+// valueError reports an error setting a flag in the syntax of the tool
+func (s *argSyntax) valueError(flag string, err error) error {
+	var valueErr *argValueError
+	switch {
+	case errors.Is(err, errUnsupportedArg):
+		return fmt.Errorf(s.unsupported, flag)
+	case !errors.As(err, &valueErr):
+		return err
+	case valueErr.float:
+		return fmt.Errorf(s.notFloat, flag, valueErr.value)
+	}
+	return fmt.Errorf(s.notInt, flag, valueErr.value)
+}
+
+// argInt parses a decimal integer value
+func argInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, &argValueError{value: s}
+	}
+	return n, nil
+}
+
+// argInts parses count integers separated by sep, e.g. "0,0,100,100".
+// A malformed list is reported with invalid, which takes the value.
+func argInts(s, sep string, count int, invalid string) ([]int, error) {
+	parts := strings.Split(s, sep)
+	if len(parts) != count {
+		return nil, fmt.Errorf(invalid, s)
+	}
+	values := make([]int, count)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf(invalid, s)
+		}
+		values[i] = n
+	}
+	return values, nil
+}
+
+func intArg[T any](field func(*T) *int, names ...string) argSpec[T] {
+	return argSpec[T]{Names: names, NArgs: 1, Set: func(o *T, v []string) error {
+		n, err := argInt(v[0])
+		*field(o) = n
+		return err
+	}}
+}
+
+func floatArg[T any](field func(*T) *float32, names ...string) argSpec[T] {
+	return argSpec[T]{Names: names, NArgs: 1, Set: func(o *T, v []string) error {
+		f, err := strconv.ParseFloat(v[0], 32)
+		if err != nil {
+			return &argValueError{float: true, value: v[0]}
+		}
+		*field(o) = float32(f)
+		return nil
+	}}
+}
+
+func boolArg[T any](field func(*T) *bool, value bool, names ...string) argSpec[T] {
+	return cmdline.Bool(field, value, names...)
+}
+
+// enumArg sets an int field from names; an unknown name is reported with invalid
+func enumArg[T any](field func(*T) *int, values argEnum, invalid string, names ...string) argSpec[T] {
+	return argSpec[T]{Names: names, NArgs: 1, Set: func(o *T, v []string) error {
+		n, ok := values.value(v[0])
+		if !ok {
+			return fmt.Errorf(invalid, v[0])
+		}
+		*field(o) = n
+		return nil
+	}}
+}
+
+// passArg keeps a flag that is not an option (output, verbosity, help) in the remaining arguments
+func passArg[T any](nargs int, names ...string) argSpec[T] {
+	return argSpec[T]{Names: names, NArgs: nargs, Pass: true}
+}
+
+// ignoredArg accepts a flag that has no effect in nextimage (assembly, progress, jobs)
+func ignoredArg[T any](nargs int, names ...string) argSpec[T] {
+	return cmdline.Ignored[T](nargs, names...)
+}
+
+// unsupportedArg rejects an upstream flag that nextimage does not implement
+func unsupportedArg[T any](nargs int, names ...string) argSpec[T] {
+	return argSpec[T]{Names: names, NArgs: nargs, Set: func(o *T, v []string) error {
+		return errUnsupportedArg
+	}}
+}
+
+// argEnum maps flag values to option values. The first name of a value is the one Args writes.
+type argEnum []struct {
+	name  string
+	value int
+}
+
+func (e argEnum) value(name string) (int, bool) {
+	for _, entry := range e {
+		if entry.name == name {
+			return entry.value, true
+		}
+	}
+	return 0, false
+}
+
+func (e argEnum) name(value int) (string, bool) {
+	for _, entry := range e {
+		if entry.value == value {
+			return entry.name, true
+		}
+	}
+	return "", false
+}
+
+// argWriter builds an argument list from the fields that differ from the defaults
+type argWriter []string
+
+func (w *argWriter) add(args ...string) {
+	*w = append(*w, args...)
+}
+
+func (w *argWriter) int(flag string, value, base int) {
+	if value != base {
+		w.add(flag, strconv.Itoa(value))
+	}
+}
+
+func (w *argWriter) float(flag string, value, base float32) {
+	if value != base {
+		w.add(flag, strconv.FormatFloat(float64(value), 'g', -1, 32))
+	}
+}
+
+func (w *argWriter) bool(flag string, value, base bool) {
+	if value && !base {
+		w.add(flag)
+	}
+}
+
+func (w *argWriter) enum(flag string, values argEnum, value, base int) {
+	if name, ok := values.name(value); ok && value != base {
+		w.add(flag, name)
+	}
+}
+
+func (w *argWriter) ints(flag, sep string, values, base []int) {
+	for i := range values {
+		if values[i] != base[i] {
+			parts := make([]string, len(values))
+			for j, v := range values {
+				parts[j] = strconv.Itoa(v)
+			}
+			w.add(flag, strings.Join(parts, sep))
+			return
+		}
+	}
+}
+
+// ========================================
+// cwebp
+// ========================================
+
+var webpPresetNames = argEnum{
+	{"default", int(PresetDefault)},
+	{"picture", int(PresetPicture)},
+	{"photo", int(PresetPhoto)},
+	{"drawing", int(PresetDrawing)},
+	{"icon", int(PresetIcon)},
+	{"text", int(PresetText)},
+}
+
+var webpHintNames = argEnum{
+	{"photo", int(HintPhoto)},
+	{"picture", int(HintPicture)},
+	{"graph", int(HintGraph)},
+}
+
+var webpAlphaFilterNames = argEnum{
+	{"none", int(AlphaFilterNone)},
+	{"fast", int(AlphaFilterFast)},
+	{"best", int(AlphaFilterBest)},
+}
+
+var webpResizeModeNames = argEnum{
+	{"always", int(ResizeModeAlways)},
+	{"up_only", int(ResizeModeUpOnly)},
+	{"down_only", int(ResizeModeDownOnly)},
+}
+
+// parseMetadataArg parses the -metadata list of cwebp
+func parseMetadataArg(s string) (int, error) {
+	keep := MetadataNone
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "all":
+			keep |= MetadataAll
+		case "none":
+			keep = MetadataNone
+		case "exif":
+			keep |= MetadataEXIF
+		case "icc":
+			keep |= MetadataICC
+		case "xmp":
+			keep |= MetadataXMP
+		default:
+			return 0, fmt.Errorf("Error! Unknown metadata type '%s'", name)
+		}
+	}
+	return keep, nil
+}
+
+// metadataArg formats a KeepMetadata value as a -metadata list
+func metadataArg(keep int) string {
+	if keep&MetadataAll == MetadataAll {
+		return "all"
+	}
+	var names []string
+	for _, m := range []struct {
+		flag int
+		name string
+	}{{MetadataEXIF, "exif"}, {MetadataICC, "icc"}, {MetadataXMP, "xmp"}} {
+		if keep&m.flag != 0 {
+			names = append(names, m.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+var cwebpArgSpecs = []argSpec[CWebPOptions]{
+	passArg[CWebPOptions](1, "-o"),
+	passArg[CWebPOptions](0, "-h", "-help", "-H", "-longhelp", "-version", "-quiet", "-short", "-v"),
+	ignoredArg[CWebPOptions](0, "-noasm", "-progress"),
+	floatArg(func(o *CWebPOptions) *float32 { return &o.Quality }, "-q"),
+	intArg(func(o *CWebPOptions) *int { return &o.AlphaQuality }, "-alpha_q"),
+	enumArg(func(o *CWebPOptions) *int { return &o.Preset }, webpPresetNames, "Error! Unrecognized preset: %s", "-preset"),
+	{Names: []string{"-z"}, NArgs: 1, Set: func(o *CWebPOptions, v []string) error {
+		n, err := argInt(v[0])
+		o.LosslessPreset = n
+		o.Lossless = true
+		return err
+	}},
+	intArg(func(o *CWebPOptions) *int { return &o.Method }, "-m"),
+	intArg(func(o *CWebPOptions) *int { return &o.Segments }, "-segments"),
+	intArg(func(o *CWebPOptions) *int { return &o.TargetSize }, "-size"),
+	floatArg(func(o *CWebPOptions) *float32 { return &o.TargetPSNR }, "-psnr"),
+	intArg(func(o *CWebPOptions) *int { return &o.SNSStrength }, "-sns"),
+	intArg(func(o *CWebPOptions) *int { return &o.FilterStrength }, "-f"),
+	intArg(func(o *CWebPOptions) *int { return &o.FilterSharpness }, "-sharpness"),
+	{Names: []string{"-strong"}, Set: func(o *CWebPOptions, v []string) error {
+		o.FilterType = int(FilterTypeStrong)
+		return nil
+	}},
+	{Names: []string{"-nostrong"}, Set: func(o *CWebPOptions, v []string) error {
+		o.FilterType = int(FilterTypeSimple)
+		return nil
+	}},
+	boolArg(func(o *CWebPOptions) *bool { return &o.Autofilter }, true, "-af"),
+	boolArg(func(o *CWebPOptions) *bool { return &o.UseSharpYUV }, true, "-sharp_yuv"),
+	intArg(func(o *CWebPOptions) *int { return &o.PartitionLimit }, "-partition_limit"),
+	intArg(func(o *CWebPOptions) *int { return &o.Pass }, "-pass"),
+	{Names: []string{"-qrange"}, NArgs: 2, Set: func(o *CWebPOptions, v []string) error {
+		var err error
+		if o.QMin, err = argInt(v[0]); err != nil {
+			return err
+		}
+		o.QMax, err = argInt(v[1])
+		return err
+	}},
+	{Names: []string{"-crop"}, NArgs: 4, Set: func(o *CWebPOptions, v []string) error {
+		var values [4]int
+		for i := range values {
+			n, err := argInt(v[i])
+			if err != nil {
+				return err
+			}
+			values[i] = n
+		}
+		o.CropX, o.CropY, o.CropWidth, o.CropHeight = values[0], values[1], values[2], values[3]
+		return nil
+	}},
+	{Names: []string{"-resize"}, NArgs: 2, Set: func(o *CWebPOptions, v []string) error {
+		var err error
+		if o.ResizeWidth, err = argInt(v[0]); err != nil {
+			return err
+		}
+		o.ResizeHeight, err = argInt(v[1])
+		return err
+	}},
+	{Names: []string{"-resize_mode"}, NArgs: 1, Set: func(o *CWebPOptions, v []string) error {
+		mode, ok := webpResizeModeNames.value(v[0])
+		if !ok {
+			return fmt.Errorf("Error! Unrecognized resize mode: %s", v[0])
+		}
+		o.ResizeMode = WebPResizeMode(mode)
+		return nil
+	}},
+	{Names: []string{"-mt"}, Set: func(o *CWebPOptions, v []string) error {
+		o.ThreadLevel = 1
+		return nil
+	}},
+	boolArg(func(o *CWebPOptions) *bool { return &o.LowMemory }, true, "-low_memory"),
+	intArg(func(o *CWebPOptions) *int { return &o.AlphaCompression }, "-alpha_method"),
+	enumArg(func(o *CWebPOptions) *int { return &o.AlphaFiltering }, webpAlphaFilterNames, "Error! Unrecognized alpha filter: %s", "-alpha_filter"),
+	boolArg(func(o *CWebPOptions) *bool { return &o.Exact }, true, "-exact"),
+	{Names: []string{"-blend_alpha"}, NArgs: 1, Set: func(o *CWebPOptions, v []string) error {
+		color, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(v[0]), "0x"), 16, 32)
+		if err != nil {
+			return &argValueError{value: v[0]}
+		}
+		o.BlendAlpha = true
+		o.BlendAlphaColor = uint32(color) & 0xffffff
+		return nil
+	}},
+	boolArg(func(o *CWebPOptions) *bool { return &o.NoAlpha }, true, "-noalpha"),
+	boolArg(func(o *CWebPOptions) *bool { return &o.Lossless }, true, "-lossless"),
+	intArg(func(o *CWebPOptions) *int { return &o.NearLossless }, "-near_lossless"),
+	enumArg(func(o *CWebPOptions) *int { return &o.ImageHint }, webpHintNames, "Error! Unrecognized image hint: %s", "-hint"),
+	{Names: []string{"-metadata"}, NArgs: 1, Set: func(o *CWebPOptions, v []string) error {
+		keep, err := parseMetadataArg(v[0])
+		o.KeepMetadata = keep
+		return err
+	}},
+	boolArg(func(o *CWebPOptions) *bool { return &o.EmulateJPEGSize }, true, "-jpeg_like"),
+	intArg(func(o *CWebPOptions) *int { return &o.Preprocessing }, "-pre"),
+	unsupportedArg[CWebPOptions](2, "-s"),
+	unsupportedArg[CWebPOptions](1, "-d", "-map"),
+	unsupportedArg[CWebPOptions](0, "-print_psnr", "-print_ssim", "-print_lsim"),
+}
+
+// ParseCWebPArgs parses a cwebp command line such as []string{"-q", "80", "-m", "6", "-sharp_yuv"}.
+// Input files and the -o, -quiet, -short, -v, -h and -version flags are returned in rest.
+// Unknown and malformed flags are rejected with cwebp's error messages.
+func ParseCWebPArgs(args []string) (opts CWebPOptions, rest []string, err error) {
+	opts = NewDefaultCWebPOptions()
+	rest, err = parseArgs(&webpSyntax, cwebpArgSpecs, &opts, args)
+	return opts, rest, err
+}
+
+// Args returns the cwebp flags that reproduce the options with ParseCWebPArgs
+func (o CWebPOptions) Args() []string {
+	base := NewDefaultCWebPOptions()
+	var w argWriter
+	w.enum("-preset", webpPresetNames, o.Preset, base.Preset) // cwebp applies -preset first
+	w.float("-q", o.Quality, base.Quality)
+	w.int("-alpha_q", o.AlphaQuality, base.AlphaQuality)
+	if o.Lossless && o.LosslessPreset != base.LosslessPreset { // -z implies -lossless
+		w.int("-z", o.LosslessPreset, -1)
+	} else {
+		w.bool("-lossless", o.Lossless, base.Lossless)
+	}
+	w.int("-m", o.Method, base.Method)
+	w.int("-segments", o.Segments, base.Segments)
+	w.int("-size", o.TargetSize, base.TargetSize)
+	w.float("-psnr", o.TargetPSNR, base.TargetPSNR)
+	w.int("-sns", o.SNSStrength, base.SNSStrength)
+	w.int("-f", o.FilterStrength, base.FilterStrength)
+	w.int("-sharpness", o.FilterSharpness, base.FilterSharpness)
+	if o.FilterType != base.FilterType {
+		if o.FilterType == int(FilterTypeSimple) {
+			w.add("-nostrong")
+		} else {
+			w.add("-strong")
+		}
+	}
+	w.bool("-af", o.Autofilter, base.Autofilter)
+	w.bool("-sharp_yuv", o.UseSharpYUV, base.UseSharpYUV)
+	w.int("-partition_limit", o.PartitionLimit, base.PartitionLimit)
+	w.int("-pass", o.Pass, base.Pass)
+	if o.QMin != base.QMin || o.QMax != base.QMax {
+		w.add("-qrange", strconv.Itoa(o.QMin), strconv.Itoa(o.QMax))
+	}
+	if cropEnabled(o.CropX, o.CropY, o.CropWidth, o.CropHeight) {
+		w.add("-crop", strconv.Itoa(o.CropX), strconv.Itoa(o.CropY), strconv.Itoa(o.CropWidth), strconv.Itoa(o.CropHeight))
+	}
+	if resizeEnabled(o.ResizeWidth, o.ResizeHeight) {
+		w.add("-resize", strconv.Itoa(o.ResizeWidth), strconv.Itoa(o.ResizeHeight))
+	}
+	w.enum("-resize_mode", webpResizeModeNames, int(o.ResizeMode), int(base.ResizeMode))
+	w.bool("-mt", o.ThreadLevel > 0, base.ThreadLevel > 0)
+	w.bool("-low_memory", o.LowMemory, base.LowMemory)
+	w.int("-alpha_method", o.AlphaCompression, base.AlphaCompression)
+	w.enum("-alpha_filter", webpAlphaFilterNames, o.AlphaFiltering, base.AlphaFiltering)
+	w.bool("-exact", o.Exact, base.Exact)
+	if o.BlendAlpha {
+		w.add("-blend_alpha", fmt.Sprintf("0x%06x", o.BlendAlphaColor))
+	}
+	w.bool("-noalpha", o.NoAlpha, base.NoAlpha)
+	w.int("-near_lossless", o.NearLossless, base.NearLossless)
+	w.enum("-hint", webpHintNames, o.ImageHint, base.ImageHint)
+	if o.KeepMetadata != base.KeepMetadata {
+		w.add("-metadata", metadataArg(o.KeepMetadata))
+	}
+	w.bool("-jpeg_like", o.EmulateJPEGSize, base.EmulateJPEGSize)
+	w.int("-pre", o.Preprocessing, base.Preprocessing)
+	return w
+}
+
+// ========================================
+// dwebp
+// ========================================
+
+var dwebpArgSpecs = []argSpec[DWebPOptions]{
+	passArg[DWebPOptions](1, "-o"),
+	passArg[DWebPOptions](0, "-h", "-help", "-version", "-quiet", "-v"),
+	ignoredArg[DWebPOptions](0, "-png", "-nodither", "-noasm"),
+	boolArg(func(o *DWebPOptions) *bool { return &o.NoFancyUpsampling }, true, "-nofancy"),
+	boolArg(func(o *DWebPOptions) *bool { return &o.BypassFiltering }, true, "-nofilter"),
+	boolArg(func(o *DWebPOptions) *bool { return &o.UseThreads }, true, "-mt"),
+	boolArg(func(o *DWebPOptions) *bool { return &o.Flip }, true, "-flip"),
+	{Names: []string{"-crop"}, NArgs: 4, Set: func(o *DWebPOptions, v []string) error {
+		var values [4]int
+		for i := range values {
+			n, err := argInt(v[i])
+			if err != nil {
+				return err
+			}
+			values[i] = n
+		}
+		o.CropX, o.CropY, o.CropWidth, o.CropHeight = values[0], values[1], values[2], values[3]
+		o.UseCrop = true
+		return nil
+	}},
+	{Names: []string{"-resize", "-scale"}, NArgs: 2, Set: func(o *DWebPOptions, v []string) error {
+		var err error
+		if o.ResizeWidth, err = argInt(v[0]); err != nil {
+			return err
+		}
+		if o.ResizeHeight, err = argInt(v[1]); err != nil {
+			return err
+		}
+		o.UseResize = true
+		return nil
+	}},
+	unsupportedArg[DWebPOptions](0, "-pam", "-ppm", "-bmp", "-tiff", "-pgm", "-yuv", "-alpha_dither", "-alpha", "-incremental"),
+	unsupportedArg[DWebPOptions](1, "-dither"),
+}
+
+// ParseDWebPArgs parses a dwebp command line such as []string{"-nofancy", "-mt", "in.webp", "-o", "out.png"}.
+// Input files and the -o, -quiet, -v, -h and -version flags are returned in rest.
+// Unknown and malformed flags are rejected with dwebp's error messages.
+func ParseDWebPArgs(args []string) (opts DWebPOptions, rest []string, err error) {
+	opts = NewDefaultDWebPOptions()
+	rest, err = parseArgs(&webpSyntax, dwebpArgSpecs, &opts, args)
+	return opts, rest, err
+}
+
+// Args returns the dwebp flags that reproduce the options with ParseDWebPArgs.
+// The output format is not included: dwebp selects it with the output flags.
+func (o DWebPOptions) Args() []string {
+	base := NewDefaultDWebPOptions()
+	var w argWriter
+	w.bool("-nofancy", o.NoFancyUpsampling, base.NoFancyUpsampling)
+	w.bool("-nofilter", o.BypassFiltering, base.BypassFiltering)
+	w.bool("-mt", o.UseThreads, base.UseThreads)
+	if o.UseCrop {
+		w.add("-crop", strconv.Itoa(o.CropX), strconv.Itoa(o.CropY), strconv.Itoa(o.CropWidth), strconv.Itoa(o.CropHeight))
+	}
+	if o.UseResize {
+		w.add("-resize", strconv.Itoa(o.ResizeWidth), strconv.Itoa(o.ResizeHeight))
+	}
+	w.bool("-flip", o.Flip, base.Flip)
+	return w
+}
+
+// ========================================
+// gif2webp
+// ========================================
+
+var gif2webpArgSpecs = []argSpec[Gif2WebPOptions]{
+	passArg[Gif2WebPOptions](1, "-o"),
+	passArg[Gif2WebPOptions](0, "-h", "-help", "-version", "-quiet", "-v"),
+	ignoredArg[Gif2WebPOptions](0, "-noasm"),
+	boolArg(func(o *Gif2WebPOptions) *bool { return &o.Lossless }, false, "-lossy"),
+	{Names: []string{"-mixed"}, Set: func(o *Gif2WebPOptions, v []string) error {
+		o.AllowMixed = true
+		o.Lossless = false
+		return nil
+	}},
+	intArg(func(o *Gif2WebPOptions) *int { return &o.NearLossless }, "-near_lossless"),
+	boolArg(func(o *Gif2WebPOptions) *bool { return &o.UseSharpYUV }, true, "-sharp_yuv"),
+	floatArg(func(o *Gif2WebPOptions) *float32 { return &o.Quality }, "-q"),
+	intArg(func(o *Gif2WebPOptions) *int { return &o.Method }, "-m"),
+	boolArg(func(o *Gif2WebPOptions) *bool { return &o.MinimizeSize }, true, "-min_size"),
+	intArg(func(o *Gif2WebPOptions) *int { return &o.Kmin }, "-kmin"),
+	intArg(func(o *Gif2WebPOptions) *int { return &o.Kmax }, "-kmax"),
+	intArg(func(o *Gif2WebPOptions) *int { return &o.FilterStrength }, "-f"),
+	{Names: []string{"-mt"}, Set: func(o *Gif2WebPOptions, v []string) error {
+		o.ThreadLevel = 1
+		return nil
+	}},
+	boolArg(func(o *Gif2WebPOptions) *bool { return &o.LoopCompatibility }, true, "-loop_compatibility"),
+	unsupportedArg[Gif2WebPOptions](1, "-metadata"),
+}
+
+// gif2webpArgsBase returns the options gif2webp starts from: lossless unless -lossy or -mixed
+func gif2webpArgsBase() Gif2WebPOptions {
+	opts := NewDefaultGif2WebPOptions()
+	opts.Lossless = true
+	return opts
+}
+
+// ParseGif2WebPArgs parses a gif2webp command line such as []string{"-lossy", "-q", "70", "in.gif", "-o", "out.webp"}.
+// As with gif2webp, frames are encoded losslessly unless -lossy or -mixed is given.
+// Input files and the -o, -quiet, -v, -h and -version flags are returned in rest.
+// Unknown and malformed flags are rejected with gif2webp's error messages.
+func ParseGif2WebPArgs(args []string) (opts Gif2WebPOptions, rest []string, err error) {
+	opts = gif2webpArgsBase()
+	rest, err = parseArgs(&gif2webpSyntax, gif2webpArgSpecs, &opts, args)
+	return opts, rest, err
+}
+
+// Args returns the gif2webp flags that reproduce the options with ParseGif2WebPArgs
+func (o Gif2WebPOptions) Args() []string {
+	base := gif2webpArgsBase()
+	var w argWriter
+	switch {
+	case o.AllowMixed:
+		w.add("-mixed")
+	case !o.Lossless:
+		w.add("-lossy")
+	}
+	w.float("-q", o.Quality, base.Quality)
+	w.int("-m", o.Method, base.Method)
+	w.int("-near_lossless", o.NearLossless, base.NearLossless)
+	w.bool("-sharp_yuv", o.UseSharpYUV, base.UseSharpYUV)
+	w.bool("-min_size", o.MinimizeSize, base.MinimizeSize)
+	w.int("-kmin", o.Kmin, base.Kmin)
+	w.int("-kmax", o.Kmax, base.Kmax)
+	w.int("-f", o.FilterStrength, base.FilterStrength)
+	w.bool("-mt", o.ThreadLevel > 0, base.ThreadLevel > 0)
+	w.bool("-loop_compatibility", o.LoopCompatibility, base.LoopCompatibility)
+	return w
+}
+
+// ========================================
+// webp2gif
+// ========================================
+
+var webp2gifArgSpecs = []argSpec[WebP2GifOptions]{
+	passArg[WebP2GifOptions](1, "-o"),
+	passArg[WebP2GifOptions](0, "-h", "-help", "-version", "-quiet"),
+}
+
+// ParseWebP2GifArgs parses a webp2gif command line such as []string{"in.webp", "-o", "out.gif"}.
+// webp2gif has no encoding options yet: input files and the -o, -quiet, -h and -version flags
+// are returned in rest, and any other flag is rejected.
+func ParseWebP2GifArgs(args []string) (opts WebP2GifOptions, rest []string, err error) {
+	opts = NewDefaultWebP2GifOptions()
+	rest, err = parseArgs(&gif2webpSyntax, webp2gifArgSpecs, &opts, args)
+	return opts, rest, err
+}
+
+// Args returns the webp2gif flags that reproduce the options (currently none)
+func (o WebP2GifOptions) Args() []string {
+	return nil
+}
+
+// ========================================
+// get_disto
+// ========================================
+
+var getDistoArgSpecs = []argSpec[GetDistoOptions]{
+	passArg[GetDistoOptions](0, "-h", "-help"),
+	{Names: []string{"-psnr"}, Set: func(o *GetDistoOptions, v []string) error {
+		o.Metric = int(DistortionPSNR)
+		return nil
+	}},
+	{Names: []string{"-ssim"}, Set: func(o *GetDistoOptions, v []string) error {
+		o.Metric = int(DistortionSSIM)
+		return nil
+	}},
+	{Names: []string{"-lsim"}, Set: func(o *GetDistoOptions, v []string) error {
+		o.Metric = int(DistortionLSIM)
+		return nil
+	}},
+	boolArg(func(o *GetDistoOptions) *bool { return &o.KeepAlpha }, true, "-alpha"),
+	ignoredArg[GetDistoOptions](0, "-pause"),
+	unsupportedArg[GetDistoOptions](0, "-scale", "-gray"),
+	unsupportedArg[GetDistoOptions](1, "-o", "-boost"),
+}
+
+// ParseGetDistoArgs parses a get_disto command line such as []string{"-ssim", "out.webp", "in.png"}.
+// The compared files and -h are returned in rest. Unknown flags are rejected.
+func ParseGetDistoArgs(args []string) (opts GetDistoOptions, rest []string, err error) {
+	opts = NewDefaultGetDistoOptions()
+	rest, err = parseArgs(&webpSyntax, getDistoArgSpecs, &opts, args)
+	return opts, rest, err
+}
+
+// Args returns the get_disto flags that reproduce the options with ParseGetDistoArgs
+func (o GetDistoOptions) Args() []string {
+	base := NewDefaultGetDistoOptions()
+	var w argWriter
+	if o.Metric != base.Metric {
+		switch DistortionMetric(o.Metric) {
+		case DistortionPSNR:
+			w.add("-psnr")
+		case DistortionSSIM:
+			w.add("-ssim")
+		case DistortionLSIM:
+			w.add("-lsim")
+		}
+	}
+	w.bool("-alpha", o.KeepAlpha, base.KeepAlpha)
+	return w
+}
+
+// ========================================
+// avifenc
+// ========================================
+
+// avifencArgState tracks the flags --lossless depends on
+type avifencArgState struct {
+	opts     AVIFEncOptions
+	lossless bool
+	yuvSet   bool // -y given, kept by --lossless
+	cicpSet  bool // --cicp given, kept by --lossless
+}
+
+var avifYUVFormatNames = argEnum{{"444", 0}, {"422", 1}, {"420", 2}, {"400", 3}}
+
+var avifYUVRangeNames = argEnum{{"full", 1}, {"limited", 0}, {"f", 1}, {"l", 0}}
+
+// avifencIntsArg sets fields from a list such as --crop 0,0,100,100
+func avifencIntsArg(sep string, count int, invalid string, set func(o *AVIFEncOptions, values []int), names ...string) argSpec[avifencArgState] {
+	return argSpec[avifencArgState]{Names: names, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		values, err := argInts(v[0], sep, count, invalid)
+		if err != nil {
+			return err
+		}
+		set(&s.opts, values)
+		return nil
+	}}
+}
+
+// avifencFileArg reads a metadata file given with --exif, --xmp or --icc
+func avifencFileArg(field func(o *AVIFEncOptions) *[]byte, what string, names ...string) argSpec[avifencArgState] {
+	return argSpec[avifencArgState]{Names: names, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		data, err := os.ReadFile(v[0])
+		if err != nil {
+			return fmt.Errorf("ERROR: Unable to read %s metadata: %s", what, v[0])
+		}
+		*field(&s.opts) = data
+		return nil
+	}}
+}
+
+func avifencOpt(field func(o *AVIFEncOptions) *int) func(s *avifencArgState) *int {
+	return func(s *avifencArgState) *int { return field(&s.opts) }
+}
+
+var avifencArgSpecs = []argSpec[avifencArgState]{
+	passArg[avifencArgState](1, "-o", "--output"),
+	passArg[avifencArgState](0, "-h", "--help", "-V", "--version"),
+	ignoredArg[avifencArgState](1, "-j", "--jobs"),
+	ignoredArg[avifencArgState](0, "--no-overwrite"),
+	boolArg(func(s *avifencArgState) *bool { return &s.lossless }, true, "-l", "--lossless"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.Quality }), "-q", "--qcolor"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.QualityAlpha }), "--qalpha"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.Speed }), "-s", "--speed"),
+	{Names: []string{"-d", "--depth"}, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		switch v[0] {
+		case "8", "10", "12":
+			s.opts.BitDepth, _ = strconv.Atoi(v[0])
+			return nil
+		}
+		return fmt.Errorf("ERROR: invalid depth: %s", v[0])
+	}},
+	{Names: []string{"-y", "--yuv"}, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		format, ok := avifYUVFormatNames.value(v[0])
+		if !ok {
+			return fmt.Errorf("ERROR: invalid format: %s", v[0])
+		}
+		s.opts.YUVFormat = format
+		s.yuvSet = true
+		return nil
+	}},
+	enumArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.YUVRange }), avifYUVRangeNames, "ERROR: Unknown range: %s", "-r", "--range"),
+	boolArg(func(s *avifencArgState) *bool { return &s.opts.PremultiplyAlpha }, true, "-p", "--premultiply"),
+	boolArg(func(s *avifencArgState) *bool { return &s.opts.SharpYUV }, true, "--sharpyuv"),
+	{Names: []string{"--cicp", "--nclx"}, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		values, err := argInts(v[0], "/", 3, "ERROR: Invalid CICP value: %s")
+		if err != nil {
+			return err
+		}
+		s.opts.ColorPrimaries, s.opts.TransferCharacteristics, s.opts.MatrixCoefficients = values[0], values[1], values[2]
+		s.cicpSet = true
+		return nil
+	}},
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.MinQuantizer }), "--min"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.MaxQuantizer }), "--max"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.MinQuantizerAlpha }), "--minalpha"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.MaxQuantizerAlpha }), "--maxalpha"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.TileRowsLog2 }), "--tilerowslog2"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.TileColsLog2 }), "--tilecolslog2"),
+	avifencIntsArg("x", 2, "ERROR: Invalid grid dims: %s", func(o *AVIFEncOptions, v []int) {
+		o.GridCols, o.GridRows = v[0], v[1]
+	}, "--grid"),
+	avifencFileArg(func(o *AVIFEncOptions) *[]byte { return &o.EXIFData }, "Exif", "--exif"),
+	avifencFileArg(func(o *AVIFEncOptions) *[]byte { return &o.XMPData }, "XMP", "--xmp"),
+	avifencFileArg(func(o *AVIFEncOptions) *[]byte { return &o.ICCData }, "ICC", "--icc"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.IrotAngle }), "--irot"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.ImirAxis }), "--imir"),
+	avifencIntsArg(",", 2, "ERROR: Invalid pasp values: %s", func(o *AVIFEncOptions, v []int) {
+		copy(o.PASP[:], v)
+	}, "--pasp"),
+	avifencIntsArg(",", 4, "ERROR: Invalid crop values: %s", func(o *AVIFEncOptions, v []int) {
+		copy(o.Crop[:], v)
+	}, "--crop"),
+	avifencIntsArg(",", 8, "ERROR: Invalid clap values: %s", func(o *AVIFEncOptions, v []int) {
+		copy(o.CLAP[:], v)
+	}, "--clap"),
+	avifencIntsArg(",", 2, "ERROR: Invalid clli values: %s", func(o *AVIFEncOptions, v []int) {
+		o.CLLIMaxCLL, o.CLLIMaxPALL = v[0], v[1]
+	}, "--clli"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.Timescale }), "--timescale", "--fps"),
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.KeyframeInterval }), "-k", "--keyframe"),
+	{Names: []string{"-a", "--advanced"}, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		key, value, ok := strings.Cut(v[0], "=")
+		if !ok {
+			value = "1"
+		}
+		if s.opts.CodecOptions == nil {
+			s.opts.CodecOptions = make(map[string]string)
+		}
+		s.opts.CodecOptions[key] = value
+		return nil
+	}},
+	intArg(avifencOpt(func(o *AVIFEncOptions) *int { return &o.TargetSize }), "--target-size"),
+	{Names: []string{"--progressive"}, Set: func(s *avifencArgState, v []string) error {
+		s.opts.ProgressiveLayers = 2
+		return nil
+	}},
+	{Names: []string{"-c", "--codec"}, NArgs: 1, Set: func(s *avifencArgState, v []string) error {
+		if v[0] != "aom" {
+			return fmt.Errorf("ERROR: Unrecognized codec: %s", v[0])
+		}
+		return nil
+	}},
+	unsupportedArg[avifencArgState](0, "--autotiling", "--layered", "--stdin", "--ignore-exif", "--ignore-xmp", "--ignore-icc", "--ignore-profile"),
+	unsupportedArg[avifencArgState](1, "--duration", "--repetition-count", "--qgain-map"),
+}
+
+// ParseAVIFEncArgs parses an avifenc command line such as []string{"-q", "60", "-s", "6", "in.png", "out.avif"}.
+// --exif, --xmp and --icc read the named files. --lossless applies after the other flags,
+// as in avifenc: quality 100, full range, 4:4:4 and the identity matrix unless -y or --cicp is given.
+// Input and output files and the -o, -h and -V flags are returned in rest.
+// Unknown and malformed flags are rejected with avifenc's error messages.
+func ParseAVIFEncArgs(args []string) (opts AVIFEncOptions, rest []string, err error) {
+	s := avifencArgState{opts: NewDefaultAVIFEncOptions()}
+	rest, err = parseArgs(&avifSyntax, avifencArgSpecs, &s, args)
+	if s.lossless {
+		s.opts.Quality = 100
+		s.opts.QualityAlpha = 100
+		s.opts.YUVRange = 1
+		if !s.yuvSet {
+			s.opts.YUVFormat = 0
+		}
+		if !s.cicpSet {
+			s.opts.MatrixCoefficients = 0
+		}
+	}
+	return s.opts, rest, err
+}
+
+// Args returns the avifenc flags that reproduce the options with ParseAVIFEncArgs.
+// Metadata, gain map and HDR input settings have no flag and are not included.
+func (o AVIFEncOptions) Args() []string {
+	base := NewDefaultAVIFEncOptions()
+	var w argWriter
+	w.int("-q", o.Quality, base.Quality)
+	w.int("--qalpha", o.QualityAlpha, base.QualityAlpha)
+	w.int("-s", o.Speed, base.Speed)
+	w.int("-d", o.BitDepth, base.BitDepth)
+	w.enum("-y", avifYUVFormatNames, o.YUVFormat, base.YUVFormat)
+	w.enum("-r", avifYUVRangeNames, o.YUVRange, base.YUVRange)
+	w.bool("-p", o.PremultiplyAlpha, base.PremultiplyAlpha)
+	w.bool("--sharpyuv", o.SharpYUV, base.SharpYUV)
+	w.ints("--cicp", "/",
+		[]int{o.ColorPrimaries, o.TransferCharacteristics, o.MatrixCoefficients},
+		[]int{base.ColorPrimaries, base.TransferCharacteristics, base.MatrixCoefficients})
+	w.int("--min", o.MinQuantizer, base.MinQuantizer)
+	w.int("--max", o.MaxQuantizer, base.MaxQuantizer)
+	w.int("--minalpha", o.MinQuantizerAlpha, base.MinQuantizerAlpha)
+	w.int("--maxalpha", o.MaxQuantizerAlpha, base.MaxQuantizerAlpha)
+	w.int("--tilerowslog2", o.TileRowsLog2, base.TileRowsLog2)
+	w.int("--tilecolslog2", o.TileColsLog2, base.TileColsLog2)
+	w.ints("--grid", "x", []int{o.GridCols, o.GridRows}, []int{base.GridCols, base.GridRows})
+	w.int("--irot", o.IrotAngle, base.IrotAngle)
+	w.int("--imir", o.ImirAxis, base.ImirAxis)
+	w.ints("--pasp", ",", o.PASP[:], base.PASP[:])
+	w.ints("--crop", ",", o.Crop[:], base.Crop[:])
+	w.ints("--clap", ",", o.CLAP[:], base.CLAP[:])
+	w.ints("--clli", ",", []int{o.CLLIMaxCLL, o.CLLIMaxPALL}, []int{base.CLLIMaxCLL, base.CLLIMaxPALL})
+	w.int("--timescale", o.Timescale, base.Timescale)
+	w.int("-k", o.KeyframeInterval, base.KeyframeInterval)
+	w.int("--target-size", o.TargetSize, base.TargetSize)
+	if o.ProgressiveLayers > 0 {
+		w.add("--progressive")
+	}
+	keys := make([]string, 0, len(o.CodecOptions))
+	for key := range o.CodecOptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.add("-a", key+"="+o.CodecOptions[key])
+	}
+	return w
+}
+
+// ========================================
+// avifdec
+// ========================================
+
+var avifChromaUpsamplingNames = argEnum{
+	{"automatic", 0},
+	{"fastest", 1},
+	{"best", 2},
+	{"nearest", 3},
+	{"bilinear", 4},
+}
+
+var avifdecArgSpecs = []argSpec[AVIFDecOptions]{
+	passArg[AVIFDecOptions](1, "-o", "--output"),
+	passArg[AVIFDecOptions](0, "-h", "--help", "-V", "--version"),
+	{Names: []string{"-j", "--jobs"}, NArgs: 1, Set: func(o *AVIFDecOptions, v []string) error {
+		if v[0] == "all" {
+			o.UseThreads = true
+			return nil
+		}
+		n, err := argInt(v[0])
+		o.UseThreads = n > 1
+		return err
+	}},
+	{Names: []string{"-c", "--codec"}, NArgs: 1, Set: func(o *AVIFDecOptions, v []string) error {
+		if v[0] != "aom" && v[0] != "dav1d" {
+			return fmt.Errorf("ERROR: Unrecognized codec: %s", v[0])
+		}
+		return nil
+	}},
+	{Names: []string{"-d", "--depth"}, NArgs: 1, Set: func(o *AVIFDecOptions, v []string) error {
+		if v[0] != "8" {
+			return fmt.Errorf("ERROR: invalid depth: %s", v[0])
+		}
+		return nil
+	}},
+	intArg(func(o *AVIFDecOptions) *int { return &o.JPEGQuality }, "-q", "--quality"),
+	enumArg(func(o *AVIFDecOptions) *int { return &o.ChromaUpsampling }, avifChromaUpsamplingNames, "ERROR: invalid upsampling: %s", "-u", "--upsampling"),
+	{Names: []string{"--no-strict"}, Set: func(o *AVIFDecOptions, v []string) error {
+		o.StrictFlags = 0
+		return nil
+	}},
+	boolArg(func(o *AVIFDecOptions) *bool { return &o.IgnoreICC }, true, "--ignore-icc"),
+	boolArg(func(o *AVIFDecOptions) *bool { return &o.IgnoreExif }, true, "--ignore-exif"),
+	boolArg(func(o *AVIFDecOptions) *bool { return &o.IgnoreXMP }, true, "--ignore-xmp"),
+	{Names: []string{"--size-limit"}, NArgs: 1, Set: func(o *AVIFDecOptions, v []string) error {
+		n, err := argInt(v[0])
+		o.ImageSizeLimit = uint32(n)
+		return err
+	}},
+	{Names: []string{"--dimension-limit"}, NArgs: 1, Set: func(o *AVIFDecOptions, v []string) error {
+		n, err := argInt(v[0])
+		o.ImageDimensionLimit = uint32(n)
+		return err
+	}},
+	unsupportedArg[AVIFDecOptions](0, "-r", "--raw-color", "--progressive", "-i", "--info"),
+	unsupportedArg[AVIFDecOptions](1, "--index", "--png-compress"),
+}
+
+// ParseAVIFDecArgs parses an avifdec command line such as []string{"-j", "all", "in.avif", "out.png"}.
+// Input and output files and the -o, -h and -V flags are returned in rest; the output format
+// follows the output file extension in avifdec and is left to the caller.
+// Unknown and malformed flags are rejected with avifdec's error messages.
+func ParseAVIFDecArgs(args []string) (opts AVIFDecOptions, rest []string, err error) {
+	opts = NewDefaultAVIFDecOptions()
+	rest, err = parseArgs(&avifSyntax, avifdecArgSpecs, &opts, args)
+	return opts, rest, err
+}
+
+// Args returns the avifdec flags that reproduce the options with ParseAVIFDecArgs.
+// The output format, tone mapping and gain map settings have no flag and are not included.
+func (o AVIFDecOptions) Args() []string {
+	base := NewDefaultAVIFDecOptions()
+	var w argWriter
+	if o.UseThreads && !base.UseThreads {
+		w.add("-j", "all")
+	}
+	w.int("-q", o.JPEGQuality, base.JPEGQuality)
+	w.enum("-u", avifChromaUpsamplingNames, o.ChromaUpsampling, base.ChromaUpsampling)
+	if o.StrictFlags == 0 && base.StrictFlags != 0 {
+		w.add("--no-strict")
+	}
+	w.bool("--ignore-icc", o.IgnoreICC, base.IgnoreICC)
+	w.bool("--ignore-exif", o.IgnoreExif, base.IgnoreExif)
+	w.bool("--ignore-xmp", o.IgnoreXMP, base.IgnoreXMP)
+	w.int("--size-limit", int(o.ImageSizeLimit), int(base.ImageSizeLimit))
+	w.int("--dimension-limit", int(o.ImageDimensionLimit), int(base.ImageDimensionLimit))
+	return w
+}
@@ -0,0 +1,246 @@
+package libnextimage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestParseCWebPArgs tests cwebp flag parsing and the arguments left to the caller
+func TestParseCWebPArgs(t *testing.T) {
+	opts, rest, err := ParseCWebPArgs(strings.Fields(
+		"-q 80 -m 6 -sharp_yuv -preset photo -crop 1 2 30 40 -resize 16 0 -blend_alpha 0xffffff -mt -metadata exif,icc -quiet in.png -o out.webp"))
+	if err != nil {
+		t.Fatalf("ParseCWebPArgs failed: %v", err)
+	}
+	if opts.Quality != 80 || opts.Method != 6 || !opts.UseSharpYUV || opts.Preset != int(PresetPhoto) || opts.ThreadLevel != 1 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if opts.CropX != 1 || opts.CropY != 2 || opts.CropWidth != 30 || opts.CropHeight != 40 ||
+		opts.ResizeWidth != 16 || opts.ResizeHeight != 0 || !opts.BlendAlpha || opts.BlendAlphaColor != 0xffffff {
+		t.Errorf("unexpected transforms %+v", opts)
+	}
+	if opts.KeepMetadata != MetadataEXIF|MetadataICC {
+		t.Errorf("unexpected metadata %d", opts.KeepMetadata)
+	}
+	if want := []string{"-quiet", "in.png", "-o", "out.webp"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("rest %q, expected %q", rest, want)
+	}
+
+	opts, _, err = ParseCWebPArgs([]string{"-z", "9"})
+	if err != nil || !opts.Lossless || opts.LosslessPreset != 9 {
+		t.Errorf("-z 9: lossless %v preset %d (%v)", opts.Lossless, opts.LosslessPreset, err)
+	}
+
+	t.Logf("✓ cwebp arguments")
+}
+
+// TestParseArgs_Errors tests that invalid flags are rejected with the upstream messages
+func TestParseArgs_Errors(t *testing.T) {
+	parsers := map[string]func([]string) error{
+		"cwebp": func(args []string) error {
+			_, _, err := ParseCWebPArgs(args)
+			return err
+		},
+		"dwebp": func(args []string) error {
+			_, _, err := ParseDWebPArgs(args)
+			return err
+		},
+		"gif2webp": func(args []string) error {
+			_, _, err := ParseGif2WebPArgs(args)
+			return err
+		},
+		"avifenc": func(args []string) error {
+			_, _, err := ParseAVIFEncArgs(args)
+			return err
+		},
+		"avifdec": func(args []string) error {
+			_, _, err := ParseAVIFDecArgs(args)
+			return err
+		},
+	}
+
+	tests := []struct {
+		tool string
+		args string
+		want string
+	}{
+		{"cwebp", "-foo in.png", "Unknown option '-foo'"},
+		{"cwebp", "in.png -q", "Unknown option '-q'"},
+		{"cwebp", "-q high", "Error! 'high' is not a floating point number."},
+		{"cwebp", "-m fast", "Error! 'fast' is not an integer."},
+		{"cwebp", "-crop 1 2 3 x", "Error! 'x' is not an integer."},
+		{"cwebp", "-preset nope", "Error! Unrecognized preset: nope"},
+		{"cwebp", "-hint nope", "Error! Unrecognized image hint: nope"},
+		{"cwebp", "-metadata exif,gps", "Error! Unknown metadata type 'gps'"},
+		{"cwebp", "-print_psnr", "Error! Option '-print_psnr' is not supported by nextimage."},
+		{"dwebp", "-foo", "Unknown option '-foo'"},
+		{"dwebp", "-scale 10 y", "Error! 'y' is not an integer."},
+		{"gif2webp", "-foo", "Unknown option [-foo]"},
+		{"gif2webp", "-kmin", "Unknown option [-kmin]"},
+		{"avifenc", "--foo", "ERROR: unrecognized option --foo"},
+		{"avifenc", "in.png -q", "-q requires an argument."},
+		{"avifenc", "-y 411", "ERROR: invalid format: 411"},
+		{"avifenc", "-r half", "ERROR: Unknown range: half"},
+		{"avifenc", "-d 9", "ERROR: invalid depth: 9"},
+		{"avifenc", "--cicp 1/13", "ERROR: Invalid CICP value: 1/13"},
+		{"avifenc", "--grid 2by2", "ERROR: Invalid grid dims: 2by2"},
+		{"avifenc", "-c rav1e", "ERROR: Unrecognized codec: rav1e"},
+		{"avifdec", "--info", "ERROR: --info is not supported by nextimage"},
+		{"avifdec", "-u smooth", "ERROR: invalid upsampling: smooth"},
+	}
+	for _, tt := range tests {
+		err := parsers[tt.tool](strings.Fields(tt.args))
+		if err == nil {
+			t.Errorf("%s %s: expected error", tt.tool, tt.args)
+		} else if err.Error() != tt.want {
+			t.Errorf("%s %s: got %q, expected %q", tt.tool, tt.args, err, tt.want)
+		}
+	}
+
+	t.Logf("✓ %d errors", len(tests))
+}
+
+// TestParseAVIFEncArgs tests avifenc parsing, including --lossless and metadata files
+func TestParseAVIFEncArgs(t *testing.T) {
+	xmp := filepath.Join("..", "testdata", "metadata", "test.xmp")
+	opts, rest, err := ParseAVIFEncArgs([]string{
+		"--qcolor", "70", "-s", "8", "-y", "420", "-r", "limited", "--cicp", "9/16/9",
+		"--grid", "2x3", "--crop", "0,0,32,32", "-a", "tune=ssim", "--xmp", xmp, "-j", "4", "in.png", "out.avif"})
+	if err != nil {
+		t.Fatalf("ParseAVIFEncArgs failed: %v", err)
+	}
+	if opts.Quality != 70 || opts.Speed != 8 || opts.YUVFormat != 2 || opts.YUVRange != 0 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if opts.ColorPrimaries != 9 || opts.TransferCharacteristics != 16 || opts.MatrixCoefficients != 9 ||
+		opts.GridCols != 2 || opts.GridRows != 3 || opts.Crop != [4]int{0, 0, 32, 32} || opts.CodecOptions["tune"] != "ssim" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if data, _ := os.ReadFile(xmp); len(opts.XMPData) == 0 || string(opts.XMPData) != string(data) {
+		t.Errorf("XMP not read from %s", xmp)
+	}
+	if want := []string{"in.png", "out.avif"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("rest %q, expected %q", rest, want)
+	}
+
+	// --lossless overrides quality and range, but keeps -y and --cicp when given
+	opts, _, err = ParseAVIFEncArgs([]string{"-r", "limited", "-q", "50", "--lossless"})
+	if err != nil {
+		t.Fatalf("ParseAVIFEncArgs failed: %v", err)
+	}
+	if opts.Quality != 100 || opts.QualityAlpha != 100 || opts.YUVRange != 1 || opts.YUVFormat != 0 || opts.MatrixCoefficients != 0 {
+		t.Errorf("unexpected lossless options %+v", opts)
+	}
+	opts, _, _ = ParseAVIFEncArgs([]string{"-l", "-y", "420", "--cicp", "1/13/6"})
+	if opts.YUVFormat != 2 || opts.MatrixCoefficients != 6 {
+		t.Errorf("lossless overrode -y/--cicp: %+v", opts)
+	}
+
+	if _, _, err := ParseAVIFEncArgs([]string{"--exif", filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected error for a missing Exif file")
+	}
+
+	t.Logf("✓ avifenc arguments")
+}
+
+// TestArgs_RoundTrip tests that parsing Args() reproduces the options
+func TestArgs_RoundTrip(t *testing.T) {
+	t.Run("cwebp", func(t *testing.T) {
+		for _, args := range []string{
+			"",
+			"-q 82.5 -m 6 -sharp_yuv -af -nostrong -qrange 10 90 -crop 1 2 3 4 -resize 10 0 -resize_mode down_only",
+			"-preset drawing -z 6 -alpha_q 50 -alpha_filter best -exact -blend_alpha 0x102030 -noalpha -hint graph",
+			"-lossless -near_lossless 60 -metadata all -mt -low_memory -jpeg_like -pre 2 -size 2000 -pass 4",
+		} {
+			opts, _, err := ParseCWebPArgs(strings.Fields(args))
+			if err != nil {
+				t.Fatalf("%s: %v", args, err)
+			}
+			again, _, err := ParseCWebPArgs(opts.Args())
+			if err != nil || !reflect.DeepEqual(again, opts) {
+				t.Errorf("%q -> %q: round trip mismatch (%v)", args, opts.Args(), err)
+			}
+		}
+		if args := NewDefaultCWebPOptions().Args(); len(args) != 0 {
+			t.Errorf("default options produced %q", args)
+		}
+
+		// A lossless preset without Lossless is not written as -z, which would turn lossless on
+		lossy := NewDefaultCWebPOptions()
+		lossy.LosslessPreset = 6
+		args := lossy.Args()
+		if slices.Contains(args, "-z") {
+			t.Errorf("lossy options produced %q", args)
+		}
+		if again, _, err := ParseCWebPArgs(args); err != nil || again.Lossless {
+			t.Errorf("%q: expected lossy options (%v)", args, err)
+		}
+	})
+
+	t.Run("dwebp", func(t *testing.T) {
+		opts, _, err := ParseDWebPArgs(strings.Fields("-nofancy -nofilter -mt -flip -crop 0 0 10 10 -scale 5 5"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _, err := ParseDWebPArgs(opts.Args())
+		if err != nil || !reflect.DeepEqual(again, opts) {
+			t.Errorf("%q: round trip mismatch (%v)", opts.Args(), err)
+		}
+	})
+
+	t.Run("gif2webp", func(t *testing.T) {
+		for _, args := range []string{"", "-lossy -q 60 -m 2 -kmin 3 -kmax 5 -f 30", "-mixed -min_size -mt -loop_compatibility"} {
+			opts, _, err := ParseGif2WebPArgs(strings.Fields(args))
+			if err != nil {
+				t.Fatalf("%s: %v", args, err)
+			}
+			again, _, err := ParseGif2WebPArgs(opts.Args())
+			if err != nil || !reflect.DeepEqual(again, opts) {
+				t.Errorf("%q -> %q: round trip mismatch (%v)", args, opts.Args(), err)
+			}
+		}
+		if args := NewDefaultGif2WebPOptions().Args(); !reflect.DeepEqual(args, []string{"-lossy"}) {
+			t.Errorf("library defaults should need -lossy, got %q", args)
+		}
+	})
+
+	t.Run("avifenc", func(t *testing.T) {
+		opts, _, err := ParseAVIFEncArgs(strings.Fields(
+			"-q 70 --qalpha 90 -s 2 -d 10 -y 422 -r l -p --sharpyuv --cicp 9/16/9 --tilerowslog2 1 --grid 2x2 " +
+				"--irot 1 --imir 0 --pasp 1,2 --clap 1,1,1,1,0,1,0,1 --clli 1000,400 -k 10 --progressive -a b=1 -a a=2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _, err := ParseAVIFEncArgs(opts.Args())
+		if err != nil || !reflect.DeepEqual(again, opts) {
+			t.Errorf("%q: round trip mismatch (%v)", opts.Args(), err)
+		}
+	})
+
+	t.Run("avifdec", func(t *testing.T) {
+		opts, _, err := ParseAVIFDecArgs(strings.Fields("-j all -q 80 -u bilinear --no-strict --ignore-exif --size-limit 1000000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _, err := ParseAVIFDecArgs(opts.Args())
+		if err != nil || !reflect.DeepEqual(again, opts) {
+			t.Errorf("%q: round trip mismatch (%v)", opts.Args(), err)
+		}
+	})
+
+	t.Run("get_disto", func(t *testing.T) {
+		opts, rest, err := ParseGetDistoArgs(strings.Fields("-ssim -alpha a.webp b.png"))
+		if err != nil || opts.Metric != int(DistortionSSIM) || !opts.KeepAlpha || len(rest) != 2 {
+			t.Fatalf("unexpected %+v %q (%v)", opts, rest, err)
+		}
+		if args := opts.Args(); !reflect.DeepEqual(args, []string{"-ssim", "-alpha"}) {
+			t.Errorf("unexpected args %q", args)
+		}
+	})
+
+	t.Logf("✓ round trips")
+}
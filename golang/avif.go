@@ -7,7 +7,10 @@ package libnextimage
 import "C"
 import (
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"time"
 	"unsafe"
 )
 
@@ -39,6 +42,12 @@ const (
 	MirrorAxisHorizontal AVIFMirrorAxis = 1  // Left-to-right mirroring
 )
 
+// AVIFBitDepthAuto makes the encoder follow the bit depth of the input image.
+// 8-bit sources are encoded at 8 bits. 16-bit PNG/TIFF sources are read at full
+// precision and encoded at 12 bits, or 10 bits when the PNG sBIT chunk says the
+// samples carry 10 significant bits or fewer (same as avifenc without --depth).
+const AVIFBitDepthAuto = 0
+
 // AVIFEncodeOptions represents AVIF encoding options
 type AVIFEncodeOptions struct {
 	// Quality settings
@@ -53,7 +62,7 @@ type AVIFEncodeOptions struct {
 	MaxQuantizerAlpha int // 0-63, default -1 (use QualityAlpha instead)
 
 	// Format settings
-	BitDepth  int           // 8, 10, or 12 (default: 8)
+	BitDepth  int           // 8, 10, 12, or AVIFBitDepthAuto (default: 8)
 	YUVFormat AVIFYUVFormat // YUV format: 444/422/420/400 (default: 444)
 	YUVRange  AVIFYUVRange  // YUV range: limited/full (default: full)
 
@@ -105,8 +114,70 @@ type AVIFEncodeOptions struct {
 	// Animation settings (for future use)
 	Timescale        int // Timescale/fps for animations (default: 30)
 	KeyframeInterval int // Max keyframe interval (default: 0=disabled)
+
+	// Gain map (ISO 21496-1)
+	// GainMapFromPair: the input is the SDR base, GainMapAlternate is the HDR rendition.
+	// GainMapFromHDR: the input is HDR (PQ/HLG); the SDR base is tone mapped from it.
+	// Non-AVIF HDR inputs (16-bit PNG/TIFF) are tagged with HDRColorPrimaries/HDRTransferCharacteristics.
+	GainMapMode                AVIFGainMapMode // Gain map mode (default: GainMapNone)
+	GainMapAlternate           []byte          // HDR rendition image file (AVIF, or 16-bit PNG/TIFF) for GainMapFromPair
+	GainMapQuality             int             // 0-100, gain map quality (default: 60)
+	GainMapDownscale           int             // Gain map downscaling as log2: 0=full size (default), 1=half, 2=quarter...
+	GainMapBitDepth            int             // 8, 10, or 12 (default: 8)
+	GainMapToneMapping         AVIFToneMapping // Tone mapping operator for the SDR base in GainMapFromHDR (default: ToneMappingBT2390)
+	HDRColorPrimaries          int             // CICP primaries of non-AVIF HDR inputs, -1=auto (9=BT.2020)
+	HDRTransferCharacteristics int             // CICP transfer of non-AVIF HDR inputs, -1=auto (16=PQ)
+
+	// Grid encoding (avifenc --grid MxN) for images beyond the AV1 frame size limits.
+	// The image is split into GridCols x GridRows equal cells; width and height must divide evenly.
+	GridCols int // Grid columns M (1-256), 0=disabled (default)
+	GridRows int // Grid rows N (1-256), 0=disabled (default)
+
+	// Progressive (layered) encoding (avifenc --progressive / --layered).
+	// The image is encoded as ProgressiveLayers AV1 layers that decoders can render coarse-to-fine.
+	// Layer qualities default to a ramp from a coarse preview up to Quality/QualityAlpha.
+	ProgressiveLayers       int    // Number of layers 2-4, 0=disabled (default)
+	ProgressiveLayerQuality [4]int // Per-layer quality 0-100 from coarsest to final, -1=auto (default)
+
+	// Codec-specific options (avifenc -a key=value), e.g. {"tune": "ssim", "denoise-noise-level": "10"}.
+	// Prefix a key with "color:" or "alpha:" (or "c:"/"a:") to apply it to one plane type only.
+	// Well-known aom options are range checked up front; other keys are checked by libaom when encoding.
+	CodecOptions map[string]string
+}
+
+// AVIFEncodeResult holds encoded AVIF data together with details of how it was encoded
+type AVIFEncodeResult struct {
+	Data []byte // Encoded AVIF file
+
+	// CodecOptions are the codec-specific options that were applied, keyed as given
+	// (including any "color:"/"alpha:" scope prefix). Nil if none were set.
+	CodecOptions map[string]string
+
+	Stats    AVIFEncodeStats // Encoder statistics
+	Duration time.Duration   // Wall-clock time spent reading the input and encoding
 }
 
+// AVIFMaxLayers is the maximum number of progressive layers
+const AVIFMaxLayers = 4
+
+// AVIFGainMapMode selects how a gain map is produced on encode
+type AVIFGainMapMode int
+
+const (
+	GainMapNone     AVIFGainMapMode = 0 // No gain map (default)
+	GainMapFromPair AVIFGainMapMode = 1 // Input is the SDR base, GainMapAlternate is the HDR rendition
+	GainMapFromHDR  AVIFGainMapMode = 2 // Input is HDR, the SDR base is tone mapped from it
+)
+
+// AVIFGainMapOutput selects what is returned when decoding an image with a gain map
+type AVIFGainMapOutput int
+
+const (
+	GainMapOutputBase      AVIFGainMapOutput = 0 // Base image (default)
+	GainMapOutputImage     AVIFGainMapOutput = 1 // Gain map image itself
+	GainMapOutputRendition AVIFGainMapOutput = 2 // Base image with the gain map applied for GainMapHeadroom
+)
+
 // ChromaUpsampling represents chroma upsampling mode for YUV to RGB conversion
 type ChromaUpsampling int
 
@@ -118,6 +189,16 @@ const (
 	ChromaUpsamplingBilinear   ChromaUpsampling = 4 // Bilinear
 )
 
+// AVIFToneMapping selects the HDR-to-SDR tone mapping operator used on decode
+type AVIFToneMapping int
+
+const (
+	ToneMappingNone     AVIFToneMapping = 0 // No tone mapping (default)
+	ToneMappingBT2390   AVIFToneMapping = 1 // ITU-R BT.2390 EETF (hermite spline in the PQ domain)
+	ToneMappingReinhard AVIFToneMapping = 2 // Extended Reinhard (source peak maps to white)
+	ToneMappingHable    AVIFToneMapping = 3 // Hable (Uncharted 2) filmic curve
+)
+
 // AVIFDecodeOptions represents AVIF decoding options
 type AVIFDecodeOptions struct {
 	// Threading
@@ -151,6 +232,19 @@ type AVIFDecodeOptions struct {
 	ResizeWidth  int  // Resize target width
 	ResizeHeight int  // Resize target height
 	UseResize    bool // Enable resizing
+
+	// HDR -> SDR tone mapping
+	// Applied only to PQ/HLG images; SDR images decode unchanged.
+	// BT.2020 primaries are gamut-mapped to sRGB (BT.709).
+	ToneMapping           AVIFToneMapping // Tone mapping operator (default: ToneMappingNone)
+	ToneMappingSourcePeak int             // Source peak luminance in nits, 0=auto (CLLI maxCLL, else mdcv max mastering luminance, else 1000)
+	ToneMappingTargetNits int             // SDR white luminance in nits (default: 203)
+
+	// Gain map (ISO 21496-1)
+	// GainMapOutputRendition with GainMapHeadroom > 0 outputs 16-bit PQ (BitDepth 16),
+	// otherwise 8-bit sRGB. Decoding a gain map output from an image without one is an error.
+	GainMapOutput   AVIFGainMapOutput // What to decode (default: GainMapOutputBase)
+	GainMapHeadroom float32           // Target HDR headroom in log2 stops for GainMapOutputRendition (default: 0=SDR)
 }
 
 // DefaultAVIFEncodeOptions returns default AVIF encoding options
@@ -179,7 +273,7 @@ func DefaultAVIFEncodeOptions() AVIFEncodeOptions {
 		SharpYUV:     opts.sharp_yuv != 0,
 		TargetSize:   int(opts.target_size),
 		Lossless:     false,
-		Jobs:         -1,   // -1 = all cores (default)
+		Jobs:         int(opts.jobs),
 		AutoTiling:   true, // automatic tiling enabled by default
 		IRotAngle:    int(opts.irot_angle),
 		IMirAxis:     AVIFMirrorAxis(opts.imir_axis),
@@ -189,6 +283,23 @@ func DefaultAVIFEncodeOptions() AVIFEncodeOptions {
 		CLLI:         [2]int{int(opts.clli_max_cll), int(opts.clli_max_pall)},
 		Timescale:    int(opts.timescale),
 		KeyframeInterval: int(opts.keyframe_interval),
+
+		// Gain map
+		GainMapMode:                AVIFGainMapMode(opts.gain_map_mode),
+		GainMapQuality:             int(opts.gain_map_quality),
+		GainMapDownscale:           int(opts.gain_map_downscale),
+		GainMapBitDepth:            int(opts.gain_map_bit_depth),
+		GainMapToneMapping:         AVIFToneMapping(opts.gain_map_tone_mapping),
+		HDRColorPrimaries:          int(opts.hdr_color_primaries),
+		HDRTransferCharacteristics: int(opts.hdr_transfer_characteristics),
+
+		// Grid
+		GridCols: int(opts.grid_cols),
+		GridRows: int(opts.grid_rows),
+
+		// Progressive
+		ProgressiveLayers:       int(opts.progressive_layers),
+		ProgressiveLayerQuality: [4]int{int(opts.progressive_layer_quality[0]), int(opts.progressive_layer_quality[1]), int(opts.progressive_layer_quality[2]), int(opts.progressive_layer_quality[3])},
 	}
 }
 
@@ -228,6 +339,15 @@ func DefaultAVIFDecodeOptions() AVIFDecodeOptions {
 		ResizeWidth:  int(opts.resize_width),
 		ResizeHeight: int(opts.resize_height),
 		UseResize:    opts.use_resize != 0,
+
+		// HDR -> SDR tone mapping
+		ToneMapping:           AVIFToneMapping(opts.tone_mapping),
+		ToneMappingSourcePeak: int(opts.tone_mapping_source_peak),
+		ToneMappingTargetNits: int(opts.tone_mapping_target_nits),
+
+		// Gain map
+		GainMapOutput:   AVIFGainMapOutput(opts.gain_map_output),
+		GainMapHeadroom: float32(opts.gain_map_headroom),
 	}
 }
 
@@ -273,6 +393,7 @@ func (opts *AVIFEncodeOptions) toCEncodeOptions() C.NextImageAVIFEncodeOptions {
 		copts.sharp_yuv = 1
 	}
 	copts.target_size = C.int(opts.TargetSize)
+	copts.jobs = C.int(opts.Jobs)
 
 	// Metadata settings - will be set in the caller to avoid Go pointer issues
 	// The caller must set these pointers and manage their lifetime
@@ -309,6 +430,25 @@ func (opts *AVIFEncodeOptions) toCEncodeOptions() C.NextImageAVIFEncodeOptions {
 	copts.timescale = C.int(opts.Timescale)
 	copts.keyframe_interval = C.int(opts.KeyframeInterval)
 
+	// Gain map (gain_map_alternate_data is set by the caller from C memory)
+	copts.gain_map_mode = C.int(opts.GainMapMode)
+	copts.gain_map_quality = C.int(opts.GainMapQuality)
+	copts.gain_map_downscale = C.int(opts.GainMapDownscale)
+	copts.gain_map_bit_depth = C.int(opts.GainMapBitDepth)
+	copts.gain_map_tone_mapping = C.int(opts.GainMapToneMapping)
+	copts.hdr_color_primaries = C.int(opts.HDRColorPrimaries)
+	copts.hdr_transfer_characteristics = C.int(opts.HDRTransferCharacteristics)
+
+	// Grid
+	copts.grid_cols = C.int(opts.GridCols)
+	copts.grid_rows = C.int(opts.GridRows)
+
+	// Progressive
+	copts.progressive_layers = C.int(opts.ProgressiveLayers)
+	for i := 0; i < AVIFMaxLayers; i++ {
+		copts.progressive_layer_quality[i] = C.int(opts.ProgressiveLayerQuality[i])
+	}
+
 	return copts
 }
 
@@ -373,6 +513,15 @@ func (opts *AVIFDecodeOptions) toCDecodeOptions() C.NextImageAVIFDecodeOptions {
 		copts.use_resize = 0
 	}
 
+	// HDR -> SDR tone mapping
+	copts.tone_mapping = C.int(opts.ToneMapping)
+	copts.tone_mapping_source_peak = C.int(opts.ToneMappingSourcePeak)
+	copts.tone_mapping_target_nits = C.int(opts.ToneMappingTargetNits)
+
+	// Gain map
+	copts.gain_map_output = C.int(opts.GainMapOutput)
+	copts.gain_map_headroom = C.float(opts.GainMapHeadroom)
+
 	return copts
 }
 
@@ -382,48 +531,46 @@ func (opts *AVIFDecodeOptions) toCDecodeOptions() C.NextImageAVIFDecodeOptions {
 func AVIFEncodeBytes(
 	imageFileData []byte,
 	options AVIFEncodeOptions,
+) ([]byte, error) {
+	return avifEncode(imageFileData, options, nil)
+}
+
+// avifEncode encodes image file data to AVIF, filling stats when it is not nil
+func avifEncode(
+	imageFileData []byte,
+	options AVIFEncodeOptions,
+	stats *C.NextImageAVIFEncodeStats,
 ) ([]byte, error) {
 	clearError()
 
 	if len(imageFileData) == 0 {
 		return nil, fmt.Errorf("avif encode: empty input data")
 	}
-
-	// Convert options
-	copts := options.toCEncodeOptions()
-
-	// Copy metadata to C memory to avoid Go pointer issues
-	// We need to free these after the C call
-	var exifPtr, xmpPtr, iccPtr unsafe.Pointer
-	if len(options.ExifData) > 0 {
-		exifPtr = C.CBytes(options.ExifData)
-		defer C.free(exifPtr)
-		copts.exif_data = (*C.uint8_t)(exifPtr)
-		copts.exif_size = C.size_t(len(options.ExifData))
-	}
-	if len(options.XMPData) > 0 {
-		xmpPtr = C.CBytes(options.XMPData)
-		defer C.free(xmpPtr)
-		copts.xmp_data = (*C.uint8_t)(xmpPtr)
-		copts.xmp_size = C.size_t(len(options.XMPData))
+	if err := checkLimits(imageFileData); err != nil {
+		return nil, fmt.Errorf("avif encode: %w", err)
 	}
-	if len(options.ICCData) > 0 {
-		iccPtr = C.CBytes(options.ICCData)
-		defer C.free(iccPtr)
-		copts.icc_data = (*C.uint8_t)(iccPtr)
-		copts.icc_size = C.size_t(len(options.ICCData))
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("avif encode: %w", err)
 	}
 
+	// Convert options (metadata and codec options are copied to C memory)
+	copts := newCAVIFEncodeOptions(options)
+	defer copts.free()
+
 	// Encode
 	var output C.NextImageBuffer
-	status := C.nextimage_avif_encode_alloc(
+	status := C.nextimage_avif_encode_alloc_with_stats(
 		(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
 		C.size_t(len(imageFileData)),
-		&copts,
+		&copts.opts,
 		&output,
+		stats,
 	)
 
 	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "AVIFEncodeOptions", options.Crop); err != nil {
+			return nil, fmt.Errorf("avif encode: %w", err)
+		}
 		return nil, makeError(status, "avif encode")
 	}
 
@@ -436,6 +583,137 @@ func AVIFEncodeBytes(
 	return result, nil
 }
 
+// AVIFEncodeBytesWithResult encodes image file data to AVIF like AVIFEncodeBytes
+// and also reports how the image was encoded
+func AVIFEncodeBytesWithResult(
+	imageFileData []byte,
+	options AVIFEncodeOptions,
+) (*AVIFEncodeResult, error) {
+	var stats C.NextImageAVIFEncodeStats
+	start := time.Now()
+	data, err := avifEncode(imageFileData, options, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AVIFEncodeResult{
+		Data:         data,
+		CodecOptions: copyCodecOptions(options.CodecOptions),
+		Stats:        convertAVIFEncodeStats(&stats),
+		Duration:     time.Since(start),
+	}, nil
+}
+
+// cAVIFEncodeOptions holds C encode options whose metadata and codec options live in C memory
+// to avoid passing Go pointers. The result must be released with free.
+type cAVIFEncodeOptions struct {
+	opts      C.NextImageAVIFEncodeOptions
+	buffers   []unsafe.Pointer
+	codecOpts *cCodecOptions
+}
+
+// newCAVIFEncodeOptions converts options to C, copying metadata and codec options to C memory
+func newCAVIFEncodeOptions(options AVIFEncodeOptions) *cAVIFEncodeOptions {
+	co := &cAVIFEncodeOptions{opts: options.toCEncodeOptions()}
+
+	cbytes := func(data []byte) (*C.uint8_t, C.size_t) {
+		ptr := C.CBytes(data)
+		co.buffers = append(co.buffers, ptr)
+		return (*C.uint8_t)(ptr), C.size_t(len(data))
+	}
+	if len(options.ExifData) > 0 {
+		co.opts.exif_data, co.opts.exif_size = cbytes(options.ExifData)
+	}
+	if len(options.XMPData) > 0 {
+		co.opts.xmp_data, co.opts.xmp_size = cbytes(options.XMPData)
+	}
+	if len(options.ICCData) > 0 {
+		co.opts.icc_data, co.opts.icc_size = cbytes(options.ICCData)
+	}
+	if len(options.GainMapAlternate) > 0 {
+		co.opts.gain_map_alternate_data, co.opts.gain_map_alternate_size = cbytes(options.GainMapAlternate)
+	}
+
+	co.codecOpts = newCCodecOptions(options.CodecOptions)
+	co.opts.codec_option_keys = co.codecOpts.keys
+	co.opts.codec_option_values = co.codecOpts.values
+	co.opts.codec_option_count = co.codecOpts.count
+
+	return co
+}
+
+// free releases the C memory of the options
+func (co *cAVIFEncodeOptions) free() {
+	for _, ptr := range co.buffers {
+		C.free(ptr)
+	}
+	co.buffers = nil
+	co.codecOpts.free()
+}
+
+// cCodecOptions holds codec-specific options as C string arrays
+type cCodecOptions struct {
+	keys   **C.char
+	values **C.char
+	count  C.int
+}
+
+// newCCodecOptions converts codec-specific options to C memory in sorted key order.
+// The result must be released with free.
+func newCCodecOptions(opts map[string]string) *cCodecOptions {
+	cc := &cCodecOptions{}
+	if len(opts) == 0 {
+		return cc
+	}
+
+	names := make([]string, 0, len(opts))
+	for k := range opts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	ptrSize := C.size_t(unsafe.Sizeof((*C.char)(nil)))
+	cc.keys = (**C.char)(C.malloc(C.size_t(len(names)) * ptrSize))
+	cc.values = (**C.char)(C.malloc(C.size_t(len(names)) * ptrSize))
+	keys := unsafe.Slice(cc.keys, len(names))
+	values := unsafe.Slice(cc.values, len(names))
+	for i, k := range names {
+		keys[i] = C.CString(k)
+		values[i] = C.CString(opts[k])
+	}
+	cc.count = C.int(len(names))
+
+	return cc
+}
+
+// free releases the C memory of the codec options
+func (cc *cCodecOptions) free() {
+	if cc.count == 0 {
+		return
+	}
+	keys := unsafe.Slice(cc.keys, int(cc.count))
+	values := unsafe.Slice(cc.values, int(cc.count))
+	for i := range keys {
+		C.free(unsafe.Pointer(keys[i]))
+		C.free(unsafe.Pointer(values[i]))
+	}
+	C.free(unsafe.Pointer(cc.keys))
+	C.free(unsafe.Pointer(cc.values))
+	cc.count = 0
+}
+
+// copyCodecOptions returns a copy of the codec options map, or nil if empty
+func copyCodecOptions(opts map[string]string) map[string]string {
+	if len(opts) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(opts))
+	for k, v := range opts {
+		copied[k] = v
+	}
+	return copied
+}
+
 // AVIFEncodeFile encodes an image file to AVIF format
 // This reads the image file (JPEG, PNG, etc.) and converts it to AVIF.
 func AVIFEncodeFile(inputPath string, options AVIFEncodeOptions) ([]byte, error) {
@@ -458,6 +736,9 @@ func AVIFDecodeBytes(
 	if len(avifData) == 0 {
 		return nil, fmt.Errorf("avif decode: empty input data")
 	}
+	if err := checkLimits(avifData); err != nil {
+		return nil, fmt.Errorf("avif decode: %w", err)
+	}
 
 	// Convert options
 	copts := options.toCDecodeOptions()
@@ -511,13 +792,88 @@ func AVIFDecodeSize(avifData []byte) (width, height, bitDepth int, requiredSize
 	return int(w), int(h), int(depth), int(size), nil
 }
 
+// AVIFGainMapMetadata describes the gain map (ISO 21496-1) of an AVIF image
+type AVIFGainMapMetadata struct {
+	Present  bool // False if the image has no gain map (other fields are zero)
+	Width    int  // Gain map image width
+	Height   int  // Gain map image height
+	BitDepth int  // Gain map image bit depth
+
+	// Per-channel (R, G, B) parameters
+	GainMapMin      [3]float64 // Minimum gain in log2 stops
+	GainMapMax      [3]float64 // Maximum gain in log2 stops
+	GainMapGamma    [3]float64 // Gain map gamma
+	BaseOffset      [3]float64 // Offset applied to the base image
+	AlternateOffset [3]float64 // Offset applied to the alternate image
+
+	BaseHDRHeadroom      float64 // Headroom of the base image in log2 stops
+	AlternateHDRHeadroom float64 // Headroom of the alternate image in log2 stops
+	UseBaseColorSpace    bool    // Gain map is applied in the base image color space
+
+	// Alternate (HDR) rendition properties
+	AltColorPrimaries          int
+	AltTransferCharacteristics int
+	AltMatrixCoefficients      int
+	AltBitDepth                int
+	AltPlaneCount              int
+	AltCLLI                    [2]int // [maxCLL, maxPALL]
+}
+
+// AVIFGainMapInfo returns the gain map metadata of an AVIF image without decoding pixels
+func AVIFGainMapInfo(avifData []byte) (*AVIFGainMapMetadata, error) {
+	clearError()
+
+	if len(avifData) == 0 {
+		return nil, fmt.Errorf("avif gain map info: empty input data")
+	}
+
+	var info C.NextImageAVIFGainMapInfo
+	status := C.nextimage_avif_gain_map_info(
+		(*C.uint8_t)(unsafe.Pointer(&avifData[0])),
+		C.size_t(len(avifData)),
+		&info,
+	)
+
+	if status != C.NEXTIMAGE_OK {
+		return nil, makeError(status, "avif gain map info")
+	}
+
+	meta := &AVIFGainMapMetadata{
+		Present:                    info.present != 0,
+		Width:                      int(info.width),
+		Height:                     int(info.height),
+		BitDepth:                   int(info.bit_depth),
+		BaseHDRHeadroom:            float64(info.base_hdr_headroom),
+		AlternateHDRHeadroom:       float64(info.alternate_hdr_headroom),
+		UseBaseColorSpace:          info.use_base_color_space != 0,
+		AltColorPrimaries:          int(info.alt_color_primaries),
+		AltTransferCharacteristics: int(info.alt_transfer_characteristics),
+		AltMatrixCoefficients:      int(info.alt_matrix_coefficients),
+		AltBitDepth:                int(info.alt_bit_depth),
+		AltPlaneCount:              int(info.alt_plane_count),
+		AltCLLI:                    [2]int{int(info.alt_clli_max_cll), int(info.alt_clli_max_pall)},
+	}
+	for c := 0; c < 3; c++ {
+		meta.GainMapMin[c] = float64(info.gain_map_min[c])
+		meta.GainMapMax[c] = float64(info.gain_map_max[c])
+		meta.GainMapGamma[c] = float64(info.gain_map_gamma[c])
+		meta.BaseOffset[c] = float64(info.base_offset[c])
+		meta.AlternateOffset[c] = float64(info.alternate_offset[c])
+	}
+
+	return meta, nil
+}
+
 // ========================================
 // AVIF Encoder/Decoder (Instance-based API)
 // ========================================
 
-// AVIFEncoder represents an AVIF encoder instance that can be reused for multiple images
+// AVIFEncoder represents an AVIF encoder instance that can be reused for multiple images.
+// An encoder is not safe for concurrent use; use one per goroutine, or an AVIFEncoderPool.
 type AVIFEncoder struct {
-	encoderPtr *C.NextImageAVIFEncoder
+	encoderPtr   *C.NextImageAVIFEncoder
+	crop         [4]int            // Crop, checked against each input
+	codecOptions map[string]string // Reported by EncodeWithResult
 }
 
 // NewAVIFEncoder creates a new AVIF encoder with the given options
@@ -540,22 +896,60 @@ func NewAVIFEncoder(optsFn func(*AVIFEncodeOptions)) (*AVIFEncoder, error) {
 	if optsFn != nil {
 		optsFn(&opts)
 	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("avif encoder: %w", err)
+	}
 
 	// Convert to C struct
 	cOpts := opts.toCEncodeOptions()
 
+	// The encoder keeps its own copy of the gain map alternate
+	if len(opts.GainMapAlternate) > 0 {
+		altPtr := C.CBytes(opts.GainMapAlternate)
+		defer C.free(altPtr)
+		cOpts.gain_map_alternate_data = (*C.uint8_t)(altPtr)
+		cOpts.gain_map_alternate_size = C.size_t(len(opts.GainMapAlternate))
+	}
+	codecOpts := newCCodecOptions(opts.CodecOptions)
+	defer codecOpts.free()
+	cOpts.codec_option_keys = codecOpts.keys
+	cOpts.codec_option_values = codecOpts.values
+	cOpts.codec_option_count = codecOpts.count
+
 	// Create encoder
 	encoderPtr := C.nextimage_avif_encoder_create(&cOpts)
 	if encoderPtr == nil {
 		return nil, fmt.Errorf("avif encoder: failed to create encoder: %s", getLastError())
 	}
 
-	return &AVIFEncoder{encoderPtr: encoderPtr}, nil
+	return &AVIFEncoder{encoderPtr: encoderPtr, crop: opts.Crop, codecOptions: copyCodecOptions(opts.CodecOptions)}, nil
 }
 
 // Encode encodes image file data (JPEG, PNG, etc.) to AVIF format
 // The encoder instance can be reused for multiple images, reducing initialization overhead
 func (e *AVIFEncoder) Encode(imageFileData []byte) ([]byte, error) {
+	return e.encode(imageFileData, nil)
+}
+
+// EncodeWithResult encodes like Encode and also reports the encoder statistics and
+// the encode time
+func (e *AVIFEncoder) EncodeWithResult(imageFileData []byte) (*AVIFEncodeResult, error) {
+	var stats C.NextImageAVIFEncodeStats
+	start := time.Now()
+	data, err := e.encode(imageFileData, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &AVIFEncodeResult{
+		Data:         data,
+		CodecOptions: copyCodecOptions(e.codecOptions),
+		Stats:        convertAVIFEncodeStats(&stats),
+		Duration:     time.Since(start),
+	}, nil
+}
+
+// encode encodes with the C encoder, filling stats when it is not nil
+func (e *AVIFEncoder) encode(imageFileData []byte, stats *C.NextImageAVIFEncodeStats) ([]byte, error) {
 	if e.encoderPtr == nil {
 		return nil, fmt.Errorf("avif encoder: encoder is closed")
 	}
@@ -563,16 +957,33 @@ func (e *AVIFEncoder) Encode(imageFileData []byte) ([]byte, error) {
 	if len(imageFileData) == 0 {
 		return nil, fmt.Errorf("avif encoder: empty input data")
 	}
+	if err := checkLimits(imageFileData); err != nil {
+		return nil, fmt.Errorf("avif encoder: %w", err)
+	}
 
 	var encoded C.NextImageBuffer
-	status := C.nextimage_avif_encoder_encode(
-		e.encoderPtr,
-		(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
-		C.size_t(len(imageFileData)),
-		&encoded,
-	)
+	var status C.NextImageStatus
+	if stats != nil {
+		status = C.nextimage_avif_encoder_encode_with_stats(
+			e.encoderPtr,
+			(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
+			C.size_t(len(imageFileData)),
+			&encoded,
+			stats,
+		)
+	} else {
+		status = C.nextimage_avif_encoder_encode(
+			e.encoderPtr,
+			(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
+			C.size_t(len(imageFileData)),
+			&encoded,
+		)
+	}
 
 	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "AVIFEncodeOptions", e.crop); err != nil {
+			return nil, fmt.Errorf("avif encoder: %w", err)
+		}
 		return nil, makeError(status, "avif encoder encode")
 	}
 
@@ -594,7 +1005,8 @@ func (e *AVIFEncoder) Close() {
 	}
 }
 
-// AVIFDecoder represents an AVIF decoder instance that can be reused for multiple images
+// AVIFDecoder represents an AVIF decoder instance that can be reused for multiple images.
+// A decoder is not safe for concurrent use; use one per goroutine, or an AVIFDecoderPool.
 type AVIFDecoder struct {
 	decoderPtr *C.NextImageAVIFDecoder
 }
@@ -641,6 +1053,9 @@ func (d *AVIFDecoder) Decode(avifData []byte) (*DecodedImage, error) {
 	if len(avifData) == 0 {
 		return nil, fmt.Errorf("avif decoder: empty input data")
 	}
+	if err := checkLimits(avifData); err != nil {
+		return nil, fmt.Errorf("avif decoder: %w", err)
+	}
 
 	var decoded C.NextImageDecodeBuffer
 	status := C.nextimage_avif_decoder_decode(
@@ -663,6 +1078,158 @@ func (d *AVIFDecoder) Decode(avifData []byte) (*DecodedImage, error) {
 	return img, nil
 }
 
+// DecodeLayers decodes the layers of a progressive (layered) AVIF in order, coarsest first.
+// avifData may be only the beginning of the file, such as the bytes received so far; pass
+// complete=false in that case and layers whose data has not arrived yet are not returned.
+// Non-progressive images have a single layer. It returns the decoded layers and the total
+// layer count (0 while the header has not arrived).
+func (d *AVIFDecoder) DecodeLayers(avifData []byte, complete bool) ([]*DecodedImage, int, error) {
+	if d.decoderPtr == nil {
+		return nil, 0, fmt.Errorf("avif decoder: decoder is closed")
+	}
+
+	if len(avifData) == 0 {
+		if complete {
+			return nil, 0, fmt.Errorf("avif decoder: empty input data")
+		}
+		return nil, 0, nil
+	}
+	if err := checkLimits(avifData); err != nil {
+		return nil, 0, fmt.Errorf("avif decoder: %w", err)
+	}
+
+	var cComplete C.int
+	if complete {
+		cComplete = 1
+	} else {
+		cComplete = 0
+	}
+
+	var layers [AVIFMaxLayers]C.NextImageDecodeBuffer
+	var layerCount, decodedCount C.int
+	status := C.nextimage_avif_decoder_decode_layers(
+		d.decoderPtr,
+		(*C.uint8_t)(unsafe.Pointer(&avifData[0])),
+		C.size_t(len(avifData)),
+		cComplete,
+		&layers[0],
+		&layerCount,
+		&decodedCount,
+	)
+
+	if status != C.NEXTIMAGE_OK {
+		return nil, 0, makeError(status, "avif decoder decode layers")
+	}
+
+	// Convert to Go structures
+	images := make([]*DecodedImage, 0, int(decodedCount))
+	for i := 0; i < int(decodedCount); i++ {
+		images = append(images, convertDecodeBuffer(&layers[i]))
+		freeDecodeBuffer(&layers[i])
+	}
+
+	return images, int(layerCount), nil
+}
+
+// layerHeaderMaxBytes is how much of a stream DecodeLayersFrom buffers to find the
+// image header, which progressive AVIFs keep in front of the layers
+const layerHeaderMaxBytes = 64 << 10
+
+// DecodeLayersFrom reads a progressive AVIF from r and calls fn with each layer as soon as
+// the data for it has arrived. index is the layer index and count the total number of layers.
+// Returning an error from fn stops decoding and returns that error.
+//
+// The data read so far is kept in C and decoding continues where it stopped, so each
+// byte is read and each layer decoded once however small the reads are.
+func (d *AVIFDecoder) DecodeLayersFrom(r io.Reader, fn func(layer *DecodedImage, index, count int) error) error {
+	if d.decoderPtr == nil {
+		return fmt.Errorf("avif decoder: decoder is closed")
+	}
+	stream := C.nextimage_avif_layer_stream_create(d.decoderPtr)
+	if stream == nil {
+		return makeError(C.NEXTIMAGE_ERROR_OUT_OF_MEMORY, "avif decoder layer stream")
+	}
+	defer C.nextimage_avif_layer_stream_destroy(stream)
+
+	lim := CurrentLimits()
+	var head []byte // Start of the file until the header limits could be checked, up to layerHeaderMaxBytes
+	var total int64
+	headerChecked := false
+	buf := make([]byte, 32*1024)
+	next := 0
+
+	for {
+		n, readErr := r.Read(buf)
+		complete := readErr == io.EOF
+		if readErr != nil && !complete {
+			return fmt.Errorf("avif decoder: read failed: %w", readErr)
+		}
+
+		total += int64(n)
+		if lim.MaxInputBytes > 0 && total > lim.MaxInputBytes {
+			return fmt.Errorf("avif decoder: %w", &LimitError{"MaxInputBytes", total, lim.MaxInputBytes})
+		}
+		if !headerChecked {
+			head = append(head, buf[:min(n, layerHeaderMaxBytes-len(head))]...)
+			if h, ok := probeHeader(head); ok {
+				if err := lim.checkHeader(h); err != nil {
+					return fmt.Errorf("avif decoder: %w", err)
+				}
+				headerChecked, head = true, nil
+			} else if len(head) >= layerHeaderMaxBytes {
+				return fmt.Errorf("avif decoder: no image header in the first %d bytes", layerHeaderMaxBytes)
+			}
+		}
+
+		if n > 0 || complete {
+			layers, count, err := d.pushLayers(stream, buf[:n], complete)
+			if err != nil {
+				return err
+			}
+			for _, layer := range layers {
+				if err := fn(layer, next, count); err != nil {
+					return err
+				}
+				next++
+			}
+			if count > 0 && next >= count {
+				return nil
+			}
+		}
+
+		if complete {
+			return fmt.Errorf("avif decoder: data ended after %d layers", next)
+		}
+	}
+}
+
+// pushLayers appends data to the layer stream and returns the layers it completed
+func (d *AVIFDecoder) pushLayers(stream *C.NextImageAVIFLayerStream, data []byte, complete bool) ([]*DecodedImage, int, error) {
+	var ptr *C.uint8_t
+	if len(data) > 0 {
+		ptr = (*C.uint8_t)(unsafe.Pointer(&data[0]))
+	}
+	var cComplete C.int
+	if complete {
+		cComplete = 1
+	}
+
+	var layers [AVIFMaxLayers]C.NextImageDecodeBuffer
+	var layerCount, decodedCount C.int
+	status := C.nextimage_avif_layer_stream_push(stream, ptr, C.size_t(len(data)), cComplete,
+		&layers[0], &layerCount, &decodedCount)
+	if status != C.NEXTIMAGE_OK {
+		return nil, 0, makeError(status, "avif decoder decode layers")
+	}
+
+	images := make([]*DecodedImage, 0, int(decodedCount))
+	for i := 0; i < int(decodedCount); i++ {
+		images = append(images, convertDecodeBuffer(&layers[i]))
+		freeDecodeBuffer(&layers[i])
+	}
+	return images, int(layerCount), nil
+}
+
 // Close releases resources associated with the decoder
 // Must be called when done using the decoder
 func (d *AVIFDecoder) Close() {
@@ -3,6 +3,7 @@ package libnextimage
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"os/exec"
@@ -306,6 +307,260 @@ func TestCompat_AVIF_EncodeDepth(t *testing.T) {
 	}
 }
 
+// TestCompat_AVIF_EncodeHighBitDepthSource tests AVIF encoding from 16-bit PNG sources
+// (read at full precision instead of through the 8-bit imageio path)
+func TestCompat_AVIF_EncodeHighBitDepthSource(t *testing.T) {
+	setupAVIFCompatTest(t)
+
+	testCases := []struct {
+		name     string
+		input    string
+		bitDepth int
+		args     []string
+	}{
+		{
+			name:     "10bit-source-depth-10",
+			input:    "source/avif-specific/10bit-source.png",
+			bitDepth: 10,
+			args:     []string{"-d", "10"},
+		},
+		{
+			name:     "10bit-source-depth-12",
+			input:    "source/avif-specific/10bit-source.png",
+			bitDepth: 12,
+			args:     []string{"-d", "12"},
+		},
+		{
+			name:     "10bit-source-depth-auto",
+			input:    "source/avif-specific/10bit-source.png",
+			bitDepth: AVIFBitDepthAuto,
+			args:     []string{},
+		},
+		{
+			name:     "12bit-source-depth-auto",
+			input:    "source/avif-specific/12bit-source.png",
+			bitDepth: AVIFBitDepthAuto,
+			args:     []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Logf("Testing AVIF encoding: %s", tc.name)
+
+			inputPath := filepath.Join(testdataDir, tc.input)
+
+			// Run avifenc command
+			cmdOutput := runAVIFEnc(t, inputPath, tc.args)
+
+			// Run library encoding
+			opts := DefaultAVIFEncodeOptions()
+			opts.BitDepth = tc.bitDepth
+			libOutput, err := encodeAVIFWithLibrary(inputPath, opts)
+			if err != nil {
+				t.Fatalf("library encoding failed: %v", err)
+			}
+
+			// Compare outputs
+			compareAVIFOutputs(t, cmdOutput, libOutput)
+		})
+	}
+}
+
+// TestAVIF_BitDepthAuto tests that AVIFBitDepthAuto follows the input bit depth
+func TestAVIF_BitDepthAuto(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		expectedDepth int
+	}{
+		{"8bit-rgb", "source/avif-specific/8bit-rgb.png", 8},
+		{"10bit-source", "source/avif-specific/10bit-source.png", 12},
+		{"12bit-source-gray", "source/avif-specific/12bit-source.png", 12},
+		{"jpeg", "jpeg/gradient.jpg", 8},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(testdataDir, tc.input))
+			if err != nil {
+				t.Fatalf("Failed to read test image: %v", err)
+			}
+
+			opts := DefaultAVIFEncodeOptions()
+			opts.BitDepth = AVIFBitDepthAuto
+			opts.Speed = 10
+
+			avifData, err := AVIFEncodeBytes(data, opts)
+			if err != nil {
+				t.Fatalf("AVIFEncodeBytes failed: %v", err)
+			}
+
+			_, _, depth, _, err := AVIFDecodeSize(avifData)
+			if err != nil {
+				t.Fatalf("AVIFDecodeSize failed: %v", err)
+			}
+			if depth != tc.expectedDepth {
+				t.Errorf("expected bit depth %d, got %d", tc.expectedDepth, depth)
+			}
+
+			t.Logf("✓ %s encoded at %d-bit (%d bytes)", tc.name, depth, len(avifData))
+		})
+	}
+
+	// Invalid bit depths are rejected
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source/avif-specific/8bit-rgb.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	opts := DefaultAVIFEncodeOptions()
+	opts.BitDepth = 9
+	if _, err := AVIFEncodeBytes(data, opts); err == nil {
+		t.Error("expected error for bit depth 9")
+	}
+}
+
+// tiff16 builds an uncompressed 16-bit RGB TIFF with a gradient, stored in strips,
+// or in tiles of tileSize when tileSize > 0. samples is the SamplesPerPixel written,
+// 3 for a valid file.
+func tiff16(width, height, tileSize, samples int) []byte {
+	pixel := func(x, y int) []uint16 {
+		return []uint16{uint16(x * 65535 / width), uint16(y * 65535 / height), uint16((x + y) * 1021)}[:samples]
+	}
+
+	// Image data: one strip, or tiles padded to tileSize
+	var data bytes.Buffer
+	var offsets, counts []uint32
+	le := binary.LittleEndian
+	if tileSize == 0 {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				for _, v := range pixel(x, y) {
+					binary.Write(&data, le, v)
+				}
+			}
+		}
+		offsets, counts = []uint32{8}, []uint32{uint32(data.Len())}
+	} else {
+		for ty := 0; ty < height; ty += tileSize {
+			for tx := 0; tx < width; tx += tileSize {
+				offsets = append(offsets, uint32(8+data.Len()))
+				for y := ty; y < ty+tileSize; y++ {
+					for x := tx; x < tx+tileSize; x++ {
+						v := make([]uint16, samples)
+						if x < width && y < height {
+							v = pixel(x, y)
+						}
+						binary.Write(&data, le, v)
+					}
+				}
+				counts = append(counts, uint32(tileSize*tileSize*2*samples))
+			}
+		}
+	}
+
+	// IFD after the data, arrays after the IFD
+	type entry struct {
+		tag, typ uint16
+		values   []uint32
+	}
+	entries := []entry{
+		{256, 4, []uint32{uint32(width)}},
+		{257, 4, []uint32{uint32(height)}},
+		{258, 3, []uint32{16, 16, 16}[:samples]},
+		{259, 3, []uint32{1}},
+		{262, 3, []uint32{2}},
+	}
+	if tileSize == 0 {
+		entries = append(entries,
+			entry{273, 4, offsets},
+			entry{277, 3, []uint32{uint32(samples)}},
+			entry{278, 4, []uint32{uint32(height)}},
+			entry{279, 4, counts},
+			entry{284, 3, []uint32{1}})
+	} else {
+		entries = append(entries,
+			entry{277, 3, []uint32{uint32(samples)}},
+			entry{284, 3, []uint32{1}},
+			entry{322, 4, []uint32{uint32(tileSize)}},
+			entry{323, 4, []uint32{uint32(tileSize)}},
+			entry{324, 4, offsets},
+			entry{325, 4, counts})
+	}
+
+	ifdOffset := 8 + data.Len()
+	extraOffset := ifdOffset + 2 + len(entries)*12 + 4
+	var ifd, extra bytes.Buffer
+	binary.Write(&ifd, le, uint16(len(entries)))
+	for _, e := range entries {
+		size := 2
+		if e.typ == 4 {
+			size = 4
+		}
+		binary.Write(&ifd, le, e.tag)
+		binary.Write(&ifd, le, e.typ)
+		binary.Write(&ifd, le, uint32(len(e.values)))
+		var value bytes.Buffer
+		for _, v := range e.values {
+			if size == 2 {
+				binary.Write(&value, le, uint16(v))
+			} else {
+				binary.Write(&value, le, v)
+			}
+		}
+		if value.Len() <= 4 {
+			ifd.Write(append(value.Bytes(), make([]byte, 4-value.Len())...))
+		} else {
+			binary.Write(&ifd, le, uint32(extraOffset+extra.Len()))
+			extra.Write(value.Bytes())
+		}
+	}
+	binary.Write(&ifd, le, uint32(0))
+
+	var out bytes.Buffer
+	out.WriteString("II")
+	binary.Write(&out, le, uint16(42))
+	binary.Write(&out, le, uint32(ifdOffset))
+	out.Write(data.Bytes())
+	out.Write(ifd.Bytes())
+	out.Write(extra.Bytes())
+	return out.Bytes()
+}
+
+// TestAVIF_16BitTIFF tests that striped and tiled 16-bit TIFFs are read at full depth
+func TestAVIF_16BitTIFF(t *testing.T) {
+	opts := DefaultAVIFEncodeOptions()
+	opts.BitDepth = AVIFBitDepthAuto
+	opts.Speed = 10
+
+	var outputs [][]byte
+	for _, tileSize := range []int{0, 16} {
+		// 40x24 does not fill the last column and row of 16x16 tiles
+		avifData, err := AVIFEncodeBytes(tiff16(40, 24, tileSize, 3), opts)
+		if err != nil {
+			t.Fatalf("AVIFEncodeBytes failed (tile size %d): %v", tileSize, err)
+		}
+		width, height, depth, _, err := AVIFDecodeSize(avifData)
+		if err != nil {
+			t.Fatalf("AVIFDecodeSize failed: %v", err)
+		}
+		if width != 40 || height != 24 || depth != 12 {
+			t.Errorf("tile size %d: expected 40x24 at 12-bit, got %dx%d at %d-bit", tileSize, width, height, depth)
+		}
+		outputs = append(outputs, avifData)
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("tiled and striped TIFFs of the same image encoded differently")
+	}
+
+	// RGB with one sample per pixel would be read past the end of each scanline
+	for _, tileSize := range []int{0, 16} {
+		if _, err := AVIFEncodeBytes(tiff16(40, 24, tileSize, 1), opts); err == nil {
+			t.Errorf("tile size %d: expected an error for an RGB TIFF with 1 sample per pixel", tileSize)
+		}
+	}
+}
+
 // TestCompat_AVIF_EncodeYUVFormat tests AVIF encoding with different YUV formats
 func TestCompat_AVIF_EncodeYUVFormat(t *testing.T) {
 	setupAVIFCompatTest(t)
@@ -1025,6 +1280,274 @@ func TestCompat_AVIF_Tiling(t *testing.T) {
 	}
 }
 
+// TestCompat_AVIF_Grid tests AVIF grid encoding (--grid MxN)
+func TestCompat_AVIF_Grid(t *testing.T) {
+	setupAVIFCompatTest(t)
+
+	testCases := []struct {
+		name  string
+		input string
+		cols  int
+		rows  int
+		args  []string
+	}{
+		{
+			name:  "grid-2x2",
+			input: "source/sizes/medium-512x512.png",
+			cols:  2,
+			rows:  2,
+			args:  []string{"--grid", "2x2"},
+		},
+		{
+			name:  "grid-4x3",
+			input: "source/colors/landscape-like.png",
+			cols:  4,
+			rows:  3,
+			args:  []string{"--grid", "4x3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Logf("Testing AVIF encoding: %s", tc.name)
+
+			inputPath := filepath.Join(testdataDir, tc.input)
+
+			// Run avifenc command
+			cmdOutput := runAVIFEnc(t, inputPath, tc.args)
+
+			// Run library encoding
+			opts := DefaultAVIFEncodeOptions()
+			opts.GridCols = tc.cols
+			opts.GridRows = tc.rows
+
+			libOutput, err := encodeAVIFWithLibrary(inputPath, opts)
+			if err != nil {
+				t.Fatalf("library encoding failed: %v", err)
+			}
+
+			// Compare outputs
+			compareAVIFOutputs(t, cmdOutput, libOutput)
+		})
+	}
+}
+
+// TestAVIF_GridDecode tests that grid AVIFs decode through the existing decoders
+func TestAVIF_GridDecode(t *testing.T) {
+	inputPath := filepath.Join(testdataDir, "source/colors/landscape-like.png")
+
+	plain, err := AVIFEncodeFile(inputPath, DefaultAVIFEncodeOptions())
+	if err != nil {
+		t.Fatalf("plain encoding failed: %v", err)
+	}
+	plainImg, err := AVIFDecodeBytes(plain, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("plain decoding failed: %v", err)
+	}
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.GridCols = 4
+	opts.GridRows = 3
+	grid, err := AVIFEncodeFile(inputPath, opts)
+	if err != nil {
+		t.Fatalf("grid encoding failed: %v", err)
+	}
+
+	// Function API
+	img, err := AVIFDecodeBytes(grid, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("grid decoding failed: %v", err)
+	}
+	if img.Width != 1024 || img.Height != 768 {
+		t.Fatalf("expected 1024x768, got %dx%d", img.Width, img.Height)
+	}
+
+	// Cells are encoded independently, so the result should stay close to the single-image encode
+	var diff float64
+	for i := range img.Data {
+		d := float64(img.Data[i]) - float64(plainImg.Data[i])
+		diff += d * d
+	}
+	mse := diff / float64(len(img.Data))
+	if mse > 50 {
+		t.Errorf("grid decode differs too much from single-image decode: MSE %.2f", mse)
+	}
+
+	// Instance API
+	decoder, err := NewAVIFDecoder(nil)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	defer decoder.Close()
+	img2, err := decoder.Decode(grid)
+	if err != nil {
+		t.Fatalf("decoder failed: %v", err)
+	}
+	if img2.Width != img.Width || img2.Height != img.Height {
+		t.Errorf("decoder size mismatch: %dx%d", img2.Width, img2.Height)
+	}
+
+	// Command API
+	decCmd, err := NewAVIFDecCommand(nil)
+	if err != nil {
+		t.Fatalf("failed to create avifdec command: %v", err)
+	}
+	defer decCmd.Close()
+	if _, err := decCmd.Run(grid); err != nil {
+		t.Fatalf("avifdec command failed: %v", err)
+	}
+
+	t.Logf("✓ 4x3 grid: %d bytes (single image %d bytes), MSE %.2f", len(grid), len(plain), mse)
+}
+
+// TestAVIF_GridInvalid tests grid parameter validation
+func TestAVIF_GridInvalid(t *testing.T) {
+	inputPath := filepath.Join(testdataDir, "source/sizes/rect-800x600.png")
+
+	testCases := []struct {
+		name string
+		cols int
+		rows int
+	}{
+		{"uneven-columns", 3, 2},
+		{"uneven-rows", 2, 7},
+		{"too-many-columns", 257, 1},
+		{"negative", -1, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultAVIFEncodeOptions()
+			opts.GridCols = tc.cols
+			opts.GridRows = tc.rows
+			if _, err := AVIFEncodeFile(inputPath, opts); err == nil {
+				t.Errorf("expected error for %dx%d grid", tc.cols, tc.rows)
+			}
+		})
+	}
+}
+
+// TestCompat_AVIF_CodecOptions tests codec-specific options (-a key=value)
+func TestCompat_AVIF_CodecOptions(t *testing.T) {
+	setupAVIFCompatTest(t)
+
+	testCases := []struct {
+		name    string
+		options map[string]string
+		args    []string
+	}{
+		{
+			name:    "tune-ssim",
+			options: map[string]string{"tune": "ssim"},
+			args:    []string{"-a", "tune=ssim"},
+		},
+		{
+			name:    "film-grain",
+			options: map[string]string{"denoise-noise-level": "10"},
+			args:    []string{"-a", "denoise-noise-level=10"},
+		},
+		{
+			name:    "scoped",
+			options: map[string]string{"color:sharpness": "2", "color:enable-chroma-deltaq": "1", "alpha:sharpness": "0"},
+			args:    []string{"-a", "alpha:sharpness=0", "-a", "color:enable-chroma-deltaq=1", "-a", "color:sharpness=2"},
+		},
+	}
+
+	inputPath := filepath.Join(testdataDir, "source/colors/photo-like.png")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Logf("Testing AVIF encoding: %s", tc.name)
+
+			// Run avifenc command
+			cmdOutput := runAVIFEnc(t, inputPath, tc.args)
+
+			// Run library encoding
+			opts := DefaultAVIFEncodeOptions()
+			opts.CodecOptions = tc.options
+
+			libOutput, err := encodeAVIFWithLibrary(inputPath, opts)
+			if err != nil {
+				t.Fatalf("library encoding failed: %v", err)
+			}
+
+			// Compare outputs
+			compareAVIFOutputs(t, cmdOutput, libOutput)
+		})
+	}
+}
+
+// TestAVIF_CodecOptions tests codec option validation and reporting
+func TestAVIF_CodecOptions(t *testing.T) {
+	inputPath := filepath.Join(testdataDir, "source/sizes/small-128x128.png")
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatalf("failed to read input: %v", err)
+	}
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.CodecOptions = map[string]string{"tune": "ssim", "color:denoise-noise-level": "8"}
+
+	result, err := AVIFEncodeBytesWithResult(data, opts)
+	if err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	if len(result.Data) == 0 {
+		t.Fatal("empty output")
+	}
+	if len(result.CodecOptions) != 2 || result.CodecOptions["tune"] != "ssim" || result.CodecOptions["color:denoise-noise-level"] != "8" {
+		t.Errorf("unexpected reported codec options: %v", result.CodecOptions)
+	}
+
+	// The reported options are a copy
+	opts.CodecOptions["tune"] = "psnr"
+	if result.CodecOptions["tune"] != "ssim" {
+		t.Error("reported codec options alias the input map")
+	}
+
+	// Command API reports the options too
+	encOpts := NewDefaultAVIFEncOptions()
+	encOpts.CodecOptions = map[string]string{"alpha:sharpness": "3"}
+	cmd, err := NewAVIFEncCommand(&encOpts)
+	if err != nil {
+		t.Fatalf("failed to create command: %v", err)
+	}
+	defer cmd.Close()
+	cmdResult, err := cmd.RunWithResult(data)
+	if err != nil {
+		t.Fatalf("command encoding failed: %v", err)
+	}
+	if cmdResult.CodecOptions["alpha:sharpness"] != "3" {
+		t.Errorf("unexpected reported codec options: %v", cmdResult.CodecOptions)
+	}
+
+	invalid := []map[string]string{
+		{"sharpness": "8"},
+		{"sharpness": "abc"},
+		{"tune": "fast"},
+		{"": "1"},
+		{"color:": "1"},
+		{"Tune": "ssim"},
+		{"tune": ""},
+		{"no-such-aom-option": "1"},
+	}
+	for _, codecOptions := range invalid {
+		opts := DefaultAVIFEncodeOptions()
+		opts.CodecOptions = codecOptions
+		if _, err := AVIFEncodeBytes(data, opts); err == nil {
+			t.Errorf("expected error for %v", codecOptions)
+		}
+	}
+
+	// Invalid options are rejected when the command is created
+	encOpts.CodecOptions = map[string]string{"sharpness": "99"}
+	if _, err := NewAVIFEncCommand(&encOpts); err == nil {
+		t.Error("expected NewAVIFEncCommand to reject invalid codec options")
+	}
+
+	t.Logf("✓ codec options applied and reported: %v", result.CodecOptions)
+}
+
 // TestCompat_AVIF_Lossless tests AVIF lossless encoding
 func TestCompat_AVIF_Lossless(t *testing.T) {
 	setupAVIFCompatTest(t)
@@ -1275,6 +1798,11 @@ func convertToAVIFEncOptions(opts AVIFEncodeOptions) AVIFEncOptions {
 		CLLIMaxPALL:             opts.CLLI[1],
 		Timescale:               opts.Timescale,
 		KeyframeInterval:        opts.KeyframeInterval,
+		GridCols:                opts.GridCols,
+		GridRows:                opts.GridRows,
+		ProgressiveLayers:       opts.ProgressiveLayers,
+		ProgressiveLayerQuality: opts.ProgressiveLayerQuality,
+		CodecOptions:            opts.CodecOptions,
 	}
 }
 
@@ -0,0 +1,241 @@
+package libnextimage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeGainMapTestAVIF encodes an AVIF with a gain map from an HDR (PQ) source
+func encodeGainMapTestAVIF(t *testing.T) []byte {
+	t.Helper()
+
+	hdrData := encodeHDRTestAVIF(t, 16, 1000)
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.Speed = 10
+	opts.GainMapMode = GainMapFromHDR
+
+	avifData, err := AVIFEncodeBytes(hdrData, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode gain map AVIF: %v", err)
+	}
+	return avifData
+}
+
+// TestAVIFGainMap_FromHDR tests encoding an SDR base plus gain map from an HDR source
+func TestAVIFGainMap_FromHDR(t *testing.T) {
+	avifData := encodeGainMapTestAVIF(t)
+
+	info, err := AVIFGainMapInfo(avifData)
+	if err != nil {
+		t.Fatalf("AVIFGainMapInfo failed: %v", err)
+	}
+	if !info.Present {
+		t.Fatal("expected a gain map to be present")
+	}
+	if info.AlternateHDRHeadroom <= info.BaseHDRHeadroom {
+		t.Errorf("expected alternate headroom > base headroom: base=%.2f alternate=%.2f",
+			info.BaseHDRHeadroom, info.AlternateHDRHeadroom)
+	}
+	if info.AltTransferCharacteristics != 16 {
+		t.Errorf("expected PQ alternate transfer, got %d", info.AltTransferCharacteristics)
+	}
+
+	// The base image decodes as regular 8-bit SDR
+	base, err := AVIFDecodeBytes(avifData, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("base decode failed: %v", err)
+	}
+	if base.BitDepth != 8 {
+		t.Errorf("expected 8-bit base, got %d", base.BitDepth)
+	}
+	if base.Width != info.Width || base.Height != info.Height {
+		t.Errorf("gain map size %dx%d differs from base %dx%d", info.Width, info.Height, base.Width, base.Height)
+	}
+
+	t.Logf("✓ gain map %dx%d %d-bit, headroom base=%.2f alternate=%.2f",
+		info.Width, info.Height, info.BitDepth, info.BaseHDRHeadroom, info.AlternateHDRHeadroom)
+}
+
+// TestAVIFGainMap_FromPair tests encoding a gain map from a separate SDR base and HDR rendition
+func TestAVIFGainMap_FromPair(t *testing.T) {
+	hdrData := encodeHDRTestAVIF(t, 16, 1000)
+
+	// SDR base with the same dimensions: the SDR decode of the HDR rendition re-encoded as PNG
+	decOpts := NewDefaultAVIFDecOptions()
+	decOpts.ToneMapping = int(ToneMappingBT2390)
+	dec, err := NewAVIFDecCommand(&decOpts)
+	if err != nil {
+		t.Fatalf("Failed to create decoder: %v", err)
+	}
+	defer dec.Close()
+	sdrPNG, err := dec.Run(hdrData)
+	if err != nil {
+		t.Fatalf("Failed to create SDR base: %v", err)
+	}
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.Speed = 10
+	opts.GainMapMode = GainMapFromPair
+	opts.GainMapAlternate = hdrData
+	opts.GainMapDownscale = 1
+
+	avifData, err := AVIFEncodeBytes(sdrPNG, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode gain map AVIF from pair: %v", err)
+	}
+
+	info, err := AVIFGainMapInfo(avifData)
+	if err != nil {
+		t.Fatalf("AVIFGainMapInfo failed: %v", err)
+	}
+	if !info.Present {
+		t.Fatal("expected a gain map to be present")
+	}
+
+	base, err := AVIFDecodeBytes(avifData, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("base decode failed: %v", err)
+	}
+	if info.Width != base.Width/2 || info.Height != base.Height/2 {
+		t.Errorf("expected half size gain map, got %dx%d for %dx%d base", info.Width, info.Height, base.Width, base.Height)
+	}
+
+	// Missing alternate is rejected
+	opts.GainMapAlternate = nil
+	if _, err := AVIFEncodeBytes(sdrPNG, opts); err == nil {
+		t.Error("expected error when GainMapAlternate is missing")
+	}
+
+	t.Logf("✓ gain map from pair: %dx%d, %d bytes", info.Width, info.Height, len(avifData))
+}
+
+// TestAVIFGainMap_DecodeOutputs tests decoding the gain map image and renditions
+func TestAVIFGainMap_DecodeOutputs(t *testing.T) {
+	avifData := encodeGainMapTestAVIF(t)
+
+	base, err := AVIFDecodeBytes(avifData, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("base decode failed: %v", err)
+	}
+
+	// Gain map image
+	opts := DefaultAVIFDecodeOptions()
+	opts.GainMapOutput = GainMapOutputImage
+	gainMap, err := AVIFDecodeBytes(avifData, opts)
+	if err != nil {
+		t.Fatalf("gain map decode failed: %v", err)
+	}
+	if gainMap.Width == 0 || gainMap.Height == 0 {
+		t.Fatal("empty gain map image")
+	}
+
+	// SDR rendition (headroom 0) matches the base size and is 8-bit
+	opts.GainMapOutput = GainMapOutputRendition
+	opts.GainMapHeadroom = 0
+	sdr, err := AVIFDecodeBytes(avifData, opts)
+	if err != nil {
+		t.Fatalf("SDR rendition decode failed: %v", err)
+	}
+	if sdr.BitDepth != 8 || sdr.Width != base.Width || sdr.Height != base.Height {
+		t.Errorf("unexpected SDR rendition: %dx%d %d-bit", sdr.Width, sdr.Height, sdr.BitDepth)
+	}
+
+	// HDR rendition is 16-bit PQ
+	opts.GainMapHeadroom = 2.0
+	hdr, err := AVIFDecodeBytes(avifData, opts)
+	if err != nil {
+		t.Fatalf("HDR rendition decode failed: %v", err)
+	}
+	if hdr.BitDepth != 16 {
+		t.Errorf("expected 16-bit HDR rendition, got %d", hdr.BitDepth)
+	}
+	if len(hdr.Data) != base.Width*base.Height*4*2 {
+		t.Errorf("unexpected HDR rendition size: %d", len(hdr.Data))
+	}
+
+	t.Logf("✓ gain map image %dx%d, SDR rendition %d bytes, HDR rendition %d bytes",
+		gainMap.Width, gainMap.Height, len(sdr.Data), len(hdr.Data))
+}
+
+// TestAVIFGainMap_NoGainMap tests behaviour on images without a gain map
+func TestAVIFGainMap_NoGainMap(t *testing.T) {
+	avifData, err := AVIFEncodeFile(filepath.Join("..", "testdata", "png", "red.png"), DefaultAVIFEncodeOptions())
+	if err != nil {
+		t.Fatalf("Failed to encode AVIF: %v", err)
+	}
+
+	info, err := AVIFGainMapInfo(avifData)
+	if err != nil {
+		t.Fatalf("AVIFGainMapInfo failed: %v", err)
+	}
+	if info.Present {
+		t.Error("expected no gain map")
+	}
+
+	opts := DefaultAVIFDecodeOptions()
+	opts.GainMapOutput = GainMapOutputImage
+	if _, err := AVIFDecodeBytes(avifData, opts); err == nil {
+		t.Error("expected error when decoding a gain map from an image without one")
+	}
+
+	// An SDR source cannot be used for GainMapFromHDR
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "png", "red.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	encOpts := DefaultAVIFEncodeOptions()
+	encOpts.GainMapMode = GainMapFromHDR
+	encOpts.HDRTransferCharacteristics = 13 // sRGB
+	if _, err := AVIFEncodeBytes(data, encOpts); err == nil {
+		t.Error("expected error for non-HDR source with GainMapFromHDR")
+	}
+
+	t.Logf("✓ images without a gain map are reported correctly")
+}
+
+// TestAVIFGainMap_Commands tests gain maps through the avifenc/avifdec command interfaces
+func TestAVIFGainMap_Commands(t *testing.T) {
+	hdrData := encodeHDRTestAVIF(t, 16, 1000)
+
+	encOpts := NewDefaultAVIFEncOptions()
+	encOpts.Speed = 10
+	encOpts.GainMapMode = int(GainMapFromHDR)
+
+	enc, err := NewAVIFEncCommand(&encOpts)
+	if err != nil {
+		t.Fatalf("Failed to create avifenc command: %v", err)
+	}
+	defer enc.Close()
+
+	avifData, err := enc.Run(hdrData)
+	if err != nil {
+		t.Fatalf("avifenc Run failed: %v", err)
+	}
+
+	decOpts := NewDefaultAVIFDecOptions()
+	decOpts.GainMapOutput = int(GainMapOutputRendition)
+	decOpts.GainMapHeadroom = 2.0
+
+	dec, err := NewAVIFDecCommand(&decOpts)
+	if err != nil {
+		t.Fatalf("Failed to create avifdec command: %v", err)
+	}
+	defer dec.Close()
+
+	pngData, err := dec.Run(avifData)
+	if err != nil {
+		t.Fatalf("avifdec Run failed: %v", err)
+	}
+	// PNG IHDR bit depth byte is at offset 24
+	if !bytes.HasPrefix(pngData, []byte("\x89PNG")) || len(pngData) < 25 || pngData[24] != 16 {
+		t.Fatal("expected a 16-bit PNG rendition")
+	}
+	if cicp := pngCICP(pngData); cicp == nil || cicp[1] != 16 {
+		t.Errorf("expected a PQ cICP chunk, got %v", cicp)
+	}
+
+	t.Logf("✓ avifenc/avifdec gain map round trip: %d bytes AVIF, %d bytes PNG", len(avifData), len(pngData))
+}
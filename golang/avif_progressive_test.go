@@ -0,0 +1,288 @@
+package libnextimage
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chunkReader returns data in fixed-size chunks to simulate a network stream
+type chunkReader struct {
+	data  []byte
+	chunk int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunk
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// encodeProgressiveTestAVIF encodes a test image as a layered AVIF
+func encodeProgressiveTestAVIF(t *testing.T, layers int, qualities [4]int) []byte {
+	t.Helper()
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.Speed = 10
+	opts.ProgressiveLayers = layers
+	opts.ProgressiveLayerQuality = qualities
+
+	avifData, err := AVIFEncodeFile(filepath.Join(testdataDir, "source/colors/photo-like.png"), opts)
+	if err != nil {
+		t.Fatalf("progressive encoding failed: %v", err)
+	}
+	return avifData
+}
+
+// TestAVIFProgressive_DecodeLayers tests that each layer of a layered AVIF is returned
+func TestAVIFProgressive_DecodeLayers(t *testing.T) {
+	testCases := []struct {
+		name      string
+		layers    int
+		qualities [4]int
+	}{
+		{"2-layers-auto", 2, [4]int{-1, -1, -1, -1}},
+		{"3-layers-auto", 3, [4]int{-1, -1, -1, -1}},
+		{"2-layers-explicit", 2, [4]int{20, 90, -1, -1}},
+	}
+
+	decoder, err := NewAVIFDecoder(nil)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			avifData := encodeProgressiveTestAVIF(t, tc.layers, tc.qualities)
+
+			layers, count, err := decoder.DecodeLayers(avifData, true)
+			if err != nil {
+				t.Fatalf("DecodeLayers failed: %v", err)
+			}
+			if count != tc.layers || len(layers) != tc.layers {
+				t.Fatalf("expected %d layers, got %d of %d", tc.layers, len(layers), count)
+			}
+			for i, layer := range layers {
+				if layer.Width != 512 || layer.Height != 512 {
+					t.Errorf("layer %d: unexpected size %dx%d", i, layer.Width, layer.Height)
+				}
+			}
+			if bytes.Equal(layers[0].Data, layers[len(layers)-1].Data) {
+				t.Error("first and final layers are identical")
+			}
+
+			// Regular decoding returns the final layer
+			final, err := decoder.Decode(avifData)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !bytes.Equal(final.Data, layers[len(layers)-1].Data) {
+				t.Error("final layer differs from regular decode")
+			}
+
+			t.Logf("✓ %s: %d bytes, %d layers", tc.name, len(avifData), count)
+		})
+	}
+}
+
+// TestAVIFProgressive_PartialData tests that layers become available as more data arrives
+func TestAVIFProgressive_PartialData(t *testing.T) {
+	avifData := encodeProgressiveTestAVIF(t, 2, [4]int{-1, -1, -1, -1})
+
+	decoder, err := NewAVIFDecoder(nil)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	// Too little data for the header
+	layers, count, err := decoder.DecodeLayers(avifData[:16], false)
+	if err != nil {
+		t.Fatalf("DecodeLayers on header prefix failed: %v", err)
+	}
+	if count != 0 || len(layers) != 0 {
+		t.Errorf("expected nothing from a 16 byte prefix, got %d of %d layers", len(layers), count)
+	}
+
+	// The number of available layers never decreases as the prefix grows
+	prev := 0
+	sawPartial := false
+	for size := 256; size < len(avifData); size += 256 {
+		layers, count, err := decoder.DecodeLayers(avifData[:size], false)
+		if err != nil {
+			t.Fatalf("DecodeLayers on %d byte prefix failed: %v", size, err)
+		}
+		if len(layers) < prev {
+			t.Fatalf("layers decreased from %d to %d at %d bytes", prev, len(layers), size)
+		}
+		if count == 2 && len(layers) == 1 {
+			sawPartial = true
+		}
+		prev = len(layers)
+	}
+	if !sawPartial {
+		t.Error("expected a prefix with only the first layer available")
+	}
+
+	// Truncated data marked complete is an error
+	if _, _, err := decoder.DecodeLayers(avifData[:len(avifData)/2], true); err == nil {
+		t.Error("expected error for truncated data")
+	}
+
+	t.Logf("✓ layers become available progressively over %d bytes", len(avifData))
+}
+
+// TestAVIFProgressive_DecodeLayersFrom tests streaming layer delivery from an io.Reader
+func TestAVIFProgressive_DecodeLayersFrom(t *testing.T) {
+	avifData := encodeProgressiveTestAVIF(t, 3, [4]int{-1, -1, -1, -1})
+
+	decoder, err := NewAVIFDecoder(nil)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	var indexes []int
+	err = decoder.DecodeLayersFrom(&chunkReader{data: avifData, chunk: 512}, func(layer *DecodedImage, index, count int) error {
+		if count != 3 {
+			t.Errorf("expected 3 layers, got %d", count)
+		}
+		if layer.Width != 512 {
+			t.Errorf("layer %d: unexpected width %d", index, layer.Width)
+		}
+		indexes = append(indexes, index)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeLayersFrom failed: %v", err)
+	}
+	if len(indexes) != 3 || indexes[0] != 0 || indexes[1] != 1 || indexes[2] != 2 {
+		t.Errorf("unexpected layer order: %v", indexes)
+	}
+
+	// A non-progressive image is a single layer
+	plain, err := AVIFEncodeFile(filepath.Join(testdataDir, "png/red.png"), DefaultAVIFEncodeOptions())
+	if err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	calls := 0
+	err = decoder.DecodeLayersFrom(bytes.NewReader(plain), func(layer *DecodedImage, index, count int) error {
+		calls++
+		if count != 1 {
+			t.Errorf("expected 1 layer, got %d", count)
+		}
+		return nil
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("non-progressive image: calls=%d err=%v", calls, err)
+	}
+
+	t.Logf("✓ streamed layers %v", indexes)
+}
+
+// TestAVIFProgressive_DecodeLayersFromSmallReads tests that layers streamed in small reads
+// match the layers decoded from the whole file
+func TestAVIFProgressive_DecodeLayersFromSmallReads(t *testing.T) {
+	avifData := encodeProgressiveTestAVIF(t, 3, [4]int{-1, -1, -1, -1})
+
+	decoder, err := NewAVIFDecoder(nil)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	want, _, err := decoder.DecodeLayers(avifData, true)
+	if err != nil {
+		t.Fatalf("DecodeLayers failed: %v", err)
+	}
+
+	var got []*DecodedImage
+	err = decoder.DecodeLayersFrom(&chunkReader{data: avifData, chunk: 7}, func(layer *DecodedImage, index, count int) error {
+		got = append(got, layer)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeLayersFrom failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("streamed %d layers, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("layer %d differs from DecodeLayers", i)
+		}
+	}
+}
+
+// TestAVIFProgressive_DecodeLayersFromNoHeader tests that a stream without an image
+// header near its start is rejected instead of being buffered
+func TestAVIFProgressive_DecodeLayersFromNoHeader(t *testing.T) {
+	decoder, err := NewAVIFDecoder(nil)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	// ftyp, then a 1 GiB free box where meta would be
+	head := []byte("\x00\x00\x00\x14ftypavif\x00\x00\x00\x00avif\x40\x00\x00\x00free")
+	r := io.MultiReader(bytes.NewReader(head), io.LimitReader(zeroReader{}, 1<<30))
+	err = decoder.DecodeLayersFrom(r, func(layer *DecodedImage, index, count int) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "no image header") {
+		t.Errorf("expected a missing header error, got %v", err)
+	}
+}
+
+// zeroReader reads zeros forever
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// TestAVIFProgressive_Invalid tests progressive option validation
+func TestAVIFProgressive_Invalid(t *testing.T) {
+	inputPath := filepath.Join(testdataDir, "source/sizes/medium-512x512.png")
+
+	testCases := []struct {
+		name string
+		fn   func(*AVIFEncodeOptions)
+	}{
+		{"too-many-layers", func(o *AVIFEncodeOptions) { o.ProgressiveLayers = 5 }},
+		{"negative-layers", func(o *AVIFEncodeOptions) { o.ProgressiveLayers = -2 }},
+		{"invalid-quality", func(o *AVIFEncodeOptions) {
+			o.ProgressiveLayers = 2
+			o.ProgressiveLayerQuality = [4]int{101, -1, -1, -1}
+		}},
+		{"with-grid", func(o *AVIFEncodeOptions) {
+			o.ProgressiveLayers = 2
+			o.GridCols = 2
+			o.GridRows = 2
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultAVIFEncodeOptions()
+			tc.fn(&opts)
+			if _, err := AVIFEncodeFile(inputPath, opts); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package libnextimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeHDRTestAVIF encodes a 16-bit PNG source as a 10-bit BT.2020 AVIF with the given transfer
+func encodeHDRTestAVIF(t *testing.T, transfer int, maxCLL int) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "source", "avif-specific", "10bit-source.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.BitDepth = 10
+	opts.Speed = 10
+	opts.ColorPrimaries = 9 // BT.2020
+	opts.TransferCharacteristics = transfer
+	opts.MatrixCoefficients = 9 // BT.2020 NCL
+	if maxCLL > 0 {
+		opts.CLLI = [2]int{maxCLL, maxCLL / 4}
+	}
+
+	avifData, err := AVIFEncodeBytes(data, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode HDR AVIF: %v", err)
+	}
+	return avifData
+}
+
+// meanValue returns the average of the color channels of an RGBA image
+func meanValue(img *DecodedImage) float64 {
+	var sum float64
+	var count int
+	for i := 0; i+3 < len(img.Data); i += 4 {
+		sum += float64(img.Data[i]) + float64(img.Data[i+1]) + float64(img.Data[i+2])
+		count += 3
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// TestAVIFDecode_ToneMapping tests HDR to SDR tone mapping for PQ and HLG images
+func TestAVIFDecode_ToneMapping(t *testing.T) {
+	sources := []struct {
+		name     string
+		transfer int
+	}{
+		{"pq", 16},
+		{"hlg", 18},
+	}
+
+	operators := []struct {
+		name string
+		op   AVIFToneMapping
+	}{
+		{"bt2390", ToneMappingBT2390},
+		{"reinhard", ToneMappingReinhard},
+		{"hable", ToneMappingHable},
+	}
+
+	for _, src := range sources {
+		avifData := encodeHDRTestAVIF(t, src.transfer, 0)
+
+		plain, err := AVIFDecodeBytes(avifData, DefaultAVIFDecodeOptions())
+		if err != nil {
+			t.Fatalf("%s: decode without tone mapping failed: %v", src.name, err)
+		}
+
+		outputs := make(map[string][]byte)
+		for _, op := range operators {
+			t.Run(src.name+"-"+op.name, func(t *testing.T) {
+				opts := DefaultAVIFDecodeOptions()
+				opts.ToneMapping = op.op
+
+				img, err := AVIFDecodeBytes(avifData, opts)
+				if err != nil {
+					t.Fatalf("decode with tone mapping failed: %v", err)
+				}
+				if img.Width != plain.Width || img.Height != plain.Height || img.BitDepth != 8 {
+					t.Fatalf("unexpected image: %dx%d %d-bit", img.Width, img.Height, img.BitDepth)
+				}
+				if bytes.Equal(img.Data, plain.Data) {
+					t.Error("tone mapped output is identical to the untone-mapped output")
+				}
+				outputs[op.name] = img.Data
+
+				t.Logf("✓ %s/%s: mean %.1f (untone-mapped %.1f)", src.name, op.name, meanValue(img), meanValue(plain))
+			})
+		}
+
+		if bytes.Equal(outputs["bt2390"], outputs["reinhard"]) || bytes.Equal(outputs["reinhard"], outputs["hable"]) {
+			t.Errorf("%s: tone mapping operators produced identical output", src.name)
+		}
+	}
+}
+
+// TestAVIFDecode_ToneMappingSourcePeak tests that a brighter source peak compresses more
+func TestAVIFDecode_ToneMappingSourcePeak(t *testing.T) {
+	decodeMean := func(avifData []byte, sourcePeak int) float64 {
+		opts := DefaultAVIFDecodeOptions()
+		opts.ToneMapping = ToneMappingReinhard
+		opts.ToneMappingSourcePeak = sourcePeak
+		img, err := AVIFDecodeBytes(avifData, opts)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		return meanValue(img)
+	}
+
+	avifData := encodeHDRTestAVIF(t, 16, 0)
+	mean1000 := decodeMean(avifData, 1000)
+	mean4000 := decodeMean(avifData, 4000)
+	if mean4000 >= mean1000 {
+		t.Errorf("expected darker output for 4000 nit peak: 1000=%.2f 4000=%.2f", mean1000, mean4000)
+	}
+
+	// CLLI maxCLL is used when no explicit peak is given
+	withCLLI := encodeHDRTestAVIF(t, 16, 4000)
+	meanCLLI := decodeMean(withCLLI, 0)
+	if meanCLLI != decodeMean(withCLLI, 4000) {
+		t.Errorf("expected CLLI maxCLL to be used as source peak")
+	}
+
+	t.Logf("✓ source peak 1000=%.2f, 4000=%.2f, CLLI=%.2f", mean1000, mean4000, meanCLLI)
+}
+
+// TestAVIFDecode_ToneMappingMasteringPeak tests that the mdcv peak luminance is the
+// source peak when there is no CLLI
+func TestAVIFDecode_ToneMappingMasteringPeak(t *testing.T) {
+	decodeMean := func(avifData []byte, sourcePeak int) float64 {
+		opts := DefaultAVIFDecodeOptions()
+		opts.ToneMapping = ToneMappingReinhard
+		opts.ToneMappingSourcePeak = sourcePeak
+		img, err := AVIFDecodeBytes(avifData, opts)
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		return meanValue(img)
+	}
+
+	avifData := encodeHDRTestAVIF(t, 16, 0)
+	withPeak := withMDCV(t, avifData, 4000)
+	meanMDCV := decodeMean(withPeak, 0)
+	if meanMDCV != decodeMean(avifData, 4000) {
+		t.Errorf("expected the mdcv peak to be used as source peak")
+	}
+	if meanMDCV == decodeMean(avifData, 0) {
+		t.Errorf("mdcv peak had no effect")
+	}
+
+	// An explicit peak still wins
+	if decodeMean(withPeak, 1000) != decodeMean(avifData, 1000) {
+		t.Errorf("expected an explicit source peak to override mdcv")
+	}
+
+	t.Logf("✓ mdcv source peak: %.2f", meanMDCV)
+}
+
+// TestAVIFDecode_ToneMappingSDRUnchanged tests that SDR images are not affected by tone mapping
+func TestAVIFDecode_ToneMappingSDRUnchanged(t *testing.T) {
+	avifData, err := AVIFEncodeFile(filepath.Join("..", "testdata", "png", "red.png"), DefaultAVIFEncodeOptions())
+	if err != nil {
+		t.Fatalf("Failed to encode SDR AVIF: %v", err)
+	}
+
+	plain, err := AVIFDecodeBytes(avifData, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	opts := DefaultAVIFDecodeOptions()
+	opts.ToneMapping = ToneMappingBT2390
+	mapped, err := AVIFDecodeBytes(avifData, opts)
+	if err != nil {
+		t.Fatalf("decode with tone mapping failed: %v", err)
+	}
+
+	if !bytes.Equal(plain.Data, mapped.Data) {
+		t.Error("SDR image changed when tone mapping was enabled")
+	}
+
+	// Invalid operator is rejected
+	opts.ToneMapping = AVIFToneMapping(99)
+	if _, err := AVIFDecodeBytes(avifData, opts); err == nil {
+		t.Error("expected error for invalid tone mapping operator")
+	}
+
+	t.Logf("✓ SDR image unchanged by tone mapping")
+}
+
+// TestAVIFDecCommand_ToneMapping tests tone mapping through the avifdec command interface
+func TestAVIFDecCommand_ToneMapping(t *testing.T) {
+	avifData := encodeHDRTestAVIF(t, 16, 1000)
+
+	opts := NewDefaultAVIFDecOptions()
+	if opts.ToneMappingTargetNits != 203 {
+		t.Errorf("expected default target of 203 nits, got %d", opts.ToneMappingTargetNits)
+	}
+	opts.ToneMapping = int(ToneMappingBT2390)
+
+	cmd, err := NewAVIFDecCommand(&opts)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	defer cmd.Close()
+
+	pngData, err := cmd.Run(avifData)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !bytes.HasPrefix(pngData, []byte("\x89PNG")) {
+		t.Fatal("output is not a PNG")
+	}
+
+	if cicp := pngCICP(pngData); cicp != nil {
+		t.Errorf("tone mapped PNG has a cICP chunk %v", cicp)
+	}
+
+	// Without tone mapping the PNG keeps PQ and says so
+	plainOpts := NewDefaultAVIFDecOptions()
+	plain, err := NewAVIFDecCommand(&plainOpts)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	defer plain.Close()
+	plainPNG, err := plain.Run(avifData)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if cicp := pngCICP(plainPNG); cicp == nil || cicp[0] != 9 || cicp[1] != 16 {
+		t.Errorf("expected a BT.2020 PQ cICP chunk, got %v", cicp)
+	}
+
+	t.Logf("✓ avifdec tone mapped PNG: %d bytes", len(pngData))
+}
+
+// pngCICP returns the payload of the cICP chunk of a PNG, or nil
+func pngCICP(pngData []byte) []byte {
+	for pos := 8; pos+12 <= len(pngData); {
+		length := int(binary.BigEndian.Uint32(pngData[pos:]))
+		if pos+12+length > len(pngData) {
+			return nil
+		}
+		switch string(pngData[pos+4 : pos+8]) {
+		case "cICP":
+			return pngData[pos+8 : pos+8+length]
+		case "IDAT":
+			return nil
+		}
+		pos += 12 + length
+	}
+	return nil
+}
+
+// withMDCV inserts an mdcv property with the given peak luminance into the ipco box
+// of an AVIF file written by libavif, moving the iloc offsets past the inserted bytes
+func withMDCV(t *testing.T, avifData []byte, peakNits uint32) []byte {
+	t.Helper()
+	be := binary.BigEndian
+
+	mdcv := make([]byte, 32)
+	be.PutUint32(mdcv, 32)
+	copy(mdcv[4:], "mdcv")
+	be.PutUint32(mdcv[24:], peakNits*10000) // 0.0001 cd/m2 units
+	be.PutUint32(mdcv[28:], 50)
+
+	// children returns the offsets of the boxes in data[start:end]
+	children := func(start, end int) map[string]int {
+		boxes := make(map[string]int)
+		for pos := start; pos+8 <= end; {
+			size := int(be.Uint32(avifData[pos:]))
+			if size < 8 || pos+size > end {
+				t.Fatalf("unsupported box layout at %d", pos)
+			}
+			boxes[string(avifData[pos+4:pos+8])] = pos
+			pos += size
+		}
+		return boxes
+	}
+	boxEnd := func(pos int) int { return pos + int(be.Uint32(avifData[pos:])) }
+
+	top := children(0, len(avifData))
+	meta, mdat := top["meta"], top["mdat"]
+	inMeta := children(meta+12, boxEnd(meta)) // meta is a FullBox
+	iprp := inMeta["iprp"]
+	ipco := children(iprp+8, boxEnd(iprp))["ipco"]
+	insert := boxEnd(ipco)
+
+	out := append(append(append([]byte{}, avifData[:insert]...), mdcv...), avifData[insert:]...)
+	for _, pos := range []int{meta, iprp, ipco} {
+		be.PutUint32(out[pos:], be.Uint32(out[pos:])+32)
+	}
+	if mdat < meta {
+		return out
+	}
+
+	// iloc file offsets (construction method 0) point into mdat, now 32 bytes later
+	pos := inMeta["iloc"] + 8
+	version := out[pos]
+	offsetSize, lengthSize := int(out[pos+4]>>4), int(out[pos+4]&0xf)
+	baseOffsetSize, indexSize := int(out[pos+5]>>4), 0
+	if version > 0 {
+		indexSize = int(out[pos+5] & 0xf)
+	}
+	pos += 6
+	readN := func(n int) uint64 {
+		var v uint64
+		for i := 0; i < n; i++ {
+			v = v<<8 | uint64(out[pos+i])
+		}
+		pos += n
+		return v
+	}
+	shift := func(n int) {
+		v := readN(n) + 32
+		for i := 1; i <= n; i++ {
+			out[pos-i] = byte(v)
+			v >>= 8
+		}
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	itemCount := readN(idSize)
+	for i := uint64(0); i < itemCount; i++ {
+		readN(idSize)
+		method := uint64(0)
+		if version > 0 {
+			method = readN(2) & 0xf
+		}
+		readN(2) // data_reference_index
+		if method == 0 && baseOffsetSize > 0 {
+			shift(baseOffsetSize)
+		} else {
+			readN(baseOffsetSize)
+		}
+		extents := readN(2)
+		for e := uint64(0); e < extents; e++ {
+			readN(indexSize)
+			if method == 0 && baseOffsetSize == 0 {
+				shift(offsetSize)
+			} else {
+				readN(offsetSize)
+			}
+			readN(lengthSize)
+		}
+	}
+	return out
+}
@@ -75,9 +75,19 @@ type AVIFDecOptions struct {
 	ResizeWidth  int  // resize width
 	ResizeHeight int  // resize height
 	UseResize    bool // enable resizing
+
+	// HDR -> SDR tone mapping (PQ/HLG images only)
+	ToneMapping           int // 0=none (default), 1=BT.2390 EETF, 2=Reinhard, 3=Hable
+	ToneMappingSourcePeak int // source peak luminance in nits, 0=auto (CLLI maxCLL, else mdcv max luminance, else 1000)
+	ToneMappingTargetNits int // SDR white luminance in nits (default: 203)
+
+	// Gain map (ISO 21496-1)
+	GainMapOutput   int     // 0=base image (default), 1=gain map image, 2=rendition for GainMapHeadroom
+	GainMapHeadroom float32 // target HDR headroom in log2 stops for GainMapOutput=2; >0 writes a 16-bit PQ PNG with a cICP chunk (default: 0)
 }
 
-// Command represents an AVIF decoder command that can be reused for multiple conversions
+// Command represents an AVIF decoder command that can be reused for multiple conversions.
+// Like the AVIFDecoder whose C decoder it wraps, it is not safe for concurrent use.
 type AVIFDecCommand struct {
 	cmd *C.AVIFDecCommand
 }
@@ -127,6 +137,9 @@ func NewDefaultAVIFDecOptions() AVIFDecOptions {
 			ImageDimensionLimit: 32768,
 			StrictFlags:         1,
 			ChromaUpsampling:    0,
+
+			// HDR -> SDR tone mapping
+			ToneMappingTargetNits: 203,
 		}
 	}
 	defer C.avifdec_free_options(cOpts)
@@ -151,6 +164,13 @@ func NewDefaultAVIFDecOptions() AVIFDecOptions {
 		ResizeWidth:         int(cOpts.resize_width),
 		ResizeHeight:        int(cOpts.resize_height),
 		UseResize:           cOpts.use_resize != 0,
+
+		// HDR -> SDR tone mapping and gain map
+		ToneMapping:           int(cOpts.tone_mapping),
+		ToneMappingSourcePeak: int(cOpts.tone_mapping_source_peak),
+		ToneMappingTargetNits: int(cOpts.tone_mapping_target_nits),
+		GainMapOutput:         int(cOpts.gain_map_output),
+		GainMapHeadroom:       float32(cOpts.gain_map_headroom),
 	}
 }
 
@@ -210,6 +230,15 @@ func avifdecOptionsToCOptions(opts AVIFDecOptions) *C.AVIFDecOptions {
 		cOpts.use_resize = 0
 	}
 
+	// HDR -> SDR tone mapping
+	cOpts.tone_mapping = C.int(opts.ToneMapping)
+	cOpts.tone_mapping_source_peak = C.int(opts.ToneMappingSourcePeak)
+	cOpts.tone_mapping_target_nits = C.int(opts.ToneMappingTargetNits)
+
+	// Gain map
+	cOpts.gain_map_output = C.int(opts.GainMapOutput)
+	cOpts.gain_map_headroom = C.float(opts.GainMapHeadroom)
+
 	return cOpts
 }
 
@@ -219,6 +248,9 @@ func avifdecOptionsToCOptions(opts AVIFDecOptions) *C.AVIFDecOptions {
 func NewAVIFDecCommand(opts *AVIFDecOptions) (*AVIFDecCommand, error) {
 	var cOpts *C.AVIFDecOptions
 	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to create avifdec command: %w", err)
+		}
 		cOpts = avifdecOptionsToCOptions(*opts)
 		if cOpts == nil {
 			return nil, fmt.Errorf("failed to create options")
@@ -245,14 +277,29 @@ func NewAVIFDecCommand(opts *AVIFDecOptions) (*AVIFDecCommand, error) {
 // Run converts AVIF data to PNG format.
 // This is the core method that performs the conversion.
 func (c *AVIFDecCommand) Run(avifData []byte) ([]byte, error) {
+	output, err := c.run(avifData)
+	if err != nil {
+		return nil, err
+	}
+
+	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	C.nextimage_free_buffer(&output)
+	return result, nil
+}
+
+// run converts the input into a C buffer, which the caller frees
+func (c *AVIFDecCommand) run(avifData []byte) (C.NextImageBuffer, error) {
+	var output C.NextImageBuffer
 	if c.cmd == nil {
-		return nil, fmt.Errorf("command is closed")
+		return output, fmt.Errorf("command is closed")
 	}
 	if len(avifData) == 0 {
-		return nil, fmt.Errorf("input data is empty")
+		return output, fmt.Errorf("input data is empty")
+	}
+	if err := checkLimits(avifData); err != nil {
+		return output, err
 	}
 
-	var output C.NextImageBuffer
 	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
 
 	status := C.avifdec_run_command(
@@ -264,16 +311,14 @@ func (c *AVIFDecCommand) Run(avifData []byte) ([]byte, error) {
 
 	if status != C.NEXTIMAGE_OK {
 		errMsg := C.nextimage_last_error_message()
-		return nil, fmt.Errorf("avifdec decoding failed (status %d): %s", status, C.GoString(errMsg))
+		return output, fmt.Errorf("avifdec decoding failed (status %d): %s", status, C.GoString(errMsg))
 	}
 
 	if output.data == nil || output.size == 0 {
-		return nil, fmt.Errorf("decoding produced empty output")
+		return output, fmt.Errorf("decoding produced empty output")
 	}
 
-	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
-	C.nextimage_free_buffer(&output)
-	return result, nil
+	return output, nil
 }
 
 // RunFile reads an AVIF file, converts it to PNG, and writes the result to outputPath.
@@ -307,17 +352,18 @@ func (c *AVIFDecCommand) RunIO(input io.Reader, output io.Writer) error {
 		return fmt.Errorf("command is closed")
 	}
 
-	inputData, err := io.ReadAll(input)
+	inputData, err := readAllLimited(input)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	pngData, err := c.Run(inputData)
+	buf, err := c.run(inputData)
 	if err != nil {
 		return err
 	}
-
-	if _, err := output.Write(pngData); err != nil {
+	_, err = writeBuffer(output, &buf)
+	C.nextimage_free_buffer(&buf)
+	if err != nil {
 		return fmt.Errorf("failed to write output: %w", err)
 	}
 
@@ -33,6 +33,7 @@ package libnextimage
 #include <string.h>
 #include "nextimage.h"
 #include "nextimage/avifenc.h"
+#include "avif.h"
 */
 import "C"
 import (
@@ -40,6 +41,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"time"
 	"unsafe"
 )
 
@@ -57,7 +59,7 @@ type AVIFEncOptions struct {
 	MaxQuantizerAlpha int // 0-63, default -1 (use quality_alpha instead)
 
 	// Format settings
-	BitDepth  int // 8, 10, or 12 (default: 8)
+	BitDepth  int // 8, 10, 12, or 0=auto (follow input, see AVIFBitDepthAuto) (default: 8)
 	YUVFormat int // 0=444, 1=422, 2=420, 3=400 (default: 444)
 	YUVRange  int // 0=limited, 1=full (default: 1=full for PNG/JPEG)
 
@@ -103,11 +105,35 @@ type AVIFEncOptions struct {
 	// Animation settings (for future use)
 	Timescale        int // timescale/fps for animations (default: 30)
 	KeyframeInterval int // max keyframe interval (default: 0=disabled)
+
+	// Gain map (ISO 21496-1)
+	GainMapMode                int    // 0=none (default), 1=input is SDR base + GainMapAlternate is HDR, 2=input is HDR
+	GainMapAlternate           []byte // HDR rendition image file (AVIF, or 16-bit PNG/TIFF) for GainMapMode=1
+	GainMapQuality             int    // 0-100, gain map quality (default: 60)
+	GainMapDownscale           int    // gain map downscaling as log2: 0=full size (default), 1=half, 2=quarter...
+	GainMapBitDepth            int    // 8, 10, or 12 (default: 8)
+	GainMapToneMapping         int    // SDR base tone mapping for GainMapMode=2: 1=BT.2390 (default), 2=Reinhard, 3=Hable
+	HDRColorPrimaries          int    // CICP primaries of non-AVIF HDR inputs, -1=auto (9=BT.2020)
+	HDRTransferCharacteristics int    // CICP transfer of non-AVIF HDR inputs, -1=auto (16=PQ)
+
+	// Grid encoding (--grid MxN): width/height must divide evenly into the cells
+	GridCols int // grid columns M (1-256), 0=disabled (default)
+	GridRows int // grid rows N (1-256), 0=disabled (default)
+
+	// Progressive (layered) encoding (--progressive / --layered)
+	ProgressiveLayers       int    // number of layers 2-4, 0=disabled (default)
+	ProgressiveLayerQuality [4]int // per-layer quality 0-100 from coarsest to final, -1=auto (default)
+
+	// Codec-specific options (-a key=value); prefix keys with "color:"/"alpha:" to limit their scope
+	CodecOptions map[string]string
 }
 
-// Command represents an AVIF encoder command that can be reused for multiple conversions
+// Command represents an AVIF encoder command that can be reused for multiple conversions.
+// It holds one C AVIF encoder; as with AVIFEncoder, give each goroutine its own command.
 type AVIFEncCommand struct {
-	cmd *C.AVIFEncCommand
+	cmd          *C.AVIFEncCommand
+	codecOptions map[string]string
+	crop         [4]int // Crop, checked against each input
 }
 
 // NewDefaultOptions creates a new Options struct with default values
@@ -144,6 +170,13 @@ func NewDefaultAVIFEncOptions() AVIFEncOptions {
 			CLLIMaxPALL:             -1,
 			Timescale:               30,
 			KeyframeInterval:        0,
+
+			GainMapQuality:             60,
+			GainMapBitDepth:            8,
+			GainMapToneMapping:         1,
+			HDRColorPrimaries:          -1,
+			HDRTransferCharacteristics: -1,
+			ProgressiveLayerQuality:    [4]int{-1, -1, -1, -1},
 		}
 	}
 	defer C.avifenc_free_options(cOpts)
@@ -177,6 +210,18 @@ func NewDefaultAVIFEncOptions() AVIFEncOptions {
 		CLLIMaxPALL:             int(cOpts.clli_max_pall),
 		Timescale:               int(cOpts.timescale),
 		KeyframeInterval:        int(cOpts.keyframe_interval),
+
+		GainMapMode:                int(cOpts.gain_map_mode),
+		GainMapQuality:             int(cOpts.gain_map_quality),
+		GainMapDownscale:           int(cOpts.gain_map_downscale),
+		GainMapBitDepth:            int(cOpts.gain_map_bit_depth),
+		GainMapToneMapping:         int(cOpts.gain_map_tone_mapping),
+		HDRColorPrimaries:          int(cOpts.hdr_color_primaries),
+		HDRTransferCharacteristics: int(cOpts.hdr_transfer_characteristics),
+		GridCols:                   int(cOpts.grid_cols),
+		GridRows:                   int(cOpts.grid_rows),
+		ProgressiveLayers:          int(cOpts.progressive_layers),
+		ProgressiveLayerQuality:    [4]int{int(cOpts.progressive_layer_quality[0]), int(cOpts.progressive_layer_quality[1]), int(cOpts.progressive_layer_quality[2]), int(cOpts.progressive_layer_quality[3])},
 	}
 }
 
@@ -274,6 +319,32 @@ func avifencOptionsToCOptions(opts AVIFEncOptions) *C.AVIFEncOptions {
 	cOpts.timescale = C.int(opts.Timescale)
 	cOpts.keyframe_interval = C.int(opts.KeyframeInterval)
 
+	// Gain map (the encoder copies the alternate data when the command is created)
+	cOpts.gain_map_mode = C.int(opts.GainMapMode)
+	if len(opts.GainMapAlternate) > 0 {
+		cOpts.gain_map_alternate_data = (*C.uint8_t)(unsafe.Pointer(&opts.GainMapAlternate[0]))
+		cOpts.gain_map_alternate_size = C.size_t(len(opts.GainMapAlternate))
+	} else {
+		cOpts.gain_map_alternate_data = nil
+		cOpts.gain_map_alternate_size = 0
+	}
+	cOpts.gain_map_quality = C.int(opts.GainMapQuality)
+	cOpts.gain_map_downscale = C.int(opts.GainMapDownscale)
+	cOpts.gain_map_bit_depth = C.int(opts.GainMapBitDepth)
+	cOpts.gain_map_tone_mapping = C.int(opts.GainMapToneMapping)
+	cOpts.hdr_color_primaries = C.int(opts.HDRColorPrimaries)
+	cOpts.hdr_transfer_characteristics = C.int(opts.HDRTransferCharacteristics)
+
+	// Grid
+	cOpts.grid_cols = C.int(opts.GridCols)
+	cOpts.grid_rows = C.int(opts.GridRows)
+
+	// Progressive
+	cOpts.progressive_layers = C.int(opts.ProgressiveLayers)
+	for i := 0; i < len(opts.ProgressiveLayerQuality); i++ {
+		cOpts.progressive_layer_quality[i] = C.int(opts.ProgressiveLayerQuality[i])
+	}
+
 	return cOpts
 }
 
@@ -282,11 +353,23 @@ func avifencOptionsToCOptions(opts AVIFEncOptions) *C.AVIFEncOptions {
 // The returned Command must be closed with Close() when done.
 func NewAVIFEncCommand(opts *AVIFEncOptions) (*AVIFEncCommand, error) {
 	var cOpts *C.AVIFEncOptions
+	var codecOptions map[string]string
 	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to create avifenc command: %w", err)
+		}
 		cOpts = avifencOptionsToCOptions(*opts)
 		if cOpts == nil {
 			return nil, fmt.Errorf("failed to create options")
 		}
+
+		// The command keeps its own copy of the codec options
+		codecOpts := newCCodecOptions(opts.CodecOptions)
+		defer codecOpts.free()
+		cOpts.codec_option_keys = codecOpts.keys
+		cOpts.codec_option_values = codecOpts.values
+		cOpts.codec_option_count = codecOpts.count
+		codecOptions = copyCodecOptions(opts.CodecOptions)
 	}
 
 	cCmd := C.avifenc_new_command(cOpts)
@@ -299,7 +382,10 @@ func NewAVIFEncCommand(opts *AVIFEncOptions) (*AVIFEncCommand, error) {
 		return nil, fmt.Errorf("failed to create avifenc command: %s", C.GoString(errMsg))
 	}
 
-	cmd := &AVIFEncCommand{cmd: cCmd}
+	cmd := &AVIFEncCommand{cmd: cCmd, codecOptions: codecOptions}
+	if opts != nil {
+		cmd.crop = opts.Crop
+	}
 	runtime.SetFinalizer(cmd, func(c *AVIFEncCommand) {
 		_ = c.Close()
 	})
@@ -315,6 +401,9 @@ func (c *AVIFEncCommand) Run(imageData []byte) ([]byte, error) {
 	if len(imageData) == 0 {
 		return nil, fmt.Errorf("input data is empty")
 	}
+	if err := checkLimits(imageData); err != nil {
+		return nil, err
+	}
 
 	var output C.NextImageBuffer
 	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
@@ -327,6 +416,9 @@ func (c *AVIFEncCommand) Run(imageData []byte) ([]byte, error) {
 	)
 
 	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "AVIFEncOptions", c.crop); err != nil {
+			return nil, err
+		}
 		errMsg := C.nextimage_last_error_message()
 		return nil, fmt.Errorf("avifenc encoding failed (status %d): %s", status, C.GoString(errMsg))
 	}
@@ -340,6 +432,55 @@ func (c *AVIFEncCommand) Run(imageData []byte) ([]byte, error) {
 	return result, nil
 }
 
+// RunWithResult converts image data to AVIF like Run and also reports how it was encoded.
+func (c *AVIFEncCommand) RunWithResult(imageData []byte) (*AVIFEncodeResult, error) {
+	if c.cmd == nil {
+		return nil, fmt.Errorf("command is closed")
+	}
+	if len(imageData) == 0 {
+		return nil, fmt.Errorf("input data is empty")
+	}
+	if err := checkLimits(imageData); err != nil {
+		return nil, err
+	}
+
+	var output C.NextImageBuffer
+	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
+	var stats C.NextImageAVIFEncodeStats
+
+	start := time.Now()
+	status := C.avifenc_run_command_with_stats(
+		c.cmd,
+		(*C.uint8_t)(unsafe.Pointer(&imageData[0])),
+		C.size_t(len(imageData)),
+		&output,
+		&stats,
+	)
+	duration := time.Since(start)
+
+	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "AVIFEncOptions", c.crop); err != nil {
+			return nil, err
+		}
+		errMsg := C.nextimage_last_error_message()
+		return nil, fmt.Errorf("avifenc encoding failed (status %d): %s", status, C.GoString(errMsg))
+	}
+
+	if output.data == nil || output.size == 0 {
+		return nil, fmt.Errorf("encoding produced empty output")
+	}
+
+	data := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	C.nextimage_free_buffer(&output)
+
+	return &AVIFEncodeResult{
+		Data:         data,
+		CodecOptions: copyCodecOptions(c.codecOptions),
+		Stats:        convertAVIFEncodeStats(&stats),
+		Duration:     duration,
+	}, nil
+}
+
 // RunFile reads an image file, converts it to AVIF, and writes the result to outputPath.
 // This is sugar syntax over Run().
 func (c *AVIFEncCommand) RunFile(inputPath, outputPath string) error {
@@ -365,27 +506,60 @@ func (c *AVIFEncCommand) RunFile(inputPath, outputPath string) error {
 }
 
 // RunIO reads image data from input, converts it to AVIF, and writes the result to output.
-// This is sugar syntax over Run().
+// The input is read up to Limits.MaxInputBytes and the output is streamed with
+// RunToWriter.
 func (c *AVIFEncCommand) RunIO(input io.Reader, output io.Writer) error {
 	if c.cmd == nil {
 		return fmt.Errorf("command is closed")
 	}
 
-	inputData, err := io.ReadAll(input)
+	inputData, err := readAllLimited(input)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	avifData, err := c.Run(inputData)
-	if err != nil {
-		return err
+	_, err = c.RunToWriter(inputData, output)
+	return err
+}
+
+// RunToWriter converts image data to AVIF like Run but passes the output from the
+// libavif buffer to w in chunks, without the copies to a C and a Go buffer. It returns
+// the number of bytes written. On error part of the output may already be written.
+func (c *AVIFEncCommand) RunToWriter(imageData []byte, w io.Writer) (int64, error) {
+	if c.cmd == nil {
+		return 0, fmt.Errorf("command is closed")
+	}
+	if len(imageData) == 0 {
+		return 0, fmt.Errorf("input data is empty")
+	}
+	if err := checkLimits(imageData); err != nil {
+		return 0, err
 	}
 
-	if _, err := output.Write(avifData); err != nil {
-		return fmt.Errorf("failed to write output: %w", err)
+	status, n, err := streamTo(w, func(writer *C.NextImageWriter) C.NextImageStatus {
+		return C.avifenc_run_command_to_writer(
+			c.cmd,
+			(*C.uint8_t)(unsafe.Pointer(&imageData[0])),
+			C.size_t(len(imageData)),
+			writer,
+			nil,
+		)
+	})
+	if err != nil {
+		return n, fmt.Errorf("failed to write output: %w", err)
+	}
+	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "AVIFEncOptions", c.crop); err != nil {
+			return n, err
+		}
+		errMsg := C.nextimage_last_error_message()
+		return n, fmt.Errorf("avifenc encoding failed (status %d): %s", status, C.GoString(errMsg))
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("encoding produced empty output")
 	}
 
-	return nil
+	return n, nil
 }
 
 // Close releases the resources associated with the command.
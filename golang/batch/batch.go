@@ -0,0 +1,483 @@
+// Package batch converts directory trees of images with a bounded worker pool.
+//
+// Every input file matched by the include/exclude globs is converted once per
+// Output, skipping outputs that are up to date. Errors are recorded per file
+// and the run continues; the Report can be written as JSON or CSV.
+//
+//	webp, _ := batch.WebP(libnextimage.DefaultWebPEncodeOptions())
+//	avif, _ := batch.AVIF(libnextimage.DefaultAVIFEncodeOptions())
+//	report, err := batch.Run(ctx, batch.Options{
+//	    Root:      "photos",
+//	    OutputDir: "public/img",
+//	    Exclude:   []string{"**/raw/**"},
+//	    Skip:      batch.SkipIfNewer,
+//	    Outputs: []batch.Output{
+//	        {Name: "webp", Ext: ".webp", Converter: webp},
+//	        {Name: "avif", Ext: ".avif", Converter: avif},
+//	    },
+//	})
+//	report.WriteCSV(os.Stdout)
+//
+// Watch runs the same conversions continuously, following changes under Root.
+package batch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SkipMode decides when an existing output is up to date
+type SkipMode int
+
+// Both checks also require the output to have been made with the same converter
+// options, which are recorded in StateFile with each output.
+const (
+	SkipNone        SkipMode = iota // Always convert (default)
+	SkipIfNewer                     // Skip outputs whose modification time is not older than the input
+	SkipIfUnchanged                 // Skip outputs converted from an input with the same SHA-256 (recorded in StateFile)
+)
+
+// DefaultInclude matches the input formats the encoders read
+var DefaultInclude = []string{"*.jpg", "*.jpeg", "*.png", "*.gif", "*.tif", "*.tiff", "*.webp"}
+
+// DefaultStateFile is the name of the skip check state file in OutputDir
+const DefaultStateFile = ".nextimage-batch.json"
+
+// Output is one conversion made for every input file
+type Output struct {
+	Name      string    // Name in the report, e.g. "webp"
+	Ext       string    // Extension replacing the input extension, e.g. ".webp"
+	Converter Converter // Conversion, called from several workers at once
+
+	// OptionsKey identifies the conversion settings for the skip checks; outputs made
+	// with a different key are converted again (default: the converter's OptionsKey,
+	// for converters implementing OptionsKeyer)
+	OptionsKey string
+}
+
+// optionsKey returns the settings an output is recorded with
+func (out Output) optionsKey() string {
+	if out.OptionsKey != "" {
+		return out.OptionsKey
+	}
+	if k, ok := out.Converter.(OptionsKeyer); ok {
+		return k.OptionsKey()
+	}
+	return ""
+}
+
+// Options configures a batch run
+type Options struct {
+	Root      string   // Source directory
+	OutputDir string   // Destination directory; outputs mirror the layout of Root
+	Include   []string // Globs of inputs relative to Root (default: DefaultInclude)
+	Exclude   []string // Globs of inputs to leave out
+	Outputs   []Output // Conversions made for every input
+	Workers   int      // Files converted at the same time (default: GOMAXPROCS)
+	Skip      SkipMode // Up-to-date check (default: SkipNone)
+	StateFile string   // Input hashes and options keys for Skip (default: DefaultStateFile in OutputDir)
+
+	// OutputPath maps an input path relative to Root to an output path relative to
+	// OutputDir (or absolute). The default replaces the extension with out.Ext,
+	// e.g. "2024/cat.jpg" -> "2024/cat.webp".
+	OutputPath func(rel string, out Output) string
+
+	// OnFile is called after each input/output pair, from the worker goroutines
+	OnFile func(FileResult)
+}
+
+// job is one input file and its outputs
+type job struct {
+	index   int
+	rel     string
+	info    fs.FileInfo
+	changed bool // Changed while watching, as opposed to found by a scan of the whole tree
+}
+
+// Run converts every matching file under opts.Root. Conversion errors are recorded in
+// the report and do not stop the run, and neither do inputs converted to the output
+// path of an earlier input, which are recorded as failed with a CollisionError.
+// The returned error is for problems with the options, the tree walk or the state file.
+// Cancelling ctx stops starting new files and lets files in progress finish.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	opts, err := prepare(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Started: time.Now()}
+	jobs, err := collect(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var state *skipState
+	if opts.Skip != SkipNone {
+		if state, err = loadSkipState(opts.StateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([][]FileResult, len(jobs))
+	collided := make(map[string]*CollisionError)
+	for _, c := range claimOutputs(opts, make(map[string]string), jobs) {
+		collided[c.Inputs[1]] = c
+	}
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				results[j.index] = convertFile(opts, state, j)
+			}
+		}()
+	}
+dispatch:
+	for _, j := range jobs {
+		if c := collided[j.rel]; c != nil {
+			results[j.index] = collisionResults(opts, j, c)
+			continue
+		}
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, files := range results {
+		report.add(files...)
+	}
+	report.Duration = time.Since(report.Started)
+
+	if state != nil {
+		if err := state.save(opts.StateFile); err != nil {
+			return report, err
+		}
+	}
+	return report, ctx.Err()
+}
+
+// prepare checks opts and fills in the defaults
+func prepare(opts Options) (Options, error) {
+	if opts.Root == "" || opts.OutputDir == "" {
+		return opts, fmt.Errorf("batch: Root and OutputDir are required")
+	}
+	if len(opts.Outputs) == 0 {
+		return opts, fmt.Errorf("batch: no outputs")
+	}
+	for _, out := range opts.Outputs {
+		if out.Converter == nil {
+			return opts, fmt.Errorf("batch: output %q has no converter", out.Name)
+		}
+	}
+	if opts.Include == nil {
+		opts.Include = DefaultInclude
+	}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if err := checkGlob(pattern); err != nil {
+			return opts, fmt.Errorf("batch: %w", err)
+		}
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.GOMAXPROCS(0)
+	}
+	if opts.OutputPath == nil {
+		opts.OutputPath = defaultOutputPath
+	}
+	if opts.StateFile == "" {
+		opts.StateFile = filepath.Join(opts.OutputDir, DefaultStateFile)
+	}
+	return opts, nil
+}
+
+// collect walks Root for inputs matched by the globs, in lexical order.
+// OutputDir is left out when it is inside Root, and so are entries removed during the walk.
+func collect(opts Options) ([]job, error) {
+	outputDir, _ := filepath.Abs(opts.OutputDir)
+	var jobs []job
+	err := filepath.WalkDir(opts.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != opts.Root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if abs, _ := filepath.Abs(path); abs == outputDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(opts.Root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.Type().IsRegular() || !matchAny(opts.Include, rel) || matchAny(opts.Exclude, rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job{index: len(jobs), rel: rel, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("batch: %w", err)
+	}
+	return jobs, nil
+}
+
+func defaultOutputPath(rel string, out Output) string {
+	return strings.TrimSuffix(rel, filepath.Ext(rel)) + out.Ext
+}
+
+// outputPath returns the file an input relative to Root is converted to
+func outputPath(opts Options, rel string, out Output) string {
+	path := opts.OutputPath(rel, out)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(opts.OutputDir, filepath.FromSlash(path))
+	}
+	return path
+}
+
+// CollisionError reports two inputs converted to the same output path, e.g. "a.jpg"
+// and "a.png" both to "a.webp" with the default OutputPath. Neither input is converted
+// over the other's output; an OutputPath keeping the input extension avoids it.
+type CollisionError struct {
+	Output string    // Output path
+	Inputs [2]string // The input owning the output, and the one left unconverted
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("batch: %s and %s are both converted to %s", e.Inputs[0], e.Inputs[1], e.Output)
+}
+
+// claimOutputs records the input of every output path in owners (output path ->
+// input), going through jobs in order. An input with an output already owned by
+// another input claims none of its outputs and is returned as a CollisionError.
+func claimOutputs(opts Options, owners map[string]string, jobs []job) []*CollisionError {
+	var collisions []*CollisionError
+	for _, j := range jobs {
+		paths := make([]string, len(opts.Outputs))
+		var collision *CollisionError
+		for i, out := range opts.Outputs {
+			paths[i] = outputPath(opts, j.rel, out)
+			if owner, ok := owners[paths[i]]; ok && owner != j.rel {
+				collision = &CollisionError{Output: paths[i], Inputs: [2]string{owner, j.rel}}
+				break
+			}
+		}
+		if collision != nil {
+			collisions = append(collisions, collision)
+			continue
+		}
+		for _, path := range paths {
+			owners[path] = j.rel
+		}
+	}
+	return collisions
+}
+
+// collisionResults records an input left unconverted because of c as failed
+func collisionResults(opts Options, j job, c *CollisionError) []FileResult {
+	results := make([]FileResult, len(opts.Outputs))
+	for i, out := range opts.Outputs {
+		results[i] = FileResult{Input: j.rel, Output: outputPath(opts, j.rel, out), Format: out.Name, InputSize: j.info.Size()}
+		results[i].fail(c)
+	}
+	if opts.OnFile != nil {
+		for _, r := range results {
+			opts.OnFile(r)
+		}
+	}
+	return results
+}
+
+// convertFile converts one input to all outputs. A non-nil state records the options
+// key, and the input hash for SkipIfUnchanged, of each output converted.
+func convertFile(opts Options, state *skipState, j job) []FileResult {
+	results := make([]FileResult, len(opts.Outputs))
+	keys := make([]string, len(opts.Outputs))
+	pending := make([]int, 0, len(opts.Outputs))
+	for i, out := range opts.Outputs {
+		path := outputPath(opts, j.rel, out)
+		results[i] = FileResult{Input: j.rel, Output: path, Format: out.Name, InputSize: j.info.Size()}
+		keys[i] = out.optionsKey()
+		if opts.Skip == SkipIfNewer && state.unchanged(path, "", keys[i]) {
+			if info, err := os.Stat(path); err == nil && !info.ModTime().Before(j.info.ModTime()) {
+				results[i].Status = StatusSkipped
+				results[i].OutputSize = info.Size()
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+
+	if len(pending) > 0 {
+		data, err := os.ReadFile(filepath.Join(opts.Root, filepath.FromSlash(j.rel)))
+		var hash string
+		if err == nil && opts.Skip == SkipIfUnchanged {
+			sum := sha256.Sum256(data)
+			hash = hex.EncodeToString(sum[:])
+		}
+		for _, i := range pending {
+			r := &results[i]
+			switch {
+			case err != nil:
+				r.fail(err)
+			case opts.Skip == SkipIfUnchanged && state.unchanged(r.Output, hash, keys[i]):
+				r.Status = StatusSkipped
+				if info, err := os.Stat(r.Output); err == nil {
+					r.OutputSize = info.Size()
+				}
+			default:
+				start := time.Now()
+				r.convert(data, opts.Outputs[i].Converter)
+				r.Duration = time.Since(start)
+				if state != nil && r.Status == StatusConverted {
+					state.set(r.Output, stateEntry{Input: hash, Options: keys[i]})
+				}
+			}
+		}
+	}
+
+	if opts.OnFile != nil {
+		for _, r := range results {
+			opts.OnFile(r)
+		}
+	}
+	return results
+}
+
+// convert runs the converter and writes the output atomically
+func (r *FileResult) convert(data []byte, converter Converter) {
+	out, err := converter.Convert(data)
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	if err := writeFileAtomic(r.Output, out); err != nil {
+		r.fail(err)
+		return
+	}
+	r.Status = StatusConverted
+	r.OutputSize = int64(len(out))
+}
+
+func (r *FileResult) fail(err error) {
+	r.Status = StatusFailed
+	r.Err = err
+}
+
+// writeFileAtomic writes through a temporary file in the same directory, so that
+// readers never see a partial output
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// stateEntry is how an output was made
+type stateEntry struct {
+	Input   string `json:"input,omitempty"`   // SHA-256 of the input, for SkipIfUnchanged
+	Options string `json:"options,omitempty"` // Output.OptionsKey
+}
+
+// skipState records how each output was made, for the skip checks
+type skipState struct {
+	mu      sync.Mutex
+	entries map[string]stateEntry // output path -> entry
+}
+
+func loadSkipState(path string) (*skipState, error) {
+	s := &skipState{entries: make(map[string]stateEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("batch: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("batch: invalid state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// unchanged reports whether output exists and was converted from an input with hash
+// ("" when hashes are not checked) using options. Outputs missing from the state
+// count as converted with neither.
+func (s *skipState) unchanged(output, hash, options string) bool {
+	s.mu.Lock()
+	recorded := s.entries[output]
+	s.mu.Unlock()
+	if recorded.Input != hash || recorded.Options != options {
+		return false
+	}
+	_, err := os.Stat(output)
+	return err == nil
+}
+
+func (s *skipState) set(output string, entry stateEntry) {
+	s.mu.Lock()
+	s.entries[output] = entry
+	s.mu.Unlock()
+}
+
+func (s *skipState) remove(output string) {
+	s.mu.Lock()
+	delete(s.entries, output)
+	s.mu.Unlock()
+}
+
+func (s *skipState) save(path string) error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("batch: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("batch: %w", err)
+	}
+	return nil
+}
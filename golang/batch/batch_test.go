@@ -0,0 +1,268 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// halve is a fake converter that keeps the first half of the input
+var halve = ConverterFunc(func(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte("bad")) {
+		return nil, errors.New("corrupt input")
+	}
+	return data[:len(data)/2], nil
+})
+
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// TestMatchGlob tests include/exclude glob matching
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.jpg", "a.jpg", true},
+		{"*.jpg", "x/y/A.JPG", true},
+		{"*.jpg", "a.png", false},
+		{"photos/*.png", "photos/a.png", true},
+		{"photos/*.png", "photos/x/a.png", false},
+		{"photos/**/*.png", "photos/a.png", true},
+		{"photos/**/*.png", "photos/x/y/a.png", true},
+		{"**/raw/**", "2024/raw/a.jpg", true},
+		{"**/raw/**", "raw/a.jpg", true},
+		{"**/raw/**", "2024/drafts/a.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+	if err := checkGlob("[a-"); err == nil {
+		t.Error("expected error for a malformed glob")
+	}
+}
+
+// TestRun tests conversion, exclusion, per-file errors and the reports
+func TestRun(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.jpg":         "aaaaaaaa",
+		"sub/b.png":     "bbbbbbbbbbbb",
+		"sub/bad.png":   "bad data",
+		"sub/raw/c.jpg": "cccc",
+		"notes.txt":     "not an image",
+	})
+	out := filepath.Join(t.TempDir(), "out")
+	opts := Options{
+		Root:      root,
+		OutputDir: out,
+		Exclude:   []string{"**/raw/**"},
+		Workers:   2,
+		Outputs:   []Output{{Name: "half", Ext: ".half", Converter: halve}},
+	}
+	report, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Converted != 2 || report.Failed != 1 || report.Skipped != 0 || len(report.Files) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if f := report.Files[0]; f.Input != "a.jpg" || f.Output != filepath.Join(out, "a.half") || f.OutputSize != 4 || f.Ratio() != 0.5 {
+		t.Errorf("unexpected result %+v", f)
+	}
+	if f := report.Files[1]; f.Input != "sub/b.png" || f.Status != StatusConverted {
+		t.Errorf("unexpected result %+v", f)
+	}
+	if f := report.Files[2]; f.Input != "sub/bad.png" || f.Status != StatusFailed || f.Err == nil {
+		t.Errorf("unexpected result %+v", f)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "sub", "b.half")); err != nil || string(data) != "bbbbbb" {
+		t.Errorf("unexpected output %q (%v)", data, err)
+	}
+
+	var csvOut, jsonOut bytes.Buffer
+	if err := report.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 4 || lines[0] != "input,output,format,status,input_size,output_size,ratio,duration_ms,error" ||
+		!strings.HasSuffix(lines[3], ",corrupt input") {
+		t.Errorf("unexpected CSV:\n%s", csvOut.String())
+	}
+	if err := report.WriteJSON(&jsonOut); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Converted int `json:"converted"`
+		Files     []struct {
+			Input string  `json:"input"`
+			Ratio float64 `json:"ratio"`
+			Error string  `json:"error"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil || decoded.Converted != 2 ||
+		decoded.Files[0].Ratio != 0.5 || decoded.Files[2].Error != "corrupt input" {
+		t.Errorf("unexpected JSON %s (%v)", jsonOut.String(), err)
+	}
+
+	t.Logf("✓ %d converted, %d failed", report.Converted, report.Failed)
+}
+
+// TestRun_Skip tests the mtime and content hash up-to-date checks
+func TestRun_Skip(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.jpg": "aaaaaaaa", "b.jpg": "bbbbbbbb"})
+	out := filepath.Join(root, "out") // inside Root: outputs are not picked up as inputs
+	opts := Options{
+		Root:      root,
+		OutputDir: out,
+		Include:   []string{"*"},
+		Outputs:   []Output{{Name: "half", Ext: ".jpg", Converter: halve}},
+	}
+
+	opts.Skip = SkipIfNewer
+	if report, err := Run(context.Background(), opts); err != nil || report.Converted != 2 {
+		t.Fatalf("first run: %+v (%v)", report, err)
+	}
+	if report, err := Run(context.Background(), opts); err != nil || report.Skipped != 2 || report.Converted != 0 {
+		t.Errorf("second run should skip: %+v (%v)", report, err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "a.jpg"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Run(context.Background(), opts); err != nil || report.Converted != 1 || report.Files[0].Status != StatusConverted {
+		t.Errorf("touched input should be converted: %+v (%v)", report, err)
+	}
+
+	// Hashes ignore modification times
+	opts.Skip = SkipIfUnchanged
+	if report, err := Run(context.Background(), opts); err != nil || report.Converted != 2 {
+		t.Fatalf("first hashed run: %+v (%v)", report, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, DefaultStateFile)); err != nil {
+		t.Errorf("state file not written: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "b.jpg"), []byte("BBBBBBBB"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(context.Background(), opts)
+	if err != nil || report.Skipped != 1 || report.Converted != 1 || report.Files[1].Status != StatusConverted {
+		t.Errorf("only the changed input should be converted: %+v (%v)", report, err)
+	}
+
+	// Both checks convert again when the options change
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "a.jpg"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	for i, skip := range []SkipMode{SkipIfUnchanged, SkipIfNewer} {
+		opts.Skip = skip
+		opts.Outputs[0].OptionsKey = fmt.Sprintf("options %d", i)
+		if report, err := Run(context.Background(), opts); err != nil || report.Converted != 2 {
+			t.Errorf("mode %d: changed options should be converted: %+v (%v)", skip, report, err)
+		}
+		if report, err := Run(context.Background(), opts); err != nil || report.Skipped != 2 {
+			t.Errorf("mode %d: same options should skip: %+v (%v)", skip, report, err)
+		}
+	}
+}
+
+// TestRun_Collision tests that an input converted to the output of another input
+// fails and the other inputs are converted
+func TestRun_Collision(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.jpg": "aaaaaaaa", "a.png": "pppppppp", "b.png": "bbbbbbbb"})
+	out := filepath.Join(t.TempDir(), "out")
+	opts := Options{
+		Root:      root,
+		OutputDir: out,
+		Outputs:   []Output{{Name: "half", Ext: ".half", Converter: halve}},
+	}
+	report, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Converted != 2 || report.Failed != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	var collision *CollisionError
+	f := report.Files[1]
+	if f.Input != "a.png" || f.Status != StatusFailed || !errors.As(f.Err, &collision) ||
+		collision.Inputs != [2]string{"a.jpg", "a.png"} || collision.Output != filepath.Join(out, "a.half") {
+		t.Fatalf("expected a collision error for a.png, got %+v", f)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "a.half")); string(data) != "aaaa" {
+		t.Errorf("output of a.jpg was overwritten with %q", data)
+	}
+	if !exists(filepath.Join(out, "b.half")) {
+		t.Error("b.png was not converted")
+	}
+
+	// Keeping the input extension tells them apart
+	opts.OutputPath = func(rel string, out Output) string { return rel + out.Ext }
+	if report, err := Run(context.Background(), opts); err != nil || report.Converted != 3 {
+		t.Errorf("unexpected report %+v (%v)", report, err)
+	}
+}
+
+// TestRun_Converters tests the library and Command converters on real images
+func TestRun_Converters(t *testing.T) {
+	webp, err := WebP(libnextimage.DefaultWebPEncodeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwebp, err := CWebP(libnextimage.NewDefaultCWebPOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cwebp.Close()
+
+	bad := libnextimage.NewDefaultAVIFEncOptions()
+	bad.Speed = 11
+	if _, err := AVIFEnc(bad); err == nil {
+		t.Error("expected error for invalid options")
+	}
+
+	report, err := Run(context.Background(), Options{
+		Root:      filepath.Join("..", "..", "testdata", "source", "sizes"),
+		OutputDir: t.TempDir(),
+		Outputs: []Output{
+			{Name: "webp", Ext: ".webp", Converter: webp},
+			{Name: "cwebp", Ext: ".cwebp.webp", Converter: cwebp},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Failed != 0 || report.Converted == 0 || report.Converted%2 != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	for _, f := range report.Files {
+		if data, err := os.ReadFile(f.Output); err != nil || !bytes.HasPrefix(data, []byte("RIFF")) {
+			t.Errorf("%s is not a WebP file (%v)", f.Output, err)
+		}
+	}
+
+	t.Logf("✓ %d files converted", report.Converted)
+}
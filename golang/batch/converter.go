@@ -0,0 +1,202 @@
+package batch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// Converter turns the bytes of one input file into the bytes of one output file.
+// Convert is called from several workers at once.
+type Converter interface {
+	Convert(data []byte) ([]byte, error)
+}
+
+// ConverterFunc adapts a function to Converter
+type ConverterFunc func(data []byte) ([]byte, error)
+
+func (f ConverterFunc) Convert(data []byte) ([]byte, error) {
+	return f(data)
+}
+
+// OptionsKeyer is implemented by converters that identify their settings. The skip
+// checks convert an output again when the key it was made with differs.
+type OptionsKeyer interface {
+	OptionsKey() string
+}
+
+// optionsKey hashes the config file form of opts, prefixed with the conversion name
+func optionsKey[T any](name string, opts T) string {
+	data, err := json.Marshal(libnextimage.NewOptionsConfig(opts))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return name + ":" + hex.EncodeToString(sum[:])
+}
+
+// keyedFunc is a ConverterFunc with an options key
+type keyedFunc struct {
+	ConverterFunc
+	key string
+}
+
+func (f keyedFunc) OptionsKey() string {
+	return f.key
+}
+
+// WebP converts with libnextimage.WebPEncodeBytes
+func WebP(opts libnextimage.WebPEncodeOptions) (Converter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return keyedFunc{func(data []byte) ([]byte, error) {
+		return libnextimage.WebPEncodeBytes(data, opts)
+	}, optionsKey("webp", opts)}, nil
+}
+
+// AVIF converts with libnextimage.AVIFEncodeBytes
+func AVIF(opts libnextimage.AVIFEncodeOptions) (Converter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return keyedFunc{func(data []byte) ([]byte, error) {
+		return libnextimage.AVIFEncodeBytes(data, opts)
+	}, optionsKey("avif", opts)}, nil
+}
+
+// runner is the interface shared by the Command types
+type runner interface {
+	Run(data []byte) ([]byte, error)
+	Close() error
+}
+
+// CommandConverter converts with one of the Command types. A command is used by one
+// worker at a time, and idle commands are reused across files.
+// Close releases them when the converter is no longer needed.
+type CommandConverter struct {
+	newCommand func() (runner, error)
+	key        string
+
+	mu     sync.Mutex
+	idle   []runner
+	closed bool
+}
+
+// newCommandConverter creates the first command up front, so that invalid options
+// are reported before the run
+func newCommandConverter(key string, newCommand func() (runner, error)) (*CommandConverter, error) {
+	cmd, err := newCommand()
+	if err != nil {
+		return nil, err
+	}
+	return &CommandConverter{newCommand: newCommand, key: key, idle: []runner{cmd}}, nil
+}
+
+// CWebP converts with a libnextimage.CWebPCommand
+func CWebP(opts libnextimage.CWebPOptions) (*CommandConverter, error) {
+	return newCommandConverter(optionsKey("cwebp", opts), func() (runner, error) {
+		cmd, err := libnextimage.NewCWebPCommand(&opts)
+		return cmd, err
+	})
+}
+
+// DWebP converts with a libnextimage.DWebPCommand
+func DWebP(opts libnextimage.DWebPOptions) (*CommandConverter, error) {
+	return newCommandConverter(optionsKey("dwebp", opts), func() (runner, error) {
+		cmd, err := libnextimage.NewDWebPCommand(&opts)
+		return cmd, err
+	})
+}
+
+// Gif2WebP converts with a libnextimage.Gif2WebPCommand
+func Gif2WebP(opts libnextimage.Gif2WebPOptions) (*CommandConverter, error) {
+	return newCommandConverter(optionsKey("gif2webp", opts), func() (runner, error) {
+		cmd, err := libnextimage.NewGif2WebPCommand(&opts)
+		return cmd, err
+	})
+}
+
+// WebP2Gif converts with a libnextimage.WebP2GifCommand
+func WebP2Gif(opts libnextimage.WebP2GifOptions) (*CommandConverter, error) {
+	return newCommandConverter(optionsKey("webp2gif", opts), func() (runner, error) {
+		cmd, err := libnextimage.NewWebP2GifCommand(&opts)
+		return cmd, err
+	})
+}
+
+// AVIFEnc converts with a libnextimage.AVIFEncCommand
+func AVIFEnc(opts libnextimage.AVIFEncOptions) (*CommandConverter, error) {
+	return newCommandConverter(optionsKey("avifenc", opts), func() (runner, error) {
+		cmd, err := libnextimage.NewAVIFEncCommand(&opts)
+		return cmd, err
+	})
+}
+
+// AVIFDec converts with a libnextimage.AVIFDecCommand
+func AVIFDec(opts libnextimage.AVIFDecOptions) (*CommandConverter, error) {
+	return newCommandConverter(optionsKey("avifdec", opts), func() (runner, error) {
+		cmd, err := libnextimage.NewAVIFDecCommand(&opts)
+		return cmd, err
+	})
+}
+
+// OptionsKey identifies the command and its options
+func (c *CommandConverter) OptionsKey() string {
+	return c.key
+}
+
+// Convert runs an idle command, creating one when all are busy
+func (c *CommandConverter) Convert(data []byte) ([]byte, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("batch: converter is closed")
+	}
+	var cmd runner
+	if n := len(c.idle); n > 0 {
+		cmd = c.idle[n-1]
+		c.idle = c.idle[:n-1]
+	}
+	c.mu.Unlock()
+
+	if cmd == nil {
+		var err error
+		if cmd, err = c.newCommand(); err != nil {
+			return nil, err
+		}
+	}
+	defer c.put(cmd)
+	return cmd.Run(data)
+}
+
+// put returns a command for reuse, closing it if the converter is closed
+func (c *CommandConverter) put(cmd runner) {
+	c.mu.Lock()
+	if !c.closed {
+		c.idle = append(c.idle, cmd)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	cmd.Close()
+}
+
+// Close releases the idle commands; commands in use are closed when their file is done
+func (c *CommandConverter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var firstErr error
+	for _, cmd := range c.idle {
+		if err := cmd.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.idle = nil
+	return firstErr
+}
@@ -0,0 +1,61 @@
+package batch
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Globs are matched against slash-separated paths relative to Root, case-insensitively.
+// A pattern without "/" matches the file name in any directory ("*.jpg"); otherwise it
+// matches the whole path, where "**" stands for any number of directories
+// ("photos/**/*.png", "**/raw/**").
+
+// checkGlob reports a malformed pattern
+func checkGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern, rel string) bool {
+	pattern, rel = strings.ToLower(pattern), strings.ToLower(rel)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,139 @@
+package batch
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Status is the outcome of one input/output pair
+type Status string
+
+const (
+	StatusConverted Status = "converted"
+	StatusSkipped   Status = "skipped" // Output was up to date
+	StatusFailed    Status = "failed"
+	StatusRemoved   Status = "removed" // Output deleted because its input was removed (Watch)
+)
+
+// FileResult is the outcome of converting one input to one output
+type FileResult struct {
+	Input      string        // Input path relative to Root, slash-separated
+	Output     string        // Output file path
+	Format     string        // Output.Name
+	Status     Status        // Converted, skipped or failed
+	InputSize  int64         // Input file size in bytes
+	OutputSize int64         // Output file size in bytes (existing output when skipped, 0 when failed)
+	Duration   time.Duration // Conversion time (0 when skipped)
+	Err        error         // Conversion or I/O error when failed
+}
+
+// Ratio returns OutputSize / InputSize, or 0 without an output
+func (r FileResult) Ratio() float64 {
+	if r.InputSize == 0 || r.OutputSize == 0 {
+		return 0
+	}
+	return float64(r.OutputSize) / float64(r.InputSize)
+}
+
+// Report summarizes a batch run. Files are ordered by input path, then by output.
+type Report struct {
+	Files       []FileResult
+	Converted   int           // Pairs converted
+	Skipped     int           // Pairs up to date
+	Failed      int           // Pairs failed
+	InputBytes  int64         // Input size of the converted pairs
+	OutputBytes int64         // Output size of the converted pairs
+	Started     time.Time     // Start of the run
+	Duration    time.Duration // Wall time of the run
+}
+
+func (r *Report) add(files ...FileResult) {
+	for _, f := range files {
+		r.Files = append(r.Files, f)
+		switch f.Status {
+		case StatusConverted:
+			r.Converted++
+			r.InputBytes += f.InputSize
+			r.OutputBytes += f.OutputSize
+		case StatusSkipped:
+			r.Skipped++
+		case StatusFailed:
+			r.Failed++
+		}
+	}
+}
+
+// fileRecord is a FileResult in the JSON and CSV reports
+type fileRecord struct {
+	Input      string  `json:"input"`
+	Output     string  `json:"output"`
+	Format     string  `json:"format"`
+	Status     Status  `json:"status"`
+	InputSize  int64   `json:"input_size"`
+	OutputSize int64   `json:"output_size"`
+	Ratio      float64 `json:"ratio"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func (r FileResult) record() fileRecord {
+	rec := fileRecord{
+		Input:      r.Input,
+		Output:     r.Output,
+		Format:     r.Format,
+		Status:     r.Status,
+		InputSize:  r.InputSize,
+		OutputSize: r.OutputSize,
+		Ratio:      r.Ratio(),
+		DurationMS: float64(r.Duration.Microseconds()) / 1000,
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	return rec
+}
+
+// WriteJSON writes the report as a JSON object with a summary and one entry per file
+func (r *Report) WriteJSON(w io.Writer) error {
+	files := make([]fileRecord, len(r.Files))
+	for i, f := range r.Files {
+		files[i] = f.record()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Started     time.Time    `json:"started"`
+		DurationMS  float64      `json:"duration_ms"`
+		Converted   int          `json:"converted"`
+		Skipped     int          `json:"skipped"`
+		Failed      int          `json:"failed"`
+		InputBytes  int64        `json:"input_bytes"`
+		OutputBytes int64        `json:"output_bytes"`
+		Files       []fileRecord `json:"files"`
+	}{r.Started, float64(r.Duration.Microseconds()) / 1000, r.Converted, r.Skipped, r.Failed, r.InputBytes, r.OutputBytes, files})
+}
+
+// WriteCSV writes one row per file with a header row
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"input", "output", "format", "status", "input_size", "output_size", "ratio", "duration_ms", "error"})
+	for _, f := range r.Files {
+		rec := f.record()
+		cw.Write([]string{
+			rec.Input,
+			rec.Output,
+			rec.Format,
+			string(rec.Status),
+			strconv.FormatInt(rec.InputSize, 10),
+			strconv.FormatInt(rec.OutputSize, 10),
+			strconv.FormatFloat(rec.Ratio, 'f', 4, 64),
+			strconv.FormatFloat(rec.DurationMS, 'f', 3, 64),
+			rec.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,404 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultManifestFile is the name of the Watch manifest in OutputDir
+const DefaultManifestFile = ".nextimage-watch.json"
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	Options
+
+	// Interval between scans of Root (default: 1s). Changes are found by polling,
+	// which behaves the same on every platform and on network file systems.
+	Interval time.Duration
+
+	// Debounce is how long a new or changed file must keep the same size and
+	// modification time before it is converted (default: 1s), so that files still
+	// being written or copied are not picked up half way
+	Debounce time.Duration
+
+	// ManifestFile records the outputs made from each input, so that outputs of
+	// inputs removed while Watch was not running are deleted on the next start
+	// (default: DefaultManifestFile in OutputDir)
+	ManifestFile string
+
+	// OnError is called with scan and state file errors, which do not stop Watch
+	OnError func(error)
+}
+
+// stamp identifies a version of an input file
+type stamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func stampOf(info fs.FileInfo) stamp {
+	return stamp{info.Size(), info.ModTime()}
+}
+
+// pendingFile is a new or changed input waiting for Debounce
+type pendingFile struct {
+	job   job
+	stamp stamp
+	since time.Time
+}
+
+// watcher is the state of one Watch call. done and pending belong to the scanning
+// goroutine; inFlight, manifest and dirty are shared with the workers.
+type watcher struct {
+	opts       WatchOptions
+	changeOpts Options // opts.Options for changes seen while running (no SkipIfNewer)
+	state      *skipState
+	queue      chan job
+
+	done    map[string]stamp // Version of each input last queued
+	pending map[string]*pendingFile
+	blocked map[string]bool   // Inputs converted to the output of another input
+	owners  map[string]string // Output path -> input, as of the last scan
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	manifest map[string]map[string]string // input -> output name -> output path
+	dirty    bool
+}
+
+// Watch keeps OutputDir in sync with Root until ctx is cancelled.
+//
+// On start it resyncs: every input is converted, subject to opts.Skip, and the outputs
+// of inputs removed since the last run are deleted. It then converts new and changed
+// inputs once they have settled for Debounce, and deletes the outputs of removed inputs.
+// Each conversion and deletion is reported to OnFile (deletions with StatusRemoved).
+// An input converted to the output path of another input is reported once to OnFile
+// as failed with a CollisionError, and left unconverted while the other input exists.
+//
+// When ctx is cancelled, Watch stops queueing files, waits for the conversions in
+// progress, saves its state and returns nil. Changes not converted yet are picked up
+// by the resync of the next run. The returned error is for problems with the options
+// or with the initial scan.
+func Watch(ctx context.Context, opts WatchOptions) error {
+	var err error
+	if opts.Options, err = prepare(opts.Options); err != nil {
+		return err
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Debounce < 0 {
+		return fmt.Errorf("batch: negative Debounce")
+	}
+	if opts.Debounce == 0 {
+		opts.Debounce = time.Second
+	}
+	if opts.ManifestFile == "" {
+		opts.ManifestFile = filepath.Join(opts.OutputDir, DefaultManifestFile)
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(error) {}
+	}
+
+	w := &watcher{
+		opts:       opts,
+		changeOpts: opts.Options,
+		queue:      make(chan job),
+		done:       make(map[string]stamp),
+		pending:    make(map[string]*pendingFile),
+		inFlight:   make(map[string]bool),
+	}
+	if w.changeOpts.Skip == SkipIfNewer {
+		// A change may restore an older file (cp -p, git checkout)
+		w.changeOpts.Skip = SkipNone
+	}
+	if opts.Skip != SkipNone {
+		if w.state, err = loadSkipState(opts.StateFile); err != nil {
+			return err
+		}
+	}
+	if w.manifest, err = loadManifest(opts.ManifestFile); err != nil {
+		return err
+	}
+	jobs, err := collect(opts.Options)
+	if err != nil {
+		return err
+	}
+	w.owners = make(map[string]string)
+	w.blocked = make(map[string]bool)
+	collided := make(map[string]*CollisionError)
+	for _, c := range claimOutputs(opts.Options, w.owners, jobs) {
+		collided[c.Inputs[1]] = c
+		w.blocked[c.Inputs[1]] = true
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+
+	w.resync(ctx, jobs, collided)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			w.scan(ctx)
+			w.save()
+		}
+	}
+
+	close(w.queue)
+	wg.Wait()
+	w.save()
+	return nil
+}
+
+// resync queues every input but the collided ones and deletes the outputs of inputs
+// that are gone
+func (w *watcher) resync(ctx context.Context, jobs []job, collided map[string]*CollisionError) {
+	seen := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		seen[j.rel] = true
+	}
+	w.mu.Lock()
+	var removed []string
+	for rel := range w.manifest {
+		if !seen[rel] {
+			removed = append(removed, rel)
+		}
+	}
+	w.mu.Unlock()
+	for _, rel := range removed {
+		w.remove(rel)
+	}
+
+	for _, j := range jobs {
+		if c := collided[j.rel]; c != nil {
+			collisionResults(w.opts.Options, j, c)
+			continue
+		}
+		if !w.dispatch(ctx, j) {
+			return
+		}
+		w.done[j.rel] = stampOf(j.info)
+	}
+}
+
+// scan compares Root with the last scan, queueing settled changes and deleting the
+// outputs of removed inputs
+func (w *watcher) scan(ctx context.Context) {
+	if _, err := os.Stat(w.opts.Root); err != nil {
+		// An unmounted or renamed Root is not a reason to delete every output
+		w.opts.OnError(fmt.Errorf("batch: %w", err))
+		return
+	}
+	jobs, err := collect(w.opts.Options)
+	if err != nil {
+		w.opts.OnError(err)
+		return
+	}
+
+	// Inputs queued before keep their outputs. A new input converted to one of them
+	// is reported once and waits until the other input is gone.
+	var known, added []job
+	for _, j := range jobs {
+		if _, ok := w.done[j.rel]; ok {
+			known = append(known, j)
+		} else {
+			added = append(added, j)
+		}
+	}
+	owners := make(map[string]string)
+	claimOutputs(w.opts.Options, owners, known)
+	collided := make(map[string]*CollisionError)
+	for _, c := range claimOutputs(w.opts.Options, owners, added) {
+		collided[c.Inputs[1]] = c
+	}
+	reported := w.blocked
+	w.blocked = make(map[string]bool, len(collided))
+	for rel := range collided {
+		w.blocked[rel] = true
+	}
+	w.owners = owners
+
+	now := time.Now()
+	seen := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		seen[j.rel] = true
+		if c := collided[j.rel]; c != nil {
+			if !reported[j.rel] {
+				collisionResults(w.opts.Options, j, c)
+			}
+			delete(w.pending, j.rel)
+			continue
+		}
+		st := stampOf(j.info)
+		if done, ok := w.done[j.rel]; ok && done == st {
+			delete(w.pending, j.rel)
+			continue
+		}
+		p := w.pending[j.rel]
+		if p == nil || p.stamp != st {
+			w.pending[j.rel] = &pendingFile{job: j, stamp: st, since: now}
+			continue
+		}
+		if now.Sub(p.since) < w.opts.Debounce || w.busy(j.rel) {
+			continue
+		}
+		j.changed = true
+		if !w.dispatch(ctx, j) {
+			return
+		}
+		w.done[j.rel] = st
+		delete(w.pending, j.rel)
+	}
+
+	for rel := range w.pending {
+		if !seen[rel] {
+			delete(w.pending, rel)
+		}
+	}
+	for rel := range w.done {
+		if !seen[rel] && !w.busy(rel) {
+			delete(w.done, rel)
+			w.remove(rel)
+		}
+	}
+}
+
+func (w *watcher) busy(rel string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.inFlight[rel]
+}
+
+// dispatch hands a file to a worker; false when ctx is cancelled first
+func (w *watcher) dispatch(ctx context.Context, j job) bool {
+	w.mu.Lock()
+	w.inFlight[j.rel] = true
+	w.mu.Unlock()
+	select {
+	case w.queue <- j:
+		return true
+	case <-ctx.Done():
+		w.mu.Lock()
+		delete(w.inFlight, j.rel)
+		w.mu.Unlock()
+		return false
+	}
+}
+
+// work converts queued files and records their outputs in the manifest
+func (w *watcher) work() {
+	for j := range w.queue {
+		opts := w.opts.Options
+		if j.changed {
+			opts = w.changeOpts
+		}
+		results := convertFile(opts, w.state, j)
+
+		outputs := make(map[string]string, len(results))
+		for _, r := range results {
+			if r.Status != StatusFailed {
+				outputs[r.Format] = r.Output
+			} else if _, err := os.Stat(r.Output); err == nil {
+				outputs[r.Format] = r.Output // Output of the previous version
+			}
+		}
+		w.mu.Lock()
+		w.manifest[j.rel] = outputs
+		delete(w.inFlight, j.rel)
+		w.dirty = true
+		w.mu.Unlock()
+	}
+}
+
+// remove deletes the outputs made from an input. Outputs now belonging to another
+// input, e.g. a.webp of a.jpg after a.png was removed, are left alone.
+func (w *watcher) remove(rel string) {
+	w.mu.Lock()
+	outputs := w.manifest[rel]
+	delete(w.manifest, rel)
+	w.dirty = true
+	shared := make(map[string]bool)
+	for _, other := range w.manifest {
+		for _, path := range other {
+			shared[path] = true
+		}
+	}
+	w.mu.Unlock()
+
+	for _, out := range w.opts.Outputs {
+		path, ok := outputs[out.Name]
+		if !ok {
+			continue
+		}
+		if owner, ok := w.owners[path]; (ok && owner != rel) || shared[path] {
+			continue
+		}
+		r := FileResult{Input: rel, Output: path, Format: out.Name, Status: StatusRemoved}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			r.fail(err)
+		}
+		if w.state != nil {
+			w.state.remove(path)
+		}
+		if w.opts.OnFile != nil {
+			w.opts.OnFile(r)
+		}
+	}
+}
+
+// save writes the manifest and the skip state when they changed
+func (w *watcher) save() {
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return
+	}
+	w.dirty = false
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	w.mu.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(w.opts.ManifestFile, append(data, '\n'))
+	}
+	if err != nil {
+		w.opts.OnError(fmt.Errorf("batch: %w", err))
+	}
+	if w.state != nil {
+		if err := w.state.save(w.opts.StateFile); err != nil {
+			w.opts.OnError(err)
+		}
+	}
+}
+
+func loadManifest(path string) (map[string]map[string]string, error) {
+	manifest := make(map[string]map[string]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("batch: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("batch: invalid manifest file %s: %w", path, err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,279 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// TestWatch tests the startup resync, debounced changes, removals and shutdown
+func TestWatch(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.jpg": "aaaaaaaa", "gone.jpg": "gggggggg"})
+	out := filepath.Join(t.TempDir(), "out")
+	opts := WatchOptions{
+		Options: Options{
+			Root:      root,
+			OutputDir: out,
+			Skip:      SkipIfNewer,
+			Outputs:   []Output{{Name: "half", Ext: ".half", Converter: halve}},
+		},
+		Interval: 10 * time.Millisecond,
+		Debounce: 50 * time.Millisecond,
+	}
+
+	var mu sync.Mutex
+	var results []FileResult
+	opts.OnFile = func(r FileResult) {
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	}
+	count := func(status Status) int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for _, r := range results {
+			if r.Status == status {
+				n++
+			}
+		}
+		return n
+	}
+
+	// First run: both files are converted and recorded in the manifest
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Watch(ctx, opts) }()
+	waitFor(t, "the initial conversion", func() bool { return count(StatusConverted) == 2 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if !exists(filepath.Join(out, DefaultManifestFile)) {
+		t.Fatal("manifest not written")
+	}
+
+	// Removed while not running: the resync deletes its output and skips a.jpg
+	if err := os.Remove(filepath.Join(root, "gone.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	results = nil
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() { done <- Watch(ctx, opts) }()
+	waitFor(t, "the resync", func() bool { return count(StatusRemoved) == 1 && count(StatusSkipped) == 1 })
+	if exists(filepath.Join(out, "gone.half")) {
+		t.Error("output of a removed input was not deleted")
+	}
+
+	// New and changed files are converted once they settle
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "new.png"), []byte("nnnnnnnnnnnn"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the new file", func() bool { return exists(filepath.Join(out, "sub", "new.half")) })
+	if data, _ := os.ReadFile(filepath.Join(out, "sub", "new.half")); string(data) != "nnnnnn" {
+		t.Errorf("unexpected output %q", data)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.jpg"), []byte("AAAA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the changed file", func() bool {
+		data, _ := os.ReadFile(filepath.Join(out, "a.half"))
+		return string(data) == "AA"
+	})
+
+	// Removed while running
+	if err := os.Remove(filepath.Join(root, "sub", "new.png")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the removal", func() bool { return !exists(filepath.Join(out, "sub", "new.half")) })
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+}
+
+// collisions returns an OnFile recording failed results and a function returning
+// the inputs failed with a CollisionError so far
+func collisions() (func(FileResult), func() [][2]string) {
+	var mu sync.Mutex
+	var inputs [][2]string
+	onFile := func(r FileResult) {
+		var c *CollisionError
+		if r.Status == StatusFailed && errors.As(r.Err, &c) {
+			mu.Lock()
+			inputs = append(inputs, c.Inputs)
+			mu.Unlock()
+		}
+	}
+	return onFile, func() [][2]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][2]string(nil), inputs...)
+	}
+}
+
+// TestWatch_Collision tests that an input converted to the output of another input
+// fails without stopping Watch, and takes over once the other input is removed
+func TestWatch_Collision(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.jpg": "aaaaaaaa", "a.png": "pppppppp", "b.png": "bbbbbbbb"})
+	out := filepath.Join(t.TempDir(), "out")
+	onFile, failed := collisions()
+	opts := WatchOptions{
+		Options: Options{
+			Root:      root,
+			OutputDir: out,
+			Outputs:   []Output{{Name: "half", Ext: ".half", Converter: halve}},
+			OnFile:    onFile,
+		},
+		Interval: 10 * time.Millisecond,
+		Debounce: 50 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- Watch(ctx, opts) }()
+	output := filepath.Join(out, "a.half")
+	waitFor(t, "the initial conversion", func() bool {
+		return exists(output) && exists(filepath.Join(out, "b.half"))
+	})
+	if got := failed(); len(got) != 1 || got[0] != [2]string{"a.jpg", "a.png"} {
+		t.Fatalf("expected one collision of a.png, got %v", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if data, _ := os.ReadFile(output); string(data) != "aaaa" {
+		t.Errorf("output of a.jpg was overwritten with %q", data)
+	}
+	if got := failed(); len(got) != 1 {
+		t.Errorf("collision reported again: %v", got)
+	}
+
+	// Once a.jpg is gone, a.png takes over the output
+	if err := os.Remove(filepath.Join(root, "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the conversion of a.png", func() bool {
+		data, _ := os.ReadFile(output)
+		return string(data) == "pppp"
+	})
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+}
+
+// TestWatch_CollisionRemoved tests that removing an input keeps its output when the
+// input it collided with now converts to it
+func TestWatch_CollisionRemoved(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.png": "pppppppp"})
+	out := filepath.Join(t.TempDir(), "out")
+	onFile, failed := collisions()
+	var mu sync.Mutex
+	var removed []FileResult
+	opts := WatchOptions{
+		Options: Options{
+			Root:      root,
+			OutputDir: out,
+			Outputs:   []Output{{Name: "webp", Ext: ".webp", Converter: halve}},
+			OnFile: func(r FileResult) {
+				onFile(r)
+				if r.Status == StatusRemoved {
+					mu.Lock()
+					removed = append(removed, r)
+					mu.Unlock()
+				}
+			},
+		},
+		Interval: 10 * time.Millisecond,
+		Debounce: 50 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- Watch(ctx, opts) }()
+	output := filepath.Join(out, "a.webp")
+	waitFor(t, "the initial conversion", func() bool { return exists(output) })
+
+	// a.jpg is added while a.png owns a.webp, then a.png is removed
+	if err := os.WriteFile(filepath.Join(root, "a.jpg"), []byte("aaaaaaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the collision", func() bool { return len(failed()) == 1 })
+	if err := os.Remove(filepath.Join(root, "a.png")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the conversion of a.jpg", func() bool {
+		data, _ := os.ReadFile(output)
+		return string(data) == "aaaa"
+	})
+	time.Sleep(200 * time.Millisecond)
+	if !exists(output) {
+		t.Error("a.webp was deleted with a.png")
+	}
+	mu.Lock()
+	if len(removed) != 0 {
+		t.Errorf("unexpected removals %+v", removed)
+	}
+	mu.Unlock()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+}
+
+// TestWatch_Shutdown tests that cancelling lets conversions in progress finish
+func TestWatch_Shutdown(t *testing.T) {
+	root := writeTree(t, map[string]string{"a.jpg": "aaaaaaaa"})
+	out := filepath.Join(t.TempDir(), "out")
+	started := make(chan struct{})
+	slow := ConverterFunc(func(data []byte) ([]byte, error) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return data, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, WatchOptions{Options: Options{
+			Root:      root,
+			OutputDir: out,
+			Outputs:   []Output{{Name: "copy", Ext: ".copy", Converter: slow}},
+		}})
+	}()
+	<-started
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "a.copy")); err != nil || string(data) != "aaaaaaaa" {
+		t.Errorf("conversion in progress was not finished: %q (%v)", data, err)
+	}
+}
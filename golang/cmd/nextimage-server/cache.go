@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCache stores encoded images under dir/<key[:2]>/<key>.
+// Once the entries exceed maxBytes the least recently used ones are removed;
+// hits refresh the modification time that eviction orders by.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu   sync.Mutex
+	size int64 // Bytes stored, counted from the entries found at startup
+}
+
+// newDiskCache opens the cache directory and counts the entries already in it
+func newDiskCache(dir string, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &diskCache{dir: dir, maxBytes: maxBytes}
+	for _, e := range c.entries() {
+		c.size += e.size
+	}
+	return c, nil
+}
+
+// cacheKey derives the cache file name from the transform, the resolved output format,
+// the validator of the source content and the effective encoder options
+func cacheKey(t *transform, format, validator, options string) string {
+	sum := sha256.Sum256([]byte(t.signed + "\x00" + format + "\x00" + validator + "\x00" + options))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the cached data, false on a miss
+func (c *diskCache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// put stores data through a temporary file so readers never see partial content
+func (c *diskCache) put(key string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+	path := c.path(key)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var replaced int64
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	c.size += int64(len(data)) - replaced
+	if c.size > c.maxBytes {
+		c.evict()
+	}
+	return nil
+}
+
+// cacheEntry is a stored file as seen by eviction
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries lists the stored files, skipping temporary files of puts in progress
+func (c *diskCache) entries() []cacheEntry {
+	var entries []cacheEntry
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			entries = append(entries, cacheEntry{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	return entries
+}
+
+// evict removes the least recently used entries until the cache is at 90% of maxBytes,
+// leaving room for the next puts. Called with mu held.
+func (c *diskCache) evict() {
+	entries := c.entries()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	c.size = 0
+	for _, e := range entries {
+		c.size += e.size
+	}
+	target := c.maxBytes / 10 * 9
+	for _, e := range entries {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(e.path); err == nil || os.IsNotExist(err) {
+			c.size -= e.size
+		}
+	}
+}
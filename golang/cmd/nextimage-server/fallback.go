@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// legacyFormat returns the format a decoded image falls back to for clients that accept
+// neither AVIF nor WebP: PNG if any pixel is transparent, JPEG otherwise
+func legacyFormat(img *libnextimage.DecodedImage) string {
+	if img.Format != libnextimage.FormatRGBA {
+		return formatJPEG
+	}
+	for y := 0; y < img.Height; y++ {
+		row := img.Data[y*img.Stride:]
+		for x := 0; x < img.Width; x++ {
+			if row[x*4+3] != 0xff {
+				return formatPNG
+			}
+		}
+	}
+	return formatJPEG
+}
+
+// encodeLegacyFormat rescales a decoded image to width x height and encodes it as JPEG
+// or PNG with the standard library
+func encodeLegacyFormat(img *libnextimage.DecodedImage, width, height int, format string, quality int) ([]byte, error) {
+	scaled := resample(img, width, height)
+	var buf bytes.Buffer
+	var err error
+	if format == formatJPEG {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode %s: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resample scales an 8-bit RGB or RGBA image to width x height. Each output pixel
+// averages the source pixels it covers, which is a box filter when shrinking and
+// nearest neighbour when enlarging.
+func resample(img *libnextimage.DecodedImage, width, height int) *image.NRGBA {
+	bpp := 4
+	if img.Format == libnextimage.FormatRGB {
+		bpp = 3
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * img.Height / height
+		y1 := max(y0+1, (y+1)*img.Height/height)
+		for x := 0; x < width; x++ {
+			x0 := x * img.Width / width
+			x1 := max(x0+1, (x+1)*img.Width/width)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := img.Data[sy*img.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*bpp:]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					if bpp == 4 {
+						sum[3] += int(p[3])
+					} else {
+						sum[3] += 255
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,92 @@
+// Command nextimage-server serves resized WebP and AVIF images from a local
+// directory or an HTTP origin, similar to imgproxy.
+//
+// Image URLs have the form
+//
+//	/<signature>/<option>/.../plain/<source path>
+//
+// with options w:<width>, h:<height>, fit:fit|fill|force, f:webp|avif|auto and q:<quality>,
+// for example /_/w:640/f:auto/plain/photos/cat.jpg. f:auto (the default) picks AVIF or WebP
+// from the Accept header and falls back to the source format when neither is accepted.
+// When a key is configured the signature is the unpadded base64url HMAC-SHA256 of
+// everything after it, e.g. of "/w:640/f:auto/plain/photos/cat.jpg".
+// Without a key, "_" or "insecure" is accepted as the signature.
+//
+// GET /health and GET /metrics (Prometheus text format) are served alongside images.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var cfg Config
+	listen := flag.String("listen", ":8080", "Address to listen on")
+	flag.StringVar(&cfg.Root, "root", "", "Local directory to serve images from")
+	flag.StringVar(&cfg.Origin, "origin", "", "Origin base URL to fetch images from")
+	keyHex := flag.String("key", os.Getenv("NEXTIMAGE_SERVER_KEY"), "Hex-encoded URL signing key (default $NEXTIMAGE_SERVER_KEY, empty allows unsigned URLs)")
+	flag.StringVar(&cfg.CacheDir, "cache-dir", "", "Directory to cache encoded images in (default: no cache)")
+	flag.Int64Var(&cfg.CacheMaxBytes, "cache-max-bytes", 1<<30, "Largest total size of the cache directory in bytes")
+	flag.Int64Var(&cfg.MaxSourceBytes, "max-source-bytes", 32<<20, "Largest source file in bytes")
+	flag.IntVar(&cfg.MaxSourcePixels, "max-source-pixels", 50_000_000, "Largest source width*height")
+	flag.IntVar(&cfg.MaxOutputDimension, "max-dimension", 8192, "Largest requested width or height")
+	flag.DurationVar(&cfg.OriginTimeout, "origin-timeout", 10*time.Second, "Timeout for origin requests")
+	flag.IntVar(&cfg.MaxConcurrency, "concurrency", 0, "Encodes running at the same time (default GOMAXPROCS)")
+	flag.IntVar(&cfg.MaxIdleEncoders, "max-idle-encoders", 0, "Idle encoders kept for reuse across all formats and qualities (default 2x concurrency)")
+	flag.DurationVar(&cfg.QueueTimeout, "queue-timeout", 30*time.Second, "Longest wait for an encode slot")
+	flag.IntVar(&cfg.WebPQuality, "webp-quality", 80, "Default WebP quality (1-100)")
+	flag.IntVar(&cfg.AVIFQuality, "avif-quality", 60, "Default AVIF quality (1-100)")
+	flag.IntVar(&cfg.AVIFSpeed, "avif-speed", 6, "AVIF encoder speed (1-10)")
+	flag.DurationVar(&cfg.CacheMaxAge, "max-age", time.Hour, "Cache-Control max-age of responses")
+	flag.Parse()
+
+	if *keyHex != "" {
+		key, err := hex.DecodeString(*keyHex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -key: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Key = key
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer server.Close()
+	if len(cfg.Key) == 0 {
+		log.Printf("nextimage-server: no signing key, accepting unsigned URLs")
+	}
+
+	httpServer := &http.Server{
+		Addr:              *listen,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("nextimage-server: listening on %s", *listen)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics holds server counters, exposed at /metrics in the Prometheus text format
+type metrics struct {
+	inFlight    atomic.Int64
+	queued      atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	sourceBytes atomic.Int64
+	outputBytes atomic.Int64
+
+	mu        sync.Mutex
+	responses map[int]int64         // Responses by status code
+	encodes   map[string]*histogram // Encode durations by format
+}
+
+// histogram is a count and a sum of seconds
+type histogram struct {
+	count int64
+	sum   float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		responses: make(map[int]int64),
+		encodes:   make(map[string]*histogram),
+	}
+}
+
+func (m *metrics) response(status int) {
+	m.mu.Lock()
+	m.responses[status]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) encode(format string, d time.Duration) {
+	m.mu.Lock()
+	h := m.encodes[format]
+	if h == nil {
+		h = &histogram{}
+		m.encodes[format] = h
+	}
+	h.count++
+	h.sum += d.Seconds()
+	m.mu.Unlock()
+}
+
+// write writes all metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) {
+	gauge := func(name, help string, v int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+	}
+	counter := func(name, help string, v int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+
+	gauge("nextimage_requests_in_flight", "Image requests being processed.", m.inFlight.Load())
+	gauge("nextimage_requests_queued", "Image requests waiting for an encode slot.", m.queued.Load())
+	counter("nextimage_cache_hits_total", "Image requests served from the disk cache.", m.cacheHits.Load())
+	counter("nextimage_cache_misses_total", "Image requests that had to be encoded.", m.cacheMisses.Load())
+	counter("nextimage_source_bytes_total", "Bytes read from sources.", m.sourceBytes.Load())
+	counter("nextimage_output_bytes_total", "Encoded bytes produced.", m.outputBytes.Load())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	codes := make([]int, 0, len(m.responses))
+	for code := range m.responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Fprintf(w, "# HELP nextimage_responses_total Image responses by status code.\n# TYPE nextimage_responses_total counter\n")
+	for _, code := range codes {
+		fmt.Fprintf(w, "nextimage_responses_total{code=\"%d\"} %d\n", code, m.responses[code])
+	}
+
+	formats := make([]string, 0, len(m.encodes))
+	for format := range m.encodes {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	fmt.Fprintf(w, "# HELP nextimage_encode_seconds Time spent encoding by output format.\n# TYPE nextimage_encode_seconds summary\n")
+	for _, format := range formats {
+		h := m.encodes[format]
+		fmt.Fprintf(w, "nextimage_encode_seconds_sum{format=%q} %g\n", format, h.sum)
+		fmt.Fprintf(w, "nextimage_encode_seconds_count{format=%q} %d\n", format, h.count)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Fit modes, following imgproxy's resizing types
+const (
+	fitInside = "fit"   // Scale to fit inside width x height, keeping the aspect ratio
+	fitFill   = "fill"  // Scale to cover width x height, cropping the overflow around the center
+	fitForce  = "force" // Scale to exactly width x height, ignoring the aspect ratio
+)
+
+// Output formats accepted in URLs
+const (
+	formatWebP = "webp"
+	formatAVIF = "avif"
+	formatAuto = "auto" // AVIF or WebP depending on the Accept header, else JPEG or PNG
+)
+
+// Formats f:auto falls back to when the client accepts neither AVIF nor WebP.
+// They are not accepted in URLs.
+const (
+	formatJPEG   = "jpeg"
+	formatPNG    = "png"
+	formatLegacy = "legacy" // PNG if the image has transparency, JPEG otherwise; chosen after decoding
+)
+
+// unsignedSignatures are accepted in place of a signature when no key is configured
+var unsignedSignatures = map[string]bool{"_": true, "insecure": true}
+
+// transform is a parsed image URL:
+//
+//	/<signature>/<option>/<option>/.../plain/<source path>
+//
+// Options are name:value pairs: w (width), h (height), fit, f (format) and q (quality).
+type transform struct {
+	Width   int    // 0 = derived from Height
+	Height  int    // 0 = derived from Width
+	Fit     string // fitInside, fitFill or fitForce
+	Format  string // formatWebP, formatAVIF or formatAuto
+	Quality int    // 0 = server default for the format
+	Source  string // Unescaped, cleaned source path without a leading slash
+
+	signature string
+	signed    string // Part of the URL path covered by the signature
+}
+
+// parseTransform parses an escaped image URL path
+func parseTransform(urlPath string) (*transform, error) {
+	segments := strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+	if len(segments) < 3 {
+		return nil, fmt.Errorf("expected /<signature>/<options>/plain/<source>")
+	}
+
+	t := &transform{
+		Fit:       fitInside,
+		Format:    formatAuto,
+		signature: segments[0],
+		signed:    urlPath[len(segments[0])+1:],
+	}
+
+	i := 1
+	for ; i < len(segments) && segments[i] != "plain"; i++ {
+		name, value, ok := strings.Cut(segments[i], ":")
+		if !ok {
+			return nil, fmt.Errorf("option %q: expected name:value", segments[i])
+		}
+		if err := t.set(name, value); err != nil {
+			return nil, fmt.Errorf("option %q: %w", segments[i], err)
+		}
+	}
+	if i >= len(segments)-1 {
+		return nil, fmt.Errorf("missing /plain/<source>")
+	}
+
+	source, err := url.PathUnescape(strings.Join(segments[i+1:], "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source path: %w", err)
+	}
+	cleaned := path.Clean("/" + source)
+	if cleaned != "/"+source || cleaned == "/" {
+		return nil, fmt.Errorf("invalid source path %q", source)
+	}
+	t.Source = cleaned[1:]
+	return t, nil
+}
+
+// set applies one option
+func (t *transform) set(name, value string) error {
+	switch name {
+	case "w", "width", "h", "height", "q", "quality":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		switch name[0] {
+		case 'w':
+			t.Width = n
+		case 'h':
+			t.Height = n
+		case 'q':
+			if n > 100 {
+				return fmt.Errorf("quality must be 0-100")
+			}
+			t.Quality = n
+		}
+	case "fit", "rt":
+		switch value {
+		case fitInside, fitFill, fitForce:
+			t.Fit = value
+		default:
+			return fmt.Errorf("unknown fit %q (fit, fill or force)", value)
+		}
+	case "f", "format":
+		switch value {
+		case formatWebP, formatAVIF, formatAuto:
+			t.Format = value
+		default:
+			return fmt.Errorf("unknown format %q (webp, avif or auto)", value)
+		}
+	default:
+		return fmt.Errorf("unknown option")
+	}
+	return nil
+}
+
+// sign returns the URL signature for the part of the path after the signature segment:
+// unpadded base64url of HMAC-SHA256 over it
+func sign(key []byte, signed string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature against the key; any unsigned placeholder is accepted without a key
+func (t *transform) verify(key []byte) bool {
+	if len(key) == 0 {
+		return unsignedSignatures[t.signature]
+	}
+	expected := sign(key, t.signed)
+	return hmac.Equal([]byte(t.signature), []byte(expected))
+}
+
+// plan computes the source crop and output size for a source of srcW x srcH.
+// The crop is the whole source unless Fit is fill. Images are never enlarged
+// except by force.
+func (t *transform) plan(srcW, srcH int) (crop [4]int, outW, outH int) {
+	crop = [4]int{0, 0, srcW, srcH}
+	w, h := t.Width, t.Height
+	if w == 0 && h == 0 {
+		return crop, srcW, srcH
+	}
+
+	switch {
+	case t.Fit == fitForce:
+		if w == 0 {
+			w = scaled(srcW, h, srcH)
+		}
+		if h == 0 {
+			h = scaled(srcH, w, srcW)
+		}
+		return crop, w, h
+
+	case t.Fit == fitFill && w > 0 && h > 0:
+		// Largest centered region with the requested aspect ratio
+		cw, ch := srcW, scaled(srcW, h, w)
+		if ch > srcH {
+			cw, ch = scaled(srcH, w, h), srcH
+		}
+		crop = [4]int{(srcW - cw) / 2, (srcH - ch) / 2, cw, ch}
+		if w > cw {
+			return crop, cw, ch
+		}
+		return crop, w, h
+	}
+
+	// fit: the side that constrains most decides the scale
+	if w == 0 || (h > 0 && int64(h)*int64(srcW) < int64(w)*int64(srcH)) {
+		w = scaled(srcW, h, srcH)
+	} else {
+		h = scaled(srcH, w, srcW)
+	}
+	if w >= srcW || h >= srcH {
+		return crop, srcW, srcH
+	}
+	return crop, w, h
+}
+
+// scaled returns v * num / den rounded to the nearest integer, at least 1
+func scaled(v, num, den int) int {
+	r := int((int64(v)*int64(num)*2 + int64(den)) / (int64(den) * 2))
+	if r < 1 {
+		r = 1
+	}
+	return r
+}
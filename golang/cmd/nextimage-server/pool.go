@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// encoder is the common interface of WebPEncoder and AVIFEncoder
+type encoder interface {
+	Encode(imageFileData []byte) ([]byte, error)
+	Close()
+}
+
+// poolKey identifies encoders created with the same options
+type poolKey struct {
+	format  libnextimage.ImageFormat
+	quality int
+}
+
+// idleEncoder is an idle encoder with the key it was created for
+type idleEncoder struct {
+	key poolKey
+	e   encoder
+}
+
+// encoderPools keeps idle encoder instances per format and quality for reuse.
+// An encoder is used by one request at a time. As qualities come from URLs, the total
+// number of idle encoders is capped as well; the least recently returned one is closed
+// to make room.
+type encoderPools struct {
+	newEncoder func(poolKey) (encoder, error)
+	maxIdle    int // Idle encoders kept per key
+	maxTotal   int // Idle encoders kept across all keys
+
+	mu     sync.Mutex
+	idle   []idleEncoder // Least recently returned first
+	closed bool
+}
+
+func newEncoderPools(maxIdle, maxTotal int, newEncoder func(poolKey) (encoder, error)) *encoderPools {
+	return &encoderPools{
+		newEncoder: newEncoder,
+		maxIdle:    maxIdle,
+		maxTotal:   maxTotal,
+	}
+}
+
+// get returns an idle encoder for the key or creates one
+func (p *encoderPools) get(key poolKey) (encoder, error) {
+	p.mu.Lock()
+	for i := len(p.idle) - 1; i >= 0; i-- {
+		if p.idle[i].key == key {
+			e := p.idle[i].e
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			p.mu.Unlock()
+			return e, nil
+		}
+	}
+	p.mu.Unlock()
+	return p.newEncoder(key)
+}
+
+// put returns an encoder to the pool, closing it if the key's pool is full or the pools
+// are closed, and closing the least recently returned encoder beyond the total cap
+func (p *encoderPools) put(key poolKey, e encoder) {
+	p.mu.Lock()
+	if p.closed || p.count(key) >= p.maxIdle {
+		p.mu.Unlock()
+		e.Close()
+		return
+	}
+	p.idle = append(p.idle, idleEncoder{key: key, e: e})
+	var evicted []encoder
+	for len(p.idle) > p.maxTotal {
+		evicted = append(evicted, p.idle[0].e)
+		p.idle = p.idle[1:]
+	}
+	p.mu.Unlock()
+	for _, e := range evicted {
+		e.Close()
+	}
+}
+
+// count returns the number of idle encoders for a key; the caller holds mu
+func (p *encoderPools) count(key poolKey) int {
+	n := 0
+	for _, ie := range p.idle {
+		if ie.key == key {
+			n++
+		}
+	}
+	return n
+}
+
+// encode encodes image file data with a pooled encoder
+func (p *encoderPools) encode(key poolKey, imageFileData []byte) ([]byte, error) {
+	e, err := p.get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer p.put(key, e)
+	return e.Encode(imageFileData)
+}
+
+// close releases all idle encoders; encoders in use are closed when returned
+func (p *encoderPools) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, ie := range p.idle {
+		ie.e.Close()
+	}
+	p.idle = nil
+}
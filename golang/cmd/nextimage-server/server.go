@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// Config configures the image server
+type Config struct {
+	// Image source: a local directory (Root) or an HTTP origin base URL (Origin). Exactly one is required.
+	Root   string
+	Origin string
+
+	// Key verifies URL signatures. Empty accepts unsigned URLs ("_" or "insecure" as the signature).
+	Key []byte
+
+	// CacheDir stores encoded images on disk. Empty disables the cache.
+	CacheDir string
+	// CacheMaxBytes bounds the disk cache; least recently used entries are removed beyond it (default 1 GiB)
+	CacheMaxBytes int64
+
+	// Per-request limits
+	MaxSourceBytes     int64         // Largest source file accepted (default 32 MiB)
+	MaxSourcePixels    int           // Largest source width*height accepted (default 50 megapixels)
+	MaxOutputDimension int           // Largest requested width or height (default 8192)
+	OriginTimeout      time.Duration // Timeout for fetching from Origin (default 10s)
+
+	// Encoding
+	MaxConcurrency  int           // Encodes running at the same time (default GOMAXPROCS)
+	MaxIdleEncoders int           // Idle pooled encoders kept across all formats and qualities (default 2*MaxConcurrency)
+	QueueTimeout    time.Duration // Longest wait for an encode slot before 503 (default 30s)
+	WebPQuality     int           // Default WebP quality when the URL has none (default 80)
+	AVIFQuality     int           // Default AVIF quality when the URL has none (default 60)
+	AVIFSpeed       int           // AVIF encoder speed 0-10 (default 6)
+
+	// CacheMaxAge is sent as Cache-Control max-age (default 1h)
+	CacheMaxAge time.Duration
+
+	// Logger receives processing errors. Nil uses the standard logger.
+	Logger *log.Logger
+}
+
+// withDefaults fills unset fields
+func (c Config) withDefaults() Config {
+	if c.MaxSourceBytes <= 0 {
+		c.MaxSourceBytes = 32 << 20
+	}
+	if c.MaxSourcePixels <= 0 {
+		c.MaxSourcePixels = 50_000_000
+	}
+	if c.MaxOutputDimension <= 0 {
+		c.MaxOutputDimension = 8192
+	}
+	if c.CacheMaxBytes <= 0 {
+		c.CacheMaxBytes = 1 << 30
+	}
+	if c.OriginTimeout <= 0 {
+		c.OriginTimeout = 10 * time.Second
+	}
+	if c.MaxConcurrency <= 0 {
+		c.MaxConcurrency = runtime.GOMAXPROCS(0)
+	}
+	if c.MaxIdleEncoders <= 0 {
+		c.MaxIdleEncoders = 2 * c.MaxConcurrency
+	}
+	if c.QueueTimeout <= 0 {
+		c.QueueTimeout = 30 * time.Second
+	}
+	if c.WebPQuality <= 0 {
+		c.WebPQuality = 80
+	}
+	if c.AVIFQuality <= 0 {
+		c.AVIFQuality = 60
+	}
+	if c.AVIFSpeed <= 0 {
+		c.AVIFSpeed = 6
+	}
+	if c.CacheMaxAge <= 0 {
+		c.CacheMaxAge = time.Hour
+	}
+	if c.Logger == nil {
+		c.Logger = log.Default()
+	}
+	return c
+}
+
+// Server serves transformed images plus /health and /metrics
+type Server struct {
+	cfg     Config
+	root    *os.Root
+	origin  *url.URL
+	client  *http.Client
+	cache   *diskCache
+	pools   *encoderPools
+	slots   chan struct{}
+	metrics *metrics
+	mux     *http.ServeMux
+}
+
+// NewServer validates the config and creates a server
+func NewServer(cfg Config) (*Server, error) {
+	cfg = cfg.withDefaults()
+	s := &Server{
+		cfg:     cfg,
+		slots:   make(chan struct{}, cfg.MaxConcurrency),
+		metrics: newMetrics(),
+		client:  &http.Client{Timeout: cfg.OriginTimeout},
+	}
+
+	switch {
+	case (cfg.Root == "") == (cfg.Origin == ""):
+		return nil, fmt.Errorf("exactly one of root and origin is required")
+	case cfg.Root != "":
+		root, err := os.OpenRoot(cfg.Root)
+		if err != nil {
+			return nil, fmt.Errorf("root: %w", err)
+		}
+		s.root = root
+	default:
+		origin, err := url.Parse(cfg.Origin)
+		if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") {
+			return nil, fmt.Errorf("origin: invalid URL %q", cfg.Origin)
+		}
+		s.origin = origin
+	}
+
+	if cfg.CacheDir != "" {
+		cache, err := newDiskCache(cfg.CacheDir, cfg.CacheMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cache: %w", err)
+		}
+		s.cache = cache
+	}
+
+	s.pools = newEncoderPools(cfg.MaxConcurrency, cfg.MaxIdleEncoders, s.newEncoder)
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /health", s.handleHealth)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	s.mux.HandleFunc("GET /", s.handleImage)
+	return s, nil
+}
+
+// Close releases the pooled encoders and the source root
+func (s *Server) Close() error {
+	s.pools.close()
+	if s.root != nil {
+		return s.root.Close()
+	}
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w)
+}
+
+// webpOptions returns the encoder options for a quality
+func (s *Server) webpOptions(quality int) libnextimage.WebPEncodeOptions {
+	opts := libnextimage.DefaultWebPEncodeOptions()
+	opts.Quality = float32(quality)
+	return opts
+}
+
+// avifOptions returns the encoder options for a quality
+func (s *Server) avifOptions(quality int) libnextimage.AVIFEncodeOptions {
+	opts := libnextimage.DefaultAVIFEncodeOptions()
+	opts.Quality = quality
+	opts.Speed = s.cfg.AVIFSpeed
+	return opts
+}
+
+// quality returns the quality a transform is encoded with in a format
+func (s *Server) quality(t *transform, format string) int {
+	if t.Quality > 0 {
+		return t.Quality
+	}
+	switch format {
+	case formatWebP:
+		return s.cfg.WebPQuality
+	case formatAVIF:
+		return s.cfg.AVIFQuality
+	case formatLegacy:
+		return jpeg.DefaultQuality
+	}
+	return 0
+}
+
+// encoderOptions describes the effective encoder options for the cache key,
+// so changing the server defaults does not serve stale entries
+func (s *Server) encoderOptions(t *transform, format string) string {
+	if format == formatAVIF {
+		return fmt.Sprintf("q=%d speed=%d", s.quality(t, format), s.cfg.AVIFSpeed)
+	}
+	return fmt.Sprintf("q=%d", s.quality(t, format))
+}
+
+// newEncoder creates a pooled encoder with the same options as the resize path
+func (s *Server) newEncoder(key poolKey) (encoder, error) {
+	if key.format == libnextimage.ImageFormatAVIF {
+		return libnextimage.NewAVIFEncoder(func(opts *libnextimage.AVIFEncodeOptions) {
+			*opts = s.avifOptions(key.quality)
+		})
+	}
+	return libnextimage.NewWebPEncoder(func(opts *libnextimage.WebPEncodeOptions) {
+		*opts = s.webpOptions(key.quality)
+	})
+}
+
+// httpError is an error with the status code it is reported as
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	s.metrics.inFlight.Add(1)
+	defer s.metrics.inFlight.Add(-1)
+
+	status, err := s.serveImage(w, r)
+	if err != nil {
+		var he *httpError
+		if errors.As(err, &he) {
+			status = he.status
+		} else {
+			status = http.StatusInternalServerError
+		}
+		if status >= 500 {
+			s.cfg.Logger.Printf("nextimage-server: %s: %v", r.URL.Path, err)
+		}
+		w.Header().Del("ETag")
+		w.Header().Del("Cache-Control")
+		http.Error(w, err.Error(), status)
+	}
+	s.metrics.response(status)
+}
+
+// serveImage handles one image request and returns the status written
+func (s *Server) serveImage(w http.ResponseWriter, r *http.Request) (int, error) {
+	t, err := parseTransform(r.URL.EscapedPath())
+	if err != nil {
+		return 0, errorf(http.StatusBadRequest, "bad URL: %v", err)
+	}
+	if !t.verify(s.cfg.Key) {
+		return 0, errorf(http.StatusForbidden, "invalid signature")
+	}
+	if t.Width > s.cfg.MaxOutputDimension || t.Height > s.cfg.MaxOutputDimension {
+		return 0, errorf(http.StatusBadRequest, "requested size exceeds %d", s.cfg.MaxOutputDimension)
+	}
+
+	src, err := s.open(r.Context(), t.Source)
+	if err != nil {
+		return 0, err
+	}
+	defer src.close()
+
+	format := t.Format
+	if format == formatAuto {
+		w.Header().Add("Vary", "Accept")
+		switch libnextimage.PreferredImageFormat(r.Header.Get("Accept"),
+			libnextimage.ImageFormatAVIF, libnextimage.ImageFormatWebP) {
+		case libnextimage.ImageFormatAVIF:
+			format = formatAVIF
+		case libnextimage.ImageFormatWebP:
+			format = formatWebP
+		default:
+			format = formatLegacy
+		}
+	}
+
+	key := cacheKey(t, format, src.validator, s.encoderOptions(t, format))
+	etag := `"` + key[:32] + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.cfg.CacheMaxAge.Seconds())))
+	if match := r.Header.Get("If-None-Match"); match == etag || match == "*" {
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified, nil
+	}
+
+	data, hit := s.cache.get(key)
+	if hit {
+		s.metrics.cacheHits.Add(1)
+	} else {
+		s.metrics.cacheMisses.Add(1)
+		data, err = s.process(r.Context(), src, t, format)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.cache.put(key, data); err != nil {
+			s.cfg.Logger.Printf("nextimage-server: cache: %v", err)
+		}
+	}
+
+	contentType := "image/" + format
+	if format == formatLegacy {
+		contentType = http.DetectContentType(data) // JPEG or PNG, decided when encoding
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	return http.StatusOK, nil
+}
+
+// process reads the source and encodes it within an encode slot
+func (s *Server) process(ctx context.Context, src *sourceFile, t *transform, format string) ([]byte, error) {
+	source, err := src.read(s.cfg.MaxSourceBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.sourceBytes.Add(int64(len(source)))
+
+	s.metrics.queued.Add(1)
+	timer := time.NewTimer(s.cfg.QueueTimeout)
+	select {
+	case s.slots <- struct{}{}:
+		timer.Stop()
+		s.metrics.queued.Add(-1)
+	case <-timer.C:
+		s.metrics.queued.Add(-1)
+		return nil, errorf(http.StatusServiceUnavailable, "server busy")
+	case <-ctx.Done():
+		timer.Stop()
+		s.metrics.queued.Add(-1)
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.slots }()
+
+	start := time.Now()
+	data, err := s.encode(source, t, format)
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.encode(format, time.Since(start))
+	s.metrics.outputBytes.Add(int64(len(data)))
+	return data, nil
+}
+
+// sourceFile is an opened source with the validator that identifies its content
+type sourceFile struct {
+	validator string   // Size and modification time of a local file, content hash of an origin response
+	head      []byte   // Leading bytes for format sniffing
+	file      *os.File // Local source, read on a cache miss
+	data      []byte   // Origin source, fetched in full
+}
+
+// read returns the source content, up to maxBytes
+func (f *sourceFile) read(maxBytes int64) ([]byte, error) {
+	if f.file == nil {
+		return f.data, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(f.file, maxBytes+1))
+	if err != nil {
+		return nil, errorf(http.StatusBadGateway, "source: %v", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "source exceeds %d bytes", maxBytes)
+	}
+	return data, nil
+}
+
+func (f *sourceFile) close() {
+	if f.file != nil {
+		f.file.Close()
+	}
+}
+
+// open opens the source in the local root or fetches it from the origin, up to MaxSourceBytes.
+// A local file is only read when the result is not cached.
+func (s *Server) open(ctx context.Context, source string) (*sourceFile, error) {
+	if s.root != nil {
+		f, err := s.root.Open(source)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errorf(http.StatusNotFound, "source not found")
+		} else if err != nil {
+			return nil, errorf(http.StatusBadRequest, "source: %v", err)
+		}
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			f.Close()
+			return nil, errorf(http.StatusNotFound, "source not found")
+		}
+		if info.Size() > s.cfg.MaxSourceBytes {
+			f.Close()
+			return nil, errorf(http.StatusRequestEntityTooLarge, "source exceeds %d bytes", s.cfg.MaxSourceBytes)
+		}
+		head := make([]byte, 512)
+		n, _ := f.ReadAt(head, 0)
+		return &sourceFile{
+			validator: fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()),
+			head:      head[:n],
+			file:      f,
+		}, nil
+	}
+
+	u := s.origin.JoinPath(source)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errorf(http.StatusBadGateway, "origin: %v", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errorf(http.StatusNotFound, "source not found")
+	case resp.StatusCode != http.StatusOK:
+		return nil, errorf(http.StatusBadGateway, "origin: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.cfg.MaxSourceBytes+1))
+	if err != nil {
+		return nil, errorf(http.StatusBadGateway, "source: %v", err)
+	}
+	if int64(len(data)) > s.cfg.MaxSourceBytes {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "source exceeds %d bytes", s.cfg.MaxSourceBytes)
+	}
+	sum := sha256.Sum256(data)
+	return &sourceFile{validator: hex.EncodeToString(sum[:]), head: data, data: data}, nil
+}
+
+// encode applies the transform and encodes the source.
+// Sources kept at their size go to the pooled encoders as they are, or are passed
+// through when already in the fallback format; others are decoded once, cropped and
+// rescaled before encoding.
+func (s *Server) encode(source []byte, t *transform, format string) ([]byte, error) {
+	width, height, ok := libnextimage.ProbeImageSize(source)
+	if !ok {
+		return nil, errorf(http.StatusUnsupportedMediaType, "source: unsupported image format")
+	}
+	if int64(width)*int64(height) > int64(s.cfg.MaxSourcePixels) {
+		return nil, errorf(http.StatusRequestEntityTooLarge, "source exceeds %d pixels", s.cfg.MaxSourcePixels)
+	}
+
+	key := poolKey{format: libnextimage.ImageFormatWebP, quality: s.quality(t, format)}
+	if format == formatAVIF {
+		key.format = libnextimage.ImageFormatAVIF
+	}
+
+	crop, outW, outH := t.plan(width, height)
+	outW, outH = s.clampOutput(outW, outH)
+	unchanged := crop == [4]int{0, 0, width, height} && outW == width && outH == height
+	sourceType := http.DetectContentType(source)
+	if unchanged {
+		switch {
+		case format == formatWebP || format == formatAVIF:
+			data, err := s.pools.encode(key, source)
+			if err != nil {
+				return nil, errorf(sourceStatus(err), "encode: %v", err)
+			}
+			return data, nil
+		case format == formatLegacy && sourceType == "image/jpeg":
+			return source, nil // JPEG has no transparency
+		}
+	}
+
+	img, err := libnextimage.DecodeImageBytes(source)
+	if err != nil {
+		return nil, errorf(sourceStatus(err), "source: %v", err)
+	}
+	img = cropImage(img, crop)
+	if format == formatLegacy {
+		legacy := legacyFormat(img)
+		if unchanged && sourceType == "image/"+legacy {
+			return source, nil
+		}
+		return encodeLegacyFormat(img, outW, outH, legacy, key.quality)
+	}
+	src, err := libnextimage.NewEncodeSourceFromImage(img)
+	if err != nil {
+		return nil, errorf(http.StatusUnsupportedMediaType, "%v", err)
+	}
+	out := src.Encode(libnextimage.EncodeJob{
+		Format: key.format,
+		WebP:   s.webpOptions(key.quality),
+		AVIF:   s.avifOptions(key.quality),
+		Width:  outW,
+		Height: outH,
+	})
+	if out.Err != nil {
+		return nil, fmt.Errorf("encode: %w", out.Err)
+	}
+	return out.Data, nil
+}
+
+// clampOutput scales an output size down, keeping the aspect ratio, until each side is
+// within MaxOutputDimension and the area within MaxSourcePixels. Forced sizes derived
+// from one side can otherwise exceed both.
+func (s *Server) clampOutput(w, h int) (int, int) {
+	scale := 1.0
+	if m := s.cfg.MaxOutputDimension; w > m || h > m {
+		scale = min(float64(m)/float64(w), float64(m)/float64(h))
+	}
+	if pixels := float64(w) * float64(h) * scale * scale; pixels > float64(s.cfg.MaxSourcePixels) {
+		scale *= math.Sqrt(float64(s.cfg.MaxSourcePixels) / pixels)
+	}
+	if scale >= 1 {
+		return w, h
+	}
+	return max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+}
+
+// sourceStatus is the HTTP status for a source the library could not decode or encode
+func sourceStatus(err error) int {
+	if errors.Is(err, libnextimage.ErrLimitExceeded) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusUnsupportedMediaType
+}
+
+// cropImage returns a view of the crop rectangle [x, y, width, height] sharing the pixel data
+func cropImage(img *libnextimage.DecodedImage, crop [4]int) *libnextimage.DecodedImage {
+	if crop == [4]int{0, 0, img.Width, img.Height} {
+		return img
+	}
+	bpp := 4
+	if img.Format == libnextimage.FormatRGB {
+		bpp = 3
+	}
+	cropped := *img
+	cropped.Data = img.Data[crop[1]*img.Stride+crop[0]*bpp:]
+	cropped.Width = crop[2]
+	cropped.Height = crop[3]
+	return &cropped
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+var testdataDir = filepath.Join("..", "..", "..", "testdata")
+
+var testKey = []byte("secret")
+
+// TestParseTransform tests URL parsing and validation
+func TestParseTransform(t *testing.T) {
+	tr, err := parseTransform("/sig/w:300/h:200/fit:fill/f:avif/q:70/plain/photos/a%20b.jpg")
+	if err != nil {
+		t.Fatalf("parseTransform failed: %v", err)
+	}
+	if tr.Width != 300 || tr.Height != 200 || tr.Fit != fitFill || tr.Format != formatAVIF || tr.Quality != 70 {
+		t.Errorf("unexpected options %+v", tr)
+	}
+	if tr.Source != "photos/a b.jpg" || tr.signed != "/w:300/h:200/fit:fill/f:avif/q:70/plain/photos/a%20b.jpg" {
+		t.Errorf("unexpected source %q / signed %q", tr.Source, tr.signed)
+	}
+
+	for _, bad := range []string{
+		"/sig/plain",
+		"/sig/w:300/photo.jpg",
+		"/sig/w:abc/plain/a.jpg",
+		"/sig/q:101/plain/a.jpg",
+		"/sig/fit:crop/plain/a.jpg",
+		"/sig/f:png/plain/a.jpg",
+		"/sig/x:1/plain/a.jpg",
+		"/sig/plain/../secret.jpg",
+		"/sig/plain/a/%2e%2e/%2e%2e/secret.jpg",
+	} {
+		if _, err := parseTransform(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+
+	t.Logf("✓ URL parsing")
+}
+
+// TestPlan tests crop and output size computation for each fit mode
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		w, h       int
+		fit        string
+		crop       [4]int
+		outW, outH int
+	}{
+		{0, 0, fitInside, [4]int{0, 0, 800, 600}, 800, 600},
+		{400, 0, fitInside, [4]int{0, 0, 800, 600}, 400, 300},
+		{0, 150, fitInside, [4]int{0, 0, 800, 600}, 200, 150},
+		{400, 400, fitInside, [4]int{0, 0, 800, 600}, 400, 300},
+		{1600, 0, fitInside, [4]int{0, 0, 800, 600}, 800, 600}, // never enlarged
+		{200, 200, fitFill, [4]int{100, 0, 600, 600}, 200, 200},
+		{800, 200, fitFill, [4]int{0, 200, 800, 200}, 800, 200},
+		{1200, 1200, fitFill, [4]int{100, 0, 600, 600}, 600, 600},
+		{100, 100, fitForce, [4]int{0, 0, 800, 600}, 100, 100},
+		{1600, 0, fitForce, [4]int{0, 0, 800, 600}, 1600, 1200},
+	}
+	for _, tt := range tests {
+		tr := &transform{Width: tt.w, Height: tt.h, Fit: tt.fit}
+		crop, outW, outH := tr.plan(800, 600)
+		if crop != tt.crop || outW != tt.outW || outH != tt.outH {
+			t.Errorf("%dx%d %s: got crop %v %dx%d, expected crop %v %dx%d",
+				tt.w, tt.h, tt.fit, crop, outW, outH, tt.crop, tt.outW, tt.outH)
+		}
+	}
+	t.Logf("✓ %d plans", len(tests))
+}
+
+// newTestServer serves testdata through a signed image server
+func newTestServer(t *testing.T, cfg Config) *httptest.Server {
+	if cfg.Origin == "" {
+		cfg.Root = testdataDir
+	}
+	cfg.Key = testKey
+	cfg.AVIFSpeed = 10
+	cfg.Logger = log.New(io.Discard, "", 0)
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(func() {
+		ts.Close()
+		server.Close()
+	})
+	return ts
+}
+
+// signedURL returns the signed URL for the options and source
+func signedURL(base, options string) string {
+	return base + "/" + sign(testKey, "/"+options) + "/" + options
+}
+
+func get(t *testing.T, url, accept string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	return resp, body
+}
+
+// TestServer tests transforms, signatures, the disk cache and the health/metrics endpoints
+func TestServer(t *testing.T) {
+	cacheDir := t.TempDir()
+	ts := newTestServer(t, Config{CacheDir: cacheDir})
+
+	source, err := libnextimage.DecodeImageBytes(mustRead(t, filepath.Join(testdataDir, "jpeg", "test.jpg")))
+	if err != nil {
+		t.Fatalf("Failed to decode source: %v", err)
+	}
+
+	tests := []struct {
+		options     string
+		accept      string
+		contentType string
+		width       int
+		height      int
+	}{
+		{"f:webp/plain/jpeg/test.jpg", "", "image/webp", source.Width, source.Height},
+		{"w:32/f:webp/plain/jpeg/test.jpg", "", "image/webp", 32, scaled(source.Height, 32, source.Width)},
+		{"w:48/h:24/fit:fill/f:avif/q:50/plain/jpeg/test.jpg", "", "image/avif", 48, 24},
+		{"w:32/h:16/fit:force/plain/jpeg/test.jpg", "image/avif,image/webp", "image/avif", 32, 16},
+		{"w:32/h:16/fit:force/plain/jpeg/test.jpg", "image/webp", "image/webp", 32, 16},
+		{"w:40/plain/source/alpha/alpha-gradient.png", "image/webp", "image/webp", 40, 0},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, signedURL(ts.URL, tt.options), tt.accept)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.options, resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: Content-Type %s, expected %s", tt.options, ct, tt.contentType)
+		}
+		decoded, err := libnextimage.DecodeImageBytes(body)
+		if err != nil {
+			t.Fatalf("%s: decode failed: %v", tt.options, err)
+		}
+		if decoded.Width != tt.width || (tt.height > 0 && decoded.Height != tt.height) {
+			t.Errorf("%s: got %dx%d, expected %dx%d", tt.options, decoded.Width, decoded.Height, tt.width, tt.height)
+		}
+		if strings.Contains(tt.options, "f:") != (resp.Header.Get("Vary") == "") {
+			t.Errorf("%s: unexpected Vary %q", tt.options, resp.Header.Get("Vary"))
+		}
+		t.Logf("  %-52s %s %dx%d %d bytes", tt.options, tt.contentType, decoded.Width, decoded.Height, len(body))
+	}
+
+	// Cached responses are identical and revalidate with the ETag
+	url := signedURL(ts.URL, "w:32/f:webp/plain/jpeg/test.jpg")
+	resp, first := get(t, url, "")
+	_, second := get(t, url, "")
+	if !bytes.Equal(first, second) {
+		t.Error("cached response differs")
+	}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	revalidated, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("revalidation failed: %v", err)
+	}
+	revalidated.Body.Close()
+	if revalidated.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", revalidated.StatusCode)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*")); len(entries) != len(tests) {
+		t.Errorf("expected %d cache entries, got %d", len(tests), len(entries))
+	}
+
+	// Errors
+	for _, tt := range []struct {
+		url    string
+		status int
+	}{
+		{ts.URL + "/bad-signature/w:64/plain/jpeg/test.jpg", http.StatusForbidden},
+		{ts.URL + "/_/w:64/plain/jpeg/test.jpg", http.StatusForbidden},
+		{signedURL(ts.URL, "w:64/plain/jpeg/missing.jpg"), http.StatusNotFound},
+		{signedURL(ts.URL, "w:9000/plain/jpeg/test.jpg"), http.StatusBadRequest},
+		{signedURL(ts.URL, "w:64/plain/jpeg"), http.StatusNotFound},
+		{signedURL(ts.URL, "w:64/plain/metadata/test.xmp"), http.StatusUnsupportedMediaType},
+	} {
+		resp, body := get(t, tt.url, "")
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, expected %d (%s)", tt.url, resp.StatusCode, tt.status, body)
+		}
+		if resp.Header.Get("ETag") != "" {
+			t.Errorf("%s: error response has an ETag", tt.url)
+		}
+	}
+
+	resp, body := get(t, ts.URL+"/health", "")
+	if resp.StatusCode != http.StatusOK || string(body) != "ok\n" {
+		t.Errorf("unexpected health response %d %q", resp.StatusCode, body)
+	}
+	_, body = get(t, ts.URL+"/metrics", "")
+	for _, want := range []string{
+		"nextimage_cache_hits_total 2\n",
+		`nextimage_responses_total{code="200"}`,
+		`nextimage_responses_total{code="403"} 2`,
+		`nextimage_encode_seconds_count{format="avif"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	t.Logf("✓ %d transforms served", len(tests))
+}
+
+// TestServer_OriginAndLimits tests fetching from an HTTP origin and the per-request limits
+func TestServer_OriginAndLimits(t *testing.T) {
+	origin := httptest.NewServer(http.FileServer(http.Dir(testdataDir)))
+	defer origin.Close()
+
+	ts := newTestServer(t, Config{Origin: origin.URL + "/jpeg", MaxSourcePixels: 32 * 32})
+
+	resp, body := get(t, signedURL(ts.URL, "f:webp/plain/test.jpg"), "")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for too many pixels, got %d: %s", resp.StatusCode, body)
+	}
+	resp, _ = get(t, signedURL(ts.URL, "f:webp/plain/missing.jpg"), "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 from origin, got %d", resp.StatusCode)
+	}
+
+	info, err := os.Stat(filepath.Join(testdataDir, "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	ts = newTestServer(t, Config{Origin: origin.URL + "/jpeg", MaxSourceBytes: info.Size() - 1})
+	resp, _ = get(t, signedURL(ts.URL, "f:webp/plain/test.jpg"), "")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a large source, got %d", resp.StatusCode)
+	}
+
+	ts = newTestServer(t, Config{Origin: origin.URL + "/jpeg"})
+	resp, body = get(t, signedURL(ts.URL, "w:50/f:avif/plain/test.jpg"), "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/avif" {
+		t.Fatalf("origin fetch failed: %d %s", resp.StatusCode, body)
+	}
+
+	if _, err := NewServer(Config{}); err == nil {
+		t.Error("expected error without a source")
+	}
+
+	t.Logf("✓ origin fetch and limits")
+}
+
+// TestServer_SourceChangesAndFallback tests that the ETag follows the source content and
+// that f:auto falls back to the source format
+func TestServer_SourceChangesAndFallback(t *testing.T) {
+	root := t.TempDir()
+	jpegData := mustRead(t, filepath.Join(testdataDir, "jpeg", "test.jpg"))
+	path := filepath.Join(root, "a.jpg")
+	if err := os.WriteFile(path, jpegData, 0644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	cfg := Config{Root: root, CacheDir: t.TempDir(), Key: testKey, AVIFSpeed: 10, Logger: log.New(io.Discard, "", 0)}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	ts := httptest.NewServer(server)
+	defer func() {
+		ts.Close()
+		server.Close()
+	}()
+
+	// Neither AVIF nor WebP accepted: unchanged sources pass through, resized ones are JPEG
+	resp, body := get(t, signedURL(ts.URL, "plain/a.jpg"), "image/jpeg,*/*")
+	if resp.Header.Get("Content-Type") != "image/jpeg" || !bytes.Equal(body, jpegData) {
+		t.Errorf("expected the source passed through, got %s %d bytes", resp.Header.Get("Content-Type"), len(body))
+	}
+	resp, body = get(t, signedURL(ts.URL, "w:32/plain/a.jpg"), "")
+	if resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("expected image/jpeg without Accept, got %s: %s", resp.Header.Get("Content-Type"), body)
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(body)); err != nil || cfg.Width != 32 {
+		t.Errorf("expected a 32 pixel wide JPEG, got %+v %v", cfg, err)
+	}
+
+	// PNG sources fall back to PNG only when they have transparency
+	for _, tt := range []struct {
+		source, name, contentType string
+	}{
+		{filepath.Join("source", "alpha", "alpha-gradient.png"), "alpha.png", "image/png"},
+		{filepath.Join("source", "colors", "photo-like.png"), "opaque.png", "image/jpeg"},
+	} {
+		if err := os.WriteFile(filepath.Join(root, tt.name), mustRead(t, filepath.Join(testdataDir, tt.source)), 0644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		for _, path := range []string{"plain/" + tt.name, "w:32/plain/" + tt.name} {
+			resp, body = get(t, signedURL(ts.URL, path), "")
+			if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("%s: expected %s, got %s", path, tt.contentType, ct)
+			}
+			if _, _, err := image.DecodeConfig(bytes.NewReader(body)); err != nil {
+				t.Errorf("%s: response does not decode: %v", path, err)
+			}
+		}
+	}
+
+	// Replacing the source changes the ETag and the cached response
+	url := signedURL(ts.URL, "w:32/f:webp/plain/a.jpg")
+	before, beforeBody := get(t, url, "")
+	png := mustRead(t, filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png"))
+	if err := os.WriteFile(path, png, 0644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	after, afterBody := get(t, url, "")
+	if before.Header.Get("ETag") == after.Header.Get("ETag") || bytes.Equal(beforeBody, afterBody) {
+		t.Error("expected a new ETag and body after the source changed")
+	}
+
+	t.Logf("✓ source changes and format fallback")
+}
+
+// TestClampOutput tests that output sizes are scaled into the pixel limits
+func TestClampOutput(t *testing.T) {
+	s := &Server{cfg: Config{MaxOutputDimension: 1000, MaxSourcePixels: 250_000}}
+	for _, tt := range []struct{ w, h, outW, outH int }{
+		{400, 300, 400, 300},
+		{2000, 500, 1000, 250},
+		{1000, 1000, 500, 500},
+		{8000, 10, 1000, 1},
+	} {
+		if w, h := s.clampOutput(tt.w, tt.h); w != tt.outW || h != tt.outH {
+			t.Errorf("%dx%d: got %dx%d, expected %dx%d", tt.w, tt.h, w, h, tt.outW, tt.outH)
+		}
+	}
+}
+
+// countingEncoder is a fake pooled encoder that counts closes
+type countingEncoder struct{ closed *int }
+
+func (e countingEncoder) Encode(data []byte) ([]byte, error) { return data, nil }
+func (e countingEncoder) Close()                             { *e.closed++ }
+
+// TestEncoderPools_MaxTotal tests that idle encoders are capped across keys
+func TestEncoderPools_MaxTotal(t *testing.T) {
+	closed := 0
+	created := 0
+	pools := newEncoderPools(2, 3, func(poolKey) (encoder, error) {
+		created++
+		return countingEncoder{&closed}, nil
+	})
+
+	// Five qualities in use at once leave five encoders to return
+	var inUse []encoder
+	for q := 1; q <= 5; q++ {
+		e, _ := pools.get(poolKey{format: libnextimage.ImageFormatWebP, quality: q})
+		inUse = append(inUse, e)
+	}
+	for i, e := range inUse {
+		pools.put(poolKey{format: libnextimage.ImageFormatWebP, quality: i + 1}, e)
+	}
+	if len(pools.idle) != 3 || closed != 2 {
+		t.Errorf("expected 3 idle and 2 closed encoders, got %d idle and %d closed", len(pools.idle), closed)
+	}
+
+	// The least recently returned encoders were closed; the recent ones are reused
+	pools.get(poolKey{format: libnextimage.ImageFormatWebP, quality: 1})
+	pools.get(poolKey{format: libnextimage.ImageFormatWebP, quality: 5})
+	if created != 6 {
+		t.Errorf("expected quality 1 to be created again and quality 5 reused, created %d", created)
+	}
+
+	pools.close()
+	if closed != 4 {
+		t.Errorf("expected the 2 remaining idle encoders closed, got %d closed", closed)
+	}
+}
+
+// TestDiskCache_Evict tests that the least recently used entries are removed beyond the bound
+func TestDiskCache_Evict(t *testing.T) {
+	c, err := newDiskCache(t.TempDir(), 350)
+	if err != nil {
+		t.Fatalf("newDiskCache failed: %v", err)
+	}
+	data := make([]byte, 100)
+	keys := []string{"aa01", "bb02", "cc03"}
+	for i, key := range keys {
+		if err := c.put(key, data); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		old := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(c.path(key), old, old)
+	}
+	c.get("aa01") // Most recently used now
+
+	if err := c.put("dd04", data); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	for key, want := range map[string]bool{"aa01": true, "bb02": false, "cc03": true, "dd04": true} {
+		if _, ok := c.get(key); ok != want {
+			t.Errorf("%s: cached=%v, expected %v", key, ok, want)
+		}
+	}
+	if c.size != 300 {
+		t.Errorf("expected 300 bytes cached, got %d", c.size)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return data
+}
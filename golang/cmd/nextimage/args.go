@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ideamans/libnextimage/golang/internal/cmdline"
+)
+
+// cliSyntax reports errors in the flags handled by nextimage itself. Encoding options
+// are parsed by the library (libnextimage.ParseCWebPArgs, ...) first, which passes the
+// other flags on; the flag tables of the commands only cover what remains: output
+// files, help, version and verbosity.
+var cliSyntax = cmdline.Syntax{
+	Unknown: "unknown option '%s'",
+	Missing: "missing value for '%s'",
+	Value: func(flag string, err error) error {
+		return fmt.Errorf("%s: %w", flag, err)
+	},
+}
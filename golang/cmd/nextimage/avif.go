@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+	"github.com/ideamans/libnextimage/golang/internal/cmdline"
+)
+
+// ========================================
+// avifenc
+// ========================================
+
+// avifencArgs is a parsed avifenc command line
+type avifencArgs struct {
+	opts    libnextimage.AVIFEncOptions
+	output  string
+	help    bool
+	version bool
+}
+
+var avifencFlags = []cmdline.Flag[avifencArgs]{
+	{Names: []string{"-o", "--output"}, NArgs: 1, Set: func(a *avifencArgs, v []string) error {
+		a.output = v[0]
+		return nil
+	}},
+	cmdline.Bool(func(a *avifencArgs) *bool { return &a.help }, true, "-h", "--help"),
+	cmdline.Bool(func(a *avifencArgs) *bool { return &a.version }, true, "-V", "--version"),
+}
+
+const avifencUsage = `Syntax: avifenc [options] input.[jpg|jpeg|png|y4m] output.avif
+
+Options mirror the upstream avifenc (-q/--qcolor, --qalpha, -s/--speed, -d/--depth,
+-y/--yuv, -r/--range, -l/--lossless, -p/--premultiply, --sharpyuv, --cicp, --min, --max,
+--minalpha, --maxalpha, --tilerowslog2, --tilecolslog2, --grid, --exif, --xmp, --icc,
+--irot, --imir, --pasp, --crop, --clap, --clli, --timescale, -k/--keyframe, -a/--advanced,
+--target-size, --progressive, -o/--output, -V/--version).
+`
+
+func runAVIFEnc(e *env, args []string) int {
+	opts, rest, err := libnextimage.ParseAVIFEncArgs(args)
+	if err != nil {
+		return usageError(e, avifencUsage, err)
+	}
+	a := avifencArgs{opts: opts}
+	positional, err := cmdline.Parse(&cliSyntax, avifencFlags, &a, rest)
+	if err != nil {
+		fmt.Fprint(e.stderr, avifencUsage)
+		return fail(e, "avifenc", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, avifencUsage)
+		return 0
+	}
+	if a.version {
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+	input, output, err := inputAndOutputFile(positional, a.output)
+	if err != nil {
+		fmt.Fprint(e.stderr, avifencUsage)
+		return fail(e, "avifenc", err)
+	}
+
+	data, err := readInput(e, input)
+	if err != nil {
+		return fail(e, "avifenc", fmt.Errorf("cannot read input file '%s': %w", input, err))
+	}
+	cmd, err := libnextimage.NewAVIFEncCommand(&a.opts)
+	if err != nil {
+		return fail(e, "avifenc", err)
+	}
+	defer cmd.Close()
+
+	result, err := cmd.RunWithResult(data)
+	if err != nil {
+		return fail(e, "avifenc", err)
+	}
+	if err := writeOutput(e, output, result.Data); err != nil {
+		return fail(e, "avifenc", fmt.Errorf("cannot write output file '%s': %w", output, err))
+	}
+	if output != "-" {
+		fmt.Fprintf(e.stdout, "Encoded successfully.\n")
+		fmt.Fprintf(e.stdout, " * Color AV1 total size: %d bytes\n", result.Stats.ColorOBUSize)
+		if result.Stats.AlphaOBUSize > 0 {
+			fmt.Fprintf(e.stdout, " * Alpha AV1 total size: %d bytes\n", result.Stats.AlphaOBUSize)
+		}
+		fmt.Fprintf(e.stdout, "Wrote AVIF: %s\n", output)
+	}
+	return 0
+}
+
+// inputAndOutputFile checks the positional arguments of avifenc/avifdec: input and output,
+// where the output may also be given with -o
+func inputAndOutputFile(positional []string, output string) (string, string, error) {
+	if output == "" && len(positional) == 2 {
+		return positional[0], positional[1], nil
+	}
+	switch {
+	case len(positional) == 0:
+		return "", "", fmt.Errorf("missing input file")
+	case output == "" && len(positional) == 1:
+		return "", "", fmt.Errorf("missing output file")
+	case len(positional) > 2 || (output != "" && len(positional) > 1):
+		return "", "", fmt.Errorf("too many positional arguments")
+	}
+	return positional[0], output, nil
+}
+
+// ========================================
+// avifdec
+// ========================================
+
+// avifdecArgs is a parsed avifdec command line
+type avifdecArgs struct {
+	opts    libnextimage.AVIFDecOptions
+	output  string
+	help    bool
+	version bool
+}
+
+var avifdecFlags = []cmdline.Flag[avifdecArgs]{
+	{Names: []string{"-o", "--output"}, NArgs: 1, Set: func(a *avifdecArgs, v []string) error {
+		a.output = v[0]
+		return nil
+	}},
+	cmdline.Bool(func(a *avifdecArgs) *bool { return &a.help }, true, "-h", "--help"),
+	cmdline.Bool(func(a *avifdecArgs) *bool { return &a.version }, true, "-V", "--version"),
+}
+
+const avifdecUsage = `Syntax: avifdec [options] input.avif output.[jpg|jpeg|png]
+
+Options mirror the upstream avifdec (-j/--jobs, -c/--codec, -d/--depth, -q/--quality,
+-u/--upsampling, --no-strict, --ignore-icc, --ignore-exif, --ignore-xmp, --size-limit,
+--dimension-limit, -V/--version). The output format follows the output file extension.
+`
+
+func runAVIFDec(e *env, args []string) int {
+	opts, rest, err := libnextimage.ParseAVIFDecArgs(args)
+	if err != nil {
+		return usageError(e, avifdecUsage, err)
+	}
+	a := avifdecArgs{opts: opts}
+	positional, err := cmdline.Parse(&cliSyntax, avifdecFlags, &a, rest)
+	if err != nil {
+		fmt.Fprint(e.stderr, avifdecUsage)
+		return fail(e, "avifdec", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, avifdecUsage)
+		return 0
+	}
+	if a.version {
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+	input, output, err := inputAndOutputFile(positional, a.output)
+	if err != nil {
+		fmt.Fprint(e.stderr, avifdecUsage)
+		return fail(e, "avifdec", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".png":
+		a.opts.OutputFormat = libnextimage.OutputPNG
+	case ".jpg", ".jpeg":
+		a.opts.OutputFormat = libnextimage.OutputJPEG
+	default:
+		return fail(e, "avifdec", fmt.Errorf("unrecognized file extension for output file: %s", output))
+	}
+
+	data, err := readInput(e, input)
+	if err != nil {
+		return fail(e, "avifdec", fmt.Errorf("cannot read input file '%s': %w", input, err))
+	}
+	cmd, err := libnextimage.NewAVIFDecCommand(&a.opts)
+	if err != nil {
+		return fail(e, "avifdec", err)
+	}
+	defer cmd.Close()
+
+	decoded, err := cmd.Run(data)
+	if err != nil {
+		return fail(e, "avifdec", err)
+	}
+	if err := writeOutput(e, output, decoded); err != nil {
+		return fail(e, "avifdec", fmt.Errorf("cannot write output file '%s': %w", output, err))
+	}
+	fmt.Fprintf(e.stdout, "Wrote %s\n", output)
+	return 0
+}
@@ -0,0 +1,159 @@
+// Command nextimage is a single binary replacing the cwebp, dwebp, avifenc, avifdec
+// and gif2webp command line tools, plus webp2gif, a watch mode for asset pipelines and
+// a coprocess mode for other languages.
+//
+// Each command accepts the upstream tool's flag syntax:
+//
+//	nextimage cwebp -q 80 -m 6 input.png -o output.webp
+//	nextimage avifenc -q 60 -s 6 input.png output.avif
+//
+// When the binary is invoked through a link named after a command (e.g. cwebp -> nextimage),
+// it runs that command directly, so it can stand in for the upstream binaries.
+// Input and output paths may be "-" for stdin and stdout.
+//
+// nextimage watch converts a directory tree to WebP and AVIF and keeps the outputs in
+// sync with it until interrupted:
+//
+//	nextimage watch -profile web-photo -exclude '**/raw/**' assets/ public/img/
+//
+// nextimage serve-stdio runs as a coprocess of programs in other languages, taking one
+// JSON request per line on stdin and answering with one JSON result per line on stdout:
+//
+//	{"id": 1, "op": "cwebp", "options": {"quality": 80}, "input": "in.png", "output": "out.webp"}
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// env is the process environment a command runs in
+type env struct {
+	ctx    context.Context // Cancels long-running commands (default: never)
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (e *env) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// command is a nextimage subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(e *env, args []string) int
+}
+
+var commands = []command{
+	{"cwebp", "Encode PNG/JPEG/TIFF/WebP to WebP", runCWebP},
+	{"dwebp", "Decode WebP to PNG", runDWebP},
+	{"avifenc", "Encode PNG/JPEG/TIFF to AVIF", runAVIFEnc},
+	{"avifdec", "Decode AVIF to PNG/JPEG", runAVIFDec},
+	{"gif2webp", "Convert GIF to (animated) WebP", runGif2WebP},
+	{"webp2gif", "Convert (animated) WebP to GIF", runWebP2Gif},
+	{"watch", "Convert a directory tree and keep it in sync", runWatch},
+	{"serve-stdio", "Run conversions for another process over JSON lines", runServeStdio},
+}
+
+func main() {
+	os.Exit(run(&env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}, os.Args))
+}
+
+// run dispatches on the program name or the first argument and returns the exit code
+func run(e *env, argv []string) int {
+	name := strings.TrimSuffix(filepath.Base(argv[0]), ".exe")
+	if cmd := lookupCommand(name); cmd != nil {
+		return cmd.run(e, argv[1:])
+	}
+
+	if len(argv) < 2 {
+		usage(e.stderr)
+		return 1
+	}
+	switch argv[1] {
+	case "help", "-h", "-help", "--help":
+		usage(e.stdout)
+		return 0
+	case "version", "-version", "--version":
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+
+	cmd := lookupCommand(argv[1])
+	if cmd == nil {
+		fmt.Fprintf(e.stderr, "nextimage: unknown command '%s'\n\n", argv[1])
+		usage(e.stderr)
+		return 1
+	}
+	return cmd.run(e, argv[2:])
+}
+
+func lookupCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: nextimage <command> [options]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nThe image commands accept the flags of the upstream tool of the same name.\n")
+	fmt.Fprintf(w, "Run 'nextimage <command> -h' for its options.\n")
+}
+
+// fail prints an error in the upstream style and returns the exit code
+func fail(e *env, tool string, err error) int {
+	fmt.Fprintf(e.stderr, "%s: %v\n", tool, err)
+	return 1
+}
+
+// usageError prints the usage and an option error, which is already in the upstream tool's words
+func usageError(e *env, usage string, err error) int {
+	fmt.Fprint(e.stderr, usage)
+	fmt.Fprintln(e.stderr, err)
+	return 1
+}
+
+// readInput reads a file, or stdin for "-"
+func readInput(e *env, path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(e.stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// writeOutput writes a file, or stdout for "-"
+func writeOutput(e *env, path string, data []byte) error {
+	if path == "-" {
+		_, err := e.stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// inputOutput checks the positional arguments of tools taking one input and an -o output
+func inputOutput(positional []string, output string) (string, string, error) {
+	switch {
+	case len(positional) == 0:
+		return "", "", fmt.Errorf("no input file specified")
+	case len(positional) > 1:
+		return "", "", fmt.Errorf("unexpected argument '%s'", positional[1])
+	}
+	return positional[0], output, nil
+}
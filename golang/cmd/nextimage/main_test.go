@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+var testdataDir = filepath.Join("..", "..", "..", "testdata")
+
+// TestRun tests each command end to end through the dispatcher
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	jpeg := filepath.Join(testdataDir, "jpeg", "test.jpg")
+	gif := filepath.Join(testdataDir, "gif-source", "animated-3frames.gif")
+	webp := filepath.Join(dir, "out.webp")
+	avif := filepath.Join(dir, "out.avif")
+
+	tests := []struct {
+		argv   []string
+		output string
+		format string
+	}{
+		{[]string{"nextimage", "cwebp", "-q", "75", "-quiet", jpeg, "-o", webp}, webp, "webp"},
+		{[]string{"/usr/local/bin/dwebp", webp, "-o", filepath.Join(dir, "out.png")}, filepath.Join(dir, "out.png"), "png"},
+		{[]string{"nextimage", "avifenc", "-q", "60", "-s", "10", jpeg, avif}, avif, "avif"},
+		{[]string{"avifdec", avif, filepath.Join(dir, "out.jpg")}, filepath.Join(dir, "out.jpg"), "jpeg"},
+		{[]string{"nextimage", "gif2webp", "-quiet", gif, "-o", filepath.Join(dir, "anim.webp")}, filepath.Join(dir, "anim.webp"), "webp"},
+		{[]string{"nextimage", "webp2gif", "-quiet", filepath.Join(dir, "anim.webp"), "-o", filepath.Join(dir, "anim.gif")}, filepath.Join(dir, "anim.gif"), "gif"},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := run(&env{stdout: &stdout, stderr: &stderr}, tt.argv); code != 0 {
+			t.Fatalf("%v: exit code %d: %s", tt.argv, code, stderr.String())
+		}
+		if format := sniff(mustRead(t, tt.output)); format != tt.format {
+			t.Errorf("%v: output format %q, expected %s", tt.argv, format, tt.format)
+		}
+	}
+
+	// stdin to stdout
+	var stdout, stderr bytes.Buffer
+	e := &env{stdin: bytes.NewReader(mustRead(t, jpeg)), stdout: &stdout, stderr: &stderr}
+	if code := run(e, []string{"cwebp", "-quiet", "-", "-o", "-"}); code != 0 {
+		t.Fatalf("stdin/stdout: exit code %d: %s", code, stderr.String())
+	}
+	if !bytes.HasPrefix(stdout.Bytes(), []byte("RIFF")) {
+		t.Error("stdout is not a WebP file")
+	}
+
+	// -short reports on stderr so that it does not corrupt the image on stdout
+	stdout.Reset()
+	stderr.Reset()
+	e = &env{stdin: bytes.NewReader(mustRead(t, jpeg)), stdout: &stdout, stderr: &stderr}
+	if code := run(e, []string{"cwebp", "-short", "-", "-o", "-"}); code != 0 {
+		t.Fatalf("-short: exit code %d: %s", code, stderr.String())
+	}
+	if _, err := libnextimage.WebPDecodeBytes(stdout.Bytes(), libnextimage.DefaultWebPDecodeOptions()); err != nil {
+		t.Errorf("-short: stdout is not a valid WebP file: %v", err)
+	}
+	if fields := strings.Fields(stderr.String()); len(fields) != 2 || fields[0] != strconv.Itoa(stdout.Len()) {
+		t.Errorf("-short: expected \"%d <PSNR>\" on stderr, got %q", stdout.Len(), stderr.String())
+	}
+
+	// Errors
+	for _, argv := range [][]string{
+		{"nextimage"},
+		{"nextimage", "convert"},
+		{"nextimage", "cwebp", "-unknown", jpeg},
+		{"nextimage", "cwebp", "-o", webp},
+		{"nextimage", "avifenc", jpeg},
+		{"nextimage", "avifdec", avif, filepath.Join(dir, "out.bmp")},
+		{"nextimage", "dwebp", filepath.Join(dir, "missing.webp")},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(&env{stdout: &stdout, stderr: &stderr}, argv); code == 0 {
+			t.Errorf("%v: expected a non-zero exit code", argv)
+		}
+	}
+
+	// Option errors are reported in the upstream tool's words
+	for _, tt := range []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"cwebp", "-unknown", jpeg}, "Unknown option '-unknown'"},
+		{[]string{"gif2webp", "-q", "high", gif}, "Error! 'high' is not a floating point number."},
+		{[]string{"avifenc", "--yuv", "411", jpeg, avif}, "ERROR: invalid format: 411"},
+	} {
+		var stdout, stderr bytes.Buffer
+		run(&env{stdout: &stdout, stderr: &stderr}, tt.argv)
+		if !strings.Contains(stderr.String(), tt.want+"\n") {
+			t.Errorf("%v: expected %q in:\n%s", tt.argv, tt.want, stderr.String())
+		}
+	}
+
+	t.Logf("✓ %d commands", len(tests))
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestWatch tests the watch command until it is cancelled
+func TestWatch(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "test.jpg"), mustRead(t, filepath.Join(testdataDir, "jpeg", "test.jpg")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout, stderr syncBuffer
+	code := make(chan int, 1)
+	go func() {
+		code <- run(&env{ctx: ctx, stdout: &stdout, stderr: &stderr},
+			[]string{"nextimage", "watch", "-profile", "thumbnail", "-interval", "20ms", "-debounce", "50ms", src, dst})
+	}()
+	deadline := time.Now().Add(30 * time.Second)
+	for !fileExists(filepath.Join(dst, "test.webp")) || !fileExists(filepath.Join(dst, "test.avif")) {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatalf("outputs not written: %s", stderr.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if c := <-code; c != 0 {
+		t.Fatalf("exit code %d: %s", c, stderr.String())
+	}
+	if format := sniff(mustRead(t, filepath.Join(dst, "test.avif"))); format != "avif" {
+		t.Errorf("output format %q, expected avif", format)
+	}
+
+	for _, argv := range [][]string{
+		{"nextimage", "watch", src},
+		{"nextimage", "watch", "-profile", "unknown", src, dst},
+		{"nextimage", "watch", "-formats", "png", src, dst},
+		{"nextimage", "watch", filepath.Join(src, "missing"), dst},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(&env{stdout: &stdout, stderr: &stderr}, argv); code == 0 {
+			t.Errorf("%v: expected a non-zero exit code", argv)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// sniff returns the image format from the file signature
+func sniff(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && string(data[8:12]) == "avif":
+		return "avif"
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return "gif"
+	}
+	return ""
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return data
+}
+
+// TestServeStdio tests a session of JSON requests and results
+func TestServeStdio(t *testing.T) {
+	dir := t.TempDir()
+	jpeg := filepath.Join(testdataDir, "jpeg", "test.jpg")
+	webp := filepath.Join(dir, "out.webp")
+	requests := []any{
+		map[string]any{"id": 1, "op": "cwebp", "options": map[string]any{"quality": 75}, "input": jpeg, "output": webp},
+		map[string]any{"id": "two", "op": "avifenc", "args": []string{"-s", "10"}, "input_base64": mustRead(t, jpeg)},
+		map[string]any{"id": 3, "op": "dwebp", "input": filepath.Join(dir, "missing.webp")},
+		map[string]any{"id": 4, "op": "convert", "input": jpeg},
+		map[string]any{"id": 5, "op": "cwebp", "options": map[string]any{"quality": "high"}, "input": jpeg},
+		map[string]any{"id": 6, "op": "dwebp", "input_base64": []byte("not a webp")},
+	}
+	var input bytes.Buffer
+	for _, req := range requests {
+		line, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input.Write(line)
+		input.WriteString("\n\n")
+	}
+	input.WriteString("not json\n")
+
+	var stdout, stderr bytes.Buffer
+	if code := run(&env{stdin: &input, stdout: &stdout, stderr: &stderr}, []string{"nextimage", "serve-stdio", "-j", "2"}); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+
+	results := make(map[string]serveResult)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var r serveResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("invalid result line %q: %v", line, err)
+		}
+		results[string(r.ID)] = r
+	}
+	if len(results) != len(requests)+1 {
+		t.Fatalf("expected %d results, got:\n%s", len(requests)+1, stdout.String())
+	}
+
+	if r := results["1"]; !r.OK || r.Output != webp || r.Stats == nil || sniff(mustRead(t, webp)) != "webp" {
+		t.Errorf("cwebp: unexpected result %+v", r)
+	}
+	if r := results[`"two"`]; !r.OK || sniff(r.OutputBase64) != "avif" || r.OutputSize != len(r.OutputBase64) {
+		t.Errorf("avifenc: unexpected result %+v", r)
+	}
+	for id, kind := range map[string]string{"3": "input", "4": "request", "5": "request", "6": "conversion", "": "request"} {
+		if r := results[id]; r.OK || r.Error == nil || r.Error.Type != kind {
+			t.Errorf("%s: expected a %s error, got %+v", id, kind, r)
+		}
+	}
+}
+
+// fakeServe is a serveCommand recording whether it was closed
+type fakeServe struct{ closed *bool }
+
+func (c fakeServe) run(data []byte) ([]byte, any, error) { return data, nil, nil }
+func (c fakeServe) Close() error                         { *c.closed = true; return nil }
+
+// TestCommandCache tests reuse per key and the eviction of the least recently used
+// Command past the cap
+func TestCommandCache(t *testing.T) {
+	cache := commandCache{maxIdle: 2}
+	closed := make([]bool, 3)
+	opened := 0
+	open := func() (serveCommand, error) {
+		opened++
+		return fakeServe{&closed[opened-1]}, nil
+	}
+
+	a, _ := cache.get("a", open)
+	b, _ := cache.get("b", open)
+	cache.put("a", a)
+	cache.put("b", b)
+	if cmd, _ := cache.get("a", open); cmd != a || opened != 2 {
+		t.Fatal("idle command not reused")
+	}
+	c, _ := cache.get("c", open)
+	cache.put("a", a)
+	cache.put("c", c) // Three idle: b is the least recently returned
+	if !closed[1] || closed[0] || closed[2] {
+		t.Errorf("expected only b to be closed, got %v", closed)
+	}
+	cache.close()
+	if !closed[0] || !closed[2] {
+		t.Errorf("idle commands left open after close: %v", closed)
+	}
+}
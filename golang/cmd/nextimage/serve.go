@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+	"github.com/ideamans/libnextimage/golang/internal/cmdline"
+)
+
+// ========================================
+// serve-stdio
+// ========================================
+
+// serveArgs is a parsed serve-stdio command line
+type serveArgs struct {
+	workers int
+	help    bool
+}
+
+var serveFlags = []cmdline.Flag[serveArgs]{
+	{Names: []string{"-j"}, NArgs: 1, Set: func(a *serveArgs, v []string) error {
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("'%s' is not a positive number", v[0])
+		}
+		a.workers = n
+		return nil
+	}},
+	cmdline.Bool(func(a *serveArgs) *bool { return &a.help }, true, "-h", "-help", "--help"),
+}
+
+const serveUsage = `Usage: nextimage serve-stdio [options]
+
+Runs conversions for another process: reads one JSON request per line on stdin and
+writes one JSON result per line on stdout, in the order the conversions finish.
+Exits when stdin is closed, after the conversions in progress are done.
+
+Request:
+  {"id": 1, "op": "cwebp", "options": {"quality": 80}, "input": "in.png", "output": "out.webp"}
+  id              Any JSON value, copied to the result
+  op              cwebp, dwebp, avifenc, avifdec, gif2webp or webp2gif
+  options         Options of the command as in its JSON encoding (default: the defaults)
+  args            Or the upstream tool's flags, e.g. ["-q", "80", "-m", "6"]
+  input           Input file, or
+  input_base64    the input bytes in base64
+  output          Output file; without it the result carries output_base64
+
+Result:
+  {"id": 1, "ok": true, "output": "out.webp", "input_size": 81234, "output_size": 20480,
+   "duration_ms": 35.2, "stats": {...}}
+  {"id": 2, "ok": false, "error": {"type": "input", "message": "..."}}
+  The error type is request, input, limit, conversion or output.
+
+Options:
+  -j <n>   Conversions run at the same time (default: number of CPUs). Up to 2n
+           idle commands are kept for reuse, the least recently used closed first.
+`
+
+// serveRequest is one line read by serve-stdio
+type serveRequest struct {
+	ID          json.RawMessage `json:"id,omitempty"`
+	Op          string          `json:"op"`
+	Options     json.RawMessage `json:"options,omitempty"`
+	Args        []string        `json:"args,omitempty"`
+	Input       string          `json:"input,omitempty"`
+	InputBase64 []byte          `json:"input_base64,omitempty"`
+	Output      string          `json:"output,omitempty"`
+}
+
+// serveResult is one line written by serve-stdio
+type serveResult struct {
+	ID           json.RawMessage `json:"id,omitempty"`
+	OK           bool            `json:"ok"`
+	Output       string          `json:"output,omitempty"`
+	OutputBase64 []byte          `json:"output_base64,omitempty"`
+	InputSize    int             `json:"input_size,omitempty"`
+	OutputSize   int             `json:"output_size,omitempty"`
+	DurationMS   float64         `json:"duration_ms,omitempty"`
+	Stats        any             `json:"stats,omitempty"`
+	Error        *serveError     `json:"error,omitempty"`
+}
+
+// serveError is a failed request. Type tells the caller whose fault it was.
+type serveError struct {
+	Type    string `json:"type"` // request, input, limit, conversion or output
+	Message string `json:"message"`
+}
+
+func requestError(err error) *serveError { return &serveError{"request", err.Error()} }
+
+// serveCommand is a Command with its results in serve-stdio terms
+type serveCommand interface {
+	run(data []byte) (output []byte, stats any, err error)
+	Close() error
+}
+
+// serveOp parses the options of a request into a key naming the Command it needs,
+// and a function creating such a Command
+type serveOp func(req *serveRequest) (key string, open func() (serveCommand, error), err error)
+
+// newServeOp builds a serveOp for a Command whose options come from JSON or from
+// the upstream tool's flags
+func newServeOp[O any](parseArgs func([]string) (O, []string, error), open func(*O) (serveCommand, error)) serveOp {
+	return func(req *serveRequest) (string, func() (serveCommand, error), error) {
+		var opts libnextimage.OptionsConfig[O]
+		switch {
+		case len(req.Args) > 0 && len(req.Options) > 0:
+			return "", nil, fmt.Errorf("options and args cannot be used together")
+		case len(req.Args) > 0:
+			parsed, rest, err := parseArgs(req.Args)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(rest) > 0 {
+				return "", nil, fmt.Errorf("unexpected argument '%s'", rest[0])
+			}
+			opts.Options = parsed
+		default:
+			options := req.Options
+			if len(options) == 0 {
+				options = json.RawMessage("{}")
+			}
+			if err := json.Unmarshal(options, &opts); err != nil {
+				return "", nil, err
+			}
+		}
+		key, err := json.Marshal(opts)
+		if err != nil {
+			return "", nil, err
+		}
+		return req.Op + string(key), func() (serveCommand, error) { return open(&opts.Options) }, nil
+	}
+}
+
+var serveOps = map[string]serveOp{
+	"cwebp": newServeOp(libnextimage.ParseCWebPArgs, func(o *libnextimage.CWebPOptions) (serveCommand, error) {
+		cmd, err := libnextimage.NewCWebPCommand(o)
+		return cwebpServe{cmd}, err
+	}),
+	"dwebp": newServeOp(libnextimage.ParseDWebPArgs, func(o *libnextimage.DWebPOptions) (serveCommand, error) {
+		cmd, err := libnextimage.NewDWebPCommand(o)
+		return runServe{cmd, cmd.Run}, err
+	}),
+	"avifenc": newServeOp(libnextimage.ParseAVIFEncArgs, func(o *libnextimage.AVIFEncOptions) (serveCommand, error) {
+		cmd, err := libnextimage.NewAVIFEncCommand(o)
+		return avifencServe{cmd}, err
+	}),
+	"avifdec": newServeOp(libnextimage.ParseAVIFDecArgs, func(o *libnextimage.AVIFDecOptions) (serveCommand, error) {
+		cmd, err := libnextimage.NewAVIFDecCommand(o)
+		return runServe{cmd, cmd.Run}, err
+	}),
+	"gif2webp": newServeOp(libnextimage.ParseGif2WebPArgs, func(o *libnextimage.Gif2WebPOptions) (serveCommand, error) {
+		cmd, err := libnextimage.NewGif2WebPCommand(o)
+		return runServe{cmd, cmd.Run}, err
+	}),
+	"webp2gif": newServeOp(libnextimage.ParseWebP2GifArgs, func(o *libnextimage.WebP2GifOptions) (serveCommand, error) {
+		cmd, err := libnextimage.NewWebP2GifCommand(o)
+		return runServe{cmd, cmd.Run}, err
+	}),
+}
+
+// runServe is a Command without statistics
+type runServe struct {
+	io.Closer
+	runFn func([]byte) ([]byte, error)
+}
+
+func (c runServe) run(data []byte) ([]byte, any, error) {
+	out, err := c.runFn(data)
+	return out, nil, err
+}
+
+type cwebpServe struct{ *libnextimage.CWebPCommand }
+
+// webpStats are the cwebp statistics reported by serve-stdio
+type webpStats struct {
+	CodedSize     int        `json:"coded_size"`
+	PSNR          [5]float32 `json:"psnr"` // Y, U, V, all and alpha, in dB
+	HeaderBytes   int        `json:"header_bytes"`
+	AlphaDataSize int        `json:"alpha_data_size"`
+	PaletteSize   int        `json:"palette_size,omitempty"`
+}
+
+func (c cwebpServe) run(data []byte) ([]byte, any, error) {
+	result, err := c.RunWithResult(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := result.Stats
+	return result.Data, webpStats{s.CodedSize, s.PSNR, s.HeaderBytes, s.AlphaDataSize, s.PaletteSize}, nil
+}
+
+type avifencServe struct{ *libnextimage.AVIFEncCommand }
+
+// avifStats are the avifenc statistics reported by serve-stdio
+type avifStats struct {
+	EstimatedMinQuantizer      int `json:"estimated_min_quantizer"`
+	EstimatedMaxQuantizer      int `json:"estimated_max_quantizer"`
+	EstimatedMinQuantizerAlpha int `json:"estimated_min_quantizer_alpha"`
+	EstimatedMaxQuantizerAlpha int `json:"estimated_max_quantizer_alpha"`
+	EstimatedTileRowsLog2      int `json:"estimated_tile_rows_log2"`
+	EstimatedTileColsLog2      int `json:"estimated_tile_cols_log2"`
+	GridCols                   int `json:"grid_cols"`
+	GridRows                   int `json:"grid_rows"`
+	LayerCount                 int `json:"layer_count"`
+	ColorOBUSize               int `json:"color_obu_size"`
+	AlphaOBUSize               int `json:"alpha_obu_size"`
+}
+
+func (c avifencServe) run(data []byte) ([]byte, any, error) {
+	result, err := c.RunWithResult(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Data, avifStats(result.Stats), nil
+}
+
+// idleCommand is an idle Command with the key it was created for
+type idleCommand struct {
+	key string
+	cmd serveCommand
+}
+
+// commandCache keeps the idle Commands of each operation and options, as a Command
+// serves one conversion at a time. As options come from the requests, the number of
+// idle Commands is capped; the least recently returned one is closed to make room.
+type commandCache struct {
+	maxIdle int // Idle Commands kept across all keys
+
+	mu   sync.Mutex
+	idle []idleCommand // Least recently returned first
+}
+
+func (c *commandCache) get(key string, open func() (serveCommand, error)) (serveCommand, error) {
+	c.mu.Lock()
+	for i := len(c.idle) - 1; i >= 0; i-- {
+		if c.idle[i].key == key {
+			cmd := c.idle[i].cmd
+			c.idle = append(c.idle[:i], c.idle[i+1:]...)
+			c.mu.Unlock()
+			return cmd, nil
+		}
+	}
+	c.mu.Unlock()
+	return open()
+}
+
+func (c *commandCache) put(key string, cmd serveCommand) {
+	c.mu.Lock()
+	c.idle = append(c.idle, idleCommand{key, cmd})
+	var evicted []serveCommand
+	for len(c.idle) > c.maxIdle {
+		evicted = append(evicted, c.idle[0].cmd)
+		c.idle = c.idle[1:]
+	}
+	c.mu.Unlock()
+	for _, cmd := range evicted {
+		cmd.Close()
+	}
+}
+
+func (c *commandCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ic := range c.idle {
+		ic.cmd.Close()
+	}
+	c.idle = nil
+}
+
+// convert runs one request
+func (c *commandCache) convert(req *serveRequest) *serveResult {
+	result := &serveResult{ID: req.ID}
+	op, ok := serveOps[req.Op]
+	if !ok {
+		result.Error = requestError(fmt.Errorf("unknown op '%s'", req.Op))
+		return result
+	}
+	key, open, err := op(req)
+	if err != nil {
+		result.Error = requestError(err)
+		return result
+	}
+
+	data := req.InputBase64
+	switch {
+	case req.Input != "" && len(data) > 0:
+		result.Error = requestError(fmt.Errorf("input and input_base64 cannot be used together"))
+		return result
+	case req.Input != "":
+		if data, err = os.ReadFile(req.Input); err != nil {
+			result.Error = &serveError{"input", fmt.Sprintf("cannot read input file '%s': %v", req.Input, err)}
+			return result
+		}
+	case len(data) == 0:
+		result.Error = requestError(fmt.Errorf("no input or input_base64"))
+		return result
+	}
+	result.InputSize = len(data)
+
+	start := time.Now()
+	cmd, err := c.get(key, open)
+	if err != nil {
+		result.Error = requestError(err)
+		return result
+	}
+	output, stats, err := cmd.run(data)
+	c.put(key, cmd)
+	result.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		kind := "conversion"
+		if errors.Is(err, libnextimage.ErrLimitExceeded) {
+			kind = "limit"
+		}
+		result.Error = &serveError{kind, err.Error()}
+		return result
+	}
+
+	if req.Output != "" {
+		if err := os.WriteFile(req.Output, output, 0644); err != nil {
+			result.Error = &serveError{"output", fmt.Sprintf("cannot write output file '%s': %v", req.Output, err)}
+			return result
+		}
+		result.Output = req.Output
+	} else {
+		result.OutputBase64 = output
+	}
+	result.OK = true
+	result.OutputSize = len(output)
+	result.Stats = stats
+	return result
+}
+
+func runServeStdio(e *env, args []string) int {
+	a := serveArgs{workers: runtime.NumCPU()}
+	positional, err := cmdline.Parse(&cliSyntax, serveFlags, &a, args)
+	if err == nil && len(positional) > 0 {
+		err = fmt.Errorf("unexpected argument '%s'", positional[0])
+	}
+	if err != nil {
+		fmt.Fprint(e.stderr, serveUsage)
+		return fail(e, "serve-stdio", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, serveUsage)
+		return 0
+	}
+
+	// Lines are read in their own goroutine so that a signal stops taking new requests
+	lines := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(e.stdin)
+		for {
+			line, err := r.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					fmt.Fprintf(e.stderr, "serve-stdio: %v\n", err)
+				}
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex // Serializes the result lines
+	out := json.NewEncoder(e.stdout)
+	write := func(result *serveResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := out.Encode(result); err != nil {
+			fmt.Fprintf(e.stderr, "serve-stdio: %v\n", err)
+		}
+	}
+
+	cache := commandCache{maxIdle: 2 * a.workers}
+	defer cache.close()
+	jobs := make(chan *serveRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < a.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range jobs {
+				write(cache.convert(req))
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(e.context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case line, ok := <-lines:
+			if !ok {
+				break loop
+			}
+			var req serveRequest
+			if err := json.Unmarshal(line, &req); err != nil {
+				write(&serveResult{Error: requestError(fmt.Errorf("invalid JSON: %v", err))})
+				continue
+			}
+			jobs <- &req
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return 0
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+	"github.com/ideamans/libnextimage/golang/batch"
+	"github.com/ideamans/libnextimage/golang/internal/cmdline"
+)
+
+// ========================================
+// watch
+// ========================================
+
+// watchArgs is a parsed watch command line
+type watchArgs struct {
+	opts    batch.WatchOptions
+	profile string
+	formats []string
+	quiet   bool
+	help    bool
+}
+
+var watchFlags = []cmdline.Flag[watchArgs]{
+	{Names: []string{"-profile"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		a.profile = v[0]
+		return nil
+	}},
+	{Names: []string{"-formats"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		a.formats = nil
+		for _, format := range strings.Split(v[0], ",") {
+			switch format = strings.TrimSpace(format); format {
+			case "webp", "avif":
+				a.formats = append(a.formats, format)
+			default:
+				return fmt.Errorf("unknown format '%s'", format)
+			}
+		}
+		return nil
+	}},
+	{Names: []string{"-include"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		a.opts.Include = append(a.opts.Include, v[0])
+		return nil
+	}},
+	{Names: []string{"-exclude"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		a.opts.Exclude = append(a.opts.Exclude, v[0])
+		return nil
+	}},
+	{Names: []string{"-j"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("'%s' is not a positive number", v[0])
+		}
+		a.opts.Workers = n
+		return nil
+	}},
+	{Names: []string{"-interval"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		return durationFlag(&a.opts.Interval, v[0])
+	}},
+	{Names: []string{"-debounce"}, NArgs: 1, Set: func(a *watchArgs, v []string) error {
+		return durationFlag(&a.opts.Debounce, v[0])
+	}},
+	{Names: []string{"-hash"}, Set: func(a *watchArgs, v []string) error {
+		a.opts.Skip = batch.SkipIfUnchanged
+		return nil
+	}},
+	cmdline.Bool(func(a *watchArgs) *bool { return &a.quiet }, true, "-quiet"),
+	cmdline.Bool(func(a *watchArgs) *bool { return &a.help }, true, "-h", "-help", "--help"),
+}
+
+func durationFlag(d *time.Duration, value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil || v <= 0 {
+		return fmt.Errorf("'%s' is not a positive duration (e.g. 500ms, 2s)", value)
+	}
+	*d = v
+	return nil
+}
+
+const watchUsage = `Usage: nextimage watch [options] <source_dir> <output_dir>
+
+Converts the images under source_dir to WebP and AVIF in output_dir, mirroring the
+directory layout, then keeps output_dir in sync until interrupted: new and changed
+images are converted and the outputs of removed images are deleted. On SIGINT or
+SIGTERM, conversions in progress are finished before exiting.
+
+Options:
+  -profile <name>   Encoding profile (default: web-photo)
+  -formats <list>   Outputs to make: webp, avif or webp,avif (default)
+  -include <glob>   Inputs to convert, may be repeated (default: JPEG, PNG, GIF, TIFF, WebP)
+  -exclude <glob>   Inputs to leave out, may be repeated (e.g. '**/raw/**')
+  -j <n>            Files converted at the same time (default: number of CPUs)
+  -interval <dur>   Time between scans of source_dir (default: 1s)
+  -debounce <dur>   Time a file must stay unchanged before it is converted (default: 1s)
+  -hash             Skip inputs whose content is unchanged, instead of comparing times
+  -quiet            Only print errors
+`
+
+func runWatch(e *env, args []string) int {
+	a := watchArgs{profile: libnextimage.ProfileWebPhoto, formats: []string{"webp", "avif"}}
+	positional, err := cmdline.Parse(&cliSyntax, watchFlags, &a, args)
+	if err != nil {
+		fmt.Fprint(e.stderr, watchUsage)
+		return fail(e, "watch", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, watchUsage)
+		fmt.Fprintf(e.stdout, "\nProfiles: %s\n", strings.Join(libnextimage.ProfileNames(), ", "))
+		return 0
+	}
+	if len(positional) != 2 {
+		fmt.Fprint(e.stderr, watchUsage)
+		return fail(e, "watch", fmt.Errorf("expected a source and an output directory"))
+	}
+	if info, err := os.Stat(positional[0]); err != nil || !info.IsDir() {
+		return fail(e, "watch", fmt.Errorf("'%s' is not a directory", positional[0]))
+	}
+	profile, ok := libnextimage.LookupProfile(a.profile)
+	if !ok {
+		return fail(e, "watch", fmt.Errorf("unknown profile '%s' (available: %s)", a.profile, strings.Join(libnextimage.ProfileNames(), ", ")))
+	}
+
+	a.opts.Root, a.opts.OutputDir = positional[0], positional[1]
+	if a.opts.Skip == batch.SkipNone {
+		a.opts.Skip = batch.SkipIfNewer
+	}
+	for _, format := range a.formats {
+		var converter batch.Converter
+		if format == "webp" {
+			converter, err = batch.WebP(profile.WebP)
+		} else {
+			converter, err = batch.AVIF(profile.AVIF)
+		}
+		if err != nil {
+			return fail(e, "watch", err)
+		}
+		a.opts.Outputs = append(a.opts.Outputs, batch.Output{Name: format, Ext: "." + format, Converter: converter})
+	}
+
+	// OnFile and OnError are called from several goroutines
+	var mu sync.Mutex
+	a.opts.OnFile = func(r batch.FileResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Status == batch.StatusFailed:
+			fmt.Fprintf(e.stderr, "watch: %s -> %s: %v\n", r.Input, r.Output, r.Err)
+		case a.quiet || r.Status == batch.StatusSkipped:
+		case r.Status == batch.StatusRemoved:
+			fmt.Fprintf(e.stderr, "Removed %s\n", r.Output)
+		default:
+			fmt.Fprintf(e.stderr, "Converted %s -> %s (%d -> %d bytes, %v)\n",
+				r.Input, r.Output, r.InputSize, r.OutputSize, r.Duration.Round(time.Millisecond))
+		}
+	}
+	a.opts.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(e.stderr, "watch: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(e.context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if !a.quiet {
+		fmt.Fprintf(e.stderr, "Watching %s -> %s (profile %s: %s). Press Ctrl-C to stop.\n",
+			a.opts.Root, a.opts.OutputDir, profile.Name, strings.Join(a.formats, ", "))
+	}
+	if err := batch.Watch(ctx, a.opts); err != nil {
+		return fail(e, "watch", err)
+	}
+	if !a.quiet {
+		fmt.Fprintf(e.stderr, "Stopped.\n")
+	}
+	return 0
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+	"github.com/ideamans/libnextimage/golang/internal/cmdline"
+)
+
+// ========================================
+// cwebp
+// ========================================
+
+// cwebpArgs is a parsed cwebp command line
+type cwebpArgs struct {
+	opts    libnextimage.CWebPOptions
+	output  string
+	quiet   bool
+	short   bool
+	verbose bool
+	help    bool
+	version bool
+}
+
+// webpOutputFlags are the output, help and version flags shared by cwebp and gif2webp
+func webpOutputFlags[T any](output func(*T) *string, help, version func(*T) *bool) []cmdline.Flag[T] {
+	return []cmdline.Flag[T]{
+		{Names: []string{"-o"}, NArgs: 1, Set: func(a *T, v []string) error {
+			*output(a) = v[0]
+			return nil
+		}},
+		cmdline.Bool(help, true, "-h", "-help", "-H", "-longhelp"),
+		cmdline.Bool(version, true, "-version"),
+	}
+}
+
+var cwebpFlags = append(webpOutputFlags(
+	func(a *cwebpArgs) *string { return &a.output },
+	func(a *cwebpArgs) *bool { return &a.help },
+	func(a *cwebpArgs) *bool { return &a.version },
+),
+	cmdline.Bool(func(a *cwebpArgs) *bool { return &a.quiet }, true, "-quiet"),
+	cmdline.Bool(func(a *cwebpArgs) *bool { return &a.short }, true, "-short"),
+	cmdline.Bool(func(a *cwebpArgs) *bool { return &a.verbose }, true, "-v"),
+)
+
+const cwebpUsage = `Usage:
+ cwebp [options] -q quality input.png -o output.webp
+
+Options mirror the upstream cwebp (-q, -alpha_q, -preset, -z, -m, -segments, -size,
+-psnr, -sns, -f, -sharpness, -strong, -nostrong, -sharp_yuv, -partition_limit, -pass,
+-qrange, -crop, -resize, -resize_mode, -mt, -low_memory, -alpha_method, -alpha_filter,
+-exact, -blend_alpha, -noalpha, -lossless, -near_lossless, -hint, -metadata, -af,
+-jpeg_like, -pre, -short, -quiet, -v, -version).
+`
+
+func runCWebP(e *env, args []string) int {
+	opts, rest, err := libnextimage.ParseCWebPArgs(args)
+	if err != nil {
+		return usageError(e, cwebpUsage, err)
+	}
+	a := cwebpArgs{opts: opts}
+	positional, err := cmdline.Parse(&cliSyntax, cwebpFlags, &a, rest)
+	if err != nil {
+		fmt.Fprint(e.stderr, cwebpUsage)
+		return fail(e, "cwebp", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, cwebpUsage)
+		return 0
+	}
+	if a.version {
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+	input, output, err := inputOutput(positional, a.output)
+	if err != nil {
+		fmt.Fprint(e.stderr, cwebpUsage)
+		return fail(e, "cwebp", err)
+	}
+
+	data, err := readInput(e, input)
+	if err != nil {
+		return fail(e, "cwebp", fmt.Errorf("cannot read input file '%s': %w", input, err))
+	}
+	cmd, err := libnextimage.NewCWebPCommand(&a.opts)
+	if err != nil {
+		return fail(e, "cwebp", err)
+	}
+	defer cmd.Close()
+
+	result, err := cmd.RunWithResult(data)
+	if err != nil {
+		return fail(e, "cwebp", err)
+	}
+
+	if output == "" {
+		if !a.quiet {
+			fmt.Fprintf(e.stderr, "No output file specified (no -o flag). Encoding will\nbe performed, but its results discarded.\n\n")
+		}
+	} else if err := writeOutput(e, output, result.Data); err != nil {
+		return fail(e, "cwebp", fmt.Errorf("cannot write output file '%s': %w", output, err))
+	}
+
+	switch {
+	case a.short:
+		fmt.Fprintf(e.stderr, "%7d %2.2f\n", result.Stats.CodedSize, result.Stats.PSNR[3])
+	case !a.quiet:
+		printWebPStats(e.stderr, input, output, result, a.opts.Lossless || a.opts.NearLossless >= 0)
+	}
+	if a.verbose {
+		fmt.Fprintf(e.stderr, "Time to encode picture: %.3fs\n", result.Duration.Seconds())
+	}
+	return 0
+}
+
+// printWebPStats prints the encode summary in the style of cwebp
+func printWebPStats(w io.Writer, input, output string, result *libnextimage.WebPEncodeResult, lossless bool) {
+	if output != "" {
+		fmt.Fprintf(w, "Saving file '%s'\n", output)
+	}
+	fmt.Fprintf(w, "File:      %s\n", input)
+	s := &result.Stats
+	if lossless {
+		fmt.Fprintf(w, "Output:    %d bytes\n", s.CodedSize)
+		fmt.Fprintf(w, "Lossless-ARGB compressed size: %d bytes\n", s.LosslessSize)
+		return
+	}
+	fmt.Fprintf(w, "Output:    %d bytes Y-U-V-All-PSNR %2.2f %2.2f %2.2f   %2.2f dB\n",
+		s.CodedSize, s.PSNR[0], s.PSNR[1], s.PSNR[2], s.PSNR[3])
+	fmt.Fprintf(w, "block count:  intra4:     %6d\n", s.BlockCount[0])
+	fmt.Fprintf(w, "              intra16:    %6d\n", s.BlockCount[1])
+	fmt.Fprintf(w, "              skipped:    %6d\n", s.BlockCount[2])
+	fmt.Fprintf(w, "bytes used:  header:      %6d\n", s.HeaderBytes)
+	fmt.Fprintf(w, "             mode-partition: %6d\n", s.ModePartitionBytes)
+	if s.AlphaDataSize > 0 {
+		fmt.Fprintf(w, "             transparency:   %6d\n", s.AlphaDataSize)
+	}
+}
+
+// ========================================
+// dwebp
+// ========================================
+
+// dwebpArgs is a parsed dwebp command line
+type dwebpArgs struct {
+	opts    libnextimage.DWebPOptions
+	output  string
+	quiet   bool
+	help    bool
+	version bool
+}
+
+var dwebpFlags = []cmdline.Flag[dwebpArgs]{
+	{Names: []string{"-o"}, NArgs: 1, Set: func(a *dwebpArgs, v []string) error {
+		a.output = v[0]
+		return nil
+	}},
+	cmdline.Bool(func(a *dwebpArgs) *bool { return &a.help }, true, "-h", "-help"),
+	cmdline.Bool(func(a *dwebpArgs) *bool { return &a.version }, true, "-version"),
+	cmdline.Bool(func(a *dwebpArgs) *bool { return &a.quiet }, true, "-quiet"),
+	cmdline.Ignored[dwebpArgs](0, "-v"),
+}
+
+const dwebpUsage = `Usage: dwebp in_file [options] [-o out_file]
+
+Decodes the WebP image file to PNG format. Options mirror the upstream dwebp
+(-png, -nofancy, -nofilter, -nodither, -mt, -crop, -resize, -scale, -flip, -quiet, -version).
+`
+
+func runDWebP(e *env, args []string) int {
+	opts, rest, err := libnextimage.ParseDWebPArgs(args)
+	if err != nil {
+		return usageError(e, dwebpUsage, err)
+	}
+	a := dwebpArgs{opts: opts}
+	positional, err := cmdline.Parse(&cliSyntax, dwebpFlags, &a, rest)
+	if err != nil {
+		fmt.Fprint(e.stderr, dwebpUsage)
+		return fail(e, "dwebp", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, dwebpUsage)
+		return 0
+	}
+	if a.version {
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+	input, output, err := inputOutput(positional, a.output)
+	if err != nil {
+		fmt.Fprint(e.stderr, dwebpUsage)
+		return fail(e, "dwebp", err)
+	}
+
+	data, err := readInput(e, input)
+	if err != nil {
+		return fail(e, "dwebp", fmt.Errorf("cannot read input file '%s': %w", input, err))
+	}
+	a.opts.OutputFormat = libnextimage.OutputPNG
+	cmd, err := libnextimage.NewDWebPCommand(&a.opts)
+	if err != nil {
+		return fail(e, "dwebp", err)
+	}
+	defer cmd.Close()
+
+	png, err := cmd.Run(data)
+	if err != nil {
+		return fail(e, "dwebp", fmt.Errorf("decoding of %s failed: %w", input, err))
+	}
+	if output == "" {
+		if !a.quiet {
+			fmt.Fprintf(e.stderr, "Nothing written; use -o flag to save the result.\n")
+		}
+		return 0
+	}
+	if err := writeOutput(e, output, png); err != nil {
+		return fail(e, "dwebp", fmt.Errorf("cannot write output file '%s': %w", output, err))
+	}
+	if !a.quiet && output != "-" {
+		fmt.Fprintf(e.stderr, "Saved file %s\n", output)
+	}
+	return 0
+}
+
+// ========================================
+// gif2webp
+// ========================================
+
+// gif2webpArgs is a parsed gif2webp command line
+type gif2webpArgs struct {
+	opts    libnextimage.Gif2WebPOptions
+	output  string
+	quiet   bool
+	help    bool
+	version bool
+}
+
+var gif2webpFlags = append(webpOutputFlags(
+	func(a *gif2webpArgs) *string { return &a.output },
+	func(a *gif2webpArgs) *bool { return &a.help },
+	func(a *gif2webpArgs) *bool { return &a.version },
+),
+	cmdline.Bool(func(a *gif2webpArgs) *bool { return &a.quiet }, true, "-quiet"),
+	cmdline.Ignored[gif2webpArgs](0, "-v"),
+)
+
+const gif2webpUsage = `Usage:
+ gif2webp [options] gif_file -o webp_file
+
+Options mirror the upstream gif2webp (-lossy, -mixed, -near_lossless, -sharp_yuv, -q, -m,
+-min_size, -kmin, -kmax, -f, -mt, -loop_compatibility, -quiet, -version).
+`
+
+func runGif2WebP(e *env, args []string) int {
+	opts, rest, err := libnextimage.ParseGif2WebPArgs(args)
+	if err != nil {
+		return usageError(e, gif2webpUsage, err)
+	}
+	a := gif2webpArgs{opts: opts}
+	positional, err := cmdline.Parse(&cliSyntax, gif2webpFlags, &a, rest)
+	if err != nil {
+		fmt.Fprint(e.stderr, gif2webpUsage)
+		return fail(e, "gif2webp", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, gif2webpUsage)
+		return 0
+	}
+	if a.version {
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+	input, output, err := inputOutput(positional, a.output)
+	if err != nil {
+		fmt.Fprint(e.stderr, gif2webpUsage)
+		return fail(e, "gif2webp", err)
+	}
+
+	data, err := readInput(e, input)
+	if err != nil {
+		return fail(e, "gif2webp", fmt.Errorf("cannot read input file '%s': %w", input, err))
+	}
+	cmd, err := libnextimage.NewGif2WebPCommand(&a.opts)
+	if err != nil {
+		return fail(e, "gif2webp", err)
+	}
+	defer cmd.Close()
+
+	webp, err := cmd.Run(data)
+	if err != nil {
+		return fail(e, "gif2webp", err)
+	}
+	if output == "" {
+		if !a.quiet {
+			fmt.Fprintf(e.stderr, "Nothing written; use -o flag to save the result.\n")
+		}
+		return 0
+	}
+	if err := writeOutput(e, output, webp); err != nil {
+		return fail(e, "gif2webp", fmt.Errorf("cannot write output file '%s': %w", output, err))
+	}
+	if !a.quiet && output != "-" {
+		fmt.Fprintf(e.stderr, "Saved output file (%d bytes): %s\n", len(webp), output)
+	}
+	return 0
+}
+
+// ========================================
+// webp2gif
+// ========================================
+
+// webp2gifArgs is a parsed webp2gif command line
+type webp2gifArgs struct {
+	opts    libnextimage.WebP2GifOptions
+	output  string
+	quiet   bool
+	help    bool
+	version bool
+}
+
+var webp2gifFlags = []cmdline.Flag[webp2gifArgs]{
+	{Names: []string{"-o"}, NArgs: 1, Set: func(a *webp2gifArgs, v []string) error {
+		a.output = v[0]
+		return nil
+	}},
+	cmdline.Bool(func(a *webp2gifArgs) *bool { return &a.help }, true, "-h", "-help"),
+	cmdline.Bool(func(a *webp2gifArgs) *bool { return &a.version }, true, "-version"),
+	cmdline.Bool(func(a *webp2gifArgs) *bool { return &a.quiet }, true, "-quiet"),
+}
+
+const webp2gifUsage = `Usage:
+ webp2gif [options] webp_file -o gif_file
+
+Converts a (possibly animated) WebP image to GIF. Options: -quiet, -version.
+`
+
+func runWebP2Gif(e *env, args []string) int {
+	opts, rest, err := libnextimage.ParseWebP2GifArgs(args)
+	if err != nil {
+		return usageError(e, webp2gifUsage, err)
+	}
+	a := webp2gifArgs{opts: opts}
+	positional, err := cmdline.Parse(&cliSyntax, webp2gifFlags, &a, rest)
+	if err != nil {
+		fmt.Fprint(e.stderr, webp2gifUsage)
+		return fail(e, "webp2gif", err)
+	}
+	if a.help {
+		fmt.Fprint(e.stdout, webp2gifUsage)
+		return 0
+	}
+	if a.version {
+		fmt.Fprintf(e.stdout, "nextimage %s\n", libnextimage.LibraryVersion)
+		return 0
+	}
+	input, output, err := inputOutput(positional, a.output)
+	if err != nil {
+		fmt.Fprint(e.stderr, webp2gifUsage)
+		return fail(e, "webp2gif", err)
+	}
+
+	data, err := readInput(e, input)
+	if err != nil {
+		return fail(e, "webp2gif", fmt.Errorf("cannot read input file '%s': %w", input, err))
+	}
+	cmd, err := libnextimage.NewWebP2GifCommand(&a.opts)
+	if err != nil {
+		return fail(e, "webp2gif", err)
+	}
+	defer cmd.Close()
+
+	gif, err := cmd.Run(data)
+	if err != nil {
+		return fail(e, "webp2gif", err)
+	}
+	if output == "" {
+		if !a.quiet {
+			fmt.Fprintf(e.stderr, "Nothing written; use -o flag to save the result.\n")
+		}
+		return 0
+	}
+	if err := writeOutput(e, output, gif); err != nil {
+		return fail(e, "webp2gif", fmt.Errorf("cannot write output file '%s': %w", output, err))
+	}
+	if !a.quiet && output != "-" {
+		fmt.Fprintf(e.stderr, "Saved output file (%d bytes): %s\n", len(gif), output)
+	}
+	return 0
+}
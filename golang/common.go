@@ -31,6 +31,7 @@ package libnextimage
 */
 import "C"
 import (
+	"bytes"
 	"fmt"
 	"unsafe"
 )
@@ -111,17 +112,58 @@ func makeError(status C.NextImageStatus, operation string) error {
 			errMsg = "unsupported operation"
 		case C.NEXTIMAGE_ERROR_BUFFER_TOO_SMALL:
 			errMsg = "buffer too small"
+		case C.NEXTIMAGE_ERROR_CROP_OUTSIDE_IMAGE:
+			errMsg = "crop rectangle outside the image"
 		}
 	}
 
 	return fmt.Errorf("%s: %s", operation, errMsg)
 }
 
+// cropError returns the field error for a crop rectangle the C encoder found outside the
+// decoded image, or nil for other statuses. The check runs after decoding, so it covers
+// inputs whose header cannot be probed.
+func cropError(status C.NextImageStatus, options string, crop [4]int) error {
+	if status != C.NEXTIMAGE_ERROR_CROP_OUTSIDE_IMAGE {
+		return nil
+	}
+	allowed := "inside the image"
+	var width, height C.int
+	C.nextimage_last_error_image_size(&width, &height)
+	if width > 0 && height > 0 {
+		allowed = fmt.Sprintf("inside the %dx%d image", width, height)
+	}
+	v := validator{options: options}
+	v.fail("Crop", crop, allowed)
+	return v.err()
+}
+
 // Version returns the library version
 func Version() string {
 	return C.GoString(C.nextimage_version())
 }
 
+// AllocationCounters counts the C allocations made by the library since the process
+// started: encoded and decoded buffers, encoders, decoders and commands. Memory
+// allocated inside the codecs is not counted.
+type AllocationCounters struct {
+	Allocations int64 // Allocations made
+	Frees       int64 // Allocations freed
+	Live        int64 // Allocations not freed yet
+}
+
+// AllocationStats returns the C allocation counters. A Live count that keeps growing
+// points to encoders, decoders or commands that are never closed.
+func AllocationStats() AllocationCounters {
+	var stats C.NextImageAllocationStats
+	C.nextimage_allocation_stats(&stats)
+	return AllocationCounters{
+		Allocations: int64(stats.allocations),
+		Frees:       int64(stats.frees),
+		Live:        int64(stats.allocations - stats.frees),
+	}
+}
+
 // freeEncodeBuffer safely frees an encode buffer
 func freeEncodeBuffer(buf *C.NextImageBuffer) {
 	if buf != nil {
@@ -164,3 +206,49 @@ func convertDecodeBuffer(cbuf *C.NextImageDecodeBuffer) *DecodedImage {
 
 	return img
 }
+
+// isAVIFData reports whether data starts with an ISOBMFF ftyp box listing an AVIF brand
+func isAVIFData(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	boxSize := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if boxSize < 12 || boxSize > len(data) {
+		boxSize = len(data)
+	}
+	ftyp := data[8:boxSize]
+	return bytes.Contains(ftyp, []byte("avif")) || bytes.Contains(ftyp, []byte("avis"))
+}
+
+// DecodeImageBytes decodes an image file (PNG, JPEG, TIFF, WebP or AVIF) to 8-bit RGBA.
+// Non-AVIF inputs are read exactly as the encoders read them, which makes the result
+// suitable as a reference when measuring encoding quality.
+func DecodeImageBytes(imageFileData []byte) (*DecodedImage, error) {
+	clearError()
+
+	if len(imageFileData) == 0 {
+		return nil, fmt.Errorf("image decode: empty input data")
+	}
+	if err := checkLimits(imageFileData); err != nil {
+		return nil, fmt.Errorf("image decode: %w", err)
+	}
+
+	if isAVIFData(imageFileData) {
+		return AVIFDecodeBytes(imageFileData, DefaultAVIFDecodeOptions())
+	}
+
+	var decoded C.NextImageDecodeBuffer
+	status := C.nextimage_image_decode_alloc(
+		(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
+		C.size_t(len(imageFileData)),
+		&decoded,
+	)
+	if status != C.NEXTIMAGE_OK {
+		return nil, makeError(status, "image decode")
+	}
+
+	img := convertDecodeBuffer(&decoded)
+	freeDecodeBuffer(&decoded)
+
+	return img, nil
+}
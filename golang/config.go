@@ -0,0 +1,510 @@
+package libnextimage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Options serialization for config files.
+//
+// OptionsConfig wraps an options struct for JSON and for the Marshaler and
+// (v2-style) Unmarshaler interfaces of gopkg.in/yaml.v2 and v3, without
+// depending on them. The options structs themselves keep Go's default encoding.
+//   - keys are snake_case field names ("target_psnr", "tile_rows_log2"),
+//   - enums are written as names ("preset": "photo", "yuv": "420"),
+//   - every field is written, in declaration order,
+//   - omitted (or null) fields keep their defaults when reading.
+
+// OptionsConfig is the config file form of an options struct such as
+// WebPEncodeOptions or CWebPOptions:
+//
+//	var c libnextimage.OptionsConfig[libnextimage.WebPEncodeOptions]
+//	err := json.Unmarshal(data, &c) // c.Options holds the defaults plus the keys in data
+type OptionsConfig[T any] struct {
+	Options T
+}
+
+// NewOptionsConfig wraps options for writing to a config file
+func NewOptionsConfig[T any](options T) OptionsConfig[T] {
+	return OptionsConfig[T]{Options: options}
+}
+
+// optionEnum names the values of an enum-like field. argEnum implements it.
+type optionEnum interface {
+	name(value int) (string, bool)
+	value(name string) (int, bool)
+}
+
+// configField describes a field whose config form differs from the default
+type configField struct {
+	ptr  any        // Pointer to the field in the options struct
+	key  string     // Config key, "" for the snake_case field name
+	enum optionEnum // Value names, nil for plain values
+}
+
+// configSchema is implemented by the options structs OptionsConfig supports
+type configSchema interface {
+	configDefaults() any         // Default options, of the same type as the receiver
+	configFields() []configField // Keys and enums of the receiver's fields
+}
+
+var avifMirrorAxisNames = argEnum{
+	{"none", int(MirrorAxisNone)},
+	{"vertical", int(MirrorAxisVertical)},
+	{"horizontal", int(MirrorAxisHorizontal)},
+}
+
+var avifToneMappingNames = argEnum{
+	{"none", int(ToneMappingNone)},
+	{"bt2390", int(ToneMappingBT2390)},
+	{"reinhard", int(ToneMappingReinhard)},
+	{"hable", int(ToneMappingHable)},
+}
+
+// metadataEnum writes KeepMetadata as a cwebp -metadata list, e.g. "exif,icc"
+type metadataEnum struct{}
+
+func (metadataEnum) name(value int) (string, bool) {
+	if value < 0 {
+		return "default", true
+	}
+	return metadataArg(value), true
+}
+
+func (metadataEnum) value(name string) (int, bool) {
+	if name == "default" {
+		return -1, true
+	}
+	keep, err := parseMetadataArg(name)
+	return keep, err == nil
+}
+
+// colorEnum writes a background color such as BlendAlphaColor as "0xRRGGBB"
+type colorEnum struct{}
+
+func (colorEnum) name(value int) (string, bool) {
+	return fmt.Sprintf("0x%06x", value), true
+}
+
+func (colorEnum) value(name string) (int, bool) {
+	s, base := name, 0 // "0xRRGGBB" or a number
+	if strings.HasPrefix(name, "#") {
+		s, base = name[1:], 16
+	}
+	color, err := strconv.ParseUint(s, base, 32)
+	return int(color), err == nil
+}
+
+// blendAlphaEnum writes BlendAlpha as a colorEnum, or "none" when disabled
+type blendAlphaEnum struct{}
+
+func (blendAlphaEnum) name(value int) (string, bool) {
+	if uint32(value) == 0xFFFFFFFF {
+		return "none", true
+	}
+	return colorEnum{}.name(value)
+}
+
+func (blendAlphaEnum) value(name string) (int, bool) {
+	if name == "none" {
+		return 0xFFFFFFFF, true
+	}
+	return colorEnum{}.value(name)
+}
+
+// optionKey returns the snake_case config key of a field name
+func optionKey(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// configStruct is an options struct with the config form of its fields
+type configStruct struct {
+	value    reflect.Value      // The options struct
+	defaults any                // Default options
+	keys     []string           // Config key per field, "" for unexported fields
+	enums    map[int]optionEnum // Enum names per field index
+}
+
+// newConfigStruct looks up the config form of the options *o points to
+func newConfigStruct(o any) (*configStruct, error) {
+	schema, ok := o.(configSchema)
+	if !ok {
+		return nil, fmt.Errorf("%T: not an options struct", o)
+	}
+	ov := reflect.ValueOf(o).Elem()
+	c := &configStruct{
+		value:    ov,
+		defaults: schema.configDefaults(),
+		keys:     make([]string, ov.NumField()),
+		enums:    make(map[int]optionEnum),
+	}
+	for i := range c.keys {
+		if field := ov.Type().Field(i); field.IsExported() {
+			c.keys[i] = optionKey(field.Name)
+		}
+	}
+	for _, f := range schema.configFields() {
+		for i := range c.keys {
+			if ov.Field(i).Addr().Interface() != f.ptr {
+				continue
+			}
+			if f.key != "" {
+				c.keys[i] = f.key
+			}
+			if f.enum != nil {
+				c.enums[i] = f.enum
+			}
+			break
+		}
+	}
+	return c, nil
+}
+
+// optionEntry is one field written to a config
+type optionEntry struct {
+	key   string
+	value any
+}
+
+// entries returns every exported field in declaration order, with enum fields as names
+func (c *configStruct) entries() []optionEntry {
+	var entries []optionEntry
+	for i, key := range c.keys {
+		if key == "" {
+			continue
+		}
+		fv := c.value.Field(i)
+		value := fv.Interface()
+		if enum, ok := c.enums[i]; ok {
+			if n, isInt := intValue(fv); isInt {
+				if name, ok := enum.name(n); ok {
+					value = name
+				}
+			}
+		}
+		entries = append(entries, optionEntry{key, value})
+	}
+	return entries
+}
+
+func intValue(v reflect.Value) (int, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint()), true
+	}
+	return 0, false
+}
+
+func setIntValue(v reflect.Value, n int) {
+	if v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64 {
+		v.SetUint(uint64(n))
+	} else {
+		v.SetInt(int64(n))
+	}
+}
+
+// marshalOptions writes every field of the options *o points to as a JSON object
+func marshalOptions(o any) ([]byte, error) {
+	c, err := newConfigStruct(o)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, entry := range c.entries() {
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", c.value.Type().Name(), entry.key, err)
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%q:%s", entry.key, value)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+// unmarshalOptions sets *o to the defaults and applies the fields of a JSON object
+func unmarshalOptions(data []byte, o any) error {
+	c, err := newConfigStruct(o)
+	if err != nil {
+		return err
+	}
+	typeName := c.value.Type().Name()
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", typeName, err)
+	}
+	fields := make(map[string]int, len(c.keys))
+	for i, key := range c.keys {
+		if key != "" {
+			fields[key] = i
+		}
+	}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c.value.Set(reflect.ValueOf(c.defaults))
+	for _, key := range keys {
+		i, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown field %q", typeName, key)
+		}
+		msg := raw[key]
+		if string(msg) == "null" {
+			continue
+		}
+		fv := c.value.Field(i)
+		if enum, ok := c.enums[i]; ok && fv.Kind() != reflect.String {
+			// A name, or a number that is also a name such as "yuv": 420
+			var name string
+			if json.Unmarshal(msg, &name) != nil {
+				name = string(msg)
+			}
+			if n, ok := enum.value(name); ok {
+				setIntValue(fv, n)
+				continue
+			}
+			if len(msg) > 0 && msg[0] == '"' {
+				return fmt.Errorf("%s: invalid %s %s", typeName, key, msg)
+			}
+		}
+		if err := json.Unmarshal(msg, fv.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: invalid %s %s", typeName, key, msg)
+		}
+	}
+	return nil
+}
+
+// optionsYAML returns every field of the options *o points to for a YAML encoder, as a
+// struct whose yaml tags are the keys so that yaml.v2 and v3 keep the declaration order.
+// Byte slices are written as base64 strings, as in JSON.
+func optionsYAML(o any) (any, error) {
+	c, err := newConfigStruct(o)
+	if err != nil {
+		return nil, err
+	}
+	entries := c.entries()
+	fields := make([]reflect.StructField, len(entries))
+	for i, entry := range entries {
+		if data, ok := entry.value.([]byte); ok {
+			entries[i].value = base64.StdEncoding.EncodeToString(data)
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: reflect.TypeOf(entries[i].value),
+			Tag:  reflect.StructTag(fmt.Sprintf(`yaml:%q json:%q`, entry.key, entry.key)),
+		}
+	}
+	v := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, entry := range entries {
+		v.Field(i).Set(reflect.ValueOf(entry.value))
+	}
+	return v.Interface(), nil
+}
+
+// unmarshalOptionsYAML decodes a YAML mapping and applies it like unmarshalOptions
+func unmarshalOptionsYAML(unmarshal func(any) error, o any) error {
+	var m map[string]any
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	data, err := json.Marshal(jsonValue(m))
+	if err != nil {
+		return fmt.Errorf("%s: %w", reflect.TypeOf(o).Elem().Name(), err)
+	}
+	return unmarshalOptions(data, o)
+}
+
+// jsonValue converts the map[any]any values of yaml.v2 to map[string]any
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case map[string]any:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	}
+	return v
+}
+
+// ========================================
+// JSON / YAML methods
+// ========================================
+
+func (c OptionsConfig[T]) MarshalJSON() ([]byte, error) {
+	return marshalOptions(&c.Options)
+}
+
+func (c *OptionsConfig[T]) UnmarshalJSON(data []byte) error {
+	return unmarshalOptions(data, &c.Options)
+}
+
+func (c OptionsConfig[T]) MarshalYAML() (any, error) {
+	return optionsYAML(&c.Options)
+}
+
+func (c *OptionsConfig[T]) UnmarshalYAML(unmarshal func(any) error) error {
+	return unmarshalOptionsYAML(unmarshal, &c.Options)
+}
+
+// ========================================
+// Config schemas
+// ========================================
+
+var webpPresetConfigNames = append(argEnum{{"none", -1}}, webpPresetNames...)
+var webpHintConfigNames = append(argEnum{{"default", int(HintDefault)}}, webpHintNames...)
+var webpFilterTypeNames = argEnum{{"simple", int(FilterTypeSimple)}, {"strong", int(FilterTypeStrong)}}
+var pixelFormatNames = argEnum{{"rgba", int(FormatRGBA)}, {"rgb", int(FormatRGB)}, {"bgra", int(FormatBGRA)}}
+var outputFormatNames = argEnum{{"png", int(OutputPNG)}, {"jpeg", int(OutputJPEG)}}
+var avifYUVFormatConfigNames = append(argEnum{{"auto", int(YUVFormatAuto)}}, avifYUVFormatNames...)
+var avifGainMapModeNames = argEnum{{"none", int(GainMapNone)}, {"pair", int(GainMapFromPair)}, {"hdr", int(GainMapFromHDR)}}
+var avifGainMapOutputNames = argEnum{{"base", int(GainMapOutputBase)}, {"image", int(GainMapOutputImage)}, {"rendition", int(GainMapOutputRendition)}}
+
+func (*WebPEncodeOptions) configDefaults() any { return DefaultWebPEncodeOptions() }
+
+func (o *WebPEncodeOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.Preset, enum: webpPresetConfigNames},
+		{ptr: &o.ImageHint, enum: webpHintConfigNames},
+		{ptr: &o.FilterType, enum: webpFilterTypeNames},
+		{ptr: &o.AlphaFiltering, enum: webpAlphaFilterNames},
+		{ptr: &o.QMin, key: "qmin"},
+		{ptr: &o.QMax, key: "qmax"},
+		{ptr: &o.KeepMetadata, enum: metadataEnum{}},
+		{ptr: &o.ResizeMode, enum: webpResizeModeNames},
+		{ptr: &o.BlendAlpha, enum: blendAlphaEnum{}},
+	}
+}
+
+func (*WebPDecodeOptions) configDefaults() any { return DefaultWebPDecodeOptions() }
+
+func (o *WebPDecodeOptions) configFields() []configField {
+	return []configField{{ptr: &o.Format, enum: pixelFormatNames}}
+}
+
+func (*AVIFEncodeOptions) configDefaults() any { return DefaultAVIFEncodeOptions() }
+
+func (o *AVIFEncodeOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.YUVFormat, key: "yuv", enum: avifYUVFormatConfigNames},
+		{ptr: &o.YUVRange, enum: avifYUVRangeNames},
+		{ptr: &o.IRotAngle, key: "irot_angle"},
+		{ptr: &o.IMirAxis, key: "imir_axis", enum: avifMirrorAxisNames},
+		{ptr: &o.GainMapMode, enum: avifGainMapModeNames},
+		{ptr: &o.GainMapToneMapping, enum: avifToneMappingNames},
+	}
+}
+
+func (*AVIFDecodeOptions) configDefaults() any { return DefaultAVIFDecodeOptions() }
+
+func (o *AVIFDecodeOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.Format, enum: pixelFormatNames},
+		{ptr: &o.ChromaUpsampling, enum: avifChromaUpsamplingNames},
+		{ptr: &o.ToneMapping, enum: avifToneMappingNames},
+		{ptr: &o.GainMapOutput, enum: avifGainMapOutputNames},
+	}
+}
+
+func (*CWebPOptions) configDefaults() any { return NewDefaultCWebPOptions() }
+
+func (o *CWebPOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.Preset, enum: webpPresetConfigNames},
+		{ptr: &o.ImageHint, enum: webpHintConfigNames},
+		{ptr: &o.FilterType, enum: webpFilterTypeNames},
+		{ptr: &o.AlphaFiltering, enum: webpAlphaFilterNames},
+		{ptr: &o.QMin, key: "qmin"},
+		{ptr: &o.QMax, key: "qmax"},
+		{ptr: &o.KeepMetadata, enum: metadataEnum{}},
+		{ptr: &o.ResizeMode, enum: webpResizeModeNames},
+		{ptr: &o.BlendAlphaColor, enum: colorEnum{}},
+	}
+}
+
+func (*DWebPOptions) configDefaults() any { return NewDefaultDWebPOptions() }
+
+func (o *DWebPOptions) configFields() []configField {
+	return []configField{{ptr: &o.OutputFormat, enum: outputFormatNames}}
+}
+
+func (*Gif2WebPOptions) configDefaults() any { return NewDefaultGif2WebPOptions() }
+
+func (o *Gif2WebPOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.FilterType, enum: webpFilterTypeNames},
+		{ptr: &o.AlphaFiltering, enum: webpAlphaFilterNames},
+	}
+}
+
+func (*WebP2GifOptions) configDefaults() any { return NewDefaultWebP2GifOptions() }
+
+func (*WebP2GifOptions) configFields() []configField { return nil }
+
+func (*AVIFEncOptions) configDefaults() any { return NewDefaultAVIFEncOptions() }
+
+func (o *AVIFEncOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.YUVFormat, key: "yuv", enum: avifYUVFormatConfigNames},
+		{ptr: &o.YUVRange, enum: avifYUVRangeNames},
+		{ptr: &o.ImirAxis, enum: avifMirrorAxisNames},
+		{ptr: &o.GainMapMode, enum: avifGainMapModeNames},
+		{ptr: &o.GainMapToneMapping, enum: avifToneMappingNames},
+	}
+}
+
+func (*AVIFDecOptions) configDefaults() any { return NewDefaultAVIFDecOptions() }
+
+func (o *AVIFDecOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.OutputFormat, enum: outputFormatNames},
+		{ptr: &o.ChromaUpsampling, enum: avifChromaUpsamplingNames},
+		{ptr: &o.ToneMapping, enum: avifToneMappingNames},
+		{ptr: &o.GainMapOutput, enum: avifGainMapOutputNames},
+	}
+}
+
+func (*GetDistoOptions) configDefaults() any { return NewDefaultGetDistoOptions() }
+
+func (o *GetDistoOptions) configFields() []configField {
+	return []configField{
+		{ptr: &o.Metric, enum: argEnum{{"psnr", int(DistortionPSNR)}, {"ssim", int(DistortionSSIM)}, {"lsim", int(DistortionLSIM)}}},
+	}
+}
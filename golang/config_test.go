@@ -0,0 +1,137 @@
+package libnextimage
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestOptionsJSON tests enum names, sentinel handling and defaults for omitted fields
+func TestOptionsJSON(t *testing.T) {
+	opts := DefaultWebPEncodeOptions()
+	opts.Quality = 82.5
+	opts.Preset = PresetPhoto
+	opts.TargetPSNR = 42
+	opts.KeepMetadata = MetadataEXIF | MetadataICC
+	opts.BlendAlpha = 0xffffff
+	data, err := json.Marshal(NewOptionsConfig(opts))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, want := range []string{`{"quality":82.5,"lossless":false,"method":4,"preset":"photo",`, `"target_psnr":42,`, `"keep_metadata":"exif,icc",`, `"blend_alpha":"0xffffff"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("got %s, expected it to contain %s", data, want)
+		}
+	}
+	var again OptionsConfig[WebPEncodeOptions]
+	if err := json.Unmarshal(data, &again); err != nil || !reflect.DeepEqual(again.Options, opts) {
+		t.Errorf("round trip mismatch (%v)", err)
+	}
+
+	// Every field is written, including those at their defaults
+	data, _ = json.Marshal(NewOptionsConfig(DefaultWebPEncodeOptions()))
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) != reflect.TypeFor[WebPEncodeOptions]().NumField() {
+		t.Errorf("default options produced %s (%v)", data, err)
+	}
+
+	// The options structs keep Go's default encoding
+	if data, _ := json.Marshal(opts); !strings.Contains(string(data), `"TargetPSNR":42`) {
+		t.Errorf("options struct encoded as %s", data)
+	}
+
+	// Omitted fields keep their defaults; enums accept names or numbers
+	var avifConfig OptionsConfig[AVIFEncodeOptions]
+	if err := json.Unmarshal([]byte(`{"quality":70,"yuv":"420","yuv_range":"limited","imir_axis":"horizontal","crop":[0,0,32,32],"codec_options":{"tune":"ssim"}}`), &avifConfig); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	avif := avifConfig.Options
+	expected := DefaultAVIFEncodeOptions()
+	expected.Quality = 70
+	expected.YUVFormat = YUVFormat420
+	expected.YUVRange = YUVRangeLimited
+	expected.IMirAxis = MirrorAxisHorizontal
+	expected.Crop = [4]int{0, 0, 32, 32}
+	expected.CodecOptions = map[string]string{"tune": "ssim"}
+	if !reflect.DeepEqual(avif, expected) {
+		t.Errorf("unexpected options %+v", avif)
+	}
+	err = json.Unmarshal([]byte(`{"yuv":422,"gain_map_tone_mapping":3,"crop":null}`), &avifConfig)
+	avif = avifConfig.Options
+	if err != nil ||
+		avif.YUVFormat != YUVFormat422 || avif.GainMapToneMapping != ToneMappingHable || avif.Quality != 60 || avif.Crop[0] != -1 {
+		t.Errorf("unexpected options %+v (%v)", avif, err)
+	}
+
+	// Command options use the same keys
+	var dec OptionsConfig[AVIFDecOptions]
+	if err := json.Unmarshal([]byte(`{"output_format":"jpeg","chroma_upsampling":"bilinear","format":"RGB"}`), &dec); err != nil ||
+		dec.Options.OutputFormat != OutputJPEG || dec.Options.ChromaUpsampling != int(ChromaUpsamplingBilinear) || dec.Options.Format != "RGB" {
+		t.Errorf("unexpected options %+v (%v)", dec, err)
+	}
+
+	t.Logf("✓ JSON options")
+}
+
+// TestOptionsJSON_Errors tests rejection of unknown keys and invalid values
+func TestOptionsJSON_Errors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{`{"qualty":80}`, `WebPEncodeOptions: unknown field "qualty"`},
+		{`{"preset":"portrait"}`, `WebPEncodeOptions: invalid preset "portrait"`},
+		{`{"method":"fast"}`, `WebPEncodeOptions: invalid method "fast"`},
+		{`{"keep_metadata":"exif,gps"}`, `WebPEncodeOptions: invalid keep_metadata "exif,gps"`},
+		{`[1,2]`, `WebPEncodeOptions: json: cannot unmarshal array`},
+	}
+	for _, tt := range tests {
+		var opts OptionsConfig[WebPEncodeOptions]
+		err := json.Unmarshal([]byte(tt.data), &opts)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, expected %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+// TestOptionsYAML tests the YAML interfaces with the values a YAML decoder produces
+func TestOptionsYAML(t *testing.T) {
+	opts := NewDefaultAVIFEncOptions()
+	opts.YUVFormat = 2
+	opts.Speed = 8
+	opts.EXIFData = []byte("Exif\x00\x00")
+	value, err := NewOptionsConfig(opts).MarshalYAML()
+	if err != nil {
+		t.Fatalf("MarshalYAML failed: %v", err)
+	}
+	// The keys are yaml tags of a struct, in declaration order
+	v := reflect.ValueOf(value)
+	var keys []string
+	m := make(map[string]any)
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("yaml")
+		keys = append(keys, key)
+		m[key] = v.Field(i).Interface()
+	}
+	if m["speed"] != 8 || m["yuv"] != "420" || m["exif_data"] != "RXhpZgAA" || m["quality"] != opts.Quality {
+		t.Errorf("unexpected mapping %v", m)
+	}
+	if len(keys) != reflect.TypeFor[AVIFEncOptions]().NumField() || keys[0] != optionKey(reflect.TypeFor[AVIFEncOptions]().Field(0).Name) {
+		t.Errorf("expected every field in declaration order, got %q", keys)
+	}
+
+	// yaml.v2 decodes "yuv: 420" as an integer and nested mappings as map[any]any
+	decoded := map[string]any{"speed": 8, "yuv": 420, "exif_data": "RXhpZgAA", "codec_options": map[any]any{"tune": "ssim"}}
+	var again OptionsConfig[AVIFEncOptions]
+	err = again.UnmarshalYAML(func(v any) error {
+		*v.(*map[string]any) = decoded
+		return nil
+	})
+	opts.CodecOptions = map[string]string{"tune": "ssim"}
+	if err != nil || !reflect.DeepEqual(again.Options, opts) {
+		t.Errorf("round trip mismatch %+v (%v)", again.Options, err)
+	}
+
+	t.Logf("✓ YAML options")
+}
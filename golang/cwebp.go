@@ -39,6 +39,7 @@ package libnextimage
 #include <string.h>
 #include "nextimage.h"
 #include "nextimage/cwebp.h"
+#include "webp.h"
 */
 import "C"
 import (
@@ -46,6 +47,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"time"
 	"unsafe"
 )
 
@@ -86,18 +88,38 @@ type CWebPOptions struct {
 
 	// Metadata settings
 	KeepMetadata int // Bitwise OR of MetadataEXIF, MetadataICC, MetadataXMP (e.g., MetadataEXIF | MetadataXMP)
+
+	// Image transformation (-crop, -resize, -resize_mode).
+	// The zero values disable crop and resize, as does -1 (the defaults).
+	CropX        int            // crop rectangle x, -1=disabled (default)
+	CropY        int            // crop rectangle y
+	CropWidth    int            // crop rectangle width, 0 with CropHeight 0 = disabled
+	CropHeight   int            // crop rectangle height
+	ResizeWidth  int            // resize width, -1=disabled (default), 0 with ResizeHeight 0 = disabled
+	ResizeHeight int            // resize height
+	ResizeMode   WebPResizeMode // ResizeModeAlways (default), ResizeModeUpOnly or ResizeModeDownOnly
+
+	// Alpha handling (-blend_alpha, -noalpha)
+	BlendAlpha      bool   // blend alpha against BlendAlphaColor
+	BlendAlphaColor uint32 // background color 0xRRGGBB used by BlendAlpha
+	NoAlpha         bool   // discard alpha channel
 }
 
 // Command represents a cwebp command instance that can be reused for multiple conversions.
+// It runs on one C WebP encoder, so like a WebPEncoder it must not be used by several
+// goroutines at once.
 type CWebPCommand struct {
-	cmd *C.CWebPCommand
+	cmd    *C.CWebPCommand
+	crop   [4]int // CropX, CropY, CropWidth, CropHeight, checked against each input
+	resize [2]int // ResizeWidth, ResizeHeight, checked against the limits at each run
 }
 
 // NewDefaultOptions creates default WebP encoding options.
 func NewDefaultCWebPOptions() CWebPOptions {
 	cOpts := C.cwebp_create_default_options()
 	if cOpts == nil {
-		return CWebPOptions{Quality: 75, Method: 4, Preset: -1, LosslessPreset: -1, QMax: 100} // fallback defaults
+		return CWebPOptions{Quality: 75, Method: 4, Segments: 4, Pass: 1, Preset: -1, LosslessPreset: -1, QMax: 100,
+			CropX: -1, CropY: -1, ResizeWidth: -1, ResizeHeight: -1} // fallback defaults
 	}
 	defer C.cwebp_free_options(cOpts)
 
@@ -134,6 +156,16 @@ func NewDefaultCWebPOptions() CWebPOptions {
 		QMin:             int(cOpts.qmin),
 		QMax:             int(cOpts.qmax),
 		KeepMetadata:     int(cOpts.keep_metadata),
+		CropX:            int(cOpts.crop_x),
+		CropY:            int(cOpts.crop_y),
+		CropWidth:        int(cOpts.crop_width),
+		CropHeight:       int(cOpts.crop_height),
+		ResizeWidth:      int(cOpts.resize_width),
+		ResizeHeight:     int(cOpts.resize_height),
+		ResizeMode:       WebPResizeMode(cOpts.resize_mode),
+		BlendAlpha:       cOpts.blend_alpha != C.uint32_t(0xFFFFFFFF),
+		BlendAlphaColor:  uint32(cOpts.blend_alpha) & 0xFFFFFF,
+		NoAlpha:          cOpts.noalpha != 0,
 	}
 }
 
@@ -211,6 +243,29 @@ func cwebpOptionsToCOptions(opts CWebPOptions) *C.CWebPOptions {
 	// Metadata settings
 	cOpts.keep_metadata = C.int(opts.KeepMetadata)
 
+	// Image transformation, left at the C defaults (disabled) unless enabled
+	if cropEnabled(opts.CropX, opts.CropY, opts.CropWidth, opts.CropHeight) {
+		cOpts.crop_x = C.int(opts.CropX)
+		cOpts.crop_y = C.int(opts.CropY)
+		cOpts.crop_width = C.int(opts.CropWidth)
+		cOpts.crop_height = C.int(opts.CropHeight)
+	}
+	if resizeEnabled(opts.ResizeWidth, opts.ResizeHeight) {
+		cOpts.resize_width = C.int(opts.ResizeWidth)
+		cOpts.resize_height = C.int(opts.ResizeHeight)
+	}
+	cOpts.resize_mode = C.int(opts.ResizeMode)
+
+	// Alpha handling
+	if opts.BlendAlpha {
+		cOpts.blend_alpha = C.uint32_t(opts.BlendAlphaColor & 0xFFFFFF)
+	}
+	if opts.NoAlpha {
+		cOpts.noalpha = 1
+	} else {
+		cOpts.noalpha = 0
+	}
+
 	return cOpts
 }
 
@@ -219,6 +274,9 @@ func cwebpOptionsToCOptions(opts CWebPOptions) *C.CWebPOptions {
 func NewCWebPCommand(opts *CWebPOptions) (*CWebPCommand, error) {
 	var cOpts *C.CWebPOptions
 	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to create cwebp command: %w", err)
+		}
 		cOpts = cwebpOptionsToCOptions(*opts)
 	} else {
 		cOpts = nil
@@ -239,12 +297,26 @@ func NewCWebPCommand(opts *CWebPOptions) (*CWebPCommand, error) {
 	}
 
 	cmd := &CWebPCommand{cmd: cCmd}
+	if opts != nil && cropEnabled(opts.CropX, opts.CropY, opts.CropWidth, opts.CropHeight) {
+		cmd.crop = [4]int{opts.CropX, opts.CropY, opts.CropWidth, opts.CropHeight}
+	}
+	if opts != nil {
+		cmd.resize = [2]int{opts.ResizeWidth, opts.ResizeHeight}
+	}
 	runtime.SetFinalizer(cmd, func(c *CWebPCommand) {
 		_ = c.Close()
 	})
 	return cmd, nil
 }
 
+// checkLimits checks imageData and the resize target against the current limits
+func (c *CWebPCommand) checkLimits(imageData []byte) error {
+	if err := checkLimits(imageData); err != nil {
+		return err
+	}
+	return checkResize(c.resize[0], c.resize[1])
+}
+
 // Run converts image data (JPEG/PNG) to WebP format.
 // This is the core method that operates on byte slices.
 func (c *CWebPCommand) Run(imageData []byte) ([]byte, error) {
@@ -255,6 +327,9 @@ func (c *CWebPCommand) Run(imageData []byte) ([]byte, error) {
 	if len(imageData) == 0 {
 		return nil, fmt.Errorf("empty input data")
 	}
+	if err := c.checkLimits(imageData); err != nil {
+		return nil, err
+	}
 
 	var output C.NextImageBuffer
 	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
@@ -267,6 +342,9 @@ func (c *CWebPCommand) Run(imageData []byte) ([]byte, error) {
 	)
 
 	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "CWebPOptions", c.crop); err != nil {
+			return nil, err
+		}
 		errMsg := C.nextimage_last_error_message()
 		if errMsg != nil {
 			return nil, fmt.Errorf("cwebp encoding failed: %s", C.GoString(errMsg))
@@ -283,6 +361,55 @@ func (c *CWebPCommand) Run(imageData []byte) ([]byte, error) {
 	return result, nil
 }
 
+// RunWithResult converts image data to WebP like Run and also reports
+// libwebp's encoder statistics and the encode time.
+func (c *CWebPCommand) RunWithResult(imageData []byte) (*WebPEncodeResult, error) {
+	if c.cmd == nil {
+		return nil, fmt.Errorf("command is closed")
+	}
+
+	if len(imageData) == 0 {
+		return nil, fmt.Errorf("empty input data")
+	}
+	if err := c.checkLimits(imageData); err != nil {
+		return nil, err
+	}
+
+	var output C.NextImageBuffer
+	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
+	var stats C.NextImageWebPEncodeStats
+
+	start := time.Now()
+	status := C.cwebp_run_command_with_stats(
+		c.cmd,
+		(*C.uint8_t)(unsafe.Pointer(&imageData[0])),
+		C.size_t(len(imageData)),
+		&output,
+		&stats,
+	)
+	duration := time.Since(start)
+
+	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "CWebPOptions", c.crop); err != nil {
+			return nil, err
+		}
+		errMsg := C.nextimage_last_error_message()
+		if errMsg != nil {
+			return nil, fmt.Errorf("cwebp encoding failed: %s", C.GoString(errMsg))
+		}
+		return nil, fmt.Errorf("cwebp encoding failed with status %d", int(status))
+	}
+
+	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	C.nextimage_free_buffer(&output)
+
+	return &WebPEncodeResult{
+		Data:     result,
+		Stats:    convertWebPEncodeStats(&stats),
+		Duration: duration,
+	}, nil
+}
+
 // RunFile converts an image file to WebP format and saves it to outputPath.
 // This is a convenience method for file-based operations.
 func (c *CWebPCommand) RunFile(inputPath, outputPath string) error {
@@ -307,28 +434,60 @@ func (c *CWebPCommand) RunFile(inputPath, outputPath string) error {
 	return nil
 }
 
-// RunIO converts image data from a reader to WebP format and writes to a writer.
-// This is a convenience method for stream-based operations.
-func (c *CWebPCommand) RunIO(input io.Reader, output io.Writer) error {
-	// Read all input
-	inputData, err := io.ReadAll(input)
-	if err != nil {
-		return fmt.Errorf("failed to read input: %w", err)
+// RunToWriter converts image data to WebP like Run but writes the output to w as
+// libwebp produces it, without holding the whole WebP in memory. It returns the
+// number of bytes written. On error part of the output may already be written.
+func (c *CWebPCommand) RunToWriter(imageData []byte, w io.Writer) (int64, error) {
+	if c.cmd == nil {
+		return 0, fmt.Errorf("command is closed")
 	}
 
-	// Convert
-	webpData, err := c.Run(inputData)
+	if len(imageData) == 0 {
+		return 0, fmt.Errorf("empty input data")
+	}
+	if err := c.checkLimits(imageData); err != nil {
+		return 0, err
+	}
+
+	status, n, err := streamTo(w, func(writer *C.NextImageWriter) C.NextImageStatus {
+		return C.cwebp_run_command_to_writer(
+			c.cmd,
+			(*C.uint8_t)(unsafe.Pointer(&imageData[0])),
+			C.size_t(len(imageData)),
+			writer,
+			nil,
+		)
+	})
 	if err != nil {
-		return err
+		return n, fmt.Errorf("failed to write output: %w", err)
+	}
+	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "CWebPOptions", c.crop); err != nil {
+			return n, err
+		}
+		errMsg := C.nextimage_last_error_message()
+		if errMsg != nil {
+			return n, fmt.Errorf("cwebp encoding failed: %s", C.GoString(errMsg))
+		}
+		return n, fmt.Errorf("cwebp encoding failed with status %d", int(status))
 	}
 
-	// Write output
-	_, err = output.Write(webpData)
+	return n, nil
+}
+
+// RunIO converts image data from a reader to WebP format and writes to a writer.
+// The input is read up to Limits.MaxInputBytes and the output is streamed with
+// RunToWriter.
+func (c *CWebPCommand) RunIO(input io.Reader, output io.Writer) error {
+	// Read all input
+	inputData, err := readAllLimited(input)
 	if err != nil {
-		return fmt.Errorf("failed to write output: %w", err)
+		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	return nil
+	// Convert and write
+	_, err = c.RunToWriter(inputData, output)
+	return err
 }
 
 // Close releases the command resources.
@@ -0,0 +1,174 @@
+package libnextimage
+
+/*
+#include "nextimage.h"
+#include "webp.h"
+*/
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// DistortionMetric selects the libwebp distortion metric (get_disto -psnr/-ssim/-lsim)
+type DistortionMetric int
+
+const (
+	DistortionPSNR DistortionMetric = C.NEXTIMAGE_DISTORTION_PSNR // Peak signal-to-noise ratio (default)
+	DistortionSSIM DistortionMetric = C.NEXTIMAGE_DISTORTION_SSIM // Structural similarity, reported in dB
+	DistortionLSIM DistortionMetric = C.NEXTIMAGE_DISTORTION_LSIM // Local similarity, reported in dB
+)
+
+// String returns the metric name as used by get_disto
+func (m DistortionMetric) String() string {
+	switch m {
+	case DistortionPSNR:
+		return "psnr"
+	case DistortionSSIM:
+		return "ssim"
+	case DistortionLSIM:
+		return "lsim"
+	default:
+		return fmt.Sprintf("DistortionMetric(%d)", int(m))
+	}
+}
+
+// DistortionOptions controls how two images are compared
+type DistortionOptions struct {
+	Metric DistortionMetric // Metric to compute (default: DistortionPSNR)
+
+	// KeepAlpha compares the alpha channel as-is (get_disto -alpha).
+	// When false, RGB is weighted by alpha (blended onto black) before comparison
+	// and the alpha channel itself compares as identical.
+	KeepAlpha bool
+}
+
+// Distortion holds per-channel and overall distortion values in dB (higher is better, capped at 99)
+type Distortion struct {
+	Metric DistortionMetric
+	R      float64
+	G      float64
+	B      float64
+	A      float64
+	All    float64
+}
+
+// DefaultDistortionOptions returns PSNR with alpha weighting, matching get_disto defaults
+func DefaultDistortionOptions() DistortionOptions {
+	return DistortionOptions{
+		Metric:    DistortionPSNR,
+		KeepAlpha: false,
+	}
+}
+
+// packedRGBA returns 8-bit image data as tightly packed RGBA
+func packedRGBA(img *DecodedImage) ([]byte, error) {
+	if img == nil || img.Width <= 0 || img.Height <= 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	if img.BitDepth != 8 {
+		return nil, fmt.Errorf("unsupported bit depth %d (must be 8)", img.BitDepth)
+	}
+
+	var bpp int
+	switch img.Format {
+	case FormatRGBA, FormatBGRA:
+		bpp = 4
+	case FormatRGB:
+		bpp = 3
+	default:
+		return nil, fmt.Errorf("unsupported pixel format %d", int(img.Format))
+	}
+
+	stride := img.Stride
+	if stride == 0 {
+		stride = img.Width * bpp
+	}
+	if stride < img.Width*bpp || len(img.Data) < stride*(img.Height-1)+img.Width*bpp {
+		return nil, fmt.Errorf("pixel data too small for %dx%d", img.Width, img.Height)
+	}
+	if img.Format == FormatRGBA && stride == img.Width*4 {
+		return img.Data[:img.Width*img.Height*4], nil
+	}
+
+	out := make([]byte, img.Width*img.Height*4)
+	for y := 0; y < img.Height; y++ {
+		row := img.Data[y*stride:]
+		for x := 0; x < img.Width; x++ {
+			src := row[x*bpp:]
+			dst := out[(y*img.Width+x)*4:]
+			switch img.Format {
+			case FormatRGBA:
+				copy(dst[:4], src[:4])
+			case FormatBGRA:
+				dst[0], dst[1], dst[2], dst[3] = src[2], src[1], src[0], src[3]
+			case FormatRGB:
+				dst[0], dst[1], dst[2], dst[3] = src[0], src[1], src[2], 255
+			}
+		}
+	}
+	return out, nil
+}
+
+// ImageDistortion computes the distortion of distorted against reference, like libwebp's get_disto.
+// Both images must be 8-bit RGBA, RGB or BGRA with the same dimensions.
+func ImageDistortion(reference, distorted *DecodedImage, opts DistortionOptions) (*Distortion, error) {
+	clearError()
+
+	refData, err := packedRGBA(reference)
+	if err != nil {
+		return nil, fmt.Errorf("distortion: reference: %w", err)
+	}
+	distData, err := packedRGBA(distorted)
+	if err != nil {
+		return nil, fmt.Errorf("distortion: distorted: %w", err)
+	}
+	if reference.Width != distorted.Width || reference.Height != distorted.Height {
+		return nil, fmt.Errorf("distortion: dimensions differ: %dx%d vs %dx%d",
+			reference.Width, reference.Height, distorted.Width, distorted.Height)
+	}
+
+	keepAlpha := 0
+	if opts.KeepAlpha {
+		keepAlpha = 1
+	}
+
+	var result [5]C.float
+	status := C.nextimage_image_distortion(
+		(*C.uint8_t)(unsafe.Pointer(&refData[0])),
+		C.int(reference.Width*4),
+		(*C.uint8_t)(unsafe.Pointer(&distData[0])),
+		C.int(distorted.Width*4),
+		C.int(reference.Width),
+		C.int(reference.Height),
+		C.NextImageDistortionMetric(opts.Metric),
+		C.int(keepAlpha),
+		&result[0],
+	)
+	if status != C.NEXTIMAGE_OK {
+		return nil, makeError(status, "distortion")
+	}
+
+	// libwebp reports channels in B, G, R, A, All order
+	return &Distortion{
+		Metric: opts.Metric,
+		B:      float64(result[0]),
+		G:      float64(result[1]),
+		R:      float64(result[2]),
+		A:      float64(result[3]),
+		All:    float64(result[4]),
+	}, nil
+}
+
+// ImageDistortionBytes decodes two image files (PNG, JPEG, TIFF, WebP or AVIF) and compares them
+func ImageDistortionBytes(referenceData, distortedData []byte, opts DistortionOptions) (*Distortion, error) {
+	reference, err := DecodeImageBytes(referenceData)
+	if err != nil {
+		return nil, fmt.Errorf("distortion: reference: %w", err)
+	}
+	distorted, err := DecodeImageBytes(distortedData)
+	if err != nil {
+		return nil, fmt.Errorf("distortion: distorted: %w", err)
+	}
+	return ImageDistortion(reference, distorted, opts)
+}
@@ -0,0 +1,233 @@
+package libnextimage
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encodeDistortionTestWebP encodes a test image as lossy WebP at the given quality
+func encodeDistortionTestWebP(t *testing.T, source []byte, quality float32) []byte {
+	t.Helper()
+
+	opts := DefaultWebPEncodeOptions()
+	opts.Quality = quality
+	webpData, err := WebPEncodeBytes(source, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode WebP: %v", err)
+	}
+	return webpData
+}
+
+// TestImageDistortion tests PSNR/SSIM/LSIM on identical and lossy images
+func TestImageDistortion(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	high := encodeDistortionTestWebP(t, source, 90)
+	low := encodeDistortionTestWebP(t, source, 10)
+
+	for _, metric := range []DistortionMetric{DistortionPSNR, DistortionSSIM, DistortionLSIM} {
+		opts := DefaultDistortionOptions()
+		opts.Metric = metric
+
+		identical, err := ImageDistortionBytes(source, source, opts)
+		if err != nil {
+			t.Fatalf("%s: identical comparison failed: %v", metric, err)
+		}
+		if identical.All < 99 {
+			t.Errorf("%s: identical images should score 99 dB, got %.2f", metric, identical.All)
+		}
+
+		highDisto, err := ImageDistortionBytes(source, high, opts)
+		if err != nil {
+			t.Fatalf("%s: comparison failed: %v", metric, err)
+		}
+		lowDisto, err := ImageDistortionBytes(source, low, opts)
+		if err != nil {
+			t.Fatalf("%s: comparison failed: %v", metric, err)
+		}
+		if highDisto.All <= lowDisto.All {
+			t.Errorf("%s: expected q90 (%.2f dB) above q10 (%.2f dB)", metric, highDisto.All, lowDisto.All)
+		}
+		if highDisto.Metric != metric {
+			t.Errorf("expected metric %s in result, got %s", metric, highDisto.Metric)
+		}
+
+		t.Logf("✓ %s: q90 all=%.2f (R %.2f G %.2f B %.2f A %.2f), q10 all=%.2f",
+			metric, highDisto.All, highDisto.R, highDisto.G, highDisto.B, highDisto.A, lowDisto.All)
+	}
+}
+
+// TestImageDistortion_Alpha tests alpha weighting versus keeping the alpha plane
+func TestImageDistortion_Alpha(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	opts := DefaultWebPEncodeOptions()
+	opts.Quality = 50
+	opts.AlphaQuality = 20
+	webpData, err := WebPEncodeBytes(source, opts)
+	if err != nil {
+		t.Fatalf("Failed to encode WebP: %v", err)
+	}
+
+	weighted, err := ImageDistortionBytes(source, webpData, DefaultDistortionOptions())
+	if err != nil {
+		t.Fatalf("alpha-weighted comparison failed: %v", err)
+	}
+	if weighted.A < 99 {
+		t.Errorf("alpha channel should compare as identical when alpha-weighted, got %.2f", weighted.A)
+	}
+
+	keep := DefaultDistortionOptions()
+	keep.KeepAlpha = true
+	kept, err := ImageDistortionBytes(source, webpData, keep)
+	if err != nil {
+		t.Fatalf("keep-alpha comparison failed: %v", err)
+	}
+	if kept.A >= 99 {
+		t.Errorf("expected alpha distortion with KeepAlpha and alpha quality 20, got %.2f", kept.A)
+	}
+
+	t.Logf("✓ alpha-weighted all=%.2f, keep-alpha all=%.2f A=%.2f", weighted.All, kept.All, kept.A)
+}
+
+// TestImageDistortion_Formats tests comparing DecodedImage values and AVIF input
+func TestImageDistortion_Formats(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	avifOpts := DefaultAVIFEncodeOptions()
+	avifOpts.Speed = 10
+	avifData, err := AVIFEncodeBytes(source, avifOpts)
+	if err != nil {
+		t.Fatalf("Failed to encode AVIF: %v", err)
+	}
+
+	disto, err := ImageDistortionBytes(source, avifData, DefaultDistortionOptions())
+	if err != nil {
+		t.Fatalf("AVIF comparison failed: %v", err)
+	}
+	if disto.All <= 20 || disto.All >= 99 {
+		t.Errorf("unexpected AVIF PSNR: %.2f", disto.All)
+	}
+
+	// RGB and BGRA DecodedImage values give the same result as RGBA
+	rgba, err := AVIFDecodeBytes(avifData, DefaultAVIFDecodeOptions())
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	bgraOpts := DefaultAVIFDecodeOptions()
+	bgraOpts.Format = FormatBGRA
+	bgra, err := AVIFDecodeBytes(avifData, bgraOpts)
+	if err != nil {
+		t.Fatalf("BGRA decode failed: %v", err)
+	}
+	reference, err := DecodeImageBytes(source)
+	if err != nil {
+		t.Fatalf("DecodeImageBytes failed: %v", err)
+	}
+	fromRGBA, _ := ImageDistortion(reference, rgba, DefaultDistortionOptions())
+	fromBGRA, err := ImageDistortion(reference, bgra, DefaultDistortionOptions())
+	if err != nil {
+		t.Fatalf("BGRA comparison failed: %v", err)
+	}
+	if *fromRGBA != *fromBGRA {
+		t.Errorf("BGRA result %+v differs from RGBA %+v", *fromBGRA, *fromRGBA)
+	}
+
+	// Dimension mismatch and invalid metric are rejected
+	small := &DecodedImage{Data: make([]byte, 16), Width: 2, Height: 2, BitDepth: 8, Format: FormatRGBA}
+	if _, err := ImageDistortion(reference, small, DefaultDistortionOptions()); err == nil {
+		t.Error("expected error for mismatched dimensions")
+	}
+	if _, err := ImageDistortion(reference, rgba, DistortionOptions{Metric: DistortionMetric(7)}); err == nil {
+		t.Error("expected error for invalid metric")
+	}
+
+	t.Logf("✓ AVIF PSNR %.2f dB, RGBA/BGRA consistent", disto.All)
+}
+
+// TestCompat_GetDisto compares GetDistoCommand with the get_disto tool
+func TestCompat_GetDisto(t *testing.T) {
+	getDistoPath := filepath.Join(binDir, "get_disto")
+	if _, err := os.Stat(getDistoPath); os.IsNotExist(err) {
+		t.Skipf("get_disto not found at %s, run scripts/build-cli-tools.sh first", getDistoPath)
+	}
+	if err := os.MkdirAll(filepath.Join(tempDir, "lib-output"), 0755); err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	originalPath := filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png")
+	original, err := os.ReadFile(originalPath)
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	compressedPath := filepath.Join(tempDir, "lib-output", "get_disto.webp")
+	if err := os.WriteFile(compressedPath, encodeDistortionTestWebP(t, original, 60), 0644); err != nil {
+		t.Fatalf("Failed to write WebP: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		opts GetDistoOptions
+	}{
+		{"psnr", nil, GetDistoOptions{Metric: int(DistortionPSNR)}},
+		{"ssim", []string{"-ssim"}, GetDistoOptions{Metric: int(DistortionSSIM)}},
+		{"lsim", []string{"-lsim"}, GetDistoOptions{Metric: int(DistortionLSIM)}},
+		{"psnr-alpha", []string{"-alpha"}, GetDistoOptions{Metric: int(DistortionPSNR), KeepAlpha: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(tt.args, compressedPath, originalPath)
+			output, err := exec.Command(getDistoPath, args...).Output()
+			if err != nil {
+				t.Fatalf("get_disto failed: %v", err)
+			}
+			expected := strings.TrimSpace(string(output))
+
+			cmd, err := NewGetDistoCommand(&tt.opts)
+			if err != nil {
+				t.Fatalf("Failed to create command: %v", err)
+			}
+			defer cmd.Close()
+
+			result, err := cmd.RunFile(compressedPath, originalPath)
+			if err != nil {
+				t.Fatalf("RunFile failed: %v", err)
+			}
+			if result.String() != expected {
+				t.Errorf("output mismatch:\n  get_disto: %s\n  library:   %s", expected, result.String())
+			}
+			t.Logf("✓ %s", result.String())
+		})
+	}
+}
+
+// TestGetDistoCommand_Invalid tests command option validation
+func TestGetDistoCommand_Invalid(t *testing.T) {
+	if _, err := NewGetDistoCommand(&GetDistoOptions{Metric: 5}); err == nil {
+		t.Error("expected error for invalid metric")
+	}
+
+	cmd, err := NewGetDistoCommand(nil)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	cmd.Close()
+	if _, err := cmd.Run([]byte{1}, []byte{1}); err == nil {
+		t.Error("expected error after Close")
+	}
+
+	t.Logf("✓ get_disto command validation")
+}
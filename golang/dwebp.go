@@ -70,6 +70,7 @@ type DWebPOptions struct {
 }
 
 // Command represents a dwebp command instance that can be reused for multiple conversions.
+// It decodes with one C WebP decoder and, like a WebPDecoder, serves one goroutine at a time.
 type DWebPCommand struct {
 	cmd *C.DWebPCommand
 }
@@ -184,6 +185,9 @@ func dwebpOptionsToCOptions(opts DWebPOptions) *C.DWebPOptions {
 func NewDWebPCommand(opts *DWebPOptions) (*DWebPCommand, error) {
 	var cOpts *C.DWebPOptions
 	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to create dwebp command: %w", err)
+		}
 		cOpts = dwebpOptionsToCOptions(*opts)
 	} else {
 		cOpts = nil
@@ -213,15 +217,30 @@ func NewDWebPCommand(opts *DWebPOptions) (*DWebPCommand, error) {
 // Run converts WebP data to PNG format.
 // This is the core method that operates on byte slices.
 func (c *DWebPCommand) Run(webpData []byte) ([]byte, error) {
+	output, err := c.run(webpData)
+	if err != nil {
+		return nil, err
+	}
+
+	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	C.nextimage_free_buffer(&output)
+	return result, nil
+}
+
+// run converts the input into a C buffer, which the caller frees
+func (c *DWebPCommand) run(webpData []byte) (C.NextImageBuffer, error) {
+	var output C.NextImageBuffer
 	if c.cmd == nil {
-		return nil, fmt.Errorf("command is closed")
+		return output, fmt.Errorf("command is closed")
 	}
 
 	if len(webpData) == 0 {
-		return nil, fmt.Errorf("empty input data")
+		return output, fmt.Errorf("empty input data")
+	}
+	if err := checkLimits(webpData); err != nil {
+		return output, err
 	}
 
-	var output C.NextImageBuffer
 	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
 
 	status := C.dwebp_run_command(
@@ -234,18 +253,12 @@ func (c *DWebPCommand) Run(webpData []byte) ([]byte, error) {
 	if status != C.NEXTIMAGE_OK {
 		errMsg := C.nextimage_last_error_message()
 		if errMsg != nil {
-			return nil, fmt.Errorf("dwebp decoding failed: %s", C.GoString(errMsg))
+			return output, fmt.Errorf("dwebp decoding failed: %s", C.GoString(errMsg))
 		}
-		return nil, fmt.Errorf("dwebp decoding failed with status %d", int(status))
+		return output, fmt.Errorf("dwebp decoding failed with status %d", int(status))
 	}
 
-	// Copy data to Go slice
-	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
-
-	// Free C buffer
-	C.nextimage_free_buffer(&output)
-
-	return result, nil
+	return output, nil
 }
 
 // RunFile converts a WebP file to PNG format and saves it to outputPath.
@@ -276,19 +289,18 @@ func (c *DWebPCommand) RunFile(inputPath, outputPath string) error {
 // This is a convenience method for stream-based operations.
 func (c *DWebPCommand) RunIO(input io.Reader, output io.Writer) error {
 	// Read all input
-	inputData, err := io.ReadAll(input)
+	inputData, err := readAllLimited(input)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	// Convert
-	pngData, err := c.Run(inputData)
+	// Convert and write from the C buffer without copying it
+	buf, err := c.run(inputData)
 	if err != nil {
 		return err
 	}
-
-	// Write output
-	_, err = output.Write(pngData)
+	_, err = writeBuffer(output, &buf)
+	C.nextimage_free_buffer(&buf)
 	if err != nil {
 		return fmt.Errorf("failed to write output: %w", err)
 	}
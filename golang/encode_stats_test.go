@@ -0,0 +1,240 @@
+package libnextimage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWebPEncodeBytesWithResult tests libwebp statistics for lossy and lossless encodes
+func TestWebPEncodeBytesWithResult(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	opts := DefaultWebPEncodeOptions()
+	opts.Quality = 75
+	res, err := WebPEncodeBytesWithResult(data, opts)
+	if err != nil {
+		t.Fatalf("WebPEncodeBytesWithResult failed: %v", err)
+	}
+
+	// Same bytes as the plain API
+	plain, err := WebPEncodeBytes(data, opts)
+	if err != nil {
+		t.Fatalf("WebPEncodeBytes failed: %v", err)
+	}
+	if string(plain) != string(res.Data) {
+		t.Error("result data differs from WebPEncodeBytes")
+	}
+
+	s := res.Stats
+	if s.CodedSize <= 0 || s.CodedSize > len(res.Data) {
+		t.Errorf("unexpected coded size %d for %d bytes", s.CodedSize, len(res.Data))
+	}
+	if s.PSNR[3] < 20 || s.PSNR[3] > 99 {
+		t.Errorf("unexpected overall PSNR %.2f", s.PSNR[3])
+	}
+	// The overall PSNR comes from the summed error of the Y, U and V planes
+	if lo, hi := min(s.PSNR[0], s.PSNR[1], s.PSNR[2]), max(s.PSNR[0], s.PSNR[1], s.PSNR[2]); s.PSNR[3] < lo || s.PSNR[3] > hi {
+		t.Errorf("overall PSNR %.2f is outside the Y/U/V range %.2f-%.2f", s.PSNR[3], lo, hi)
+	}
+	if s.BlockCount[0]+s.BlockCount[1] == 0 {
+		t.Error("expected intra4/intra16 macroblocks")
+	}
+	if s.SegmentSize[0]+s.SegmentSize[1]+s.SegmentSize[2]+s.SegmentSize[3] == 0 {
+		t.Error("expected macroblocks in segments")
+	}
+	if res.Duration <= 0 {
+		t.Error("expected a positive encode duration")
+	}
+	t.Logf("✓ lossy: %d bytes, PSNR %.2f dB, quant %v, %v", s.CodedSize, s.PSNR[3], s.SegmentQuant, res.Duration)
+
+	lossless := DefaultWebPEncodeOptions()
+	lossless.Lossless = true
+	res, err = WebPEncodeBytesWithResult(data, lossless)
+	if err != nil {
+		t.Fatalf("lossless WebPEncodeBytesWithResult failed: %v", err)
+	}
+	if res.Stats.LosslessSize <= 0 || res.Stats.LosslessDataSize <= 0 {
+		t.Errorf("expected lossless sizes, got %+v", res.Stats)
+	}
+	t.Logf("✓ lossless: size %d, features %04b, cache bits %d",
+		res.Stats.LosslessSize, res.Stats.LosslessFeatures, res.Stats.CacheBits)
+}
+
+// TestCWebPCommand_RunWithResult tests statistics from the command API
+func TestCWebPCommand_RunWithResult(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	cmd, err := NewCWebPCommand(nil)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	defer cmd.Close()
+
+	res, err := cmd.RunWithResult(data)
+	if err != nil {
+		t.Fatalf("RunWithResult failed: %v", err)
+	}
+	if res.Stats.AlphaDataSize <= 0 {
+		t.Errorf("expected alpha data for an image with transparency, got %d", res.Stats.AlphaDataSize)
+	}
+
+	cmd.Close()
+	if _, err := cmd.RunWithResult(data); err == nil {
+		t.Error("expected error after Close")
+	}
+	t.Logf("✓ cwebp alpha data %d bytes of %d", res.Stats.AlphaDataSize, len(res.Data))
+}
+
+// TestAVIFEncodeBytesWithResult_Stats tests quantizer, tile, OBU and layout statistics
+func TestAVIFEncodeBytesWithResult_Stats(t *testing.T) {
+	opaque, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.Speed = 10
+	opts.Quality = 60
+	res, err := AVIFEncodeBytesWithResult(opaque, opts)
+	if err != nil {
+		t.Fatalf("AVIFEncodeBytesWithResult failed: %v", err)
+	}
+
+	s := res.Stats
+	// libavif maps quality 60 to quantizer ((100-60)*63+50)/100 = 25
+	if s.EstimatedMinQuantizer != 25 || s.EstimatedMaxQuantizer != 25 {
+		t.Errorf("expected quantizer 25 for quality 60, got %d-%d", s.EstimatedMinQuantizer, s.EstimatedMaxQuantizer)
+	}
+	if s.EstimatedMinQuantizerAlpha != -1 || s.EstimatedMaxQuantizerAlpha != -1 {
+		t.Errorf("expected no alpha quantizer for an opaque image, got %d-%d", s.EstimatedMinQuantizerAlpha, s.EstimatedMaxQuantizerAlpha)
+	}
+	if s.AlphaOBUSize != 0 {
+		t.Errorf("expected no alpha for an opaque image, got %d bytes", s.AlphaOBUSize)
+	}
+	if s.ColorOBUSize <= 0 || s.ColorOBUSize >= len(res.Data) {
+		t.Errorf("unexpected color OBU size %d for %d bytes", s.ColorOBUSize, len(res.Data))
+	}
+	if s.GridCols != 1 || s.GridRows != 1 || s.LayerCount != 1 {
+		t.Errorf("expected a single-image layout, got %+v", s)
+	}
+	if res.Duration <= 0 {
+		t.Error("expected a positive encode duration")
+	}
+	t.Logf("✓ opaque: quantizer %d, color OBU %d bytes, %v", s.EstimatedMaxQuantizer, s.ColorOBUSize, res.Duration)
+
+	tiled := opts
+	tiled.AutoTiling = false
+	tiled.TileColsLog2 = 1
+	res, err = AVIFEncodeBytesWithResult(opaque, tiled)
+	if err != nil {
+		t.Fatalf("tiled encode failed: %v", err)
+	}
+	if res.Stats.EstimatedTileRowsLog2 != 0 || res.Stats.EstimatedTileColsLog2 != 1 || res.Stats.EstimatedTileCount() != 2 {
+		t.Errorf("expected 1x2 tiles, got rows log2 %d, cols log2 %d", res.Stats.EstimatedTileRowsLog2, res.Stats.EstimatedTileColsLog2)
+	}
+
+	alpha, err := os.ReadFile(filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	alphaOpts := opts
+	alphaOpts.QualityAlpha = 100
+	res, err = AVIFEncodeBytesWithResult(alpha, alphaOpts)
+	if err != nil {
+		t.Fatalf("alpha encode failed: %v", err)
+	}
+	if res.Stats.AlphaOBUSize <= 0 {
+		t.Errorf("expected alpha OBUs, got %d bytes", res.Stats.AlphaOBUSize)
+	}
+	if res.Stats.EstimatedMinQuantizerAlpha != 0 || res.Stats.EstimatedMaxQuantizerAlpha != 0 {
+		t.Errorf("expected alpha quantizer 0 for quality alpha 100, got %d-%d",
+			res.Stats.EstimatedMinQuantizerAlpha, res.Stats.EstimatedMaxQuantizerAlpha)
+	}
+	if res.Stats.ColorOBUSize+res.Stats.AlphaOBUSize >= len(res.Data) {
+		t.Errorf("OBU sizes %d+%d exceed file size %d", res.Stats.ColorOBUSize, res.Stats.AlphaOBUSize, len(res.Data))
+	}
+	t.Logf("✓ alpha: OBUs %d/%d bytes", res.Stats.ColorOBUSize, res.Stats.AlphaOBUSize)
+}
+
+// TestAVIFEncCommand_RunWithResult_Stats tests statistics from the command API
+func TestAVIFEncCommand_RunWithResult_Stats(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	opts := NewDefaultAVIFEncOptions()
+	opts.Speed = 10
+	opts.Quality = 100
+	cmd, err := NewAVIFEncCommand(&opts)
+	if err != nil {
+		t.Fatalf("Failed to create command: %v", err)
+	}
+	defer cmd.Close()
+
+	res, err := cmd.RunWithResult(data)
+	if err != nil {
+		t.Fatalf("RunWithResult failed: %v", err)
+	}
+	if res.Stats.EstimatedMinQuantizer != 0 || res.Stats.EstimatedMaxQuantizer != 0 {
+		t.Errorf("expected quantizer 0 for quality 100, got %d-%d", res.Stats.EstimatedMinQuantizer, res.Stats.EstimatedMaxQuantizer)
+	}
+	if res.Stats.ColorOBUSize <= 0 {
+		t.Error("expected color OBU size")
+	}
+	t.Logf("✓ avifenc quality 100: color OBU %d bytes, %v", res.Stats.ColorOBUSize, res.Duration)
+}
+
+// TestEncoder_EncodeWithResult tests statistics from the instance-based encoders
+func TestEncoder_EncodeWithResult(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	webpEncoder, err := NewWebPEncoder(nil)
+	if err != nil {
+		t.Fatalf("Failed to create WebP encoder: %v", err)
+	}
+	defer webpEncoder.Close()
+	webpRes, err := webpEncoder.EncodeWithResult(data)
+	if err != nil {
+		t.Fatalf("WebPEncoder.EncodeWithResult failed: %v", err)
+	}
+	if webpRes.Stats.CodedSize != len(webpRes.Data) || webpRes.Stats.AlphaDataSize <= 0 {
+		t.Errorf("unexpected WebP stats: coded %d of %d bytes, alpha %d",
+			webpRes.Stats.CodedSize, len(webpRes.Data), webpRes.Stats.AlphaDataSize)
+	}
+
+	avifEncoder, err := NewAVIFEncoder(func(opts *AVIFEncodeOptions) {
+		opts.Speed = 10
+		opts.CodecOptions = map[string]string{"tune": "ssim"}
+	})
+	if err != nil {
+		t.Fatalf("Failed to create AVIF encoder: %v", err)
+	}
+	defer avifEncoder.Close()
+	avifRes, err := avifEncoder.EncodeWithResult(data)
+	if err != nil {
+		t.Fatalf("AVIFEncoder.EncodeWithResult failed: %v", err)
+	}
+	if avifRes.Stats.ColorOBUSize <= 0 || avifRes.Stats.AlphaOBUSize <= 0 {
+		t.Errorf("expected color and alpha OBUs, got %+v", avifRes.Stats)
+	}
+	if avifRes.CodecOptions["tune"] != "ssim" {
+		t.Errorf("expected the codec options, got %v", avifRes.CodecOptions)
+	}
+
+	// Encode is unchanged
+	plain, err := webpEncoder.Encode(data)
+	if err != nil || len(plain) != len(webpRes.Data) {
+		t.Errorf("Encode after EncodeWithResult: %d bytes, err %v", len(plain), err)
+	}
+}
@@ -0,0 +1,133 @@
+package libnextimage
+
+/*
+#include "webp.h"
+#include "avif.h"
+*/
+import "C"
+import "time"
+
+// WebPEncodeStats holds libwebp's encoder statistics (WebPAuxStats)
+type WebPEncodeStats struct {
+	CodedSize int // Final size in bytes
+
+	// PSNR in dB for Y, U, V, all and alpha (lossy only)
+	PSNR [5]float32
+
+	BlockCount         [3]int    // Number of intra4 / intra16 / skipped macroblocks
+	HeaderBytes        int       // Approximate number of bytes spent for the header
+	ModePartitionBytes int       // Approximate number of bytes spent for the mode partition #0
+	ResidualBytes      [3][4]int // Approximate bytes spent for DC / AC / UV coefficients per segment
+	SegmentSize        [4]int    // Number of macroblocks in each segment
+	SegmentQuant       [4]int    // Quantizer value of each segment
+	SegmentLevel       [4]int    // Filtering strength of each segment [0..63]
+	AlphaDataSize      int       // Size of the transparency data
+
+	// Lossless statistics
+	LosslessFeatures uint32 // bit0: predictor, bit1: cross-color transform, bit2: subtract-green, bit3: color indexing
+	HistogramBits    int    // Number of precision bits of the histogram
+	TransformBits    int    // Precision bits for the predictor transform
+	CacheBits        int    // Number of bits for the color cache lookup
+	PaletteSize      int    // Number of colors in the palette, 0 if not used
+	LosslessSize     int    // Final lossless size
+	LosslessHdrSize  int    // Lossless header (transform, Huffman etc.) size
+	LosslessDataSize int    // Lossless image data size
+}
+
+// WebPEncodeResult holds encoded WebP data together with encoder statistics
+type WebPEncodeResult struct {
+	Data     []byte          // Encoded WebP file
+	Stats    WebPEncodeStats // libwebp encoder statistics
+	Duration time.Duration   // Wall-clock time spent reading the input and encoding
+}
+
+// AVIFEncodeStats describes how libavif encoded an image. libavif does not report the
+// quantizers and tiles it used, so the Estimated fields are computed from the encoder
+// settings with libavif's formulas and may drift from a newer libavif.
+type AVIFEncodeStats struct {
+	EstimatedMinQuantizer      int // Minimum color quantizer (0-63); for progressive, the final layer
+	EstimatedMaxQuantizer      int // Maximum color quantizer (0-63)
+	EstimatedMinQuantizerAlpha int // Minimum alpha quantizer (0-63), -1 if there is no alpha
+	EstimatedMaxQuantizerAlpha int // Maximum alpha quantizer (0-63), -1 if there is no alpha
+	EstimatedTileRowsLog2      int // log2 of the tile rows per image or grid cell
+	EstimatedTileColsLog2      int // log2 of the tile columns per image or grid cell
+	GridCols                   int // Number of grid columns, 1 if not a grid
+	GridRows                   int // Number of grid rows, 1 if not a grid
+	LayerCount                 int // Number of progressive layers, 1 if not progressive
+	ColorOBUSize               int // Total size of the color AV1 OBUs in bytes
+	AlphaOBUSize               int // Total size of the alpha AV1 OBUs in bytes, 0 if there is no alpha
+}
+
+// EstimatedTileCount returns the estimated number of tiles per image or grid cell
+func (s AVIFEncodeStats) EstimatedTileCount() int {
+	return 1 << (s.EstimatedTileRowsLog2 + s.EstimatedTileColsLog2)
+}
+
+// convertWebPEncodeStats converts C encode statistics to Go
+func convertWebPEncodeStats(cs *C.NextImageWebPEncodeStats) WebPEncodeStats {
+	s := WebPEncodeStats{
+		CodedSize:          int(cs.coded_size),
+		HeaderBytes:        int(cs.header_bytes),
+		ModePartitionBytes: int(cs.mode_partition_bytes),
+		AlphaDataSize:      int(cs.alpha_data_size),
+		LosslessFeatures:   uint32(cs.lossless_features),
+		HistogramBits:      int(cs.histogram_bits),
+		TransformBits:      int(cs.transform_bits),
+		CacheBits:          int(cs.cache_bits),
+		PaletteSize:        int(cs.palette_size),
+		LosslessSize:       int(cs.lossless_size),
+		LosslessHdrSize:    int(cs.lossless_hdr_size),
+		LosslessDataSize:   int(cs.lossless_data_size),
+	}
+	for i := range s.PSNR {
+		s.PSNR[i] = float32(cs.psnr[i])
+	}
+	for i := range s.BlockCount {
+		s.BlockCount[i] = int(cs.block_count[i])
+	}
+	for i := range s.ResidualBytes {
+		for j := range s.ResidualBytes[i] {
+			s.ResidualBytes[i][j] = int(cs.residual_bytes[i][j])
+		}
+	}
+	for i := 0; i < 4; i++ {
+		s.SegmentSize[i] = int(cs.segment_size[i])
+		s.SegmentQuant[i] = int(cs.segment_quant[i])
+		s.SegmentLevel[i] = int(cs.segment_level[i])
+	}
+	return s
+}
+
+// convertAVIFEncodeStats converts C encode statistics to Go
+func convertAVIFEncodeStats(cs *C.NextImageAVIFEncodeStats) AVIFEncodeStats {
+	return AVIFEncodeStats{
+		EstimatedMinQuantizer:      int(cs.estimated_min_quantizer),
+		EstimatedMaxQuantizer:      int(cs.estimated_max_quantizer),
+		EstimatedMinQuantizerAlpha: int(cs.estimated_min_quantizer_alpha),
+		EstimatedMaxQuantizerAlpha: int(cs.estimated_max_quantizer_alpha),
+		EstimatedTileRowsLog2:      int(cs.estimated_tile_rows_log2),
+		EstimatedTileColsLog2:      int(cs.estimated_tile_cols_log2),
+		GridCols:                   int(cs.grid_cols),
+		GridRows:                   int(cs.grid_rows),
+		LayerCount:                 int(cs.layer_count),
+		ColorOBUSize:               int(cs.color_obu_size),
+		AlphaOBUSize:               int(cs.alpha_obu_size),
+	}
+}
+
+// WebPEncodeBytesWithResult encodes image file data to WebP like WebPEncodeBytes
+// and also reports libwebp's encoder statistics and the encode time
+func WebPEncodeBytesWithResult(imageFileData []byte, opts WebPEncodeOptions) (*WebPEncodeResult, error) {
+	var stats C.NextImageWebPEncodeStats
+	start := time.Now()
+	data, err := webpEncode(imageFileData, opts, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebPEncodeResult{
+		Data:     data,
+		Stats:    convertWebPEncodeStats(&stats),
+		Duration: time.Since(start),
+	}, nil
+}
@@ -0,0 +1,117 @@
+package libnextimage
+
+import (
+	"fmt"
+	"os"
+)
+
+// GetDistoOptions represents get_disto options.
+// Unlike the other commands this runs entirely on the shared decode and distortion API.
+type GetDistoOptions struct {
+	Metric    int  // 0=PSNR (-psnr, default), 1=SSIM (-ssim), 2=LSIM (-lsim)
+	KeepAlpha bool // -alpha: preserve the alpha plane instead of alpha-weighting RGB
+}
+
+// GetDistoResult is the outcome of a get_disto comparison
+type GetDistoResult struct {
+	Distortion
+	Size         int     // Size of the compressed file in bytes
+	BitsPerPixel float64 // Compressed size in bits per pixel of the original
+}
+
+// String formats the result like get_disto's output line:
+// "<size> <all>    <B> <G> <R> <A> [ <bpp> bpp ]"
+func (r *GetDistoResult) String() string {
+	return fmt.Sprintf("%d %.2f    %.2f %.2f %.2f %.2f [ %.2f bpp ]",
+		r.Size, r.All, r.B, r.G, r.R, r.A, r.BitsPerPixel)
+}
+
+// GetDistoCommand compares compressed images against their originals.
+type GetDistoCommand struct {
+	opts   DistortionOptions
+	closed bool
+}
+
+// NewDefaultGetDistoOptions creates default get_disto options (PSNR with alpha weighting)
+func NewDefaultGetDistoOptions() GetDistoOptions {
+	return GetDistoOptions{
+		Metric:    int(DistortionPSNR),
+		KeepAlpha: false,
+	}
+}
+
+// NewGetDistoCommand creates a new get_disto command with the given options.
+// If opts is nil, default options are used.
+func NewGetDistoCommand(opts *GetDistoOptions) (*GetDistoCommand, error) {
+	o := NewDefaultGetDistoOptions()
+	if opts != nil {
+		o = *opts
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to create get_disto command: %w", err)
+	}
+
+	return &GetDistoCommand{
+		opts: DistortionOptions{
+			Metric:    DistortionMetric(o.Metric),
+			KeepAlpha: o.KeepAlpha,
+		},
+	}, nil
+}
+
+// Run compares compressed image data against the original image data.
+// Both may be any supported format (PNG, JPEG, TIFF, WebP or AVIF).
+func (c *GetDistoCommand) Run(compressedData, originalData []byte) (*GetDistoResult, error) {
+	if c.closed {
+		return nil, fmt.Errorf("command is closed")
+	}
+	if len(compressedData) == 0 || len(originalData) == 0 {
+		return nil, fmt.Errorf("input data is empty")
+	}
+
+	compressed, err := DecodeImageBytes(compressedData)
+	if err != nil {
+		return nil, fmt.Errorf("get_disto: compressed: %w", err)
+	}
+	original, err := DecodeImageBytes(originalData)
+	if err != nil {
+		return nil, fmt.Errorf("get_disto: original: %w", err)
+	}
+
+	disto, err := ImageDistortion(original, compressed, c.opts)
+	if err != nil {
+		return nil, fmt.Errorf("get_disto: %w", err)
+	}
+
+	return &GetDistoResult{
+		Distortion:   *disto,
+		Size:         len(compressedData),
+		BitsPerPixel: 8 * float64(len(compressedData)) / float64(original.Width*original.Height),
+	}, nil
+}
+
+// RunFile compares a compressed file against the original file.
+// This is sugar syntax over Run().
+func (c *GetDistoCommand) RunFile(compressedPath, originalPath string) (*GetDistoResult, error) {
+	if c.closed {
+		return nil, fmt.Errorf("command is closed")
+	}
+
+	compressedData, err := os.ReadFile(compressedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed file: %w", err)
+	}
+	originalData, err := os.ReadFile(originalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read original file: %w", err)
+	}
+
+	return c.Run(compressedData, originalData)
+}
+
+// Close releases the command. After calling Close, the command cannot be used anymore.
+func (c *GetDistoCommand) Close() error {
+	c.closed = true
+	return nil
+}
@@ -72,9 +72,19 @@ type Gif2WebPOptions struct {
 	Exact            bool
 	UseDeltaPalette  bool
 	UseSharpYUV      bool
+
+	// Animation settings
+	AllowMixed        bool // allow mixed lossy/lossless frames (-mixed)
+	MinimizeSize      bool // minimize output size, slow (-min_size)
+	Kmin              int  // min distance between key frames (-kmin), -1=auto (default)
+	Kmax              int  // max distance between key frames (-kmax), -1=auto (default)
+	AnimLoopCount     int  // animation loop count, 0=infinite (default)
+	LoopCompatibility bool // Chrome M62 compatible loop count handling (-loop_compatibility)
 }
 
 // Command represents a gif2webp command instance that can be reused for multiple conversions.
+// The C command only holds the options, so Run may be called from several goroutines,
+// but not concurrently with Close.
 type Gif2WebPCommand struct {
 	cmd *C.Gif2WebPCommand
 }
@@ -83,7 +93,7 @@ type Gif2WebPCommand struct {
 func NewDefaultGif2WebPOptions() Gif2WebPOptions {
 	cOpts := C.gif2webp_create_default_options()
 	if cOpts == nil {
-		return Gif2WebPOptions{Quality: 75, Method: 4} // fallback defaults
+		return Gif2WebPOptions{Quality: 75, Method: 4, Segments: 4, Pass: 1, Kmin: -1, Kmax: -1} // fallback defaults
 	}
 	defer C.gif2webp_free_options(cOpts)
 
@@ -114,6 +124,13 @@ func NewDefaultGif2WebPOptions() Gif2WebPOptions {
 		Exact:            cOpts.exact != 0,
 		UseDeltaPalette:  cOpts.use_delta_palette != 0,
 		UseSharpYUV:      cOpts.use_sharp_yuv != 0,
+
+		AllowMixed:        cOpts.allow_mixed != 0,
+		MinimizeSize:      cOpts.minimize_size != 0,
+		Kmin:              int(cOpts.kmin),
+		Kmax:              int(cOpts.kmax),
+		AnimLoopCount:     int(cOpts.anim_loop_count),
+		LoopCompatibility: cOpts.loop_compatibility != 0,
 	}
 }
 
@@ -183,6 +200,26 @@ func gif2webpOptionsToCOptions(opts Gif2WebPOptions) *C.Gif2WebPOptions {
 		cOpts.use_sharp_yuv = 0
 	}
 
+	// Animation settings
+	if opts.AllowMixed {
+		cOpts.allow_mixed = 1
+	} else {
+		cOpts.allow_mixed = 0
+	}
+	if opts.MinimizeSize {
+		cOpts.minimize_size = 1
+	} else {
+		cOpts.minimize_size = 0
+	}
+	cOpts.kmin = C.int(opts.Kmin)
+	cOpts.kmax = C.int(opts.Kmax)
+	cOpts.anim_loop_count = C.int(opts.AnimLoopCount)
+	if opts.LoopCompatibility {
+		cOpts.loop_compatibility = 1
+	} else {
+		cOpts.loop_compatibility = 0
+	}
+
 	return cOpts
 }
 
@@ -192,6 +229,9 @@ func gif2webpOptionsToCOptions(opts Gif2WebPOptions) *C.Gif2WebPOptions {
 func NewGif2WebPCommand(opts *Gif2WebPOptions) (*Gif2WebPCommand, error) {
 	var cOpts *C.Gif2WebPOptions
 	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to create gif2webp command: %w", err)
+		}
 		cOpts = gif2webpOptionsToCOptions(*opts)
 		if cOpts == nil {
 			return nil, fmt.Errorf("failed to create options")
@@ -218,14 +258,29 @@ func NewGif2WebPCommand(opts *Gif2WebPOptions) (*Gif2WebPCommand, error) {
 // Run converts GIF data to WebP format.
 // This is the core method that performs the conversion.
 func (c *Gif2WebPCommand) Run(gifData []byte) ([]byte, error) {
+	output, err := c.run(gifData)
+	if err != nil {
+		return nil, err
+	}
+
+	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	C.nextimage_free_buffer(&output)
+	return result, nil
+}
+
+// run converts the input into a C buffer, which the caller frees
+func (c *Gif2WebPCommand) run(gifData []byte) (C.NextImageBuffer, error) {
+	var output C.NextImageBuffer
 	if c.cmd == nil {
-		return nil, fmt.Errorf("command is closed")
+		return output, fmt.Errorf("command is closed")
 	}
 	if len(gifData) == 0 {
-		return nil, fmt.Errorf("input data is empty")
+		return output, fmt.Errorf("input data is empty")
+	}
+	if err := checkLimits(gifData); err != nil {
+		return output, err
 	}
 
-	var output C.NextImageBuffer
 	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
 
 	status := C.gif2webp_run_command(
@@ -237,16 +292,14 @@ func (c *Gif2WebPCommand) Run(gifData []byte) ([]byte, error) {
 
 	if status != C.NEXTIMAGE_OK {
 		errMsg := C.nextimage_last_error_message()
-		return nil, fmt.Errorf("gif2webp encoding failed (status %d): %s", status, C.GoString(errMsg))
+		return output, fmt.Errorf("gif2webp encoding failed (status %d): %s", status, C.GoString(errMsg))
 	}
 
 	if output.data == nil || output.size == 0 {
-		return nil, fmt.Errorf("encoding produced empty output")
+		return output, fmt.Errorf("encoding produced empty output")
 	}
 
-	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
-	C.nextimage_free_buffer(&output)
-	return result, nil
+	return output, nil
 }
 
 // RunFile reads a GIF file, converts it to WebP, and writes the result to outputPath.
@@ -280,17 +333,18 @@ func (c *Gif2WebPCommand) RunIO(input io.Reader, output io.Writer) error {
 		return fmt.Errorf("command is closed")
 	}
 
-	inputData, err := io.ReadAll(input)
+	inputData, err := readAllLimited(input)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	webpData, err := c.Run(inputData)
+	buf, err := c.run(inputData)
 	if err != nil {
 		return err
 	}
-
-	if _, err := output.Write(webpData); err != nil {
+	_, err = writeBuffer(output, &buf)
+	C.nextimage_free_buffer(&buf)
+	if err != nil {
 		return fmt.Errorf("failed to write output: %w", err)
 	}
 
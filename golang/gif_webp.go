@@ -18,6 +18,9 @@ func GIF2WebPEncodeBytes(gifData []byte, opts WebPEncodeOptions) ([]byte, error)
 	if len(gifData) == 0 {
 		return nil, fmt.Errorf("gif2webp: empty input data")
 	}
+	if err := checkLimits(gifData); err != nil {
+		return nil, fmt.Errorf("gif2webp: %w", err)
+	}
 
 	clearError()
 
@@ -70,6 +73,9 @@ func WebP2GIFConvertBytes(webpData []byte) ([]byte, error) {
 	if len(webpData) == 0 {
 		return nil, fmt.Errorf("webp2gif: empty input data")
 	}
+	if err := checkLimits(webpData); err != nil {
+		return nil, fmt.Errorf("webp2gif: %w", err)
+	}
 
 	clearError()
 
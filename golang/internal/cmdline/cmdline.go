@@ -0,0 +1,90 @@
+// Package cmdline parses argument lists in the style of the upstream tools. It is shared
+// by the libnextimage Parse*Args functions and the nextimage command.
+package cmdline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flag describes one command line flag
+type Flag[T any] struct {
+	Names []string // All spellings, e.g. "-q" and "--qcolor"
+	NArgs int      // Number of values following the flag
+	Pass  bool     // Not handled here: returned with its values in the remaining arguments
+	Set   func(v *T, values []string) error
+}
+
+// Syntax holds the error messages of a tool
+type Syntax struct {
+	Unknown string                             // Unknown flag, with the flag
+	Missing string                             // Missing value, with the flag
+	Value   func(flag string, err error) error // Error returned by Set
+	// KeepSeparator keeps "--" in front of the argument it makes positional, for
+	// arguments that are parsed again
+	KeepSeparator bool
+}
+
+// Parse applies the flags in args to v and returns the remaining arguments.
+// As in the upstream tools, "--" makes the next argument positional and "-" is stdin/stdout.
+func Parse[T any](syntax *Syntax, flags []Flag[T], v *T, args []string) ([]string, error) {
+	index := make(map[string]*Flag[T])
+	for i := range flags {
+		for _, name := range flags[i].Names {
+			index[name] = &flags[i]
+		}
+	}
+
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				if syntax.KeepSeparator {
+					rest = append(rest, arg)
+				}
+				rest = append(rest, args[i+1])
+				i++
+			}
+			continue
+		}
+		if arg == "-" || !strings.HasPrefix(arg, "-") {
+			rest = append(rest, arg)
+			continue
+		}
+
+		flag := index[arg]
+		if flag == nil {
+			return nil, fmt.Errorf(syntax.Unknown, arg)
+		}
+		if i+flag.NArgs >= len(args) {
+			return nil, fmt.Errorf(syntax.Missing, arg)
+		}
+		values := args[i+1 : i+1+flag.NArgs]
+		i += flag.NArgs
+		if flag.Pass {
+			rest = append(rest, arg)
+			rest = append(rest, values...)
+			continue
+		}
+		if err := flag.Set(v, values); err != nil {
+			return nil, syntax.Value(arg, err)
+		}
+	}
+	return rest, nil
+}
+
+// Bool sets a bool field to value
+func Bool[T any](field func(*T) *bool, value bool, names ...string) Flag[T] {
+	return Flag[T]{Names: names, Set: func(v *T, values []string) error {
+		*field(v) = value
+		return nil
+	}}
+}
+
+// Ignored accepts a flag that has no effect
+func Ignored[T any](nargs int, names ...string) Flag[T] {
+	return Flag[T]{Names: names, NArgs: nargs, Set: func(v *T, values []string) error {
+		return nil
+	}}
+}
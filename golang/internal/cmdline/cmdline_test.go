@@ -0,0 +1,66 @@
+package cmdline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type options struct {
+	quality string
+	quiet   bool
+}
+
+var flags = []Flag[options]{
+	{Names: []string{"-q"}, NArgs: 1, Set: func(o *options, v []string) error {
+		if v[0] == "high" {
+			return errors.New("not a number")
+		}
+		o.quality = v[0]
+		return nil
+	}},
+	Bool(func(o *options) *bool { return &o.quiet }, true, "-quiet"),
+	Ignored[options](0, "-v"),
+	{Names: []string{"-o"}, NArgs: 1, Pass: true},
+}
+
+var syntax = Syntax{
+	Unknown: "unknown %s",
+	Missing: "missing %s",
+	Value: func(flag string, err error) error {
+		return errors.New(flag + ": " + err.Error())
+	},
+}
+
+// TestParse tests flags, passed flags, "--" and the error messages
+func TestParse(t *testing.T) {
+	var o options
+	rest, err := Parse(&syntax, flags, &o, []string{"-q", "80", "in.png", "-v", "-o", "out.webp", "-quiet", "--", "-dash.png", "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.quality != "80" || !o.quiet {
+		t.Errorf("unexpected options %+v", o)
+	}
+	if want := []string{"in.png", "-o", "out.webp", "-dash.png", "-"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("rest %q, expected %q", rest, want)
+	}
+
+	kept := syntax
+	kept.KeepSeparator = true
+	rest, err = Parse(&kept, flags, &o, []string{"--", "-dash.png"})
+	if want := []string{"--", "-dash.png"}; err != nil || !reflect.DeepEqual(rest, want) {
+		t.Errorf("rest %q (%v), expected %q", rest, err, want)
+	}
+
+	for want, args := range map[string][]string{
+		"unknown -x":       {"-x"},
+		"missing -q":       {"in.png", "-q"},
+		"-q: not a number": {"-q", "high"},
+		"missing -o":       {"-o"},
+	} {
+		if _, err := Parse(&syntax, flags, &o, args); err == nil || err.Error() != want {
+			t.Errorf("%q: expected %q, got %v", args, want, err)
+		}
+	}
+}
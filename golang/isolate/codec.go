@@ -0,0 +1,225 @@
+package isolate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// Encoder mirrors libnextimage.WebPEncoder and AVIFEncoder, encoding in the children
+// of a Pool. It holds no C resources and is safe for concurrent use.
+type Encoder struct {
+	pool *Pool
+	req  request // Op and options of every job
+}
+
+// NewWebPEncoder mirrors libnextimage.NewWebPEncoder
+func (p *Pool) NewWebPEncoder(optsFn func(*libnextimage.WebPEncodeOptions)) (*Encoder, error) {
+	opts := libnextimage.DefaultWebPEncodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("isolate: webp encoder: %w", err)
+	}
+	return &Encoder{pool: p, req: request{Op: opWebPEncode, WebPEncode: &opts}}, nil
+}
+
+// NewAVIFEncoder mirrors libnextimage.NewAVIFEncoder
+func (p *Pool) NewAVIFEncoder(optsFn func(*libnextimage.AVIFEncodeOptions)) (*Encoder, error) {
+	opts := libnextimage.DefaultAVIFEncodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("isolate: avif encoder: %w", err)
+	}
+	return &Encoder{pool: p, req: request{Op: opAVIFEncode, AVIFEncode: &opts}}, nil
+}
+
+// Encode encodes image file data (JPEG, PNG, etc.) in a child process
+func (e *Encoder) Encode(ctx context.Context, imageFileData []byte) ([]byte, error) {
+	req := e.req
+	req.Data = imageFileData
+	resp, err := e.pool.run(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Close does nothing; it is there to keep the libnextimage method set
+func (e *Encoder) Close() {}
+
+// Decoder mirrors libnextimage.WebPDecoder and AVIFDecoder, decoding in the children
+// of a Pool. It holds no C resources and is safe for concurrent use.
+type Decoder struct {
+	pool *Pool
+	req  request
+}
+
+// NewWebPDecoder mirrors libnextimage.NewWebPDecoder
+func (p *Pool) NewWebPDecoder(optsFn func(*libnextimage.WebPDecodeOptions)) (*Decoder, error) {
+	opts := libnextimage.DefaultWebPDecodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	return &Decoder{pool: p, req: request{Op: opWebPDecode, WebPDecode: &opts}}, nil
+}
+
+// NewAVIFDecoder mirrors libnextimage.NewAVIFDecoder
+func (p *Pool) NewAVIFDecoder(optsFn func(*libnextimage.AVIFDecodeOptions)) (*Decoder, error) {
+	opts := libnextimage.DefaultAVIFDecodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	return &Decoder{pool: p, req: request{Op: opAVIFDecode, AVIFDecode: &opts}}, nil
+}
+
+// Decode decodes an image in a child process
+func (d *Decoder) Decode(ctx context.Context, data []byte) (*libnextimage.DecodedImage, error) {
+	req := d.req
+	req.Data = data
+	resp, err := d.pool.run(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Image, nil
+}
+
+// Close does nothing; it is there to keep the libnextimage method set
+func (d *Decoder) Close() {}
+
+// Command mirrors the libnextimage command types (CWebPCommand, DWebPCommand, ...),
+// running each conversion in a child of a Pool. It holds no C resources and is safe
+// for concurrent use.
+type Command struct {
+	pool *Pool
+	req  request
+}
+
+// NewCWebPCommand mirrors libnextimage.NewCWebPCommand
+func (p *Pool) NewCWebPCommand(opts *libnextimage.CWebPOptions) (*Command, error) {
+	req := request{Op: opCWebP}
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("isolate: cwebp command: %w", err)
+		}
+		req.CWebP = ptr(*opts)
+	}
+	return &Command{pool: p, req: req}, nil
+}
+
+// NewDWebPCommand mirrors libnextimage.NewDWebPCommand
+func (p *Pool) NewDWebPCommand(opts *libnextimage.DWebPOptions) (*Command, error) {
+	req := request{Op: opDWebP}
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("isolate: dwebp command: %w", err)
+		}
+		req.DWebP = ptr(*opts)
+	}
+	return &Command{pool: p, req: req}, nil
+}
+
+// NewGif2WebPCommand mirrors libnextimage.NewGif2WebPCommand
+func (p *Pool) NewGif2WebPCommand(opts *libnextimage.Gif2WebPOptions) (*Command, error) {
+	req := request{Op: opGif2WebPCommand}
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("isolate: gif2webp command: %w", err)
+		}
+		req.Gif2WebP = ptr(*opts)
+	}
+	return &Command{pool: p, req: req}, nil
+}
+
+// NewWebP2GifCommand mirrors libnextimage.NewWebP2GifCommand
+func (p *Pool) NewWebP2GifCommand(opts *libnextimage.WebP2GifOptions) (*Command, error) {
+	req := request{Op: opWebP2GifCommand}
+	if opts != nil {
+		req.WebP2Gif = ptr(*opts)
+	}
+	return &Command{pool: p, req: req}, nil
+}
+
+// NewAVIFEncCommand mirrors libnextimage.NewAVIFEncCommand
+func (p *Pool) NewAVIFEncCommand(opts *libnextimage.AVIFEncOptions) (*Command, error) {
+	req := request{Op: opAVIFEnc}
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("isolate: avifenc command: %w", err)
+		}
+		req.AVIFEnc = ptr(*opts)
+	}
+	return &Command{pool: p, req: req}, nil
+}
+
+// NewAVIFDecCommand mirrors libnextimage.NewAVIFDecCommand
+func (p *Pool) NewAVIFDecCommand(opts *libnextimage.AVIFDecOptions) (*Command, error) {
+	req := request{Op: opAVIFDec}
+	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("isolate: avifdec command: %w", err)
+		}
+		req.AVIFDec = ptr(*opts)
+	}
+	return &Command{pool: p, req: req}, nil
+}
+
+// Run converts data in a child process
+func (c *Command) Run(ctx context.Context, data []byte) ([]byte, error) {
+	req := c.req
+	req.Data = data
+	resp, err := c.pool.run(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// RunFile converts the file at inputPath to outputPath
+func (c *Command) RunFile(ctx context.Context, inputPath, outputPath string) error {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+	out, err := c.Run(ctx, data)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, out, 0644); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
+
+// RunIO reads input to the end, converts it and writes the result to output. Input
+// past Limits.MaxInputBytes is not read; the child rejects it.
+func (c *Command) RunIO(ctx context.Context, input io.Reader, output io.Writer) error {
+	if max := libnextimage.CurrentLimits().MaxInputBytes; max > 0 {
+		input = io.LimitReader(input, max+1)
+	}
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	out, err := c.Run(ctx, data)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(out)
+	return err
+}
+
+// Close does nothing; it is there to keep the libnextimage method set
+func (c *Command) Close() error {
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,401 @@
+// Package isolate runs conversions in child processes of the running binary, so that
+// an input crashing libwebp, libaom or giflib only takes down a child, and a conversion
+// stuck inside a cgo call can be stopped by killing it.
+//
+// A Pool starts up to Workers children, sends each job over the child's stdin and
+// reads the result from its stdout. A child that crashes, hangs past the job timeout
+// or whose job is cancelled is killed, and a new one is started for the next job.
+// The methods mirror the package-level functions of libnextimage with a leading
+// context, Encoder, Decoder and Command mirror its encoder, decoder and command types,
+// and the current libnextimage.Limits are applied inside the child.
+//
+// The children run the same executable, which must call Main at the top of main:
+//
+//	func main() {
+//		isolate.Main()
+//		pool, err := isolate.NewPool(isolate.Options{Timeout: 30 * time.Second, MaxMemory: 2 << 30})
+//		...
+//		webpData, err := pool.WebPEncodeBytes(ctx, data, libnextimage.DefaultWebPEncodeOptions())
+//	}
+package isolate
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// DefaultTimeout is the job timeout used when Options.Timeout is zero
+const DefaultTimeout = time.Minute
+
+const (
+	envWorker    = "NEXTIMAGE_ISOLATE_WORKER"
+	envMaxMemory = "NEXTIMAGE_ISOLATE_MAX_MEMORY"
+
+	// magic is written by a child before the job stream, telling the pool that Main
+	// is in control of the process
+	magic = "nextimage-isolate/1\n"
+
+	startTimeout = 10 * time.Second
+)
+
+var (
+	// ErrWorkerCrashed is returned for a job whose child process exited before answering
+	ErrWorkerCrashed = errors.New("isolate: worker crashed")
+	// ErrTimeout is returned for a job that ran longer than Options.Timeout
+	ErrTimeout = errors.New("isolate: job timed out")
+	// ErrClosed is returned by a Pool after Close
+	ErrClosed = errors.New("isolate: pool is closed")
+)
+
+// Options configures a Pool
+type Options struct {
+	// Workers is the number of child processes running jobs at the same time.
+	// Default: runtime.NumCPU().
+	Workers int
+
+	// Timeout is the time a job may run before its child is killed. Default: DefaultTimeout.
+	Timeout time.Duration
+
+	// MaxMemory limits the address space of each child in bytes (RLIMIT_AS), so that
+	// an allocation over it fails inside the child. Zero means no limit. It is only
+	// enforced on Linux and NetBSD; elsewhere, including macOS, NewPool fails when it is set.
+	MaxMemory int64
+
+	// MaxJobs restarts a child after it has run this many jobs. Zero means never.
+	MaxJobs int
+
+	// Executable is the binary started for the children. Default: os.Executable().
+	Executable string
+
+	// Stderr receives the standard error of the children. Default: os.Stderr.
+	Stderr io.Writer
+}
+
+// Stats reports what a Pool has done
+type Stats struct {
+	Workers  int   // Child processes running
+	Jobs     int64 // Jobs answered by a child
+	Crashes  int64 // Jobs whose child crashed
+	Timeouts int64 // Jobs whose child was killed after Options.Timeout
+	Started  int64 // Child processes started
+}
+
+// Pool runs jobs in child processes. It is safe for concurrent use.
+type Pool struct {
+	opts Options
+	slot chan struct{} // One per worker
+
+	mu      sync.Mutex
+	idle    []*worker
+	running int
+	closed  bool
+
+	jobs, crashes, timeouts, started atomic.Int64
+}
+
+// NewPool creates a pool. Children are started when jobs need them.
+func NewPool(opts Options) (*Pool, error) {
+	if os.Getenv(envWorker) != "" {
+		return nil, fmt.Errorf("isolate: NewPool called inside a worker; call isolate.Main at the top of main")
+	}
+	if opts.MaxMemory > 0 && !memoryLimitSupported {
+		return nil, fmt.Errorf("isolate: MaxMemory: %w", setMemoryLimit(opts.MaxMemory))
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Executable == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("isolate: %w", err)
+		}
+		opts.Executable = exe
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	return &Pool{opts: opts, slot: make(chan struct{}, opts.Workers)}, nil
+}
+
+// WebPEncodeBytes runs libnextimage.WebPEncodeBytes in a child process
+func (p *Pool) WebPEncodeBytes(ctx context.Context, data []byte, opts libnextimage.WebPEncodeOptions) ([]byte, error) {
+	resp, err := p.run(ctx, &request{Op: opWebPEncode, Data: data, WebPEncode: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// AVIFEncodeBytes runs libnextimage.AVIFEncodeBytes in a child process
+func (p *Pool) AVIFEncodeBytes(ctx context.Context, data []byte, opts libnextimage.AVIFEncodeOptions) ([]byte, error) {
+	resp, err := p.run(ctx, &request{Op: opAVIFEncode, Data: data, AVIFEncode: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// WebPDecodeBytes runs libnextimage.WebPDecodeBytes in a child process
+func (p *Pool) WebPDecodeBytes(ctx context.Context, data []byte, opts libnextimage.WebPDecodeOptions) (*libnextimage.DecodedImage, error) {
+	resp, err := p.run(ctx, &request{Op: opWebPDecode, Data: data, WebPDecode: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Image, nil
+}
+
+// AVIFDecodeBytes runs libnextimage.AVIFDecodeBytes in a child process
+func (p *Pool) AVIFDecodeBytes(ctx context.Context, data []byte, opts libnextimage.AVIFDecodeOptions) (*libnextimage.DecodedImage, error) {
+	resp, err := p.run(ctx, &request{Op: opAVIFDecode, Data: data, AVIFDecode: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Image, nil
+}
+
+// DecodeImageBytes runs libnextimage.DecodeImageBytes in a child process
+func (p *Pool) DecodeImageBytes(ctx context.Context, data []byte) (*libnextimage.DecodedImage, error) {
+	resp, err := p.run(ctx, &request{Op: opDecode, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Image, nil
+}
+
+// GIF2WebP runs libnextimage.GIF2WebP in a child process
+func (p *Pool) GIF2WebP(ctx context.Context, data []byte, opts libnextimage.WebPEncodeOptions) ([]byte, error) {
+	resp, err := p.run(ctx, &request{Op: opGIF2WebP, Data: data, WebPEncode: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// WebP2GIF runs libnextimage.WebP2GIF in a child process
+func (p *Pool) WebP2GIF(ctx context.Context, data []byte) ([]byte, error) {
+	resp, err := p.run(ctx, &request{Op: opWebP2GIF, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Stats returns the pool counters
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	workers := p.running + len(p.idle)
+	p.mu.Unlock()
+	return Stats{
+		Workers:  workers,
+		Jobs:     p.jobs.Load(),
+		Crashes:  p.crashes.Load(),
+		Timeouts: p.timeouts.Load(),
+		Started:  p.started.Load(),
+	}
+}
+
+// Close stops the idle children and makes further jobs fail with ErrClosed.
+// Children running a job stop when it is done.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+	for _, w := range idle {
+		w.stop()
+	}
+	return nil
+}
+
+// run sends a job to a child and waits for its answer, the timeout or ctx
+func (p *Pool) run(ctx context.Context, req *request) (*response, error) {
+	select {
+	case p.slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slot }()
+
+	w, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	req.Limits = libnextimage.CurrentLimits()
+
+	timeout, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+	type result struct {
+		resp *response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := w.call(req)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			p.crashes.Add(1)
+			p.discard()
+			return nil, fmt.Errorf("%w (%v)", ErrWorkerCrashed, w.kill())
+		}
+		p.jobs.Add(1)
+		p.put(w)
+		if err := r.resp.err(); err != nil {
+			return nil, err
+		}
+		return r.resp, nil
+	case <-timeout.Done():
+		w.kill()
+		<-done
+		p.discard()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p.timeouts.Add(1)
+		return nil, fmt.Errorf("%w after %v", ErrTimeout, p.opts.Timeout)
+	}
+}
+
+// get returns an idle child or starts one
+func (p *Pool) get() (*worker, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	p.running++
+	if n := len(p.idle); n > 0 {
+		w := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return w, nil
+	}
+	p.mu.Unlock()
+
+	w, err := p.start()
+	if err != nil {
+		p.discard()
+		return nil, err
+	}
+	return w, nil
+}
+
+// put returns a child after a job, stopping it if it is due for a restart
+func (p *Pool) put(w *worker) {
+	w.jobs++
+	p.mu.Lock()
+	p.running--
+	if p.closed || (p.opts.MaxJobs > 0 && w.jobs >= p.opts.MaxJobs) {
+		p.mu.Unlock()
+		w.stop()
+		return
+	}
+	p.idle = append(p.idle, w)
+	p.mu.Unlock()
+}
+
+// discard forgets a child that was killed or failed to start
+func (p *Pool) discard() {
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+}
+
+// start starts a child and waits for it to announce itself
+func (p *Pool) start() (*worker, error) {
+	cmd := exec.Command(p.opts.Executable)
+	cmd.Env = append(os.Environ(), envWorker+"=1")
+	if p.opts.MaxMemory > 0 {
+		cmd.Env = append(cmd.Env, envMaxMemory+"="+strconv.FormatInt(p.opts.MaxMemory, 10))
+	}
+	cmd.Stderr = p.opts.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("isolate: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("isolate: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("isolate: starting worker: %w", err)
+	}
+	p.started.Add(1)
+	w := &worker{cmd: cmd, stdin: stdin, enc: gob.NewEncoder(stdin), dec: gob.NewDecoder(stdout)}
+
+	hello := make(chan error, 1)
+	go func() {
+		buf := make([]byte, len(magic))
+		_, err := io.ReadFull(stdout, buf)
+		if err == nil && string(buf) != magic {
+			err = fmt.Errorf("unexpected output %q", buf)
+		}
+		hello <- err
+	}()
+	timer := time.NewTimer(startTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-hello:
+	case <-timer.C:
+		err = fmt.Errorf("no answer after %v", startTimeout)
+	}
+	if err != nil {
+		w.kill()
+		return nil, fmt.Errorf("isolate: worker did not start (is isolate.Main called at the top of main?): %w", err)
+	}
+	return w, nil
+}
+
+// worker is a child process
+type worker struct {
+	cmd   *exec.Cmd
+	stdin io.Closer
+	enc   *gob.Encoder
+	dec   *gob.Decoder
+	jobs  int
+}
+
+func (w *worker) call(req *request) (*response, error) {
+	if err := w.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := w.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// stop closes the child's stdin, which makes it exit, and waits for it
+func (w *worker) stop() {
+	w.stdin.Close()
+	w.cmd.Wait()
+}
+
+// kill kills the child and returns how it exited
+func (w *worker) kill() error {
+	w.cmd.Process.Kill()
+	if err := w.cmd.Wait(); err != nil {
+		return err
+	}
+	return errors.New("exited")
+}
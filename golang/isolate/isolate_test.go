@@ -0,0 +1,222 @@
+package isolate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// The test binary is also the worker
+func TestMain(m *testing.M) {
+	Main()
+	os.Exit(m.Run())
+}
+
+// Operations that misbehave, run by the workers
+func init() {
+	handlers["test-crash"] = func(*request) (*response, error) {
+		os.Exit(3)
+		return nil, nil
+	}
+	handlers["test-hang"] = func(*request) (*response, error) {
+		time.Sleep(time.Hour)
+		return nil, nil
+	}
+}
+
+// TestPool tests conversions, errors and limits through child processes
+func TestPool(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	p, err := NewPool(Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webp, err := p.WebPEncodeBytes(ctx, data, libnextimage.DefaultWebPEncodeOptions())
+			if err != nil || !bytes.HasPrefix(webp, []byte("RIFF")) {
+				t.Errorf("webp encode: %v", err)
+				return
+			}
+			img, err := p.WebPDecodeBytes(ctx, webp, libnextimage.DefaultWebPDecodeOptions())
+			if err != nil || img.Width == 0 || len(img.Data) == 0 {
+				t.Errorf("webp decode: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	opts := libnextimage.DefaultAVIFEncodeOptions()
+	opts.Speed = 10
+	if avif, err := p.AVIFEncodeBytes(ctx, data, opts); err != nil || len(avif) == 0 {
+		t.Errorf("avif encode: %v", err)
+	}
+	if _, err := p.WebPEncodeBytes(ctx, []byte("not an image"), libnextimage.DefaultWebPEncodeOptions()); err == nil {
+		t.Error("expected error for invalid input")
+	}
+
+	// The limits of the parent apply in the children
+	defer libnextimage.SetLimits(libnextimage.CurrentLimits())
+	libnextimage.SetLimits(libnextimage.Limits{MaxPixels: 16})
+	if _, err := p.WebPEncodeBytes(ctx, data, libnextimage.DefaultWebPEncodeOptions()); !errors.Is(err, libnextimage.ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+
+	if st := p.Stats(); st.Started > 2 || st.Jobs != 15 || st.Workers != int(st.Started) {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+// TestPool_Types tests the encoder, decoder and command types
+func TestPool_Types(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	p, err := NewPool(Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+
+	enc, err := p.NewWebPEncoder(func(o *libnextimage.WebPEncodeOptions) { o.Quality = 50 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	webp, err := enc.Encode(ctx, data)
+	if err != nil || !bytes.HasPrefix(webp, []byte("RIFF")) {
+		t.Fatalf("webp encoder: %v", err)
+	}
+	dec, err := p.NewWebPDecoder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if img, err := dec.Decode(ctx, webp); err != nil || img.Width == 0 {
+		t.Errorf("webp decoder: %v", err)
+	}
+	if _, err := p.NewAVIFEncoder(func(o *libnextimage.AVIFEncodeOptions) { o.Quality = 200 }); err == nil {
+		t.Error("expected an error for invalid encoder options")
+	}
+
+	cwebp, err := p.NewCWebPCommand(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cwebp.Close()
+	if out, err := cwebp.Run(ctx, data); err != nil || !bytes.HasPrefix(out, []byte("RIFF")) {
+		t.Errorf("cwebp: %v", err)
+	}
+	dwebp, err := p.NewDWebPCommand(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.webp"), webp, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dwebp.RunFile(ctx, filepath.Join(dir, "in.webp"), filepath.Join(dir, "out.png")); err != nil {
+		t.Errorf("dwebp: %v", err)
+	}
+	if png, _ := os.ReadFile(filepath.Join(dir, "out.png")); !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Error("dwebp did not write a PNG")
+	}
+	var out bytes.Buffer
+	if err := dwebp.RunIO(ctx, bytes.NewReader([]byte("not a webp")), &out); err == nil || out.Len() != 0 {
+		t.Errorf("expected an error for invalid input, got %v", err)
+	}
+}
+
+// TestPool_Failures tests that crashed, hung and cancelled jobs kill their child and
+// that the pool recovers
+func TestPool_Failures(t *testing.T) {
+	p, err := NewPool(Options{Workers: 1, Timeout: 200 * time.Millisecond, MaxJobs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	gif := func() error {
+		_, err := p.WebP2GIF(ctx, []byte("not a webp"))
+		return err
+	}
+
+	if _, err := p.run(ctx, &request{Op: "test-crash"}); !errors.Is(err, ErrWorkerCrashed) {
+		t.Errorf("expected ErrWorkerCrashed, got %v", err)
+	}
+	if err := gif(); err == nil || errors.Is(err, ErrWorkerCrashed) {
+		t.Errorf("expected a conversion error after the restart, got %v", err)
+	}
+
+	start := time.Now()
+	if _, err := p.run(ctx, &request{Op: "test-hang"}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hung job took %v to stop", elapsed)
+	}
+
+	cancelled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.run(cancelled, &request{Op: "test-hang"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+
+	// Two jobs on one child, then a restart for MaxJobs
+	for i := 0; i < 3; i++ {
+		if err := gif(); err == nil {
+			t.Error("expected a conversion error")
+		}
+	}
+
+	st := p.Stats()
+	if st.Crashes != 1 || st.Timeouts != 1 || st.Jobs != 4 || st.Started != 5 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	p.Close()
+	if err := gif(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if st := p.Stats(); st.Workers != 0 {
+		t.Errorf("workers left after Close: %+v", st)
+	}
+}
+
+// TestPool_MemoryLimitFailure tests that a child which cannot apply its memory limit
+// exits instead of serving jobs without it
+func TestPool_MemoryLimitFailure(t *testing.T) {
+	t.Setenv(envMaxMemory, "not a number") // Inherited by the children
+	var stderr bytes.Buffer
+	p, err := NewPool(Options{Workers: 1, Stderr: &stderr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	_, err = p.WebP2GIF(context.Background(), []byte("not a webp"))
+	if err == nil || !strings.Contains(err.Error(), "worker did not start") {
+		t.Errorf("expected the worker not to start, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "setting the memory limit") {
+		t.Errorf("expected the reason on stderr, got %q", stderr.String())
+	}
+}
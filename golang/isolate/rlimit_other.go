@@ -0,0 +1,16 @@
+//go:build !(linux || netbsd)
+
+package isolate
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// memoryLimitSupported is false where RLIMIT_AS is missing or, as on macOS, accepted
+// but not enforced
+const memoryLimitSupported = false
+
+func setMemoryLimit(bytes int64) error {
+	return fmt.Errorf("memory limits are not supported on %s", runtime.GOOS)
+}
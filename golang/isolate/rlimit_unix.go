@@ -0,0 +1,13 @@
+//go:build linux || netbsd
+
+package isolate
+
+import "syscall"
+
+// memoryLimitSupported tells whether setMemoryLimit is enforced by the kernel
+const memoryLimitSupported = true
+
+// setMemoryLimit limits the address space of the process
+func setMemoryLimit(bytes int64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: uint64(bytes), Max: uint64(bytes)})
+}
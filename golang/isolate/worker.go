@@ -0,0 +1,202 @@
+package isolate
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// Operations of a request
+const (
+	opWebPEncode = "webp-encode"
+	opAVIFEncode = "avif-encode"
+	opWebPDecode = "webp-decode"
+	opAVIFDecode = "avif-decode"
+	opDecode     = "decode"
+	opGIF2WebP   = "gif2webp"
+	opWebP2GIF   = "webp2gif"
+
+	opCWebP           = "cwebp"
+	opDWebP           = "dwebp"
+	opGif2WebPCommand = "gif2webp-command"
+	opWebP2GifCommand = "webp2gif-command"
+	opAVIFEnc         = "avifenc"
+	opAVIFDec         = "avifdec"
+)
+
+// request is a job sent to a child
+type request struct {
+	Op     string
+	Data   []byte
+	Limits libnextimage.Limits
+
+	WebPEncode *libnextimage.WebPEncodeOptions
+	AVIFEncode *libnextimage.AVIFEncodeOptions
+	WebPDecode *libnextimage.WebPDecodeOptions
+	AVIFDecode *libnextimage.AVIFDecodeOptions
+
+	// Command options; nil for the defaults
+	CWebP    *libnextimage.CWebPOptions
+	DWebP    *libnextimage.DWebPOptions
+	Gif2WebP *libnextimage.Gif2WebPOptions
+	WebP2Gif *libnextimage.WebP2GifOptions
+	AVIFEnc  *libnextimage.AVIFEncOptions
+	AVIFDec  *libnextimage.AVIFDecOptions
+}
+
+// response is the answer of a child. Limit carries a *LimitError across the process
+// boundary so that errors.Is(err, libnextimage.ErrLimitExceeded) still holds.
+type response struct {
+	Data  []byte
+	Image *libnextimage.DecodedImage
+	Err   string
+	Limit *libnextimage.LimitError
+}
+
+func (r *response) err() error {
+	switch {
+	case r.Limit != nil:
+		return r.Limit
+	case r.Err != "":
+		return errors.New(r.Err)
+	}
+	return nil
+}
+
+// handlers runs the operations inside a child
+var handlers = map[string]func(req *request) (*response, error){
+	opWebPEncode: func(req *request) (*response, error) {
+		data, err := libnextimage.WebPEncodeBytes(req.Data, *req.WebPEncode)
+		return &response{Data: data}, err
+	},
+	opAVIFEncode: func(req *request) (*response, error) {
+		data, err := libnextimage.AVIFEncodeBytes(req.Data, *req.AVIFEncode)
+		return &response{Data: data}, err
+	},
+	opWebPDecode: func(req *request) (*response, error) {
+		img, err := libnextimage.WebPDecodeBytes(req.Data, *req.WebPDecode)
+		return &response{Image: img}, err
+	},
+	opAVIFDecode: func(req *request) (*response, error) {
+		img, err := libnextimage.AVIFDecodeBytes(req.Data, *req.AVIFDecode)
+		return &response{Image: img}, err
+	},
+	opDecode: func(req *request) (*response, error) {
+		img, err := libnextimage.DecodeImageBytes(req.Data)
+		return &response{Image: img}, err
+	},
+	opGIF2WebP: func(req *request) (*response, error) {
+		data, err := libnextimage.GIF2WebP(req.Data, *req.WebPEncode)
+		return &response{Data: data}, err
+	},
+	opWebP2GIF: func(req *request) (*response, error) {
+		data, err := libnextimage.WebP2GIF(req.Data)
+		return &response{Data: data}, err
+	},
+	opCWebP: func(req *request) (*response, error) {
+		cmd, err := libnextimage.NewCWebPCommand(req.CWebP)
+		return runCommand(cmd, err, req.Data)
+	},
+	opDWebP: func(req *request) (*response, error) {
+		cmd, err := libnextimage.NewDWebPCommand(req.DWebP)
+		return runCommand(cmd, err, req.Data)
+	},
+	opGif2WebPCommand: func(req *request) (*response, error) {
+		cmd, err := libnextimage.NewGif2WebPCommand(req.Gif2WebP)
+		return runCommand(cmd, err, req.Data)
+	},
+	opWebP2GifCommand: func(req *request) (*response, error) {
+		cmd, err := libnextimage.NewWebP2GifCommand(req.WebP2Gif)
+		return runCommand(cmd, err, req.Data)
+	},
+	opAVIFEnc: func(req *request) (*response, error) {
+		cmd, err := libnextimage.NewAVIFEncCommand(req.AVIFEnc)
+		return runCommand(cmd, err, req.Data)
+	},
+	opAVIFDec: func(req *request) (*response, error) {
+		cmd, err := libnextimage.NewAVIFDecCommand(req.AVIFDec)
+		return runCommand(cmd, err, req.Data)
+	},
+}
+
+// runCommand runs a command created for one job and closes it
+func runCommand[C interface {
+	Run([]byte) ([]byte, error)
+	Close() error
+}](cmd C, err error, data []byte) (*response, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer cmd.Close()
+	out, err := cmd.Run(data)
+	return &response{Data: out}, err
+}
+
+// Main turns the process into a worker when it was started by a Pool, serving jobs
+// until the pool closes its stdin and then exiting. Otherwise it returns at once.
+// It must be called at the top of main, before anything writes to stdout.
+func Main() {
+	if os.Getenv(envWorker) == "" {
+		return
+	}
+	if v := os.Getenv(envMaxMemory); v != "" {
+		// Exit before the magic so that the pool does not run jobs without the limit
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			err = setMemoryLimit(n)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "isolate: setting the memory limit: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "isolate: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// serve answers requests one at a time until r is closed
+func serve(r io.Reader, w io.Writer) error {
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(r)
+	enc := gob.NewEncoder(w)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		resp := handle(&req)
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func handle(req *request) *response {
+	handler, ok := handlers[req.Op]
+	if !ok {
+		return &response{Err: fmt.Sprintf("isolate: unknown operation %q", req.Op)}
+	}
+	libnextimage.SetLimits(req.Limits)
+	resp, err := handler(req)
+	if err != nil {
+		var le *libnextimage.LimitError
+		if errors.As(err, &le) {
+			return &response{Limit: le}
+		}
+		return &response{Err: err.Error()}
+	}
+	return resp
+}
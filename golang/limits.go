@@ -0,0 +1,403 @@
+package libnextimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// ErrLimitExceeded matches every *LimitError with errors.Is
+var ErrLimitExceeded = errors.New("libnextimage: resource limit exceeded")
+
+// Limits bounds the input accepted by every decode and encode entry point. The
+// dimensions and frame count are read from the image header, so an input over a
+// limit is rejected before any pixel buffer is allocated. A zero field means no limit.
+//
+// Frames are counted for animated GIF and WebP. Dimensions are known for PNG, JPEG,
+// GIF, WebP, AVIF and TIFF; other inputs are only checked against MaxInputBytes,
+// except AVIF headers without an image size, which fail the dimension limits.
+type Limits struct {
+	MaxPixels          int64 // Width × height of the image or animation canvas
+	MaxDimension       int   // Width or height
+	MaxFrames          int   // Frames of an animation
+	MaxAnimationPixels int64 // Frames × width × height of an animation
+	MaxInputBytes      int64 // Size of the encoded input
+}
+
+// DefaultLimits returns the limits in effect unless SetLimits is called: 268435456
+// pixels (16384 × 16384) and 32768 pixels per side as for AVIF decoding, 10000 frames
+// and 4 gigapixels per animation, and no input size limit
+func DefaultLimits() Limits {
+	return Limits{
+		MaxPixels:          268435456,
+		MaxDimension:       32768,
+		MaxFrames:          10000,
+		MaxAnimationPixels: 1 << 32,
+	}
+}
+
+var (
+	limitsMu sync.RWMutex
+	limits   = DefaultLimits()
+)
+
+// SetLimits replaces the limits checked by all functions, encoders, decoders and commands
+func SetLimits(l Limits) {
+	limitsMu.Lock()
+	limits = l
+	limitsMu.Unlock()
+}
+
+// CurrentLimits returns the limits set with SetLimits
+func CurrentLimits() Limits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return limits
+}
+
+// LimitError reports an input over one of the Limits
+type LimitError struct {
+	Limit string // Limits field name, e.g. "MaxPixels"
+	Value int64  // Value of the input
+	Max   int64  // Configured limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("input exceeds %s (%d > %d)", e.Limit, e.Value, e.Max)
+}
+
+// Is makes errors.Is(err, ErrLimitExceeded) true
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Check returns a *LimitError if data is over one of the limits
+func (l Limits) Check(data []byte) error {
+	if l.MaxInputBytes > 0 && int64(len(data)) > l.MaxInputBytes {
+		return &LimitError{"MaxInputBytes", int64(len(data)), l.MaxInputBytes}
+	}
+	h, ok := probeHeader(data)
+	if !ok {
+		if isHEIF(data) && (l.MaxPixels > 0 || l.MaxDimension > 0 || l.MaxAnimationPixels > 0) {
+			// The dimensions of an AVIF are only in its header; no header, no decode
+			return fmt.Errorf("no image size in the AVIF header to check the limits: %w", ErrLimitExceeded)
+		}
+		return nil
+	}
+	return l.checkHeader(h)
+}
+
+func (l Limits) checkHeader(h imageHeader) error {
+	pixels := int64(h.width) * int64(h.height)
+	switch {
+	case l.MaxDimension > 0 && max(h.width, h.height) > l.MaxDimension:
+		return &LimitError{"MaxDimension", int64(max(h.width, h.height)), int64(l.MaxDimension)}
+	case l.MaxPixels > 0 && pixels > l.MaxPixels:
+		return &LimitError{"MaxPixels", pixels, l.MaxPixels}
+	case l.MaxFrames > 0 && h.frames > l.MaxFrames:
+		return &LimitError{"MaxFrames", int64(h.frames), int64(l.MaxFrames)}
+	case l.MaxAnimationPixels > 0 && h.frames > 1 && int64(h.frames)*pixels > l.MaxAnimationPixels:
+		return &LimitError{"MaxAnimationPixels", int64(h.frames) * pixels, l.MaxAnimationPixels}
+	}
+	return nil
+}
+
+// checkLimits checks data against the current limits
+func checkLimits(data []byte) error {
+	return CurrentLimits().Check(data)
+}
+
+// checkResize returns a *LimitError if a cwebp -resize target is over MaxPixels or
+// MaxDimension, which the input itself may be well under
+func checkResize(width, height int) error {
+	if !resizeEnabled(width, height) {
+		return nil
+	}
+	l := CurrentLimits()
+	l = Limits{MaxPixels: l.MaxPixels, MaxDimension: l.MaxDimension}
+	return l.checkHeader(imageHeader{width: width, height: height, frames: 1})
+}
+
+// readAllLimited reads r to the end, stopping with a *LimitError past MaxInputBytes.
+// When r knows its length (files, bytes.Reader, ...) the buffer is allocated once
+// instead of growing by copies.
+func readAllLimited(r io.Reader) ([]byte, error) {
+	maxBytes := CurrentLimits().MaxInputBytes
+	size := readerSize(r)
+	if maxBytes > 0 {
+		r = io.LimitReader(r, maxBytes+1)
+		size = min(size, maxBytes+1)
+	}
+	var buf bytes.Buffer
+	if size > 0 && size < math.MaxInt32 {
+		buf.Grow(int(size) + bytes.MinRead)
+	}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return buf.Bytes(), err
+	}
+	if maxBytes > 0 && int64(buf.Len()) > maxBytes {
+		return nil, &LimitError{"MaxInputBytes", int64(buf.Len()), maxBytes}
+	}
+	return buf.Bytes(), nil
+}
+
+// readerSize returns the number of bytes left in r when it can tell, or 0
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case *os.File:
+		fi, err := v.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0
+		}
+		off, err := v.Seek(0, io.SeekCurrent)
+		if err != nil || off > fi.Size() {
+			return 0
+		}
+		return fi.Size() - off
+	}
+	return 0
+}
+
+// imageHeader is what the limits are checked against
+type imageHeader struct {
+	width, height int // Image or animation canvas size
+	frames        int // 1 for still images
+}
+
+// probeHeader reads the dimensions and frame count from the header of an image
+func probeHeader(data []byte) (imageHeader, bool) {
+	if len(data) >= 13 && (string(data[:6]) == "GIF87a" || string(data[:6]) == "GIF89a") {
+		return probeGIF(data)
+	}
+	width, height, ok := probeDimensions(data)
+	if !ok {
+		return imageHeader{}, false
+	}
+	h := imageHeader{width: width, height: height, frames: 1}
+	if len(data) >= 30 && string(data[8:16]) == "WEBPVP8X" && data[20]&0x02 != 0 { // Animation flag
+		h.frames = countWebPFrames(data)
+	}
+	return h, true
+}
+
+// ProbeImageSize reads the dimensions of a PNG, JPEG, GIF, WebP, AVIF or TIFF image from its
+// header without decoding it. ok is false for data it cannot read.
+func ProbeImageSize(data []byte) (width, height int, ok bool) {
+	return probeDimensions(data)
+}
+
+// probeDimensions reads the dimensions of a PNG, JPEG, GIF, WebP, AVIF or TIFF image from its header
+func probeDimensions(data []byte) (width, height int, ok bool) {
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		decodeConfig = png.DecodeConfig
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		decodeConfig = jpeg.DecodeConfig
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		decodeConfig = gif.DecodeConfig
+	}
+	if decodeConfig != nil {
+		if cfg, err := decodeConfig(bytes.NewReader(data)); err == nil {
+			return cfg.Width, cfg.Height, true
+		}
+		return 0, 0, false
+	}
+	if len(data) >= 30 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP" {
+		switch string(data[12:16]) {
+		case "VP8X": // Canvas size, 24 bits each minus one
+			return 1 + (int(data[24]) | int(data[25])<<8 | int(data[26])<<16),
+				1 + (int(data[27]) | int(data[28])<<8 | int(data[29])<<16), true
+		case "VP8 ": // Key frame header after the 3-byte start code, 14 bits each
+			return int(binary.LittleEndian.Uint16(data[26:28]) & 0x3fff),
+				int(binary.LittleEndian.Uint16(data[28:30]) & 0x3fff), true
+		case "VP8L": // Signature byte, then 14 bits each minus one
+			bits := binary.LittleEndian.Uint32(data[21:25])
+			return 1 + int(bits&0x3fff), 1 + int(bits>>14&0x3fff), true
+		}
+		return 0, 0, false
+	}
+	if isHEIF(data) {
+		return probeHEIF(data)
+	}
+	if len(data) >= 8 && (string(data[:4]) == "II*\x00" || string(data[:4]) == "MM\x00*") {
+		return probeTIFF(data)
+	}
+	return 0, 0, false
+}
+
+// isHEIF reports whether data starts with the ftyp box of a HEIF (AVIF) file
+func isHEIF(data []byte) bool {
+	return len(data) >= 12 && string(data[4:8]) == "ftyp"
+}
+
+// probeHEIF reads the item properties in meta/iprp/ipco: the largest 'ispe' (image
+// spatial extents) is the primary image, the others are alpha, gain map or thumbnail
+// items. ok is false until the whole ipco box is in data.
+func probeHEIF(data []byte) (width, height int, ok bool) {
+	meta, ok := findBox(data, "meta")
+	if !ok || len(meta) < 4 {
+		return 0, 0, false
+	}
+	iprp, ok := findBox(meta[4:], "iprp") // meta is a full box: version and flags first
+	if !ok {
+		return 0, 0, false
+	}
+	ipco, ok := findBox(iprp, "ipco")
+	if !ok {
+		return 0, 0, false
+	}
+	for len(ipco) > 0 {
+		typ, body, rest, ok := nextBox(ipco)
+		if !ok {
+			return 0, 0, false
+		}
+		if typ == "ispe" && len(body) >= 12 { // Version and flags, then width and height
+			w := binary.BigEndian.Uint32(body[4:])
+			h := binary.BigEndian.Uint32(body[8:])
+			if uint64(w)*uint64(h) > uint64(width)*uint64(height) {
+				width, height = int(w), int(h)
+			}
+		}
+		ipco = rest
+	}
+	return width, height, width > 0 && height > 0
+}
+
+// findBox returns the body of the first ISO BMFF box of type typ in data
+func findBox(data []byte, typ string) ([]byte, bool) {
+	for len(data) > 0 {
+		t, body, rest, ok := nextBox(data)
+		if !ok {
+			return nil, false
+		}
+		if t == typ {
+			return body, true
+		}
+		data = rest
+	}
+	return nil, false
+}
+
+// nextBox splits the first ISO BMFF box off data. ok is false when it is truncated,
+// or extends to the end of the file (size 0), which only mdat does after the header.
+func nextBox(data []byte) (typ string, body, rest []byte, ok bool) {
+	if len(data) < 8 {
+		return "", nil, nil, false
+	}
+	size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+	if size == 1 { // 64-bit size after the type
+		if len(data) < 16 {
+			return "", nil, nil, false
+		}
+		size, header = binary.BigEndian.Uint64(data[8:]), 16
+	}
+	if size < header || size > uint64(len(data)) {
+		return "", nil, nil, false
+	}
+	return string(data[4:8]), data[header:size], data[size:], true
+}
+
+// probeTIFF reads ImageWidth and ImageLength from the first IFD
+func probeTIFF(data []byte) (width, height int, ok bool) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	ifd := int(order.Uint32(data[4:8]))
+	if ifd < 8 || ifd+2 > len(data) {
+		return 0, 0, false
+	}
+	count := int(order.Uint16(data[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(data) {
+			break
+		}
+		var value int
+		switch order.Uint16(data[entry+2:]) { // Field type
+		case 3: // SHORT
+			value = int(order.Uint16(data[entry+8:]))
+		case 4: // LONG
+			value = int(order.Uint32(data[entry+8:]))
+		}
+		switch order.Uint16(data[entry:]) { // Tag
+		case 256:
+			width = value
+		case 257:
+			height = value
+		}
+	}
+	return width, height, width > 0 && height > 0
+}
+
+// probeGIF walks the GIF blocks without decompressing them, counting the frames.
+// Frames reaching outside the logical screen enlarge it, as in the GIF decoders.
+func probeGIF(data []byte) (imageHeader, bool) {
+	h := imageHeader{
+		width:  int(binary.LittleEndian.Uint16(data[6:8])),
+		height: int(binary.LittleEndian.Uint16(data[8:10])),
+	}
+	pos := 13
+	if data[10]&0x80 != 0 { // Global color table
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+	// skipSubBlocks returns the position after a sequence of data sub-blocks
+	skipSubBlocks := func(pos int) int {
+		for pos < len(data) && data[pos] != 0 {
+			pos += 1 + int(data[pos])
+		}
+		return pos + 1
+	}
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension: label, then sub-blocks
+			pos = skipSubBlocks(pos + 2)
+		case 0x2C: // Image descriptor
+			if pos+10 > len(data) {
+				return h, h.frames > 0
+			}
+			left := int(binary.LittleEndian.Uint16(data[pos+1:]))
+			top := int(binary.LittleEndian.Uint16(data[pos+3:]))
+			h.width = max(h.width, left+int(binary.LittleEndian.Uint16(data[pos+5:])))
+			h.height = max(h.height, top+int(binary.LittleEndian.Uint16(data[pos+7:])))
+			h.frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 { // Local color table
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos = skipSubBlocks(pos + 1) // LZW minimum code size, then image data
+		default: // Trailer or garbage
+			return h, true
+		}
+	}
+	return h, true
+}
+
+// countWebPFrames counts the ANMF chunks of an animated WebP
+func countWebPFrames(data []byte) int {
+	frames := 0
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if string(data[pos:pos+4]) == "ANMF" {
+			frames++
+		}
+		if size < 0 || size > len(data) {
+			break
+		}
+		pos += 8 + size + size&1 // Chunks are padded to an even size
+	}
+	return frames
+}
@@ -0,0 +1,226 @@
+package libnextimage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gifWithFrames builds a GIF header with frames image descriptors of width × height
+// and no pixel data, as a crafted input would
+func gifWithFrames(width, height uint16, frames int) []byte {
+	var b bytes.Buffer
+	b.WriteString("GIF89a")
+	binary.Write(&b, binary.LittleEndian, [2]uint16{width, height})
+	b.Write([]byte{0x80, 0, 0})                   // Global color table of 2 entries
+	b.Write(make([]byte, 6))                      // Color table
+	b.Write([]byte{0x21, 0xF9, 4, 0, 0, 0, 0, 0}) // Graphic control extension
+	for i := 0; i < frames; i++ {
+		b.WriteByte(0x2C)
+		binary.Write(&b, binary.LittleEndian, [4]uint16{0, 0, width, height})
+		b.Write([]byte{0, 2, 2, 0x4C, 0x01, 0}) // Flags, code size, one sub-block
+	}
+	b.WriteByte(0x3B)
+	return b.Bytes()
+}
+
+// box builds an ISO BMFF box
+func box(typ string, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	b = append(b, typ...)
+	return append(b, data...)
+}
+
+// heifWithExtents builds an AVIF header with an ispe property of each size, after an
+// mdat holding a decoy ispe
+func heifWithExtents(sizes ...[2]uint32) []byte {
+	var ipco [][]byte
+	for _, size := range sizes {
+		ipco = append(ipco, box("ispe", make([]byte, 4), binary.BigEndian.AppendUint32(nil, size[0]), binary.BigEndian.AppendUint32(nil, size[1])))
+	}
+	decoy := box("ispe", make([]byte, 4), []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("avif"), make([]byte, 4), []byte("avifmif1")),
+		box("mdat", decoy),
+		box("meta", make([]byte, 4), box("hdlr", make([]byte, 24)), box("iprp", box("ipco", ipco...))),
+	}, nil)
+}
+
+// TestLimits tests the header checks against each limit
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		limits Limits
+		limit  string // Expected LimitError.Limit, or "" for no error
+	}{
+		{"small gif", gifWithFrames(100, 100, 1), DefaultLimits(), ""},
+		{"huge gif", gifWithFrames(65535, 65535, 1), DefaultLimits(), "MaxDimension"},
+		{"wide gif", gifWithFrames(30000, 30000, 1), DefaultLimits(), "MaxPixels"},
+		{"many frames", gifWithFrames(10, 10, 20), Limits{MaxFrames: 10}, "MaxFrames"},
+		{"animation pixels", gifWithFrames(1000, 1000, 50), Limits{MaxAnimationPixels: 10000000}, "MaxAnimationPixels"},
+		{"input bytes", gifWithFrames(10, 10, 1), Limits{MaxInputBytes: 10}, "MaxInputBytes"},
+		{"no limits", gifWithFrames(65535, 65535, 100), Limits{}, ""},
+		{"unknown data", []byte("not an image"), Limits{MaxPixels: 1}, ""},
+		{"small avif", heifWithExtents([2]uint32{100, 100}, [2]uint32{50, 50}), DefaultLimits(), ""},
+		{"huge avif", heifWithExtents([2]uint32{10, 10}, [2]uint32{40000, 100}), DefaultLimits(), "MaxDimension"},
+	}
+	for _, tt := range tests {
+		err := tt.limits.Check(tt.data)
+		var le *LimitError
+		switch {
+		case tt.limit == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.limit != "" && (!errors.As(err, &le) || le.Limit != tt.limit):
+			t.Errorf("%s: expected %s error, got %v", tt.name, tt.limit, err)
+		case tt.limit != "" && !errors.Is(err, ErrLimitExceeded):
+			t.Errorf("%s: error does not match ErrLimitExceeded", tt.name)
+		}
+	}
+
+	// AVIF headers without an image size are rejected unless no dimension is limited
+	truncated := heifWithExtents([2]uint32{100, 100})
+	for name, data := range map[string][]byte{
+		"no meta":   box("ftyp", []byte("avif"), make([]byte, 4)),
+		"no ispe":   heifWithExtents(),
+		"truncated": truncated[:len(truncated)-4],
+	} {
+		if _, _, ok := probeDimensions(data); ok {
+			t.Errorf("%s: unexpected dimensions", name)
+		}
+		if err := DefaultLimits().Check(data); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: expected ErrLimitExceeded, got %v", name, err)
+		}
+		if err := (Limits{MaxFrames: 10}).Check(data); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+
+	// Frames of real files
+	for _, tt := range []struct {
+		path   string
+		frames int
+	}{
+		{filepath.Join(testdataDir, "gif-source", "animated-3frames.gif"), 3},
+		{filepath.Join(testdataDir, "webp-samples", "alpha-gradient.webp"), 1},
+	} {
+		data, err := os.ReadFile(tt.path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", tt.path, err)
+		}
+		if h, ok := probeHeader(data); !ok || h.frames != tt.frames {
+			t.Errorf("%s: got %d frames (%v), expected %d", tt.path, h.frames, ok, tt.frames)
+		}
+	}
+}
+
+// TestLimits_EntryPoints tests that the encoders, decoders and commands reject inputs
+// over the current limits before decoding them
+func TestLimits_EntryPoints(t *testing.T) {
+	png, err := os.ReadFile(filepath.Join(testdataDir, "source", "sizes", "medium-512x512.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	webp, err := WebPEncodeBytes(png, DefaultWebPEncodeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer SetLimits(CurrentLimits())
+	SetLimits(Limits{MaxPixels: 256 * 256})
+
+	checks := map[string]func() error{
+		"WebPEncodeBytes":  func() error { _, err := WebPEncodeBytes(png, DefaultWebPEncodeOptions()); return err },
+		"AVIFEncodeBytes":  func() error { _, err := AVIFEncodeBytes(png, DefaultAVIFEncodeOptions()); return err },
+		"WebPDecodeBytes":  func() error { _, err := WebPDecodeBytes(webp, DefaultWebPDecodeOptions()); return err },
+		"DecodeImageBytes": func() error { _, err := DecodeImageBytes(png); return err },
+		"CWebPCommand": func() error {
+			cmd, err := NewCWebPCommand(nil)
+			if err != nil {
+				return err
+			}
+			defer cmd.Close()
+			_, err = cmd.Run(png)
+			return err
+		},
+		"GIF2WebP": func() error { _, err := GIF2WebP(gifWithFrames(1000, 1000, 1), DefaultWebPEncodeOptions()); return err },
+	}
+	for name, check := range checks {
+		if err := check(); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: expected ErrLimitExceeded, got %v", name, err)
+		}
+	}
+
+	SetLimits(Limits{MaxInputBytes: 100})
+	if _, err := readAllLimited(strings.NewReader(strings.Repeat("x", 101))); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+	if data, err := readAllLimited(strings.NewReader(strings.Repeat("x", 100))); err != nil || len(data) != 100 {
+		t.Errorf("expected 100 bytes, got %d (%v)", len(data), err)
+	}
+
+	SetLimits(DefaultLimits())
+	if _, err := WebPEncodeBytes(png, DefaultWebPEncodeOptions()); err != nil {
+		t.Errorf("default limits rejected a 512x512 image: %v", err)
+	}
+}
+
+// TestLimits_Resize tests that a resize target over the limits is rejected even when
+// the input is under them
+func TestLimits_Resize(t *testing.T) {
+	png, err := os.ReadFile(filepath.Join(testdataDir, "source", "sizes", "medium-512x512.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	defer SetLimits(CurrentLimits())
+	SetLimits(Limits{MaxPixels: 1024 * 1024, MaxDimension: 2048})
+
+	webpOpts := DefaultWebPEncodeOptions()
+	webpOpts.ResizeWidth, webpOpts.ResizeHeight = 2048, 2048
+	source, err := NewEncodeSource(png)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := map[string]func() error{
+		"WebPEncodeBytes": func() error { _, err := WebPEncodeBytes(png, webpOpts); return err },
+		"WebPEncoder": func() error {
+			encoder, err := NewWebPEncoder(func(o *WebPEncodeOptions) { *o = webpOpts })
+			if err != nil {
+				return err
+			}
+			defer encoder.Close()
+			_, err = encoder.Encode(png)
+			return err
+		},
+		"CWebPCommand": func() error {
+			opts := NewDefaultCWebPOptions()
+			opts.ResizeWidth, opts.ResizeHeight = 4096, 16
+			cmd, err := NewCWebPCommand(&opts)
+			if err != nil {
+				return err
+			}
+			defer cmd.Close()
+			_, err = cmd.Run(png)
+			return err
+		},
+		"EncodeJob": func() error {
+			return source.Encode(EncodeJob{Format: ImageFormatWebP, WebP: DefaultWebPEncodeOptions(), Width: 2048}).Err
+		},
+	}
+	for name, check := range checks {
+		var limitErr *LimitError
+		if err := check(); !errors.As(err, &limitErr) {
+			t.Errorf("%s: expected a *LimitError, got %v", name, err)
+		}
+	}
+
+	if out := source.Encode(EncodeJob{Format: ImageFormatWebP, WebP: DefaultWebPEncodeOptions(), Width: 1024}); out.Err != nil {
+		t.Errorf("1024x1024 resize rejected: %v", out.Err)
+	}
+}
@@ -0,0 +1,290 @@
+package libnextimage
+
+/*
+#include "nextimage.h"
+#include "webp.h"
+#include "avif.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// EncodeJob describes one output produced from a shared EncodeSource.
+// Only the options matching Format are used.
+type EncodeJob struct {
+	Name   string      // Name used in outputs and errors, e.g. "avif-640w"
+	Format ImageFormat // ImageFormatWebP or ImageFormatAVIF
+	WebP   WebPEncodeOptions
+	AVIF   AVIFEncodeOptions
+
+	// Resize the source before encoding. One of Width and Height may be 0 to keep
+	// the aspect ratio; both 0 encodes at the source size.
+	// Jobs requesting the same size share one resized image.
+	Width  int
+	Height int
+}
+
+// EncodeOutput is the result of one EncodeJob
+type EncodeOutput struct {
+	Name     string
+	Format   ImageFormat
+	Data     []byte        // Encoded image, nil if Err is set
+	Width    int           // Width of the image passed to the encoder
+	Height   int           // Height of the image passed to the encoder
+	Duration time.Duration // Time spent encoding (shared decode and resize are not included)
+	Err      error
+}
+
+// MultiEncodeOptions controls how EncodeAll and MultiEncode run their jobs
+type MultiEncodeOptions struct {
+	// Parallelism is the maximum number of jobs encoded at the same time.
+	// 0 or 1 runs the jobs one after another, -1 uses runtime.GOMAXPROCS(0).
+	Parallelism int
+}
+
+// EncodeSource is an image decoded once and encoded many times.
+// It is safe for concurrent use.
+type EncodeSource struct {
+	image *DecodedImage // Packed RGBA 8-bit
+
+	mu      sync.Mutex
+	resized map[[2]int]*resizedSource
+}
+
+// resizedSource is a lazily computed resize of the source, shared by jobs of the same size
+type resizedSource struct {
+	once  sync.Once
+	image *DecodedImage
+	err   error
+}
+
+// NewEncodeSource decodes image file data (PNG, JPEG, TIFF, GIF, WebP or AVIF) once for multiple encodes.
+// The source is decoded to 8-bit RGBA with the same reader the encoders use, so 16-bit
+// PNG/TIFF input is reduced to 8 bits; use AVIFEncodeBytes for high bit depth AVIF output.
+func NewEncodeSource(imageFileData []byte) (*EncodeSource, error) {
+	img, err := DecodeImageBytes(imageFileData)
+	if err != nil {
+		return nil, fmt.Errorf("encode source: %w", err)
+	}
+	return NewEncodeSourceFromImage(img)
+}
+
+// NewEncodeSourceFromImage creates a source from decoded 8-bit RGBA, RGB or BGRA pixels.
+// Packed RGBA data is used without copying and must not be modified while the source is in use.
+func NewEncodeSourceFromImage(img *DecodedImage) (*EncodeSource, error) {
+	data, err := packedRGBA(img)
+	if err != nil {
+		return nil, fmt.Errorf("encode source: %w", err)
+	}
+	return &EncodeSource{
+		image: &DecodedImage{
+			Data:     data,
+			Stride:   img.Width * 4,
+			Width:    img.Width,
+			Height:   img.Height,
+			BitDepth: 8,
+			Format:   FormatRGBA,
+		},
+		resized: make(map[[2]int]*resizedSource),
+	}, nil
+}
+
+// Width returns the source width
+func (s *EncodeSource) Width() int {
+	return s.image.Width
+}
+
+// Height returns the source height
+func (s *EncodeSource) Height() int {
+	return s.image.Height
+}
+
+// sized returns the source resized to width x height, computing each size only once
+func (s *EncodeSource) sized(width, height int) (*DecodedImage, error) {
+	if width == 0 && height == 0 {
+		return s.image, nil
+	}
+	// A 0 side keeps the aspect ratio, rounded as in WebPPictureRescale
+	w, h := int64(width), int64(height)
+	if w == 0 {
+		w = (int64(s.image.Width)*h + int64(s.image.Height)/2) / int64(s.image.Height)
+	}
+	if h == 0 {
+		h = (int64(s.image.Height)*w + int64(s.image.Width)/2) / int64(s.image.Width)
+	}
+	if err := checkResize(int(w), int(h)); err != nil {
+		return nil, err
+	}
+
+	key := [2]int{width, height}
+	s.mu.Lock()
+	r, ok := s.resized[key]
+	if !ok {
+		r = &resizedSource{}
+		s.resized[key] = r
+	}
+	s.mu.Unlock()
+
+	r.once.Do(func() {
+		r.image, r.err = resizeRGBA(s.image, width, height)
+	})
+	return r.image, r.err
+}
+
+// resizeRGBA resizes packed RGBA pixels with libwebp's rescaler (as cwebp -resize does)
+func resizeRGBA(img *DecodedImage, width, height int) (*DecodedImage, error) {
+	clearError()
+
+	var output C.NextImageDecodeBuffer
+	status := C.nextimage_image_resize_alloc(
+		(*C.uint8_t)(unsafe.Pointer(&img.Data[0])),
+		C.int(img.Width),
+		C.int(img.Height),
+		C.int(img.Stride),
+		C.int(width),
+		C.int(height),
+		&output,
+	)
+	if status != C.NEXTIMAGE_OK {
+		return nil, makeError(status, "resize")
+	}
+	defer freeDecodeBuffer(&output)
+
+	return convertDecodeBuffer(&output), nil
+}
+
+// encodeRGBA encodes packed RGBA pixels with the job's format and options
+func encodeRGBA(img *DecodedImage, job *EncodeJob) ([]byte, error) {
+	clearError()
+
+	var output C.NextImageBuffer
+	var status C.NextImageStatus
+	var operation string
+	switch job.Format {
+	case ImageFormatWebP:
+		operation = "webp encode"
+		copts := convertEncodeOptions(job.WebP)
+		status = C.nextimage_webp_encode_rgba_alloc(
+			(*C.uint8_t)(unsafe.Pointer(&img.Data[0])),
+			C.int(img.Width),
+			C.int(img.Height),
+			C.int(img.Stride),
+			&copts,
+			&output,
+		)
+	case ImageFormatAVIF:
+		operation = "avif encode"
+		copts := newCAVIFEncodeOptions(job.AVIF)
+		defer copts.free()
+		status = C.nextimage_avif_encode_rgba_alloc(
+			(*C.uint8_t)(unsafe.Pointer(&img.Data[0])),
+			C.int(img.Width),
+			C.int(img.Height),
+			C.int(img.Stride),
+			&copts.opts,
+			&output,
+		)
+	default:
+		return nil, fmt.Errorf("unsupported format %s", job.Format)
+	}
+
+	if status != C.NEXTIMAGE_OK {
+		return nil, makeError(status, operation)
+	}
+
+	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	freeEncodeBuffer(&output)
+	return result, nil
+}
+
+// validate checks the job before any work is done
+func (job *EncodeJob) validate() error {
+	if job.Format != ImageFormatWebP && job.Format != ImageFormatAVIF {
+		return fmt.Errorf("unsupported format %s", job.Format)
+	}
+	if job.Width < 0 || job.Height < 0 {
+		return fmt.Errorf("invalid resize %dx%d", job.Width, job.Height)
+	}
+	return nil
+}
+
+// Encode runs a single job against the source
+func (s *EncodeSource) Encode(job EncodeJob) EncodeOutput {
+	out := EncodeOutput{Name: job.Name, Format: job.Format}
+	if err := job.validate(); err != nil {
+		out.Err = err
+		return out
+	}
+
+	img, err := s.sized(job.Width, job.Height)
+	if err != nil {
+		out.Err = err
+		return out
+	}
+	out.Width = img.Width
+	out.Height = img.Height
+
+	start := time.Now()
+	out.Data, out.Err = encodeRGBA(img, &job)
+	out.Duration = time.Since(start)
+	return out
+}
+
+// EncodeAll runs all jobs against the source and returns their outputs in job order.
+// Every job runs even when others fail; the returned error joins the failures.
+func (s *EncodeSource) EncodeAll(jobs []EncodeJob, opts MultiEncodeOptions) ([]EncodeOutput, error) {
+	parallelism := opts.Parallelism
+	if parallelism < 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	outputs := make([]EncodeOutput, len(jobs))
+	if parallelism == 1 {
+		for i := range jobs {
+			outputs[i] = s.Encode(jobs[i])
+		}
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, parallelism)
+		for i := range jobs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				outputs[i] = s.Encode(jobs[i])
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	var errs []error
+	for i, out := range outputs {
+		if out.Err != nil {
+			errs = append(errs, fmt.Errorf("job %d (%s): %w", i, out.Name, out.Err))
+		}
+	}
+	return outputs, errors.Join(errs...)
+}
+
+// MultiEncode decodes image file data once and encodes it with every job.
+// Outputs are returned in job order even when some jobs fail; see EncodeSource.EncodeAll.
+func MultiEncode(imageFileData []byte, jobs []EncodeJob, opts MultiEncodeOptions) ([]EncodeOutput, error) {
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("multi encode: no jobs")
+	}
+	source, err := NewEncodeSource(imageFileData)
+	if err != nil {
+		return nil, err
+	}
+	return source.EncodeAll(jobs, opts)
+}
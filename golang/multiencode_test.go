@@ -0,0 +1,141 @@
+package libnextimage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMultiEncode tests encoding WebP and AVIF at several sizes from one decode
+func TestMultiEncode(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	webpOpts := DefaultWebPEncodeOptions()
+	avifOpts := DefaultAVIFEncodeOptions()
+	avifOpts.Speed = 10
+
+	jobs := []EncodeJob{
+		{Name: "webp", Format: ImageFormatWebP, WebP: webpOpts},
+		{Name: "avif", Format: ImageFormatAVIF, AVIF: avifOpts},
+		{Name: "webp-64w", Format: ImageFormatWebP, WebP: webpOpts, Width: 64},
+		{Name: "avif-64w", Format: ImageFormatAVIF, AVIF: avifOpts, Width: 64},
+		{Name: "webp-32x32", Format: ImageFormatWebP, WebP: webpOpts, Width: 32, Height: 32},
+	}
+
+	outputs, err := MultiEncode(data, jobs, MultiEncodeOptions{Parallelism: -1})
+	if err != nil {
+		t.Fatalf("MultiEncode failed: %v", err)
+	}
+	if len(outputs) != len(jobs) {
+		t.Fatalf("expected %d outputs, got %d", len(jobs), len(outputs))
+	}
+
+	source, err := DecodeImageBytes(data)
+	if err != nil {
+		t.Fatalf("DecodeImageBytes failed: %v", err)
+	}
+
+	for i, out := range outputs {
+		if out.Name != jobs[i].Name || out.Format != jobs[i].Format {
+			t.Errorf("output %d is %s/%s, expected job order", i, out.Name, out.Format)
+		}
+		decoded, err := DecodeImageBytes(out.Data)
+		if err != nil {
+			t.Fatalf("%s: decode failed: %v", out.Name, err)
+		}
+		if decoded.Width != out.Width || decoded.Height != out.Height {
+			t.Errorf("%s: decoded %dx%d, reported %dx%d", out.Name, decoded.Width, decoded.Height, out.Width, out.Height)
+		}
+		t.Logf("  %-10s %dx%d %6d bytes %v", out.Name, out.Width, out.Height, len(out.Data), out.Duration)
+	}
+
+	// Resizing keeps the aspect ratio when one dimension is 0
+	expectedHeight := source.Height * 64 / source.Width
+	if outputs[2].Width != 64 || outputs[2].Height < expectedHeight || outputs[2].Height > expectedHeight+1 {
+		t.Errorf("unexpected resize %dx%d from %dx%d", outputs[2].Width, outputs[2].Height, source.Width, source.Height)
+	}
+	if outputs[4].Width != 32 || outputs[4].Height != 32 {
+		t.Errorf("expected 32x32, got %dx%d", outputs[4].Width, outputs[4].Height)
+	}
+
+	// Full-size outputs match the single-encode APIs for 8-bit input
+	webpData, err := WebPEncodeBytes(data, webpOpts)
+	if err != nil {
+		t.Fatalf("WebPEncodeBytes failed: %v", err)
+	}
+	if !bytes.Equal(outputs[0].Data, webpData) {
+		t.Errorf("WebP output differs from WebPEncodeBytes (%d vs %d bytes)", len(outputs[0].Data), len(webpData))
+	}
+	avifData, err := AVIFEncodeBytes(data, avifOpts)
+	if err != nil {
+		t.Fatalf("AVIFEncodeBytes failed: %v", err)
+	}
+	if !bytes.Equal(outputs[1].Data, avifData) {
+		t.Errorf("AVIF output differs from AVIFEncodeBytes (%d vs %d bytes)", len(outputs[1].Data), len(avifData))
+	}
+
+	t.Logf("✓ %d outputs from one decode", len(outputs))
+}
+
+// TestEncodeSource_Errors tests that failing jobs are reported without stopping the others
+func TestEncodeSource_Errors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "alpha", "alpha-gradient.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	source, err := NewEncodeSource(data)
+	if err != nil {
+		t.Fatalf("NewEncodeSource failed: %v", err)
+	}
+
+	gainMap := DefaultAVIFEncodeOptions()
+	gainMap.GainMapMode = GainMapFromHDR
+
+	outputs, err := source.EncodeAll([]EncodeJob{
+		{Name: "webp", Format: ImageFormatWebP, WebP: DefaultWebPEncodeOptions(), Width: 100},
+		{Name: "no-format"},
+		{Name: "gain-map", Format: ImageFormatAVIF, AVIF: gainMap},
+		{Name: "negative", Format: ImageFormatWebP, WebP: DefaultWebPEncodeOptions(), Width: -1},
+	}, MultiEncodeOptions{})
+	if err == nil {
+		t.Fatal("expected an error for the failing jobs")
+	}
+	if outputs[0].Err != nil || len(outputs[0].Data) == 0 {
+		t.Errorf("valid job failed: %v", outputs[0].Err)
+	}
+	for _, out := range outputs[1:] {
+		if out.Err == nil {
+			t.Errorf("%s: expected an error", out.Name)
+		}
+	}
+
+	// Alpha is kept through the shared decode
+	decoded, err := WebPDecodeBytes(outputs[0].Data, DefaultWebPDecodeOptions())
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	hasAlpha := false
+	for i := 3; i < len(decoded.Data); i += 4 {
+		if decoded.Data[i] != 255 {
+			hasAlpha = true
+			break
+		}
+	}
+	if !hasAlpha {
+		t.Error("expected transparency in the resized WebP")
+	}
+
+	if _, err := MultiEncode(data, nil, MultiEncodeOptions{}); err == nil {
+		t.Error("expected error for no jobs")
+	}
+	if _, err := MultiEncode([]byte("not an image"), []EncodeJob{{Format: ImageFormatWebP}}, MultiEncodeOptions{}); err == nil {
+		t.Error("expected error for undecodable input")
+	}
+
+	t.Logf("✓ errors reported: %v", err)
+}
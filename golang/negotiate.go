@@ -0,0 +1,482 @@
+package libnextimage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NegotiateOptions configures NegotiateHandler
+type NegotiateOptions struct {
+	// Formats offered to clients in order of preference (default: AVIF, then WebP).
+	// A format is used only when the Accept header lists its media type explicitly;
+	// wildcards such as image/* do not count.
+	Formats []ImageFormat
+
+	WebP WebPEncodeOptions // Options for WebP variants (and GIF to animated WebP)
+	AVIF AVIFEncodeOptions // Options for AVIF variants
+
+	// CacheDir stores encoded variants on disk, keyed by the upstream body and the options.
+	// Empty disables the cache.
+	CacheDir string
+
+	// MaxBodySize is the largest upstream response that is transcoded, in bytes.
+	// Larger responses are streamed through unchanged. 0 means 32 MiB.
+	MaxBodySize int64
+
+	// OnError is called when a variant cannot be produced; the original is served instead.
+	OnError func(r *http.Request, err error)
+}
+
+// DefaultNegotiateOptions returns AVIF and WebP at the default encoder settings without a disk cache
+func DefaultNegotiateOptions() NegotiateOptions {
+	return NegotiateOptions{
+		Formats:     []ImageFormat{ImageFormatAVIF, ImageFormatWebP},
+		WebP:        DefaultWebPEncodeOptions(),
+		AVIF:        DefaultAVIFEncodeOptions(),
+		MaxBodySize: 32 << 20,
+	}
+}
+
+// negotiableTypes are the upstream content types that can be transcoded, with the formats each supports
+var negotiableTypes = map[string][]ImageFormat{
+	"image/jpeg": {ImageFormatAVIF, ImageFormatWebP},
+	"image/png":  {ImageFormatAVIF, ImageFormatWebP},
+	"image/gif":  {ImageFormatWebP}, // Animated WebP via gif2webp
+}
+
+// PreferredImageFormat picks the format to serve for an Accept header.
+// Candidates are in server preference order; the client's q-values take precedence.
+// It returns ImageFormatOriginal when the client accepts none of the candidates.
+func PreferredImageFormat(accept string, candidates ...ImageFormat) ImageFormat {
+	best := ImageFormatOriginal
+	bestQ := 0.0
+	for _, format := range candidates {
+		q := acceptQuality(accept, format.MIMEType())
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value the Accept header gives an explicitly listed media type, 0 if absent
+func acceptQuality(accept, mediaType string) float64 {
+	if mediaType == "" {
+		return 0
+	}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(name, "q") {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		return q
+	}
+	return 0
+}
+
+// addVary adds a header name to Vary unless already present
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// mediaType returns the lower-case media type of a Content-Type header
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// negotiateHandler serves AVIF/WebP variants of upstream JPEG, PNG and GIF responses
+type negotiateHandler struct {
+	next    http.Handler
+	opts    NegotiateOptions
+	digests map[ImageFormat]string // Options digest per format, part of cache keys and ETags
+	flights flightGroup            // Concurrent requests for the same variant share one encode
+}
+
+// NegotiateHandler wraps an upstream handler and transcodes its JPEG, PNG and GIF responses
+// to the best format the client accepts. Responses carry Vary: Accept, the variant's
+// Content-Type and an ETag derived from the upstream body and encoder options.
+// The original is served when the client accepts no variant, the variant is not smaller,
+// or encoding fails.
+func NegotiateHandler(next http.Handler, opts NegotiateOptions) http.Handler {
+	if len(opts.Formats) == 0 {
+		opts.Formats = []ImageFormat{ImageFormatAVIF, ImageFormatWebP}
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = 32 << 20
+	}
+	return &negotiateHandler{
+		next: next,
+		opts: opts,
+		digests: map[ImageFormat]string{
+			ImageFormatWebP: optionsDigest(opts.WebP),
+			ImageFormatAVIF: optionsDigest(opts.AVIF),
+		},
+	}
+}
+
+// NegotiateFileServer serves files from root with AVIF/WebP negotiation.
+// This is sugar syntax over NegotiateHandler(http.FileServer(root), opts).
+func NegotiateFileServer(root http.FileSystem, opts NegotiateOptions) http.Handler {
+	return NegotiateHandler(http.FileServer(root), opts)
+}
+
+// optionsDigest fingerprints encoder options for cache keys
+func optionsDigest(opts interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", opts)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (h *negotiateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	format := PreferredImageFormat(r.Header.Get("Accept"), h.opts.Formats...)
+	if format == ImageFormatOriginal {
+		// The response still depends on Accept for clients that do accept a variant
+		h.next.ServeHTTP(&varyWriter{ResponseWriter: w}, r)
+		return
+	}
+
+	accept := r.Header.Get("Accept")
+	transcodes := func(mt string) bool {
+		return PreferredImageFormat(accept, h.offeredFormats(mt)...) != ImageFormatOriginal
+	}
+
+	// The request goes upstream unchanged; only a partial response of an image that will be
+	// transcoded is fetched again in full, as ranges and validators refer to the original
+	upstream := r.Clone(r.Context())
+	upstream.Method = http.MethodGet
+	bw := &bufferingWriter{w: w, header: make(http.Header), limit: h.opts.MaxBodySize, transcodes: transcodes}
+	h.next.ServeHTTP(bw, upstream)
+	if bw.partial {
+		for _, name := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+			upstream.Header.Del(name)
+		}
+		bw = &bufferingWriter{w: w, header: make(http.Header), limit: h.opts.MaxBodySize, transcodes: transcodes}
+		h.next.ServeHTTP(bw, upstream)
+	}
+	if !bw.finish() {
+		return // Already streamed through
+	}
+
+	body := bw.buf.Bytes()
+	mt := mediaType(bw.header.Get("Content-Type"))
+	if !formatSupported(mt, format) {
+		format = PreferredImageFormat(accept, h.offeredFormats(mt)...)
+	}
+
+	if format != ImageFormatOriginal {
+		data, etag, err := h.variant(body, mt, format)
+		if err == nil && len(data) < len(body) {
+			header := w.Header()
+			copyHeader(header, bw.header)
+			header.Set("Content-Type", format.MIMEType())
+			header.Set("Content-Length", strconv.Itoa(len(data)))
+			header.Set("ETag", etag)
+			header.Del("Accept-Ranges")
+			header.Del("Content-Range")
+			h.respond(w, r, http.StatusOK, data)
+			return
+		}
+		if err != nil && h.opts.OnError != nil {
+			h.opts.OnError(r, err)
+		}
+	}
+
+	copyHeader(w.Header(), bw.header)
+	h.respond(w, r, bw.status, body)
+}
+
+// respond writes the response, answering a matching If-None-Match with 304
+func (h *negotiateHandler) respond(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	addVary(w.Header(), "Accept")
+	if etag := w.Header().Get("ETag"); etag != "" && status == http.StatusOK && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(body)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches the ETag (weak comparison)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSupported reports whether a content type can be transcoded to the format
+func formatSupported(mt string, format ImageFormat) bool {
+	for _, f := range negotiableTypes[mt] {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// offeredFormats returns the configured formats a content type can be transcoded to, in
+// the configured order of preference
+func (h *negotiateHandler) offeredFormats(mt string) []ImageFormat {
+	var formats []ImageFormat
+	for _, f := range h.opts.Formats {
+		if formatSupported(mt, f) {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// variant returns the encoded variant and its ETag, using the disk cache when configured
+func (h *negotiateHandler) variant(body []byte, mt string, format ImageFormat) ([]byte, string, error) {
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:]) + "-" + h.digests[format]
+	etag := `"` + key[:32] + "-" + format.String() + `"`
+
+	var cachePath string
+	if h.opts.CacheDir != "" {
+		cachePath = filepath.Join(h.opts.CacheDir, key[:2], key+"."+format.String())
+		if data, err := os.ReadFile(cachePath); err == nil {
+			return data, etag, nil
+		}
+	}
+
+	data, err := h.flights.do(key+"."+format.String(), func() ([]byte, error) {
+		var data []byte
+		var err error
+		switch {
+		case mt == "image/gif":
+			data, err = GIF2WebPEncodeBytes(body, h.opts.WebP)
+		case format == ImageFormatWebP:
+			data, err = WebPEncodeBytes(body, h.opts.WebP)
+		default:
+			data, err = AVIFEncodeBytes(body, h.opts.AVIF)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if cachePath != "" {
+			if err := writeFileAtomic(cachePath, data); err != nil {
+				return nil, fmt.Errorf("negotiate: cache: %w", err)
+			}
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return data, etag, nil
+}
+
+// flightGroup runs one call per key at a time; callers arriving while it runs wait
+// for and share its result instead of repeating the work
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func (g *flightGroup) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.data, c.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.data, c.err = fn()
+	return c.data, c.err
+}
+
+// writeFileAtomic writes data through a temporary file so readers never see partial content
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyHeader copies all header values from src to dst
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append([]string(nil), values...)
+	}
+}
+
+// varyWriter adds Vary: Accept to negotiable image responses that are passed through
+type varyWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (v *varyWriter) WriteHeader(status int) {
+	if !v.wroteHeader {
+		v.wroteHeader = true
+		if _, ok := negotiableTypes[mediaType(v.Header().Get("Content-Type"))]; ok {
+			addVary(v.Header(), "Accept")
+		}
+	}
+	v.ResponseWriter.WriteHeader(status)
+}
+
+func (v *varyWriter) Write(p []byte) (int, error) {
+	if !v.wroteHeader {
+		if v.Header().Get("Content-Type") == "" {
+			v.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		v.WriteHeader(http.StatusOK)
+	}
+	return v.ResponseWriter.Write(p)
+}
+
+// bufferingWriter captures an upstream image response that will be transcoded and streams
+// anything else through. A partial response of such an image is discarded for a full fetch.
+type bufferingWriter struct {
+	w          http.ResponseWriter
+	header     http.Header
+	limit      int64
+	transcodes func(mt string) bool // Whether a content type will be transcoded for the client
+
+	status      int
+	wroteHeader bool
+	passthrough bool
+	partial     bool
+	buf         bytes.Buffer
+}
+
+func (b *bufferingWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferingWriter) WriteHeader(status int) {
+	if b.wroteHeader {
+		return
+	}
+	b.wroteHeader = true
+	b.status = status
+
+	transcodes := b.header.Get("Content-Encoding") == "" && b.transcodes(mediaType(b.header.Get("Content-Type")))
+	switch {
+	case transcodes && status == http.StatusPartialContent:
+		b.partial = true
+	case !transcodes || status != http.StatusOK:
+		b.startPassthrough()
+	}
+}
+
+func (b *bufferingWriter) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		if b.header.Get("Content-Type") == "" {
+			b.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		b.WriteHeader(http.StatusOK)
+	}
+	if b.passthrough {
+		return b.w.Write(p)
+	}
+	if b.partial {
+		return len(p), nil
+	}
+	if int64(b.buf.Len()+len(p)) > b.limit {
+		b.startPassthrough()
+		return b.w.Write(p)
+	}
+	return b.buf.Write(p)
+}
+
+// startPassthrough sends the captured header and any buffered body to the client
+func (b *bufferingWriter) startPassthrough() {
+	b.passthrough = true
+	header := b.w.Header()
+	copyHeader(header, b.header)
+	if _, ok := negotiableTypes[mediaType(b.header.Get("Content-Type"))]; ok {
+		addVary(header, "Accept")
+	}
+	b.w.WriteHeader(b.status)
+	if b.buf.Len() > 0 {
+		_, _ = b.w.Write(b.buf.Bytes())
+		b.buf.Reset()
+	}
+}
+
+// finish completes the upstream response and reports whether the body was buffered for transcoding
+func (b *bufferingWriter) finish() bool {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	return !b.passthrough
+}
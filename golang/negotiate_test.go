@@ -0,0 +1,269 @@
+package libnextimage
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestPreferredImageFormat tests Accept header parsing
+func TestPreferredImageFormat(t *testing.T) {
+	both := []ImageFormat{ImageFormatAVIF, ImageFormatWebP}
+	tests := []struct {
+		accept   string
+		expected ImageFormat
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", ImageFormatAVIF},
+		{"image/webp,*/*", ImageFormatWebP},
+		{"image/avif;q=0.5, image/webp", ImageFormatWebP},
+		{"IMAGE/AVIF", ImageFormatAVIF},
+		{"image/avif;q=0, image/webp;q=0", ImageFormatOriginal},
+		{"image/*,*/*;q=0.8", ImageFormatOriginal},
+		{"", ImageFormatOriginal},
+	}
+	for _, tt := range tests {
+		if got := PreferredImageFormat(tt.accept, both...); got != tt.expected {
+			t.Errorf("PreferredImageFormat(%q) = %s, expected %s", tt.accept, got, tt.expected)
+		}
+	}
+
+	// Server preference breaks ties
+	if got := PreferredImageFormat("image/avif,image/webp", ImageFormatWebP, ImageFormatAVIF); got != ImageFormatWebP {
+		t.Errorf("expected server preference WebP, got %s", got)
+	}
+
+	t.Logf("✓ %d Accept headers", len(tests))
+}
+
+// newNegotiateTestServer serves testdata through the middleware with a disk cache
+func newNegotiateTestServer(t *testing.T) (*httptest.Server, string) {
+	cacheDir := filepath.Join(tempDir, "negotiate-cache")
+	os.RemoveAll(cacheDir)
+
+	opts := DefaultNegotiateOptions()
+	opts.AVIF.Speed = 10
+	opts.CacheDir = cacheDir
+	opts.OnError = func(r *http.Request, err error) {
+		t.Errorf("%s: %v", r.URL.Path, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(testdataDir)))
+	mux.HandleFunc("/text", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "notes.txt", time.Unix(0, 0), strings.NewReader("hello, range"))
+	})
+	server := httptest.NewServer(NegotiateHandler(mux, opts))
+	t.Cleanup(server.Close)
+	return server, cacheDir
+}
+
+func negotiateGet(t *testing.T, url, accept string, header map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	return resp, buf.Bytes()
+}
+
+// TestNegotiateHandler tests serving variants, Vary, ETag revalidation and the disk cache
+func TestNegotiateHandler(t *testing.T) {
+	server, cacheDir := newNegotiateTestServer(t)
+	url := server.URL + "/jpeg/test.jpg"
+
+	original, err := os.ReadFile(filepath.Join(testdataDir, "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	for _, tt := range []struct {
+		accept      string
+		contentType string
+	}{
+		{"image/avif,image/webp,*/*", "image/avif"},
+		{"image/webp,*/*", "image/webp"},
+		{"*/*", "image/jpeg"},
+	} {
+		resp, body := negotiateGet(t, url, tt.accept, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.accept, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: Content-Type %s, expected %s", tt.accept, ct, tt.contentType)
+		}
+		if !strings.Contains(resp.Header.Get("Vary"), "Accept") {
+			t.Errorf("%s: missing Vary: Accept", tt.accept)
+		}
+		if tt.contentType == "image/jpeg" {
+			if !bytes.Equal(body, original) {
+				t.Errorf("expected the original JPEG")
+			}
+			continue
+		}
+		if len(body) >= len(original) {
+			t.Errorf("%s: variant %d bytes is not smaller than %d", tt.contentType, len(body), len(original))
+		}
+		if _, err := DecodeImageBytes(body); err != nil {
+			t.Errorf("%s: variant does not decode: %v", tt.contentType, err)
+		}
+
+		// Revalidation with the variant ETag
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: missing ETag", tt.contentType)
+		}
+		resp, _ = negotiateGet(t, url, tt.accept, map[string]string{"If-None-Match": etag})
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for matching ETag, got %d", tt.contentType, resp.StatusCode)
+		}
+
+		// Second request is served from the disk cache with the same bytes
+		_, cached := negotiateGet(t, url, tt.accept, nil)
+		if !bytes.Equal(cached, body) {
+			t.Errorf("%s: cached variant differs", tt.contentType)
+		}
+		t.Logf("  %s: %d -> %d bytes, ETag %s", tt.contentType, len(original), len(body), etag)
+	}
+
+	cached, _ := filepath.Glob(filepath.Join(cacheDir, "*", "*"))
+	if len(cached) != 2 {
+		t.Errorf("expected 2 cached variants, got %v", cached)
+	}
+
+	t.Logf("✓ variants negotiated and cached")
+}
+
+// TestNegotiateHandler_Passthrough tests responses that must not be transcoded
+func TestNegotiateHandler_Passthrough(t *testing.T) {
+	server, _ := newNegotiateTestServer(t)
+	accept := "image/avif,image/webp,*/*"
+
+	// Non-image responses are untouched and do not vary
+	resp, body := negotiateGet(t, server.URL+"/text", accept, nil)
+	if string(body) != "hello" || resp.Header.Get("Vary") != "" {
+		t.Errorf("unexpected text response %q, Vary %q", body, resp.Header.Get("Vary"))
+	}
+
+	// Errors are passed through
+	resp, _ = negotiateGet(t, server.URL+"/missing.jpg", accept, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+
+	// Already modern formats are passed through
+	webpFiles, _ := filepath.Glob(filepath.Join(testdataDir, "webp-samples", "*.webp"))
+	if len(webpFiles) > 0 {
+		name, _ := filepath.Rel(testdataDir, webpFiles[0])
+		resp, _ = negotiateGet(t, server.URL+"/"+filepath.ToSlash(name), accept, nil)
+		if ct := resp.Header.Get("Content-Type"); ct != "image/webp" {
+			t.Errorf("expected WebP passthrough, got %s", ct)
+		}
+	}
+
+	// GIF is only offered as WebP
+	resp, body = negotiateGet(t, server.URL+"/gif-source/static-512x512.gif", accept, nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "image/webp" && ct != "image/gif" {
+		t.Errorf("GIF served as %s", ct)
+	}
+	if len(body) == 0 {
+		t.Error("empty GIF response")
+	}
+
+	// Ranges of responses that are not transcoded reach the upstream unchanged
+	resp, body = negotiateGet(t, server.URL+"/notes.txt", accept, map[string]string{"Range": "bytes=7-"})
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "range" {
+		t.Errorf("expected 206 \"range\" for a text range, got %d %q", resp.StatusCode, body)
+	}
+	resp, body = negotiateGet(t, server.URL+"/jpeg/test.jpg", "*/*", map[string]string{"Range": "bytes=0-9"})
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 10 {
+		t.Errorf("expected a 10-byte 206 for a JPEG range without a variant, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+
+	// An image that is transcoded is fetched in full and served as a whole variant
+	resp, body = negotiateGet(t, server.URL+"/jpeg/test.jpg", accept, map[string]string{"Range": "bytes=0-9"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/avif" {
+		t.Errorf("expected a full AVIF variant for a JPEG range, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if _, err := DecodeImageBytes(body); err != nil {
+		t.Errorf("ranged variant does not decode: %v", err)
+	}
+
+	t.Logf("✓ passthrough responses untouched")
+}
+
+// TestNegotiateHandler_ConfiguredFormats tests that the GIF fallback only offers configured formats
+func TestNegotiateHandler_ConfiguredFormats(t *testing.T) {
+	opts := DefaultNegotiateOptions()
+	opts.Formats = []ImageFormat{ImageFormatAVIF}
+	server := httptest.NewServer(NegotiateHandler(http.FileServer(http.Dir(testdataDir)), opts))
+	defer server.Close()
+
+	// AVIF is preferred but GIF can only become WebP, which is not configured
+	resp, _ := negotiateGet(t, server.URL+"/gif-source/static-512x512.gif", "image/avif,image/webp,*/*", nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "image/gif" {
+		t.Errorf("expected the original GIF with only AVIF configured, got %s", ct)
+	}
+}
+
+// TestFlightGroup tests that concurrent calls for a key share one run
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+	var runs atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do("key", func() ([]byte, error) {
+				runs.Add(1)
+				<-release
+				return []byte("variant"), nil
+			})
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the callers pile up behind the first
+	close(release)
+	wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("expected one run for concurrent callers, got %d", n)
+	}
+	for i, r := range results {
+		if string(r) != "variant" {
+			t.Errorf("caller %d got %q", i, r)
+		}
+	}
+
+	// A later call runs again
+	if _, err := g.do("key", func() ([]byte, error) { runs.Add(1); return nil, nil }); err != nil || runs.Load() != 2 {
+		t.Errorf("expected a new run after the first finished, runs=%d err=%v", runs.Load(), err)
+	}
+}
@@ -0,0 +1,58 @@
+// Package nextimagetest provides helpers for testing code that uses libnextimage.
+//
+// CheckLeaks fails a test when the function it runs leaves C allocations behind,
+// which catches encoders, decoders and commands whose Close is never called:
+//
+//	func TestThumbnail(t *testing.T) {
+//		nextimagetest.CheckLeaks(t, func() {
+//			if _, err := MakeThumbnail(data); err != nil {
+//				t.Fatal(err)
+//			}
+//		})
+//	}
+//
+// The allocation counters are shared by the whole process, so tests using CheckLeaks
+// must not run in parallel with other tests that use libnextimage.
+package nextimagetest
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// CheckLeaks runs f and reports an error on t if the C allocations made while it ran
+// are not all freed when it returns. More frees than allocations are reported too, as
+// they would hide a leak in the same f.
+func CheckLeaks(t testing.TB, f func()) {
+	t.Helper()
+	settle()
+	before := libnextimage.AllocationStats()
+	f()
+	after := libnextimage.AllocationStats()
+	made, freed := after.Allocations-before.Allocations, after.Frees-before.Frees
+	switch leaked := after.Live - before.Live; {
+	case leaked > 0:
+		t.Errorf("nextimagetest: %d C allocations not freed (%d made, %d freed); is a Close() call missing?",
+			leaked, made, freed)
+	case leaked < 0:
+		t.Errorf("nextimagetest: %d more C frees than allocations (%d made, %d freed)", -leaked, made, freed)
+	}
+}
+
+// settle runs the finalizers of instances that were dropped without Close before f
+// runs, so that freeing them does not offset a leak made by f
+func settle() {
+	live := libnextimage.AllocationStats().Live
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond) // Finalizers run on their own goroutine
+		now := libnextimage.AllocationStats().Live
+		if now == live {
+			return
+		}
+		live = now
+	}
+}
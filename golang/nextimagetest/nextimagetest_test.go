@@ -0,0 +1,107 @@
+package nextimagetest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	libnextimage "github.com/ideamans/libnextimage/golang"
+)
+
+// recorder is a testing.TB that records errors instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// TestCheckLeaks tests that a closed encoder passes and a forgotten one is reported
+func TestCheckLeaks(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	r := &recorder{TB: t}
+	CheckLeaks(r, func() {
+		encoder, err := libnextimage.NewWebPEncoder(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer encoder.Close()
+		if _, err := encoder.Encode(data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := libnextimage.WebPEncodeBytes(data, libnextimage.DefaultWebPEncodeOptions()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(r.errors) != 0 {
+		t.Errorf("closed encoder reported as a leak: %v", r.errors)
+	}
+
+	var forgotten *libnextimage.AVIFDecoder
+	CheckLeaks(r, func() {
+		forgotten, err = libnextimage.NewAVIFDecoder(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	forgotten.Close()
+	if len(r.errors) != 1 {
+		t.Errorf("expected one leak report, got %v", r.errors)
+	}
+
+	// The PNG outputs of the decode commands are counted like any other buffer, so a
+	// forgotten decoder next to them is still reported
+	webp, err := libnextimage.WebPEncodeBytes(data, libnextimage.DefaultWebPEncodeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	avif, err := os.ReadFile(filepath.Join("..", "..", "testdata", "avif", "red.avif"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	decode := func() {
+		dwebp, err := libnextimage.NewDWebPCommand(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dwebp.Close()
+		if _, err := dwebp.Run(webp); err != nil {
+			t.Fatal(err)
+		}
+		avifdec, err := libnextimage.NewAVIFDecCommand(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer avifdec.Close()
+		if _, err := avifdec.Run(avif); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.errors = nil
+	CheckLeaks(r, decode)
+	if len(r.errors) != 0 {
+		t.Errorf("decode commands reported: %v", r.errors)
+	}
+	CheckLeaks(r, func() {
+		decode()
+		forgotten, err = libnextimage.NewAVIFDecoder(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	forgotten.Close()
+	if len(r.errors) != 1 {
+		t.Errorf("expected one leak report, got %v", r.errors)
+	}
+
+	if st := libnextimage.AllocationStats(); st.Allocations == 0 || st.Live != st.Allocations-st.Frees {
+		t.Errorf("unexpected allocation stats %+v", st)
+	}
+}
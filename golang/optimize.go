@@ -0,0 +1,236 @@
+package libnextimage
+
+import (
+	"fmt"
+	"time"
+)
+
+// ImageFormat identifies the encoded format produced by Optimize and MultiEncode
+type ImageFormat int
+
+const (
+	ImageFormatOriginal ImageFormat = iota // The input data, unchanged (Optimize only)
+	ImageFormatWebP                        // WebP
+	ImageFormatAVIF                        // AVIF
+)
+
+// String returns the format name
+func (f ImageFormat) String() string {
+	switch f {
+	case ImageFormatOriginal:
+		return "original"
+	case ImageFormatWebP:
+		return "webp"
+	case ImageFormatAVIF:
+		return "avif"
+	default:
+		return fmt.Sprintf("ImageFormat(%d)", int(f))
+	}
+}
+
+// MIMEType returns the media type of the format, or "" for ImageFormatOriginal
+func (f ImageFormat) MIMEType() string {
+	switch f {
+	case ImageFormatWebP:
+		return "image/webp"
+	case ImageFormatAVIF:
+		return "image/avif"
+	default:
+		return ""
+	}
+}
+
+// OptimizeCandidate is one encoding tried by Optimize.
+// Only the options matching Format are used.
+type OptimizeCandidate struct {
+	Name   string      // Name shown in the report, e.g. "webp-lossy"
+	Format ImageFormat // ImageFormatWebP or ImageFormatAVIF
+	WebP   WebPEncodeOptions
+	AVIF   AVIFEncodeOptions
+}
+
+// WebPCandidate creates a WebP candidate
+func WebPCandidate(name string, opts WebPEncodeOptions) OptimizeCandidate {
+	return OptimizeCandidate{Name: name, Format: ImageFormatWebP, WebP: opts}
+}
+
+// AVIFCandidate creates an AVIF candidate
+func AVIFCandidate(name string, opts AVIFEncodeOptions) OptimizeCandidate {
+	return OptimizeCandidate{Name: name, Format: ImageFormatAVIF, AVIF: opts}
+}
+
+// OptimizePolicy controls which candidates Optimize tries and which it accepts
+type OptimizePolicy struct {
+	// Candidates to try in order. Nil or empty means DefaultOptimizeCandidates().
+	// When two candidates produce the same size the earlier one wins.
+	Candidates []OptimizeCandidate
+
+	// Quality floor: candidates scoring below MinScore against the input are rejected.
+	// MinScore 0 disables scoring, which also skips decoding the candidates.
+	Metric   QualityMetric
+	MinScore float64
+}
+
+// OptimizeCandidateReport describes how one candidate fared
+type OptimizeCandidateReport struct {
+	Name     string
+	Format   ImageFormat
+	Size     int           // Encoded size in bytes, 0 if encoding failed
+	Score    float64       // Score against the input, 0 if not scored
+	Duration time.Duration // Time spent encoding
+
+	// Rejected is the reason the candidate was not eligible, empty if it was.
+	// Err holds the underlying error when encoding or scoring failed.
+	Rejected string
+	Err      error
+
+	Selected bool // True for the winning candidate
+}
+
+// OptimizeResult is the outcome of Optimize
+type OptimizeResult struct {
+	Data         []byte                    // Winning encoding, or the input when the original was kept
+	Format       ImageFormat               // Format of Data
+	Name         string                    // Winning candidate name, "original" when the original was kept
+	OriginalSize int                       // Size of the input in bytes
+	Candidates   []OptimizeCandidateReport // Report of every candidate, in policy order
+}
+
+// Saved returns the number of bytes saved compared to the input
+func (r *OptimizeResult) Saved() int {
+	return r.OriginalSize - len(r.Data)
+}
+
+// DefaultOptimizeCandidates returns lossy and lossless WebP and AVIF at speeds 6 and 8
+func DefaultOptimizeCandidates() []OptimizeCandidate {
+	lossless := DefaultWebPEncodeOptions()
+	lossless.Lossless = true
+
+	avifSpeed6 := DefaultAVIFEncodeOptions()
+	avifSpeed6.Speed = 6
+	avifSpeed8 := DefaultAVIFEncodeOptions()
+	avifSpeed8.Speed = 8
+
+	return []OptimizeCandidate{
+		WebPCandidate("webp-lossy", DefaultWebPEncodeOptions()),
+		WebPCandidate("webp-lossless", lossless),
+		AVIFCandidate("avif-speed6", avifSpeed6),
+		AVIFCandidate("avif-speed8", avifSpeed8),
+	}
+}
+
+// DefaultOptimizePolicy returns the default candidates with an SSIMULACRA2-style floor of 70
+func DefaultOptimizePolicy() OptimizePolicy {
+	return OptimizePolicy{
+		Candidates: DefaultOptimizeCandidates(),
+		Metric:     QualityMetricSSIMULACRA2,
+		MinScore:   70,
+	}
+}
+
+// encode encodes the input with the candidate's options
+func (c *OptimizeCandidate) encode(imageFileData []byte) ([]byte, error) {
+	switch c.Format {
+	case ImageFormatWebP:
+		return WebPEncodeBytes(imageFileData, c.WebP)
+	case ImageFormatAVIF:
+		return AVIFEncodeBytes(imageFileData, c.AVIF)
+	default:
+		return nil, fmt.Errorf("unsupported candidate format %s", c.Format)
+	}
+}
+
+// Optimize encodes image file data (PNG, JPEG, TIFF, WebP, etc.) with every candidate of the policy
+// and returns the smallest one that meets the quality floor.
+// The input is returned unchanged when no eligible candidate is smaller than it.
+// A failing candidate is reported and skipped; an error is returned only for invalid input or policy.
+func Optimize(imageFileData []byte, policy OptimizePolicy) (*OptimizeResult, error) {
+	if len(imageFileData) == 0 {
+		return nil, fmt.Errorf("optimize: empty input data")
+	}
+	if policy.MinScore < 0 {
+		return nil, fmt.Errorf("optimize: invalid min score %g", policy.MinScore)
+	}
+	if policy.MinScore > 0 && (policy.Metric < QualityMetricSSIM || policy.Metric > QualityMetricSSIMULACRA2) {
+		return nil, fmt.Errorf("optimize: unknown metric %d", int(policy.Metric))
+	}
+
+	candidates := policy.Candidates
+	if len(candidates) == 0 {
+		candidates = DefaultOptimizeCandidates()
+	}
+	for i, c := range candidates {
+		if c.Format != ImageFormatWebP && c.Format != ImageFormatAVIF {
+			return nil, fmt.Errorf("optimize: candidate %d (%q): unsupported format %s", i, c.Name, c.Format)
+		}
+	}
+
+	var reference *DecodedImage
+	if policy.MinScore > 0 {
+		var err error
+		reference, err = DecodeImageBytes(imageFileData)
+		if err != nil {
+			return nil, fmt.Errorf("optimize: %w", err)
+		}
+	}
+
+	result := &OptimizeResult{
+		Data:         imageFileData,
+		Format:       ImageFormatOriginal,
+		Name:         ImageFormatOriginal.String(),
+		OriginalSize: len(imageFileData),
+		Candidates:   make([]OptimizeCandidateReport, len(candidates)),
+	}
+
+	winner := -1
+	var winnerData []byte
+	for i := range candidates {
+		c := &candidates[i]
+		report := &result.Candidates[i]
+		report.Name = c.Name
+		report.Format = c.Format
+
+		start := time.Now()
+		data, err := c.encode(imageFileData)
+		report.Duration = time.Since(start)
+		if err != nil {
+			report.Rejected = "encode failed"
+			report.Err = err
+			continue
+		}
+		report.Size = len(data)
+
+		if reference != nil {
+			decoded, err := DecodeImageBytes(data)
+			if err == nil {
+				report.Score, err = PerceptualScore(policy.Metric, reference, decoded)
+			}
+			if err != nil {
+				report.Rejected = "scoring failed"
+				report.Err = err
+				continue
+			}
+			if report.Score < policy.MinScore {
+				report.Rejected = fmt.Sprintf("%s %.4g below floor %.4g", policy.Metric, report.Score, policy.MinScore)
+				continue
+			}
+		}
+
+		if len(data) >= len(imageFileData) {
+			report.Rejected = "not smaller than original"
+			continue
+		}
+		if winner < 0 || len(data) < len(winnerData) {
+			winner = i
+			winnerData = data
+		}
+	}
+
+	if winner >= 0 {
+		result.Candidates[winner].Selected = true
+		result.Data = winnerData
+		result.Format = candidates[winner].Format
+		result.Name = candidates[winner].Name
+	}
+	return result, nil
+}
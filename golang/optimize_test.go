@@ -0,0 +1,129 @@
+package libnextimage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestOptimize tests picking the smallest candidate with the default policy
+func TestOptimize(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	result, err := Optimize(data, DefaultOptimizePolicy())
+	if err != nil {
+		t.Fatalf("Optimize failed: %v", err)
+	}
+	if len(result.Candidates) != len(DefaultOptimizeCandidates()) {
+		t.Fatalf("expected a report for every candidate, got %d", len(result.Candidates))
+	}
+	if result.Format == ImageFormatOriginal || result.Saved() <= 0 {
+		t.Fatalf("expected a candidate smaller than the PNG, kept %s", result.Name)
+	}
+
+	selected := 0
+	for _, c := range result.Candidates {
+		if c.Selected {
+			selected++
+			if c.Name != result.Name || c.Size != len(result.Data) || c.Rejected != "" {
+				t.Errorf("selected report %+v does not match result %s (%d bytes)", c, result.Name, len(result.Data))
+			}
+		} else if c.Rejected == "" && c.Size < len(result.Data) {
+			t.Errorf("eligible candidate %s (%d bytes) is smaller than the winner", c.Name, c.Size)
+		}
+		if c.Err == nil && c.Score < 70 && c.Rejected == "" {
+			t.Errorf("candidate %s scored %.2f below the floor but was not rejected", c.Name, c.Score)
+		}
+		t.Logf("  %-14s %-5s %7d bytes score %6.2f %v %s", c.Name, c.Format, c.Size, c.Score, c.Duration, c.Rejected)
+	}
+	if selected != 1 {
+		t.Errorf("expected exactly one selected candidate, got %d", selected)
+	}
+
+	t.Logf("✓ %s (%s) %d -> %d bytes", result.Name, result.Format.MIMEType(), result.OriginalSize, len(result.Data))
+}
+
+// TestOptimize_KeepsOriginal tests that the input wins when no candidate is smaller
+func TestOptimize_KeepsOriginal(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	webpOpts := DefaultWebPEncodeOptions()
+	webpOpts.Quality = 10
+	small, err := WebPEncodeBytes(source, webpOpts)
+	if err != nil {
+		t.Fatalf("Failed to encode WebP: %v", err)
+	}
+
+	lossless := DefaultWebPEncodeOptions()
+	lossless.Lossless = true
+	policy := OptimizePolicy{Candidates: []OptimizeCandidate{WebPCandidate("webp-lossless", lossless)}}
+
+	result, err := Optimize(small, policy)
+	if err != nil {
+		t.Fatalf("Optimize failed: %v", err)
+	}
+	if result.Format != ImageFormatOriginal || !bytes.Equal(result.Data, small) {
+		t.Fatalf("expected the original to be kept, got %s", result.Name)
+	}
+	if result.Candidates[0].Rejected == "" || result.Candidates[0].Selected {
+		t.Errorf("expected the larger candidate to be rejected: %+v", result.Candidates[0])
+	}
+
+	t.Logf("✓ original kept: %s", result.Candidates[0].Rejected)
+}
+
+// TestOptimize_QualityFloor tests rejecting candidates below the floor and reporting failures
+func TestOptimize_QualityFloor(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	low := DefaultWebPEncodeOptions()
+	low.Quality = 1
+	high := DefaultWebPEncodeOptions()
+	high.Quality = 95
+	broken := DefaultAVIFEncodeOptions()
+	broken.CodecOptions = map[string]string{"cq-level": "999"}
+
+	policy := OptimizePolicy{
+		Candidates: []OptimizeCandidate{
+			WebPCandidate("webp-q1", low),
+			AVIFCandidate("avif-broken", broken),
+			WebPCandidate("webp-q95", high),
+		},
+		Metric:   QualityMetricSSIM,
+		MinScore: 0.97,
+	}
+
+	result, err := Optimize(data, policy)
+	if err != nil {
+		t.Fatalf("Optimize failed: %v", err)
+	}
+	if result.Candidates[0].Rejected == "" {
+		t.Errorf("expected quality 1 to fall below the floor (score %.4f)", result.Candidates[0].Score)
+	}
+	if result.Candidates[1].Err == nil {
+		t.Error("expected the invalid AVIF candidate to report an error")
+	}
+	if result.Name != "webp-q95" {
+		t.Errorf("expected webp-q95 to win, got %s", result.Name)
+	}
+
+	// Invalid input and policy
+	if _, err := Optimize(nil, policy); err == nil {
+		t.Error("expected error for empty input")
+	}
+	invalid := OptimizePolicy{Candidates: []OptimizeCandidate{{Name: "none"}}}
+	if _, err := Optimize(data, invalid); err == nil {
+		t.Error("expected error for a candidate without a format")
+	}
+
+	t.Logf("✓ floor: %s, failure: %v", result.Candidates[0].Rejected, result.Candidates[1].Err)
+}
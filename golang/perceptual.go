@@ -0,0 +1,421 @@
+package libnextimage
+
+import (
+	"fmt"
+	"math"
+)
+
+// QualityMetric selects a perceptual metric for comparing a decoded image against its source
+type QualityMetric int
+
+const (
+	// QualityMetricSSIM is the structural similarity index on luma (0-1, 1=identical)
+	QualityMetricSSIM QualityMetric = iota
+	// QualityMetricMSSSIM is multi-scale SSIM on luma over up to 5 scales (0-1, 1=identical)
+	QualityMetricMSSSIM
+	// QualityMetricSSIMULACRA2 is an SSIMULACRA2-style score in the XYB color space
+	// (100=identical, around 90 visually lossless, 70 high, 50 medium quality).
+	// It follows the structure and output scale of SSIMULACRA2 with a simplified
+	// weighting, so absolute values differ somewhat from the reference tool.
+	QualityMetricSSIMULACRA2
+)
+
+// String returns the metric name
+func (m QualityMetric) String() string {
+	switch m {
+	case QualityMetricSSIM:
+		return "ssim"
+	case QualityMetricMSSSIM:
+		return "ms-ssim"
+	case QualityMetricSSIMULACRA2:
+		return "ssimulacra2"
+	default:
+		return fmt.Sprintf("QualityMetric(%d)", int(m))
+	}
+}
+
+// Constants shared by the SSIM based metrics (8-bit dynamic range)
+const (
+	ssimSigma = 1.5
+	ssimC1    = (0.01 * 255) * (0.01 * 255)
+	ssimC2    = (0.03 * 255) * (0.03 * 255)
+)
+
+// msssimWeights are the per-scale exponents from Wang et al. (2003)
+var msssimWeights = [5]float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// ssimulacra2Scales is the number of scales evaluated by the SSIMULACRA2-style metric
+const ssimulacra2Scales = 6
+
+// ssimulacra2ChannelWeights weights the X, Y and B channels
+var ssimulacra2ChannelWeights = [3]float64{0.5, 1.0, 0.25}
+
+// ssimulacra2FeatureWeights weights SSIM, ringing/blocking artifact and detail loss
+// error maps, each pooled with 1-norm and 4-norm
+var ssimulacra2FeatureWeights = [6]float64{20, 10, 15, 7.5, 15, 7.5}
+
+// PerceptualScore compares a distorted image against a reference with the given metric.
+// Both images must be 8-bit RGBA, RGB or BGRA with the same dimensions.
+// Transparent pixels are composited onto mid-gray before comparison.
+func PerceptualScore(metric QualityMetric, reference, distorted *DecodedImage) (float64, error) {
+	ref, err := newPlaneImage(reference)
+	if err != nil {
+		return 0, fmt.Errorf("perceptual score: reference: %w", err)
+	}
+	dist, err := newPlaneImage(distorted)
+	if err != nil {
+		return 0, fmt.Errorf("perceptual score: distorted: %w", err)
+	}
+	if ref.width != dist.width || ref.height != dist.height {
+		return 0, fmt.Errorf("perceptual score: dimensions differ: %dx%d vs %dx%d",
+			ref.width, ref.height, dist.width, dist.height)
+	}
+
+	switch metric {
+	case QualityMetricSSIM:
+		s, _ := ssimLuma(ref.luma(), dist.luma(), ref.width, ref.height)
+		return s, nil
+	case QualityMetricMSSSIM:
+		return msssim(ref.luma(), dist.luma(), ref.width, ref.height), nil
+	case QualityMetricSSIMULACRA2:
+		return ssimulacra2(ref, dist), nil
+	default:
+		return 0, fmt.Errorf("perceptual score: unknown metric %d", int(metric))
+	}
+}
+
+// planeImage holds sRGB channels as float planes in the 0-255 range
+type planeImage struct {
+	width, height int
+	rgb           [3][]float64
+}
+
+// newPlaneImage converts a decoded 8-bit image to float planes, compositing alpha onto mid-gray
+func newPlaneImage(img *DecodedImage) (*planeImage, error) {
+	if img == nil || img.Width <= 0 || img.Height <= 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	if img.BitDepth != 8 {
+		return nil, fmt.Errorf("unsupported bit depth %d (must be 8)", img.BitDepth)
+	}
+
+	var bpp, ri, gi, bi, ai int
+	switch img.Format {
+	case FormatRGBA:
+		bpp, ri, gi, bi, ai = 4, 0, 1, 2, 3
+	case FormatBGRA:
+		bpp, ri, gi, bi, ai = 4, 2, 1, 0, 3
+	case FormatRGB:
+		bpp, ri, gi, bi, ai = 3, 0, 1, 2, -1
+	default:
+		return nil, fmt.Errorf("unsupported pixel format %d", int(img.Format))
+	}
+
+	stride := img.Stride
+	if stride == 0 {
+		stride = img.Width * bpp
+	}
+	if stride < img.Width*bpp || len(img.Data) < stride*(img.Height-1)+img.Width*bpp {
+		return nil, fmt.Errorf("pixel data too small for %dx%d", img.Width, img.Height)
+	}
+
+	n := img.Width * img.Height
+	p := &planeImage{width: img.Width, height: img.Height}
+	for c := range p.rgb {
+		p.rgb[c] = make([]float64, n)
+	}
+	for y := 0; y < img.Height; y++ {
+		row := img.Data[y*stride:]
+		for x := 0; x < img.Width; x++ {
+			px := row[x*bpp:]
+			a := 1.0
+			if ai >= 0 {
+				a = float64(px[ai]) / 255
+			}
+			i := y*img.Width + x
+			p.rgb[0][i] = float64(px[ri])*a + 128*(1-a)
+			p.rgb[1][i] = float64(px[gi])*a + 128*(1-a)
+			p.rgb[2][i] = float64(px[bi])*a + 128*(1-a)
+		}
+	}
+	return p, nil
+}
+
+// luma returns the BT.601 luma plane
+func (p *planeImage) luma() []float64 {
+	y := make([]float64, len(p.rgb[0]))
+	for i := range y {
+		y[i] = 0.299*p.rgb[0][i] + 0.587*p.rgb[1][i] + 0.114*p.rgb[2][i]
+	}
+	return y
+}
+
+// gaussianKernel returns a normalized 1D gaussian kernel truncated at 3 sigma
+func gaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		d := float64(i - radius)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// gaussianBlur applies a separable gaussian blur with clamped edges
+func gaussianBlur(src []float64, w, h int, kernel []float64) []float64 {
+	radius := len(kernel) / 2
+	tmp := make([]float64, len(src))
+	dst := make([]float64, len(src))
+
+	clamp := func(v, max int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= max {
+			return max - 1
+		}
+		return v
+	}
+
+	for y := 0; y < h; y++ {
+		row := src[y*w : (y+1)*w]
+		for x := 0; x < w; x++ {
+			var sum float64
+			for k, kv := range kernel {
+				sum += kv * row[clamp(x+k-radius, w)]
+			}
+			tmp[y*w+x] = sum
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float64
+			for k, kv := range kernel {
+				sum += kv * tmp[clamp(y+k-radius, h)*w+x]
+			}
+			dst[y*w+x] = sum
+		}
+	}
+	return dst
+}
+
+// multiply returns the element-wise product of two planes
+func multiply(a, b []float64) []float64 {
+	out := make([]float64, len(a))
+	for i := range a {
+		out[i] = a[i] * b[i]
+	}
+	return out
+}
+
+// ssimLuma returns the mean SSIM and mean contrast-structure term of two planes
+func ssimLuma(a, b []float64, w, h int) (ssim, cs float64) {
+	kernel := gaussianKernel(ssimSigma)
+	muA := gaussianBlur(a, w, h, kernel)
+	muB := gaussianBlur(b, w, h, kernel)
+	sAA := gaussianBlur(multiply(a, a), w, h, kernel)
+	sBB := gaussianBlur(multiply(b, b), w, h, kernel)
+	sAB := gaussianBlur(multiply(a, b), w, h, kernel)
+
+	var sumSSIM, sumCS float64
+	for i := range a {
+		varA := sAA[i] - muA[i]*muA[i]
+		varB := sBB[i] - muB[i]*muB[i]
+		cov := sAB[i] - muA[i]*muB[i]
+		l := (2*muA[i]*muB[i] + ssimC1) / (muA[i]*muA[i] + muB[i]*muB[i] + ssimC1)
+		c := (2*cov + ssimC2) / (varA + varB + ssimC2)
+		sumSSIM += l * c
+		sumCS += c
+	}
+	n := float64(len(a))
+	return sumSSIM / n, sumCS / n
+}
+
+// downsample2x halves a plane by averaging 2x2 blocks (odd edges are replicated)
+func downsample2x(src []float64, w, h int) ([]float64, int, int) {
+	nw, nh := (w+1)/2, (h+1)/2
+	dst := make([]float64, nw*nh)
+	for y := 0; y < nh; y++ {
+		y0, y1 := 2*y, 2*y+1
+		if y1 >= h {
+			y1 = h - 1
+		}
+		for x := 0; x < nw; x++ {
+			x0, x1 := 2*x, 2*x+1
+			if x1 >= w {
+				x1 = w - 1
+			}
+			dst[y*nw+x] = (src[y0*w+x0] + src[y0*w+x1] + src[y1*w+x0] + src[y1*w+x1]) / 4
+		}
+	}
+	return dst, nw, nh
+}
+
+// msssim computes multi-scale SSIM, using fewer scales for images too small to halve
+func msssim(a, b []float64, w, h int) float64 {
+	scales := 1
+	for sw, sh := w, h; scales < len(msssimWeights) && sw >= 16 && sh >= 16; scales++ {
+		sw, sh = (sw+1)/2, (sh+1)/2
+	}
+	var weightSum float64
+	for s := 0; s < scales; s++ {
+		weightSum += msssimWeights[s]
+	}
+
+	result := 1.0
+	for s := 0; s < scales; s++ {
+		if s > 0 {
+			a, _, _ = downsample2x(a, w, h)
+			b, w, h = downsample2x(b, w, h)
+		}
+		ssim, cs := ssimLuma(a, b, w, h)
+		// Contrast-structure at every scale, full SSIM (with luminance) at the coarsest
+		v := cs
+		if s == scales-1 {
+			v = ssim
+		}
+		if v < 0 {
+			v = 0
+		}
+		result *= math.Pow(v, msssimWeights[s]/weightSum)
+	}
+	return result
+}
+
+// srgbToLinear converts an 8-bit range sRGB value to linear light (0-1)
+func srgbToLinear(v float64) float64 {
+	v /= 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// xybOpsin is the opsin absorbance matrix of the JPEG XL XYB color space
+var xybOpsin = [3][3]float64{
+	{0.30, 0.622, 0.078},
+	{0.23, 0.692, 0.078},
+	{0.24342268924547819, 0.20476744424496821, 0.55180986650955360},
+}
+
+// xybBias is the opsin absorbance bias of the JPEG XL XYB color space
+const xybBias = 0.0037930732552754493
+
+// linearToXYB converts linear RGB planes to XYB planes shifted into a positive range
+func linearToXYB(lin [3][]float64) [3][]float64 {
+	n := len(lin[0])
+	var out [3][]float64
+	for c := range out {
+		out[c] = make([]float64, n)
+	}
+
+	cbrtBias := math.Cbrt(xybBias)
+	for i := 0; i < n; i++ {
+		var lms [3]float64
+		for c := range lms {
+			m := xybOpsin[c][0]*lin[0][i] + xybOpsin[c][1]*lin[1][i] + xybOpsin[c][2]*lin[2][i] + xybBias
+			if m < 0 {
+				m = 0
+			}
+			lms[c] = math.Cbrt(m) - cbrtBias
+		}
+		x := (lms[0] - lms[1]) / 2
+		y := (lms[0] + lms[1]) / 2
+		out[0][i] = x*14 + 0.42
+		out[1][i] = y + 0.01
+		out[2][i] = (lms[2] - y) + 0.55
+	}
+	return out
+}
+
+// ssimulacra2 computes the SSIMULACRA2-style score of dist against ref
+func ssimulacra2(ref, dist *planeImage) float64 {
+	w, h := ref.width, ref.height
+	var linRef, linDist [3][]float64
+	for c := 0; c < 3; c++ {
+		linRef[c] = make([]float64, len(ref.rgb[c]))
+		linDist[c] = make([]float64, len(dist.rgb[c]))
+		for i := range ref.rgb[c] {
+			linRef[c][i] = srgbToLinear(ref.rgb[c][i])
+			linDist[c][i] = srgbToLinear(dist.rgb[c][i])
+		}
+	}
+
+	kernel := gaussianKernel(ssimSigma)
+	var sum float64
+	for s := 0; s < ssimulacra2Scales; s++ {
+		if s > 0 {
+			if w < 8 || h < 8 {
+				break
+			}
+			// Downscaling happens in linear light, as in SSIMULACRA2
+			nw, nh := w, h
+			for c := 0; c < 3; c++ {
+				linRef[c], _, _ = downsample2x(linRef[c], w, h)
+				linDist[c], nw, nh = downsample2x(linDist[c], w, h)
+			}
+			w, h = nw, nh
+		}
+
+		xybRef := linearToXYB(linRef)
+		xybDist := linearToXYB(linDist)
+		for c := 0; c < 3; c++ {
+			features := ssimulacra2Features(xybRef[c], xybDist[c], w, h, kernel)
+			for f, v := range features {
+				sum += ssimulacra2ChannelWeights[c] * ssimulacra2FeatureWeights[f] * v
+			}
+		}
+	}
+	sum /= ssimulacra2Scales
+
+	// Map the weighted error onto the SSIMULACRA2 output scale
+	sum *= 0.9562382616834844
+	sum = 2.326765642916932*sum - 0.020884521182843837*sum*sum + 6.248496625763138e-05*sum*sum*sum
+	if sum <= 0 {
+		return 100
+	}
+	return 100 - 10*math.Pow(sum, 0.6276336467831387)
+}
+
+// ssimulacra2Features returns the SSIM, artifact (added edges) and detail loss (removed edges)
+// error maps of one XYB channel, each pooled with the 1-norm and the 4-norm
+func ssimulacra2Features(a, b []float64, w, h int, kernel []float64) [6]float64 {
+	muA := gaussianBlur(a, w, h, kernel)
+	muB := gaussianBlur(b, w, h, kernel)
+	sAA := gaussianBlur(multiply(a, a), w, h, kernel)
+	sBB := gaussianBlur(multiply(b, b), w, h, kernel)
+	sAB := gaussianBlur(multiply(a, b), w, h, kernel)
+
+	const c2 = 0.0009
+	var ssim1, ssim4, art1, art4, det1, det4 float64
+	for i := range a {
+		diff := muA[i] - muB[i]
+		numM := 1 - diff*diff
+		numS := 2*(sAB[i]-muA[i]*muB[i]) + c2
+		denS := (sAA[i] - muA[i]*muA[i]) + (sBB[i] - muB[i]*muB[i]) + c2
+		d := 1 - numM*numS/denS
+		if d < 0 {
+			d = 0
+		}
+		ssim1 += d
+		ssim4 += d * d * d * d
+
+		ed := (1+math.Abs(b[i]-muB[i]))/(1+math.Abs(a[i]-muA[i])) - 1
+		if ed > 0 {
+			art1 += ed
+			art4 += ed * ed * ed * ed
+		} else {
+			det1 -= ed
+			det4 += ed * ed * ed * ed
+		}
+	}
+
+	n := float64(len(a))
+	norm4 := func(v float64) float64 { return math.Sqrt(math.Sqrt(v / n)) }
+	return [6]float64{ssim1 / n, norm4(ssim4), art1 / n, norm4(art4), det1 / n, norm4(det4)}
+}
@@ -0,0 +1,164 @@
+package libnextimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Profile is a named set of WebP and AVIF encode options, e.g. "web-photo".
+// In JSON and YAML, omitted "webp" and "avif" options keep their defaults.
+type Profile struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	WebP        WebPEncodeOptions `json:"webp" yaml:"webp"`
+	AVIF        AVIFEncodeOptions `json:"avif" yaml:"avif"`
+}
+
+// Built-in profile names
+const (
+	ProfileWebPhoto        = "web-photo"        // Photos for web pages: good quality, ICC profile kept
+	ProfileThumbnail       = "thumbnail"        // Small previews: lower quality, fast, no metadata
+	ProfileLosslessGraphic = "lossless-graphic" // Logos, diagrams and screenshots: lossless, exact colors
+)
+
+var profiles = struct {
+	sync.RWMutex
+	byName map[string]Profile
+}{byName: make(map[string]Profile)}
+
+func init() {
+	for _, p := range builtinProfiles() {
+		profiles.byName[p.Name] = p
+	}
+}
+
+func builtinProfiles() []Profile {
+	webPhoto := Profile{Name: ProfileWebPhoto, Description: "Photos for web pages: good quality, ICC profile kept",
+		WebP: DefaultWebPEncodeOptions(), AVIF: DefaultAVIFEncodeOptions()}
+	webPhoto.WebP.Preset = PresetPhoto
+	webPhoto.WebP.Quality = 80
+	webPhoto.WebP.Method = 6
+	webPhoto.WebP.UseSharpYUV = true
+	webPhoto.WebP.KeepMetadata = MetadataICC
+	webPhoto.AVIF.Quality = 65
+	webPhoto.AVIF.YUVFormat = YUVFormat420
+	webPhoto.AVIF.SharpYUV = true
+
+	thumbnail := Profile{Name: ProfileThumbnail, Description: "Small previews: lower quality, fast, no metadata",
+		WebP: DefaultWebPEncodeOptions(), AVIF: DefaultAVIFEncodeOptions()}
+	thumbnail.WebP.Preset = PresetPicture
+	thumbnail.WebP.Quality = 60
+	thumbnail.WebP.KeepMetadata = MetadataNone
+	thumbnail.AVIF.Quality = 50
+	thumbnail.AVIF.Speed = 8
+	thumbnail.AVIF.YUVFormat = YUVFormat420
+
+	// Same settings as cwebp -z 6 -exact and avifenc --lossless
+	lossless := Profile{Name: ProfileLosslessGraphic, Description: "Logos, diagrams and screenshots: lossless, exact colors",
+		WebP: DefaultWebPEncodeOptions(), AVIF: DefaultAVIFEncodeOptions()}
+	lossless.WebP.Lossless = true
+	lossless.WebP.LosslessPreset = 6
+	lossless.WebP.Exact = true
+	lossless.WebP.ImageHint = HintGraph
+	lossless.AVIF.Lossless = true
+	lossless.AVIF.Quality = 100
+	lossless.AVIF.QualityAlpha = 100
+	lossless.AVIF.YUVFormat = YUVFormat444
+	lossless.AVIF.YUVRange = YUVRangeFull
+	lossless.AVIF.MatrixCoefficients = 0 // identity
+
+	return []Profile{webPhoto, thumbnail, lossless}
+}
+
+// RegisterProfile adds a profile, replacing any profile with the same name
+// (built-in profiles included). The options are validated first.
+func RegisterProfile(p Profile) error {
+	if p.Name == "" {
+		return fmt.Errorf("register profile: empty name")
+	}
+	if err := p.WebP.Validate(); err != nil {
+		return fmt.Errorf("register profile %q: %w", p.Name, err)
+	}
+	if err := p.AVIF.Validate(); err != nil {
+		return fmt.Errorf("register profile %q: %w", p.Name, err)
+	}
+
+	profiles.Lock()
+	defer profiles.Unlock()
+	profiles.byName[p.Name] = p.clone()
+	return nil
+}
+
+// LookupProfile returns the profile registered under name.
+// The returned options are a copy and can be modified freely.
+func LookupProfile(name string) (Profile, bool) {
+	profiles.RLock()
+	defer profiles.RUnlock()
+	p, ok := profiles.byName[name]
+	if !ok {
+		return Profile{}, false
+	}
+	return p.clone(), true
+}
+
+// ProfileNames returns the names of the registered profiles in sorted order
+func ProfileNames() []string {
+	profiles.RLock()
+	defer profiles.RUnlock()
+	return slices.Sorted(maps.Keys(profiles.byName))
+}
+
+// clone copies the slices and maps of the options so that callers do not share them
+func (p Profile) clone() Profile {
+	p.AVIF.ExifData = slices.Clone(p.AVIF.ExifData)
+	p.AVIF.XMPData = slices.Clone(p.AVIF.XMPData)
+	p.AVIF.ICCData = slices.Clone(p.AVIF.ICCData)
+	p.AVIF.GainMapAlternate = slices.Clone(p.AVIF.GainMapAlternate)
+	p.AVIF.CodecOptions = maps.Clone(p.AVIF.CodecOptions)
+	return p
+}
+
+// profileConfig is the config file form of a Profile
+type profileConfig struct {
+	Name        string                           `json:"name" yaml:"name"`
+	Description string                           `json:"description,omitempty" yaml:"description,omitempty"`
+	WebP        OptionsConfig[WebPEncodeOptions] `json:"webp" yaml:"webp"`
+	AVIF        OptionsConfig[AVIFEncodeOptions] `json:"avif" yaml:"avif"`
+}
+
+func newProfileConfig(p Profile) profileConfig {
+	return profileConfig{p.Name, p.Description, NewOptionsConfig(p.WebP), NewOptionsConfig(p.AVIF)}
+}
+
+func (c profileConfig) profile() Profile {
+	return Profile{c.Name, c.Description, c.WebP.Options, c.AVIF.Options}
+}
+
+func (p Profile) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newProfileConfig(p))
+}
+
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	c := newProfileConfig(Profile{WebP: DefaultWebPEncodeOptions(), AVIF: DefaultAVIFEncodeOptions()})
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	*p = c.profile()
+	return nil
+}
+
+func (p Profile) MarshalYAML() (any, error) {
+	return newProfileConfig(p), nil
+}
+
+func (p *Profile) UnmarshalYAML(unmarshal func(any) error) error {
+	c := newProfileConfig(Profile{WebP: DefaultWebPEncodeOptions(), AVIF: DefaultAVIFEncodeOptions()})
+	if err := unmarshal(&c); err != nil {
+		return err
+	}
+	*p = c.profile()
+	return nil
+}
@@ -0,0 +1,81 @@
+package libnextimage
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// restoreProfiles puts the profile registry back as it was when the test ends, so
+// profiles registered by the test do not leak into others
+func restoreProfiles(t *testing.T) {
+	profiles.Lock()
+	saved := maps.Clone(profiles.byName)
+	profiles.Unlock()
+	t.Cleanup(func() {
+		profiles.Lock()
+		profiles.byName = saved
+		profiles.Unlock()
+	})
+}
+
+// TestProfiles tests the built-in profiles and runtime registration
+func TestProfiles(t *testing.T) {
+	restoreProfiles(t)
+	for _, name := range []string{ProfileWebPhoto, ProfileThumbnail, ProfileLosslessGraphic} {
+		p, ok := LookupProfile(name)
+		if !ok {
+			t.Fatalf("built-in profile %s not registered", name)
+		}
+		if err := p.WebP.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if err := p.AVIF.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if p, _ := LookupProfile(ProfileLosslessGraphic); !p.WebP.Lossless || p.AVIF.Quality != 100 {
+		t.Errorf("lossless-graphic is not lossless: %+v", p)
+	}
+
+	// Profiles load from config files; omitted options keep their defaults
+	var p Profile
+	if err := json.Unmarshal([]byte(`{"name":"banner","webp":{"quality":90,"preset":"drawing"},"avif":{"yuv":"422"}}`), &p); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := RegisterProfile(p); err != nil {
+		t.Fatalf("RegisterProfile failed: %v", err)
+	}
+	got, ok := LookupProfile("banner")
+	if !ok || got.WebP.Quality != 90 || got.WebP.Preset != PresetDrawing || got.WebP.Method != 4 ||
+		got.AVIF.YUVFormat != YUVFormat422 || got.AVIF.Quality != 60 {
+		t.Errorf("unexpected profile %+v", got)
+	}
+	if !slices.Contains(ProfileNames(), "banner") {
+		t.Errorf("banner missing from %q", ProfileNames())
+	}
+
+	// Lookups return copies
+	got.AVIF.CodecOptions = map[string]string{"tune": "ssim"}
+	if again, _ := LookupProfile("banner"); again.AVIF.CodecOptions != nil {
+		t.Error("modifying a looked up profile changed the registry")
+	}
+
+	// Invalid profiles are rejected
+	p.Name = "broken"
+	p.WebP.Method = 9
+	var verr *ValidationError
+	if err := RegisterProfile(p); !errors.As(err, &verr) {
+		t.Errorf("expected a ValidationError, got %v", err)
+	}
+	if _, ok := LookupProfile("broken"); ok {
+		t.Error("invalid profile was registered")
+	}
+	if err := RegisterProfile(Profile{WebP: DefaultWebPEncodeOptions(), AVIF: DefaultAVIFEncodeOptions()}); err == nil {
+		t.Error("expected error for an empty name")
+	}
+
+	t.Logf("✓ %d profiles", len(ProfileNames()))
+}
@@ -0,0 +1,559 @@
+package libnextimage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Scheduler.Acquire when SchedulerOptions.MaxQueue jobs are already waiting
+var ErrQueueFull = errors.New("libnextimage: scheduler queue is full")
+
+// ErrSchedulerClosed is returned by Scheduler.Acquire after Close
+var ErrSchedulerClosed = errors.New("libnextimage: scheduler is closed")
+
+// Cost is the resources a job holds while it runs
+type Cost struct {
+	Memory  int64 // Estimated peak memory in bytes
+	Threads int   // Threads used by the encoder or decoder
+}
+
+// SchedulerOptions sets the budgets of a Scheduler
+type SchedulerOptions struct {
+	// MaxMemory is the total estimated peak memory of the jobs running at the same
+	// time, in bytes. 0 means no memory limit.
+	MaxMemory int64
+
+	// MaxThreads is the total number of encoder and decoder threads running at the
+	// same time (default: runtime.GOMAXPROCS(0))
+	MaxThreads int
+
+	// MaxQueue is the number of jobs allowed to wait; more are rejected with
+	// ErrQueueFull. 0 means no limit.
+	MaxQueue int
+}
+
+// SchedulerStats is a snapshot of a Scheduler's queue and budgets
+type SchedulerStats struct {
+	Queued       int           // Jobs waiting for memory or threads
+	Running      int           // Jobs admitted and not released yet
+	MemoryInUse  int64         // Estimated peak memory of the running jobs
+	ThreadsInUse int           // Threads of the running jobs
+	MaxMemory    int64         // Memory budget (0 = unlimited)
+	MaxThreads   int           // Thread budget
+	Admitted     uint64        // Jobs admitted since the scheduler was created
+	Rejected     uint64        // Jobs rejected because the queue was full
+	Canceled     uint64        // Jobs whose context ended while they were queued
+	WaitTime     time.Duration // Total time admitted jobs spent in the queue
+}
+
+// Scheduler admits encode and decode jobs against a memory budget and a thread budget.
+// Jobs that do not fit wait in a first-in first-out queue, so a large image is not
+// starved by a stream of small ones. A job larger than a whole budget runs alone.
+// A Scheduler is safe for concurrent use.
+//
+// The encoder and decoder pools (NewWebPEncoderPool, NewAVIFEncoderPool, ...) run each
+// call through the scheduler with an estimate from the probed image dimensions. Other
+// work, such as the Command types, can be scheduled with Acquire:
+//
+//	release, err := sched.Acquire(ctx, libnextimage.EstimateWebPEncode(data, libnextimage.DefaultWebPEncodeOptions()))
+//	if err != nil {
+//	    return err
+//	}
+//	defer release()
+//	out, err := cwebp.Run(data)
+type Scheduler struct {
+	maxMemory  int64
+	maxThreads int
+	maxQueue   int
+
+	mu      sync.Mutex
+	queue   []*schedulerWaiter
+	memory  int64
+	threads int
+	running int
+	closed  bool
+
+	admitted uint64
+	rejected uint64
+	canceled uint64
+	waitTime time.Duration
+}
+
+// schedulerWaiter is a queued Acquire call. ready is closed when the job is admitted
+// or the scheduler is closed (err set).
+type schedulerWaiter struct {
+	cost   Cost
+	queued time.Time
+	ready  chan struct{}
+	err    error
+}
+
+// NewScheduler creates a scheduler with the given budgets
+func NewScheduler(opts SchedulerOptions) *Scheduler {
+	if opts.MaxThreads <= 0 {
+		opts.MaxThreads = runtime.GOMAXPROCS(0)
+	}
+	return &Scheduler{maxMemory: opts.MaxMemory, maxThreads: opts.MaxThreads, maxQueue: opts.MaxQueue}
+}
+
+// Acquire waits until cost fits in the budgets and reserves it. The returned release
+// function must be called when the job is done; calling it more than once has no effect.
+// Acquire returns ctx.Err() if ctx ends first, ErrQueueFull if the queue is full and
+// ErrSchedulerClosed after Close.
+func (s *Scheduler) Acquire(ctx context.Context, cost Cost) (release func(), err error) {
+	cost = s.clamp(cost)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrSchedulerClosed
+	}
+	if len(s.queue) == 0 && s.fits(cost) {
+		s.admit(cost, 0)
+		s.mu.Unlock()
+		return s.releaseFunc(cost), nil
+	}
+	if s.maxQueue > 0 && len(s.queue) >= s.maxQueue {
+		s.rejected++
+		s.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	w := &schedulerWaiter{cost: cost, queued: time.Now(), ready: make(chan struct{})}
+	s.queue = append(s.queue, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		if w.err != nil {
+			return nil, w.err
+		}
+		return s.releaseFunc(cost), nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.ready:
+		// Admitted or closed while ctx ended: give the reservation back
+		if w.err == nil {
+			s.release(cost)
+		}
+	default:
+		for i, queued := range s.queue {
+			if queued == w {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+		s.canceled++
+		s.dispatch() // The jobs behind w may fit now
+	}
+	return nil, ctx.Err()
+}
+
+// Stats returns a snapshot of the queue and the budgets in use
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SchedulerStats{
+		Queued:       len(s.queue),
+		Running:      s.running,
+		MemoryInUse:  s.memory,
+		ThreadsInUse: s.threads,
+		MaxMemory:    s.maxMemory,
+		MaxThreads:   s.maxThreads,
+		Admitted:     s.admitted,
+		Rejected:     s.rejected,
+		Canceled:     s.canceled,
+		WaitTime:     s.waitTime,
+	}
+}
+
+// Close rejects queued and future jobs with ErrSchedulerClosed.
+// Running jobs are not affected.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, w := range s.queue {
+		w.err = ErrSchedulerClosed
+		close(w.ready)
+	}
+	s.queue = nil
+}
+
+// clamp limits a cost to the budgets, so that a job larger than a budget runs alone
+// instead of never
+func (s *Scheduler) clamp(cost Cost) Cost {
+	if cost.Threads < 1 {
+		cost.Threads = 1
+	}
+	if cost.Threads > s.maxThreads {
+		cost.Threads = s.maxThreads
+	}
+	if cost.Memory < 0 {
+		cost.Memory = 0
+	}
+	if s.maxMemory > 0 && cost.Memory > s.maxMemory {
+		cost.Memory = s.maxMemory
+	}
+	return cost
+}
+
+func (s *Scheduler) fits(cost Cost) bool {
+	return (s.maxMemory == 0 || s.memory+cost.Memory <= s.maxMemory) && s.threads+cost.Threads <= s.maxThreads
+}
+
+func (s *Scheduler) admit(cost Cost, wait time.Duration) {
+	s.memory += cost.Memory
+	s.threads += cost.Threads
+	s.running++
+	s.admitted++
+	s.waitTime += wait
+}
+
+// dispatch admits queued jobs in order while the first one fits
+func (s *Scheduler) dispatch() {
+	for len(s.queue) > 0 && s.fits(s.queue[0].cost) {
+		w := s.queue[0]
+		s.queue = s.queue[1:]
+		s.admit(w.cost, time.Since(w.queued))
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) release(cost Cost) {
+	s.memory -= cost.Memory
+	s.threads -= cost.Threads
+	s.running--
+	s.dispatch()
+}
+
+func (s *Scheduler) releaseFunc(cost Cost) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.release(cost)
+			s.mu.Unlock()
+		})
+	}
+}
+
+// ========================================
+// Cost estimates
+// ========================================
+
+// The estimates are rough models of the peak memory for still images, in bytes per
+// pixel of the input, plus the input and output buffers. They are meant for admission
+// control, not as exact figures. When the dimensions cannot be probed (TIFF, corrupt
+// data), the input is assumed to be 8 pixels per byte.
+
+// EstimateWebPEncode estimates the cost of WebP-encoding imageFileData with opts
+func EstimateWebPEncode(imageFileData []byte, opts WebPEncodeOptions) Cost {
+	// Decoded RGBA, the ARGB or YUVA picture and the encoder's working buffers
+	perPixel := int64(12)
+	if opts.Lossless {
+		perPixel = 16
+	}
+	cost := Cost{Memory: probePixels(imageFileData)*perPixel + 2*int64(len(imageFileData)), Threads: 1}
+	if opts.ThreadLevel {
+		cost.Threads = 2
+	}
+	return cost
+}
+
+// EstimateAVIFEncode estimates the cost of AVIF-encoding imageFileData with opts.
+// Jobs of -1 or 0 count as all cores, which makes the job run alone under a thread
+// budget smaller than the CPU count; set Jobs to share the budget.
+func EstimateAVIFEncode(imageFileData []byte, opts AVIFEncodeOptions) Cost {
+	// Decoded RGBA, the YUV image and the AV1 encoder's frame buffers, doubled above 8 bits
+	perPixel := int64(24)
+	if opts.BitDepth > 8 {
+		perPixel = 40
+	}
+	cost := Cost{Memory: probePixels(imageFileData)*perPixel + 2*int64(len(imageFileData)), Threads: opts.Jobs}
+	if opts.Jobs <= 0 {
+		cost.Threads = runtime.NumCPU()
+	}
+	return cost
+}
+
+// EstimateWebPDecode estimates the cost of decoding webpData with opts
+func EstimateWebPDecode(webpData []byte, opts WebPDecodeOptions) Cost {
+	cost := Cost{Memory: probePixels(webpData)*6 + int64(len(webpData)), Threads: 1}
+	if opts.UseThreads {
+		cost.Threads = 2
+	}
+	return cost
+}
+
+// EstimateAVIFDecode estimates the cost of decoding avifData with opts
+func EstimateAVIFDecode(avifData []byte, opts AVIFDecodeOptions) Cost {
+	// The YUV image, the RGBA output and the AV1 decoder's frame buffers
+	return Cost{Memory: probePixels(avifData)*12 + int64(len(avifData)), Threads: 1}
+}
+
+// probePixels returns the pixel count of the image from its header
+func probePixels(data []byte) int64 {
+	if width, height, ok := probeDimensions(data); ok {
+		return int64(width) * int64(height)
+	}
+	return 8 * int64(len(data))
+}
+
+// ========================================
+// Pools
+// ========================================
+
+// instancePool keeps idle encoder or decoder instances for reuse.
+// An instance is used by one call at a time.
+type instancePool[T any] struct {
+	newInstance   func() (T, error)
+	closeInstance func(T)
+
+	mu     sync.Mutex
+	idle   []T
+	closed bool
+}
+
+// init creates the first instance up front, so that invalid options are reported by the constructor
+func (p *instancePool[T]) init(newInstance func() (T, error), closeInstance func(T)) error {
+	p.newInstance, p.closeInstance = newInstance, closeInstance
+	instance, err := newInstance()
+	if err != nil {
+		return err
+	}
+	p.idle = []T{instance}
+	return nil
+}
+
+// get returns an idle instance or creates one
+func (p *instancePool[T]) get() (T, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		var zero T
+		return zero, errors.New("pool is closed")
+	}
+	if n := len(p.idle); n > 0 {
+		instance := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return instance, nil
+	}
+	p.mu.Unlock()
+	return p.newInstance()
+}
+
+// put returns an instance to the pool, closing it if the pool is closed
+func (p *instancePool[T]) put(instance T) {
+	p.mu.Lock()
+	if !p.closed {
+		p.idle = append(p.idle, instance)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.closeInstance(instance)
+}
+
+// close releases the idle instances; instances in use are closed when returned
+func (p *instancePool[T]) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, instance := range p.idle {
+		p.closeInstance(instance)
+	}
+	p.idle = nil
+}
+
+// WebPEncoderPool encodes with reusable WebPEncoder instances, scheduled by a Scheduler.
+// It is safe for concurrent use.
+type WebPEncoderPool struct {
+	scheduler *Scheduler
+	opts      WebPEncodeOptions
+	pool      instancePool[*WebPEncoder]
+}
+
+// NewWebPEncoderPool creates a pool of WebP encoders with the given options.
+// Options can be customized using the provided callback function, as with NewWebPEncoder.
+func (s *Scheduler) NewWebPEncoderPool(optsFn func(*WebPEncodeOptions)) (*WebPEncoderPool, error) {
+	opts := DefaultWebPEncodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	p := &WebPEncoderPool{scheduler: s, opts: opts}
+	err := p.pool.init(func() (*WebPEncoder, error) {
+		return NewWebPEncoder(func(o *WebPEncodeOptions) { *o = opts })
+	}, (*WebPEncoder).Close)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Encode waits for the scheduler to admit the job, then encodes image file data
+// (JPEG, PNG, etc.) to WebP format with an idle encoder
+func (p *WebPEncoderPool) Encode(ctx context.Context, imageFileData []byte) ([]byte, error) {
+	release, err := p.scheduler.Acquire(ctx, EstimateWebPEncode(imageFileData, p.opts))
+	if err != nil {
+		return nil, fmt.Errorf("webp encoder pool: %w", err)
+	}
+	defer release()
+	e, err := p.pool.get()
+	if err != nil {
+		return nil, fmt.Errorf("webp encoder pool: %w", err)
+	}
+	defer p.pool.put(e)
+	return e.Encode(imageFileData)
+}
+
+// Close releases the idle encoders; encoders in use are closed when their call returns
+func (p *WebPEncoderPool) Close() {
+	p.pool.close()
+}
+
+// AVIFEncoderPool encodes with reusable AVIFEncoder instances, scheduled by a Scheduler.
+// It is safe for concurrent use.
+type AVIFEncoderPool struct {
+	scheduler *Scheduler
+	opts      AVIFEncodeOptions
+	pool      instancePool[*AVIFEncoder]
+}
+
+// NewAVIFEncoderPool creates a pool of AVIF encoders with the given options.
+// Options can be customized using the provided callback function, as with NewAVIFEncoder.
+// Set Jobs to the threads each encode may use; the default of all cores lets only one
+// encode run at a time under a thread budget smaller than the CPU count.
+func (s *Scheduler) NewAVIFEncoderPool(optsFn func(*AVIFEncodeOptions)) (*AVIFEncoderPool, error) {
+	opts := DefaultAVIFEncodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	p := &AVIFEncoderPool{scheduler: s, opts: opts}
+	err := p.pool.init(func() (*AVIFEncoder, error) {
+		return NewAVIFEncoder(func(o *AVIFEncodeOptions) { *o = opts })
+	}, (*AVIFEncoder).Close)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Encode waits for the scheduler to admit the job, then encodes image file data
+// (JPEG, PNG, etc.) to AVIF format with an idle encoder
+func (p *AVIFEncoderPool) Encode(ctx context.Context, imageFileData []byte) ([]byte, error) {
+	release, err := p.scheduler.Acquire(ctx, EstimateAVIFEncode(imageFileData, p.opts))
+	if err != nil {
+		return nil, fmt.Errorf("avif encoder pool: %w", err)
+	}
+	defer release()
+	e, err := p.pool.get()
+	if err != nil {
+		return nil, fmt.Errorf("avif encoder pool: %w", err)
+	}
+	defer p.pool.put(e)
+	return e.Encode(imageFileData)
+}
+
+// Close releases the idle encoders; encoders in use are closed when their call returns
+func (p *AVIFEncoderPool) Close() {
+	p.pool.close()
+}
+
+// WebPDecoderPool decodes with reusable WebPDecoder instances, scheduled by a Scheduler.
+// It is safe for concurrent use.
+type WebPDecoderPool struct {
+	scheduler *Scheduler
+	opts      WebPDecodeOptions
+	pool      instancePool[*WebPDecoder]
+}
+
+// NewWebPDecoderPool creates a pool of WebP decoders with the given options.
+// Options can be customized using the provided callback function, as with NewWebPDecoder.
+func (s *Scheduler) NewWebPDecoderPool(optsFn func(*WebPDecodeOptions)) (*WebPDecoderPool, error) {
+	opts := DefaultWebPDecodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	p := &WebPDecoderPool{scheduler: s, opts: opts}
+	err := p.pool.init(func() (*WebPDecoder, error) {
+		return NewWebPDecoder(func(o *WebPDecodeOptions) { *o = opts })
+	}, (*WebPDecoder).Close)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Decode waits for the scheduler to admit the job, then decodes WebP data with an idle decoder
+func (p *WebPDecoderPool) Decode(ctx context.Context, webpData []byte) (*DecodedImage, error) {
+	release, err := p.scheduler.Acquire(ctx, EstimateWebPDecode(webpData, p.opts))
+	if err != nil {
+		return nil, fmt.Errorf("webp decoder pool: %w", err)
+	}
+	defer release()
+	d, err := p.pool.get()
+	if err != nil {
+		return nil, fmt.Errorf("webp decoder pool: %w", err)
+	}
+	defer p.pool.put(d)
+	return d.Decode(webpData)
+}
+
+// Close releases the idle decoders; decoders in use are closed when their call returns
+func (p *WebPDecoderPool) Close() {
+	p.pool.close()
+}
+
+// AVIFDecoderPool decodes with reusable AVIFDecoder instances, scheduled by a Scheduler.
+// It is safe for concurrent use.
+type AVIFDecoderPool struct {
+	scheduler *Scheduler
+	opts      AVIFDecodeOptions
+	pool      instancePool[*AVIFDecoder]
+}
+
+// NewAVIFDecoderPool creates a pool of AVIF decoders with the given options.
+// Options can be customized using the provided callback function, as with NewAVIFDecoder.
+func (s *Scheduler) NewAVIFDecoderPool(optsFn func(*AVIFDecodeOptions)) (*AVIFDecoderPool, error) {
+	opts := DefaultAVIFDecodeOptions()
+	if optsFn != nil {
+		optsFn(&opts)
+	}
+	p := &AVIFDecoderPool{scheduler: s, opts: opts}
+	err := p.pool.init(func() (*AVIFDecoder, error) {
+		return NewAVIFDecoder(func(o *AVIFDecodeOptions) { *o = opts })
+	}, (*AVIFDecoder).Close)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Decode waits for the scheduler to admit the job, then decodes AVIF data with an idle decoder
+func (p *AVIFDecoderPool) Decode(ctx context.Context, avifData []byte) (*DecodedImage, error) {
+	release, err := p.scheduler.Acquire(ctx, EstimateAVIFDecode(avifData, p.opts))
+	if err != nil {
+		return nil, fmt.Errorf("avif decoder pool: %w", err)
+	}
+	defer release()
+	d, err := p.pool.get()
+	if err != nil {
+		return nil, fmt.Errorf("avif decoder pool: %w", err)
+	}
+	defer p.pool.put(d)
+	return d.Decode(avifData)
+}
+
+// Close releases the idle decoders; decoders in use are closed when their call returns
+func (p *AVIFDecoderPool) Close() {
+	p.pool.close()
+}
@@ -0,0 +1,226 @@
+package libnextimage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestScheduler tests admission against the budgets, queue order and cancellation
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(SchedulerOptions{MaxMemory: 100, MaxThreads: 4, MaxQueue: 2})
+	ctx := context.Background()
+
+	r1, err := s.Acquire(ctx, Cost{Memory: 60, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := s.Acquire(ctx, Cost{Memory: 40, Threads: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(); st.Running != 2 || st.MemoryInUse != 100 || st.ThreadsInUse != 4 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	// Both budgets are used up: a larger-than-budget job and a small one queue in order
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	queue := func(name string, cost Cost) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.Acquire(ctx, cost)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			release()
+		}()
+	}
+	queue("big", Cost{Memory: 1000, Threads: 1}) // Clamped to the budget: runs alone
+	waitQueued(t, s, 1)
+	queue("small", Cost{Memory: 1, Threads: 1})
+	waitQueued(t, s, 2)
+
+	if _, err := s.Acquire(ctx, Cost{Memory: 1, Threads: 1}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	r1()
+	r1() // No effect
+	if st := s.Stats(); st.Queued != 2 || st.Running != 1 {
+		t.Errorf("big must wait for the whole memory budget: %+v", st)
+	}
+	r2()
+	wg.Wait()
+	if len(order) != 2 || order[0] != "big" || order[1] != "small" {
+		t.Errorf("expected FIFO order, got %v", order)
+	}
+
+	st := s.Stats()
+	if st.Running != 0 || st.MemoryInUse != 0 || st.ThreadsInUse != 0 || st.Queued != 0 {
+		t.Errorf("budgets not released: %+v", st)
+	}
+	if st.Admitted != 4 || st.Rejected != 1 || st.WaitTime <= 0 {
+		t.Errorf("unexpected counters %+v", st)
+	}
+
+	// A cancelled job leaves the queue and lets the jobs behind it in
+	r3, _ := s.Acquire(ctx, Cost{Memory: 100, Threads: 1})
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(timeout, Cost{Memory: 1, Threads: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if st := s.Stats(); st.Queued != 0 || st.Canceled != 1 {
+		t.Errorf("cancelled job still counted: %+v", st)
+	}
+	r3()
+
+	s.Close()
+	if _, err := s.Acquire(ctx, Cost{}); !errors.Is(err, ErrSchedulerClosed) {
+		t.Errorf("expected ErrSchedulerClosed, got %v", err)
+	}
+}
+
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stats().Queued < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d queued jobs", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestProbeDimensions tests reading the dimensions from image headers
+func TestProbeDimensions(t *testing.T) {
+	tests := []struct {
+		path          string
+		width, height int
+	}{
+		{filepath.Join(testdataDir, "source", "sizes", "medium-512x512.png"), 512, 512},
+		{filepath.Join(testdataDir, "webp-samples", "large-2048x2048.webp"), 2048, 2048},
+		{filepath.Join(testdataDir, "webp-samples", "small-128x128.webp"), 128, 128},
+		{filepath.Join(testdataDir, "webp-samples", "alpha-gradient.webp"), 512, 512},
+		{filepath.Join(testdataDir, "avif", "red.avif"), 64, 64},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", tt.path, err)
+		}
+		if w, h, ok := probeDimensions(data); !ok || w != tt.width || h != tt.height {
+			t.Errorf("%s: got %dx%d (%v), expected %dx%d", tt.path, w, h, ok, tt.width, tt.height)
+		}
+	}
+
+	// Encoded outputs: lossy and lossless WebP, and AVIF
+	png, _ := os.ReadFile(tests[0].path)
+	lossless := DefaultWebPEncodeOptions()
+	lossless.Lossless = true
+	for name, encode := range map[string]func() ([]byte, error){
+		"webp":          func() ([]byte, error) { return WebPEncodeBytes(png, DefaultWebPEncodeOptions()) },
+		"webp-lossless": func() ([]byte, error) { return WebPEncodeBytes(png, lossless) },
+		"avif": func() ([]byte, error) {
+			opts := DefaultAVIFEncodeOptions()
+			opts.Speed = 10
+			return AVIFEncodeBytes(png, opts)
+		},
+	} {
+		data, err := encode()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if w, h, ok := probeDimensions(data); !ok || w != 512 || h != 512 {
+			t.Errorf("%s: got %dx%d (%v), expected 512x512", name, w, h, ok)
+		}
+	}
+
+	if _, _, ok := probeDimensions([]byte("not an image")); ok {
+		t.Error("expected no dimensions for unknown data")
+	}
+	if EstimateAVIFEncode(png, DefaultAVIFEncodeOptions()).Memory <= EstimateWebPEncode(png, DefaultWebPEncodeOptions()).Memory {
+		t.Error("AVIF encoding is expected to cost more memory than WebP")
+	}
+}
+
+// TestEncoderPools tests concurrent encoding and decoding through the pools
+func TestEncoderPools(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testdataDir, "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	s := NewScheduler(SchedulerOptions{MaxMemory: 64 << 20, MaxThreads: 4})
+	webpPool, err := s.NewWebPEncoderPool(func(opts *WebPEncodeOptions) { opts.Quality = 75 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webpPool.Close()
+	avifPool, err := s.NewAVIFEncoderPool(func(opts *AVIFEncodeOptions) {
+		opts.Speed = 10
+		opts.Jobs = 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer avifPool.Close()
+	webpDecoders, err := s.NewWebPDecoderPool(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webpDecoders.Close()
+	avifDecoders, err := s.NewAVIFDecoderPool(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer avifDecoders.Close()
+
+	if _, err := s.NewWebPEncoderPool(func(opts *WebPEncodeOptions) { opts.Quality = 101 }); err == nil {
+		t.Error("expected error for invalid options")
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webp, err := webpPool.Encode(ctx, data)
+			if err != nil || !bytes.HasPrefix(webp, []byte("RIFF")) {
+				t.Errorf("webp encode: %v", err)
+				return
+			}
+			avif, err := avifPool.Encode(ctx, data)
+			if err != nil {
+				t.Errorf("avif encode: %v", err)
+				return
+			}
+			if img, err := webpDecoders.Decode(ctx, webp); err != nil || img.Width == 0 {
+				t.Errorf("webp decode: %v", err)
+			}
+			if img, err := avifDecoders.Decode(ctx, avif); err != nil || img.Width == 0 {
+				t.Errorf("avif decode: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	st := s.Stats()
+	if st.Admitted != 32 || st.Running != 0 || st.MemoryInUse != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	t.Logf("✓ %d jobs, %v total queue wait", st.Admitted, st.WaitTime)
+}
@@ -45,6 +45,15 @@ typedef struct {
     int resize_width;           // resize width
     int resize_height;          // resize height
     int use_resize;             // 0 or 1, enable resizing
+
+    // HDR -> SDR tone mapping (applied only to PQ/HLG images; SDR images are unaffected)
+    int tone_mapping;             // 0=disabled (default), 1=BT.2390 EETF, 2=Reinhard, 3=Hable
+    int tone_mapping_source_peak; // source peak luminance in nits, 0=auto (CLLI maxCLL, else mdcv max luminance, else 1000)
+    int tone_mapping_target_nits; // SDR white luminance in nits that maps to 1.0 (default: 203)
+
+    // Gain map (ISO 21496-1)
+    int gain_map_output;        // 0=base image (default), 1=gain map image, 2=rendition for gain_map_headroom
+    float gain_map_headroom;    // target HDR headroom (log2, 0=SDR) for gain_map_output=2; >0 outputs 16-bit PQ (default: 0)
 } AVIFDecOptions;
 
 // デフォルトオプションの作成
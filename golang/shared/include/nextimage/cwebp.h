@@ -131,6 +131,27 @@ NextImageStatus cwebp_run_command(
     NextImageBuffer* output
 );
 
+// バイト列の変換（統計情報付き、cwebp -v 相当）
+// stats: NextImageWebPEncodeStats（webp.hで定義）
+struct NextImageWebPEncodeStats;
+NextImageStatus cwebp_run_command_with_stats(
+    CWebPCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    struct NextImageWebPEncodeStats* stats
+);
+
+// バイト列の変換（出力をライターへ逐次渡す、statsはNULL可）
+// 出力全体をメモリに保持しないため大きな画像向け
+NextImageStatus cwebp_run_command_to_writer(
+    CWebPCommand* cmd,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    struct NextImageWebPEncodeStats* stats
+);
+
 // コマンドの解放
 void cwebp_free_command(CWebPCommand* cmd);
 
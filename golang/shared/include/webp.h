@@ -168,6 +168,63 @@ NextImageStatus nextimage_webp_encode_alloc(
     NextImageBuffer* output
 );
 
+// エンコード統計情報（libwebpのWebPAuxStats相当、cwebp -v / -print_psnr の出力内容）
+typedef struct NextImageWebPEncodeStats {
+    int coded_size;             // 最終的なサイズ（バイト単位）
+    float psnr[5];              // Y, U, V, 全体, アルファ のPSNR（dB、lossyのみ）
+    int block_count[3];         // intra4 / intra16 / スキップされたマクロブロック数
+    int header_bytes;           // ヘッダーのおおよそのバイト数
+    int mode_partition_bytes;   // モードパーティション#0のおおよそのバイト数
+    int residual_bytes[3][4];   // DC / AC / UV係数のセグメント別バイト数
+    int segment_size[4];        // セグメント別のマクロブロック数
+    int segment_quant[4];       // セグメント別の量子化値
+    int segment_level[4];       // セグメント別のフィルタレベル
+    int alpha_data_size;        // 圧縮アルファデータのサイズ
+    // lossless
+    uint32_t lossless_features; // bit0: predictor, bit1: cross-color, bit2: subtract-green, bit3: palette
+    int histogram_bits;         // ヒストグラムのビット数
+    int transform_bits;         // predictor変換のビット数
+    int cache_bits;             // カラーキャッシュのビット数
+    int palette_size;           // パレットサイズ（未使用時は0）
+    int lossless_size;          // losslessの最終サイズ
+    int lossless_hdr_size;      // losslessのヘッダーサイズ
+    int lossless_data_size;     // losslessの画像データサイズ
+} NextImageWebPEncodeStats;
+
+// エンコード（統計情報付き）
+// stats: 成功時に統計情報が格納される（NULL可）
+NextImageStatus nextimage_webp_encode_alloc_with_stats(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output,
+    NextImageWebPEncodeStats* stats
+);
+
+// エンコード（ライターへ逐次出力）
+// 出力バッファを確保せず、エンコード結果をwriterへチャンクごとに渡す
+// writerが0を返した場合は中断してエラーを返す（それまでのチャンクは出力済み）
+// stats: 成功時に統計情報が格納される（NULL可）
+NextImageStatus nextimage_webp_encode_to_writer(
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWebPEncodeOptions* options,
+    const NextImageWriter* writer,
+    NextImageWebPEncodeStats* stats
+);
+
+// デコード済みRGBA 8-bitピクセルからエンコード
+// 1回のデコード結果から複数のエンコードを行う場合に使う（crop / resize / blend_alpha等のオプションも適用される）
+// rgba: RGBA画素データ、stride: 1行のバイト数（width * 4以上）
+NextImageStatus nextimage_webp_encode_rgba_alloc(
+    const uint8_t* rgba,
+    int width,
+    int height,
+    int stride,
+    const NextImageWebPEncodeOptions* options,
+    NextImageBuffer* output
+);
+
 // ========================================
 // WebP デコード
 // ========================================
@@ -205,6 +262,57 @@ NextImageStatus nextimage_webp_decode_size(
     size_t* required_size
 );
 
+// ========================================
+// 入力画像デコード
+// ========================================
+
+// PNG/JPEG/TIFF/WebP等の画像ファイルをRGBA 8-bitにデコード（ライブラリがメモリを割り当て）
+// エンコーダーと同じimageioで読み込むため、品質評価の参照画像として使用できる
+// input_data: 画像ファイルデータ
+// input_size: データサイズ
+// output: 出力バッファ（nextimage_free_decode_buffer()で解放）
+NextImageStatus nextimage_image_decode_alloc(
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageDecodeBuffer* output
+);
+
+// RGBA 8-bit画像をリサイズ（ライブラリがメモリを割り当て、cwebp -resize と同じリスケーラー）
+// new_width / new_height: 一方が0の場合はアスペクト比を維持
+// output: 出力バッファ（nextimage_free_decode_buffer()で解放）
+NextImageStatus nextimage_image_resize_alloc(
+    const uint8_t* rgba,
+    int width,
+    int height,
+    int stride,
+    int new_width,
+    int new_height,
+    NextImageDecodeBuffer* output
+);
+
+// 歪み指標（get_disto -psnr / -ssim / -lsim）
+typedef enum {
+    NEXTIMAGE_DISTORTION_PSNR = 0,
+    NEXTIMAGE_DISTORTION_SSIM = 1,
+    NEXTIMAGE_DISTORTION_LSIM = 2,
+} NextImageDistortionMetric;
+
+// 2つのRGBA 8-bit画像の歪みを計算（libwebpのget_distoと同等）
+// reference_rgba / distorted_rgba: 同じサイズのRGBA画素データ
+// keep_alpha: 0ならRGBを黒背景に合成（アルファで重み付け）して比較、1ならアルファを保持（get_disto -alpha）
+// result: B, G, R, A, 全体 の順にdB値が格納される（WebPPictureDistortionと同じ順序）
+NextImageStatus nextimage_image_distortion(
+    const uint8_t* reference_rgba,
+    int reference_stride,
+    const uint8_t* distorted_rgba,
+    int distorted_stride,
+    int width,
+    int height,
+    NextImageDistortionMetric metric,
+    int keep_alpha,
+    float result[5]
+);
+
 // ========================================
 // GIF to WebP
 // ========================================
@@ -259,6 +367,22 @@ NextImageStatus nextimage_webp_encoder_encode(
     size_t input_size,
     NextImageBuffer* output);
 
+// エンコーダーでエンコード（統計情報付き）
+NextImageStatus nextimage_webp_encoder_encode_with_stats(
+    NextImageWebPEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    NextImageBuffer* output,
+    NextImageWebPEncodeStats* stats);
+
+// エンコーダーでエンコード（ライターへ逐次出力、statsはNULL可）
+NextImageStatus nextimage_webp_encoder_encode_to_writer(
+    NextImageWebPEncoder* encoder,
+    const uint8_t* input_data,
+    size_t input_size,
+    const NextImageWriter* writer,
+    NextImageWebPEncodeStats* stats);
+
 // エンコーダーの破棄（内部メモリの解放）
 void nextimage_webp_encoder_destroy(NextImageWebPEncoder* encoder);
 
@@ -0,0 +1,75 @@
+package libnextimage
+
+/*
+#include <stdint.h>
+#include "nextimage.h"
+
+extern int nextimageWriteGo(uint8_t* data, size_t size, uintptr_t handle);
+
+static int nextimage_go_write(const uint8_t* data, size_t size, void* user_data) {
+    return nextimageWriteGo((uint8_t*)data, size, (uintptr_t)user_data);
+}
+
+// The handle travels as user_data and comes back to nextimageWriteGo
+static NextImageWriter nextimage_go_writer(uintptr_t handle) {
+    NextImageWriter writer = { nextimage_go_write, (void*)handle };
+    return writer;
+}
+*/
+import "C"
+import (
+	"bufio"
+	"io"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// streamBufferSize is the buffer between the encoder callbacks and the io.Writer,
+// which gathers the small header and partition writes of libwebp
+const streamBufferSize = 64 * 1024
+
+// streamWriter receives encoder output through nextimageWriteGo
+type streamWriter struct {
+	w   io.Writer
+	err error // First write error, which aborts the encode
+}
+
+// countingWriter counts the bytes accepted by w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamTo runs encode with a NextImageWriter that writes the output to w as it is
+// produced. It returns the status of encode, the number of bytes w accepted (not
+// counting those left in the buffer after an error) and the error of w, if any.
+func streamTo(w io.Writer, encode func(writer *C.NextImageWriter) C.NextImageStatus) (C.NextImageStatus, int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, streamBufferSize)
+	sw := &streamWriter{w: bw}
+	h := cgo.NewHandle(sw)
+	defer h.Delete()
+
+	writer := C.nextimage_go_writer(C.uintptr_t(h))
+	status := encode(&writer)
+	if status == C.NEXTIMAGE_OK && sw.err == nil {
+		sw.err = bw.Flush()
+	}
+	return status, cw.n, sw.err
+}
+
+// writeBuffer writes a C output buffer to w straight from C memory, without copying
+// it to a Go slice first
+func writeBuffer(w io.Writer, buf *C.NextImageBuffer) (int64, error) {
+	if buf.data == nil || buf.size == 0 {
+		return 0, nil
+	}
+	n, err := w.Write(unsafe.Slice((*byte)(unsafe.Pointer(buf.data)), int(buf.size)))
+	return int64(n), err
+}
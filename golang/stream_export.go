@@ -0,0 +1,29 @@
+package libnextimage
+
+// Kept apart from stream.go: a file with //export may only declare in its preamble
+
+/*
+#include <stddef.h>
+#include <stdint.h>
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// nextimageWriteGo is the NextImageWriter callback of streamTo. It hands the chunk to
+// the io.Writer without copying and returns 0 to abort the encode once a write fails.
+//
+//export nextimageWriteGo
+func nextimageWriteGo(data *C.uint8_t, size C.size_t, handle C.uintptr_t) C.int {
+	sw := cgo.Handle(handle).Value().(*streamWriter)
+	if sw.err != nil {
+		return 0
+	}
+	if _, err := sw.w.Write(unsafe.Slice((*byte)(unsafe.Pointer(data)), int(size))); err != nil {
+		sw.err = err
+		return 0
+	}
+	return 1
+}
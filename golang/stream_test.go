@@ -0,0 +1,113 @@
+package libnextimage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// failingWriter accepts n bytes and then fails
+type failingWriter struct{ n, written int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errWriteFailed
+	}
+	w.n -= len(p)
+	w.written += len(p)
+	return len(p), nil
+}
+
+// TestRunToWriter tests that streamed output matches Run and that writer errors
+// abort the encode
+func TestRunToWriter(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "jpeg", "test.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	cwebp, err := NewCWebPCommand(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cwebp.Close()
+	avifOpts := NewDefaultAVIFEncOptions()
+	avifOpts.Speed = 10
+	avifenc, err := NewAVIFEncCommand(&avifOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer avifenc.Close()
+
+	tests := []struct {
+		name        string
+		run         func([]byte) ([]byte, error)
+		runToWriter func([]byte, *bytes.Buffer) (int64, error)
+	}{
+		{"cwebp", cwebp.Run, func(d []byte, b *bytes.Buffer) (int64, error) { return cwebp.RunToWriter(d, b) }},
+		{"avifenc", avifenc.Run, func(d []byte, b *bytes.Buffer) (int64, error) { return avifenc.RunToWriter(d, b) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := tt.run(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			n, err := tt.runToWriter(data, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("streamed %d bytes, want the %d bytes of Run", n, len(want))
+			}
+		})
+	}
+
+	fw := &failingWriter{n: 16}
+	if n, err := cwebp.RunToWriter(data, fw); !errors.Is(err, errWriteFailed) {
+		t.Errorf("cwebp: expected the writer error, got %v", err)
+	} else if n != int64(fw.written) {
+		t.Errorf("cwebp: reported %d bytes written, the writer accepted %d", n, fw.written)
+	}
+	if _, err := avifenc.RunToWriter(data, &failingWriter{}); !errors.Is(err, errWriteFailed) {
+		t.Errorf("avifenc: expected the writer error, got %v", err)
+	}
+
+	// The command keeps working after an aborted encode
+	if err := cwebp.RunIO(bytes.NewReader(data), &bytes.Buffer{}); err != nil {
+		t.Errorf("cwebp after an aborted encode: %v", err)
+	}
+}
+
+// TestRunIO_Decode tests that the decode commands write the same output from the C buffer
+func TestRunIO_Decode(t *testing.T) {
+	webp, err := os.ReadFile(filepath.Join("..", "testdata", "webp-samples", "lossy-q75.webp"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	dwebp, err := NewDWebPCommand(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dwebp.Close()
+
+	want, err := dwebp.Run(webp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := dwebp.RunIO(bytes.NewReader(webp), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("RunIO wrote %d bytes, Run returned %d", buf.Len(), len(want))
+	}
+	if err := dwebp.RunIO(bytes.NewReader(webp), &failingWriter{}); !errors.Is(err, errWriteFailed) {
+		t.Errorf("expected the writer error, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package libnextimage
+
+import (
+	"fmt"
+)
+
+// QualityTarget describes a perceptual quality goal for WebPEncodeToTarget and AVIFEncodeToTarget.
+// The encoder quality is bisected to find the lowest quality whose decoded result
+// scores at least Score against the source image.
+type QualityTarget struct {
+	Metric QualityMetric // Metric used to score the decoded result
+	Score  float64       // Minimum acceptable score (e.g. 0.98 for SSIM, 80 for SSIMULACRA2)
+
+	// Quality search range (inclusive). Both 0 means the full 0-100 range.
+	MinQuality int
+	MaxQuality int
+
+	// Maximum number of encode/decode trials, 0=until the search converges.
+	// One extra trial at MaxQuality may run when the target was not reached.
+	MaxIterations int
+}
+
+// QualityTargetResult reports the outcome of a quality search
+type QualityTargetResult struct {
+	Data       []byte  // Encoded image at the chosen quality
+	Quality    int     // Chosen quality
+	Score      float64 // Score of Data against the source
+	Iterations int     // Number of encode/decode trials performed
+	Reached    bool    // False if no quality in range reached the target (Data is then encoded at MaxQuality)
+}
+
+// DefaultQualityTarget returns a target of SSIMULACRA2-style score 80 over the full quality range
+func DefaultQualityTarget() QualityTarget {
+	return QualityTarget{
+		Metric:     QualityMetricSSIMULACRA2,
+		Score:      80,
+		MinQuality: 0,
+		MaxQuality: 100,
+	}
+}
+
+// qualityTrial is the result of one encode/decode/score round
+type qualityTrial struct {
+	data    []byte
+	quality int
+	score   float64
+}
+
+// searchQuality bisects quality over the target range.
+// encode returns the encoded data and its decoded image for a given quality.
+func searchQuality(
+	reference *DecodedImage,
+	target QualityTarget,
+	encode func(quality int) ([]byte, *DecodedImage, error),
+) (*QualityTargetResult, error) {
+	minQ, maxQ := target.MinQuality, target.MaxQuality
+	if minQ == 0 && maxQ == 0 {
+		maxQ = 100
+	}
+	if minQ < 0 || maxQ > 100 || minQ > maxQ {
+		return nil, fmt.Errorf("invalid quality range: %d-%d (must be within 0-100)", minQ, maxQ)
+	}
+	if target.MaxIterations < 0 {
+		return nil, fmt.Errorf("invalid max iterations: %d", target.MaxIterations)
+	}
+	if target.Metric < QualityMetricSSIM || target.Metric > QualityMetricSSIMULACRA2 {
+		return nil, fmt.Errorf("unknown metric %d", int(target.Metric))
+	}
+
+	iterations := 0
+	tried := make(map[int]*qualityTrial)
+	evaluate := func(quality int) (*qualityTrial, error) {
+		if t, ok := tried[quality]; ok {
+			return t, nil
+		}
+		data, decoded, err := encode(quality)
+		if err != nil {
+			return nil, err
+		}
+		score, err := PerceptualScore(target.Metric, reference, decoded)
+		if err != nil {
+			return nil, err
+		}
+		iterations++
+		t := &qualityTrial{data: data, quality: quality, score: score}
+		tried[quality] = t
+		return t, nil
+	}
+
+	var best *qualityTrial
+	lo, hi := minQ, maxQ
+	for lo <= hi && (target.MaxIterations == 0 || iterations < target.MaxIterations) {
+		mid := (lo + hi) / 2
+		t, err := evaluate(mid)
+		if err != nil {
+			return nil, err
+		}
+		if t.score >= target.Score {
+			best = t
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if best != nil {
+		return &QualityTargetResult{
+			Data:       best.data,
+			Quality:    best.quality,
+			Score:      best.score,
+			Iterations: iterations,
+			Reached:    true,
+		}, nil
+	}
+
+	// Target not reached within the range: fall back to the highest quality
+	t, err := evaluate(maxQ)
+	if err != nil {
+		return nil, err
+	}
+	return &QualityTargetResult{
+		Data:       t.data,
+		Quality:    t.quality,
+		Score:      t.score,
+		Iterations: iterations,
+		Reached:    t.score >= target.Score,
+	}, nil
+}
+
+// WebPEncodeToTarget encodes an image file to lossy WebP at the lowest quality that meets the target.
+// TargetSize and TargetPSNR in opts are ignored; lossless and near-lossless modes are rejected.
+// Crop and resize options are not supported because the result must match the source dimensions.
+func WebPEncodeToTarget(imageFileData []byte, opts WebPEncodeOptions, target QualityTarget) (*QualityTargetResult, error) {
+	if len(imageFileData) == 0 {
+		return nil, fmt.Errorf("webp encode to target: empty input data")
+	}
+	if opts.Lossless || opts.NearLossless >= 0 || opts.LosslessPreset >= 0 {
+		return nil, fmt.Errorf("webp encode to target: lossless encoding has no quality to search")
+	}
+	if opts.CropWidth > 0 || opts.ResizeWidth > 0 || opts.ResizeHeight > 0 {
+		return nil, fmt.Errorf("webp encode to target: crop and resize are not supported")
+	}
+
+	reference, err := DecodeImageBytes(imageFileData)
+	if err != nil {
+		return nil, fmt.Errorf("webp encode to target: %w", err)
+	}
+
+	opts.TargetSize = 0
+	opts.TargetPSNR = 0
+	result, err := searchQuality(reference, target, func(quality int) ([]byte, *DecodedImage, error) {
+		opts.Quality = float32(quality)
+		data, err := WebPEncodeBytes(imageFileData, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoded, err := WebPDecodeBytes(data, DefaultWebPDecodeOptions())
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, decoded, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("webp encode to target: %w", err)
+	}
+	return result, nil
+}
+
+// AVIFEncodeToTarget encodes an image file to AVIF at the lowest quality that meets the target.
+// QualityAlpha is kept as given (-1 follows the searched quality). TargetSize and the deprecated
+// quantizer settings are ignored; lossless mode is rejected.
+// Crop is not supported because the result must match the source dimensions, and gain maps
+// are not supported because the base image is not a rendition of the source.
+func AVIFEncodeToTarget(imageFileData []byte, opts AVIFEncodeOptions, target QualityTarget) (*QualityTargetResult, error) {
+	if len(imageFileData) == 0 {
+		return nil, fmt.Errorf("avif encode to target: empty input data")
+	}
+	if opts.Lossless {
+		return nil, fmt.Errorf("avif encode to target: lossless encoding has no quality to search")
+	}
+	if opts.Crop[0] != -1 {
+		return nil, fmt.Errorf("avif encode to target: crop is not supported")
+	}
+	if opts.GainMapMode != GainMapNone {
+		return nil, fmt.Errorf("avif encode to target: gain maps are not supported")
+	}
+
+	reference, err := DecodeImageBytes(imageFileData)
+	if err != nil {
+		return nil, fmt.Errorf("avif encode to target: %w", err)
+	}
+
+	opts.TargetSize = 0
+	opts.MinQuantizer = -1
+	opts.MaxQuantizer = -1
+	result, err := searchQuality(reference, target, func(quality int) ([]byte, *DecodedImage, error) {
+		opts.Quality = quality
+		data, err := AVIFEncodeBytes(imageFileData, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoded, err := AVIFDecodeBytes(data, DefaultAVIFDecodeOptions())
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, decoded, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("avif encode to target: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,205 @@
+package libnextimage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readTargetTestImage reads the photo-like source used by the quality targeting tests
+func readTargetTestImage(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "source", "colors", "photo-like.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	return data
+}
+
+// TestPerceptualScore tests the metrics on identical and increasingly degraded images
+func TestPerceptualScore(t *testing.T) {
+	data := readTargetTestImage(t)
+
+	reference, err := DecodeImageBytes(data)
+	if err != nil {
+		t.Fatalf("DecodeImageBytes failed: %v", err)
+	}
+	if reference.BitDepth != 8 || reference.Format != FormatRGBA {
+		t.Fatalf("unexpected reference image: %d-bit format %d", reference.BitDepth, reference.Format)
+	}
+
+	decodeAtQuality := func(quality float32) *DecodedImage {
+		opts := DefaultWebPEncodeOptions()
+		opts.Quality = quality
+		webpData, err := WebPEncodeBytes(data, opts)
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		img, err := WebPDecodeBytes(webpData, DefaultWebPDecodeOptions())
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		return img
+	}
+	high := decodeAtQuality(90)
+	low := decodeAtQuality(5)
+
+	for _, metric := range []QualityMetric{QualityMetricSSIM, QualityMetricMSSSIM, QualityMetricSSIMULACRA2} {
+		identical, err := PerceptualScore(metric, reference, reference)
+		if err != nil {
+			t.Fatalf("%s: %v", metric, err)
+		}
+		highScore, _ := PerceptualScore(metric, reference, high)
+		lowScore, _ := PerceptualScore(metric, reference, low)
+
+		perfect := 1.0
+		if metric == QualityMetricSSIMULACRA2 {
+			perfect = 100
+		}
+		if identical < perfect-1e-9 {
+			t.Errorf("%s: identical images scored %.6f, expected %.0f", metric, identical, perfect)
+		}
+		if !(highScore > lowScore) {
+			t.Errorf("%s: expected quality 90 (%.4f) to score above quality 5 (%.4f)", metric, highScore, lowScore)
+		}
+		t.Logf("✓ %s: identical=%.4f q90=%.4f q5=%.4f", metric, identical, highScore, lowScore)
+	}
+
+	// Mismatched dimensions are rejected
+	small := &DecodedImage{Data: make([]byte, 4*4*4), Width: 4, Height: 4, BitDepth: 8, Format: FormatRGBA}
+	if _, err := PerceptualScore(QualityMetricSSIM, reference, small); err == nil {
+		t.Error("expected error for mismatched dimensions")
+	}
+}
+
+// TestWebPEncodeToTarget tests bisecting WebP quality to an SSIM target
+func TestWebPEncodeToTarget(t *testing.T) {
+	data := readTargetTestImage(t)
+
+	target := DefaultQualityTarget()
+	target.Metric = QualityMetricSSIM
+	target.Score = 0.95
+
+	result, err := WebPEncodeToTarget(data, DefaultWebPEncodeOptions(), target)
+	if err != nil {
+		t.Fatalf("WebPEncodeToTarget failed: %v", err)
+	}
+	if !result.Reached || result.Score < target.Score {
+		t.Errorf("target not reached: quality=%d score=%.4f", result.Quality, result.Score)
+	}
+	if result.Iterations < 1 || result.Iterations > 8 {
+		t.Errorf("unexpected iteration count for a 0-100 bisection: %d", result.Iterations)
+	}
+
+	// The returned data is what was scored
+	decoded, err := WebPDecodeBytes(result.Data, DefaultWebPDecodeOptions())
+	if err != nil {
+		t.Fatalf("decode of result failed: %v", err)
+	}
+	reference, _ := DecodeImageBytes(data)
+	score, _ := PerceptualScore(QualityMetricSSIM, reference, decoded)
+	if score != result.Score {
+		t.Errorf("reported score %.6f differs from rescored %.6f", result.Score, score)
+	}
+
+	t.Logf("✓ WebP quality %d: SSIM %.4f after %d iterations, %d bytes",
+		result.Quality, result.Score, result.Iterations, len(result.Data))
+}
+
+// TestAVIFEncodeToTarget tests bisecting AVIF quality to MS-SSIM and SSIMULACRA2 targets
+func TestAVIFEncodeToTarget(t *testing.T) {
+	data := readTargetTestImage(t)
+
+	opts := DefaultAVIFEncodeOptions()
+	opts.Speed = 10
+
+	var previousQuality int
+	for i, target := range []QualityTarget{
+		{Metric: QualityMetricSSIMULACRA2, Score: 60},
+		{Metric: QualityMetricSSIMULACRA2, Score: 85},
+	} {
+		result, err := AVIFEncodeToTarget(data, opts, target)
+		if err != nil {
+			t.Fatalf("AVIFEncodeToTarget failed: %v", err)
+		}
+		if !result.Reached {
+			t.Fatalf("target %.0f not reached: quality=%d score=%.2f", target.Score, result.Quality, result.Score)
+		}
+		if i > 0 && result.Quality < previousQuality {
+			t.Errorf("higher target chose lower quality: %d < %d", result.Quality, previousQuality)
+		}
+		previousQuality = result.Quality
+		t.Logf("✓ AVIF target %.0f: quality %d, score %.2f, %d iterations, %d bytes",
+			target.Score, result.Quality, result.Score, result.Iterations, len(result.Data))
+	}
+
+	target := QualityTarget{Metric: QualityMetricMSSSIM, Score: 0.99, MinQuality: 40, MaxQuality: 90, MaxIterations: 3}
+	result, err := AVIFEncodeToTarget(data, opts, target)
+	if err != nil {
+		t.Fatalf("AVIFEncodeToTarget (MS-SSIM) failed: %v", err)
+	}
+	if result.Quality < 40 || result.Quality > 90 {
+		t.Errorf("quality %d outside the requested range", result.Quality)
+	}
+	if result.Iterations > 4 {
+		t.Errorf("expected at most 4 iterations, got %d", result.Iterations)
+	}
+	t.Logf("✓ AVIF MS-SSIM: quality %d, score %.4f, %d iterations", result.Quality, result.Score, result.Iterations)
+}
+
+// TestEncodeToTarget_Unreachable tests falling back to the maximum quality
+func TestEncodeToTarget_Unreachable(t *testing.T) {
+	data := readTargetTestImage(t)
+
+	target := QualityTarget{Metric: QualityMetricSSIMULACRA2, Score: 101, MinQuality: 10, MaxQuality: 50}
+	result, err := WebPEncodeToTarget(data, DefaultWebPEncodeOptions(), target)
+	if err != nil {
+		t.Fatalf("WebPEncodeToTarget failed: %v", err)
+	}
+	if result.Reached {
+		t.Error("expected an unreachable target to be reported")
+	}
+	if result.Quality != 50 || len(result.Data) == 0 {
+		t.Errorf("expected fallback to quality 50, got %d (%d bytes)", result.Quality, len(result.Data))
+	}
+
+	t.Logf("✓ unreachable target falls back to quality %d (score %.2f)", result.Quality, result.Score)
+}
+
+// TestEncodeToTarget_Invalid tests rejection of invalid targets and options
+func TestEncodeToTarget_Invalid(t *testing.T) {
+	data := readTargetTestImage(t)
+
+	invalidTargets := []QualityTarget{
+		{Metric: QualityMetricSSIM, Score: 0.9, MinQuality: 80, MaxQuality: 20},
+		{Metric: QualityMetricSSIM, Score: 0.9, MaxQuality: 101},
+		{Metric: QualityMetricSSIM, Score: 0.9, MaxIterations: -1},
+		{Metric: QualityMetric(99), Score: 0.9},
+	}
+	for _, target := range invalidTargets {
+		if _, err := WebPEncodeToTarget(data, DefaultWebPEncodeOptions(), target); err == nil {
+			t.Errorf("expected error for target %+v", target)
+		}
+	}
+
+	lossless := DefaultWebPEncodeOptions()
+	lossless.Lossless = true
+	if _, err := WebPEncodeToTarget(data, lossless, DefaultQualityTarget()); err == nil {
+		t.Error("expected error for lossless WebP")
+	}
+
+	avifLossless := DefaultAVIFEncodeOptions()
+	avifLossless.Lossless = true
+	if _, err := AVIFEncodeToTarget(data, avifLossless, DefaultQualityTarget()); err == nil {
+		t.Error("expected error for lossless AVIF")
+	}
+
+	avifCrop := DefaultAVIFEncodeOptions()
+	avifCrop.Crop = [4]int{0, 0, 16, 16}
+	if _, err := AVIFEncodeToTarget(data, avifCrop, DefaultQualityTarget()); err == nil {
+		t.Error("expected error for cropped AVIF")
+	}
+
+	t.Logf("✓ invalid targets and options rejected")
+}
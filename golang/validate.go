@@ -0,0 +1,424 @@
+package libnextimage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes one option field whose value is out of range
+type FieldError struct {
+	Field   string // Field name, e.g. "Method" or "Crop"
+	Value   any    // Rejected value
+	Allowed string // Accepted values, e.g. "0-6" or "-1 (disabled) or 0-100"
+}
+
+func (e FieldError) String() string {
+	return fmt.Sprintf("%s=%v (allowed: %s)", e.Field, e.Value, e.Allowed)
+}
+
+// ValidationError is returned by Validate, the encoder constructors and New*Command
+// when options are out of range. It lists every invalid field, not just the first one.
+//
+//	var verr *libnextimage.ValidationError
+//	if errors.As(err, &verr) {
+//	    for _, f := range verr.Fields {
+//	        log.Printf("%s: %v not in %s", f.Field, f.Value, f.Allowed)
+//	    }
+//	}
+type ValidationError struct {
+	Options string       // Options type, e.g. "WebPEncodeOptions"
+	Fields  []FieldError // Invalid fields in declaration order
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.String()
+	}
+	return fmt.Sprintf("invalid %s: %s", e.Options, strings.Join(fields, ", "))
+}
+
+// validator collects the invalid fields of one options struct
+type validator struct {
+	options string
+	fields  []FieldError
+}
+
+func (v *validator) fail(field string, value any, allowed string) {
+	v.fields = append(v.fields, FieldError{Field: field, Value: value, Allowed: allowed})
+}
+
+// check records the field unless ok
+func (v *validator) check(ok bool, field string, value any, allowed string) {
+	if !ok {
+		v.fail(field, value, allowed)
+	}
+}
+
+// intRange checks min <= value <= max
+func (v *validator) intRange(field string, value, min, max int) {
+	v.check(value >= min && value <= max, field, value, fmt.Sprintf("%d-%d", min, max))
+}
+
+// optionalRange checks value == -1 (unset) or min <= value <= max
+func (v *validator) optionalRange(field string, value, min, max int, unset string) {
+	v.check(value == -1 || (value >= min && value <= max), field, value, fmt.Sprintf("-1 (%s) or %d-%d", unset, min, max))
+}
+
+func (v *validator) floatRange(field string, value, min, max float32) {
+	v.check(value >= min && value <= max, field, value, fmt.Sprintf("%g-%g", min, max))
+}
+
+func (v *validator) nonNegative(field string, value int) {
+	v.check(value >= 0, field, value, ">= 0")
+}
+
+func (v *validator) oneOf(field string, value int, allowed ...int) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	names := make([]string, len(allowed))
+	for i, a := range allowed {
+		names[i] = fmt.Sprint(a)
+	}
+	v.fail(field, value, strings.Join(names, ", "))
+}
+
+func (v *validator) pixelFormat(field, value string) {
+	v.check(value == "RGBA" || value == "RGB" || value == "BGRA", field, value, "RGBA, RGB, BGRA")
+}
+
+// cropRect checks an enabled crop rectangle: non-negative origin and positive size
+func (v *validator) cropRect(field string, x, y, width, height int) {
+	v.check(x >= 0 && y >= 0 && width > 0 && height > 0, field, [4]int{x, y, width, height},
+		"x, y >= 0 and width, height > 0")
+}
+
+// resizeSize checks an enabled resize: non-negative, and 0 for at most one side (keep aspect ratio)
+func (v *validator) resizeSize(field string, width, height int) {
+	v.check(width >= 0 && height >= 0 && width+height > 0, field, [2]int{width, height},
+		"width, height >= 0, not both 0")
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Options: v.options, Fields: v.fields}
+}
+
+// webpConfigFields are the libwebp WebPConfig settings shared by the WebP option structs
+type webpConfigFields struct {
+	Quality          float32
+	Method           int
+	TargetSize       int
+	TargetPSNR       float32
+	Segments         int
+	SNSStrength      int
+	FilterStrength   int
+	FilterSharpness  int
+	FilterType       int
+	AlphaCompression int
+	AlphaFiltering   int
+	AlphaQuality     int
+	Pass             int
+	Preprocessing    int
+	Partitions       int
+	PartitionLimit   int
+	NearLossless     int
+}
+
+// webpConfig checks the ranges of WebPValidateConfig.
+// alphaMethod is the name of the AlphaCompression field in the options struct.
+func (v *validator) webpConfig(c webpConfigFields, alphaMethod string) {
+	v.floatRange("Quality", c.Quality, 0, 100)
+	v.intRange("Method", c.Method, 0, 6)
+	v.nonNegative("TargetSize", c.TargetSize)
+	v.check(c.TargetPSNR >= 0, "TargetPSNR", c.TargetPSNR, ">= 0")
+	v.intRange("Segments", c.Segments, 1, 4)
+	v.intRange("SNSStrength", c.SNSStrength, 0, 100)
+	v.intRange("FilterStrength", c.FilterStrength, 0, 100)
+	v.intRange("FilterSharpness", c.FilterSharpness, 0, 7)
+	v.intRange("FilterType", c.FilterType, 0, 1)
+	v.intRange(alphaMethod, c.AlphaCompression, 0, 1)
+	v.intRange("AlphaFiltering", c.AlphaFiltering, 0, 2)
+	v.intRange("AlphaQuality", c.AlphaQuality, 0, 100)
+	v.intRange("Pass", c.Pass, 1, 10)
+	v.intRange("Preprocessing", c.Preprocessing, 0, 7)
+	v.intRange("Partitions", c.Partitions, 0, 3)
+	v.intRange("PartitionLimit", c.PartitionLimit, 0, 100)
+	v.optionalRange("NearLossless", c.NearLossless, 0, 100, "off")
+}
+
+// cropEnabled reports whether a cwebp crop rectangle is set: x -1 and an all-zero rectangle disable it
+func cropEnabled(x, y, width, height int) bool {
+	return x != -1 && [4]int{x, y, width, height} != [4]int{}
+}
+
+// resizeEnabled reports whether a cwebp resize is set: -1 -1 and 0 0 disable it
+func resizeEnabled(width, height int) bool {
+	return !(width == -1 && height == -1) && !(width == 0 && height == 0)
+}
+
+// webpTransform checks the cwebp -crop, -resize and -resize_mode settings
+func (v *validator) webpTransform(cropX, cropY, cropWidth, cropHeight, resizeWidth, resizeHeight int, resizeMode WebPResizeMode) {
+	if cropEnabled(cropX, cropY, cropWidth, cropHeight) {
+		v.cropRect("Crop", cropX, cropY, cropWidth, cropHeight)
+	}
+	if resizeEnabled(resizeWidth, resizeHeight) {
+		v.check(resizeWidth > 0 && resizeHeight > 0, "Resize", [2]int{resizeWidth, resizeHeight},
+			"width, height > 0, or both -1 or 0 (disabled)")
+	}
+	v.intRange("ResizeMode", int(resizeMode), 0, 2)
+}
+
+// webpAnimation checks the WebPAnimEncoder settings
+func (v *validator) webpAnimation(kmin, kmax, loopCount int) {
+	v.check(kmin >= -1, "Kmin", kmin, "-1 (auto) or >= 0")
+	v.check(kmax >= -1, "Kmax", kmax, "-1 (auto) or >= 0")
+	v.intRange("AnimLoopCount", loopCount, 0, 65535)
+}
+
+// Validate checks the options against the ranges libwebp accepts
+func (o WebPEncodeOptions) Validate() error {
+	v := validator{options: "WebPEncodeOptions"}
+	v.webpConfig(webpConfigFields{
+		o.Quality, o.Method, o.TargetSize, o.TargetPSNR, o.Segments, o.SNSStrength, o.FilterStrength,
+		o.FilterSharpness, int(o.FilterType), o.AlphaMethod, int(o.AlphaFiltering), o.AlphaQuality,
+		o.Pass, o.Preprocessing, o.Partitions, o.PartitionLimit, o.NearLossless,
+	}, "AlphaMethod")
+	v.optionalRange("Preset", int(o.Preset), 0, 5, "none")
+	v.intRange("ImageHint", int(o.ImageHint), 0, 3)
+	v.optionalRange("LosslessPreset", o.LosslessPreset, 0, 9, "none")
+	v.intRange("QMin", o.QMin, 0, 100)
+	v.intRange("QMax", o.QMax, 0, 100)
+	v.check(o.QMin <= o.QMax, "QMin", o.QMin, fmt.Sprintf("<= QMax (%d)", o.QMax))
+	v.optionalRange("KeepMetadata", o.KeepMetadata, 0, MetadataAll, "default")
+	v.webpTransform(o.CropX, o.CropY, o.CropWidth, o.CropHeight, o.ResizeWidth, o.ResizeHeight, o.ResizeMode)
+	v.check(o.BlendAlpha == 0xFFFFFFFF || o.BlendAlpha <= 0xFFFFFF, "BlendAlpha", fmt.Sprintf("0x%X", o.BlendAlpha),
+		"0xRRGGBB or 0xFFFFFFFF (disabled)")
+	v.webpAnimation(o.Kmin, o.Kmax, o.AnimLoopCount)
+	return v.err()
+}
+
+// Validate checks the options against the ranges cwebp accepts
+func (o CWebPOptions) Validate() error {
+	v := validator{options: "CWebPOptions"}
+	v.webpConfig(webpConfigFields{
+		o.Quality, o.Method, o.TargetSize, o.TargetPSNR, o.Segments, o.SNSStrength, o.FilterStrength,
+		o.FilterSharpness, o.FilterType, o.AlphaCompression, o.AlphaFiltering, o.AlphaQuality,
+		o.Pass, o.Preprocessing, o.Partitions, o.PartitionLimit, o.NearLossless,
+	}, "AlphaCompression")
+	v.optionalRange("Preset", o.Preset, 0, 5, "none")
+	v.intRange("ImageHint", o.ImageHint, 0, 3)
+	v.optionalRange("LosslessPreset", o.LosslessPreset, 0, 9, "none")
+	v.intRange("ThreadLevel", o.ThreadLevel, 0, 1)
+	v.intRange("QMin", o.QMin, 0, 100)
+	v.intRange("QMax", o.QMax, 0, 100)
+	v.check(o.QMin <= o.QMax, "QMin", o.QMin, fmt.Sprintf("<= QMax (%d)", o.QMax))
+	v.optionalRange("KeepMetadata", o.KeepMetadata, 0, MetadataAll, "default")
+	v.webpTransform(o.CropX, o.CropY, o.CropWidth, o.CropHeight, o.ResizeWidth, o.ResizeHeight, o.ResizeMode)
+	v.check(!o.BlendAlpha || o.BlendAlphaColor <= 0xFFFFFF, "BlendAlphaColor", fmt.Sprintf("0x%X", o.BlendAlphaColor), "0xRRGGBB")
+	return v.err()
+}
+
+// Validate checks the options against the ranges gif2webp accepts
+func (o Gif2WebPOptions) Validate() error {
+	v := validator{options: "Gif2WebPOptions"}
+	v.webpConfig(webpConfigFields{
+		o.Quality, o.Method, o.TargetSize, o.TargetPSNR, o.Segments, o.SNSStrength, o.FilterStrength,
+		o.FilterSharpness, o.FilterType, o.AlphaCompression, o.AlphaFiltering, o.AlphaQuality,
+		o.Pass, o.Preprocessing, o.Partitions, o.PartitionLimit, o.NearLossless,
+	}, "AlphaCompression")
+	v.intRange("ThreadLevel", o.ThreadLevel, 0, 1)
+	v.webpAnimation(o.Kmin, o.Kmax, o.AnimLoopCount)
+	return v.err()
+}
+
+// Validate checks the output format and the crop and resize settings
+func (o DWebPOptions) Validate() error {
+	v := validator{options: "DWebPOptions"}
+	v.intRange("OutputFormat", int(o.OutputFormat), int(OutputPNG), int(OutputJPEG))
+	v.intRange("JPEGQuality", o.JPEGQuality, 0, 100)
+	v.pixelFormat("Format", o.Format)
+	if o.UseCrop {
+		v.cropRect("Crop", o.CropX, o.CropY, o.CropWidth, o.CropHeight)
+	}
+	if o.UseResize {
+		v.resizeSize("Resize", o.ResizeWidth, o.ResizeHeight)
+	}
+	return v.err()
+}
+
+// Validate always succeeds: webp2gif has no options yet
+func (o WebP2GifOptions) Validate() error {
+	return nil
+}
+
+// Validate checks the metric
+func (o GetDistoOptions) Validate() error {
+	v := validator{options: "GetDistoOptions"}
+	v.oneOf("Metric", o.Metric, int(DistortionPSNR), int(DistortionSSIM), int(DistortionLSIM))
+	return v.err()
+}
+
+// avifEncodeFields are the libavif encoder settings shared by AVIFEncodeOptions and AVIFEncOptions
+type avifEncodeFields struct {
+	Quality, QualityAlpha, Speed                                      int
+	MinQuantizer, MaxQuantizer, MinQuantizerAlpha, MaxQuantizerAlpha  int
+	BitDepth, YUVFormat, YUVRange                                     int
+	TileRowsLog2, TileColsLog2                                        int
+	ColorPrimaries, TransferCharacteristics, MatrixCoefficients       int
+	TargetSize                                                        int
+	IrotAngle, ImirAxis                                               int
+	PASP                                                              [2]int
+	Crop                                                              [4]int
+	CLAP                                                              [8]int
+	CLLI                                                              [2]int
+	Timescale, KeyframeInterval                                       int
+	GainMapMode, GainMapQuality, GainMapDownscale, GainMapBitDepth    int
+	GainMapToneMapping, HDRColorPrimaries, HDRTransferCharacteristics int
+	HasGainMapAlternate                                               bool
+	GridCols, GridRows, ProgressiveLayers                             int
+	ProgressiveLayerQuality                                           [4]int
+}
+
+// avifEncode checks the ranges libavif and avifenc accept.
+// irot, imir and clli are the names of those fields in the options struct.
+func (v *validator) avifEncode(c avifEncodeFields, irot, imir, clli string) {
+	v.intRange("Quality", c.Quality, 0, 100)
+	v.optionalRange("QualityAlpha", c.QualityAlpha, 0, 100, "use Quality")
+	v.intRange("Speed", c.Speed, 0, 10)
+	v.optionalRange("MinQuantizer", c.MinQuantizer, 0, 63, "use Quality")
+	v.optionalRange("MaxQuantizer", c.MaxQuantizer, 0, 63, "use Quality")
+	if c.MinQuantizer >= 0 && c.MaxQuantizer >= 0 {
+		v.check(c.MinQuantizer <= c.MaxQuantizer, "MinQuantizer", c.MinQuantizer, fmt.Sprintf("<= MaxQuantizer (%d)", c.MaxQuantizer))
+	}
+	v.optionalRange("MinQuantizerAlpha", c.MinQuantizerAlpha, 0, 63, "use QualityAlpha")
+	v.optionalRange("MaxQuantizerAlpha", c.MaxQuantizerAlpha, 0, 63, "use QualityAlpha")
+	if c.MinQuantizerAlpha >= 0 && c.MaxQuantizerAlpha >= 0 {
+		v.check(c.MinQuantizerAlpha <= c.MaxQuantizerAlpha, "MinQuantizerAlpha", c.MinQuantizerAlpha,
+			fmt.Sprintf("<= MaxQuantizerAlpha (%d)", c.MaxQuantizerAlpha))
+	}
+	v.oneOf("BitDepth", c.BitDepth, AVIFBitDepthAuto, 8, 10, 12)
+	v.optionalRange("YUVFormat", c.YUVFormat, 0, 3, "auto")
+	v.intRange("YUVRange", c.YUVRange, 0, 1)
+	v.intRange("TileRowsLog2", c.TileRowsLog2, 0, 6)
+	v.intRange("TileColsLog2", c.TileColsLog2, 0, 6)
+	v.optionalRange("ColorPrimaries", c.ColorPrimaries, 0, 255, "auto")
+	v.optionalRange("TransferCharacteristics", c.TransferCharacteristics, 0, 255, "auto")
+	v.optionalRange("MatrixCoefficients", c.MatrixCoefficients, 0, 255, "auto")
+	v.nonNegative("TargetSize", c.TargetSize)
+	v.optionalRange(irot, c.IrotAngle, 0, 3, "disabled")
+	v.optionalRange(imir, c.ImirAxis, 0, 1, "disabled")
+	if c.PASP[0] != -1 {
+		v.check(c.PASP[0] > 0 && c.PASP[1] > 0, "PASP", c.PASP, "-1 (disabled) or positive spacings")
+	}
+	if c.Crop[0] != -1 {
+		v.cropRect("Crop", c.Crop[0], c.Crop[1], c.Crop[2], c.Crop[3])
+	}
+	if c.CLAP[0] != -1 {
+		v.check(c.CLAP[0] > 0 && c.CLAP[2] > 0 && c.CLAP[1] > 0 && c.CLAP[3] > 0 && c.CLAP[5] > 0 && c.CLAP[7] > 0,
+			"CLAP", c.CLAP, "-1 (disabled) or positive width, height and denominators")
+	}
+	if c.CLLI[0] != -1 || c.CLLI[1] != -1 {
+		v.check(c.CLLI[0] >= 0 && c.CLLI[0] <= 65535 && c.CLLI[1] >= 0 && c.CLLI[1] <= 65535,
+			clli, c.CLLI, "-1 (disabled) or 0-65535")
+	}
+	v.check(c.Timescale > 0, "Timescale", c.Timescale, "> 0")
+	v.nonNegative("KeyframeInterval", c.KeyframeInterval)
+
+	v.intRange("GainMapMode", c.GainMapMode, int(GainMapNone), int(GainMapFromHDR))
+	if c.GainMapMode == int(GainMapFromPair) {
+		v.check(c.HasGainMapAlternate, "GainMapAlternate", "empty", "HDR image data with GainMapFromPair")
+	}
+	v.intRange("GainMapQuality", c.GainMapQuality, 0, 100)
+	v.intRange("GainMapDownscale", c.GainMapDownscale, 0, 7)
+	v.oneOf("GainMapBitDepth", c.GainMapBitDepth, 8, 10, 12)
+	v.intRange("GainMapToneMapping", c.GainMapToneMapping, int(ToneMappingNone), int(ToneMappingHable))
+	v.optionalRange("HDRColorPrimaries", c.HDRColorPrimaries, 0, 255, "auto")
+	v.optionalRange("HDRTransferCharacteristics", c.HDRTransferCharacteristics, 0, 255, "auto")
+
+	v.check((c.GridCols == 0) == (c.GridRows == 0), "GridCols", [2]int{c.GridCols, c.GridRows}, "both 0 (disabled) or both 1-256")
+	v.intRange("GridCols", c.GridCols, 0, 256)
+	v.intRange("GridRows", c.GridRows, 0, 256)
+	v.check(c.ProgressiveLayers == 0 || (c.ProgressiveLayers >= 2 && c.ProgressiveLayers <= AVIFMaxLayers),
+		"ProgressiveLayers", c.ProgressiveLayers, fmt.Sprintf("0 (disabled) or 2-%d", AVIFMaxLayers))
+	if c.ProgressiveLayers > 0 && c.GridCols > 0 {
+		v.fail("ProgressiveLayers", c.ProgressiveLayers, "0 when GridCols/GridRows are set")
+	}
+	for i, q := range c.ProgressiveLayerQuality {
+		v.optionalRange(fmt.Sprintf("ProgressiveLayerQuality[%d]", i), q, 0, 100, "auto")
+	}
+}
+
+// Validate checks the options against the ranges libavif accepts
+func (o AVIFEncodeOptions) Validate() error {
+	v := validator{options: "AVIFEncodeOptions"}
+	v.avifEncode(avifEncodeFields{
+		Quality: o.Quality, QualityAlpha: o.QualityAlpha, Speed: o.Speed,
+		MinQuantizer: o.MinQuantizer, MaxQuantizer: o.MaxQuantizer,
+		MinQuantizerAlpha: o.MinQuantizerAlpha, MaxQuantizerAlpha: o.MaxQuantizerAlpha,
+		BitDepth: o.BitDepth, YUVFormat: int(o.YUVFormat), YUVRange: int(o.YUVRange),
+		TileRowsLog2: o.TileRowsLog2, TileColsLog2: o.TileColsLog2,
+		ColorPrimaries: o.ColorPrimaries, TransferCharacteristics: o.TransferCharacteristics, MatrixCoefficients: o.MatrixCoefficients,
+		TargetSize: o.TargetSize, IrotAngle: o.IRotAngle, ImirAxis: int(o.IMirAxis),
+		PASP: o.PASP, Crop: o.Crop, CLAP: o.CLAP, CLLI: o.CLLI,
+		Timescale: o.Timescale, KeyframeInterval: o.KeyframeInterval,
+		GainMapMode: int(o.GainMapMode), GainMapQuality: o.GainMapQuality, GainMapDownscale: o.GainMapDownscale,
+		GainMapBitDepth: o.GainMapBitDepth, GainMapToneMapping: int(o.GainMapToneMapping),
+		HDRColorPrimaries: o.HDRColorPrimaries, HDRTransferCharacteristics: o.HDRTransferCharacteristics,
+		HasGainMapAlternate: len(o.GainMapAlternate) > 0,
+		GridCols:            o.GridCols, GridRows: o.GridRows,
+		ProgressiveLayers: o.ProgressiveLayers, ProgressiveLayerQuality: o.ProgressiveLayerQuality,
+	}, "IRotAngle", "IMirAxis", "CLLI")
+	v.check(o.Jobs >= -1, "Jobs", o.Jobs, "-1 (all cores), 0 (auto) or a thread count")
+	return v.err()
+}
+
+// Validate checks the options against the ranges avifenc accepts
+func (o AVIFEncOptions) Validate() error {
+	v := validator{options: "AVIFEncOptions"}
+	v.avifEncode(avifEncodeFields{
+		Quality: o.Quality, QualityAlpha: o.QualityAlpha, Speed: o.Speed,
+		MinQuantizer: o.MinQuantizer, MaxQuantizer: o.MaxQuantizer,
+		MinQuantizerAlpha: o.MinQuantizerAlpha, MaxQuantizerAlpha: o.MaxQuantizerAlpha,
+		BitDepth: o.BitDepth, YUVFormat: o.YUVFormat, YUVRange: o.YUVRange,
+		TileRowsLog2: o.TileRowsLog2, TileColsLog2: o.TileColsLog2,
+		ColorPrimaries: o.ColorPrimaries, TransferCharacteristics: o.TransferCharacteristics, MatrixCoefficients: o.MatrixCoefficients,
+		TargetSize: o.TargetSize, IrotAngle: o.IrotAngle, ImirAxis: o.ImirAxis,
+		PASP: o.PASP, Crop: o.Crop, CLAP: o.CLAP, CLLI: [2]int{o.CLLIMaxCLL, o.CLLIMaxPALL},
+		Timescale: o.Timescale, KeyframeInterval: o.KeyframeInterval,
+		GainMapMode: o.GainMapMode, GainMapQuality: o.GainMapQuality, GainMapDownscale: o.GainMapDownscale,
+		GainMapBitDepth: o.GainMapBitDepth, GainMapToneMapping: o.GainMapToneMapping,
+		HDRColorPrimaries: o.HDRColorPrimaries, HDRTransferCharacteristics: o.HDRTransferCharacteristics,
+		HasGainMapAlternate: len(o.GainMapAlternate) > 0,
+		GridCols:            o.GridCols, GridRows: o.GridRows,
+		ProgressiveLayers: o.ProgressiveLayers, ProgressiveLayerQuality: o.ProgressiveLayerQuality,
+	}, "IrotAngle", "ImirAxis", "CLLIMaxCLL")
+	return v.err()
+}
+
+// Validate checks the output format, limits, tone mapping and gain map settings
+func (o AVIFDecOptions) Validate() error {
+	v := validator{options: "AVIFDecOptions"}
+	v.intRange("OutputFormat", int(o.OutputFormat), int(OutputPNG), int(OutputJPEG))
+	v.intRange("JPEGQuality", o.JPEGQuality, 0, 100)
+	v.pixelFormat("Format", o.Format)
+	v.intRange("StrictFlags", o.StrictFlags, 0, 1)
+	v.intRange("ChromaUpsampling", o.ChromaUpsampling, int(ChromaUpsamplingAutomatic), int(ChromaUpsamplingBilinear))
+	if o.UseCrop {
+		v.cropRect("Crop", o.CropX, o.CropY, o.CropWidth, o.CropHeight)
+	}
+	if o.UseResize {
+		v.resizeSize("Resize", o.ResizeWidth, o.ResizeHeight)
+	}
+	v.intRange("ToneMapping", o.ToneMapping, int(ToneMappingNone), int(ToneMappingHable))
+	v.nonNegative("ToneMappingSourcePeak", o.ToneMappingSourcePeak)
+	v.check(o.ToneMappingTargetNits > 0, "ToneMappingTargetNits", o.ToneMappingTargetNits, "> 0")
+	v.intRange("GainMapOutput", o.GainMapOutput, int(GainMapOutputBase), int(GainMapOutputRendition))
+	v.check(o.GainMapHeadroom >= 0, "GainMapHeadroom", o.GainMapHeadroom, ">= 0")
+	return v.err()
+}
@@ -0,0 +1,151 @@
+package libnextimage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// invalidFields returns the field names of a *ValidationError, or nil
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %T: %v", err, err)
+	}
+	fields := make([]string, len(verr.Fields))
+	for i, f := range verr.Fields {
+		fields[i] = f.Field
+	}
+	return fields
+}
+
+// TestValidate_Defaults tests that every default options struct is valid
+func TestValidate_Defaults(t *testing.T) {
+	for name, opts := range map[string]interface{ Validate() error }{
+		"WebPEncodeOptions": DefaultWebPEncodeOptions(),
+		"AVIFEncodeOptions": DefaultAVIFEncodeOptions(),
+		"CWebPOptions":      NewDefaultCWebPOptions(),
+		"DWebPOptions":      NewDefaultDWebPOptions(),
+		"Gif2WebPOptions":   NewDefaultGif2WebPOptions(),
+		"WebP2GifOptions":   NewDefaultWebP2GifOptions(),
+		"AVIFEncOptions":    NewDefaultAVIFEncOptions(),
+		"AVIFDecOptions":    NewDefaultAVIFDecOptions(),
+		"GetDistoOptions":   NewDefaultGetDistoOptions(),
+	} {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestValidate_Fields tests that every invalid field is reported with its value and range
+func TestValidate_Fields(t *testing.T) {
+	webp := DefaultWebPEncodeOptions()
+	webp.Method = 9
+	webp.Segments = 7
+	webp.QMin = 80
+	webp.QMax = 20
+	webp.CropX, webp.CropY, webp.CropWidth, webp.CropHeight = 0, 0, 0, 10
+	err := webp.Validate()
+	if got, want := invalidFields(t, err), []string{"Method", "Segments", "QMin", "Crop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WebPEncodeOptions: got %q, expected %q", got, want)
+	}
+	if want := "invalid WebPEncodeOptions: Method=9 (allowed: 0-6), Segments=7 (allowed: 1-4)"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("unexpected message %q", err)
+	}
+
+	avif := DefaultAVIFEncodeOptions()
+	avif.BitDepth = 11
+	avif.TileRowsLog2 = 8
+	avif.MinQuantizer, avif.MaxQuantizer = 40, 10
+	avif.GridCols = 2
+	if got, want := invalidFields(t, avif.Validate()), []string{"MinQuantizer", "BitDepth", "TileRowsLog2", "GridCols"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AVIFEncodeOptions: got %q, expected %q", got, want)
+	}
+
+	cwebp := NewDefaultCWebPOptions()
+	cwebp.ResizeWidth, cwebp.ResizeHeight = -2, 0
+	cwebp.BlendAlpha, cwebp.BlendAlphaColor = true, 0x1000000
+	if got, want := invalidFields(t, cwebp.Validate()), []string{"Resize", "BlendAlphaColor"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CWebPOptions: got %q, expected %q", got, want)
+	}
+
+	avifdec := NewDefaultAVIFDecOptions()
+	avifdec.ChromaUpsampling = 5
+	avifdec.UseCrop = true
+	if got, want := invalidFields(t, avifdec.Validate()), []string{"ChromaUpsampling", "Crop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AVIFDecOptions: got %q, expected %q", got, want)
+	}
+
+	t.Logf("✓ field errors")
+}
+
+// TestValidate_BeforeC tests that constructors and encode functions return the ValidationError
+func TestValidate_BeforeC(t *testing.T) {
+	if _, err := NewWebPEncoder(func(o *WebPEncodeOptions) { o.Method = 9 }); !reflect.DeepEqual(invalidFields(t, err), []string{"Method"}) {
+		t.Errorf("NewWebPEncoder: %v", err)
+	}
+	if _, err := NewAVIFEncoder(func(o *AVIFEncodeOptions) { o.Speed = 11 }); !reflect.DeepEqual(invalidFields(t, err), []string{"Speed"}) {
+		t.Errorf("NewAVIFEncoder: %v", err)
+	}
+
+	cwebp := NewDefaultCWebPOptions()
+	cwebp.Segments = 0
+	if _, err := NewCWebPCommand(&cwebp); !reflect.DeepEqual(invalidFields(t, err), []string{"Segments"}) {
+		t.Errorf("NewCWebPCommand: %v", err)
+	}
+	avifenc := NewDefaultAVIFEncOptions()
+	avifenc.YUVRange = 2
+	if _, err := NewAVIFEncCommand(&avifenc); !reflect.DeepEqual(invalidFields(t, err), []string{"YUVRange"}) {
+		t.Errorf("NewAVIFEncCommand: %v", err)
+	}
+	if _, err := NewGetDistoCommand(&GetDistoOptions{Metric: 7}); !reflect.DeepEqual(invalidFields(t, err), []string{"Metric"}) {
+		t.Errorf("NewGetDistoCommand: %v", err)
+	}
+
+	// A crop outside the image is reported as a field error once the input is decoded
+	data, err := os.ReadFile(filepath.Join("..", "testdata", "source", "sizes", "medium-512x512.png"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	webp := DefaultWebPEncodeOptions()
+	webp.CropX, webp.CropY, webp.CropWidth, webp.CropHeight = 400, 0, 200, 100
+	_, err = WebPEncodeBytes(data, webp)
+	if !reflect.DeepEqual(invalidFields(t, err), []string{"Crop"}) || !strings.Contains(err.Error(), "inside the 512x512 image") {
+		t.Errorf("WebPEncodeBytes: %v", err)
+	}
+	avif := DefaultAVIFEncodeOptions()
+	avif.Crop = [4]int{0, 500, 100, 100}
+	if _, err := AVIFEncodeBytes(data, avif); !reflect.DeepEqual(invalidFields(t, err), []string{"Crop"}) {
+		t.Errorf("AVIFEncodeBytes: %v", err)
+	}
+	webp.CropX = 100
+	if _, err := WebPEncodeBytes(data, webp); err != nil {
+		t.Errorf("crop inside the image failed: %v", err)
+	}
+
+	// Also for inputs image.DecodeConfig cannot read
+	webpData, err := os.ReadFile(filepath.Join("..", "testdata", "webp-samples", "alpha-gradient.webp"))
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+	cwebp = NewDefaultCWebPOptions()
+	cwebp.CropX, cwebp.CropY, cwebp.CropWidth, cwebp.CropHeight = 0, 0, 10000, 10
+	cmd, err := NewCWebPCommand(&cwebp)
+	if err != nil {
+		t.Fatalf("NewCWebPCommand failed: %v", err)
+	}
+	defer cmd.Close()
+	if _, err := cmd.Run(webpData); !reflect.DeepEqual(invalidFields(t, err), []string{"Crop"}) {
+		t.Errorf("CWebPCommand.Run with WebP input: %v", err)
+	}
+
+	t.Logf("✓ validated before and after decoding")
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 	"unsafe"
 )
 
@@ -490,23 +491,41 @@ func convertDecodeOptions(opts WebPDecodeOptions) C.NextImageWebPDecodeOptions {
 // The input data should be a complete image file (JPEG, PNG, GIF, TIFF, WebP, etc.)
 // not raw pixel data.
 func WebPEncodeBytes(imageFileData []byte, opts WebPEncodeOptions) ([]byte, error) {
+	return webpEncode(imageFileData, opts, nil)
+}
+
+// webpEncode encodes image file data to WebP, filling stats when it is not nil
+func webpEncode(imageFileData []byte, opts WebPEncodeOptions, stats *C.NextImageWebPEncodeStats) ([]byte, error) {
 	clearError()
 
 	if len(imageFileData) == 0 {
 		return nil, fmt.Errorf("webp encode: empty input data")
 	}
+	if err := checkLimits(imageFileData); err != nil {
+		return nil, fmt.Errorf("webp encode: %w", err)
+	}
+	if err := checkResize(opts.ResizeWidth, opts.ResizeHeight); err != nil {
+		return nil, fmt.Errorf("webp encode: %w", err)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("webp encode: %w", err)
+	}
 
 	cOpts := convertEncodeOptions(opts)
 	var encoded C.NextImageBuffer
 
-	status := C.nextimage_webp_encode_alloc(
+	status := C.nextimage_webp_encode_alloc_with_stats(
 		(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
 		C.size_t(len(imageFileData)),
 		&cOpts,
 		&encoded,
+		stats,
 	)
 
 	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "WebPEncodeOptions", [4]int{opts.CropX, opts.CropY, opts.CropWidth, opts.CropHeight}); err != nil {
+			return nil, fmt.Errorf("webp encode: %w", err)
+		}
 		return nil, makeError(status, "webp encode")
 	}
 
@@ -538,6 +557,9 @@ func WebPDecodeBytes(webpData []byte, opts WebPDecodeOptions) (*DecodedImage, er
 	if len(webpData) == 0 {
 		return nil, fmt.Errorf("webp decode: empty input data")
 	}
+	if err := checkLimits(webpData); err != nil {
+		return nil, fmt.Errorf("webp decode: %w", err)
+	}
 
 	cOpts := convertDecodeOptions(opts)
 	var decoded C.NextImageDecodeBuffer
@@ -647,6 +669,9 @@ func GIF2WebP(gifData []byte, opts WebPEncodeOptions) ([]byte, error) {
 	if len(gifData) == 0 {
 		return nil, fmt.Errorf("gif2webp: empty input data")
 	}
+	if err := checkLimits(gifData); err != nil {
+		return nil, fmt.Errorf("gif2webp: %w", err)
+	}
 
 	cOpts := convertEncodeOptions(opts)
 	var encoded C.NextImageBuffer
@@ -678,6 +703,9 @@ func WebP2GIF(webpData []byte) ([]byte, error) {
 	if len(webpData) == 0 {
 		return nil, fmt.Errorf("webp2gif: empty input data")
 	}
+	if err := checkLimits(webpData); err != nil {
+		return nil, fmt.Errorf("webp2gif: %w", err)
+	}
 
 	var encoded C.NextImageBuffer
 
@@ -704,9 +732,12 @@ func WebP2GIF(webpData []byte) ([]byte, error) {
 // WebP Encoder/Decoder (Instance-based API)
 // ========================================
 
-// WebPEncoder represents a WebP encoder instance that can be reused for multiple images
+// WebPEncoder represents a WebP encoder instance that can be reused for multiple images.
+// An encoder is not safe for concurrent use; use one per goroutine, or a WebPEncoderPool.
 type WebPEncoder struct {
 	encoderPtr *C.NextImageWebPEncoder
+	crop       [4]int // CropX, CropY, CropWidth, CropHeight, checked against each input
+	resize     [2]int // ResizeWidth, ResizeHeight, checked against the limits at each encode
 }
 
 // NewWebPEncoder creates a new WebP encoder with the given options
@@ -732,6 +763,9 @@ func NewWebPEncoder(optsFn func(*WebPEncodeOptions)) (*WebPEncoder, error) {
 	if optsFn != nil {
 		optsFn(&opts)
 	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("webp encoder: %w", err)
+	}
 
 	// Convert back to C struct
 	cOpts = convertEncodeOptions(opts)
@@ -742,7 +776,11 @@ func NewWebPEncoder(optsFn func(*WebPEncodeOptions)) (*WebPEncoder, error) {
 		return nil, fmt.Errorf("webp encoder: failed to create encoder: %s", getLastError())
 	}
 
-	encoder := &WebPEncoder{encoderPtr: encoderPtr}
+	encoder := &WebPEncoder{
+		encoderPtr: encoderPtr,
+		crop:       [4]int{opts.CropX, opts.CropY, opts.CropWidth, opts.CropHeight},
+		resize:     [2]int{opts.ResizeWidth, opts.ResizeHeight},
+	}
 
 	// Set up finalizer for automatic cleanup
 	runtime.SetFinalizer(encoder, func(e *WebPEncoder) {
@@ -757,6 +795,27 @@ func NewWebPEncoder(optsFn func(*WebPEncodeOptions)) (*WebPEncoder, error) {
 // Encode encodes image file data (JPEG, PNG, etc.) to WebP format
 // The encoder instance can be reused for multiple images, reducing initialization overhead
 func (e *WebPEncoder) Encode(imageFileData []byte) ([]byte, error) {
+	return e.encode(imageFileData, nil)
+}
+
+// EncodeWithResult encodes like Encode and also reports the encoder statistics and
+// the encode time
+func (e *WebPEncoder) EncodeWithResult(imageFileData []byte) (*WebPEncodeResult, error) {
+	var stats C.NextImageWebPEncodeStats
+	start := time.Now()
+	data, err := e.encode(imageFileData, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &WebPEncodeResult{
+		Data:     data,
+		Stats:    convertWebPEncodeStats(&stats),
+		Duration: time.Since(start),
+	}, nil
+}
+
+// encode encodes with the C encoder, filling stats when it is not nil
+func (e *WebPEncoder) encode(imageFileData []byte, stats *C.NextImageWebPEncodeStats) ([]byte, error) {
 	if e.encoderPtr == nil {
 		return nil, fmt.Errorf("webp encoder: encoder is closed")
 	}
@@ -764,16 +823,36 @@ func (e *WebPEncoder) Encode(imageFileData []byte) ([]byte, error) {
 	if len(imageFileData) == 0 {
 		return nil, fmt.Errorf("webp encoder: empty input data")
 	}
+	if err := checkLimits(imageFileData); err != nil {
+		return nil, fmt.Errorf("webp encoder: %w", err)
+	}
+	if err := checkResize(e.resize[0], e.resize[1]); err != nil {
+		return nil, fmt.Errorf("webp encoder: %w", err)
+	}
 
 	var encoded C.NextImageBuffer
-	status := C.nextimage_webp_encoder_encode(
-		e.encoderPtr,
-		(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
-		C.size_t(len(imageFileData)),
-		&encoded,
-	)
+	var status C.NextImageStatus
+	if stats != nil {
+		status = C.nextimage_webp_encoder_encode_with_stats(
+			e.encoderPtr,
+			(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
+			C.size_t(len(imageFileData)),
+			&encoded,
+			stats,
+		)
+	} else {
+		status = C.nextimage_webp_encoder_encode(
+			e.encoderPtr,
+			(*C.uint8_t)(unsafe.Pointer(&imageFileData[0])),
+			C.size_t(len(imageFileData)),
+			&encoded,
+		)
+	}
 
 	if status != C.NEXTIMAGE_OK {
+		if err := cropError(status, "WebPEncodeOptions", e.crop); err != nil {
+			return nil, fmt.Errorf("webp encoder: %w", err)
+		}
 		return nil, makeError(status, "webp encoder encode")
 	}
 
@@ -796,7 +875,8 @@ func (e *WebPEncoder) Close() {
 	}
 }
 
-// WebPDecoder represents a WebP decoder instance that can be reused for multiple images
+// WebPDecoder represents a WebP decoder instance that can be reused for multiple images.
+// A decoder is not safe for concurrent use; use one per goroutine, or a WebPDecoderPool.
 type WebPDecoder struct {
 	decoderPtr *C.NextImageWebPDecoder
 }
@@ -856,6 +936,9 @@ func (d *WebPDecoder) Decode(webpData []byte) (*DecodedImage, error) {
 	if len(webpData) == 0 {
 		return nil, fmt.Errorf("webp decoder: empty input data")
 	}
+	if err := checkLimits(webpData); err != nil {
+		return nil, fmt.Errorf("webp decoder: %w", err)
+	}
 
 	var decoded C.NextImageDecodeBuffer
 	status := C.nextimage_webp_decoder_decode(
@@ -50,6 +50,8 @@ type WebP2GifOptions struct {
 }
 
 // Command represents a webp2gif command instance that can be reused for multiple conversions.
+// The C command holds nothing but its reserved options, so Run may be called from
+// several goroutines, but not concurrently with Close.
 type WebP2GifCommand struct {
 	cmd *C.WebP2GifCommand
 }
@@ -85,6 +87,9 @@ func webp2gifOptionsToCOptions(opts WebP2GifOptions) *C.WebP2GifOptions {
 func NewWebP2GifCommand(opts *WebP2GifOptions) (*WebP2GifCommand, error) {
 	var cOpts *C.WebP2GifOptions
 	if opts != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, fmt.Errorf("failed to create webp2gif command: %w", err)
+		}
 		cOpts = webp2gifOptionsToCOptions(*opts)
 		if cOpts == nil {
 			return nil, fmt.Errorf("failed to create options")
@@ -111,14 +116,29 @@ func NewWebP2GifCommand(opts *WebP2GifOptions) (*WebP2GifCommand, error) {
 // Run converts WebP data to GIF format.
 // This is the core method that performs the conversion.
 func (c *WebP2GifCommand) Run(webpData []byte) ([]byte, error) {
+	output, err := c.run(webpData)
+	if err != nil {
+		return nil, err
+	}
+
+	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
+	C.nextimage_free_buffer(&output)
+	return result, nil
+}
+
+// run converts the input into a C buffer, which the caller frees
+func (c *WebP2GifCommand) run(webpData []byte) (C.NextImageBuffer, error) {
+	var output C.NextImageBuffer
 	if c.cmd == nil {
-		return nil, fmt.Errorf("command is closed")
+		return output, fmt.Errorf("command is closed")
 	}
 	if len(webpData) == 0 {
-		return nil, fmt.Errorf("input data is empty")
+		return output, fmt.Errorf("input data is empty")
+	}
+	if err := checkLimits(webpData); err != nil {
+		return output, err
 	}
 
-	var output C.NextImageBuffer
 	C.memset(unsafe.Pointer(&output), 0, C.sizeof_NextImageBuffer)
 
 	status := C.webp2gif_run_command(
@@ -130,16 +150,14 @@ func (c *WebP2GifCommand) Run(webpData []byte) ([]byte, error) {
 
 	if status != C.NEXTIMAGE_OK {
 		errMsg := C.nextimage_last_error_message()
-		return nil, fmt.Errorf("webp2gif conversion failed (status %d): %s", status, C.GoString(errMsg))
+		return output, fmt.Errorf("webp2gif conversion failed (status %d): %s", status, C.GoString(errMsg))
 	}
 
 	if output.data == nil || output.size == 0 {
-		return nil, fmt.Errorf("conversion produced empty output")
+		return output, fmt.Errorf("conversion produced empty output")
 	}
 
-	result := C.GoBytes(unsafe.Pointer(output.data), C.int(output.size))
-	C.nextimage_free_buffer(&output)
-	return result, nil
+	return output, nil
 }
 
 // RunFile reads a WebP file, converts it to GIF, and writes the result to outputPath.
@@ -173,17 +191,18 @@ func (c *WebP2GifCommand) RunIO(input io.Reader, output io.Writer) error {
 		return fmt.Errorf("command is closed")
 	}
 
-	inputData, err := io.ReadAll(input)
+	inputData, err := readAllLimited(input)
 	if err != nil {
 		return fmt.Errorf("failed to read input: %w", err)
 	}
 
-	gifData, err := c.Run(inputData)
+	buf, err := c.run(inputData)
 	if err != nil {
 		return err
 	}
-
-	if _, err := output.Write(gifData); err != nil {
+	_, err = writeBuffer(output, &buf)
+	C.nextimage_free_buffer(&buf)
+	if err != nil {
 		return fmt.Errorf("failed to write output: %w", err)
 	}
 
@@ -0,0 +1,63 @@
+package libnextimage
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestCWebPOptions_ZeroResize tests that a 0x0 resize is disabled while a resize with
+// only one dimension 0 is rejected
+func TestCWebPOptions_ZeroResize(t *testing.T) {
+	opts := NewDefaultCWebPOptions()
+	opts.ResizeWidth, opts.ResizeHeight = 0, 0
+	if err := opts.Validate(); err != nil {
+		t.Errorf("a resize with both dimensions 0 is disabled, got %v", err)
+	}
+	opts.ResizeWidth = 100
+	if err := opts.Validate(); err == nil {
+		t.Error("expected an error for a resize with one dimension 0")
+	}
+	opts.ResizeWidth = -2
+	if err := opts.Validate(); err == nil {
+		t.Error("expected an error for a negative resize width")
+	}
+}
+
+// TestCWebPOptions_LiteralKeepsAlpha tests that a CWebPOptions literal leaves crop, resize
+// and alpha blending disabled, so an image with alpha round-trips unchanged
+func TestCWebPOptions_LiteralKeepsAlpha(t *testing.T) {
+	inputData, err := os.ReadFile("../testdata/source/alpha/alpha-gradient.png")
+	if err != nil {
+		t.Fatalf("Failed to read input file: %v", err)
+	}
+	source, err := DecodeImageBytes(inputData)
+	if err != nil {
+		t.Fatalf("Failed to decode source: %v", err)
+	}
+
+	opts := CWebPOptions{Lossless: true, Method: 4, Exact: true, QMax: 100, Pass: 1, Segments: 4}
+	cmd, err := NewCWebPCommand(&opts)
+	if err != nil {
+		t.Fatalf("NewCWebPCommand failed: %v", err)
+	}
+	defer cmd.Close()
+	webpData, err := cmd.Run(inputData)
+	if err != nil {
+		t.Fatalf("Encoding failed: %v", err)
+	}
+	img, err := WebPDecodeBytes(webpData, DefaultWebPDecodeOptions())
+	if err != nil {
+		t.Fatalf("Failed to decode WebP: %v", err)
+	}
+	if img.Width != source.Width || img.Height != source.Height {
+		t.Fatalf("Expected %dx%d, got %dx%d", source.Width, source.Height, img.Width, img.Height)
+	}
+	for y := 0; y < img.Height; y++ {
+		got := img.Data[y*img.Stride : y*img.Stride+img.Width*4]
+		want := source.Data[y*source.Stride : y*source.Stride+source.Width*4]
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Row %d differs: alpha was blended or the image changed", y)
+		}
+	}
+}
@@ -37,7 +37,7 @@ cmake "$LIBWEBP_DIR" \
     -DWEBP_BUILD_IMG2WEBP=OFF \
     -DWEBP_BUILD_VWEBP=OFF \
     -DWEBP_BUILD_WEBPINFO=OFF \
-    -DWEBP_BUILD_EXTRAS=OFF \
+    -DWEBP_BUILD_EXTRAS=ON \
     -DWEBP_BUILD_ANIM_UTILS=ON
 
 cmake --build . --config Release -j$(sysctl -n hw.ncpu)
@@ -48,12 +48,18 @@ cp dwebp "$BIN_DIR/"
 if [ -f gif2webp ]; then
     cp gif2webp "$BIN_DIR/"
 fi
+if [ -f get_disto ]; then
+    cp get_disto "$BIN_DIR/"
+fi
 
 echo "  ✓ cwebp: $BIN_DIR/cwebp"
 echo "  ✓ dwebp: $BIN_DIR/dwebp"
 if [ -f "$BIN_DIR/gif2webp" ]; then
     echo "  ✓ gif2webp: $BIN_DIR/gif2webp"
 fi
+if [ -f "$BIN_DIR/get_disto" ]; then
+    echo "  ✓ get_disto: $BIN_DIR/get_disto"
+fi
 
 # バージョン確認
 echo ""